@@ -0,0 +1,215 @@
+package admission
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/filter"
+)
+
+// CAPSHeader is the column layout of the admission upload file the central
+// admissions processing system (CAPS) expects, in order
+var CAPSHeader = []string{
+	"RegNumber", "Surname", "FirstName", "MiddleName", "Gender",
+	"StateOfOrigin", "LGA", "Aggregate", "InstitutionCode", "CourseCode",
+	"AdmissionStatus", "ModeOfEntry", "Year",
+}
+
+// CAPS field rules
+const (
+	capsMaxNameLength = 50
+	capsMaxAggregate  = 400
+)
+
+var (
+	capsRegNumber = regexp.MustCompile(`^[0-9]{8}[A-Z]{2}$`)
+	capsName      = regexp.MustCompile(`^[A-Z][A-Z' -]*$`)
+)
+
+// CAPSRejection is an admitted candidate left out of the upload file
+// because their record breaks the CAPS field rules
+type CAPSRejection struct {
+	RegNumber string
+	Problems  []string
+}
+
+// CAPSExport is a year's admission decisions in the CAPS upload layout
+type CAPSExport struct {
+	Year        int
+	Institution string
+	// Rows are the valid records, one per admitted candidate, as text in
+	// CAPSHeader order
+	Rows     [][]string
+	Rejected []CAPSRejection
+}
+
+// capsSQL lists the admitted candidates of year $1 in source c, with the
+// names CAPS wants for their state and LGA. $2 is an institution code, or
+// blank for every institution.
+const capsSQL = `
+    SELECT c.regnumber, COALESCE(c.surname, ''), COALESCE(c.firstname, ''),
+           COALESCE(c.middlename, ''), COALESCE(c.gender, ''),
+           COALESCE(s.st_name, ''), COALESCE(l.lg_name, ''), c.aggregate,
+           COALESCE(c.inid, ''), i.inid IS NOT NULL,
+           COALESCE(c.app_course1, ''), co.course_code IS NOT NULL,
+           COALESCE(c.is_direct_entry, false)
+    FROM %s c
+    LEFT JOIN state s ON s.st_id = c.statecode
+    LEFT JOIN lga l ON l.lg_id = c.lg_id
+    LEFT JOIN institution i ON i.inid = c.inid
+    LEFT JOIN course co ON co.course_code = c.app_course1
+    WHERE c.year = $1 AND c.is_admitted
+      AND ($2 = '' OR UPPER(c.inid) = UPPER($2))
+      AND %s
+    ORDER BY c.inid, c.app_course1, c.regnumber`
+
+// CAPS produces the admission decisions recorded for year in source
+// (candidate, or a working set table) in the CAPS upload layout,
+// optionally for one institution and the candidates matching expr. Every
+// record is checked against the CAPS field rules; records that break them
+// are returned as rejections instead of rows, so the upload file is
+// accepted as a whole.
+func CAPS(ctx context.Context, db *sql.DB, year int, institution, source string, expr *filter.Filter) (*CAPSExport, error) {
+	where, args := expr.SQL("c", 2)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(capsSQL, source, where),
+		append([]interface{}{year, strings.TrimSpace(institution)}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading admission decisions: %w", err)
+	}
+	defer rows.Close()
+
+	export := &CAPSExport{Year: year, Institution: strings.ToUpper(strings.TrimSpace(institution))}
+	for rows.Next() {
+		var (
+			c                        capsCandidate
+			knownInstitution, course bool
+		)
+		if err := rows.Scan(&c.regNumber, &c.surname, &c.firstName, &c.middleName, &c.gender,
+			&c.state, &c.lga, &c.aggregate, &c.institution, &knownInstitution,
+			&c.course, &course, &c.directEntry); err != nil {
+			return nil, err
+		}
+		row, problems := c.record(year, knownInstitution, course)
+		if len(problems) > 0 {
+			export.Rejected = append(export.Rejected, CAPSRejection{RegNumber: c.regNumber, Problems: problems})
+			continue
+		}
+		export.Rows = append(export.Rows, row)
+	}
+	return export, rows.Err()
+}
+
+type capsCandidate struct {
+	regNumber, surname, firstName, middleName, gender string
+	state, lga, institution, course                   string
+	aggregate                                         sql.NullInt64
+	directEntry                                       bool
+}
+
+// record formats a candidate as a CAPS row and lists the field rules it
+// breaks
+func (c capsCandidate) record(year int, knownInstitution, knownCourse bool) ([]string, []string) {
+	var problems []string
+	reg := strings.ToUpper(strings.TrimSpace(c.regNumber))
+	if !capsRegNumber.MatchString(reg) {
+		problems = append(problems, "RegNumber must be 8 digits followed by 2 letters")
+	}
+
+	names := make([]string, 3)
+	for i, field := range []struct {
+		name     string
+		value    string
+		required bool
+	}{
+		{"Surname", c.surname, true},
+		{"FirstName", c.firstName, true},
+		{"MiddleName", c.middleName, false},
+	} {
+		value := strings.Join(strings.Fields(strings.ToUpper(field.value)), " ")
+		names[i] = value
+		switch {
+		case value == "":
+			if field.required {
+				problems = append(problems, field.name+" is required")
+			}
+		case len(value) > capsMaxNameLength:
+			problems = append(problems, fmt.Sprintf("%s is longer than %d characters", field.name, capsMaxNameLength))
+		case !capsName.MatchString(value):
+			problems = append(problems, field.name+" may only contain letters, spaces, hyphens and apostrophes")
+		}
+	}
+
+	gender := ""
+	switch strings.ToUpper(strings.TrimSpace(c.gender)) {
+	case "M", "MALE":
+		gender = "M"
+	case "F", "FEMALE":
+		gender = "F"
+	default:
+		problems = append(problems, "Gender must be M or F")
+	}
+
+	if c.state == "" {
+		problems = append(problems, "StateOfOrigin is required")
+	}
+
+	aggregate := ""
+	mode := "UTME"
+	if c.directEntry {
+		// Direct entry candidates are admitted without a UTME score
+		mode = "DE"
+	}
+	switch {
+	case c.aggregate.Valid && (c.aggregate.Int64 < 0 || c.aggregate.Int64 > capsMaxAggregate):
+		problems = append(problems, fmt.Sprintf("Aggregate must be between 0 and %d", capsMaxAggregate))
+	case c.aggregate.Valid:
+		aggregate = strconv.FormatInt(c.aggregate.Int64, 10)
+	case !c.directEntry:
+		problems = append(problems, "Aggregate is required for UTME candidates")
+	}
+
+	institution := strings.ToUpper(strings.TrimSpace(c.institution))
+	if institution == "" {
+		problems = append(problems, "InstitutionCode is required")
+	} else if !knownInstitution {
+		problems = append(problems, "InstitutionCode "+institution+" is not a known institution")
+	}
+	course := strings.ToUpper(strings.TrimSpace(c.course))
+	if course == "" {
+		problems = append(problems, "CourseCode is required")
+	} else if !knownCourse {
+		problems = append(problems, "CourseCode "+course+" is not a known course")
+	}
+
+	return []string{
+		reg, names[0], names[1], names[2], gender,
+		strings.ToUpper(c.state), strings.ToUpper(c.lga), aggregate, institution, course,
+		"ADMITTED", mode, strconv.Itoa(year),
+	}, problems
+}
+
+// WriteCSV writes the upload file: the CAPS header and the valid rows
+func (e *CAPSExport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(CAPSHeader)
+	cw.WriteAll(e.Rows)
+	return cw.Error()
+}
+
+// WriteRejections writes the rejected candidates and the rules each breaks
+// as CSV, for correcting their records before the next upload
+func (e *CAPSExport) WriteRejections(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"regnumber", "problems"})
+	for _, r := range e.Rejected {
+		cw.Write([]string{r.RegNumber, strings.Join(r.Problems, "; ")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,372 @@
+package admission
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/reports"
+)
+
+// LetterFields are the merge fields a letter template can use as
+// {{field}}
+var LetterFields = []string{
+	"regnumber", "surname", "firstname", "middlename", "fullname", "gender",
+	"state", "lga", "aggregate", "year", "session", "mode",
+	"institution_code", "institution", "institution_abbreviation",
+	"course_code", "course", "degree", "duration", "date",
+}
+
+// DefaultLetterTemplate is used when no template file is given
+const DefaultLetterTemplate = `# {{institution}}
+
+{{date}}
+
+{{fullname}}
+Registration number: {{regnumber}}
+{{lga}}, {{state}}
+
+## Offer of Provisional Admission, {{session}} Session
+
+Dear {{firstname}},
+
+We are pleased to offer you provisional admission to {{institution}} to study {{course}} ({{course_code}}), a {{duration}} year {{degree}} programme, by {{mode}}, with a UTME aggregate of {{aggregate}}.
+
+This offer is subject to the confirmation of your admission on CAPS and to the verification of your credentials on registration. It lapses if you do not accept it on CAPS within the period the institution sets.
+
+Congratulations.
+
+Admissions Officer
+{{institution}}
+`
+
+var mergeField = regexp.MustCompile(`{{\s*([a-z_]+)\s*}}`)
+
+// Letter templates are text with {{field}} merge fields. A line starting
+// "# " is the letterhead and "## " a bold heading; a blank line ends a
+// paragraph, and other lines within a paragraph are kept as separate lines.
+type LetterTemplate struct {
+	blocks []letterBlock
+}
+
+type letterBlock struct {
+	style string // "#", "##" or "" for body text
+	text  string
+}
+
+// ParseLetterTemplate reads a letter template, rejecting merge fields
+// that are not in LetterFields
+func ParseLetterTemplate(text string) (*LetterTemplate, error) {
+	known := map[string]bool{}
+	for _, f := range LetterFields {
+		known[f] = true
+	}
+	for _, m := range mergeField.FindAllStringSubmatch(text, -1) {
+		if !known[m[1]] {
+			return nil, fmt.Errorf("unknown merge field {{%s}} (fields are %s)", m[1], strings.Join(LetterFields, ", "))
+		}
+	}
+
+	t := &LetterTemplate{}
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			t.blocks = append(t.blocks, letterBlock{text: strings.Join(paragraph, "\n")})
+			paragraph = nil
+		}
+	}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case strings.HasPrefix(line, "## "):
+			flush()
+			t.blocks = append(t.blocks, letterBlock{style: "##", text: strings.TrimSpace(line[3:])})
+		case strings.HasPrefix(line, "# "):
+			flush()
+			t.blocks = append(t.blocks, letterBlock{style: "#", text: strings.TrimSpace(line[2:])})
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+	if len(t.blocks) == 0 {
+		return nil, fmt.Errorf("the letter template is empty")
+	}
+	return t, scanner.Err()
+}
+
+// LoadLetterTemplate reads a template file, or the default template when
+// path is blank
+func LoadLetterTemplate(path string) (*LetterTemplate, error) {
+	if path == "" {
+		return ParseLetterTemplate(DefaultLetterTemplate)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading letter template: %w", err)
+	}
+	t, err := ParseLetterTemplate(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// LetterBatch generates the admission letters of a year's admitted
+// candidates, one zip of PDFs per institution
+type LetterBatch struct {
+	Year int
+	// Institution limits the batch to one institution code
+	Institution string
+	Filter      *filter.Filter
+	Template    *LetterTemplate
+	Dir         string
+	// Date is printed as {{date}}; it defaults to today
+	Date time.Time
+	// OnInstitution, when set, is called after each institution's zip is
+	// written
+	OnInstitution func(LetterArchive)
+}
+
+// LetterArchive is the zip of one institution's letters
+type LetterArchive struct {
+	Institution string
+	File        string
+	Letters     int
+}
+
+// lettersSQL lists the admitted candidates in source c with the fields the
+// letters merge in, by institution
+const lettersSQL = `
+    SELECT c.regnumber, COALESCE(c.surname, ''), COALESCE(c.firstname, ''),
+           COALESCE(c.middlename, ''), COALESCE(c.gender, ''),
+           COALESCE(s.st_name, ''), COALESCE(l.lg_name, ''), c.aggregate, c.year,
+           COALESCE(c.is_direct_entry, false), UPPER(c.inid),
+           COALESCE(i.inname, c.inid), COALESCE(i.inabv, ''),
+           COALESCE(c.app_course1, ''), COALESCE(co.course_name, c.app_course1, ''),
+           COALESCE(co.degree, ''), co.duration
+    FROM %s c
+    LEFT JOIN state s ON s.st_id = c.statecode
+    LEFT JOIN lga l ON l.lg_id = c.lg_id
+    LEFT JOIN institution i ON i.inid = c.inid
+    LEFT JOIN course co ON co.course_code = c.app_course1
+    WHERE c.is_admitted AND c.inid IS NOT NULL AND TRIM(c.inid) <> ''
+      AND ($%d = '' OR UPPER(c.inid) = UPPER($%d))
+    ORDER BY UPPER(c.inid), c.app_course1, c.regnumber`
+
+// Run writes admission-letters-YEAR-INID.zip into Dir for every
+// institution with admitted candidates matching the batch, each holding a
+// REGNUMBER.pdf letter per candidate. The candidates are read from the
+// same filtered source as the reports.
+func (b *LetterBatch) Run(ctx context.Context, db *sql.DB) ([]LetterArchive, error) {
+	if b.Template == nil {
+		t, err := ParseLetterTemplate(DefaultLetterTemplate)
+		if err != nil {
+			return nil, err
+		}
+		b.Template = t
+	}
+	if b.Date.IsZero() {
+		b.Date = time.Now()
+	}
+	if err := os.MkdirAll(b.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating letter directory: %w", err)
+	}
+
+	source, args := reports.Source(b.Year, b.Filter)
+	n := len(args) + 1
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(lettersSQL, source, n, n),
+		append(args, strings.TrimSpace(b.Institution))...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading admitted candidates: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		archives []LetterArchive
+		current  *letterZip
+	)
+	closeCurrent := func() error {
+		if current == nil {
+			return nil
+		}
+		archive, err := current.close()
+		current = nil
+		if err != nil {
+			return err
+		}
+		archives = append(archives, archive)
+		if b.OnInstitution != nil {
+			b.OnInstitution(archive)
+		}
+		return nil
+	}
+	defer func() {
+		if current != nil {
+			current.abort()
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			aggregate, duration sql.NullInt64
+			year                int
+			directEntry         bool
+		)
+		var reg, surname, firstname, middlename, gender, state, lga, inid, inname, inabv, courseCode, course, degree string
+		if err := rows.Scan(&reg, &surname, &firstname, &middlename, &gender, &state, &lga, &aggregate, &year,
+			&directEntry, &inid, &inname, &inabv, &courseCode, &course, &degree, &duration); err != nil {
+			return archives, err
+		}
+		f := map[string]string{"regnumber": reg}
+		f["surname"], f["firstname"], f["middlename"] = surname, firstname, middlename
+		f["fullname"] = strings.Join(strings.Fields(surname+" "+firstname+" "+middlename), " ")
+		f["gender"], f["state"], f["lga"] = gender, state, lga
+		if aggregate.Valid {
+			f["aggregate"] = strconv.FormatInt(aggregate.Int64, 10)
+		}
+		f["year"] = strconv.Itoa(year)
+		f["session"] = fmt.Sprintf("%d/%d", year, year+1)
+		f["mode"] = "UTME"
+		if directEntry {
+			f["mode"] = "Direct Entry"
+		}
+		f["institution_code"], f["institution"], f["institution_abbreviation"] = inid, inname, inabv
+		f["course_code"], f["course"], f["degree"] = courseCode, course, degree
+		if duration.Valid {
+			f["duration"] = strconv.FormatInt(duration.Int64, 10)
+		}
+		f["date"] = b.Date.Format("2 January 2006")
+
+		if current == nil || current.institution != inid {
+			if err := closeCurrent(); err != nil {
+				return archives, err
+			}
+			name := fmt.Sprintf("admission-letters-%d-%s.zip", b.Year, safeFileName(inid))
+			if b.Year == 0 {
+				name = fmt.Sprintf("admission-letters-%s.zip", safeFileName(inid))
+			}
+			if current, err = newLetterZip(filepath.Join(b.Dir, name), inid); err != nil {
+				return archives, err
+			}
+		}
+		if err := current.add(safeFileName(reg)+".pdf", b.Template, f); err != nil {
+			return archives, fmt.Errorf("letter for %s: %w", reg, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return archives, err
+	}
+	if err := closeCurrent(); err != nil {
+		return archives, err
+	}
+	return archives, nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func safeFileName(s string) string {
+	s = unsafeFileChars.ReplaceAllString(strings.TrimSpace(s), "_")
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// letterZip is the archive of one institution's letters, written to a
+// temporary name and renamed once complete
+type letterZip struct {
+	institution string
+	path        string
+	file        *os.File
+	zw          *zip.Writer
+	letters     int
+}
+
+func newLetterZip(path, institution string) (*letterZip, error) {
+	file, err := os.Create(path + ".partial")
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", path, err)
+	}
+	return &letterZip{institution: institution, path: path, file: file, zw: zip.NewWriter(file)}, nil
+}
+
+func (z *letterZip) add(name string, t *LetterTemplate, fields map[string]string) error {
+	w, err := z.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := t.render(fields).Output(w); err != nil {
+		return err
+	}
+	z.letters++
+	return nil
+}
+
+func (z *letterZip) close() (LetterArchive, error) {
+	if err := z.zw.Close(); err != nil {
+		z.abort()
+		return LetterArchive{}, err
+	}
+	if err := z.file.Close(); err != nil {
+		os.Remove(z.file.Name())
+		return LetterArchive{}, err
+	}
+	if err := os.Rename(z.file.Name(), z.path); err != nil {
+		return LetterArchive{}, err
+	}
+	return LetterArchive{Institution: z.institution, File: z.path, Letters: z.letters}, nil
+}
+
+func (z *letterZip) abort() {
+	z.file.Close()
+	os.Remove(z.file.Name())
+}
+
+// render lays a letter out on an A4 page, continuing onto more pages if
+// the template is long. The core fonts are Latin-1, so names are
+// converted and characters outside it print as dots.
+func (t *LetterTemplate) render(fields map[string]string) *fpdf.Fpdf {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(25, 25, 25)
+	pdf.SetAutoPageBreak(true, 25)
+	pdf.SetTitle(fields["institution"]+" admission letter "+fields["regnumber"], true)
+	pdf.AddPage()
+	latin1 := pdf.UnicodeTranslatorFromDescriptor("")
+
+	for _, block := range t.blocks {
+		text := latin1(mergeField.ReplaceAllStringFunc(block.text, func(m string) string {
+			return fields[mergeField.FindStringSubmatch(m)[1]]
+		}))
+		switch block.style {
+		case "#":
+			pdf.SetFont("Helvetica", "B", 16)
+			pdf.MultiCell(0, 8, text, "", "C", false)
+			pdf.Ln(2)
+			pdf.Line(25, pdf.GetY(), 185, pdf.GetY())
+			pdf.Ln(6)
+		case "##":
+			pdf.SetFont("Helvetica", "B", 12)
+			pdf.MultiCell(0, 6, text, "", "L", false)
+			pdf.Ln(4)
+		default:
+			pdf.SetFont("Helvetica", "", 11)
+			pdf.MultiCell(0, 5.5, text, "", "J", false)
+			pdf.Ln(4)
+		}
+	}
+	return pdf
+}
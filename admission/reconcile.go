@@ -0,0 +1,148 @@
+// Package admission reconciles candidates' admission flags with the
+// admission files imported for their year.
+package admission
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Discrepancy is a candidate whose is_admitted flag disagrees with the
+// latest admission file listing them
+type Discrepancy struct {
+	RegNumber string
+	Current   sql.NullBool
+	Expected  bool
+	// SourceFile is the admission file the expected value comes from, empty
+	// when no file lists the candidate and they are expected not admitted
+	SourceFile string
+}
+
+// Reconciliation summarises a comparison of flags with admission files
+type Reconciliation struct {
+	Year          int
+	Files         []string
+	Candidates    int
+	Discrepancies []Discrepancy
+	Applied       bool
+}
+
+// Counts returns how many discrepancies would admit and un-admit candidates
+func (r *Reconciliation) Counts() (admit, unadmit int) {
+	for _, d := range r.Discrepancies {
+		if d.Expected {
+			admit++
+		} else {
+			unadmit++
+		}
+	}
+	return admit, unadmit
+}
+
+// expectedSQL gives every candidate of year $1 the admission status from
+// the most recently imported admission row for them; candidates in no
+// admission file are expected not admitted
+const expectedSQL = `
+    WITH latest AS (
+        SELECT DISTINCT ON (regnumber) regnumber, is_admitted, source_file
+        FROM admission_records
+        WHERE year = $1
+        ORDER BY regnumber, imported_at DESC, id DESC
+    )
+    SELECT c.regnumber, c.is_admitted AS current,
+           COALESCE(l.is_admitted, false) AS expected,
+           COALESCE(l.source_file, '') AS source_file
+    FROM candidate c
+    LEFT JOIN latest l ON l.regnumber = c.regnumber
+    WHERE c.year = $1`
+
+// Reconcile recomputes is_admitted for year's candidates from all admission
+// files imported for that year and reports where the stored flag differs.
+// With apply set the flags are corrected and each change is recorded in
+// candidate_changes.
+func Reconcile(ctx context.Context, db *sql.DB, year int, apply bool) (*Reconciliation, error) {
+	rec := &Reconciliation{Year: year}
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT source_file FROM admission_records
+        WHERE year = $1
+        GROUP BY source_file
+        ORDER BY MAX(imported_at)`, year)
+	if err != nil {
+		return nil, fmt.Errorf("error listing admission files: %w", err)
+	}
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rec.Files = append(rec.Files, file)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(rec.Files) == 0 {
+		return nil, fmt.Errorf("no admission files have been imported for %d", year)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM candidate WHERE year = $1", year).Scan(&rec.Candidates); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+        SELECT regnumber, current, expected, source_file
+        FROM (`+expectedSQL+`) e
+        WHERE current IS DISTINCT FROM expected
+        ORDER BY regnumber`, year)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing admission flags: %w", err)
+	}
+	for rows.Next() {
+		var d Discrepancy
+		if err := rows.Scan(&d.RegNumber, &d.Current, &d.Expected, &d.SourceFile); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rec.Discrepancies = append(rec.Discrepancies, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !apply || len(rec.Discrepancies) == 0 {
+		return rec, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO candidate_changes (regnumber, column_name, old_value, new_value, source_file)
+        SELECT regnumber, 'is_admitted', current::text, expected::text,
+               'admission reconciliation: ' || COALESCE(NULLIF(source_file, ''), 'not in any admission file')
+        FROM (`+expectedSQL+`) e
+        WHERE current IS DISTINCT FROM expected`, year)
+	if err != nil {
+		return nil, fmt.Errorf("error recording admission changes: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+        UPDATE candidate c
+        SET is_admitted = e.expected, updated_at = NOW()
+        FROM (`+expectedSQL+`) e
+        WHERE e.regnumber = c.regnumber AND e.current IS DISTINCT FROM e.expected`, year)
+	if err != nil {
+		return nil, fmt.Errorf("error updating admission flags: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	rec.Applied = true
+	return rec, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/admission"
+	"github.com/nonsonwune/spk2_db/filter"
+)
+
+// handleAdmissionLetters generates personalised admission letters for the
+// admitted candidates, zipped per institution
+func handleAdmissionLetters(ctx context.Context, db *sql.DB) error {
+	if publicOutput != nil {
+		return fmt.Errorf("candidate-level exports are disabled while public output mode is on")
+	}
+	color.Cyan("\nAdmission Letters")
+	fmt.Print("Year: ")
+	year := readInt()
+	if year == 0 {
+		return fmt.Errorf("a year is required")
+	}
+	fmt.Print("Institution code (blank for every institution): ")
+	batch := &admission.LetterBatch{Year: year, Institution: readString()}
+	if input := readFilter(ctx, db, "Filter on the admitted candidates (optional): "); input != "" {
+		var err error
+		if batch.Filter, err = filter.Parse(input); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+	fmt.Printf("Merge fields: %s\n", strings.Join(admission.LetterFields, ", "))
+	fmt.Print("Letter template file (blank for the default letter): ")
+	var err error
+	if batch.Template, err = admission.LoadLetterTemplate(readString()); err != nil {
+		return err
+	}
+	fmt.Print("Output directory [letters]: ")
+	if batch.Dir = readString(); batch.Dir == "" {
+		batch.Dir = "letters"
+	}
+
+	batch.OnInstitution = func(a admission.LetterArchive) {
+		fmt.Printf("Wrote %s (%d letters)\n", a.File, a.Letters)
+	}
+	archives, err := batch.Run(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		color.Yellow("No admitted candidates match")
+		return nil
+	}
+	total := 0
+	files := make([]string, len(archives))
+	for i, a := range archives {
+		total += a.Letters
+		files[i] = a.File
+	}
+	color.Green("Generated %d letters for %d institutions in %s", total, len(archives), batch.Dir)
+	return deliverFiles(ctx, db, fmt.Sprintf("letters:%d", year), files)
+}
+
+// runLetters is the scriptable form of the admission letter batch
+func runLetters(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("letters")
+	year := fs.Int("year", 0, "year of the admissions (required)")
+	institution := fs.String("institution", "", "only this institution's candidates")
+	filterText := fs.String("filter", "", "only admitted candidates matching this filter")
+	template := fs.String("template", "", "letter template with {{field}} merge fields (default the built-in letter)")
+	dir := fs.String("dir", "letters", "directory the per-institution zips are written to")
+	date := fs.String("date", "", "date printed on the letters, YYYY-MM-DD (default today)")
+	printTemplate := fs.Bool("print-template", false, "print the built-in template, to start your own from, and exit")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *printTemplate {
+		fmt.Print(admission.DefaultLetterTemplate)
+		return nil
+	}
+	if *year == 0 || fs.NArg() > 0 {
+		return usageError{errors.New("letters needs -year and no other arguments")}
+	}
+	if publicOutput != nil {
+		return fmt.Errorf("candidate-level exports are disabled while public output mode is on")
+	}
+
+	batch := &admission.LetterBatch{Year: *year, Institution: *institution, Dir: *dir}
+	var err error
+	if batch.Filter, err = parseFilter(*filterText); err != nil {
+		return err
+	}
+	if batch.Template, err = admission.LoadLetterTemplate(*template); err != nil {
+		return err
+	}
+	if *date != "" {
+		if batch.Date, err = time.Parse("2006-01-02", *date); err != nil {
+			return usageError{fmt.Errorf("invalid -date %q, use YYYY-MM-DD", *date)}
+		}
+	}
+
+	batch.OnInstitution = func(a admission.LetterArchive) {
+		fmt.Fprintf(os.Stderr, "Wrote %d letters for %s\n", a.Letters, a.Institution)
+		fmt.Println(a.File)
+	}
+	archives, err := batch.Run(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		fmt.Fprintln(os.Stderr, "No admitted candidates match")
+	}
+	return nil
+}
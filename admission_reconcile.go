@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/admission"
+)
+
+// discrepancyPreview is how many discrepancies are listed on screen
+const discrepancyPreview = 20
+
+// handleAdmissionReconciliation recomputes is_admitted for a year from the
+// admission files imported for it and optionally applies the corrections
+func handleAdmissionReconciliation(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nAdmission Reconciliation")
+	fmt.Print("Year: ")
+	year := readInt()
+	if year == 0 {
+		return fmt.Errorf("a year is required")
+	}
+
+	rec, err := admission.Reconcile(ctx, db, year, false)
+	if err != nil {
+		return err
+	}
+
+	admit, unadmit := rec.Counts()
+	fmt.Printf("\nAdmission files for %d (oldest first):\n", year)
+	for _, file := range rec.Files {
+		fmt.Printf("  %s\n", file)
+	}
+	fmt.Printf("Candidates: %d\n", rec.Candidates)
+	fmt.Printf("Flags matching the latest admission file: %d\n", rec.Candidates-len(rec.Discrepancies))
+	fmt.Printf("Would be admitted: %d\n", admit)
+	fmt.Printf("Would be un-admitted: %d\n", unadmit)
+	if len(rec.Discrepancies) == 0 {
+		color.Green("All admission flags agree with the admission files")
+		return nil
+	}
+
+	table := newResultTable("admission-mismatches")
+	table.SetHeader([]string{"Reg Number", "Current", "Expected", "Source File"})
+	for i, d := range rec.Discrepancies {
+		if i == discrepancyPreview {
+			break
+		}
+		table.Append(discrepancyRow(d))
+	}
+	table.Render()
+	if len(rec.Discrepancies) > discrepancyPreview {
+		fmt.Printf("... and %d more\n", len(rec.Discrepancies)-discrepancyPreview)
+	}
+
+	fmt.Print("\nSave all discrepancies to CSV (blank to skip): ")
+	if path := readString(); path != "" {
+		if err := writeDiscrepancies(path, rec.Discrepancies); err != nil {
+			return err
+		}
+		color.Green("Wrote %d discrepancies to %s", len(rec.Discrepancies), path)
+	}
+
+	fmt.Printf("Update is_admitted for %d candidates? (y/n): ", len(rec.Discrepancies))
+	if strings.ToLower(readString()) != "y" {
+		return nil
+	}
+	rec, err = admission.Reconcile(ctx, db, year, true)
+	if err != nil {
+		return err
+	}
+	color.Green("Updated %d admission flags; changes are recorded in candidate_changes", len(rec.Discrepancies))
+	return nil
+}
+
+func discrepancyRow(d admission.Discrepancy) []string {
+	current := "NULL"
+	if d.Current.Valid {
+		current = strconv.FormatBool(d.Current.Bool)
+	}
+	source := d.SourceFile
+	if source == "" {
+		source = "(not in any admission file)"
+	}
+	return []string{d.RegNumber, current, strconv.FormatBool(d.Expected), source}
+}
+
+func writeDiscrepancies(path string, discrepancies []admission.Discrepancy) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"regnumber", "current", "expected", "source_file"})
+	for _, d := range discrepancies {
+		w.Write(discrepancyRow(d))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/formula"
+	"github.com/olekukonko/tablewriter"
+)
+
+func handleAggregateFormulas(ctx context.Context, db *sql.DB) error {
+	store := formula.NewStore(db)
+
+	color.Cyan("\nAggregate Formulas")
+	fmt.Println("1. List formulas")
+	fmt.Println("2. Set formula for a year")
+	fmt.Println("3. Recompute normalized aggregates")
+	fmt.Println("4. Compare raw and normalized aggregates")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		return displayAggregateFormulas(ctx, store)
+	case "2":
+		fmt.Print("Year: ")
+		year := readInt()
+		fmt.Println("Expression over subject abbreviations or names, e.g. round((ENG + MTH + PHY + CHM) / 4, 2)")
+		fmt.Printf("Functions: %s; total is the sum of all subjects sat\n", strings.Join(formulaFunctionNames(), ", "))
+		fmt.Print("Formula: ")
+		expr := readString()
+		fmt.Print("Description (optional): ")
+		description := readString()
+		if err := store.Set(ctx, year, expr, description); err != nil {
+			return err
+		}
+		color.Green("Saved aggregate formula for %d", year)
+	case "3":
+		fmt.Print("Year to recompute: ")
+		year := readInt()
+		n, err := store.Recompute(ctx, year)
+		if err != nil {
+			return err
+		}
+		color.Green("Recomputed normalized aggregates for %d candidates", n)
+	case "4":
+		return displayNormalizedComparison(ctx, db)
+	}
+	return nil
+}
+
+func displayAggregateFormulas(ctx context.Context, store *formula.Store) error {
+	defs, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(defs) == 0 {
+		color.Yellow("No aggregate formulas configured")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Year", "Formula", "Description", "Updated"})
+	table.SetAutoWrapText(false)
+	for _, d := range defs {
+		table.Append([]string{
+			fmt.Sprintf("%d", d.Year),
+			d.Expression,
+			d.Description,
+			d.UpdatedAt.Format("2006-01-02 15:04"),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// displayNormalizedComparison shows raw and normalized aggregates side by
+// side for each year that has been recomputed
+func displayNormalizedComparison(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+        SELECT n.year, COUNT(*),
+               ROUND(AVG(c.aggregate), 2), ROUND(STDDEV(c.aggregate), 2),
+               ROUND(AVG(n.aggregate), 2), ROUND(STDDEV(n.aggregate), 2)
+        FROM normalized_aggregates n
+        JOIN candidate c ON c.regnumber = n.cand_reg_number AND c.year = n.year
+        GROUP BY n.year
+        ORDER BY n.year`)
+	if err != nil {
+		return fmt.Errorf("error comparing aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	color.Yellow("\nRaw vs Normalized Aggregates")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Year", "Candidates", "Raw Avg", "Raw StdDev", "Normalized Avg", "Normalized StdDev"})
+	for rows.Next() {
+		var year, count int
+		var rawAvg, rawStd, normAvg, normStd sql.NullFloat64
+		if err := rows.Scan(&year, &count, &rawAvg, &rawStd, &normAvg, &normStd); err != nil {
+			return err
+		}
+		table.Append([]string{
+			fmt.Sprintf("%d", year),
+			fmt.Sprintf("%d", count),
+			fmt.Sprintf("%.2f", rawAvg.Float64),
+			fmt.Sprintf("%.2f", rawStd.Float64),
+			fmt.Sprintf("%.2f", normAvg.Float64),
+			fmt.Sprintf("%.2f", normStd.Float64),
+		})
+	}
+	table.Render()
+	return rows.Err()
+}
+
+func formulaFunctionNames() []string {
+	names := make([]string, 0, len(formula.Functions))
+	for name := range formula.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
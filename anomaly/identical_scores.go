@@ -0,0 +1,181 @@
+// Package anomaly detects score patterns that suggest examination malpractice.
+package anomaly
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Sensitivity levels for the identical-scores detector
+const (
+	SensitivityLow    = "low"
+	SensitivityMedium = "medium"
+	SensitivityHigh   = "high"
+)
+
+// Thresholds control when identical score sets at a centre are flagged
+type Thresholds struct {
+	// MinClusterSize is how many candidates must share a score set
+	MinClusterSize int `json:"min_cluster_size"`
+	// MinCentreSize ignores centres too small for ratios to mean anything
+	MinCentreSize int `json:"min_centre_size"`
+	// MinClusteredRatio is the share of a centre's candidates in clusters
+	// above which the centre is flagged
+	MinClusteredRatio float64 `json:"min_clustered_ratio"`
+}
+
+var sensitivityThresholds = map[string]Thresholds{
+	SensitivityLow:    {MinClusterSize: 4, MinCentreSize: 20, MinClusteredRatio: 0.10},
+	SensitivityMedium: {MinClusterSize: 3, MinCentreSize: 10, MinClusteredRatio: 0.05},
+	SensitivityHigh:   {MinClusterSize: 2, MinCentreSize: 10, MinClusteredRatio: 0.02},
+}
+
+// ThresholdsFor returns the thresholds for a sensitivity level
+func ThresholdsFor(sensitivity string) (Thresholds, error) {
+	t, ok := sensitivityThresholds[strings.ToLower(sensitivity)]
+	if !ok {
+		return Thresholds{}, fmt.Errorf("unknown sensitivity %q (use low, medium or high)", sensitivity)
+	}
+	return t, nil
+}
+
+// SubjectScore is one subject in a cluster's shared score set
+type SubjectScore struct {
+	Subject string `json:"subject"`
+	Score   int    `json:"score"`
+}
+
+// Cluster is a group of candidates at one centre with identical scores in
+// every subject
+type Cluster struct {
+	Centre     string         `json:"centre"`
+	Scores     []SubjectScore `json:"scores"`
+	Total      int            `json:"total"`
+	RegNumbers []string       `json:"regnumbers"`
+	signature  string
+}
+
+// Centre summarises a flagged examination centre
+type Centre struct {
+	Centre              string  `json:"centre"`
+	Candidates          int     `json:"candidates"`
+	ClusteredCandidates int     `json:"clustered_candidates"`
+	Clusters            int     `json:"clusters"`
+	ClusteredRatio      float64 `json:"clustered_ratio"`
+	LargestCluster      int     `json:"largest_cluster"`
+}
+
+// Report is the detector's result for a year
+type Report struct {
+	Year       int        `json:"year"`
+	Thresholds Thresholds `json:"thresholds"`
+	Centres    []Centre   `json:"centres"`
+	Clusters   []Cluster  `json:"clusters"`
+}
+
+// DetectIdenticalScores finds examination centres where unusually many
+// candidates share exactly the same score in every subject they sat. Score
+// sets totalling zero (absent candidates) are ignored.
+func DetectIdenticalScores(ctx context.Context, db *sql.DB, year int, t Thresholds) (*Report, error) {
+	rows, err := db.QueryContext(ctx, `
+        WITH signatures AS (
+            SELECT cs.cand_reg_number,
+                   string_agg(s.su_name || ':' || cs.score, ',' ORDER BY s.su_name) AS signature,
+                   SUM(cs.score) AS total
+            FROM candidate_scores cs
+            JOIN subject s ON s.su_id = cs.subject_id
+            WHERE cs.year = $1 AND cs.score IS NOT NULL
+            GROUP BY cs.cand_reg_number
+        ),
+        centred AS (
+            SELECT e.exam_centre, sg.cand_reg_number, sg.signature, sg.total
+            FROM signatures sg
+            JOIN candidate_exam_info e ON e.cand_reg_number = sg.cand_reg_number
+            WHERE e.exam_centre IS NOT NULL AND e.exam_centre <> '' AND sg.total > 0
+        ),
+        totals AS (
+            SELECT exam_centre, COUNT(*) AS candidates
+            FROM centred
+            GROUP BY exam_centre
+            HAVING COUNT(*) >= $3
+        )
+        SELECT c.exam_centre, t.candidates, c.signature, MAX(c.total),
+               array_agg(c.cand_reg_number ORDER BY c.cand_reg_number)
+        FROM centred c
+        JOIN totals t ON t.exam_centre = c.exam_centre
+        GROUP BY c.exam_centre, t.candidates, c.signature
+        HAVING COUNT(*) >= $2`, year, t.MinClusterSize, t.MinCentreSize)
+	if err != nil {
+		return nil, fmt.Errorf("error detecting identical scores: %w", err)
+	}
+	defer rows.Close()
+
+	centres := make(map[string]*Centre)
+	clustersByCentre := make(map[string][]Cluster)
+	for rows.Next() {
+		var cl Cluster
+		var candidates int
+		if err := rows.Scan(&cl.Centre, &candidates, &cl.signature, &cl.Total, pq.Array(&cl.RegNumbers)); err != nil {
+			return nil, fmt.Errorf("error scanning cluster: %w", err)
+		}
+		cl.Scores = parseSignature(cl.signature)
+
+		c := centres[cl.Centre]
+		if c == nil {
+			c = &Centre{Centre: cl.Centre, Candidates: candidates}
+			centres[cl.Centre] = c
+		}
+		c.Clusters++
+		c.ClusteredCandidates += len(cl.RegNumbers)
+		if len(cl.RegNumbers) > c.LargestCluster {
+			c.LargestCluster = len(cl.RegNumbers)
+		}
+		clustersByCentre[cl.Centre] = append(clustersByCentre[cl.Centre], cl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Year: year, Thresholds: t, Centres: []Centre{}, Clusters: []Cluster{}}
+	for name, c := range centres {
+		c.ClusteredRatio = float64(c.ClusteredCandidates) / float64(c.Candidates)
+		if c.ClusteredRatio < t.MinClusteredRatio {
+			continue
+		}
+		report.Centres = append(report.Centres, *c)
+		report.Clusters = append(report.Clusters, clustersByCentre[name]...)
+	}
+
+	sort.Slice(report.Centres, func(i, j int) bool {
+		return report.Centres[i].ClusteredRatio > report.Centres[j].ClusteredRatio
+	})
+	sort.Slice(report.Clusters, func(i, j int) bool {
+		a, b := report.Clusters[i], report.Clusters[j]
+		if len(a.RegNumbers) != len(b.RegNumbers) {
+			return len(a.RegNumbers) > len(b.RegNumbers)
+		}
+		return a.Centre < b.Centre
+	})
+	return report, nil
+}
+
+// parseSignature splits "English:60,Physics:70" into subject scores
+func parseSignature(signature string) []SubjectScore {
+	parts := strings.Split(signature, ",")
+	scores := make([]SubjectScore, 0, len(parts))
+	for _, part := range parts {
+		i := strings.LastIndex(part, ":")
+		if i < 0 {
+			continue
+		}
+		var score int
+		fmt.Sscanf(part[i+1:], "%d", &score)
+		scores = append(scores, SubjectScore{Subject: part[:i], Score: score})
+	}
+	return scores
+}
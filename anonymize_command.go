@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+// newPseudonymizer masks personal data with PSEUDONYM_KEY, as the default
+// masking changed by spec
+func newPseudonymizer(spec string) (*privacy.Pseudonymizer, error) {
+	return privacy.NewPseudonymizer(privacy.PseudonymKeyFromEnv(), spec)
+}
+
+// describeMasking lists how each masked column is treated, e.g. for a
+// confirmation prompt
+func describeMasking(p *privacy.Pseudonymizer) string {
+	parts := make([]string, 0, len(privacy.PersonalColumns))
+	for _, column := range p.Columns() {
+		parts = append(parts, fmt.Sprintf("%s=%s", column, p.Method(column)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runAnonymize masks personal data in the connected database. It rewrites
+// the data for good, so it is meant for a copy made to share with
+// researchers and refuses to run without -yes.
+func runAnonymize(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("anonymize")
+	masking := fs.String("mask", "", "column=method changes to the default masking (methods: pseudonym, mask, null, keep), e.g. address=mask,gsmno=null")
+	year := fs.Int("year", 0, "only mask candidates of this year (default all, which also empties import_errors, the query logs and other raw copies)")
+	yes := fs.Bool("yes", false, "confirm that the database may be rewritten")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{errors.New("anonymize takes no arguments")}
+	}
+	p, err := newPseudonymizer(*masking)
+	if err != nil {
+		return err
+	}
+	if err := p.CheckInPlace(); err != nil {
+		return usageError{err}
+	}
+	if !*yes {
+		return usageError{fmt.Errorf("anonymize rewrites candidate personal data in database %s on %s for good (%s); run it on a copy, with -yes",
+			cfg.DB.Name, cfg.DB.Host, describeMasking(p))}
+	}
+
+	summary, err := privacy.Anonymize(ctx, db, p, *year, func(done int) {
+		fmt.Fprintf(os.Stderr, "\rMasked %d candidates", done)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("anonymize failed, nothing was changed: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Masked %d candidates and %d exam records (%s)\n", summary.Candidates, summary.ExamInfo, describeMasking(p))
+	if summary.Locations > 0 {
+		fmt.Fprintf(os.Stderr, "Removed %d locations geocoded from addresses\n", summary.Locations)
+	}
+	if len(summary.Emptied) > 0 {
+		fmt.Fprintf(os.Stderr, "Emptied %s\n", strings.Join(summary.Emptied, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nonsonwune/spk2_db/admission"
+	"github.com/nonsonwune/spk2_db/filter"
+)
+
+// capsRejection is a candidate left out of a CAPS upload file
+type capsRejection struct {
+	RegNumber string   `json:"regnumber"`
+	Problems  []string `json:"problems"`
+}
+
+// handleCAPSExport returns a year's admission decisions in the CAPS upload
+// layout, as a CSV file ready to upload.
+//
+//	GET /api/admissions/caps?year=2024&institution=UNILAG&filter=tag=first-list
+//
+// Candidates whose records break the CAPS field rules are left out; their
+// number is in the X-CAPS-Rejected header. format=json returns the rows
+// and the rejected candidates with their problems instead.
+func (s *Server) handleCAPSExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+
+	year, err := strconv.Atoi(q.Get("year"))
+	if err != nil || year <= 0 {
+		writeError(w, http.StatusBadRequest, "year is required")
+		return
+	}
+	var expr *filter.Filter
+	if input := q.Get("filter"); input != "" {
+		if expr, err = filter.Parse(input); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid filter: %v", err))
+			return
+		}
+	}
+
+	export, err := admission.CAPS(r.Context(), s.db, year, q.Get("institution"), "candidate", expr)
+	if err != nil {
+		log.Printf("Error exporting CAPS file: %v", err)
+		writeError(w, http.StatusInternalServerError, "error exporting admission decisions")
+		return
+	}
+
+	if q.Get("format") == "json" {
+		rows := export.Rows
+		if rows == nil {
+			rows = [][]string{}
+		}
+		rejected := make([]capsRejection, len(export.Rejected))
+		for i, r := range export.Rejected {
+			rejected[i] = capsRejection{RegNumber: r.RegNumber, Problems: r.Problems}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"columns":  admission.CAPSHeader,
+			"rows":     rows,
+			"rejected": rejected,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="caps-%d.csv"`, year))
+	w.Header().Set("X-CAPS-Rejected", strconv.Itoa(len(export.Rejected)))
+	if err := export.WriteCSV(w); err != nil {
+		log.Printf("Error writing CAPS file: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nonsonwune/spk2_db/anomaly"
+)
+
+// handleIdenticalScores flags examination centres where many candidates
+// share identical subject scores.
+//
+//	GET /api/anomalies/identical-scores?year=2023&sensitivity=medium
+//
+// sensitivity is low, medium (default) or high; min_cluster_size,
+// min_centre_size and min_ratio override its individual thresholds.
+func (s *Server) handleIdenticalScores(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+
+	year, err := strconv.Atoi(q.Get("year"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "year is required")
+		return
+	}
+
+	sensitivity := q.Get("sensitivity")
+	if sensitivity == "" {
+		sensitivity = anomaly.SensitivityMedium
+	}
+	thresholds, err := anomaly.ThresholdsFor(sensitivity)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if raw := q.Get("min_cluster_size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 2 {
+			writeError(w, http.StatusBadRequest, "min_cluster_size must be an integer of at least 2")
+			return
+		}
+		thresholds.MinClusterSize = n
+	}
+	if raw := q.Get("min_centre_size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "min_centre_size must be a positive integer")
+			return
+		}
+		thresholds.MinCentreSize = n
+	}
+	if raw := q.Get("min_ratio"); raw != "" {
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || f < 0 || f > 1 {
+			writeError(w, http.StatusBadRequest, "min_ratio must be a number from 0 to 1")
+			return
+		}
+		thresholds.MinClusteredRatio = f
+	}
+
+	report, err := anomaly.DetectIdenticalScores(r.Context(), s.db, year, thresholds)
+	if err != nil {
+		log.Printf("Error detecting identical scores: %v", err)
+		writeError(w, http.StatusInternalServerError, "error running detector")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
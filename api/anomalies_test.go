@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIdenticalScoresRejectsBadThresholds(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"min_cluster_size=many", "min_cluster_size must be an integer of at least 2"},
+		{"min_cluster_size=1", "min_cluster_size must be an integer of at least 2"},
+		{"min_centre_size=0", "min_centre_size must be a positive integer"},
+		{"min_centre_size=2.5", "min_centre_size must be a positive integer"},
+		{"min_ratio=1.5", "min_ratio must be a number from 0 to 1"},
+		{"min_ratio=-0.1", "min_ratio must be a number from 0 to 1"},
+		{"min_ratio=half", "min_ratio must be a number from 0 to 1"},
+		{"sensitivity=extreme", "sensitivity"},
+	}
+	// The detector is never reached, so the server needs no database
+	s := &Server{}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handleIdenticalScores(rec, httptest.NewRequest(http.MethodGet, "/api/anomalies/identical-scores?year=2023&"+tt.query, nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: %d %s, want 400 %q", tt.query, rec.Code, strings.TrimSpace(rec.Body.String()), tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/nonsonwune/spk2_db/export"
+)
+
+// arrowBatchSize is the number of rows per Arrow record batch
+const arrowBatchSize = 10000
+
+// writeArrowRows streams rows as an Arrow IPC stream in record batches of
+// arrowBatchSize, which pyarrow and the R arrow package read directly into
+// dataframes.
+func writeArrowRows(w http.ResponseWriter, rows *sql.Rows) error {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	fields := make([]arrow.Field, len(columnTypes))
+	for i, ct := range columnTypes {
+		fields[i] = arrow.Field{Name: ct.Name(), Type: export.ArrowType(ct), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	w.Header().Set("Content-Type", ArrowStreamMediaType)
+	writer := ipc.NewWriter(w, ipc.WithSchema(schema))
+
+	flush := func() error {
+		record := builder.NewRecord()
+		defer record.Release()
+		if record.NumRows() == 0 {
+			return nil
+		}
+		return writer.Write(record)
+	}
+
+	values := make([]interface{}, len(columnTypes))
+	ptrs := make([]interface{}, len(columnTypes))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			writer.Close()
+			return err
+		}
+		for i, v := range values {
+			if err := export.AppendArrowValue(builder.Field(i), v); err != nil {
+				writer.Close()
+				return fmt.Errorf("column %s: %w", fields[i].Name, err)
+			}
+		}
+		if n++; n%arrowBatchSize == 0 {
+			if err := flush(); err != nil {
+				writer.Close()
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := flush(); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
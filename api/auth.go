@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// minTokenLength keeps API tokens too long to guess
+const minTokenLength = 24
+
+// authUserKey is the context key of the user an API token named
+type authUserKey struct{}
+
+// ParseTokens parses API_TOKENS, comma separated name:token pairs such as
+// dashboard:3f9c...,analyst:81be.... The name is who the token authenticates.
+func ParseTokens(raw string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(entry, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("API token %q is not name:token", entry)
+		}
+		if len(token) < minTokenLength {
+			return nil, fmt.Errorf("API token for %s is shorter than %d characters", name, minTokenLength)
+		}
+		if _, dup := tokens[token]; dup {
+			return nil, fmt.Errorf("API token for %s is also given to %s", name, tokens[token])
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// SetTokens requires every request but the health check to carry one of
+// tokens, mapping token to user, as Authorization: Bearer <token>. The
+// user the token names replaces any X-User header the client sent.
+func (s *Server) SetTokens(tokens map[string]string) {
+	s.tokens = tokens
+}
+
+// authenticate rejects requests without a known token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		user := ""
+		if ok {
+			user = s.tokenUser(strings.TrimSpace(token))
+		}
+		if user == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="spk2"`)
+			writeError(w, http.StatusUnauthorized, "a valid API token is required")
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), authUserKey{}, user))
+		r.Header.Set(userHeader, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenUser returns the user token names, comparing every token in
+// constant time so the comparison does not reveal how much of one matched
+func (s *Server) tokenUser(token string) string {
+	user := ""
+	for known, name := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			user = name
+		}
+	}
+	return user
+}
+
+// authenticatedUser returns the user r's API token named, or "" when the
+// server does not require tokens
+func authenticatedUser(r *http.Request) string {
+	user, _ := r.Context().Value(authUserKey{}).(string)
+	return user
+}
+
+// IsLoopback reports whether addr, as given to ListenAndServe, only accepts
+// connections from this machine
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
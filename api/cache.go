@@ -0,0 +1,240 @@
+package api
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxCacheEntries and maxCacheBytes bound the response cache; the least
+	// recently used responses are evicted past either
+	maxCacheEntries = 2000
+	maxCacheBytes   = 64 << 20
+)
+
+// ResponseCache keeps rendered responses of aggregate endpoints in memory and
+// answers conditional requests with 304 Not Modified. Entries are keyed by
+// path, the query parameters the endpoint reads and response format, and
+// tagged with the year they cover so an import only evicts what it could
+// have changed. It holds at most maxCacheEntries responses and
+// maxCacheBytes of bodies, evicting the least recently used.
+type ResponseCache struct {
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+	bytes   int
+}
+
+type cacheEntry struct {
+	key    string
+	year   int // 0 when the response spans all years
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Middleware serves cached responses for GET requests and caches successful
+// responses from next. params are the query parameters next reads; others
+// are ignored, so they cannot fill the cache with copies of one response.
+func (c *ResponseCache) Middleware(params []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+
+		key := cacheKey(r, params)
+		entry := c.get(key)
+		if entry == nil {
+			rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+			next(rec, r)
+			if rec.status != http.StatusOK {
+				return
+			}
+			sum := sha256.Sum256(rec.body.Bytes())
+			entry = &cacheEntry{
+				key:    key,
+				year:   requestYear(r),
+				etag:   `"` + hex.EncodeToString(sum[:16]) + `"`,
+				header: rec.Header().Clone(),
+				body:   rec.body.Bytes(),
+			}
+			c.put(entry)
+		}
+
+		w.Header().Set("ETag", entry.etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), entry.etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		for name, values := range entry.header {
+			w.Header()[name] = values
+		}
+		w.Write(entry.body)
+	}
+}
+
+func (c *ResponseCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+// put caches entry, evicting the least recently used responses to stay
+// within the bounds. A body larger than the whole cache is not kept.
+func (c *ResponseCache) put(entry *cacheEntry) {
+	if len(entry.body) > maxCacheBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	c.bytes += len(entry.body)
+	for c.order.Len() > maxCacheEntries || c.bytes > maxCacheBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove evicts el; the caller holds mu
+func (c *ResponseCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.body)
+}
+
+// InvalidateYear evicts responses for year and responses spanning all years
+func (c *ResponseCache) InvalidateYear(year int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		if entry := el.Value.(*cacheEntry); entry.year == year || entry.year == 0 {
+			c.remove(el)
+		}
+	}
+}
+
+// Clear evicts every cached response
+func (c *ResponseCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// cacheKey identifies a response by path, the values of params and format
+func cacheKey(r *http.Request, params []string) string {
+	format := "json"
+	if wantsArrow(r) {
+		format = "arrow"
+	}
+	q := r.URL.Query()
+	values := make(url.Values, len(params))
+	for _, p := range params {
+		if v := q.Get(p); v != "" {
+			values.Set(p, v)
+		}
+	}
+	return r.URL.Path + "?" + values.Encode() + "#" + format
+}
+
+func requestYear(r *http.Request) int {
+	year, _ := strconv.Atoi(r.URL.Query().Get("year"))
+	return year
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingWriter buffers a successful body so it can be cached and sent
+// with its ETag, which is only known once the body is complete. Error
+// responses pass straight through.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	if status != http.StatusOK {
+		rw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if rw.status != http.StatusOK {
+		return rw.ResponseWriter.Write(p)
+	}
+	return rw.body.Write(p)
+}
+
+// warmCache renders the JSON responses of every cached endpoint for year and
+// for all years, so the first dashboard load after an import is served from
+// memory.
+func (s *Server) warmCache(ctx context.Context, year int) {
+	for _, path := range s.cached {
+		for _, query := range []string{"year=" + strconv.Itoa(year), ""} {
+			if ctx.Err() != nil {
+				return
+			}
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, path+"?"+query, nil)
+			if err != nil {
+				continue
+			}
+			w := &discardWriter{header: make(http.Header)}
+			s.mux.ServeHTTP(w, r)
+			if w.status != http.StatusOK {
+				log.Printf("Warning: warming %s?%s returned %d", path, query, w.status)
+			}
+		}
+	}
+}
+
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+
+func (w *discardWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/nonsonwune/spk2_db/export"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+const (
+	defaultCandidateLimit = 1000
+	maxCandidateLimit     = 1000000
+)
+
+// withheldColumn reports whether column identifies or contacts a candidate.
+// The API does not serve, search, sort or filter on those; the menu and
+// exports, which can mask them, do. The registration number stays, as pages are keyed on it.
+func withheldColumn(column string) bool {
+	if column == "date_of_birth" {
+		return true
+	}
+	for _, c := range privacy.PersonalColumns {
+		if c == column && c != "regnumber" {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateColumns parses the columns parameter, defaulting to the export
+// defaults without the withheld columns
+func candidateColumns(spec string) ([]export.ColumnSpec, error) {
+	if spec == "" {
+		var columns []export.ColumnSpec
+		for _, c := range export.DefaultColumnSpec() {
+			if !withheldColumn(c.Column) {
+				columns = append(columns, c)
+			}
+		}
+		return columns, nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		return nil, fmt.Errorf("column spec files are not supported over the API")
+	}
+	columns, err := export.ParseColumnSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range columns {
+		if withheldColumn(c.Column) {
+			return nil, fmt.Errorf("column %s is personal data and not served over the API", c.Column)
+		}
+	}
+	return columns, nil
+}
+
+// handleCandidates lists candidates ordered by registration number.
+//
+//	GET /api/candidates?filter=year=2023 AND state=LAGOS&columns=regnumber,aggregate:score&limit=50000&after=<regnumber>
+//
+// Pages are keyed on regnumber: pass the last regnumber received as after to
+// fetch the next page. Names, contact details and dates of birth are not
+// served. Add format=arrow (or Accept: application/vnd.apache.arrow.stream)
+// for an Arrow IPC stream instead of JSON.
+func (s *Server) handleCandidates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+
+	var expr *filter.Filter
+	if input := q.Get("filter"); input != "" {
+		var err error
+		if expr, err = filter.Parse(input); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid filter: %v", err))
+			return
+		}
+		for _, column := range expr.Fields() {
+			if withheldColumn(column) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid filter: %s is personal data and cannot be filtered on over the API", column))
+				return
+			}
+		}
+	}
+
+	columns, err := candidateColumns(q.Get("columns"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := defaultCandidateLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxCandidateLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxCandidateLimit))
+			return
+		}
+		limit = n
+	}
+
+	selects := make([]string, len(columns))
+	for i, c := range columns {
+		selects[i] = fmt.Sprintf("%s AS %s", export.ColumnSQL(c.Column), pq.QuoteIdentifier(c.Header))
+	}
+	where, args := expr.SQL("c", 2)
+	query := fmt.Sprintf(`
+        SELECT %s
+        FROM candidate c
+        WHERE c.regnumber > $1 AND %s
+        ORDER BY c.regnumber
+        LIMIT %d`, strings.Join(selects, ", "), where, limit)
+
+	rows, err := s.db.QueryContext(r.Context(), query, append([]interface{}{q.Get("after")}, args...)...)
+	if err != nil {
+		log.Printf("Error querying candidates: %v", err)
+		writeError(w, http.StatusInternalServerError, "error querying candidates")
+		return
+	}
+	defer rows.Close()
+
+	// Headers are already sent once streaming starts, so a failure part way
+	// through can only be logged and the response cut short.
+	if err := writeRows(w, r, rows); err != nil {
+		log.Printf("Error streaming candidates: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// InvalidationChannel is the PostgreSQL NOTIFY channel API servers listen on
+// for cache invalidations
+const InvalidationChannel = "api_cache_invalidate"
+
+// NotifyImportComplete tells every running API server that candidate data
+// for year has changed. It is registered as an importer completion hook.
+func NotifyImportComplete(ctx context.Context, db *sql.DB, year int) error {
+	_, err := db.ExecContext(ctx, "SELECT pg_notify($1, $2)", InvalidationChannel, strconv.Itoa(year))
+	return err
+}
+
+// WatchInvalidations listens for import notifications on a dedicated
+// connection and evicts, then re-warms, affected cache entries until ctx is
+// cancelled. The whole cache is dropped after a reconnect, since
+// notifications may have been missed while disconnected.
+func (s *Server) WatchInvalidations(ctx context.Context, dsn string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventReconnected:
+			s.cache.Clear()
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("Cache invalidation listener: %v", err)
+		}
+	})
+	if err := listener.Listen(InvalidationChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				if n == nil {
+					// Connection was re-established
+					s.cache.Clear()
+					continue
+				}
+				year, err := strconv.Atoi(n.Extra)
+				if err != nil {
+					s.cache.Clear()
+					continue
+				}
+				log.Printf("Invalidating cached responses for %d", year)
+				s.cache.InvalidateYear(year)
+				go s.warmCache(ctx, year)
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,396 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/nlquery"
+)
+
+const (
+	nlSessionsPath = "/api/nl/sessions"
+	// DefaultNLSessionTTL is how long an idle NL session is kept
+	DefaultNLSessionTTL = 30 * time.Minute
+	// maxNLSessionsPerUser bounds the model clients one analyst can hold open
+	maxNLSessionsPerUser = 10
+	// nlAnswerTimeout bounds a single question, including model retries
+	nlAnswerTimeout = 2 * time.Minute
+	// userHeader carries the analyst's identity, set by the authenticating
+	// proxy in front of the API
+	userHeader = "X-User"
+)
+
+// nlAnswerer answers natural language questions, treating each as a
+// possible follow-up to the ones before it until Reset;
+// *nlquery.NLQueryEngine is the implementation
+type nlAnswerer interface {
+	Answer(ctx context.Context, question string) (*nlquery.QueryResult, error)
+	Reset()
+}
+
+// NLTurn is one question of a session and its outcome. A turn after a
+// reset does not follow up on the turns before it.
+type NLTurn struct {
+	Question    string    `json:"question"`
+	Reset       bool      `json:"reset,omitempty"`
+	SQL         string    `json:"sql,omitempty"`
+	Provider    string    `json:"provider,omitempty"`
+	Explanation string    `json:"explanation,omitempty"`
+	Results     string    `json:"results,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	AskedAt     time.Time `json:"asked_at"`
+}
+
+// nlSession is one analyst's conversation. Each session has its own engine,
+// so sessions never share model clients or state.
+type nlSession struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Turns     []NLTurn  `json:"turns"`
+
+	user         string
+	engine       nlAnswerer
+	busy         bool // a question is being answered
+	resetPending bool // the next turn starts a new conversation
+}
+
+// nlSessionSummary lists a session without its turns
+type nlSessionSummary struct {
+	ID           string    `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastUsed     time.Time `json:"last_used_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Turns        int       `json:"turns"`
+	LastQuestion string    `json:"last_question,omitempty"`
+}
+
+// nlSessions holds the NL sessions of every analyst in memory. Sessions idle
+// for longer than ttl are dropped the next time the store is used.
+type nlSessions struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	sessions  map[string]*nlSession
+	newEngine func() (nlAnswerer, error)
+}
+
+func newNLSessions(ttl time.Duration, newEngine func() (nlAnswerer, error)) *nlSessions {
+	return &nlSessions{ttl: ttl, sessions: make(map[string]*nlSession), newEngine: newEngine}
+}
+
+// SetNLSessionTTL changes how long idle NL sessions are kept. The public
+// server has no sessions and ignores it.
+func (s *Server) SetNLSessionTTL(ttl time.Duration) {
+	if s.nl == nil {
+		return
+	}
+	s.nl.mu.Lock()
+	defer s.nl.mu.Unlock()
+	s.nl.ttl = ttl
+}
+
+// expire drops idle sessions; the caller holds mu
+func (st *nlSessions) expire(now time.Time) {
+	for id, session := range st.sessions {
+		if !session.busy && now.Sub(session.LastUsed) > st.ttl {
+			delete(st.sessions, id)
+		}
+	}
+}
+
+// touch marks a session used; the caller holds mu
+func (st *nlSessions) touch(session *nlSession, now time.Time) {
+	session.LastUsed = now
+	session.ExpiresAt = now.Add(st.ttl)
+}
+
+// get returns a live session owned by user, or nil
+func (st *nlSessions) get(user, id string) *nlSession {
+	st.expire(time.Now())
+	session := st.sessions[id]
+	if session == nil || session.user != user {
+		return nil
+	}
+	return session
+}
+
+func (s *Server) registerNLSessions() {
+	s.nl = newNLSessions(DefaultNLSessionTTL, func() (nlAnswerer, error) {
+		engine, err := nlquery.NewNLQueryEngineWithOptions(s.db, nlquery.Options{QueryDB: s.analytics, Source: "api"})
+		if err != nil {
+			return nil, err
+		}
+		return engine, nil
+	})
+	s.mux.HandleFunc(nlSessionsPath, s.handleNLSessions)
+	s.mux.HandleFunc(nlSessionsPath+"/", s.handleNLSession)
+	s.mux.HandleFunc(nlCachePath, s.handleNLCache)
+	s.mux.HandleFunc(nlKeysPath, s.handleNLKeys)
+}
+
+const nlKeysPath = "/api/nl/keys"
+
+// GET /api/nl/keys reports the health of the server's Gemini API keys
+func (s *Server) handleNLKeys(w http.ResponseWriter, r *http.Request) {
+	if requestUser(w, r) == "" {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": nlquery.KeyHealth()})
+}
+
+const nlCachePath = "/api/nl/cache"
+
+// DELETE /api/nl/cache empties the NL translation cache
+func (s *Server) handleNLCache(w http.ResponseWriter, r *http.Request) {
+	if requestUser(w, r) == "" {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	n, err := nlquery.ClearCache(r.Context(), s.db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"deleted": n})
+}
+
+// requestUser returns the analyst making the request, writing an error and
+// returning "" when the identity header is missing
+func requestUser(w http.ResponseWriter, r *http.Request) string {
+	user := strings.TrimSpace(r.Header.Get(userHeader))
+	if user == "" {
+		writeError(w, http.StatusUnauthorized, userHeader+" header is required")
+	}
+	return user
+}
+
+// GET /api/nl/sessions lists the caller's sessions, most recent first;
+// POST /api/nl/sessions starts a new one
+func (s *Server) handleNLSessions(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(w, r)
+	if user == "" {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.listNLSessions(w, user)
+	case http.MethodPost:
+		s.createNLSession(w, user)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) listNLSessions(w http.ResponseWriter, user string) {
+	st := s.nl
+	st.mu.Lock()
+	st.expire(time.Now())
+	list := make([]nlSessionSummary, 0)
+	for _, session := range st.sessions {
+		if session.user != user {
+			continue
+		}
+		summary := nlSessionSummary{
+			ID: session.ID, CreatedAt: session.CreatedAt, LastUsed: session.LastUsed,
+			ExpiresAt: session.ExpiresAt, Turns: len(session.Turns),
+		}
+		if n := len(session.Turns); n > 0 {
+			summary.LastQuestion = session.Turns[n-1].Question
+		}
+		list = append(list, summary)
+	}
+	st.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].LastUsed.After(list[j].LastUsed) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) createNLSession(w http.ResponseWriter, user string) {
+	st := s.nl
+	st.mu.Lock()
+	st.expire(time.Now())
+	count := 0
+	for _, session := range st.sessions {
+		if session.user == user {
+			count++
+		}
+	}
+	st.mu.Unlock()
+	if count >= maxNLSessionsPerUser {
+		writeError(w, http.StatusTooManyRequests, "too many open sessions; delete one or wait for it to expire")
+		return
+	}
+
+	engine, err := st.newEngine()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "natural language queries unavailable: "+err.Error())
+		return
+	}
+	id, err := newSessionID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error creating session")
+		return
+	}
+
+	now := time.Now()
+	session := &nlSession{ID: id, CreatedAt: now, Turns: []NLTurn{}, user: user, engine: engine}
+	st.mu.Lock()
+	st.touch(session, now)
+	st.sessions[id] = session
+	body, _ := json.Marshal(session)
+	st.mu.Unlock()
+
+	w.Header().Set("Location", nlSessionsPath+"/"+id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
+
+// GET /api/nl/sessions/{id} returns a session with its turns, to resume it;
+// DELETE ends it. POST /api/nl/sessions/{id}/questions with
+// {"question": "..."} asks a question in the session, which may follow up
+// on the earlier ones; "no_cache": true skips the translation cache. POST
+// /api/nl/sessions/{id}/reset makes the next question start afresh.
+func (s *Server) handleNLSession(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(w, r)
+	if user == "" {
+		return
+	}
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, nlSessionsPath+"/"), "/")
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		st := s.nl
+		st.mu.Lock()
+		session := st.get(user, id)
+		var body []byte
+		if session != nil {
+			st.touch(session, time.Now())
+			body, _ = json.Marshal(session)
+		}
+		st.mu.Unlock()
+		if session == nil {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	case action == "" && r.Method == http.MethodDelete:
+		st := s.nl
+		st.mu.Lock()
+		session := st.get(user, id)
+		if session != nil {
+			delete(st.sessions, id)
+		}
+		st.mu.Unlock()
+		if session == nil {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "questions" && r.Method == http.MethodPost:
+		s.askNLSession(w, r, user, id)
+	case action == "reset" && r.Method == http.MethodPost:
+		st := s.nl
+		st.mu.Lock()
+		session := st.get(user, id)
+		switch {
+		case session == nil:
+			st.mu.Unlock()
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		case session.busy:
+			st.mu.Unlock()
+			writeError(w, http.StatusConflict, "the session is still answering a question")
+			return
+		}
+		session.engine.Reset()
+		session.resetPending = true
+		st.touch(session, time.Now())
+		st.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case action == "" || action == "questions" || action == "reset":
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) askNLSession(w http.ResponseWriter, r *http.Request, user, id string) {
+	var req struct {
+		Question string `json:"question"`
+		NoCache  bool   `json:"no_cache"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Question) == "" {
+		writeError(w, http.StatusBadRequest, `body must be {"question": "..."}`)
+		return
+	}
+
+	st := s.nl
+	st.mu.Lock()
+	session := st.get(user, id)
+	switch {
+	case session == nil:
+		st.mu.Unlock()
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	case session.busy:
+		st.mu.Unlock()
+		writeError(w, http.StatusConflict, "the session is still answering a question")
+		return
+	}
+	session.busy = true
+	st.touch(session, time.Now())
+	st.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), nlAnswerTimeout)
+	defer cancel()
+	if req.NoCache {
+		ctx = nlquery.WithoutCache(ctx)
+	}
+	turn := NLTurn{Question: strings.TrimSpace(req.Question), AskedAt: time.Now()}
+	result, err := session.engine.Answer(ctx, turn.Question)
+	if result != nil {
+		turn.SQL, turn.Provider = result.SQLQuery, result.Provider
+		turn.Explanation, turn.Results = result.Explanation, result.Results
+	}
+	if err != nil {
+		turn.Error = err.Error()
+	}
+
+	st.mu.Lock()
+	turn.Reset, session.resetPending = session.resetPending, false
+	session.Turns = append(session.Turns, turn)
+	session.busy = false
+	st.touch(session, time.Now())
+	st.mu.Unlock()
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, turn)
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
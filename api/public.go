@@ -0,0 +1,223 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+// NewPublicServer serves only the aggregate statistics that are safe to
+// publish as open data. No endpoint returns candidate-level rows and every
+// table passes through guard, so no published cell describes fewer than
+// guard.K candidates.
+func NewPublicServer(db *sql.DB, guard privacy.Guard) *Server {
+	s := &Server{
+		db:    db,
+		mux:   http.NewServeMux(),
+		cache: NewResponseCache(),
+		guard: guard,
+	}
+	s.publicRoutes()
+	return s
+}
+
+func (s *Server) publicRoutes() {
+	s.mux.HandleFunc("/api/health", s.handleHealth)
+	s.handlePublic("/public/v1/stats/gender", []string{"year"}, s.handlePublicGender)
+	s.handlePublic("/public/v1/stats/states", []string{"year"}, s.handlePublicStates)
+	s.handlePublic("/public/v1/stats/score-bands", []string{"year", "by"}, s.handlePublicScoreBands)
+	s.handlePublic("/public/v1/stats/admissions", []string{"year", "by"}, s.handlePublicAdmissions)
+	s.handlePublic("/public/v1/stats/courses", []string{"year"}, s.handlePublicCourses)
+}
+
+// handlePublic registers a cached public endpoint, reading the query
+// parameters params, that browser dashboards on any origin may read
+func (s *Server) handlePublic(path string, params []string, h http.HandlerFunc) {
+	s.cached = append(s.cached, path)
+	cached := s.cache.Middleware(params, h)
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		cached(w, r)
+	})
+}
+
+// publicTable is the response body of every public endpoint
+type publicTable struct {
+	MinGroupSize     int             `json:"min_group_size"`
+	Mode             privacy.Mode    `json:"mode"`
+	SuppressedGroups int             `json:"suppressed_groups"`
+	MergedGroups     int             `json:"merged_groups"`
+	BlankedCounts    int             `json:"blanked_counts"`
+	Columns          []string        `json:"columns"`
+	Rows             [][]interface{} `json:"rows"`
+}
+
+// publicGroupings maps the by parameter to the grouping expression
+var publicGroupings = map[string]string{
+	"gender": "c.gender",
+	"state":  "s.st_name",
+}
+
+func publicGrouping(r *http.Request) (string, string, error) {
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "gender"
+	}
+	expr, ok := publicGroupings[by]
+	if !ok {
+		return "", "", fmt.Errorf("by must be gender or state")
+	}
+	return by, expr, nil
+}
+
+// queryPublic runs an aggregate query whose %s placeholder takes the year
+// condition and publishes the result through the server's privacy guard
+func (s *Server) queryPublic(w http.ResponseWriter, r *http.Request, name, query string, spec privacy.Spec) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	where, args, err := yearClause(r, "c")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(query, where), args...)
+	if err != nil {
+		log.Printf("Error getting public %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "error getting "+name)
+		return
+	}
+	defer rows.Close()
+
+	table, err := s.guarded(rows, spec)
+	if err != nil {
+		log.Printf("Error reading public %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "error getting "+name)
+		return
+	}
+	writeJSON(w, http.StatusOK, table)
+}
+
+func (s *Server) guarded(rows *sql.Rows, spec privacy.Spec) (*publicTable, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var all [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			values[i] = jsonValue(v)
+		}
+		all = append(all, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	published, res, err := s.guard.Apply(columns, all, spec)
+	if err != nil {
+		return nil, err
+	}
+	if published == nil {
+		published = [][]interface{}{}
+	}
+	return &publicTable{
+		MinGroupSize:     s.guard.K,
+		Mode:             s.guard.Mode,
+		SuppressedGroups: res.Suppressed,
+		MergedGroups:     res.Merged,
+		BlankedCounts:    res.Blanked,
+		Columns:          columns,
+		Rows:             published,
+	}, nil
+}
+
+// GET /public/v1/stats/gender?year=2023
+func (s *Server) handlePublicGender(w http.ResponseWriter, r *http.Request) {
+	s.queryPublic(w, r, "gender statistics", `
+        SELECT c.gender, COUNT(*) AS candidates, ROUND(AVG(c.aggregate)::numeric, 1) AS average_aggregate
+        FROM candidate c
+        WHERE c.gender IS NOT NULL AND %s
+        GROUP BY c.gender
+        ORDER BY c.gender`,
+		privacy.Spec{Size: "candidates", Label: "gender", Means: []string{"average_aggregate"}})
+}
+
+// GET /public/v1/stats/states?year=2023
+func (s *Server) handlePublicStates(w http.ResponseWriter, r *http.Request) {
+	s.queryPublic(w, r, "state statistics", `
+        SELECT s.st_name AS state, COUNT(*) AS candidates, ROUND(AVG(c.aggregate)::numeric, 1) AS average_aggregate
+        FROM candidate c
+        JOIN state s ON c.statecode = s.st_id
+        WHERE %s
+        GROUP BY s.st_name
+        ORDER BY s.st_name`,
+		privacy.Spec{Size: "candidates", Label: "state", Means: []string{"average_aggregate"}})
+}
+
+// GET /public/v1/stats/score-bands?year=2023&by=state
+func (s *Server) handlePublicScoreBands(w http.ResponseWriter, r *http.Request) {
+	by, expr, err := publicGrouping(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.queryPublic(w, r, "score bands", fmt.Sprintf(`
+        SELECT %[1]s AS %[2]s, COUNT(*) AS candidates,
+               COUNT(*) FILTER (WHERE c.aggregate >= 300) AS band_300_plus,
+               COUNT(*) FILTER (WHERE c.aggregate >= 250 AND c.aggregate < 300) AS band_250_299,
+               COUNT(*) FILTER (WHERE c.aggregate >= 200 AND c.aggregate < 250) AS band_200_249,
+               COUNT(*) FILTER (WHERE c.aggregate >= 150 AND c.aggregate < 200) AS band_150_199,
+               COUNT(*) FILTER (WHERE c.aggregate < 150) AS band_below_150
+        FROM candidate c
+        LEFT JOIN state s ON c.statecode = s.st_id
+        WHERE %[1]s IS NOT NULL AND %%s
+        GROUP BY 1
+        ORDER BY 1`, expr, by),
+		privacy.Spec{Size: "candidates", Label: by, Counts: []string{
+			"band_300_plus", "band_250_299", "band_200_249", "band_150_199", "band_below_150"}})
+}
+
+// GET /public/v1/stats/admissions?year=2023&by=gender
+func (s *Server) handlePublicAdmissions(w http.ResponseWriter, r *http.Request) {
+	by, expr, err := publicGrouping(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.queryPublic(w, r, "admissions", fmt.Sprintf(`
+        SELECT %[1]s AS %[2]s, COUNT(*) AS candidates,
+               COUNT(*) FILTER (WHERE c.is_admitted) AS admitted
+        FROM candidate c
+        LEFT JOIN state s ON c.statecode = s.st_id
+        WHERE %[1]s IS NOT NULL AND %%s
+        GROUP BY 1
+        ORDER BY 1`, expr, by),
+		privacy.Spec{Size: "candidates", Label: by, Counts: []string{"admitted"}})
+}
+
+// GET /public/v1/stats/courses?year=2023
+func (s *Server) handlePublicCourses(w http.ResponseWriter, r *http.Request) {
+	s.queryPublic(w, r, "course statistics", `
+        SELECT co.course_name AS course, COUNT(*) AS candidates,
+               ROUND(AVG(c.aggregate)::numeric, 1) AS average_aggregate
+        FROM candidate c
+        JOIN course co ON co.course_code = c.app_course1
+        WHERE %s
+        GROUP BY co.course_name
+        ORDER BY candidates DESC`,
+		privacy.Spec{Size: "candidates", Label: "course", Means: []string{"average_aggregate"}})
+}
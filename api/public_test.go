@@ -0,0 +1,112 @@
+package api
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+// getPublic serves one public request against rows and decodes the table
+func getPublic(t *testing.T, target, query string, rows *sqlmock.Rows) publicTable {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(query).WithArgs(2023).WillReturnRows(rows)
+
+	s := NewPublicServer(db, privacy.Guard{K: 10, Mode: privacy.ModeSuppress})
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d: %s", target, rec.Code, rec.Body)
+	}
+	var table publicTable
+	if err := json.Unmarshal(rec.Body.Bytes(), &table); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	return table
+}
+
+func TestPublicScoreBandsCannotBeRebuilt(t *testing.T) {
+	columns := []string{"state", "candidates", "band_300_plus", "band_250_299", "band_200_249", "band_150_199", "band_below_150"}
+	source := map[string][]int64{
+		"Lagos": {120, 30, 40, 30, 15, 5},
+		"Ogun":  {40, 0, 12, 20, 8, 0},
+		"Osun":  {45, 0, 3, 2, 40, 0},
+		"Oyo":   {5, 0, 1, 2, 2, 0},
+	}
+	rows := sqlmock.NewRows(columns)
+	for _, state := range []string{"Lagos", "Ogun", "Osun", "Oyo"} {
+		values := []driver.Value{state}
+		for _, n := range source[state] {
+			values = append(values, n)
+		}
+		rows.AddRow(values...)
+	}
+	table := getPublic(t, "/public/v1/stats/score-bands?year=2023&by=state", "band_300_plus", rows)
+
+	if len(table.Rows) != 3 || table.SuppressedGroups != 1 {
+		t.Fatalf("published %d rows suppressing %d, want 3 suppressing 1", len(table.Rows), table.SuppressedGroups)
+	}
+	for _, row := range table.Rows {
+		state := row[0].(string)
+		// The bands add up to the candidates, so an attacker subtracts the
+		// visible bands from the total to recover whatever was blanked
+		rest := row[1].(float64)
+		var blanks []string
+		var hidden int64
+		for i := 2; i < len(row); i++ {
+			if row[i] == nil {
+				blanks = append(blanks, columns[i])
+				hidden += source[state][i-1]
+				continue
+			}
+			rest -= row[i].(float64)
+		}
+		if len(blanks) == 1 {
+			t.Errorf("%s: %s rebuilt as %v from the rest of the row", state, blanks[0], rest)
+		}
+		if len(blanks) > 0 && hidden < 10 {
+			t.Errorf("%s: blanked bands %v together hold %d candidates", state, blanks, hidden)
+		}
+		for i := 2; i < len(row); i++ {
+			if n, ok := row[i].(float64); ok && n > 0 && n < 10 {
+				t.Errorf("%s: %s of %v published", state, columns[i], n)
+			}
+		}
+	}
+}
+
+func TestPublicAdmissionsHideSmallComplement(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"gender", "candidates", "admitted"}).
+		AddRow("F", int64(50), int64(45)).
+		AddRow("M", int64(40), int64(30))
+	table := getPublic(t, "/public/v1/stats/admissions?year=2023&by=gender", "is_admitted", rows)
+
+	if len(table.Rows) != 2 || table.BlankedCounts != 1 {
+		t.Fatalf("rows = %v blanking %d, want 2 rows blanking 1", table.Rows, table.BlankedCounts)
+	}
+	for _, row := range table.Rows {
+		admitted, ok := row[2].(float64)
+		if !ok {
+			continue
+		}
+		// Those not admitted are the candidates less the admitted
+		if rest := row[1].(float64) - admitted; rest > 0 && rest < 10 {
+			t.Errorf("%v: %v not admitted can be derived", row[0], rest)
+		}
+	}
+	if row := table.Rows[0]; row[2] != nil {
+		t.Errorf("F admitted = %v, want blanked", row[2])
+	}
+}
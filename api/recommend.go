@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nonsonwune/spk2_db/recommend"
+)
+
+// handleRecommendations suggests courses a UTME result is likely to get a
+// candidate into.
+//
+//	GET /api/recommendations?scores=ENG=70,MTH=65,PHY=60,CHM=58&state=LAGOS&limit=20
+//
+// years and band optionally override how many past years are considered
+// and how close past applicants' aggregates must be to count as comparable.
+func (s *Server) handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+
+	scores, err := recommend.ParseScores(q.Get("scores"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req := recommend.Request{Scores: scores}
+	if state := q.Get("state"); state != "" {
+		if req.StateID, err = recommend.StateID(r.Context(), s.db, state); err != nil {
+			writeRecommendError(w, err)
+			return
+		}
+	}
+	for name, field := range map[string]*int{"limit": &req.Limit, "years": &req.Years, "band": &req.Band} {
+		if raw := q.Get(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, name+" must be a positive integer")
+				return
+			}
+			*field = n
+		}
+	}
+
+	result, err := recommend.Recommend(r.Context(), s.db, req)
+	if err != nil {
+		writeRecommendError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeRecommendError(w http.ResponseWriter, err error) {
+	if errors.Is(err, recommend.ErrInvalidRequest) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("Error recommending courses: %v", err)
+	writeError(w, http.StatusInternalServerError, "error recommending courses")
+}
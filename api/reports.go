@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/reports"
+)
+
+const reportsPath = "/api/reports/"
+
+// reportParams are the query parameters every report reads
+var reportParams = []string{"year", "filter"}
+
+// registerReports serves each menu report as a cached endpoint under
+// /api/reports/<name>, plus an index of them at /api/reports
+func (s *Server) registerReports() {
+	s.mux.HandleFunc("/api/reports", s.handleReportIndex)
+	for _, report := range reports.All {
+		report := report
+		s.handleCached(reportsPath+report.Name, reportParams, func(w http.ResponseWriter, r *http.Request) {
+			s.runReport(w, r, report)
+		})
+	}
+	s.handleCached(reportsPath+"year-comparison", reportParams, s.handleYearComparison)
+	s.handleCached(reportsPath+"institution-composite", []string{"year", "filter", "weights"}, s.handleCompositeRanking)
+}
+
+// GET /api/reports
+func (s *Server) handleReportIndex(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		Name  string `json:"name"`
+		Title string `json:"title"`
+		Path  string `json:"path"`
+	}
+	all := append(append([]reports.Report{}, reports.All...),
+		reports.YearComparison(false), reports.CompositeRanking(reports.DefaultRankingWeights))
+	index := make([]entry, len(all))
+	for i, report := range all {
+		index[i] = entry{Name: report.Name, Title: report.Title, Path: reportsPath + report.Name}
+	}
+	writeJSON(w, http.StatusOK, index)
+}
+
+// GET /api/reports/year-comparison?filter=state=LAGOS
+func (s *Server) handleYearComparison(w http.ResponseWriter, r *http.Request) {
+	var equated bool
+	err := s.db.QueryRowContext(r.Context(), `
+        SELECT EXISTS (
+            SELECT 1 FROM information_schema.columns
+            WHERE table_name = 'candidate' AND column_name = 'equated_aggregate'
+        )`).Scan(&equated)
+	if err != nil {
+		log.Printf("Error checking for equated scores: %v", err)
+	}
+	s.runReport(w, r, reports.YearComparison(equated))
+}
+
+// GET /api/reports/institution-composite?weights=score=0.6,volume=0.4
+func (s *Server) handleCompositeRanking(w http.ResponseWriter, r *http.Request) {
+	weights := reports.DefaultRankingWeights
+	if input := r.URL.Query().Get("weights"); input != "" {
+		var err error
+		if weights, err = reports.ParseRankingWeights(input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	s.runReport(w, r, reports.CompositeRanking(weights))
+}
+
+// runReport runs a report over the candidates selected by the request's
+// year and filter parameters, e.g.
+//
+//	GET /api/reports/courses?year=2023&filter=state=LAGOS AND gender=F
+//
+// Results are JSON, or an Arrow stream with format=arrow. The X-Report-Source
+// header says whether summary tables or candidate data were read.
+func (s *Server) runReport(w http.ResponseWriter, r *http.Request, report reports.Report) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	source, args, err := reportSource(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	plan, err := reports.Route(r.Context(), s.db, report, source)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	w.Header().Set("X-Report-Source", plan.Footer())
+
+	rows, err := s.db.QueryContext(r.Context(), plan.SQL, args...)
+	if err != nil {
+		log.Printf("Error running report %s: %v", report.Name, err)
+		writeError(w, http.StatusInternalServerError, "error running report "+report.Name)
+		return
+	}
+	defer rows.Close()
+
+	if err := writeRows(w, r, rows); err != nil {
+		log.Printf("Error writing report %s: %v", report.Name, err)
+		// Keeps the partial body out of the response cache
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// reportSource returns the candidate relation a report reads: the whole
+// candidate table, or a subquery restricted by the year and filter
+// parameters
+func reportSource(r *http.Request) (string, []interface{}, error) {
+	q := r.URL.Query()
+	var expr *filter.Filter
+	if input := q.Get("filter"); input != "" {
+		var err error
+		if expr, err = filter.Parse(input); err != nil {
+			return "", nil, fmt.Errorf("invalid filter: %v", err)
+		}
+	}
+
+	year := 0
+	if raw := q.Get("year"); raw != "" {
+		var err error
+		if year, err = strconv.Atoi(raw); err != nil {
+			return "", nil, fmt.Errorf("invalid year %q", raw)
+		}
+	}
+	source, args := reports.Source(year, expr)
+	return source, args, nil
+}
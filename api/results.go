@@ -0,0 +1,90 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ArrowStreamMediaType is the media type of an Arrow IPC stream
+const ArrowStreamMediaType = "application/vnd.apache.arrow.stream"
+
+// wantsArrow reports whether the client asked for an Arrow stream, either
+// with ?format=arrow or through the Accept header.
+func wantsArrow(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return strings.EqualFold(format, "arrow")
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == ArrowStreamMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRows streams a result set in the format the client negotiated. Rows
+// are written as they are scanned, so large result sets are never held in
+// memory.
+func writeRows(w http.ResponseWriter, r *http.Request, rows *sql.Rows) error {
+	if wantsArrow(r) {
+		return writeArrowRows(w, rows)
+	}
+	return writeJSONRows(w, rows)
+}
+
+// writeJSONRows writes {"columns": [...], "rows": [[...], ...]}
+func writeJSONRows(w http.ResponseWriter, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	header, _ := json.Marshal(columns)
+	fmt.Fprintf(w, `{"columns":%s,"rows":[`, header)
+
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	first := true
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			values[i] = jsonValue(v)
+		}
+		row, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		w.Write(row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("]}\n"))
+	return err
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []byte:
+		return string(val)
+	case time.Time:
+		return val.Format(time.RFC3339)
+	}
+	return v
+}
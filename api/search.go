@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/search"
+)
+
+// searchResponse is a page of search results with a link to the next page
+type searchResponse struct {
+	*search.Result
+	Pages int    `json:"pages"`
+	Next  string `json:"next,omitempty"`
+}
+
+// handleSearch finds candidates by registration number.
+//
+//	GET /api/search?q=okafor&year=2023&state=LAGOS&lga=IKEJA&gender=F&course=MEDICINE
+//	    &min_score=250&max_score=300&admitted=true&sort=aggregate&order=desc&page=2&page_size=50
+//
+// Every parameter is optional; filter takes a filter expression as in
+// /api/candidates. As there, names are withheld: q matches registration
+// numbers only, and results cannot be sorted or filtered on names. The
+// response carries the total and, when there are more matches, the URL of
+// the next page.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	criteria, opts, err := searchParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := search.Run(r.Context(), s.db, criteria, opts)
+	if err != nil {
+		log.Printf("Error searching candidates: %v", err)
+		writeError(w, http.StatusInternalServerError, "error searching candidates")
+		return
+	}
+
+	resp := searchResponse{Result: result, Pages: result.Pages()}
+	if result.HasNext() {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(result.Page+1))
+		resp.Next = r.URL.Path + "?" + next.Encode()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func searchParams(r *http.Request) (search.Criteria, search.Options, error) {
+	q := r.URL.Query()
+	criteria := search.Criteria{
+		Term:          q.Get("q"),
+		State:         q.Get("state"),
+		LGA:           q.Get("lga"),
+		Gender:        q.Get("gender"),
+		Course:        q.Get("course"),
+		WithholdNames: true,
+	}
+	opts := search.Options{Sort: q.Get("sort")}
+	if withheldColumn(opts.Sort) {
+		return criteria, opts, fmt.Errorf("cannot sort by %s: it is personal data and not served over the API", opts.Sort)
+	}
+
+	intParam := func(name string, dst *int) error {
+		if raw := q.Get(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", name, raw)
+			}
+			*dst = n
+		}
+		return nil
+	}
+	scoreParam := func(name string) (*int, error) {
+		if q.Get(name) == "" {
+			return nil, nil
+		}
+		var n int
+		err := intParam(name, &n)
+		return &n, err
+	}
+
+	var err error
+	for name, dst := range map[string]*int{"year": &criteria.Year, "page": &opts.Page, "page_size": &opts.PageSize} {
+		if err = intParam(name, dst); err != nil {
+			return criteria, opts, err
+		}
+	}
+	if criteria.MinScore, err = scoreParam("min_score"); err != nil {
+		return criteria, opts, err
+	}
+	if criteria.MaxScore, err = scoreParam("max_score"); err != nil {
+		return criteria, opts, err
+	}
+	if raw := q.Get("admitted"); raw != "" {
+		admitted, err := strconv.ParseBool(raw)
+		if err != nil {
+			return criteria, opts, fmt.Errorf("invalid admitted %q", raw)
+		}
+		criteria.Admitted = &admitted
+	}
+	switch order := strings.ToLower(q.Get("order")); order {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return criteria, opts, fmt.Errorf("invalid order %q; use asc or desc", order)
+	}
+	if input := q.Get("filter"); input != "" {
+		if criteria.Filter, err = filter.Parse(input); err != nil {
+			return criteria, opts, fmt.Errorf("invalid filter: %v", err)
+		}
+		for _, column := range criteria.Filter.Fields() {
+			if withheldColumn(column) {
+				return criteria, opts, fmt.Errorf("invalid filter: %s is personal data and cannot be filtered on over the API", column)
+			}
+		}
+	}
+	return criteria, opts, opts.Validate()
+}
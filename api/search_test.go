@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchParamsWithholdNames(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"q=2023&sort=aggregate&filter=year=2023", ""},
+		{"sort=surname", "cannot sort by surname"},
+		{"filter=year=2023 AND surname=OKAFOR", "surname is personal data"},
+		{"filter=NOT (surname=OKAFOR OR state=LAGOS)", "surname is personal data"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/search?"+strings.ReplaceAll(tt.query, " ", "+"), nil)
+		criteria, _, err := searchParams(r)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.query, err)
+			} else if !criteria.WithholdNames {
+				t.Errorf("%s: names are not withheld", tt.query)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.query, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package api exposes the candidate database over HTTP for dashboards and
+// analysts who work outside the interactive menu.
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+// Server serves the HTTP API
+type Server struct {
+	db *sql.DB
+	// analytics runs natural language queries; db unless SetAnalyticsDB chose a replica
+	analytics *sql.DB
+	mux       *http.ServeMux
+	cache     *ResponseCache
+	// cached lists the paths served through the response cache
+	cached []string
+	// guard enforces k-anonymity on the public endpoints
+	guard privacy.Guard
+	// nl holds analysts' natural language sessions
+	nl *nlSessions
+	// verify rate limits applicant verification per client, on the
+	// verification server only
+	verify *verifyLimiter
+	// tokens maps each accepted API token to its user; empty when the
+	// server does not require one
+	tokens map[string]string
+}
+
+func NewServer(db *sql.DB) *Server {
+	s := &Server{
+		db:        db,
+		analytics: db,
+		mux:       http.NewServeMux(),
+		cache:     NewResponseCache(),
+	}
+	s.routes()
+	return s
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("/api/health", s.handleHealth)
+	s.mux.HandleFunc("/api/candidates", s.handleCandidates)
+	s.mux.HandleFunc("/api/candidates/standing", s.handleStanding)
+	s.mux.HandleFunc("/api/search", s.handleSearch)
+	s.mux.HandleFunc("/api/anomalies/identical-scores", s.cache.Middleware(
+		[]string{"year", "sensitivity", "min_cluster_size", "min_centre_size", "min_ratio"}, s.handleIdenticalScores))
+	s.mux.HandleFunc("/api/recommendations", s.handleRecommendations)
+	s.mux.HandleFunc("/api/admissions/caps", s.handleCAPSExport)
+	s.registerNLSessions()
+
+	// Aggregates only change when data is imported, so they are cached
+	s.handleCached("/api/stats/gender", []string{"year"}, s.handleGenderStats)
+	s.handleCached("/api/stats/states", []string{"year", "cumulative"}, s.handleStateDistribution)
+	s.registerReports()
+}
+
+// SetAnalyticsDB runs natural language queries on db, e.g. a read-only
+// replica
+func (s *Server) SetAnalyticsDB(db *sql.DB) {
+	s.analytics = db
+}
+
+// handleCached registers a cached endpoint that reads the query parameters
+// params
+func (s *Server) handleCached(path string, params []string, h http.HandlerFunc) {
+	s.cached = append(s.cached, path)
+	s.mux.HandleFunc(path, s.cache.Middleware(params, h))
+}
+
+// Handler returns the API's root handler
+func (s *Server) Handler() http.Handler {
+	var h http.Handler = s.mux
+	if len(s.tokens) > 0 {
+		h = s.authenticate(h)
+	}
+	return logRequests(h)
+}
+
+// ListenAndServe serves on addr until ctx is cancelled, then shuts down
+// gracefully, giving in-flight requests time to finish.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("API server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down API server: %w", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.PingContext(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "database unavailable")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s (%v)", r.Method, r.URL.RequestURI(), time.Since(start).Round(time.Millisecond))
+	})
+}
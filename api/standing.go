@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/models"
+)
+
+// handleStanding reports how a candidate's aggregate compares with the
+// year's candidates nationally, in their state and for their course.
+//
+//	GET /api/candidates/standing?regnumber=12345678AB&year=2023
+//
+// year defaults to the candidate's own year.
+func (s *Server) handleStanding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	regNumber := strings.ToUpper(strings.TrimSpace(q.Get("regnumber")))
+	if regNumber == "" {
+		writeError(w, http.StatusBadRequest, "regnumber is required")
+		return
+	}
+	year := 0
+	if raw := q.Get("year"); raw != "" {
+		var err error
+		if year, err = strconv.Atoi(raw); err != nil {
+			writeError(w, http.StatusBadRequest, "year must be an integer")
+			return
+		}
+	}
+
+	standing, err := models.NewCandidateRepository(s.db).Standing(r.Context(), regNumber, year)
+	switch {
+	case errors.Is(err, models.ErrCandidateNotFound):
+		writeError(w, http.StatusNotFound, "no candidate with that registration number")
+	case errors.Is(err, models.ErrNoAggregate):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, models.ErrOtherYear):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		log.Printf("Error ranking candidate %s: %v", regNumber, err)
+		writeError(w, http.StatusInternalServerError, "error ranking candidate")
+	default:
+		writeJSON(w, http.StatusOK, standing)
+	}
+}
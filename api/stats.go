@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// yearClause returns a WHERE condition restricting alias to the request's
+// year parameter, if any, with its argument.
+func yearClause(r *http.Request, alias string) (string, []interface{}, error) {
+	raw := r.URL.Query().Get("year")
+	if raw == "" {
+		return "TRUE", nil, nil
+	}
+	year, err := strconv.Atoi(raw)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid year %q", raw)
+	}
+	return alias + ".year = $1", []interface{}{year}, nil
+}
+
+// queryAggregate runs an aggregate query filtered by the year parameter and
+// writes the result set
+func (s *Server) queryAggregate(w http.ResponseWriter, r *http.Request, name, query string) {
+	where, args, err := yearClause(r, "c")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(query, where), args...)
+	if err != nil {
+		log.Printf("Error getting %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "error getting "+name)
+		return
+	}
+	defer rows.Close()
+
+	if err := writeRows(w, r, rows); err != nil {
+		log.Printf("Error writing %s: %v", name, err)
+		// Keeps the partial body out of the response cache
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GET /api/stats/gender?year=2023
+func (s *Server) handleGenderStats(w http.ResponseWriter, r *http.Request) {
+	s.queryAggregate(w, r, "gender stats", `
+        SELECT c.gender, COUNT(*) AS count,
+               ROUND(100.0 * COUNT(*) / SUM(COUNT(*)) OVER (), 2) AS share_pct
+        FROM candidate c
+        WHERE c.gender IS NOT NULL AND %s
+        GROUP BY c.gender
+        ORDER BY c.gender`)
+}
+
+// GET /api/stats/states?year=2023&cumulative=true
+//
+// cumulative adds the running share of candidates down the list
+func (s *Server) handleStateDistribution(w http.ResponseWriter, r *http.Request) {
+	cumulative := ""
+	if ok, _ := strconv.ParseBool(r.URL.Query().Get("cumulative")); ok {
+		cumulative = `,
+               ROUND(100.0 * SUM(COUNT(*)) OVER (ORDER BY COUNT(*) DESC, s.st_name ROWS UNBOUNDED PRECEDING)
+                     / SUM(COUNT(*)) OVER (), 2) AS cumulative_pct`
+	}
+	s.queryAggregate(w, r, "state distribution", `
+        SELECT s.st_name AS state, COUNT(*) AS count,
+               ROUND(100.0 * COUNT(*) / SUM(COUNT(*)) OVER (), 2) AS share_pct`+cumulative+`
+        FROM candidate c
+        JOIN state s ON c.statecode = s.st_id
+        WHERE %s
+        GROUP BY s.st_name
+        ORDER BY count DESC, s.st_name`)
+}
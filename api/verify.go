@@ -0,0 +1,206 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/models"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultVerifyRateLimit is how many applicants a client may verify a
+	// minute
+	DefaultVerifyRateLimit = 120
+	// maxVerifyBatch bounds the applicants in one request
+	maxVerifyBatch = 100
+	// verifyClientIdle is how long a quiet client's allowance is kept
+	verifyClientIdle = 10 * time.Minute
+)
+
+// verifyLimiter allows each client, known by its API token or else its
+// address, a number of verifications a minute, a batch counting each
+// applicant
+type verifyLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	clients   map[string]*verifyClient
+}
+
+type verifyClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newVerifyLimiter(perMinute int) *verifyLimiter {
+	return &verifyLimiter{perMinute: perMinute, clients: make(map[string]*verifyClient)}
+}
+
+// maxBatch is the most applicants a request may hold
+func (l *verifyLimiter) maxBatch() int {
+	return min(maxVerifyBatch, l.perMinute)
+}
+
+// allow takes n verifications from the client's allowance, or returns how
+// long to wait before the request can be made
+func (l *verifyLimiter) allow(client string, n int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) > verifyClientIdle {
+			delete(l.clients, key)
+		}
+	}
+	c, ok := l.clients[client]
+	if !ok {
+		c = &verifyClient{limiter: rate.NewLimiter(rate.Limit(float64(l.perMinute)/60), l.perMinute)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	reservation := c.limiter.ReserveN(now, n)
+	if !reservation.OK() {
+		return false, time.Minute
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// NewVerifyServer serves only applicant verification, so institutions can
+// be given it without reaching the candidate records the full API serves
+func NewVerifyServer(db *sql.DB) *Server {
+	s := &Server{
+		db:     db,
+		mux:    http.NewServeMux(),
+		cache:  NewResponseCache(),
+		verify: newVerifyLimiter(DefaultVerifyRateLimit),
+	}
+	s.mux.HandleFunc("/api/health", s.handleHealth)
+	s.mux.HandleFunc("/api/verify", s.handleVerify)
+	return s
+}
+
+// SetVerifyRateLimit sets how many applicants a client may verify a minute
+func (s *Server) SetVerifyRateLimit(perMinute int) {
+	s.verify = newVerifyLimiter(perMinute)
+}
+
+// verifyApplicant is one applicant of a verification request
+type verifyApplicant struct {
+	RegNumber   string `json:"regnumber"`
+	Surname     string `json:"surname"`
+	DateOfBirth string `json:"date_of_birth"`
+}
+
+// handleVerify lets institutions confirm an applicant's registration
+// without seeing their record. Only whether the registration number,
+// surname and date of birth match a candidate is returned, with the
+// candidate's year and admission status.
+//
+//	GET  /api/verify?regnumber=12345678AB&surname=Okafor&date_of_birth=2005-03-14
+//	POST /api/verify {"applicants": [{"regnumber": ..., "surname": ..., "date_of_birth": ...}, ...]}
+//
+// A POST verifies up to 100 applicants, each counting against the client's
+// rate limit.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var applicants []verifyApplicant
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		applicants = []verifyApplicant{{RegNumber: q.Get("regnumber"), Surname: q.Get("surname"), DateOfBirth: q.Get("date_of_birth")}}
+	case http.MethodPost:
+		var body struct {
+			Applicants []verifyApplicant `json:"applicants"`
+		}
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+		applicants = body.Applicants
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if len(applicants) == 0 {
+		writeError(w, http.StatusBadRequest, "applicants is empty")
+		return
+	}
+	if limit := s.verify.maxBatch(); len(applicants) > limit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d applicants can be verified at once", limit))
+		return
+	}
+
+	queries := make([]models.VerificationQuery, len(applicants))
+	for i, a := range applicants {
+		q, err := a.query()
+		if err != nil {
+			if r.Method == http.MethodPost {
+				err = fmt.Errorf("applicant %d: %w", i+1, err)
+			}
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		queries[i] = q
+	}
+
+	// Rate limited after validation, so a malformed request costs nothing
+	if ok, wait := s.verify.allow(verifyClientKey(r), len(queries)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "verification rate limit exceeded")
+		return
+	}
+
+	results, err := models.NewCandidateRepository(s.db).Verify(r.Context(), queries)
+	if err != nil {
+		log.Printf("Error verifying applicants: %v", err)
+		writeError(w, http.StatusInternalServerError, "error verifying applicants")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, results[0])
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+func (a verifyApplicant) query() (models.VerificationQuery, error) {
+	q := models.VerificationQuery{RegNumber: strings.TrimSpace(a.RegNumber), Surname: strings.TrimSpace(a.Surname)}
+	if q.RegNumber == "" || q.Surname == "" || a.DateOfBirth == "" {
+		return q, fmt.Errorf("regnumber, surname and date_of_birth are required")
+	}
+	dob, err := time.Parse("2006-01-02", strings.TrimSpace(a.DateOfBirth))
+	if err != nil {
+		return q, fmt.Errorf("date_of_birth must be a date such as 2005-03-14")
+	}
+	q.DateOfBirth = dob
+	return q, nil
+}
+
+// verifyClientKey identifies the client for rate limiting: the user its
+// API token names, or the connecting address. X-User is not used, as an
+// unauthenticated client could vary it to escape the limit.
+func verifyClientKey(r *http.Request) string {
+	if user := authenticatedUser(r); user != "" {
+		return "user:" + user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyLimiterMaxBatch(t *testing.T) {
+	tests := []struct {
+		perMinute, want int
+	}{
+		{20, 20},
+		{maxVerifyBatch, maxVerifyBatch},
+		{DefaultVerifyRateLimit, maxVerifyBatch},
+	}
+	for _, tt := range tests {
+		if got := newVerifyLimiter(tt.perMinute).maxBatch(); got != tt.want {
+			t.Errorf("maxBatch with %d a minute = %d, want %d", tt.perMinute, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyLimiterAllow(t *testing.T) {
+	l := newVerifyLimiter(60)
+	steps := []struct {
+		client string
+		n      int
+		ok     bool
+		wait   time.Duration // least delay expected when refused
+	}{
+		{"addr:203.0.113.5", 50, true, 0},
+		{"addr:203.0.113.5", 10, true, 0},
+		// The allowance refills at one a second, so a batch of 20 waits
+		{"addr:203.0.113.5", 20, false, 15 * time.Second},
+		// A refused request takes nothing, so a smaller one still waits less
+		{"addr:203.0.113.5", 1, false, 0},
+		{"addr:198.51.100.7", 60, true, 0},
+		// More than a minute's allowance can never be granted
+		{"user:dashboard", 61, false, time.Minute},
+	}
+	for i, s := range steps {
+		ok, wait := l.allow(s.client, s.n)
+		if ok != s.ok {
+			t.Fatalf("step %d: allow(%s, %d) = %v, want %v", i, s.client, s.n, ok, s.ok)
+		}
+		if ok && wait != 0 {
+			t.Errorf("step %d: allowed with wait %v", i, wait)
+		}
+		if !ok && (wait <= 0 || wait < s.wait) {
+			t.Errorf("step %d: refused with wait %v, want at least %v", i, wait, s.wait)
+		}
+	}
+}
+
+func TestVerifyLimiterForgetsIdleClients(t *testing.T) {
+	l := newVerifyLimiter(60)
+	l.allow("addr:203.0.113.5", 60)
+	l.clients["addr:203.0.113.5"].lastSeen = time.Now().Add(-verifyClientIdle - time.Second)
+
+	l.allow("addr:198.51.100.7", 1)
+	if _, kept := l.clients["addr:203.0.113.5"]; kept {
+		t.Error("idle client was kept")
+	}
+	if ok, _ := l.allow("addr:203.0.113.5", 60); !ok {
+		t.Error("forgotten client did not get a fresh allowance")
+	}
+}
+
+func TestVerifyClientKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string // X-User sent by the client
+		user       string // user the API token named
+		want       string
+	}{
+		{"address", "203.0.113.5:52100", "", "", "addr:203.0.113.5"},
+		{"X-User is ignored", "203.0.113.5:52100", "someone-else", "", "addr:203.0.113.5"},
+		{"IPv6 address", "[2001:db8::1]:443", "", "", "addr:2001:db8::1"},
+		{"address without port", "203.0.113.5", "", "", "addr:203.0.113.5"},
+		{"token user", "203.0.113.5:52100", "someone-else", "dashboard", "user:dashboard"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/verify", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.header != "" {
+			r.Header.Set(userHeader, tt.header)
+		}
+		if tt.user != "" {
+			r = r.WithContext(context.WithValue(r.Context(), authUserKey{}, tt.user))
+		}
+		if got := verifyClientKey(r); got != tt.want {
+			t.Errorf("%s: verifyClientKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
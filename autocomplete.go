@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/nonsonwune/spk2_db/filter"
+)
+
+// Entity kinds that prompts can complete, with the query listing their names
+const (
+	entityState       = "SELECT st_name FROM state WHERE st_name IS NOT NULL ORDER BY 1"
+	entityLGA         = "SELECT DISTINCT lg_name FROM lga WHERE lg_name IS NOT NULL ORDER BY 1"
+	entityCourse      = "SELECT DISTINCT course_name FROM course WHERE course_name IS NOT NULL ORDER BY 1"
+	entityInstitution = "SELECT inname FROM institution WHERE inname IS NOT NULL ORDER BY 1"
+)
+
+// filterValues lists the values offered after "field=" in filter expressions
+var filterValues = map[string]string{
+	"state":       entityState,
+	"lga":         entityLGA,
+	"course":      "SELECT DISTINCT course_code FROM course WHERE course_code IS NOT NULL ORDER BY 1",
+	"institution": "SELECT inid FROM institution WHERE inid IS NOT NULL ORDER BY 1",
+	"tag":         tagNames,
+}
+
+// entityCache holds reference names per query; the tables are small and
+// rarely change while the menu is open
+var entityCache = map[string][]string{}
+
+func entityNames(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	if names, ok := entityCache[query]; ok {
+		return names, nil
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error loading names for completion: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	entityCache[query] = names
+	return names, nil
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// readCompleted prints prompt and reads a line with Tab completion when
+// stdin is a terminal, falling back to a plain read for piped input
+func readCompleted(prompt string, completer readline.AutoCompleter) string {
+	if !stdinIsTerminal() {
+		fmt.Print(prompt)
+		return readString()
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:                 prompt,
+		AutoComplete:           completer,
+		DisableAutoSaveHistory: true,
+	})
+	if err != nil {
+		fmt.Print(prompt)
+		return readString()
+	}
+	defer rl.Close()
+
+	line, err := rl.Readline()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(line)
+}
+
+// readEntity prompts for a state, LGA, course or institution name with Tab
+// completion from the reference table named by query. Input that isn't an
+// exact name is matched fuzzily and the user picks from the closest names or
+// keeps what they typed.
+func readEntity(ctx context.Context, db *sql.DB, prompt, query string) (string, error) {
+	names, err := entityNames(ctx, db, query)
+	if err != nil {
+		return "", err
+	}
+	input := readCompleted(prompt, &nameCompleter{names: names})
+	if input == "" {
+		return "", nil
+	}
+
+	matches := rankNames(names, input, 5)
+	if len(matches) > 0 && strings.EqualFold(matches[0], input) {
+		return matches[0], nil
+	}
+	if len(matches) == 0 {
+		return input, nil
+	}
+
+	fmt.Printf("%q is not an exact match. Did you mean:\n", input)
+	for i, name := range matches {
+		fmt.Printf("%d. %s\n", i+1, name)
+	}
+	fmt.Printf("0. Keep %q\n", input)
+	fmt.Print("Choice [1]: ")
+	choice := readString()
+	if choice == "" {
+		return matches[0], nil
+	}
+	n, err := strconv.Atoi(choice)
+	if err != nil || n < 0 || n > len(matches) {
+		return "", fmt.Errorf("invalid choice")
+	}
+	if n == 0 {
+		return input, nil
+	}
+	return matches[n-1], nil
+}
+
+// rankNames returns up to limit names resembling input, best first: exact,
+// prefix, word prefix, substring, subsequence, then small typos
+func rankNames(names []string, input string, limit int) []string {
+	input = strings.ToUpper(strings.TrimSpace(input))
+	type scored struct {
+		name  string
+		score int
+	}
+	var found []scored
+	for _, name := range names {
+		upper := strings.ToUpper(name)
+		score := -1
+		switch {
+		case upper == input:
+			score = 0
+		case strings.HasPrefix(upper, input):
+			score = 1
+		case strings.Contains(" "+upper, " "+input):
+			score = 2
+		case strings.Contains(upper, input):
+			score = 3
+		case isSubsequence(input, upper):
+			score = 4
+		default:
+			if d := editDistance(input, upper); d <= 2 && d < len(input) {
+				score = 4 + d
+			}
+		}
+		if score >= 0 {
+			found = append(found, scored{name, score})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].score < found[j].score })
+
+	var result []string
+	for i := 0; i < len(found) && i < limit; i++ {
+		result = append(result, found[i].name)
+	}
+	return result
+}
+
+func isSubsequence(needle, haystack string) bool {
+	i := 0
+	for _, r := range haystack {
+		if i < len(needle) && rune(needle[i]) == r {
+			i++
+		}
+	}
+	return i == len(needle)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// nameCompleter completes the whole line against a list of names,
+// ignoring case
+type nameCompleter struct {
+	names []string
+}
+
+func (nc *nameCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	typed := string(line[:pos])
+	var candidates []string
+	for _, name := range nc.names {
+		if len(name) >= len(typed) && strings.EqualFold(name[:len(typed)], typed) {
+			candidates = append(candidates, name[len(typed):])
+		}
+	}
+	return toRunes(candidates), len([]rune(typed))
+}
+
+var (
+	filterKeywords   = []string{"AND", "OR", "NOT", "IN"}
+	filterValueField = regexp.MustCompile(`(?i)(\w+)\s*(?:!=|=|\s+IN\s*\([^)]*)$`)
+)
+
+// filterCompleter completes field names and keywords in filter expressions
+// and, after state=, lga=, course=, institution= or tag=, the known values
+type filterCompleter struct {
+	ctx context.Context
+	db  *sql.DB
+}
+
+func (fc *filterCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	start := pos
+	for start > 0 && !strings.ContainsRune(" (,=<>!", line[start-1]) {
+		start--
+	}
+	word := string(line[start:pos])
+	before := strings.TrimRight(string(line[:start]), " ")
+
+	if m := filterValueField.FindStringSubmatch(before); m != nil {
+		query, ok := filterValues[strings.ToLower(m[1])]
+		if !ok {
+			return nil, 0
+		}
+		values, err := entityNames(fc.ctx, fc.db, query)
+		if err != nil {
+			return nil, 0
+		}
+		return completeFilterValue(values, word), len([]rune(word))
+	}
+
+	var candidates []string
+	for _, name := range append(filter.FieldNames(), filterKeywords...) {
+		if len(name) > len(word) && strings.EqualFold(name[:len(word)], word) {
+			candidates = append(candidates, name[len(word):]+" ")
+		}
+	}
+	return toRunes(candidates), len([]rune(word))
+}
+
+// completeFilterValue offers values starting with word. Values containing
+// spaces must be quoted, so they are only offered once a quote is typed.
+func completeFilterValue(values []string, word string) [][]rune {
+	quote := ""
+	prefix := word
+	if strings.HasPrefix(word, "'") || strings.HasPrefix(word, `"`) {
+		quote, prefix = word[:1], word[1:]
+	}
+	var candidates []string
+	for _, v := range values {
+		if quote == "" && strings.Contains(v, " ") {
+			continue
+		}
+		if len(v) >= len(prefix) && strings.EqualFold(v[:len(prefix)], prefix) {
+			candidates = append(candidates, v[len(prefix):]+quote)
+		}
+	}
+	return toRunes(candidates)
+}
+
+// readFilter prompts for a filter expression with completion
+func readFilter(ctx context.Context, db *sql.DB, prompt string) string {
+	return readCompleted(prompt, &filterCompleter{ctx: ctx, db: db})
+}
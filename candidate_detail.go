@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/models"
+)
+
+// handleViewCandidate shows everything recorded about one candidate
+func handleViewCandidate(ctx context.Context, db *sql.DB) error {
+	if publicOutput != nil {
+		return fmt.Errorf("candidate records are not shown while public output mode is on")
+	}
+	fmt.Print("Registration number: ")
+	regNumber := strings.ToUpper(readString())
+	if regNumber == "" {
+		return fmt.Errorf("registration number is required")
+	}
+
+	c, err := models.NewCandidateRepository(db).Load(ctx, regNumber)
+	if errors.Is(err, models.ErrCandidateNotFound) {
+		color.Yellow("No candidate with registration number %s", regNumber)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	noteRows, err := candidateNoteRows(ctx, db, c)
+	if err != nil {
+		return err
+	}
+	table := newResultTable("candidate-" + c.RegNumber)
+	table.SetHeader([]string{"Field", "Value"})
+	for _, row := range append(candidateDetailRows(c), noteRows...) {
+		table.Append([]string{row[0].(string), fmt.Sprint(row[1])})
+	}
+	table.Render()
+	return nil
+}
+
+// runCandidate is the scriptable form of the View Candidate menu item
+func runCandidate(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("candidate")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{errors.New("candidate needs one registration number")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	c, err := models.NewCandidateRepository(db).Load(ctx, strings.ToUpper(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+	noteRows, err := candidateNoteRows(ctx, db, c)
+	if err != nil {
+		return err
+	}
+	return writeResult("candidate-"+c.RegNumber, []string{"field", "value"}, append(candidateDetailRows(c), noteRows...), *format, *output)
+}
+
+// candidateDetailRows lays a loaded candidate out as field/value rows, one
+// row per subject score after the candidate's own details
+func candidateDetailRows(c *models.Candidate) [][]interface{} {
+	var rows [][]interface{}
+	add := func(field string, value interface{}) {
+		rows = append(rows, []interface{}{field, value})
+	}
+	yesNo := func(b bool) string {
+		if b {
+			return "Yes"
+		}
+		return "No"
+	}
+
+	add("Reg Number", c.RegNumber)
+	add("Year", c.Year)
+	add("Name", strings.Join(strings.Fields(strings.Join([]string{c.Surname.String, c.FirstName.String, c.MiddleName.String}, " ")), " "))
+	add("Gender", c.Gender.String)
+	if c.DateOfBirth.Valid {
+		add("Date of Birth", c.DateOfBirth.Time.Format("2006-01-02"))
+	} else {
+		add("Date of Birth", "")
+	}
+	add("Marital Status", c.MaritalStatus.String)
+	add("Address", c.Address.String)
+	add("Email", c.Email.String)
+	add("Phone", c.GSMNo.String)
+
+	state := ""
+	if c.State != nil {
+		state = c.State.Name
+	} else if c.StateCode.Valid {
+		state = fmt.Sprintf("unknown state %d", c.StateCode.Int64)
+	}
+	add("State", state)
+	lga := ""
+	if c.LGA != nil {
+		lga = c.LGA.Name
+	} else if c.LGID.Valid {
+		lga = fmt.Sprintf("unknown LGA %d", c.LGID.Int64)
+	}
+	add("LGA", lga)
+
+	institution := c.InID.String
+	if c.Institution != nil {
+		institution = fmt.Sprintf("%s (%s)", c.Institution.InName, c.Institution.InID)
+	}
+	add("Institution", institution)
+	course := c.AppCourse1.String
+	if c.Course != nil {
+		course = fmt.Sprintf("%s (%s)", c.Course.CourseName, c.Course.CourseCode)
+	}
+	add("Course", course)
+
+	aggregate := ""
+	if c.Aggregate.Valid {
+		aggregate = strconv.FormatInt(c.Aggregate.Int64, 10)
+	}
+	add("Aggregate", aggregate)
+	sittings := ""
+	if c.NoOfSittings.Valid {
+		sittings = strconv.FormatInt(c.NoOfSittings.Int64, 10)
+	}
+	add("Sittings", sittings)
+	add("Admitted", yesNo(c.IsAdmitted.Bool))
+	add("Direct Entry", yesNo(c.IsDirectEntry.Bool))
+	add("Malpractice", c.Malpractice.String)
+
+	if e := c.ExamInfo; e != nil {
+		add("Exam Town", e.ExamTown)
+		add("Exam Centre", e.ExamCentre)
+		add("Exam Number", e.ExamNumber)
+		add("Mock Candidate", yesNo(e.IsMockCandidate))
+		if e.IsMockCandidate {
+			add("Mock Town", e.MockTown)
+		}
+	}
+	if d := c.Disabilities; d != nil {
+		add("Blind", yesNo(d.IsBlind))
+		add("Deaf", yesNo(d.IsDeaf))
+		add("Other Challenges", d.OtherChallenges)
+	}
+
+	if len(c.Scores) == 0 {
+		add("Scores", "none recorded")
+	}
+	for _, score := range c.Scores {
+		add("Score: "+score.Subject.Name, score.Score)
+	}
+	return rows
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dbconn "github.com/nonsonwune/spk2_db/db"
+)
+
+// candidateList is a set of registration numbers loaded from a file that
+// scopes the session working set by joining on a table rather than
+// expanding into an IN clause.
+type candidateList struct {
+	file   string
+	table  string
+	loaded int64
+}
+
+// regNumberHeaders are first-row values recognised as a header rather than data
+var regNumberHeaders = map[string]bool{
+	"regnumber": true, "reg_number": true, "reg number": true, "regno": true,
+	"rg_num": true, "cand_reg_number": true,
+}
+
+// readRegNumbers reads registration numbers from a TXT file (one per line)
+// or a CSV file (a regnumber column if there is a header, otherwise the
+// first column). Blank lines and duplicates are skipped.
+func readRegNumbers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening list: %w", err)
+	}
+	defer f.Close()
+
+	var values []string
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		reader := csv.NewReader(bufio.NewReader(f))
+		reader.FieldsPerRecord = -1
+		column := 0
+		for first := true; ; first = false {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error reading list: %w", err)
+			}
+			if first {
+				if i := regNumberColumn(record); i >= 0 {
+					column = i
+					continue
+				}
+			}
+			if column < len(record) {
+				values = append(values, record[column])
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(f)
+		for first := true; scanner.Scan(); first = false {
+			line := scanner.Text()
+			if first && regNumberHeaders[strings.ToLower(strings.TrimSpace(line))] {
+				continue
+			}
+			values = append(values, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading list: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool, len(values))
+	regs := make([]string, 0, len(values))
+	for _, v := range values {
+		v = strings.ToUpper(strings.TrimSpace(v))
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		regs = append(regs, v)
+	}
+	if len(regs) == 0 {
+		return nil, fmt.Errorf("%s contains no registration numbers", path)
+	}
+	return regs, nil
+}
+
+func regNumberColumn(header []string) int {
+	for i, h := range header {
+		if regNumberHeaders[strings.ToLower(strings.TrimSpace(h))] {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadCandidateList copies regs into a fresh UNLOGGED table with COPY
+func loadCandidateList(ctx context.Context, db *sql.DB, table string, regs []string) error {
+	if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(
+		`CREATE UNLOGGED TABLE %s (regnumber VARCHAR(20) PRIMARY KEY)`, table)); err != nil {
+		return fmt.Errorf("error creating list table: %w", err)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows := make([][]interface{}, len(regs))
+	for i, reg := range regs {
+		rows[i] = []interface{}{reg}
+	}
+	if err := dbconn.CopyIn(ctx, conn, tx, table, []string{"regnumber"}, rows); err != nil {
+		return fmt.Errorf("error loading list: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, "ANALYZE "+table)
+	return err
+}
+
+// bulkUpdateFields are the candidate columns that may be set for every
+// candidate in the working set
+var bulkUpdateFields = map[string]string{
+	"is_admitted":       "bool",
+	"is_direct_entry":   "bool",
+	"is_mock_candidate": "bool",
+	"malpractice":       "text",
+}
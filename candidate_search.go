@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/search"
+)
+
+// searchCandidates finds candidates by name or registration number, with
+// optional filters and sorting, and pages through the matches
+func searchCandidates(ctx context.Context, db *sql.DB) error {
+	var criteria search.Criteria
+	fmt.Print("Registration number or name to search (blank for any): ")
+	criteria.Term = readString()
+
+	fmt.Print("Add filters and sorting? (y/n): ")
+	opts := search.Options{}
+	if strings.ToLower(readString()) == "y" {
+		if err := readSearchFilters(ctx, db, &criteria, &opts); err != nil {
+			return err
+		}
+	}
+
+	for {
+		result, err := search.Run(ctx, db, criteria, opts)
+		if err != nil {
+			return err
+		}
+		if result.Total == 0 {
+			color.Yellow("No candidates match")
+			return nil
+		}
+
+		table := newResultTable("candidate-search")
+		table.SetHeader([]string{"Reg Number", "Surname", "First Name", "Gender", "Year", "Aggregate", "State", "LGA", "Course", "Admitted"})
+		for _, c := range result.Candidates {
+			aggregate := ""
+			if c.Aggregate != nil {
+				aggregate = strconv.Itoa(*c.Aggregate)
+			}
+			admitted := "No"
+			if c.Admitted {
+				admitted = "Yes"
+			}
+			table.Append([]string{c.RegNumber, c.Surname, c.FirstName, c.Gender, strconv.Itoa(c.Year),
+				aggregate, c.State, c.LGA, c.Course, admitted})
+		}
+		table.Render()
+		fmt.Printf("Page %d of %d (%d candidates)\n", result.Page, result.Pages(), result.Total)
+		if result.Pages() == 1 {
+			return nil
+		}
+
+		fmt.Print("[n]ext page, [p]revious page, a page number, or Enter to finish: ")
+		switch input := strings.ToLower(readString()); {
+		case input == "":
+			return nil
+		case input == "n":
+			if !result.HasNext() {
+				color.Yellow("This is the last page")
+			} else {
+				opts.Page++
+			}
+		case input == "p":
+			if opts.Page <= 1 {
+				color.Yellow("This is the first page")
+			} else {
+				opts.Page--
+			}
+		default:
+			page, err := strconv.Atoi(input)
+			if err != nil || page < 1 || page > result.Pages() {
+				color.Red("Enter a page between 1 and %d", result.Pages())
+				continue
+			}
+			opts.Page = page
+		}
+	}
+}
+
+// readSearchFilters prompts for the search filters; blank answers leave a
+// filter unset
+func readSearchFilters(ctx context.Context, db *sql.DB, criteria *search.Criteria, opts *search.Options) error {
+	var err error
+	fmt.Print("Year (blank for all): ")
+	if input := readString(); input != "" {
+		if criteria.Year, err = strconv.Atoi(input); err != nil {
+			return fmt.Errorf("invalid year %q", input)
+		}
+	}
+	if criteria.State, err = readEntity(ctx, db, "State (blank for all): ", entityState); err != nil {
+		return err
+	}
+	if criteria.LGA, err = readEntity(ctx, db, "LGA (blank for all): ", entityLGA); err != nil {
+		return err
+	}
+	fmt.Print("Gender (M/F, blank for both): ")
+	criteria.Gender = readString()
+	if criteria.Course, err = readEntity(ctx, db, "First choice course (blank for all): ", entityCourse); err != nil {
+		return err
+	}
+	if criteria.MinScore, err = readOptionalInt("Minimum aggregate (blank for none): "); err != nil {
+		return err
+	}
+	if criteria.MaxScore, err = readOptionalInt("Maximum aggregate (blank for none): "); err != nil {
+		return err
+	}
+	fmt.Print("Admitted? (y/n, blank for either): ")
+	switch strings.ToLower(readString()) {
+	case "y":
+		admitted := true
+		criteria.Admitted = &admitted
+	case "n":
+		admitted := false
+		criteria.Admitted = &admitted
+	}
+	if input := readFilter(ctx, db, "Other filter, e.g. sittings=1 AND direct_entry=false (blank for none): "); input != "" {
+		if criteria.Filter, err = filter.Parse(input); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	fmt.Printf("Sort by (%s) [regnumber]: ", strings.Join(search.SortFields(), ", "))
+	opts.Sort = strings.ToLower(readString())
+	fmt.Print("Descending order? (y/n): ")
+	opts.Desc = strings.ToLower(readString()) == "y"
+	fmt.Printf("Candidates per page [%d]: ", search.DefaultPageSize)
+	if input := readString(); input != "" {
+		if opts.PageSize, err = strconv.Atoi(input); err != nil {
+			return fmt.Errorf("invalid page size %q", input)
+		}
+	}
+	return opts.Validate()
+}
+
+func readOptionalInt(prompt string) (*int, error) {
+	fmt.Print(prompt)
+	input := readString()
+	if input == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", input)
+	}
+	return &n, nil
+}
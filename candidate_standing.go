@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/models"
+)
+
+var standingHeader = []string{"level", "group", "candidates", "rank", "percentile", "z_score", "mean", "stddev"}
+
+func standingRows(s *models.Standing) [][]interface{} {
+	rows := make([][]interface{}, len(s.Levels))
+	for i, l := range s.Levels {
+		var z interface{}
+		if l.ZScore != nil {
+			z = fmt.Sprintf("%+.2f", *l.ZScore)
+		}
+		rows[i] = []interface{}{l.Scope, l.Group, l.Candidates, l.Rank,
+			fmt.Sprintf("%.1f", l.Percentile), z, fmt.Sprintf("%.1f", l.Mean), fmt.Sprintf("%.1f", l.StdDev)}
+	}
+	return rows
+}
+
+// handleCandidateStanding answers how a candidate compares with the rest
+// of their year, nationally, in their state and for their course
+func handleCandidateStanding(ctx context.Context, db *sql.DB) error {
+	if publicOutput != nil {
+		return fmt.Errorf("candidate records are not shown while public output mode is on")
+	}
+	color.Cyan("\nCandidate Standing")
+	fmt.Print("Registration number: ")
+	regNumber := strings.ToUpper(readString())
+	if regNumber == "" {
+		return fmt.Errorf("registration number is required")
+	}
+	fmt.Print("Year (blank for the candidate's own year): ")
+	year := readInt()
+
+	s, err := models.NewCandidateRepository(db).Standing(ctx, regNumber, year)
+	if errors.Is(err, models.ErrCandidateNotFound) {
+		color.Yellow("No candidate with registration number %s", regNumber)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	color.Yellow("\n%s scored %d in %d", s.RegNumber, s.Aggregate, s.Year)
+	showResult("standing-"+s.RegNumber, standingHeader, standingRows(s), false)
+	for _, l := range s.Levels {
+		fmt.Printf("Scored higher than %.1f%% of %s candidates\n", l.Percentile, describeLevel(l))
+	}
+	return nil
+}
+
+func describeLevel(l models.StandingLevel) string {
+	switch l.Scope {
+	case models.ScopeState:
+		return "the " + l.Group + " state"
+	case models.ScopeCourse:
+		return "the " + l.Group + " course"
+	}
+	return "all"
+}
+
+// runStanding is the scriptable form of the candidate standing
+func runStanding(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("standing")
+	year := fs.Int("year", 0, "compare with this year's candidates (default the candidate's own year)")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{errors.New("standing needs one registration number")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	s, err := models.NewCandidateRepository(db).Standing(ctx, strings.ToUpper(fs.Arg(0)), *year)
+	if err != nil {
+		return err
+	}
+	return writeResult("standing-"+s.RegNumber, standingHeader, standingRows(s), *format, *output)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/admission"
+	"github.com/nonsonwune/spk2_db/filter"
+)
+
+// handleCAPSExport writes the admission decisions recorded locally in the
+// CAPS upload layout, with the candidates whose records break its field
+// rules listed separately
+func handleCAPSExport(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nCAPS Admission Upload File")
+	fmt.Print("Year: ")
+	year := readInt()
+	if year == 0 {
+		return fmt.Errorf("a year is required")
+	}
+	fmt.Print("Institution code (blank for every institution): ")
+	institution := readString()
+	var expr *filter.Filter
+	if input := readFilter(ctx, db, "Filter (optional): "); input != "" {
+		var err error
+		if expr, err = filter.Parse(input); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	export, err := admission.CAPS(ctx, db, year, institution, currentSession.CandidateSource(), expr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Admitted candidates: %d\n", len(export.Rows)+len(export.Rejected))
+	fmt.Printf("Valid for upload: %d\n", len(export.Rows))
+	if len(export.Rejected) > 0 {
+		color.Yellow("Breaking CAPS field rules: %d", len(export.Rejected))
+		table := newResultTable("caps-rejections")
+		table.SetHeader([]string{"Reg Number", "Problems"})
+		for i, r := range export.Rejected {
+			if i == discrepancyPreview {
+				break
+			}
+			table.Append([]string{r.RegNumber, strings.Join(r.Problems, "; ")})
+		}
+		table.Render()
+		if len(export.Rejected) > discrepancyPreview {
+			fmt.Printf("... and %d more\n", len(export.Rejected)-discrepancyPreview)
+		}
+	}
+	if len(export.Rows) == 0 {
+		color.Yellow("No admission decisions to upload")
+		return nil
+	}
+
+	fmt.Printf("Upload file [caps-%d.csv]: ", year)
+	path := readString()
+	if path == "" {
+		path = fmt.Sprintf("caps-%d.csv", year)
+	}
+	if err := writeCAPSFile(path, export.WriteCSV); err != nil {
+		return err
+	}
+	color.Green("Wrote %d admission decisions to %s", len(export.Rows), path)
+
+	if len(export.Rejected) > 0 {
+		fmt.Print("Save the rejected candidates to CSV (blank to skip): ")
+		if path := readString(); path != "" {
+			if err := writeCAPSFile(path, export.WriteRejections); err != nil {
+				return err
+			}
+			color.Green("Wrote %d rejected candidates to %s", len(export.Rejected), path)
+		}
+	}
+	return nil
+}
+
+func writeCAPSFile(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// runCAPS is the scriptable form of menu item 44
+func runCAPS(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("caps")
+	year := fs.Int("year", 0, "year of the admission decisions (required)")
+	institution := fs.String("institution", "", "only this institution's decisions")
+	filterText := fs.String("filter", "", "only admitted candidates matching this filter")
+	output := fs.String("o", "", "write the upload file here instead of stdout")
+	rejects := fs.String("rejects", "", "write candidates breaking the CAPS field rules to this CSV file")
+	strict := fs.Bool("strict", false, "fail without writing the upload file if any candidate breaks the field rules")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *year == 0 || fs.NArg() > 0 {
+		return usageError{errors.New("caps needs -year and no other arguments")}
+	}
+	expr, err := parseFilter(*filterText)
+	if err != nil {
+		return err
+	}
+
+	export, err := admission.CAPS(ctx, db, *year, *institution, "candidate", expr)
+	if err != nil {
+		return err
+	}
+	if *rejects != "" {
+		if err := writeCAPSFile(*rejects, export.WriteRejections); err != nil {
+			return err
+		}
+	}
+	if len(export.Rejected) > 0 {
+		fmt.Fprintf(os.Stderr, "%d admitted candidates break the CAPS field rules and were left out\n", len(export.Rejected))
+		if *strict {
+			return fmt.Errorf("%d candidates break the CAPS field rules; see -rejects", len(export.Rejected))
+		}
+	}
+	if *output == "" {
+		return export.WriteCSV(os.Stdout)
+	}
+	if err := writeCAPSFile(*output, export.WriteCSV); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d admission decisions to %s\n", len(export.Rows), *output)
+	return nil
+}
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/importer"
+	"github.com/nonsonwune/spk2_db/nlquery"
+	"github.com/nonsonwune/spk2_db/privacy"
+	"github.com/nonsonwune/spk2_db/reports"
+	"github.com/nonsonwune/spk2_db/search"
+)
+
+// command is a spk2 subcommand. Commands write results to stdout and
+// diagnostics to stderr so they can be piped into other tools.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, db *sql.DB, cfg *Config, args []string) error
+}
+
+// commands is filled in by init, as the commands refer back to it for usage
+var commands []command
+
+// offlineCommands run without the configuration or a database connection,
+// and are passed neither
+var offlineCommands = map[string]bool{"manifest": true}
+
+func init() {
+	commands = []command{
+		{"interactive", "interactive", "numbered menu (the default when no command is given)", runInteractive},
+		{"serve", "serve [-addr :8080] [-public | -verify]", "run the HTTP API server", runServe},
+		{"search", "search [-year N] [-state S] [-gender G] [-min-score N] [-sort FIELD] [-page N] [flags] [TERM]", "find candidates by name or registration number, with filters and paging", runSearch},
+		{"candidate", "candidate [-format table|csv|json|xlsx] [-o FILE] REGNUMBER", "show a candidate's full record", runCandidate},
+		{"standing", "standing [-year N] [-format table|csv|json|xlsx] [-o FILE] REGNUMBER", "show a candidate's national, state and course percentile and z-score", runStanding},
+		{"slip", "slip [-format text|pdf] [-o FILE] REGNUMBER", "render a candidate's result slip with scores, aggregate and percentiles, as text or PDF", runSlip},
+		{"stats", "stats [-year N] [-filter EXPR] [-weights W] [-format table|csv|json|xlsx] [-o FILE] [-copy] [-copy-sql] REPORT|list", "run a statistics report", runStats},
+		{"import", "import candidates|courses|scores -file PATH|-query SQL [flags]", "import a CSV or .xlsx file, or a source database query, without prompts", runImport},
+		{"score-range", "score-range [-year N] [-format table|csv|json|xlsx] [-o FILE] list | set SUBJECT [-min N] -max N | delete SUBJECT", "list and set the valid score range of each subject, checked by score imports", runScoreRange},
+		{"state-matches", "state-matches [-format table|csv|json|xlsx] [-o FILE] list | review | accept RAW | correct RAW STATE", "review the states imports matched by spelling distance, correcting wrongly matched candidates", runStateMatches},
+		{"course-map", "course-map [-institution ID] [-from DATE] [-to DATE] [-reason R] [-dry-run] [-format table|csv|json|xlsx] [-o FILE] list [CODE] | add OLD NEW | import FILE | delete ID", "list and add the old to new course code mappings candidate imports apply", runCourseMap},
+		{"anonymize", "anonymize [-mask SPEC] [-year N] -yes", "mask candidates' personal data in place with deterministic pseudonyms, for a database shared with researchers", runAnonymize},
+		{"nulls", "nulls [-year N] [-threshold P] [-regressions] [-format table|csv|json|xlsx] [-o FILE]", "report the share of NULLs in each candidate column per year, flagging columns that got worse", runNulls},
+		{"quality", "quality [-year N] [-threshold P] [-format table|csv|json|xlsx] [-o FILE]", "check candidates for codes missing from the state, LGA, institution and course tables, NULL regressions, duplicate regnumbers and aggregates out of range", runQuality},
+		{"repeaters", "repeaters [-match regnumber,name_dob_state|all] [-year N] [-list] [-format table|csv|json|xlsx] [-o FILE]", "link candidates across years and report repeat takers' score improvements and admissions", runRepeaters},
+		{"migrate", "migrate [-steps N] [-yes] up|down|status", "apply, roll back or list schema migrations", runMigrate},
+		{"export", "export -dir DIR [-year N] [-state S] [-course C] [-admitted true|false] [-filter EXPR] [-columns SPEC] [-format csv|parquet] [-chunk N] [-compression none|gzip|zip] [-pseudonymize [-mask SPEC]] [-encrypt]", "stream candidates into CSV or Parquet parts for pandas or Spark, resuming an interrupted export", runExport},
+		{"manifest", "manifest keygen FILE | verify [-key FILE.pub] DIR", "create an export signing key, or check an export's files against its signed manifest", runManifest},
+		{"caps", "caps -year N [-institution CODE] [-filter EXPR] [-o FILE] [-rejects FILE] [-strict]", "write admission decisions in the CAPS upload format", runCAPS},
+		{"letters", "letters -year N [-institution CODE] [-filter EXPR] [-template FILE] [-dir DIR] [-date YYYY-MM-DD] | -print-template", "generate admission letter PDFs from a template, zipped per institution", runLetters},
+		{"report", "report [-year N] [-state S] [-course C] [-format table|csv|json|xlsx] [-o FILE] list | run NAME | save NAME -sql SQL|@FILE [-description D] | delete NAME", "list, run, save and delete saved reports", runReport},
+		{"explain", "explain [-year N] [-state S] [-course C] [-filter EXPR] [-sql] REPORT | saved NAME | list", "run EXPLAIN ANALYZE on a built-in or saved report and show the plan as a tree with per-node timing and buffers", runExplain},
+		{"slow-queries", "slow-queries [-days N] [-limit N] [-format table|csv|json|xlsx] [-o FILE]", "summarise the queries recorded over DB_SLOW_QUERY_THRESHOLD, with advice on each", runSlowQueries},
+		{"sql", "sql [-timeout D] [-limit N] [-format table|csv|json|xlsx] [-o FILE] [STATEMENT|@FILE]", "run one read-only SQL statement, or open the SQL console", runSQL},
+		{"tag", "tag [-filter EXPR | -list FILE] [-description D] add|remove NAME | delete NAME | list [-format table|csv|json|xlsx] [-o FILE]", "tag candidate cohorts for use as tag=NAME in filters", runTag},
+		{"note", "note [-format table|csv|json|xlsx] [-o FILE] list [KIND [KEY]] | add KIND KEY TEXT | delete ID", "list, add and delete notes on institutions, courses and imports", runNote},
+		{"jobs", "jobs [-job NAME] [-limit N] [-format table|csv|json|xlsx] [-o FILE] list|history|run NAME|start", "list, run and show the history of scheduled jobs", runJobs},
+		{"nlq", "nlq [-sql] [-no-cache] [-copy] [-copy-sql] [-format table|csv|json|xlsx] [-o FILE] QUESTION | -history [-source S] [-limit N] | -keys | -clear-cache | -refresh-schema", "answer a natural language question", runNLQuery},
+		{"help", "help", "show this help", nil},
+	}
+}
+
+// commandLine splits the program arguments into the command name and its
+// arguments. Without a command, or when the first argument is a flag as in
+// the older "spk2 --serve", the interactive command is run.
+func commandLine(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "interactive", args
+	}
+	return args[0], args[1:]
+}
+
+// waitForDBFlag applies to every command, so it is taken out of the
+// arguments before the command parses them
+const waitForDBFlag = "wait-for-db"
+
+// globalFlags removes -wait-for-db DURATION (or --wait-for-db=DURATION)
+// from args, wherever it appears before a "--", and returns its value, or
+// -1 when it was not given
+func globalFlags(args []string) ([]string, time.Duration, error) {
+	wait := time.Duration(-1)
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != waitForDBFlag {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return nil, 0, usageError{fmt.Errorf("-%s needs a duration, e.g. 60s", waitForDBFlag)}
+			}
+			i++
+			value = args[i]
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, 0, usageError{fmt.Errorf("-%s %q is not a duration such as 60s", waitForDBFlag, value)}
+		}
+		wait = d
+	}
+	return rest, wait, nil
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: spk2 <command> [flags] [args]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nGlobal flags:")
+	fmt.Fprintf(w, "  -%s DURATION  keep retrying the database connection this long (default $DB_WAIT_TIMEOUT)\n", waitForDBFlag)
+	fmt.Fprintln(w, "\nRun spk2 <command> -h for a command's flags.")
+}
+
+// usageError is returned for bad command lines, which exit with status 2
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+
+func (e usageError) Unwrap() error { return e.err }
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("spk2 "+name, flag.ContinueOnError)
+	// Errors are reported once, by commandFailed
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseFlags parses a command's flags, printing them for -h
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		if cmd := findCommand(strings.Fields(fs.Name())[1]); cmd != nil {
+			fmt.Printf("usage: spk2 %s\n", cmd.usage)
+		}
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if err != nil {
+		return usageError{err}
+	}
+	return nil
+}
+
+// commandFailed reports err from cmd on stderr and returns the exit status
+func commandFailed(cmd *command, err error) int {
+	var usage usageError
+	var headers *importer.HeaderError
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		// parseFlags has printed the usage
+		return 0
+	case errors.As(err, &headers):
+		// Machine-readable, so pipelines can report the columns to fix
+		fmt.Fprintln(os.Stderr, headers.JSON())
+		return 2
+	case errors.As(err, &usage):
+		fmt.Fprintf(os.Stderr, "%v\nusage: spk2 %s\n", err, cmd.usage)
+		return 2
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+}
+
+// parseFilter parses an optional filter expression
+func parseFilter(input string) (*filter.Filter, error) {
+	if input == "" {
+		return nil, nil
+	}
+	expr, err := filter.Parse(input)
+	if err != nil {
+		return nil, usageError{fmt.Errorf("invalid filter: %w", err)}
+	}
+	return expr, nil
+}
+
+func checkFormat(format string) error {
+	if _, err := parseResultFormat(format); err != nil {
+		return usageError{err}
+	}
+	return nil
+}
+
+// runSearch is the scriptable form of menu item 1
+func runSearch(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("search")
+	var criteria search.Criteria
+	var opts search.Options
+	var minScore, maxScore int
+	var admitted string
+	fs.IntVar(&criteria.Year, "year", 0, "application year")
+	fs.StringVar(&criteria.State, "state", "", "state of origin name")
+	fs.StringVar(&criteria.LGA, "lga", "", "local government area name")
+	fs.StringVar(&criteria.Gender, "gender", "", "M or F")
+	fs.StringVar(&criteria.Course, "course", "", "first choice course code, or part of its name")
+	fs.IntVar(&minScore, "min-score", -1, "lowest aggregate")
+	fs.IntVar(&maxScore, "max-score", -1, "highest aggregate")
+	fs.StringVar(&admitted, "admitted", "", "true or false to match admission status")
+	filterText := fs.String("filter", "", "further restrict matches, e.g. sittings=1 AND direct_entry=false")
+	fs.StringVar(&opts.Sort, "sort", "regnumber", "sort by "+strings.Join(search.SortFields(), ", "))
+	fs.BoolVar(&opts.Desc, "desc", false, "sort in descending order")
+	fs.IntVar(&opts.Page, "page", 1, "page of results")
+	fs.IntVar(&opts.PageSize, "page-size", search.DefaultPageSize, "candidates per page")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageError{errors.New("search takes at most one search term")}
+	}
+	criteria.Term = fs.Arg(0)
+	if minScore >= 0 {
+		criteria.MinScore = &minScore
+	}
+	if maxScore >= 0 {
+		criteria.MaxScore = &maxScore
+	}
+	if admitted != "" {
+		value, err := strconv.ParseBool(admitted)
+		if err != nil {
+			return usageError{fmt.Errorf("invalid -admitted %q", admitted)}
+		}
+		criteria.Admitted = &value
+	}
+	if err := opts.Validate(); err != nil {
+		return usageError{err}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	var err error
+	if criteria.Filter, err = parseFilter(*filterText); err != nil {
+		return err
+	}
+
+	result, err := search.Run(ctx, db, criteria, opts)
+	if err != nil {
+		return err
+	}
+	header := []string{"regnumber", "surname", "firstname", "gender", "year", "aggregate", "state", "lga", "course", "admitted"}
+	rows := make([][]interface{}, len(result.Candidates))
+	for i, c := range result.Candidates {
+		var aggregate interface{}
+		if c.Aggregate != nil {
+			aggregate = *c.Aggregate
+		}
+		rows[i] = []interface{}{c.RegNumber, c.Surname, c.FirstName, c.Gender, c.Year, aggregate, c.State, c.LGA, c.Course, c.Admitted}
+	}
+	if err := writeResult("candidate-search", header, rows, *format, *output); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Page %d of %d (%d candidates)\n", result.Page, result.Pages(), result.Total)
+	return nil
+}
+
+// runStats runs one of the shared reports, named as in the API's
+// /api/reports index
+func runStats(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("stats")
+	year := fs.Int("year", 0, "restrict the report to one year (default all years)")
+	filterText := fs.String("filter", "", "restrict the candidates reported on, e.g. state=LAGOS AND gender=F")
+	weights := fs.String("weights", "", "institution-composite weights, e.g. score=0.6,volume=0.4")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	copyRows := fs.Bool("copy", false, "also copy the result to the clipboard as tab-separated rows")
+	copySQL := fs.Bool("copy-sql", false, "also copy the report's SQL to the clipboard")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{errors.New("stats needs a report name, or list to show them")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	expr, err := parseFilter(*filterText)
+	if err != nil {
+		return err
+	}
+
+	all := builtinReports()
+	name := fs.Arg(0)
+	if name == "list" {
+		for _, report := range all {
+			fmt.Printf("%-24s %s\n", report.Name, report.Title)
+		}
+		return nil
+	}
+
+	var report *reports.Report
+	for i := range all {
+		if all[i].Name == name {
+			report = &all[i]
+		}
+	}
+	switch {
+	case report == nil:
+		return usageError{fmt.Errorf("unknown report %q; spk2 stats list shows the reports", name)}
+	case name == "year-comparison":
+		*report = reports.YearComparison(tableHasColumn(ctx, db, "candidate", "equated_aggregate"))
+	case name == "institution-composite" && *weights != "":
+		w, err := reports.ParseRankingWeights(*weights)
+		if err != nil {
+			return usageError{err}
+		}
+		*report = reports.CompositeRanking(w)
+	}
+
+	source, sourceArgs := reports.Source(*year, expr)
+	plan, err := reports.Route(ctx, db, *report, source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	rows, err := db.QueryContext(ctx, plan.SQL, sourceArgs...)
+	if err != nil {
+		return fmt.Errorf("error running report %s: %w", report.Name, err)
+	}
+	defer rows.Close()
+	columns, result, err := scanResultRows(rows)
+	if err != nil {
+		return err
+	}
+	if err := writeResult(report.Name, columns, result, *format, *output); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, plan.Footer())
+	if *copyRows {
+		if err := copyTable(report.Name, columns, result); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Copied %d rows to the clipboard\n", len(result))
+	}
+	if *copySQL {
+		if err := copyToClipboard(plan.SQL); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Copied the SQL to the clipboard")
+		for i, arg := range sourceArgs {
+			fmt.Fprintf(os.Stderr, "  $%d = %v\n", i+1, arg)
+		}
+	}
+	return nil
+}
+
+// runImport imports a file or source query without prompting. Candidate imports resolve
+// headers non-interactively, so an unrecognised layout fails with the
+// unresolved columns as JSON on stderr.
+func runImport(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return usageError{errors.New("import needs a kind: candidates, courses or scores")}
+	}
+	kind, args := args[0], args[1:]
+
+	workerCount := 4
+	if count, err := strconv.Atoi(os.Getenv("WORKER_COUNT")); err == nil && count > 0 {
+		workerCount = count
+	}
+	// STATE_MATCH_DISTANCE is checked at startup
+	stateMatchDistance, _ := importer.StateMatchDistanceFromEnv()
+
+	fs := newFlagSet("import " + kind)
+	file := fs.String("file", "", "CSV or .xlsx file to import")
+	sheet := fs.String("sheet", "", "workbook sheet to read (default the first)")
+	query := fs.String("query", "", "instead of -file, import the rows this SQL (or @file.sql) returns from the source database")
+	sourceDSN := fs.String("source-dsn", "", "with -query, Postgres DSN of the source database ($IMPORT_SOURCE_DSN), or local for foreign tables in this database")
+	var year *int
+	var dryRun *bool
+	if kind != "courses" {
+		year = fs.Int("year", 0, "year of the data (required for candidates)")
+		dryRun = fs.Bool("dry-run", false, "report what would change without writing (scores, or candidates with -delta)")
+	}
+	var (
+		admission *bool
+		profile   *string
+		lookup    *string
+		strategy  *string
+		workers   *int
+		threshold *float64
+		delta     *bool
+		pseudo    *bool
+		masking   *string
+		stateDist *int
+	)
+	switch kind {
+	case "candidates":
+		admission = fs.Bool("admission", false, "the file is admission data")
+		profile = fs.String("profile", "", "YAML or JSON column mapping profile")
+		lookup = fs.String("lookup", "lenient", "unknown state/LGA/course/institution values: strict or lenient")
+		strategy = fs.String("strategy", "insert", "how rows are written: insert or copy")
+		workers = fs.Int("workers", workerCount, "parallel import workers (default $WORKER_COUNT or 4)")
+		threshold = fs.Float64("threshold", importer.DefaultAutoAcceptThreshold, "fuzzy header match confidence accepted automatically")
+		delta = fs.Bool("delta", false, "the file holds only changed candidates; update values that differ")
+		pseudo = fs.Bool("pseudonymize", false, "mask names, email, gsmno and address as they are imported, with $PSEUDONYM_KEY")
+		masking = fs.String("mask", "", "with -pseudonymize, column=method changes to the default masking, e.g. address=mask")
+		stateDist = fs.Int("state-distance", stateMatchDistance, "largest spelling distance a misspelt state is matched within; 0 turns this off (default $STATE_MATCH_DISTANCE or 2)")
+	case "courses", "scores":
+	default:
+		return usageError{fmt.Errorf("unknown import kind %q", kind)}
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*file == "") == (*query == "") || fs.NArg() > 0 {
+		return usageError{errors.New("import needs one of -file or -query and no other arguments")}
+	}
+	if *sourceDSN != "" && *query == "" {
+		return usageError{errors.New("-source-dsn needs -query")}
+	}
+
+	var (
+		reader *csv.Reader
+		size   int64
+		source io.Closer
+		err    error
+	)
+	if *query != "" {
+		reader, source, *file, err = openQuerySource(ctx, db, *sourceDSN, *query)
+	} else {
+		reader, size, source, err = openImportSheet(*file, *sheet)
+	}
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	switch kind {
+	case "courses":
+		importCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		defer cancel()
+		config := importer.ImportConfig{BatchSize: 1000, WorkerCount: 4}
+		if err := importer.ImportCourses(importCtx, db, config, reader); err != nil {
+			return fmt.Errorf("import failed: %w", err)
+		}
+		color.Green("Successfully imported courses!")
+		return nil
+
+	case "scores":
+		importCtx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+		defer cancel()
+		config := importer.ImportConfig{
+			Year:         *year,
+			SourceFile:   *file,
+			BatchSize:    5000,
+			ValidateOnly: *dryRun,
+			Progress:     importer.ProgressFunc(printImportProgress),
+			SourceSize:   size,
+			OnComplete:   scoreImportHooks(db),
+		}
+		summary, err := importer.ImportScores(importCtx, db, config, reader)
+		if err != nil {
+			return fmt.Errorf("score import error: %w", err)
+		}
+		printScoreSummary(summary)
+		return nil
+	}
+
+	if *year == 0 {
+		return usageError{errors.New("import candidates needs -year")}
+	}
+	if *dryRun && !*delta {
+		return usageError{errors.New("-dry-run needs -delta for candidate imports")}
+	}
+	lookupMode, err := importer.ParseLookupMode(*lookup)
+	if err != nil {
+		return usageError{err}
+	}
+	importStrategy, err := importer.ParseStrategy(*strategy)
+	if err != nil {
+		return usageError{err}
+	}
+	if *masking != "" && !*pseudo {
+		return usageError{errors.New("-mask needs -pseudonymize")}
+	}
+	var pseudonymizer *privacy.Pseudonymizer
+	if *pseudo {
+		if pseudonymizer, err = newPseudonymizer(*masking); err != nil {
+			return err
+		}
+		if err := pseudonymizer.CheckInPlace(); err != nil {
+			return usageError{err}
+		}
+	}
+	config := importer.ImportConfig{
+		Year:                *year,
+		SourceFile:          *file,
+		IsAdmission:         *admission,
+		BatchSize:           1000,
+		ValidateOnly:        *dryRun,
+		WorkerCount:         *workers,
+		LookupMode:          lookupMode,
+		Strategy:            importStrategy,
+		OnComplete:          candidateImportHooks(db),
+		Progress:            importer.ProgressFunc(printImportProgress),
+		SourceSize:          size,
+		MappingProfile:      *profile,
+		NonInteractive:      true,
+		AutoAcceptThreshold: *threshold,
+		Pseudonymizer:       pseudonymizer,
+		StateMatchDistance:  *stateDist,
+	}
+	if *stateDist == 0 {
+		config.StateMatchDistance = -1
+	}
+
+	importCtx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+	if *delta {
+		return runDeltaImport(importCtx, db, config, reader)
+	}
+	if err := importer.ImportData(importCtx, db, config, reader); err != nil {
+		return fmt.Errorf("import error: %w", err)
+	}
+	color.Green("Import completed successfully!")
+	return nil
+}
+
+// runNLQuery answers one question and prints the result rows
+func runNLQuery(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("nlq")
+	showSQL := fs.Bool("sql", false, "print the generated SQL to stderr")
+	noCache := fs.Bool("no-cache", false, "ask the model even if the question's SQL is cached")
+	clearCache := fs.Bool("clear-cache", false, "empty the translation cache ($NL_CACHE) and exit")
+	refreshSchema := fs.Bool("refresh-schema", false, "reread the schema described to the model and print it")
+	history := fs.Bool("history", false, "list the latest logged questions and exit")
+	keys := fs.Bool("keys", false, "send a short prompt with each Gemini API key, list their health and exit")
+	source := fs.String("source", "", "with -history, only questions from menu, cli or api")
+	limit := fs.Int("limit", 20, "with -history, number of questions to list")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	copyRows := fs.Bool("copy", false, "also copy the result to the clipboard as tab-separated rows")
+	copySQL := fs.Bool("copy-sql", false, "also copy the generated SQL to the clipboard")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *clearCache {
+		n, err := nlquery.ClearCache(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Translation cache cleared (%d stored entries removed)\n", n)
+		return nil
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *history {
+		if *limit <= 0 {
+			return usageError{errors.New("-limit must be positive")}
+		}
+		entries, err := nlquery.QueryLog(ctx, db, strings.ToLower(*source), *limit)
+		if err != nil {
+			return err
+		}
+		header, rows := nlQueryLogRows(entries)
+		return writeResult("nl-query-log", header, rows, *format, *output)
+	}
+	if *keys {
+		statuses, err := nlquery.CheckKeys(ctx)
+		if err != nil {
+			return err
+		}
+		header, rows := nlKeyRows(statuses)
+		return writeResult("nl-keys", header, rows, *format, *output)
+	}
+	if *refreshSchema {
+		description, err := nlquery.RefreshSchemaContext(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Print(description)
+		return nil
+	}
+	question := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if question == "" {
+		return usageError{errors.New("nlq needs a question")}
+	}
+
+	engine, err := nlquery.NewNLQueryEngineWithOptions(db, nlquery.Options{QueryDB: analyticsDB, Source: "cli"})
+	if err != nil {
+		return fmt.Errorf("error initializing query engine: %w", err)
+	}
+	if *noCache {
+		ctx = nlquery.WithoutCache(ctx)
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	result, err := engine.Answer(queryCtx, question)
+	if result != nil && result.Provider != "" {
+		fmt.Fprintf(os.Stderr, "Answered by: %s\n", result.Provider)
+	}
+	if *showSQL && result != nil && result.SQLQuery != "" {
+		fmt.Fprintln(os.Stderr, result.SQLQuery)
+	}
+	if result != nil && result.Explanation != "" {
+		fmt.Fprintf(os.Stderr, "Explanation: %s\n", result.Explanation)
+	}
+	if err != nil {
+		return err
+	}
+	if *format == "table" && *output == "" {
+		fmt.Println(result.Results)
+	} else if err := writeResult("nl-query", result.Columns, nlRows(result.Rows), *format, *output); err != nil {
+		return err
+	}
+	if *copyRows {
+		if err := copyTable("nl-query", result.Columns, nlRows(result.Rows)); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Copied %d rows to the clipboard\n", len(result.Rows))
+	}
+	if *copySQL {
+		if err := copyToClipboard(result.SQLQuery); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Copied the SQL to the clipboard")
+	}
+	return nil
+}
+
+// scanResultRows reads query rows into the cells writeResult takes
+func scanResultRows(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var result [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		result = append(result, values)
+	}
+	return columns, result, rows.Err()
+}
+
+// writeResult renders a result in format, to path or to stdout when path
+// is empty. Workbooks need a path.
+func writeResult(name string, columns []string, result [][]interface{}, format, path string) error {
+	if path == "" {
+		switch format {
+		case "xlsx":
+			return usageError{errors.New("-format xlsx needs -o")}
+		case "csv":
+			return CSVRenderer{W: os.Stdout}.Render(name, columns, result)
+		case "json":
+			return JSONRenderer{W: os.Stdout}.Render(name, columns, result)
+		default:
+			return consoleTable(false).Render(name, columns, limitForConsole(name, columns, result, ""))
+		}
+	}
+	renderer, file, err := newFileRenderer(format, path)
+	if err != nil {
+		return err
+	}
+	err = renderer.Render(name, columns, result)
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Saved %d rows to %s\n", len(result), path)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/reports"
+)
+
+// lastShown is the latest result shown in the menu and the SQL behind it,
+// kept so it can be copied to the clipboard
+var lastShown struct {
+	name   string
+	header []string
+	rows   [][]interface{}
+	sql    string
+}
+
+func rememberShown(name string, header []string, rows [][]interface{}, sql string) {
+	lastShown.name, lastShown.header, lastShown.rows, lastShown.sql = name, header, rows, sql
+}
+
+// reportSQL returns the SQL of the named report over the session's
+// candidates, or "" if the result is not one of the reports
+func reportSQL(name string) string {
+	for _, report := range reports.All {
+		if report.Name == name {
+			return report.SQL(currentSession.CandidateSource())
+		}
+	}
+	return ""
+}
+
+// handleCopy copies the last result shown, as TSV, or the SQL behind it
+func handleCopy(sql bool) error {
+	if lastShown.header == nil {
+		return fmt.Errorf("nothing has been shown yet")
+	}
+	if sql {
+		if lastShown.sql == "" {
+			return fmt.Errorf("no SQL is kept for %s", lastShown.name)
+		}
+		if err := copyToClipboard(lastShown.sql); err != nil {
+			return err
+		}
+		color.Green("Copied the SQL for %s", lastShown.name)
+		return nil
+	}
+	if err := copyTable(lastShown.name, lastShown.header, lastShown.rows); err != nil {
+		return err
+	}
+	color.Green("Copied %d rows of %s", len(lastShown.rows), lastShown.name)
+	return nil
+}
+
+// copyTable copies a result as TSV, ready to paste into a spreadsheet
+func copyTable(name string, header []string, rows [][]interface{}) error {
+	var buf bytes.Buffer
+	if err := (TSVRenderer{W: &buf}).Render(name, header, rows); err != nil {
+		return err
+	}
+	return copyToClipboard(buf.String())
+}
+
+// copyToClipboard hands text to the platform's clipboard tool
+func copyToClipboard(text string) error {
+	for _, tool := range clipboardTools() {
+		path, err := exec.LookPath(tool[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("error copying with %s: %v %s", tool[0], err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return errors.New("no clipboard tool found; install xclip, xsel or wl-clipboard")
+}
+
+func clipboardTools() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	}
+	tools := [][]string{{"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append([][]string{{"wl-copy"}}, tools...)
+	}
+	return tools
+}
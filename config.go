@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/nonsonwune/spk2_db/api"
+	"github.com/nonsonwune/spk2_db/db"
+	"github.com/nonsonwune/spk2_db/export"
+	"github.com/nonsonwune/spk2_db/importer"
+	"github.com/nonsonwune/spk2_db/nlquery"
+	"github.com/nonsonwune/spk2_db/privacy"
+	"github.com/nonsonwune/spk2_db/secrets"
+)
+
+// Config holds application configuration
+type Config struct {
+	DB db.Config
+
+	// Secrets is the secret store selected by SECRETS_BACKEND; nil when
+	// settings come only from .env and the environment
+	Secrets *secrets.Store
+
+	// Numbers is how console tables show numbers
+	Numbers numberFormat
+
+	// Render caps the rows drawn as console tables
+	Render renderLimit
+
+	// Layout chooses between tables and records on the console
+	Layout tableLayout
+}
+
+// settingsError lists every missing or invalid setting found at startup
+type settingsError struct {
+	problems []string
+}
+
+func (e *settingsError) Error() string {
+	var b strings.Builder
+	b.WriteString("missing or invalid settings; set these in .env or the environment:")
+	for _, p := range e.problems {
+		b.WriteString("\n  - ")
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+// loadConfig reads .env, when there is one, and checks every setting the
+// command depends on before anything connects. The natural language
+// settings are only required by the nlq command; other commands warn about
+// them, as the menu and the API server work without the NL features.
+func loadConfig(command string) (*Config, error) {
+	var problems []string
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, fmt.Sprintf("error reading .env: %v", err))
+	}
+
+	// Secrets override .env, so they are fetched before anything is read
+	store, err := loadSecrets()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	dbConfig, err := db.ConfigFromEnv()
+	var configErr *db.ConfigError
+	if errors.As(err, &configErr) {
+		problems = append(problems, configErr.Problems...)
+	} else if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if raw := os.Getenv("WORKER_COUNT"); raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil || n <= 0 {
+			problems = append(problems, fmt.Sprintf("WORKER_COUNT %q is not a positive whole number", raw))
+		}
+	}
+	if raw := os.Getenv("NL_SESSION_TTL"); raw != "" {
+		if ttl, err := time.ParseDuration(raw); err != nil || ttl <= 0 {
+			problems = append(problems, fmt.Sprintf("NL_SESSION_TTL %q is not a duration such as 30m", raw))
+		}
+	}
+	if _, err := api.ParseTokens(os.Getenv("API_TOKENS")); err != nil {
+		problems = append(problems, fmt.Sprintf("API_TOKENS: %v", err))
+	}
+	if raw := os.Getenv("VERIFY_RATE_LIMIT"); raw != "" {
+		if limit, err := strconv.Atoi(raw); err != nil || limit <= 0 {
+			problems = append(problems, fmt.Sprintf("VERIFY_RATE_LIMIT %q is not a positive number of verifications a minute", raw))
+		}
+	}
+	if _, _, err := consoleLimits(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := scheduleInterval("SCHEDULE_REFRESH_STATS"); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := loadReportSchedule(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := slowQueryReportFromEnv(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := scheduleCatchUp(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := privacy.FromEnv(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if key := privacy.PseudonymKeyFromEnv(); key != "" && len(key) < privacy.MinKeyLength {
+		problems = append(problems, fmt.Sprintf("PSEUDONYM_KEY must be at least %d characters", privacy.MinKeyLength))
+	}
+	numbers, err := numberFormatFromEnv()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	render, err := renderLimitFromEnv()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	layout, err := tableLayoutFromEnv()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := importer.StateMatchDistanceFromEnv(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := export.RecipientsFromEnv(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := export.SigningKeyFromEnv(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if nl := nlquery.CheckSettings(); len(nl) > 0 {
+		if command == "nlq" {
+			problems = append(problems, nl...)
+		} else {
+			for _, p := range nl {
+				log.Printf("Warning: natural language queries unavailable: %s", p)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &settingsError{problems: problems}
+	}
+	if store != nil {
+		dbConfig.PasswordFunc = func(ctx context.Context) (string, error) {
+			return store.Get(ctx, "DB_PASSWORD")
+		}
+	}
+	return &Config{DB: dbConfig, Secrets: store, Numbers: numbers, Render: render, Layout: layout}, nil
+}
+
+// loadSecrets fetches the secrets from the store SECRETS_BACKEND selects,
+// cached for SECRETS_REFRESH (default 5m)
+func loadSecrets() (*secrets.Store, error) {
+	source, err := secrets.FromEnv()
+	if err != nil || source == nil {
+		return nil, err
+	}
+	refresh := secrets.DefaultRefresh
+	if raw := os.Getenv("SECRETS_REFRESH"); raw != "" {
+		if refresh, err = time.ParseDuration(raw); err != nil || refresh <= 0 {
+			return nil, fmt.Errorf("SECRETS_REFRESH %q is not a duration such as 5m", raw)
+		}
+	}
+	store := secrets.NewStore(source, refresh)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := store.Load(ctx); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
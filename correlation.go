@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// defaultCorrelationWorkers bounds the number of pair queries in flight
+	defaultCorrelationWorkers = 4
+	// minCorrelationSample is the minimum shared candidates for a pair to be reported
+	minCorrelationSample = 1000
+	// subjectCorrelationView is the optional precomputed correlation matrix
+	subjectCorrelationView = "subject_correlation_mv"
+)
+
+// subjectPair identifies two subjects whose scores are correlated
+type subjectPair struct {
+	ID1, ID2     int
+	Name1, Name2 string
+}
+
+// correlationResult holds the statistics computed for a subject pair
+type correlationResult struct {
+	Subject1, Subject2 string
+	Correlation        float64
+	SampleSize         int
+	AvgScore1          float64
+	AvgScore2          float64
+	StdDev1            float64
+	StdDev2            float64
+}
+
+func displaySubjectCorrelation(ctx context.Context, db *sql.DB) error {
+	year, err := latestCandidateYear(ctx, db)
+	if err != nil {
+		color.Red("Error determining latest year: %v", err)
+		return err
+	}
+
+	var results []correlationResult
+	if exists, _ := materializedViewExists(ctx, db, subjectCorrelationView); exists {
+		fmt.Print("Use precomputed correlation view? (y/n, r to refresh first): ")
+		switch strings.ToLower(readString()) {
+		case "r":
+			color.Yellow("Refreshing %s...", subjectCorrelationView)
+			if err := refreshSubjectCorrelationView(ctx, db); err != nil {
+				color.Red("Error refreshing correlation view: %v", err)
+				return err
+			}
+			results, err = loadPrecomputedCorrelations(ctx, db, year)
+		case "y":
+			results, err = loadPrecomputedCorrelations(ctx, db, year)
+		default:
+			results, err = computeSubjectCorrelations(ctx, db, year, correlationWorkerCount())
+		}
+	} else {
+		results, err = computeSubjectCorrelations(ctx, db, year, correlationWorkerCount())
+	}
+	if err != nil {
+		color.Red("Error fetching subject correlations: %v", err)
+		return err
+	}
+
+	table := newResultTable("subject-correlation")
+	table.SetHeader([]string{
+		"Subject 1",
+		"Subject 2",
+		"Correlation",
+		"Sample Size",
+		"Avg Score 1",
+		"Avg Score 2",
+		"StdDev 1",
+		"StdDev 2",
+	})
+
+	for _, r := range results {
+		table.Append([]string{
+			r.Subject1,
+			r.Subject2,
+			fmt.Sprintf("%.3f", r.Correlation),
+			fmt.Sprintf("%d", r.SampleSize),
+			fmt.Sprintf("%.2f", r.AvgScore1),
+			fmt.Sprintf("%.2f", r.AvgScore2),
+			fmt.Sprintf("%.2f", r.StdDev1),
+			fmt.Sprintf("%.2f", r.StdDev2),
+		})
+	}
+
+	color.Cyan("\nSubject Score Correlations (%d)\n", year)
+	if len(results) == 0 {
+		color.Yellow("No significant correlations found between subjects.")
+	} else {
+		table.Render()
+	}
+
+	return nil
+}
+
+// correlationWorkerCount reads CORRELATION_WORKERS, falling back to the default
+func correlationWorkerCount() int {
+	if env := os.Getenv("CORRELATION_WORKERS"); env != "" {
+		if count, err := strconv.Atoi(env); err == nil && count > 0 {
+			return count
+		}
+	}
+	return defaultCorrelationWorkers
+}
+
+func latestCandidateYear(ctx context.Context, db *sql.DB) (int, error) {
+	var year sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(year) FROM `+currentSession.CandidateSource()).Scan(&year); err != nil {
+		return 0, err
+	}
+	if !year.Valid {
+		return 0, fmt.Errorf("no candidate data available")
+	}
+	return int(year.Int64), nil
+}
+
+// scoredSubjects returns the subjects that have scores recorded for the year
+func scoredSubjects(ctx context.Context, db *sql.DB, year int) ([]subjectPair, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT DISTINCT s.su_id, s.su_name
+        FROM subject s
+        JOIN candidate_scores cs ON cs.subject_id = s.su_id
+        WHERE cs.year = $1
+        ORDER BY s.su_id`, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subjects []subjectPair
+	for rows.Next() {
+		var s subjectPair
+		if err := rows.Scan(&s.ID1, &s.Name1); err != nil {
+			return nil, err
+		}
+		subjects = append(subjects, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pairs := make([]subjectPair, 0, len(subjects)*(len(subjects)-1)/2)
+	for i := 0; i < len(subjects); i++ {
+		for j := i + 1; j < len(subjects); j++ {
+			pairs = append(pairs, subjectPair{
+				ID1:   subjects[i].ID1,
+				ID2:   subjects[j].ID1,
+				Name1: subjects[i].Name1,
+				Name2: subjects[j].Name1,
+			})
+		}
+	}
+	return pairs, nil
+}
+
+// computeSubjectCorrelations runs one correlation query per subject pair using
+// a bounded pool of workers and merges the results ordered by strength.
+func computeSubjectCorrelations(ctx context.Context, db *sql.DB, year, workers int) ([]correlationResult, error) {
+	pairs, err := scoredSubjects(ctx, db, year)
+	if err != nil {
+		return nil, fmt.Errorf("error listing subjects: %w", err)
+	}
+
+	fmt.Printf("\nComputing %d subject pairs with %d workers\n", len(pairs), workers)
+
+	jobs := make(chan subjectPair)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		results  []correlationResult
+		firstErr error
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pair := range jobs {
+				result, ok, err := correlatePair(ctx, db, pair, year)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("error correlating %s and %s: %w", pair.Name1, pair.Name2, err)
+				} else if ok {
+					results = append(results, result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, pair := range pairs {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- pair:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	sortCorrelations(results)
+	return results, nil
+}
+
+// correlatePair computes statistics for a single pair. The boolean result is
+// false when the pair lacks enough shared candidates or variance.
+func correlatePair(ctx context.Context, db *sql.DB, pair subjectPair, year int) (correlationResult, bool, error) {
+	query := `
+        SELECT
+            COUNT(*),
+            CORR(a.score, b.score),
+            AVG(a.score),
+            AVG(b.score),
+            STDDEV(a.score),
+            STDDEV(b.score)
+        FROM candidate_scores a
+        JOIN candidate_scores b
+            ON a.cand_reg_number = b.cand_reg_number AND a.year = b.year
+        WHERE a.subject_id = $1 AND b.subject_id = $2 AND a.year = $3
+    `
+
+	var (
+		sampleSize                   int
+		corr, avg1, avg2, std1, std2 sql.NullFloat64
+	)
+	err := db.QueryRowContext(ctx, query, pair.ID1, pair.ID2, year).
+		Scan(&sampleSize, &corr, &avg1, &avg2, &std1, &std2)
+	if err != nil {
+		return correlationResult{}, false, err
+	}
+
+	if sampleSize < minCorrelationSample || !corr.Valid || std1.Float64 == 0 || std2.Float64 == 0 {
+		return correlationResult{}, false, nil
+	}
+
+	return correlationResult{
+		Subject1:    pair.Name1,
+		Subject2:    pair.Name2,
+		Correlation: corr.Float64,
+		SampleSize:  sampleSize,
+		AvgScore1:   avg1.Float64,
+		AvgScore2:   avg2.Float64,
+		StdDev1:     std1.Float64,
+		StdDev2:     std2.Float64,
+	}, true, nil
+}
+
+func sortCorrelations(results []correlationResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return math.Abs(results[i].Correlation) > math.Abs(results[j].Correlation)
+	})
+}
+
+func materializedViewExists(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_matviews WHERE schemaname = 'public' AND matviewname = $1)`,
+		name).Scan(&exists)
+	return exists, err
+}
+
+func refreshSubjectCorrelationView(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "REFRESH MATERIALIZED VIEW "+subjectCorrelationView)
+	return err
+}
+
+// loadPrecomputedCorrelations reads the pair statistics from the materialized view
+func loadPrecomputedCorrelations(ctx context.Context, db *sql.DB, year int) ([]correlationResult, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT subject1, subject2, correlation, sample_size,
+               avg_score1, avg_score2, stddev1, stddev2
+        FROM `+subjectCorrelationView+`
+        WHERE year = $1 AND correlation IS NOT NULL`, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []correlationResult
+	for rows.Next() {
+		var r correlationResult
+		if err := rows.Scan(&r.Subject1, &r.Subject2, &r.Correlation, &r.SampleSize,
+			&r.AvgScore1, &r.AvgScore2, &r.StdDev1, &r.StdDev2); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortCorrelations(results)
+	return results, nil
+}
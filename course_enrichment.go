@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/enrichment"
+	"github.com/nonsonwune/spk2_db/nlquery"
+	"github.com/olekukonko/tablewriter"
+)
+
+func handleCourseNameEnrichment(ctx context.Context, db *sql.DB) error {
+	enricher := enrichment.NewCourseNameEnricher(db, nil)
+
+	color.Cyan("\nCourse Name Enrichment")
+	fmt.Println("1. Generate suggestions from historical mappings")
+	fmt.Println("2. Generate suggestions with LLM")
+	fmt.Println("3. Review pending suggestions")
+	fmt.Println("4. Show recent name changes")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		n, err := enricher.SuggestFromHistory(ctx)
+		if err != nil {
+			return err
+		}
+		color.Green("Queued %d suggestions for review", n)
+	case "2":
+		engine, err := nlquery.NewNLQueryEngine(db)
+		if err != nil {
+			return fmt.Errorf("error initializing LLM: %w", err)
+		}
+		enricher = enrichment.NewCourseNameEnricher(db, engine)
+
+		fmt.Print("How many courses to process? (default 20): ")
+		limit, err := strconv.Atoi(readString())
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		courses, err := enricher.PlaceholderCourses(ctx, limit)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Requesting suggestions for %d courses...\n", len(courses))
+		n, err := enricher.SuggestWithLLM(ctx, courses)
+		if err != nil {
+			color.Red("Stopped early: %v", err)
+		}
+		color.Green("Queued %d suggestions for review", n)
+	case "3":
+		return reviewCourseNameSuggestions(ctx, enricher)
+	case "4":
+		return displayCourseNameAudit(ctx, db)
+	}
+	return nil
+}
+
+func reviewCourseNameSuggestions(ctx context.Context, enricher *enrichment.CourseNameEnricher) error {
+	suggestions, err := enricher.PendingSuggestions(ctx, 50)
+	if err != nil {
+		return err
+	}
+	if len(suggestions) == 0 {
+		color.Yellow("No pending suggestions")
+		return nil
+	}
+
+	reviewer := os.Getenv("USER")
+	approved, rejected := 0, 0
+	for i, s := range suggestions {
+		fmt.Printf("\n[%d/%d] %s (%s)\n", i+1, len(suggestions), s.CourseCode, s.CurrentName)
+		fmt.Printf("  Suggested: %s\n", s.SuggestedName)
+		fmt.Printf("  Source: %s, confidence %.2f\n", s.Source, s.Confidence)
+		if s.Rationale != "" {
+			fmt.Printf("  Why: %s\n", s.Rationale)
+		}
+		fmt.Print("Approve (a), edit (e), reject (r), skip (s), quit (q): ")
+
+		switch strings.ToLower(readString()) {
+		case "a":
+			if err := enricher.Approve(ctx, s, "", reviewer); err != nil {
+				color.Red("Error: %v", err)
+				continue
+			}
+			approved++
+		case "e":
+			fmt.Print("Course name: ")
+			name := strings.ToUpper(readString())
+			if name == "" {
+				continue
+			}
+			if err := enricher.Approve(ctx, s, name, reviewer); err != nil {
+				color.Red("Error: %v", err)
+				continue
+			}
+			approved++
+		case "r":
+			if err := enricher.Reject(ctx, s.ID); err != nil {
+				color.Red("Error: %v", err)
+				continue
+			}
+			rejected++
+		case "q":
+			color.Green("Approved %d, rejected %d", approved, rejected)
+			return nil
+		}
+	}
+
+	color.Green("Approved %d, rejected %d", approved, rejected)
+	return nil
+}
+
+func displayCourseNameAudit(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+        SELECT course_code, COALESCE(old_name, ''), new_name, COALESCE(changed_by, ''), changed_at
+        FROM course_name_audit
+        ORDER BY changed_at DESC
+        LIMIT 25`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Course Code", "Old Name", "New Name", "Changed By", "Changed At"})
+
+	for rows.Next() {
+		var code, oldName, newName, changedBy string
+		var changedAt sql.NullTime
+		if err := rows.Scan(&code, &oldName, &newName, &changedBy, &changedAt); err != nil {
+			return err
+		}
+		table.Append([]string{code, oldName, newName, changedBy, changedAt.Time.Format("2006-01-02 15:04")})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	color.Cyan("\nRecent Course Name Changes")
+	table.Render()
+	return nil
+}
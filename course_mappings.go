@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/importer"
+)
+
+// runCourseMap lists and adds the old to new course code mappings
+// candidate imports apply to codes no longer in the course table
+func runCourseMap(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("course-map")
+	institution := fs.String("institution", "", "with add or import, the institution the mappings apply to (default every institution)")
+	from := fs.String("from", "", "with add or import, the first year or date the mappings apply to")
+	to := fs.String("to", "", "with add or import, the last year or date the mappings apply to")
+	reason := fs.String("reason", "", "with add or import, why the codes changed")
+	dryRun := fs.Bool("dry-run", false, "with import, check the file without adding its mappings")
+	format := fs.String("format", "table", "with list, output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "with list, write the result to this file instead of stdout")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError{errors.New("course-map needs list, add OLD NEW, import FILE or delete ID")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	fromDate, err := importer.ParseMappingDate(*from, false)
+	if err != nil {
+		return usageError{fmt.Errorf("-from: %w", err)}
+	}
+	toDate, err := importer.ParseMappingDate(*to, true)
+	if err != nil {
+		return usageError{fmt.Errorf("-to: %w", err)}
+	}
+
+	var mappings []importer.CourseMapping
+	switch action := positional[0]; action {
+	case "list":
+		list, err := importer.ListCourseMappings(ctx, db)
+		if err != nil {
+			return err
+		}
+		var rows [][]interface{}
+		for _, m := range list {
+			if len(positional) > 1 && m.OldCode != positional[1] && m.NewCode != positional[1] {
+				continue
+			}
+			rows = append(rows, []interface{}{m.ID, m.OldCode, m.NewCode, m.Institution, mappingDate(m.From), mappingDate(m.To), m.Reason})
+		}
+		return writeResult("course-mappings", []string{"id", "old_course_code", "new_course_code", "institution_id", "effective_from", "effective_to", "reason"}, rows, *format, *output)
+	case "delete":
+		if len(positional) != 2 {
+			return usageError{errors.New("course-map delete needs a mapping ID")}
+		}
+		id, err := strconv.Atoi(positional[1])
+		if err != nil {
+			return usageError{fmt.Errorf("invalid mapping ID %q", positional[1])}
+		}
+		if err := importer.DeleteCourseMapping(ctx, db, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Deleted course code mapping %d\n", id)
+		return nil
+	case "add":
+		if len(positional) != 3 {
+			return usageError{errors.New("course-map add needs an old and a new course code")}
+		}
+		mappings = []importer.CourseMapping{{OldCode: positional[1], NewCode: positional[2]}}
+	case "import":
+		if len(positional) != 2 {
+			return usageError{errors.New("course-map import needs a CSV file")}
+		}
+		file, err := os.Open(positional[1])
+		if err != nil {
+			return err
+		}
+		mappings, err = importer.ReadCourseMappings(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", positional[1], err)
+		}
+	default:
+		return usageError{fmt.Errorf("unknown course-map action %q", action)}
+	}
+
+	// The flags fill in what a file's rows leave out
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Institution == "" {
+			m.Institution = *institution
+		}
+		if m.From.IsZero() {
+			m.From = fromDate
+		}
+		if m.To.IsZero() {
+			m.To = toDate
+		}
+		if m.Reason == "" {
+			m.Reason = *reason
+		}
+	}
+
+	added, problems, err := importer.AddCourseMappings(ctx, db, mappings, *dryRun)
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "Skipped %s -> %s: %s\n", p.Mapping.OldCode, p.Mapping.NewCode, p.Reason)
+	}
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(os.Stderr, "%d of %d mappings are valid; nothing was added\n", added, len(mappings))
+	} else {
+		fmt.Fprintf(os.Stderr, "Added %d course code mappings, %d already recorded; candidate imports apply them from now on\n",
+			added, len(mappings)-len(problems)-added)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d of %d mappings were not valid", len(problems), len(mappings))
+	}
+	return nil
+}
+
+func mappingDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,174 @@
+// Package cutoff simulates the effect of a hypothetical cutoff mark on the
+// applicants to a course at an institution.
+package cutoff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Scenario is a hypothetical cutoff for one course at one institution
+type Scenario struct {
+	CourseCode    string
+	InstitutionID string
+	// Year defaults to the latest year the course has applicants
+	Year   int
+	Cutoff int
+}
+
+// Group is one category of a demographic breakdown
+type Group struct {
+	Name       string `json:"name"`
+	Applicants int    `json:"applicants"`
+	Qualifying int    `json:"qualifying"`
+	Admitted   int    `json:"admitted"`
+}
+
+// Breakdown splits the applicants by one demographic dimension
+type Breakdown struct {
+	Dimension string  `json:"dimension"`
+	Groups    []Group `json:"groups"`
+}
+
+// Simulation is the outcome of applying a scenario's cutoff to the
+// course's current applicants
+type Simulation struct {
+	Scenario
+	CourseName  string `json:"course_name"`
+	Institution string `json:"institution"`
+	Applicants  int    `json:"applicants"`
+	// Qualifying applicants have an aggregate at or above the cutoff
+	Qualifying int `json:"qualifying"`
+	// Admitted and ActualCutoff describe what really happened: how many were
+	// admitted and the lowest admitted aggregate (0 if none were)
+	Admitted     int         `json:"admitted"`
+	ActualCutoff int         `json:"actual_cutoff"`
+	Breakdowns   []Breakdown `json:"breakdowns"`
+}
+
+// AdmissionRate is the share of applicants that would be admitted if every
+// qualifying applicant were
+func (s *Simulation) AdmissionRate() float64 {
+	if s.Applicants == 0 {
+		return 0
+	}
+	return float64(s.Qualifying) / float64(s.Applicants)
+}
+
+// ActualRate is the share of applicants that were actually admitted
+func (s *Simulation) ActualRate() float64 {
+	if s.Applicants == 0 {
+		return 0
+	}
+	return float64(s.Admitted) / float64(s.Applicants)
+}
+
+// dimension is a demographic breakdown: the SQL naming each candidate's
+// group and any join it needs
+type dimension struct {
+	name  string
+	group string
+	join  string
+	// order sorts the groups, by applicants when empty
+	order string
+}
+
+var dimensions = []dimension{
+	{name: "Gender", group: "COALESCE(c.gender, 'Unknown')"},
+	{name: "State of Origin", group: "COALESCE(s.st_name, 'Unknown')", join: "LEFT JOIN state s ON s.st_id = c.statecode"},
+	{
+		name: "Age",
+		group: `CASE
+            WHEN c.date_of_birth IS NULL THEN 'Unknown'
+            WHEN DATE_PART('year', AGE(MAKE_DATE(c.year, 1, 1), c.date_of_birth)) < 18 THEN 'Under 18'
+            WHEN DATE_PART('year', AGE(MAKE_DATE(c.year, 1, 1), c.date_of_birth)) <= 20 THEN '18-20'
+            WHEN DATE_PART('year', AGE(MAKE_DATE(c.year, 1, 1), c.date_of_birth)) <= 24 THEN '21-24'
+            ELSE '25 and over' END`,
+		order: "grp",
+	},
+	{
+		name: "Disability",
+		group: `CASE
+            WHEN c.is_blind AND c.is_deaf THEN 'Blind and deaf'
+            WHEN c.is_blind THEN 'Blind'
+            WHEN c.is_deaf THEN 'Deaf'
+            ELSE 'None recorded' END`,
+	},
+}
+
+// Simulate applies the scenario's cutoff to the course's applicants in
+// source, the candidate table or a session working set
+func Simulate(ctx context.Context, db *sql.DB, source string, sc Scenario) (*Simulation, error) {
+	sim := &Simulation{Scenario: sc}
+	err := db.QueryRowContext(ctx, `
+        SELECT co.course_name, i.inname
+        FROM course co, institution i
+        WHERE co.course_code = $1 AND i.inid = $2`, sc.CourseCode, sc.InstitutionID).Scan(&sim.CourseName, &sim.Institution)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown course %q or institution %q", sc.CourseCode, sc.InstitutionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sim.Year == 0 {
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`
+            SELECT COALESCE(MAX(c.year), 0) FROM %s c
+            WHERE c.app_course1 = $1 AND c.inid = $2`, source), sc.CourseCode, sc.InstitutionID).Scan(&sim.Year)
+		if err != nil {
+			return nil, fmt.Errorf("error finding the latest year: %w", err)
+		}
+		if sim.Year == 0 {
+			return nil, fmt.Errorf("%s at %s has no applicants", sim.CourseName, sim.Institution)
+		}
+	}
+
+	args := []interface{}{sc.CourseCode, sc.InstitutionID, sim.Year, sc.Cutoff}
+	where := "c.app_course1 = $1 AND c.inid = $2 AND c.year = $3"
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`
+        SELECT COUNT(*),
+               COUNT(*) FILTER (WHERE c.aggregate >= $4),
+               COUNT(*) FILTER (WHERE c.is_admitted),
+               COALESCE(MIN(c.aggregate) FILTER (WHERE c.is_admitted), 0)
+        FROM %s c
+        WHERE %s`, source, where), args...).Scan(&sim.Applicants, &sim.Qualifying, &sim.Admitted, &sim.ActualCutoff)
+	if err != nil {
+		return nil, fmt.Errorf("error counting applicants: %w", err)
+	}
+
+	for _, d := range dimensions {
+		order := d.order
+		if order == "" {
+			order = "applicants DESC, grp"
+		}
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+            SELECT %s AS grp,
+                   COUNT(*) AS applicants,
+                   COUNT(*) FILTER (WHERE c.aggregate >= $4),
+                   COUNT(*) FILTER (WHERE c.is_admitted)
+            FROM %s c %s
+            WHERE %s
+            GROUP BY grp
+            ORDER BY %s`, d.group, source, d.join, where, order), args...)
+		if err != nil {
+			return nil, fmt.Errorf("error breaking down applicants by %s: %w", strings.ToLower(d.name), err)
+		}
+		breakdown := Breakdown{Dimension: d.name}
+		for rows.Next() {
+			var g Group
+			if err := rows.Scan(&g.Name, &g.Applicants, &g.Qualifying, &g.Admitted); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			breakdown.Groups = append(breakdown.Groups, g)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		sim.Breakdowns = append(sim.Breakdowns, breakdown)
+	}
+	return sim, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/cutoff"
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+// handleCutoffSimulator shows who would qualify for a course at an
+// institution under hypothetical cutoffs
+func handleCutoffSimulator(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nWhat-if Cutoff Simulator")
+	institutionID, err := readInstitution(ctx, db)
+	if err != nil {
+		return err
+	}
+	courseCode, err := readCourse(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Print("Year (0 for the latest with applicants): ")
+	year := readInt()
+
+	for {
+		fmt.Print("Hypothetical cutoff (blank to finish): ")
+		input := readString()
+		if input == "" {
+			return nil
+		}
+		mark, err := strconv.Atoi(input)
+		if err != nil || mark < 0 {
+			color.Red("Enter the cutoff as a whole number")
+			continue
+		}
+
+		sim, err := cutoff.Simulate(ctx, db, currentSession.CandidateSource(), cutoff.Scenario{
+			CourseCode:    courseCode,
+			InstitutionID: institutionID,
+			Year:          year,
+			Cutoff:        mark,
+		})
+		if err != nil {
+			return err
+		}
+		year = sim.Year
+		displayCutoffSimulation(sim)
+	}
+}
+
+func displayCutoffSimulation(sim *cutoff.Simulation) {
+	color.Cyan("\n%s at %s, %d, cutoff %d", sim.CourseName, sim.Institution, sim.Year, sim.Cutoff)
+	fmt.Printf("Applicants: %d\n", sim.Applicants)
+	fmt.Printf("Would qualify: %d (admission rate %.1f%% if all are admitted)\n", sim.Qualifying, sim.AdmissionRate()*100)
+	if sim.Admitted > 0 {
+		fmt.Printf("Actually admitted: %d (%.1f%%), lowest admitted aggregate %d\n",
+			sim.Admitted, sim.ActualRate()*100, sim.ActualCutoff)
+	} else {
+		fmt.Println("Actually admitted: none recorded")
+	}
+
+	for _, breakdown := range sim.Breakdowns {
+		header := []string{breakdown.Dimension, "Applicants", "Qualifying", "Admitted"}
+		table := newReportTable("cutoff-"+strings.ReplaceAll(strings.ToLower(breakdown.Dimension), " ", "-"), header, privacy.Spec{
+			Size:   "Applicants",
+			Label:  breakdown.Dimension,
+			Counts: []string{"Qualifying", "Admitted"},
+		})
+		for _, g := range breakdown.Groups {
+			table.Append([]string{g.Name, strconv.Itoa(g.Applicants), strconv.Itoa(g.Qualifying), strconv.Itoa(g.Admitted)})
+		}
+		fmt.Printf("\nBy %s\n", strings.ToLower(breakdown.Dimension))
+		table.Render()
+	}
+}
+
+// readInstitution asks for an institution by name, abbreviation or ID and
+// returns its ID
+func readInstitution(ctx context.Context, db *sql.DB) (string, error) {
+	name, err := readEntity(ctx, db, "Institution: ", entityInstitution)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = db.QueryRowContext(ctx, `
+        SELECT inid FROM institution
+        WHERE UPPER(inname) = UPPER($1) OR UPPER(inabv) = UPPER($1) OR inid = $1
+        ORDER BY inid
+        LIMIT 1`, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("unknown institution %q", name)
+	}
+	return id, err
+}
+
+// readCourse asks for a course by name or code and returns its code,
+// asking which is meant when several codes share the name
+func readCourse(ctx context.Context, db *sql.DB) (string, error) {
+	name, err := readEntity(ctx, db, "Course: ", entityCourse)
+	if err != nil {
+		return "", err
+	}
+	rows, err := db.QueryContext(ctx, `
+        SELECT course_code FROM course
+        WHERE UPPER(course_name) = UPPER($1) OR course_code = $1
+        ORDER BY course_code`, name)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return "", err
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	switch len(codes) {
+	case 0:
+		return "", fmt.Errorf("unknown course %q", name)
+	case 1:
+		return codes[0], nil
+	}
+	fmt.Printf("%q has several course codes: %s\n", name, strings.Join(codes, ", "))
+	fmt.Print("Course code: ")
+	code := readString()
+	for _, c := range codes {
+		if strings.EqualFold(c, code) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not one of the codes for %q", code, name)
+}
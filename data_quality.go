@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/quality"
+)
+
+// runNulls reports the share of NULLs in every candidate column per year
+// and flags the columns that got worse with the latest import
+func runNulls(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("nulls")
+	year := fs.Int("year", 0, "only report this year (default every year)")
+	threshold := fs.Float64("threshold", quality.DefaultRegressionThreshold, "rise in a column's NULL percentage, in points, reported as a regression")
+	onlyRegressed := fs.Bool("regressions", false, "only list the columns that regressed")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{errors.New("nulls takes no arguments")}
+	}
+	if *threshold <= 0 {
+		return usageError{errors.New("-threshold must be positive")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	// Every year is counted, as a year is compared with the one before it
+	counts, err := quality.NullRatios(ctx, db, 0)
+	if err != nil {
+		return err
+	}
+	regressions, err := quality.Regressions(ctx, db, counts, *threshold)
+	if err != nil {
+		return err
+	}
+	type yearColumn struct {
+		year   int
+		column string
+	}
+	regressed := map[yearColumn]quality.Regression{}
+	for _, r := range regressions {
+		regressed[yearColumn{r.Year, r.Column}] = r
+	}
+
+	var rows [][]interface{}
+	flagged := 0
+	for _, c := range counts {
+		if *year != 0 && c.Year != *year {
+			continue
+		}
+		r, bad := regressed[yearColumn{c.Year, c.Column}]
+		if *onlyRegressed && !bad {
+			continue
+		}
+		row := []interface{}{c.Year, c.Column, c.Rows, c.Nulls, fmt.Sprintf("%.1f", c.Percent()), "", "", ""}
+		if bad {
+			flagged++
+			row[5], row[6], row[7] = fmt.Sprintf("%.1f", r.Before), r.Baseline, fmt.Sprintf("+%.1f", r.Rise())
+		}
+		rows = append(rows, row)
+	}
+	if len(counts) == 0 {
+		fmt.Fprintln(os.Stderr, "No candidates imported yet")
+		return nil
+	}
+	if flagged > 0 {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "%d columns have at least %.1f points more NULLs than their baseline\n", flagged, *threshold)
+	}
+	return writeResult("null-ratios", []string{"year", "column", "rows", "nulls", "null_pct", "regressed_from_pct", "baseline", "change"}, rows, *format, *output)
+}
+
+var qualityHeader = []string{"check", "year", "status", "count", "detail"}
+
+// qualityRows lays out the findings of a data quality report, one row per
+// check and year
+func qualityRows(report *quality.Report) [][]interface{} {
+	rows := make([][]interface{}, len(report.Findings))
+	for i, f := range report.Findings {
+		year, status := "all", "ok"
+		if f.Year != 0 {
+			year = strconv.Itoa(f.Year)
+		}
+		if f.Count > 0 {
+			status = "problem"
+		}
+		rows[i] = []interface{}{f.Check, year, status, f.Count, f.Detail}
+	}
+	return rows
+}
+
+// nullRateTable lays out the NULL percentage of each candidate column, one
+// row per column and one column per year. Regressed values are marked
+// with their rise.
+func nullRateTable(report *quality.Report) ([]string, [][]interface{}) {
+	type yearColumn struct {
+		year   int
+		column string
+	}
+	rise := map[yearColumn]float64{}
+	for _, r := range report.Regressions {
+		rise[yearColumn{r.Year, r.Column}] = r.Rise()
+	}
+	header := []string{"column"}
+	yearIndex := map[int]int{}
+	rowIndex := map[string]int{}
+	var rows [][]interface{}
+	for _, c := range report.Nulls {
+		if _, ok := yearIndex[c.Year]; !ok {
+			yearIndex[c.Year] = len(header)
+			header = append(header, strconv.Itoa(c.Year))
+		}
+		if _, ok := rowIndex[c.Column]; !ok {
+			rowIndex[c.Column] = len(rows)
+			rows = append(rows, []interface{}{c.Column})
+		}
+	}
+	for i := range rows {
+		for len(rows[i]) < len(header) {
+			rows[i] = append(rows[i], "")
+		}
+	}
+	for _, c := range report.Nulls {
+		cell := fmt.Sprintf("%.1f", c.Percent())
+		if r, ok := rise[yearColumn{c.Year, c.Column}]; ok {
+			cell += fmt.Sprintf(" (+%.1f)", r)
+		}
+		rows[rowIndex[c.Column]][yearIndex[c.Year]] = cell
+	}
+	return header, rows
+}
+
+// handleDataQuality runs the data quality checks and shows a summary of
+// them and the NULL rate of every column, optionally saving both as CSV
+func handleDataQuality(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nData Quality")
+	fmt.Print("Year (blank for every year): ")
+	year := 0
+	if input := readString(); input != "" {
+		var err error
+		if year, err = strconv.Atoi(input); err != nil {
+			return fmt.Errorf("invalid year %q", input)
+		}
+	}
+
+	fmt.Println("Checking codes, NULL rates, regnumbers and aggregates...")
+	report, err := quality.RunChecks(ctx, db, year, quality.DefaultRegressionThreshold)
+	if err != nil {
+		return err
+	}
+	if len(report.Nulls) == 0 {
+		color.Yellow("No candidates imported for that year")
+		return nil
+	}
+
+	rows := qualityRows(report)
+	showResult("data-quality", qualityHeader, rows, false)
+	if n := report.Problems(); n > 0 {
+		color.Yellow("%d of %d checks found problems", n, len(rows))
+	} else {
+		color.Green("Every check passed")
+	}
+
+	color.Cyan("\nNULL percentage by column and year")
+	nullHeader, nullRows := nullRateTable(report)
+	showResult("null-rates", nullHeader, nullRows, false)
+
+	fmt.Print("\nExport the report to CSV? (y/n): ")
+	if strings.ToLower(readString()) != "y" {
+		return nil
+	}
+	fmt.Print("Directory (blank for results): ")
+	dir := readString()
+	if dir == "" {
+		dir = "results"
+	}
+	out := resultOutput{format: "csv", dir: dir}
+	for _, table := range []struct {
+		name   string
+		header []string
+		rows   [][]interface{}
+	}{{"data-quality", qualityHeader, rows}, {"null-rates", nullHeader, nullRows}} {
+		path, err := out.save(table.name, table.header, table.rows)
+		if err != nil {
+			return err
+		}
+		color.Green("Saved %s", path)
+	}
+	return nil
+}
+
+// runQuality runs the data quality checks and writes their summary
+func runQuality(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("quality")
+	year := fs.Int("year", 0, "only check this year (default every year)")
+	threshold := fs.Float64("threshold", quality.DefaultRegressionThreshold, "rise in a column's NULL percentage, in points, reported as a regression")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{errors.New("quality takes no arguments")}
+	}
+	if *threshold <= 0 {
+		return usageError{errors.New("-threshold must be positive")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	report, err := quality.RunChecks(ctx, db, *year, *threshold)
+	if err != nil {
+		return err
+	}
+	if n := report.Problems(); n > 0 {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "%d of %d checks found problems\n", n, len(report.Findings))
+	}
+	return writeResult("data-quality", qualityHeader, qualityRows(report), *format, *output)
+}
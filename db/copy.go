@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+var errNotPgx = errors.New("not a pgx connection")
+
+// CopyIn bulk loads rows into table with COPY. tx must have been begun on
+// conn; the rows are only visible once it commits. pgx connections use the
+// COPY protocol directly, other drivers go through lib/pq's CopyIn.
+func CopyIn(ctx context.Context, conn *sql.Conn, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	err := conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errNotPgx
+		}
+		_, err := c.Conn().CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, pgx.CopyFromRows(rows))
+		return err
+	})
+	if !errors.Is(err, errNotPgx) {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return fmt.Errorf("error starting copy: %w", err)
+	}
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
@@ -0,0 +1,413 @@
+// Package db opens the PostgreSQL connection pools shared by the menu, the
+// command line, the API server and the natural language query engine.
+// Settings come from DB_* environment variables; see ConfigFromEnv.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// Drivers
+const (
+	DriverPgx = "pgx" // pgxpool behind database/sql; the default
+	DriverPq  = "pq"  // lib/pq, for deployments that depend on its behaviour
+)
+
+// Config describes how to reach the database and size the pools
+type Config struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+
+	SSLMode     string // disable, allow, prefer, require, verify-ca or verify-full
+	SSLRootCert string // CA certificate for verify-ca and verify-full
+
+	Driver           string
+	MaxConns         int
+	MinConns         int // pgx only: connections kept open when idle
+	MaxIdleConns     int // pq only
+	ConnMaxLifetime  time.Duration
+	StatementTimeout time.Duration // 0 leaves the server default
+
+	// WaitTimeout is how long Open keeps retrying a database that is not
+	// accepting connections yet, e.g. while a container starts; 0 tries
+	// once. RetryBackoff is the first delay, doubled after each attempt.
+	WaitTimeout  time.Duration
+	RetryBackoff time.Duration
+
+	// PasswordFunc, when set, supplies the password for each new pgx
+	// connection, so a rotated password is used without a restart
+	PasswordFunc func(ctx context.Context) (string, error)
+
+	// ReplicaHost is a read-only replica, or a comma separated list of
+	// them, for analytics queries. Blank sends them to the primary.
+	ReplicaHost string
+
+	// SlowQueryThreshold is the duration from which queries are handed to
+	// Tracer to be recorded; 0 records none. pgx only.
+	SlowQueryThreshold time.Duration
+	// SlowQueryParams records the text parameters of slow queries, which
+	// may hold names and other personal data, instead of redacting them
+	SlowQueryParams bool
+	// Tracer, when set, traces every query on the pgx pools
+	Tracer pgx.QueryTracer
+}
+
+// ConfigError lists every problem found in the database settings
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid database settings: " + strings.Join(e.Problems, "; ")
+}
+
+// ConfigFromEnv reads DB_HOST, DB_PORT, DB_USER, DB_PASSWORD and DB_NAME,
+// and the optional DB_SSLMODE (default disable), DB_SSLROOTCERT, DB_DRIVER
+// (pgx or pq), DB_MAX_CONNS (25), DB_MIN_CONNS (0), DB_MAX_IDLE_CONNS (5),
+// DB_CONN_MAX_LIFETIME (5m), DB_STATEMENT_TIMEOUT, DB_WAIT_TIMEOUT (0),
+// DB_RETRY_BACKOFF (500ms), DB_REPLICA_HOST, DB_SLOW_QUERY_THRESHOLD (0) and
+// DB_SLOW_QUERY_PARAMS (false).
+// Every missing or invalid setting is reported in one *ConfigError.
+func ConfigFromEnv() (Config, error) {
+	c := Config{
+		Host:        os.Getenv("DB_HOST"),
+		Port:        os.Getenv("DB_PORT"),
+		User:        os.Getenv("DB_USER"),
+		Password:    os.Getenv("DB_PASSWORD"),
+		Name:        os.Getenv("DB_NAME"),
+		SSLMode:     envOr("DB_SSLMODE", "disable"),
+		SSLRootCert: os.Getenv("DB_SSLROOTCERT"),
+		Driver:      strings.ToLower(envOr("DB_DRIVER", DriverPgx)),
+		ReplicaHost: os.Getenv("DB_REPLICA_HOST"),
+	}
+	var problems []string
+	for _, key := range []string{"DB_HOST", "DB_PORT", "DB_USER", "DB_NAME"} {
+		if os.Getenv(key) == "" {
+			problems = append(problems, key+" is not set")
+		}
+	}
+	if c.Port != "" {
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("DB_PORT %q is not a port number", c.Port))
+		}
+	}
+	var err error
+	if c.MaxConns, err = envInt("DB_MAX_CONNS", 25); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.MinConns, err = envInt("DB_MIN_CONNS", 0); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.MaxIdleConns, err = envInt("DB_MAX_IDLE_CONNS", 5); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.ConnMaxLifetime, err = envDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.StatementTimeout, err = envDuration("DB_STATEMENT_TIMEOUT", 0); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.WaitTimeout, err = envDuration("DB_WAIT_TIMEOUT", 0); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.RetryBackoff, err = envDuration("DB_RETRY_BACKOFF", 500*time.Millisecond); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.SlowQueryThreshold, err = envDuration("DB_SLOW_QUERY_THRESHOLD", 0); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.SlowQueryParams, err = envBool("DB_SLOW_QUERY_PARAMS", false); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) == 0 {
+		if err := c.Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return c, &ConfigError{Problems: problems}
+	}
+	return c, nil
+}
+
+// Validate checks the settings that would otherwise fail on first use
+func (c Config) Validate() error {
+	switch c.Driver {
+	case DriverPgx, DriverPq:
+	default:
+		return fmt.Errorf("DB_DRIVER must be %s or %s, not %q", DriverPgx, DriverPq, c.Driver)
+	}
+	switch c.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		return fmt.Errorf("DB_SSLMODE %q must be disable, allow, prefer, require, verify-ca or verify-full", c.SSLMode)
+	}
+	if c.MaxConns <= 0 {
+		return fmt.Errorf("DB_MAX_CONNS must be positive")
+	}
+	if c.Driver == DriverPq && strings.Contains(c.ReplicaHost, ",") {
+		return fmt.Errorf("the pq driver supports a single DB_REPLICA_HOST")
+	}
+	if c.Driver == DriverPq && c.SlowQueryThreshold > 0 {
+		return fmt.Errorf("DB_SLOW_QUERY_THRESHOLD needs the %s driver", DriverPgx)
+	}
+	return nil
+}
+
+// DSN returns the key=value connection string for the primary
+func (c Config) DSN() string {
+	return c.dsn(c.Host)
+}
+
+func (c Config) dsn(host string) string {
+	params := []string{
+		"host=" + quote(host),
+		"port=" + quote(c.Port),
+		"user=" + quote(c.User),
+		"password=" + quote(c.Password),
+		"dbname=" + quote(c.Name),
+		"sslmode=" + quote(c.SSLMode),
+	}
+	if c.SSLRootCert != "" {
+		params = append(params, "sslrootcert="+quote(c.SSLRootCert))
+	}
+	if c.StatementTimeout > 0 {
+		// Both drivers pass unknown keys on as run-time parameters
+		params = append(params, fmt.Sprintf("statement_timeout=%d", c.StatementTimeout.Milliseconds()))
+	}
+	return strings.Join(params, " ")
+}
+
+// quote escapes a connection string value
+func quote(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+// Pools holds the primary pool and the pool analytics queries run on,
+// which is the primary when no replica is configured
+type Pools struct {
+	Primary   *sql.DB
+	Analytics *sql.DB
+	closers   []func()
+}
+
+// Open connects to the primary, and to the replica if one is configured,
+// and checks both respond within ctx. The primary is retried for up to
+// c.WaitTimeout while it refuses connections.
+func Open(ctx context.Context, c Config) (*Pools, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	pools := &Pools{}
+	primary, err := pools.openWithRetry(ctx, c, c.DSN())
+	if err != nil {
+		pools.Close()
+		return nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+	pools.Primary, pools.Analytics = primary, primary
+
+	if c.ReplicaHost != "" {
+		dsn := c.dsn(c.ReplicaHost)
+		if c.Driver == DriverPgx {
+			dsn += " target_session_attrs=prefer-standby"
+		}
+		replica, err := pools.open(ctx, c, dsn)
+		if err != nil {
+			pools.Close()
+			return nil, fmt.Errorf("error connecting to replica: %w", err)
+		}
+		pools.Analytics = replica
+	}
+	return pools, nil
+}
+
+// maxRetryBackoff caps the delay between connection attempts
+const maxRetryBackoff = 10 * time.Second
+
+// openWithRetry opens a pool, retrying with exponential backoff and jitter
+// until c.WaitTimeout has passed. Authentication failures are not retried.
+func (p *Pools) openWithRetry(ctx context.Context, c Config, dsn string) (*sql.DB, error) {
+	if c.WaitTimeout <= 0 {
+		return p.open(ctx, c, dsn)
+	}
+	deadline := time.Now().Add(c.WaitTimeout)
+	backoff := c.RetryBackoff
+	if backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	for attempt := 1; ; attempt++ {
+		db, err := p.open(ctx, c, dsn)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Connected to database after %d attempts", attempt)
+			}
+			return db, nil
+		}
+		if !retryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		// Full jitter between half and all of the backoff keeps restarted
+		// services from reconnecting in lockstep
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("database not ready after %s: %w", c.WaitTimeout, err)
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		log.Printf("Database not ready (attempt %d): %v; retrying in %s", attempt, err, delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// retryable reports whether a connection error may clear up by itself.
+// Bad credentials and other invalid authorization (SQLSTATE class 28)
+// will not.
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return !strings.HasPrefix(pgErr.Code, "28")
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Class() != "28"
+	}
+	return true
+}
+
+// open opens one pool and pings it, closing it again if the ping fails
+func (p *Pools) open(ctx context.Context, c Config, dsn string) (*sql.DB, error) {
+	var db *sql.DB
+	var closers []func()
+	if c.Driver == DriverPq {
+		var err error
+		if db, err = sql.Open("postgres", dsn); err != nil {
+			return nil, err
+		}
+		db.SetMaxOpenConns(c.MaxConns)
+		db.SetMaxIdleConns(c.MaxIdleConns)
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	} else {
+		poolConfig, err := pgxpool.ParseConfig(dsn)
+		if err != nil {
+			return nil, err
+		}
+		if c.PasswordFunc != nil {
+			poolConfig.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+				password, err := c.PasswordFunc(ctx)
+				if err != nil {
+					return err
+				}
+				cc.Password = password
+				return nil
+			}
+		}
+		poolConfig.ConnConfig.Tracer = c.Tracer
+		poolConfig.MaxConns = int32(c.MaxConns)
+		poolConfig.MinConns = int32(c.MinConns)
+		if c.ConnMaxLifetime > 0 {
+			poolConfig.MaxConnLifetime = c.ConnMaxLifetime
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, pool.Close)
+		db = stdlib.OpenDBFromPool(pool)
+	}
+	closers = append(closers, func() { db.Close() })
+
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+		return nil, err
+	}
+	p.closers = append(p.closers, closers...)
+	return db, nil
+}
+
+// Close closes every pool, the database/sql handles before the pgx pools
+// behind them
+func (p *Pools) Close() {
+	for i := len(p.closers) - 1; i >= 0; i-- {
+		p.closers[i]()
+	}
+	p.closers = nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not a whole number", key, raw)
+	}
+	return n, nil
+}
+
+func envBool(key string, def bool) (bool, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s %q is not true or false", key, raw)
+	}
+	return b, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s %q is not a duration such as 30s or 5m", key, raw)
+	}
+	return d, nil
+}
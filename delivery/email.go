@@ -0,0 +1,151 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MailConfig is an SMTP server generated files are emailed through
+type MailConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// MailConfigFromEnv reads SMTP_HOST, SMTP_PORT (default 587), SMTP_USER,
+// SMTP_PASSWORD and SMTP_FROM. It returns nil when no server is configured.
+func MailConfigFromEnv() (*MailConfig, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, nil
+	}
+	cfg := &MailConfig{
+		Host:     host,
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+	if cfg.From == "" {
+		cfg.From = cfg.Username
+	}
+	if !strings.Contains(cfg.From, "@") {
+		return nil, fmt.Errorf("SMTP_FROM %q is not an email address", cfg.From)
+	}
+	return cfg, nil
+}
+
+// SendFile emails a generated file as an attachment. The connection is
+// upgraded with STARTTLS when the server offers it, and credentials are
+// only sent over TLS.
+func (c *MailConfig) SendFile(ctx context.Context, to []string, subject, body, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", file, err)
+	}
+	msg, err := c.message(to, subject, body, filepath.Base(file), data)
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(c.Host, c.Port))
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error starting SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+	if c.Username != "" {
+		// PlainAuth refuses to send the password without TLS, except to localhost
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(c.From); err != nil {
+		return fmt.Errorf("SMTP server refused sender %s: %w", c.From, err)
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return fmt.Errorf("SMTP server refused recipient %s: %w", addr, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server refused the message: %w", err)
+	}
+	return client.Quit()
+}
+
+// message builds a multipart MIME message with the file attached
+func (c *MailConfig) message(to []string, subject, body, name string, data []byte) ([]byte, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	boundary := fmt.Sprintf("spk2-%x", b)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+
+	fmt.Fprintf(&msg, "--%s\r\n", boundary)
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	fmt.Fprintf(&msg, "--%s\r\n", boundary)
+	fmt.Fprintf(&msg, "Content-Type: %s\r\n", contentType)
+	msg.WriteString("Content-Transfer-Encoding: base64\r\n")
+	fmt.Fprintf(&msg, "Content-Disposition: attachment; filename=%q\r\n\r\n", name)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		msg.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	msg.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&msg, "--%s--\r\n", boundary)
+	return msg.Bytes(), nil
+}
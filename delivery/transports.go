@@ -0,0 +1,157 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const dialTimeout = 30 * time.Second
+
+type sftpUploader struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+func dialSFTP(ctx context.Context, cfg *Config) (uploader, error) {
+	var auth []ssh.AuthMethod
+	if password, ok := cfg.URL.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+	if cfg.KeyFile != "" {
+		key, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing SSH key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("no SFTP credentials: set a password in DELIVERY_URL or DELIVERY_SSH_KEY")
+	}
+
+	hostKeys, err := knownhosts.New(cfg.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading known hosts %s: %w", cfg.KnownHostsFile, err)
+	}
+
+	addr := cfg.URL.Host
+	if cfg.URL.Port() == "" {
+		addr = net.JoinHostPort(cfg.URL.Hostname(), "22")
+	}
+
+	netConn, err := (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, &ssh.ClientConfig{
+		User:            cfg.URL.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         dialTimeout,
+	})
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("SSH handshake failed: %w", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error starting SFTP session: %w", err)
+	}
+	return &sftpUploader{ssh: client, sftp: sftpClient}, nil
+}
+
+// Upload writes to a temporary name and renames it, so consumers polling
+// the directory never pick up a partial file.
+func (u *sftpUploader) Upload(ctx context.Context, localPath, remotePath string) error {
+	in, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := u.sftp.MkdirAll(path.Dir(remotePath)); err != nil {
+		return fmt.Errorf("error creating remote directory: %w", err)
+	}
+
+	tmp := remotePath + ".part"
+	out, err := u.sftp.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", tmp, err)
+	}
+	if err := copyWithContext(ctx, out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("error uploading %s: %w", localPath, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	u.sftp.Remove(remotePath)
+	return u.sftp.Rename(tmp, remotePath)
+}
+
+func (u *sftpUploader) Close() error {
+	u.sftp.Close()
+	return u.ssh.Close()
+}
+
+type ftpUploader struct {
+	conn *ftp.ServerConn
+}
+
+func dialFTP(ctx context.Context, cfg *Config) (uploader, error) {
+	addr := cfg.URL.Host
+	if cfg.URL.Port() == "" {
+		addr = net.JoinHostPort(cfg.URL.Hostname(), "21")
+	}
+
+	conn, err := ftp.Dial(addr, ftp.DialWithContext(ctx), ftp.DialWithTimeout(dialTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+
+	password, _ := cfg.URL.User.Password()
+	if err := conn.Login(cfg.URL.User.Username(), password); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("FTP login failed: %w", err)
+	}
+	return &ftpUploader{conn: conn}, nil
+}
+
+func (u *ftpUploader) Upload(ctx context.Context, localPath, remotePath string) error {
+	in, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := remotePath + ".part"
+	if err := u.conn.Stor(tmp, in); err != nil {
+		return fmt.Errorf("error uploading %s: %w", localPath, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	u.conn.Delete(remotePath)
+	return u.conn.Rename(tmp, remotePath)
+}
+
+func (u *ftpUploader) Close() error {
+	return u.conn.Quit()
+}
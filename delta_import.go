@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/importer"
+	"github.com/olekukonko/tablewriter"
+)
+
+// runDeltaImport applies (or previews) a delta file and prints the
+// per-column change summary
+func runDeltaImport(ctx context.Context, db *sql.DB, config importer.ImportConfig, reader *csv.Reader) error {
+	fmt.Println("\nComparing delta file with current values...")
+	summary, err := importer.ImportDelta(ctx, db, config, reader)
+	if summary != nil {
+		printDeltaSummary(summary)
+	}
+	if err != nil {
+		return fmt.Errorf("delta import error: %w", err)
+	}
+
+	if !summary.Applied {
+		color.Yellow("Preview only: no changes were applied")
+		return nil
+	}
+	color.Green("Delta import completed: %d candidates updated, %d inserted", summary.Changed, summary.Inserted)
+	if summary.Changed > 0 {
+		fmt.Println("Previous values are recorded in candidate_changes.")
+	}
+	return nil
+}
+
+func printDeltaSummary(summary *importer.DeltaSummary) {
+	color.Yellow("\nDelta Summary")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Outcome", "Candidates"})
+	table.Append([]string{"Rows read", fmt.Sprintf("%d", summary.Rows)})
+	table.Append([]string{"Changed", fmt.Sprintf("%d", summary.Changed)})
+	table.Append([]string{"Unchanged", fmt.Sprintf("%d", summary.Unchanged)})
+	table.Append([]string{"New", fmt.Sprintf("%d", summary.Inserted)})
+	table.Append([]string{"Failed", fmt.Sprintf("%d", summary.Failed)})
+	table.Render()
+
+	if len(summary.ColumnChanges) == 0 {
+		return
+	}
+	color.Yellow("\nChanges by Column")
+	table = tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Column", "Values Changed"})
+	for _, column := range summary.Columns() {
+		table.Append([]string{column, fmt.Sprintf("%d", summary.ColumnChanges[column])})
+	}
+	table.Render()
+}
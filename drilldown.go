@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/notes"
+	"github.com/nonsonwune/spk2_db/privacy"
+	"github.com/nonsonwune/spk2_db/reports"
+)
+
+// drillStep is a row drilled into: the candidates are restricted to it
+type drillStep struct {
+	entity reports.Entity
+	key    interface{}
+	label  string
+}
+
+// drillRow is one row of a breakdown
+type drillRow struct {
+	key        interface{}
+	label      string
+	candidates int64
+	avgScore   sql.NullFloat64
+	admitted   int64
+}
+
+// handleDrillDown starts the drill-down navigator at the states
+func handleDrillDown(ctx context.Context, db *sql.DB) error {
+	fmt.Print("Year (Enter for all years): ")
+	year := 0
+	if input := readString(); input != "" {
+		y, err := strconv.Atoi(input)
+		if err != nil {
+			return fmt.Errorf("invalid year %q", input)
+		}
+		year = y
+	}
+	return drillDown(ctx, db, year, nil, reports.EntityState)
+}
+
+// offerDrillDown follows a report listing entities by name, letting the
+// user jump into the navigator at one of them
+func offerDrillDown(ctx context.Context, db *sql.DB, entity reports.Entity, latestYear bool) error {
+	fmt.Printf("Drill into a %s (enter its name, or press Enter to return): ", entity.Name)
+	name := readString()
+	if name == "" {
+		return nil
+	}
+	var key interface{}
+	err := db.QueryRowContext(ctx, entity.Lookup(), name).Scan(&key)
+	if err == sql.ErrNoRows {
+		color.Yellow("No %s named %s", entity.Name, name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error looking up %s: %w", entity.Name, err)
+	}
+
+	year := 0
+	if latestYear {
+		var latest sql.NullInt64
+		query := fmt.Sprintf("SELECT MAX(year) FROM %s c", currentSession.CandidateSource())
+		if err := db.QueryRowContext(ctx, query).Scan(&latest); err != nil {
+			return fmt.Errorf("error finding the latest year: %w", err)
+		}
+		year = int(latest.Int64)
+	}
+	step := drillStep{entity: entity, key: key, label: strings.ToUpper(name)}
+	next := pickDrillDown(step)
+	if next.Name == "" {
+		return nil
+	}
+	return drillDown(ctx, db, year, []drillStep{step}, next)
+}
+
+// drillDown shows the candidates under path broken down by level. Picking
+// a row and then a breakdown goes one level deeper; 'b' goes back up.
+func drillDown(ctx context.Context, db *sql.DB, year int, path []drillStep, level reports.Entity) error {
+	for {
+		rows, err := queryBreakdown(ctx, db, year, path, level)
+		if err != nil {
+			return err
+		}
+		rows, withheld := publishable(rows)
+		showBreakdown(year, path, level, rows, withheld)
+		if len(path) > 0 {
+			step := path[len(path)-1]
+			if kind, err := notes.ParseKind(step.entity.Name); err == nil && kind != notes.Import {
+				printNotes(ctx, db, kind, fmt.Sprint(step.key), step.label)
+			}
+		}
+
+		if len(rows) == 0 && len(path) == 0 {
+			return nil
+		}
+		fmt.Print("Row number to drill into, 'b' to go back, or press Enter to return: ")
+		input := strings.ToLower(readString())
+		switch {
+		case input == "":
+			return nil
+		case input == "b":
+			if len(path) == 0 {
+				return nil
+			}
+			level = path[len(path)-1].entity
+			path = path[:len(path)-1]
+		default:
+			n, err := strconv.Atoi(input)
+			if err != nil || n < 1 || n > len(rows) {
+				color.Red("Enter a row number between 1 and %d", len(rows))
+				continue
+			}
+			step := drillStep{entity: level, key: rows[n-1].key, label: rows[n-1].label}
+			next := pickDrillDown(step)
+			if next.Name == "" {
+				continue
+			}
+			path = append(path, step)
+			level = next
+		}
+	}
+}
+
+// pickDrillDown asks which breakdown to show under step, returning a zero
+// Entity if the user cancels
+func pickDrillDown(step drillStep) reports.Entity {
+	choices := reports.DrillDowns[step.entity.Name]
+	if len(choices) == 1 {
+		return choices[0]
+	}
+	fmt.Printf("Break %s down by:\n", step.label)
+	for i, e := range choices {
+		fmt.Printf("%d. %s\n", i+1, e.Title)
+	}
+	fmt.Print("Choice (Enter to cancel): ")
+	n, err := strconv.Atoi(readString())
+	if err != nil || n < 1 || n > len(choices) {
+		return reports.Entity{}
+	}
+	return choices[n-1]
+}
+
+func queryBreakdown(ctx context.Context, db *sql.DB, year int, path []drillStep, level reports.Entity) ([]drillRow, error) {
+	source := currentSession.CandidateSource()
+	var args []interface{}
+	if year != 0 {
+		args = append(args, year)
+		source = reports.Restrict(source, "year", len(args))
+	}
+	for _, step := range path {
+		args = append(args, step.key)
+		source = reports.Restrict(source, step.entity.Column, len(args))
+	}
+
+	rows, err := db.QueryContext(ctx, reports.Breakdown(level, source), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s breakdown: %w", level.Name, err)
+	}
+	defer rows.Close()
+
+	var result []drillRow
+	for rows.Next() {
+		var r drillRow
+		if err := rows.Scan(&r.key, &r.label, &r.candidates, &r.avgScore, &r.admitted); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// publishable drops groups under k in public output mode before the rows are
+// numbered, so every numbered row can be shown and drilled into
+func publishable(rows []drillRow) ([]drillRow, int) {
+	if publicOutput == nil {
+		return rows, 0
+	}
+	var kept []drillRow
+	for _, r := range rows {
+		if r.candidates >= int64(publicOutput.K) {
+			kept = append(kept, r)
+		}
+	}
+	return kept, len(rows) - len(kept)
+}
+
+// showBreakdown prints the path so far and the numbered breakdown
+func showBreakdown(year int, path []drillStep, level reports.Entity, rows []drillRow, withheld int) {
+	crumbs := []string{"All candidates"}
+	if year != 0 {
+		crumbs[0] = fmt.Sprintf("All candidates (%d)", year)
+	}
+	for _, step := range path {
+		crumbs = append(crumbs, step.label)
+	}
+	color.Cyan("\n%s > %s", strings.Join(crumbs, " > "), level.Title)
+
+	if len(rows) == 0 {
+		color.Yellow("No candidates to show")
+		if withheld > 0 {
+			color.Yellow("%d groups under %d candidates withheld", withheld, publicOutput.K)
+		}
+		return
+	}
+
+	table := newReportTable("drilldown-"+level.Name, []string{"#", "Name", "Candidates", "Avg Score", "Admitted"},
+		privacy.Spec{Size: "Candidates", Label: "Name", Counts: []string{"Admitted"}, Means: []string{"Avg Score"}})
+	for i, r := range rows {
+		avg := "N/A"
+		if r.avgScore.Valid {
+			avg = fmt.Sprintf("%.2f", r.avgScore.Float64)
+		}
+		table.Append([]string{
+			strconv.Itoa(i + 1),
+			r.label,
+			strconv.FormatInt(r.candidates, 10),
+			avg,
+			strconv.FormatInt(r.admitted, 10),
+		})
+	}
+	table.Render()
+	if withheld > 0 {
+		color.Yellow("%d groups under %d candidates withheld", withheld, publicOutput.K)
+	}
+	if len(rows) == reports.BreakdownLimit {
+		fmt.Printf("Showing the %d largest groups\n", reports.BreakdownLimit)
+	}
+}
@@ -0,0 +1,311 @@
+// Package enrichment proposes corrections for incomplete reference data and
+// applies them once a reviewer has approved them.
+package enrichment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Suggestion sources
+const (
+	SourceHistory     = "historical_mapping"
+	SourceAbbrevMatch = "abbreviation_match"
+	SourceLLM         = "llm"
+)
+
+// Suggestion statuses
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// TextGenerator produces free text from a prompt. The NL query engine
+// satisfies this so suggestions can optionally be LLM-assisted.
+type TextGenerator interface {
+	GenerateText(ctx context.Context, prompt string) (string, error)
+}
+
+// CourseNameSuggestion is a proposed name for a course with a placeholder name
+type CourseNameSuggestion struct {
+	ID            int
+	CourseCode    string
+	CurrentName   string
+	SuggestedName string
+	Source        string
+	Confidence    float64
+	Rationale     string
+	Status        string
+	CreatedAt     time.Time
+}
+
+// PlaceholderCourse is a course whose name was generated from its code
+type PlaceholderCourse struct {
+	CourseCode   string
+	CourseName   string
+	Abbreviation string
+	Institutions []string // names of institutions candidates applied to with this course
+}
+
+// CourseNameEnricher finds courses with placeholder names ("Course 112838K"),
+// queues suggested names for review and applies approved names with an audit trail.
+type CourseNameEnricher struct {
+	db        *sql.DB
+	generator TextGenerator // optional
+}
+
+func NewCourseNameEnricher(db *sql.DB, generator TextGenerator) *CourseNameEnricher {
+	return &CourseNameEnricher{
+		db:        db,
+		generator: generator,
+	}
+}
+
+// PlaceholderCourses returns courses still named after their code that have no
+// pending suggestion, up to limit (0 for all).
+func (e *CourseNameEnricher) PlaceholderCourses(ctx context.Context, limit int) ([]PlaceholderCourse, error) {
+	query := `
+        SELECT co.course_code, co.course_name, COALESCE(co.course_abbreviation, ''),
+               COALESCE(ARRAY_TO_STRING(ARRAY(
+                   SELECT DISTINCT i.inname
+                   FROM candidate c
+                   JOIN institution i ON c.inid = i.inid
+                   WHERE c.app_course1 = co.course_code
+                   LIMIT 3
+               ), '|'), '')
+        FROM course co
+        WHERE co.course_name LIKE 'Course %'
+        AND NOT EXISTS (
+            SELECT 1 FROM course_name_suggestions s
+            WHERE s.course_code = co.course_code AND s.status = 'pending'
+        )
+        ORDER BY co.course_code`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := e.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error listing placeholder courses: %w", err)
+	}
+	defer rows.Close()
+
+	var courses []PlaceholderCourse
+	for rows.Next() {
+		var c PlaceholderCourse
+		var institutions string
+		if err := rows.Scan(&c.CourseCode, &c.CourseName, &c.Abbreviation, &institutions); err != nil {
+			return nil, err
+		}
+		if institutions != "" {
+			c.Institutions = strings.Split(institutions, "|")
+		}
+		courses = append(courses, c)
+	}
+	return courses, rows.Err()
+}
+
+// SuggestFromHistory queues names recorded for the code in course_code_mappings
+// and names of other courses sharing the same abbreviation. It returns the
+// number of suggestions queued.
+func (e *CourseNameEnricher) SuggestFromHistory(ctx context.Context) (int, error) {
+	queries := []struct {
+		source string
+		query  string
+	}{
+		{SourceHistory, `
+            INSERT INTO course_name_suggestions
+                (course_code, current_name, suggested_name, source, confidence, rationale)
+            SELECT DISTINCT ON (co.course_code)
+                co.course_code, co.course_name, m.course_name, $1, 0.9,
+                'Recorded in course_code_mappings for ' || COALESCE(m.year::text, 'unknown year')
+            FROM course co
+            JOIN course_code_mappings m
+                ON m.new_course_code = co.course_code OR m.old_course_code = co.course_code
+            WHERE co.course_name LIKE 'Course %'
+            AND m.course_name IS NOT NULL AND m.course_name NOT LIKE 'Course %'
+            ORDER BY co.course_code, m.mapping_date DESC NULLS LAST
+            ON CONFLICT (course_code, suggested_name) DO NOTHING`},
+		{SourceAbbrevMatch, `
+            INSERT INTO course_name_suggestions
+                (course_code, current_name, suggested_name, source, confidence, rationale)
+            SELECT DISTINCT ON (co.course_code)
+                co.course_code, co.course_name, other.course_name, $1, 0.6,
+                'Shares abbreviation ' || co.course_abbreviation || ' with course ' || other.course_code
+            FROM course co
+            JOIN course other
+                ON other.course_abbreviation = co.course_abbreviation
+                AND other.course_code <> co.course_code
+            WHERE co.course_name LIKE 'Course %'
+            AND COALESCE(co.course_abbreviation, '') <> ''
+            AND other.course_name NOT LIKE 'Course %'
+            AND NOT EXISTS (
+                SELECT 1 FROM course_name_suggestions s
+                WHERE s.course_code = co.course_code AND s.status = 'pending'
+            )
+            ORDER BY co.course_code, other.course_name
+            ON CONFLICT (course_code, suggested_name) DO NOTHING`},
+	}
+
+	total := 0
+	for _, q := range queries {
+		res, err := e.db.ExecContext(ctx, q.query, q.source)
+		if err != nil {
+			return total, fmt.Errorf("error generating %s suggestions: %w", q.source, err)
+		}
+		n, _ := res.RowsAffected()
+		total += int(n)
+	}
+	return total, nil
+}
+
+// SuggestWithLLM asks the configured generator to propose names for the given
+// courses. Courses the model cannot name with confidence are skipped.
+func (e *CourseNameEnricher) SuggestWithLLM(ctx context.Context, courses []PlaceholderCourse) (int, error) {
+	if e.generator == nil {
+		return 0, fmt.Errorf("no LLM generator configured")
+	}
+
+	queued := 0
+	for _, course := range courses {
+		select {
+		case <-ctx.Done():
+			return queued, ctx.Err()
+		default:
+		}
+
+		resp, err := e.generator.GenerateText(ctx, buildCourseNamePrompt(course))
+		if err != nil {
+			return queued, fmt.Errorf("error generating suggestion for %s: %w", course.CourseCode, err)
+		}
+
+		var proposal struct {
+			CourseName string  `json:"course_name"`
+			Confidence float64 `json:"confidence"`
+			Rationale  string  `json:"rationale"`
+		}
+		if err := json.Unmarshal([]byte(cleanJSON(resp)), &proposal); err != nil {
+			continue
+		}
+		name := strings.ToUpper(strings.TrimSpace(proposal.CourseName))
+		if name == "" || strings.HasPrefix(name, "COURSE ") {
+			continue
+		}
+
+		if _, err := e.db.ExecContext(ctx, `
+            INSERT INTO course_name_suggestions
+                (course_code, current_name, suggested_name, source, confidence, rationale)
+            VALUES ($1, $2, $3, $4, $5, $6)
+            ON CONFLICT (course_code, suggested_name) DO NOTHING`,
+			course.CourseCode, course.CourseName, name, SourceLLM, proposal.Confidence, proposal.Rationale); err != nil {
+			return queued, fmt.Errorf("error queueing suggestion for %s: %w", course.CourseCode, err)
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+func buildCourseNamePrompt(course PlaceholderCourse) string {
+	institutions := "unknown"
+	if len(course.Institutions) > 0 {
+		institutions = strings.Join(course.Institutions, "; ")
+	}
+	return fmt.Sprintf(`A Nigerian university admissions database has a course with a placeholder name.
+
+Course code: %s
+Abbreviation: %s
+Institutions candidates applied to with this course: %s
+
+Propose the most likely official course name in UPPER CASE (e.g. "COMPUTER SCIENCE").
+If you cannot infer it, return an empty course_name.
+
+Return ONLY JSON with NO markdown formatting:
+{"course_name": "...", "confidence": 0.0-1.0, "rationale": "one sentence"}`,
+		course.CourseCode, course.Abbreviation, institutions)
+}
+
+func cleanJSON(resp string) string {
+	resp = strings.ReplaceAll(resp, "```json", "")
+	resp = strings.ReplaceAll(resp, "```", "")
+	return strings.TrimSpace(resp)
+}
+
+// PendingSuggestions returns queued suggestions awaiting review, best first
+func (e *CourseNameEnricher) PendingSuggestions(ctx context.Context, limit int) ([]CourseNameSuggestion, error) {
+	rows, err := e.db.QueryContext(ctx, `
+        SELECT id, course_code, COALESCE(current_name, ''), suggested_name, source,
+               COALESCE(confidence, 0), COALESCE(rationale, ''), status, created_at
+        FROM course_name_suggestions
+        WHERE status = 'pending'
+        ORDER BY confidence DESC, course_code
+        LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing suggestions: %w", err)
+	}
+	defer rows.Close()
+
+	var suggestions []CourseNameSuggestion
+	for rows.Next() {
+		var s CourseNameSuggestion
+		if err := rows.Scan(&s.ID, &s.CourseCode, &s.CurrentName, &s.SuggestedName, &s.Source,
+			&s.Confidence, &s.Rationale, &s.Status, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+// Approve applies a suggestion (optionally with an edited name), records the
+// change in course_name_audit and closes any competing suggestions.
+func (e *CourseNameEnricher) Approve(ctx context.Context, s CourseNameSuggestion, name, reviewer string) error {
+	if name == "" {
+		name = s.SuggestedName
+	}
+
+	tx, err := e.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldName sql.NullString
+	if err := tx.QueryRowContext(ctx,
+		`SELECT course_name FROM course WHERE course_code = $1 FOR UPDATE`, s.CourseCode).Scan(&oldName); err != nil {
+		return fmt.Errorf("error loading course %s: %w", s.CourseCode, err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE course SET course_name = $1 WHERE course_code = $2`, name, s.CourseCode); err != nil {
+		return fmt.Errorf("error updating course %s: %w", s.CourseCode, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO course_name_audit (course_code, old_name, new_name, suggestion_id, changed_by)
+        VALUES ($1, $2, $3, $4, $5)`, s.CourseCode, oldName, name, s.ID, reviewer); err != nil {
+		return fmt.Errorf("error recording audit entry: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE course_name_suggestions
+        SET status = CASE WHEN id = $1 THEN 'approved' ELSE 'rejected' END,
+            reviewed_at = NOW()
+        WHERE course_code = $2 AND status = 'pending'`, s.ID, s.CourseCode); err != nil {
+		return fmt.Errorf("error updating suggestion status: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// Reject marks a suggestion as rejected
+func (e *CourseNameEnricher) Reject(ctx context.Context, id int) error {
+	_, err := e.db.ExecContext(ctx, `
+        UPDATE course_name_suggestions SET status = 'rejected', reviewed_at = NOW()
+        WHERE id = $1`, id)
+	return err
+}
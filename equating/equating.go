@@ -0,0 +1,146 @@
+// Package equating places aggregates from different years on a common scale
+// so trends aren't distorted by shifts in score distributions.
+package equating
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Equating methods
+const (
+	// MethodPercentile maps each candidate's percentile rank in their year to
+	// the aggregate at the same percentile in the reference year
+	MethodPercentile = "percentile"
+	// MethodZScore rescales aggregates to the reference year's mean and
+	// standard deviation
+	MethodZScore = "zscore"
+)
+
+// quantileSteps is the resolution of the reference distribution used for
+// percentile matching
+const quantileSteps = 1000
+
+// Run records how a year's equated aggregates were computed
+type Run struct {
+	Year          int
+	ReferenceYear int
+	Method        string
+	Candidates    int64
+	ComputedAt    time.Time
+}
+
+// Equate computes equated_aggregate for every candidate in year against
+// referenceYear and records the run
+func Equate(ctx context.Context, db *sql.DB, year, referenceYear int, method string) (*Run, error) {
+	var query string
+	switch method {
+	case MethodZScore:
+		query = zScoreQuery
+	case MethodPercentile:
+		query = percentileQuery
+	default:
+		return nil, fmt.Errorf("unknown equating method %q (use %s or %s)", method, MethodPercentile, MethodZScore)
+	}
+
+	var refCount int
+	if err := db.QueryRowContext(ctx,
+		"SELECT COUNT(aggregate) FROM candidate WHERE year = $1", referenceYear).Scan(&refCount); err != nil {
+		return nil, err
+	}
+	if refCount < 2 {
+		return nil, fmt.Errorf("reference year %d has too few aggregates to equate against", referenceYear)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE candidate SET equated_aggregate = NULL WHERE year = $1", year); err != nil {
+		return nil, fmt.Errorf("error clearing equated aggregates: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, query, year, referenceYear)
+	if err != nil {
+		return nil, fmt.Errorf("error equating aggregates: %w", err)
+	}
+	run := &Run{Year: year, ReferenceYear: referenceYear, Method: method, ComputedAt: time.Now()}
+	run.Candidates, _ = result.RowsAffected()
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO equating_runs (year, reference_year, method, candidates, computed_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (year) DO UPDATE
+        SET reference_year = EXCLUDED.reference_year,
+            method = EXCLUDED.method,
+            candidates = EXCLUDED.candidates,
+            computed_at = EXCLUDED.computed_at`,
+		run.Year, run.ReferenceYear, run.Method, run.Candidates, run.ComputedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error recording equating run: %w", err)
+	}
+	return run, tx.Commit()
+}
+
+// Runs lists the recorded equating runs by year
+func Runs(ctx context.Context, db *sql.DB) ([]Run, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT year, reference_year, method, candidates, computed_at
+        FROM equating_runs
+        ORDER BY year`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.Year, &r.ReferenceYear, &r.Method, &r.Candidates, &r.ComputedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+const zScoreQuery = `
+        WITH target AS (
+            SELECT AVG(aggregate) AS mean, STDDEV_POP(aggregate) AS sd
+            FROM candidate WHERE year = $1
+        ), ref AS (
+            SELECT AVG(aggregate) AS mean, STDDEV_POP(aggregate) AS sd
+            FROM candidate WHERE year = $2
+        )
+        UPDATE candidate c
+        SET equated_aggregate = ROUND((ref.mean + (c.aggregate - target.mean) / NULLIF(target.sd, 0) * ref.sd)::numeric, 2)
+        FROM target, ref
+        WHERE c.year = $1 AND c.aggregate IS NOT NULL`
+
+// percentileQuery uses mid-percentile ranks, so tied aggregates share an
+// equated value, and interpolates linearly between reference quantiles.
+var percentileQuery = fmt.Sprintf(`
+        WITH ref AS (
+            SELECT PERCENTILE_CONT(ARRAY(SELECT g / %[1]d.0 FROM generate_series(0, %[1]d) g))
+                   WITHIN GROUP (ORDER BY aggregate) AS q
+            FROM candidate
+            WHERE year = $2 AND aggregate IS NOT NULL
+        ), ranked AS (
+            SELECT regnumber,
+                   (PERCENT_RANK() OVER w + CUME_DIST() OVER w) / 2 * %[1]d AS pos
+            FROM candidate
+            WHERE year = $1 AND aggregate IS NOT NULL
+            WINDOW w AS (ORDER BY aggregate)
+        ), located AS (
+            SELECT regnumber,
+                   LEAST(FLOOR(pos)::int, %[1]d - 1) AS idx,
+                   pos - LEAST(FLOOR(pos)::int, %[1]d - 1) AS frac
+            FROM ranked
+        )
+        UPDATE candidate c
+        SET equated_aggregate = ROUND((ref.q[l.idx + 1] + (ref.q[l.idx + 2] - ref.q[l.idx + 1]) * l.frac)::numeric, 2)
+        FROM located l, ref
+        WHERE c.year = $1 AND c.regnumber = l.regnumber`, quantileSteps)
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/equating"
+	"github.com/olekukonko/tablewriter"
+)
+
+func handleScoreEquating(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nScore Equating")
+	fmt.Println("1. Equate a year against a reference year")
+	fmt.Println("2. Show equating runs")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		fmt.Print("Year to equate: ")
+		year := readInt()
+		fmt.Print("Reference year: ")
+		referenceYear := readInt()
+		fmt.Printf("Method (%s, %s) [%s]: ", equating.MethodPercentile, equating.MethodZScore, equating.MethodPercentile)
+		method := strings.ToLower(readString())
+		if method == "" {
+			method = equating.MethodPercentile
+		}
+
+		run, err := equating.Equate(ctx, db, year, referenceYear, method)
+		if err != nil {
+			return err
+		}
+		color.Green("Equated %d aggregates for %d against %d using %s matching",
+			run.Candidates, run.Year, run.ReferenceYear, run.Method)
+		fmt.Println("Year-over-Year Comparison now includes the equated average.")
+	case "2":
+		runs, err := equating.Runs(ctx, db)
+		if err != nil {
+			return err
+		}
+		if len(runs) == 0 {
+			color.Yellow("No years have been equated")
+			return nil
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Year", "Reference Year", "Method", "Candidates", "Computed"})
+		for _, r := range runs {
+			table.Append([]string{
+				fmt.Sprintf("%d", r.Year),
+				fmt.Sprintf("%d", r.ReferenceYear),
+				r.Method,
+				fmt.Sprintf("%d", r.Candidates),
+				r.ComputedAt.Format("2006-01-02 15:04"),
+			})
+		}
+		table.Render()
+	}
+	return nil
+}
+
+// tableHasColumn reports whether table has the named column, for reports
+// that use optional columns added by later features
+func tableHasColumn(ctx context.Context, db *sql.DB, table, column string) bool {
+	var exists bool
+	err := db.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM information_schema.columns
+            WHERE table_schema = 'public' AND table_name = $1 AND column_name = $2
+        )`, table, column).Scan(&exists)
+	return err == nil && exists
+}
@@ -0,0 +1,227 @@
+// Package explain runs EXPLAIN ANALYZE on a query and renders the plan as
+// a tree, with each node's own share of the time and buffers.
+package explain
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Node is one step of a query plan, with the figures EXPLAIN (ANALYZE,
+// BUFFERS, FORMAT JSON) reports for it
+type Node struct {
+	NodeType           string   `json:"Node Type"`
+	ParentRelationship string   `json:"Parent Relationship"`
+	SubplanName        string   `json:"Subplan Name"`
+	Strategy           string   `json:"Strategy"`
+	JoinType           string   `json:"Join Type"`
+	RelationName       string   `json:"Relation Name"`
+	Alias              string   `json:"Alias"`
+	IndexName          string   `json:"Index Name"`
+	CTEName            string   `json:"CTE Name"`
+	IndexCond          string   `json:"Index Cond"`
+	HashCond           string   `json:"Hash Cond"`
+	MergeCond          string   `json:"Merge Cond"`
+	JoinFilter         string   `json:"Join Filter"`
+	Filter             string   `json:"Filter"`
+	RowsRemoved        float64  `json:"Rows Removed by Filter"`
+	SortKey            []string `json:"Sort Key"`
+	SortMethod         string   `json:"Sort Method"`
+	GroupKey           []string `json:"Group Key"`
+	WorkersLaunched    int      `json:"Workers Launched"`
+
+	PlanRows    float64 `json:"Plan Rows"`
+	TotalCost   float64 `json:"Total Cost"`
+	StartupTime float64 `json:"Actual Startup Time"`
+	TotalTime   float64 `json:"Actual Total Time"` // ms, per loop
+	ActualRows  float64 `json:"Actual Rows"`       // per loop
+	Loops       float64 `json:"Actual Loops"`
+
+	SharedHit     int64 `json:"Shared Hit Blocks"`
+	SharedRead    int64 `json:"Shared Read Blocks"`
+	SharedDirtied int64 `json:"Shared Dirtied Blocks"`
+	SharedWritten int64 `json:"Shared Written Blocks"`
+	TempRead      int64 `json:"Temp Read Blocks"`
+	TempWritten   int64 `json:"Temp Written Blocks"`
+
+	Plans []*Node `json:"Plans"`
+
+	// SelfTime is the time spent in the node itself, its inclusive time
+	// less its children's, in ms
+	SelfTime float64 `json:"-"`
+	// Self buffers are those the node read or hit itself; EXPLAIN
+	// reports them including the children's
+	SelfHit, SelfRead int64 `json:"-"`
+}
+
+// Plan is the analysed plan of a query
+type Plan struct {
+	Root          *Node   `json:"Plan"`
+	PlanningTime  float64 `json:"Planning Time"`
+	ExecutionTime float64 `json:"Execution Time"`
+	// Query is the statement that was explained
+	Query string `json:"-"`
+}
+
+// Analyze runs the query under EXPLAIN (ANALYZE, BUFFERS) in a read-only
+// transaction that is rolled back, so the query runs in full but can
+// change nothing
+func Analyze(ctx context.Context, db *sql.DB, query string, args ...interface{}) (*Plan, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var raw string
+	if err := tx.QueryRowContext(ctx, "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) "+query, args...).Scan(&raw); err != nil {
+		return nil, fmt.Errorf("error explaining query: %w", err)
+	}
+	plan, err := Parse([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+	plan.Query = query
+	return plan, nil
+}
+
+// Parse reads the output of EXPLAIN (ANALYZE, FORMAT JSON) and works out
+// each node's own time and buffers
+func Parse(data []byte) (*Plan, error) {
+	var plans []Plan
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("error parsing plan: %w", err)
+	}
+	if len(plans) != 1 || plans[0].Root == nil {
+		return nil, fmt.Errorf("EXPLAIN returned no plan")
+	}
+	plan := &plans[0]
+	computeSelf(plan.Root)
+	return plan, nil
+}
+
+// inclusiveTime is the node's total time over all its loops. Workers of a
+// parallel plan each count as a loop but run at the same time, so their
+// time is divided among them.
+func (n *Node) inclusiveTime(workers int) float64 {
+	loops := n.Loops
+	if workers > 0 && loops > 0 {
+		loops /= float64(workers + 1)
+	}
+	if loops < 1 {
+		loops = 1
+	}
+	return n.TotalTime * loops
+}
+
+func computeSelf(root *Node) {
+	var walk func(n *Node, workers int)
+	walk = func(n *Node, workers int) {
+		if n.WorkersLaunched > 0 {
+			workers = n.WorkersLaunched
+		}
+		self := n.inclusiveTime(workers)
+		n.SelfHit, n.SelfRead = n.SharedHit, n.SharedRead
+		for _, child := range n.Plans {
+			walk(child, workers)
+			// An InitPlan runs once, apart from the node it hangs off
+			if child.ParentRelationship == "InitPlan" {
+				continue
+			}
+			self -= child.inclusiveTime(workers)
+			n.SelfHit -= child.SharedHit
+			n.SelfRead -= child.SharedRead
+		}
+		if self < 0 {
+			self = 0
+		}
+		if n.SelfHit < 0 {
+			n.SelfHit = 0
+		}
+		if n.SelfRead < 0 {
+			n.SelfRead = 0
+		}
+		n.SelfTime = self
+	}
+	walk(root, 0)
+}
+
+// Costliest returns the node that spent the most time itself
+func (p *Plan) Costliest() *Node {
+	var worst *Node
+	p.Walk(func(n *Node, depth int) {
+		if worst == nil || n.SelfTime > worst.SelfTime {
+			worst = n
+		}
+	})
+	return worst
+}
+
+// Walk calls fn on every node, parents before their children
+func (p *Plan) Walk(fn func(n *Node, depth int)) {
+	var walk func(n *Node, depth int)
+	walk = func(n *Node, depth int) {
+		fn(n, depth)
+		for _, child := range n.Plans {
+			walk(child, depth+1)
+		}
+	}
+	walk(p.Root, 0)
+}
+
+// Label describes the node in one line, e.g. "Index Scan using
+// candidate_year_idx on candidate c"
+func (n *Node) Label() string {
+	label := n.NodeType
+	switch {
+	case n.Strategy == "Hashed" || n.Strategy == "Sorted" || n.Strategy == "Mixed":
+		label = n.Strategy + " " + label
+	case n.JoinType != "" && n.JoinType != "Inner":
+		label += " (" + n.JoinType + ")"
+	}
+	if n.IndexName != "" {
+		label += " using " + n.IndexName
+	}
+	if n.RelationName != "" {
+		label += " on " + n.RelationName
+		if n.Alias != "" && n.Alias != n.RelationName {
+			label += " " + n.Alias
+		}
+	}
+	if n.CTEName != "" {
+		label += " on " + n.CTEName
+	}
+	if n.SubplanName != "" {
+		label = n.SubplanName + ": " + label
+	}
+	return label
+}
+
+// Details lists the node's conditions and keys, one per line
+func (n *Node) Details() []string {
+	var details []string
+	add := func(name, value string) {
+		if value != "" {
+			details = append(details, name+": "+value)
+		}
+	}
+	add("Index Cond", n.IndexCond)
+	add("Hash Cond", n.HashCond)
+	add("Merge Cond", n.MergeCond)
+	add("Join Filter", n.JoinFilter)
+	if n.Filter != "" {
+		filter := n.Filter
+		if n.RowsRemoved > 0 {
+			filter += fmt.Sprintf(" (removed %.0f rows)", n.RowsRemoved)
+		}
+		add("Filter", filter)
+	}
+	add("Sort Key", strings.Join(n.SortKey, ", "))
+	add("Sort Method", n.SortMethod)
+	add("Group Key", strings.Join(n.GroupKey, ", "))
+	return details
+}
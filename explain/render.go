@@ -0,0 +1,112 @@
+package explain
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Render writes the plan as an indented tree. Each node shows its
+// inclusive and own time, its own share of the execution time, actual
+// against estimated rows and its buffers; the node that spent the most
+// time itself is highlighted.
+func Render(w io.Writer, p *Plan) {
+	worst := p.Costliest()
+	total := p.ExecutionTime
+	if total <= 0 {
+		total = p.Root.inclusiveTime(0)
+	}
+	highlight := color.New(color.FgRed, color.Bold)
+	dim := color.New(color.Faint)
+
+	var walk func(n *Node, prefix string, last, root bool)
+	walk = func(n *Node, prefix string, last, root bool) {
+		branch, childPrefix := "", ""
+		if !root {
+			branch, childPrefix = "├─ ", prefix+"│  "
+			if last {
+				branch, childPrefix = "└─ ", prefix+"   "
+			}
+		}
+
+		share := 0.0
+		if total > 0 {
+			share = n.SelfTime * 100 / total
+		}
+		line := fmt.Sprintf("%s  %s ms, self %s ms (%.0f%%)  rows %s of %s est%s  %s",
+			n.Label(), formatMs(n.TotalTime), formatMs(n.SelfTime), share,
+			formatRows(n.ActualRows*max(n.Loops, 1)), formatRows(n.PlanRows), loops(n.Loops), buffers(n))
+		line = strings.TrimRight(line, " ")
+		if n == worst {
+			fmt.Fprintf(w, "%s%s%s\n", prefix, branch, highlight.Sprint(line+"  <- most expensive"))
+		} else {
+			fmt.Fprintf(w, "%s%s%s\n", prefix, branch, line)
+		}
+
+		detailPrefix := childPrefix
+		if len(n.Plans) > 0 {
+			detailPrefix += "│  "
+		} else {
+			detailPrefix += "   "
+		}
+		for _, d := range n.Details() {
+			fmt.Fprintf(w, "%s%s\n", detailPrefix, dim.Sprint(d))
+		}
+		for i, child := range n.Plans {
+			walk(child, childPrefix, i == len(n.Plans)-1, false)
+		}
+	}
+	walk(p.Root, "", true, true)
+
+	fmt.Fprintf(w, "\nPlanning %s ms, execution %s ms\n", formatMs(p.PlanningTime), formatMs(p.ExecutionTime))
+	if worst != nil {
+		fmt.Fprintf(w, "Most expensive: %s, %s ms of its own\n", worst.Label(), formatMs(worst.SelfTime))
+	}
+}
+
+func formatMs(ms float64) string {
+	switch {
+	case ms >= 100:
+		return fmt.Sprintf("%.0f", ms)
+	case ms >= 1:
+		return fmt.Sprintf("%.1f", ms)
+	}
+	return fmt.Sprintf("%.3f", ms)
+}
+
+func formatRows(rows float64) string {
+	switch {
+	case rows >= 1e6:
+		return fmt.Sprintf("%.1fM", rows/1e6)
+	case rows >= 1e4:
+		return fmt.Sprintf("%.0fk", rows/1e3)
+	}
+	return fmt.Sprintf("%.0f", rows)
+}
+
+func loops(n float64) string {
+	if n <= 1 {
+		return ""
+	}
+	return fmt.Sprintf(" x%.0f loops", n)
+}
+
+// buffers describes the node's own buffer use in 8kB blocks
+func buffers(n *Node) string {
+	var parts []string
+	if n.SelfHit > 0 {
+		parts = append(parts, fmt.Sprintf("hit %d", n.SelfHit))
+	}
+	if n.SelfRead > 0 {
+		parts = append(parts, fmt.Sprintf("read %d", n.SelfRead))
+	}
+	if n.TempRead+n.TempWritten > 0 {
+		parts = append(parts, fmt.Sprintf("temp %d/%d", n.TempRead, n.TempWritten))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "buffers " + strings.Join(parts, " ")
+}
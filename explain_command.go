@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/explain"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/reports"
+)
+
+// builtinReports lists the reports stats and explain can run by name
+func builtinReports() []reports.Report {
+	return append(append([]reports.Report{}, reports.All...),
+		reports.YearComparison(false), reports.CompositeRanking(reports.DefaultRankingWeights))
+}
+
+// builtinReportQuery returns the SQL a built-in report runs for year and
+// expr, as routed to the fact tables when it can be, with its arguments
+func builtinReportQuery(ctx context.Context, db *sql.DB, name string, year int, expr *filter.Filter) (string, []interface{}, error) {
+	var report *reports.Report
+	for _, r := range builtinReports() {
+		if r.Name == name {
+			r := r
+			report = &r
+		}
+	}
+	if report == nil {
+		return "", nil, fmt.Errorf("unknown report %q", name)
+	}
+	if name == "year-comparison" {
+		*report = reports.YearComparison(tableHasColumn(ctx, db, "candidate", "equated_aggregate"))
+	}
+	source, args := reports.Source(year, expr)
+	plan, err := reports.Route(ctx, db, *report, source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return plan.SQL, args, nil
+}
+
+// handleExplain shows how the database runs a built-in or saved report
+func handleExplain(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nExplain a Report Query")
+	builtin := builtinReports()
+	saved, err := reports.ListSaved(ctx, db)
+	if err != nil {
+		return err
+	}
+	for i, r := range builtin {
+		fmt.Printf("%2d. %s\n", i+1, r.Title)
+	}
+	for i, r := range saved {
+		fmt.Printf("%2d. %s (saved)\n", len(builtin)+i+1, r.Name)
+	}
+	fmt.Print("\nQuery to explain: ")
+	choice, err := strconv.Atoi(readString())
+	if err != nil || choice < 1 || choice > len(builtin)+len(saved) {
+		return fmt.Errorf("invalid choice")
+	}
+
+	var query string
+	var args []interface{}
+	if choice <= len(builtin) {
+		fmt.Print("Year (blank for all years): ")
+		year := readInt()
+		var expr *filter.Filter
+		if input := readFilter(ctx, db, "Filter (optional): "); input != "" {
+			if expr, err = filter.Parse(input); err != nil {
+				return fmt.Errorf("invalid filter: %w", err)
+			}
+		}
+		if query, args, err = builtinReportQuery(ctx, db, builtin[choice-1].Name, year, expr); err != nil {
+			return err
+		}
+	} else {
+		report := saved[choice-len(builtin)-1]
+		params := map[string]string{}
+		for _, name := range report.Parameters() {
+			if def := report.Defaults[name]; def != "" {
+				fmt.Printf("%s [%s]: ", name, def)
+			} else {
+				fmt.Printf("%s: ", name)
+			}
+			params[name] = readString()
+		}
+		if query, args, err = report.Bind(params); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Running the query under EXPLAIN ANALYZE...")
+	plan, err := explain.Analyze(ctx, db, query, args...)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	explain.Render(os.Stdout, plan)
+	return nil
+}
+
+// runExplain is the scriptable form of the plan visualizer
+func runExplain(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("explain")
+	params := map[string]*string{}
+	for _, name := range reports.Params {
+		params[name] = fs.String(name, "", "restrict a built-in report to this "+name+", or the value of a saved report's :"+name)
+	}
+	filterText := fs.String("filter", "", "with a built-in report, restrict the candidates, e.g. gender=F")
+	showSQL := fs.Bool("sql", false, "also print the query that was explained")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError{errors.New("explain needs a report name, saved NAME, or list")}
+	}
+
+	if positional[0] == "list" {
+		for _, r := range builtinReports() {
+			fmt.Printf("%-24s %s\n", r.Name, r.Title)
+		}
+		saved, err := reports.ListSaved(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, r := range saved {
+			fmt.Printf("saved %-18s %s\n", r.Name, r.Description)
+		}
+		return nil
+	}
+
+	var query string
+	var queryArgs []interface{}
+	if positional[0] == "saved" {
+		if len(positional) != 2 {
+			return usageError{errors.New("explain saved needs a report name")}
+		}
+		report, err := reports.GetSaved(ctx, db, positional[1])
+		if err != nil {
+			return err
+		}
+		values := map[string]string{}
+		for name, value := range params {
+			values[name] = *value
+		}
+		if query, queryArgs, err = report.Bind(values); err != nil {
+			return err
+		}
+	} else {
+		if len(positional) != 1 {
+			return usageError{errors.New("explain takes one report name")}
+		}
+		year := 0
+		if *params["year"] != "" {
+			if year, err = strconv.Atoi(*params["year"]); err != nil {
+				return usageError{fmt.Errorf("invalid year %q", *params["year"])}
+			}
+		}
+		var terms []string
+		if *params["state"] != "" {
+			terms = append(terms, "state="+filterString(*params["state"]))
+		}
+		if *params["course"] != "" {
+			terms = append(terms, "course="+filterString(*params["course"]))
+		}
+		if *filterText != "" {
+			terms = append(terms, "("+*filterText+")")
+		}
+		expr, err := parseFilter(strings.Join(terms, " AND "))
+		if err != nil {
+			return err
+		}
+		if query, queryArgs, err = builtinReportQuery(ctx, db, positional[0], year, expr); err != nil {
+			return usageError{fmt.Errorf("%v; spk2 explain list shows the reports", err)}
+		}
+	}
+
+	plan, err := explain.Analyze(ctx, db, query, queryArgs...)
+	if err != nil {
+		return err
+	}
+	if *showSQL {
+		fmt.Println(strings.TrimSpace(plan.Query))
+		fmt.Println()
+	}
+	explain.Render(os.Stdout, plan)
+	return nil
+}
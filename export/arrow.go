@@ -0,0 +1,94 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+)
+
+// ArrowType maps a PostgreSQL column type to the Arrow type it is written as
+func ArrowType(ct *sql.ColumnType) arrow.DataType {
+	switch strings.ToUpper(ct.DatabaseTypeName()) {
+	case "INT2", "INT4", "INT8":
+		return arrow.PrimitiveTypes.Int64
+	case "FLOAT4", "FLOAT8", "NUMERIC":
+		return arrow.PrimitiveTypes.Float64
+	case "BOOL":
+		return arrow.FixedWidthTypes.Boolean
+	case "DATE":
+		return arrow.FixedWidthTypes.Date32
+	case "TIMESTAMP", "TIMESTAMPTZ":
+		return arrow.FixedWidthTypes.Timestamp_us
+	}
+	return arrow.BinaryTypes.String
+}
+
+// AppendArrowValue appends a value scanned from database/sql to the builder
+// of its column's ArrowType
+func AppendArrowValue(b array.Builder, v interface{}) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch builder := b.(type) {
+	case *array.Int64Builder:
+		switch val := v.(type) {
+		case int64:
+			builder.Append(val)
+		default:
+			n, err := strconv.ParseInt(fmt.Sprint(textValue(v)), 10, 64)
+			if err != nil {
+				return err
+			}
+			builder.Append(n)
+		}
+	case *array.Float64Builder:
+		switch val := v.(type) {
+		case float64:
+			builder.Append(val)
+		default:
+			// NUMERIC arrives from lib/pq as text
+			f, err := strconv.ParseFloat(fmt.Sprint(textValue(v)), 64)
+			if err != nil {
+				return err
+			}
+			builder.Append(f)
+		}
+	case *array.BooleanBuilder:
+		val, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("expected bool, got %T", v)
+		}
+		builder.Append(val)
+	case *array.Date32Builder:
+		val, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("expected date, got %T", v)
+		}
+		builder.Append(arrow.Date32FromTime(val))
+	case *array.TimestampBuilder:
+		val, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("expected timestamp, got %T", v)
+		}
+		builder.Append(arrow.Timestamp(val.UnixMicro()))
+	case *array.StringBuilder:
+		builder.Append(fmt.Sprint(textValue(v)))
+	default:
+		return fmt.Errorf("unsupported arrow builder %T", b)
+	}
+	return nil
+}
+
+func textValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
@@ -0,0 +1,329 @@
+// Package export writes candidate data to files in resumable chunks.
+package export
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+// DefaultChunkSize is the number of rows written per export part
+const DefaultChunkSize = 100000
+
+// DefaultColumns are the candidate columns exported when none are specified
+var DefaultColumns = []string{
+	"regnumber", "year", "surname", "firstname", "middlename", "gender",
+	"statecode", "lg_id", "inid", "app_course1", "aggregate",
+	"is_admitted", "is_direct_entry",
+}
+
+// Job describes a candidate export into a directory of CSV or Parquet parts
+type Job struct {
+	Dir       string
+	Name      string
+	Filter    *filter.Filter
+	Columns   []ColumnSpec
+	ChunkSize int
+	// Format is FormatCSV, the default, or FormatParquet
+	Format string
+	// Source is the relation candidates are read from, e.g. a session
+	// working set. It defaults to the candidate table.
+	Source string
+	// Compression is one of the Compression* modes; Level is the flate
+	// compression level (1-9, 0 for the default).
+	Compression string
+	Level       int
+	// Pseudonymizer, when set, masks personal data columns, e.g. for
+	// researchers
+	Pseudonymizer *privacy.Pseudonymizer
+	// Recipients, when set, are the age or SSH public keys every part is
+	// encrypted for as it is written; see RecipientsFromEnv
+	Recipients []string
+	// SigningKey, when set, signs the completed manifest; see
+	// SigningKeyFromEnv
+	SigningKey ed25519.PrivateKey
+	// OnChunk, when set, is called after each part is written
+	OnChunk func(ChunkInfo)
+}
+
+// Run exports the candidates matching the job's filter. If the directory
+// already holds an incomplete manifest for the same job, the export resumes
+// after the last completed chunk.
+func (j *Job) Run(ctx context.Context, db *sql.DB) (*Manifest, error) {
+	if j.ChunkSize <= 0 {
+		j.ChunkSize = DefaultChunkSize
+	}
+	if len(j.Columns) == 0 {
+		j.Columns = DefaultColumnSpec()
+	}
+	if j.Format == "" {
+		j.Format = FormatCSV
+	}
+	if j.Format == FormatParquet && j.Compression == CompressionGzip {
+		return nil, fmt.Errorf("Parquet parts are compressed internally; use no compression or zip")
+	}
+	recipients, err := ParseRecipients(j.Recipients)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(j.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating export directory: %w", err)
+	}
+
+	manifest, err := LoadManifest(j.Dir)
+	if err != nil {
+		return nil, err
+	}
+	masking := ""
+	if j.Pseudonymizer != nil {
+		masking = j.Pseudonymizer.Spec()
+	}
+	if manifest != nil {
+		if manifest.Completed {
+			return manifest, fmt.Errorf("export in %s is already complete", j.Dir)
+		}
+		if manifest.Filter != j.Filter.String() || FormatColumnSpec(manifest.ColumnSpec()) != FormatColumnSpec(j.Columns) ||
+			manifest.Compression != j.Compression || manifest.Masking != masking || manifest.PartFormat() != j.Format ||
+			strings.Join(manifest.Recipients, ",") != strings.Join(j.Recipients, ",") {
+			return nil, fmt.Errorf("export in %s was started with different settings", j.Dir)
+		}
+		j.ChunkSize = manifest.ChunkSize
+	} else {
+		manifest = &Manifest{
+			Name:        j.Name,
+			Filter:      j.Filter.String(),
+			Columns:     columnNames(j.Columns),
+			Headers:     headerNames(j.Columns),
+			ChunkSize:   j.ChunkSize,
+			Format:      j.Format,
+			Compression: j.Compression,
+			Masking:     masking,
+			Recipients:  j.Recipients,
+			StartedAt:   time.Now(),
+			GeneratedBy: generatedBy(),
+		}
+		if err := manifest.Save(j.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return manifest, ctx.Err()
+		default:
+		}
+
+		chunk, err := j.writeChunk(ctx, db, len(manifest.Chunks), manifest.LastKey(), recipients)
+		if err != nil {
+			return manifest, err
+		}
+		if chunk.Rows == 0 {
+			break
+		}
+
+		manifest.Chunks = append(manifest.Chunks, chunk)
+		manifest.TotalRows += chunk.Rows
+		if err := manifest.Save(j.Dir); err != nil {
+			return manifest, err
+		}
+		if j.OnChunk != nil {
+			j.OnChunk(chunk)
+		}
+		if chunk.Rows < j.ChunkSize {
+			break
+		}
+	}
+
+	if j.Compression == CompressionZip && len(manifest.Chunks) > 0 {
+		archive := j.Name + ".zip"
+		if err := archiveParts(j.Dir, archive, manifest.Chunks, j.Level); err != nil {
+			return manifest, err
+		}
+		manifest.Archive = archive
+		if manifest.ArchiveSHA256, err = fileSHA256(filepath.Join(j.Dir, archive)); err != nil {
+			return manifest, err
+		}
+	}
+
+	finished := time.Now()
+	manifest.FinishedAt = &finished
+	manifest.Completed = true
+	if j.SigningKey != nil {
+		manifest.SignedBy = KeyID(j.SigningKey.Public().(ed25519.PublicKey))
+	}
+	if err := manifest.Save(j.Dir); err != nil {
+		return manifest, err
+	}
+	if j.SigningKey != nil {
+		if err := signManifest(j.Dir, j.SigningKey); err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// generatedBy names who ran an export, as user@host
+func generatedBy() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
+// writeChunk exports up to ChunkSize rows after lastKey into a part file,
+// encrypted for the recipients if there are any. The part is written to a
+// temporary name and renamed once complete, so a crash never leaves a
+// truncated part that looks finished.
+func (j *Job) writeChunk(ctx context.Context, db *sql.DB, index int, lastKey string, recipients []age.Recipient) (ChunkInfo, error) {
+	where, args := j.Filter.SQL("c", 1)
+	columns := make([]string, len(j.Columns))
+	for i, col := range j.Columns {
+		columns[i] = ColumnSQL(col.Column)
+	}
+
+	source := j.Source
+	if source == "" {
+		source = "candidate"
+	}
+
+	// regnumber is always selected first as the pagination key
+	query := fmt.Sprintf(`
+        SELECT c.regnumber, %s
+        FROM %s c
+        WHERE c.regnumber > $1 AND %s
+        ORDER BY c.regnumber
+        LIMIT %d`, strings.Join(columns, ", "), source, where, j.ChunkSize)
+
+	rows, err := db.QueryContext(ctx, query, append([]interface{}{lastKey}, args...)...)
+	if err != nil {
+		return ChunkInfo{}, fmt.Errorf("error querying chunk %d: %w", index, err)
+	}
+	defer rows.Close()
+
+	name := partFileName(index, j.Format, j.Compression, len(recipients) > 0)
+	path := filepath.Join(j.Dir, name)
+	tmp := path + ".partial"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return ChunkInfo{}, fmt.Errorf("error creating %s: %w", tmp, err)
+	}
+	defer file.Close()
+
+	// The digest covers the bytes on disk, so recipients can check a part
+	// before decrypting it
+	digest := sha256.New()
+	enc, err := newEncryptWriter(io.MultiWriter(file, digest), recipients)
+	if err != nil {
+		return ChunkInfo{}, err
+	}
+	chunk := ChunkInfo{Index: index, File: name}
+	if j.Format == FormatParquet {
+		err = j.writeParquetPart(rows, enc, &chunk)
+	} else {
+		err = j.writeCSVPart(rows, enc, &chunk)
+	}
+	if err != nil {
+		return ChunkInfo{}, err
+	}
+	if err := enc.Close(); err != nil {
+		return ChunkInfo{}, err
+	}
+	if err := file.Close(); err != nil {
+		return ChunkInfo{}, err
+	}
+
+	if chunk.Rows == 0 {
+		os.Remove(tmp)
+		return chunk, nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return ChunkInfo{}, err
+	}
+	chunk.CompletedAt = time.Now()
+	chunk.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	return chunk, nil
+}
+
+// writeCSVPart writes the rows after the regnumber key column as CSV with
+// the part-level compression
+func (j *Job) writeCSVPart(rows *sql.Rows, w io.Writer, chunk *ChunkInfo) error {
+	pw, err := newPartWriter(w, j.Compression, j.Level)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(pw)
+	if err := cw.Write(headerNames(j.Columns)); err != nil {
+		return err
+	}
+
+	var key string
+	values := make([]sql.NullString, len(j.Columns))
+	ptrs := make([]interface{}, len(j.Columns)+1)
+	ptrs[0] = &key
+	for i := range values {
+		ptrs[i+1] = &values[i]
+	}
+
+	record := make([]string, len(j.Columns))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		for i, v := range values {
+			record[i] = v.String
+			if j.Pseudonymizer != nil {
+				record[i], _ = j.Pseudonymizer.Apply(j.Columns[i].Column, v.String)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		chunk.Rows++
+		chunk.LastKey = key
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+func columnNames(columns []ColumnSpec) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Column
+	}
+	return names
+}
+
+func headerNames(columns []ColumnSpec) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Header
+	}
+	return names
+}
@@ -0,0 +1,123 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/notes"
+)
+
+// ExportableColumns lists the candidate columns that may appear in an export
+var ExportableColumns = map[string]bool{
+	"regnumber": true, "year": true, "surname": true, "firstname": true,
+	"middlename": true, "gender": true, "date_of_birth": true, "maritalstatus": true,
+	"email": true, "gsmno": true, "address": true, "statecode": true, "lg_id": true,
+	"inid": true, "app_course1": true, "aggregate": true, "noofsittings": true,
+	"is_admitted": true, "is_direct_entry": true, "is_blind": true, "is_deaf": true,
+	"is_mock_candidate": true, "malpractice": true,
+	"institution_notes": true, "course_notes": true, "exam_number": true,
+}
+
+// computedColumns are exportable columns that are not stored on the
+// candidate, with the expression that selects them
+var computedColumns = map[string]string{
+	"institution_notes": notes.Column(notes.Institution, "UPPER(c.inid)"),
+	"course_notes":      notes.Column(notes.Course, "UPPER(c.app_course1)"),
+	"exam_number":       "(SELECT e.exam_number FROM candidate_exam_info e WHERE e.cand_reg_number = c.regnumber)",
+}
+
+// ColumnSQL returns the SQL selecting an exportable column from the
+// candidate table aliased c
+func ColumnSQL(column string) string {
+	if expr, ok := computedColumns[column]; ok {
+		return expr
+	}
+	return "c." + column
+}
+
+// ColumnSpec selects a candidate column and the header it is written under
+type ColumnSpec struct {
+	Column string
+	Header string
+}
+
+// ParseColumnSpec parses a comma or newline separated list of columns, each
+// optionally renamed with a colon, in output order:
+//
+//	regnumber:RegNo, surname:Surname, aggregate:Score
+//
+// A spec starting with @ is read from the named file.
+func ParseColumnSpec(spec string) ([]ColumnSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@") {
+		data, err := os.ReadFile(strings.TrimPrefix(spec, "@"))
+		if err != nil {
+			return nil, fmt.Errorf("error reading column spec: %w", err)
+		}
+		spec = string(data)
+	}
+
+	entries := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' })
+	columns := make([]ColumnSpec, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		column, header, renamed := strings.Cut(entry, ":")
+		column = strings.ToLower(strings.TrimSpace(column))
+		header = strings.TrimSpace(header)
+		if !renamed || header == "" {
+			header = column
+		}
+
+		if !ExportableColumns[column] {
+			return nil, fmt.Errorf("unknown column %q (available: %s)", column, strings.Join(exportableColumnNames(), ", "))
+		}
+		if seen[header] {
+			return nil, fmt.Errorf("duplicate header %q", header)
+		}
+		seen[header] = true
+		columns = append(columns, ColumnSpec{Column: column, Header: header})
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("column spec selects no columns")
+	}
+	return columns, nil
+}
+
+// DefaultColumnSpec exports DefaultColumns under their own names
+func DefaultColumnSpec() []ColumnSpec {
+	columns := make([]ColumnSpec, len(DefaultColumns))
+	for i, col := range DefaultColumns {
+		columns[i] = ColumnSpec{Column: col, Header: col}
+	}
+	return columns
+}
+
+// FormatColumnSpec renders columns back into spec syntax
+func FormatColumnSpec(columns []ColumnSpec) string {
+	parts := make([]string, len(columns))
+	for i, c := range columns {
+		if c.Header == c.Column {
+			parts[i] = c.Column
+		} else {
+			parts[i] = c.Column + ":" + c.Header
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func exportableColumnNames() []string {
+	names := make([]string, 0, len(ExportableColumns))
+	for name := range ExportableColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,119 @@
+package export
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Supported compression modes
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip" // each part written as .csv.gz
+	CompressionZip  = "zip"  // parts bundled into a single .zip when the export completes
+)
+
+// ParseCompression normalises a user supplied compression name
+func ParseCompression(name string) (string, error) {
+	switch name {
+	case "", "none", "csv":
+		return CompressionNone, nil
+	case "gzip", "gz", "csv.gz":
+		return CompressionGzip, nil
+	case "zip":
+		return CompressionZip, nil
+	}
+	return "", fmt.Errorf("unsupported compression %q (use none, gzip or zip)", name)
+}
+
+func partFileName(index int, format, compression string, encrypted bool) string {
+	name := fmt.Sprintf("part-%05d.%s", index, format)
+	if compression == CompressionGzip {
+		name += ".gz"
+	}
+	if encrypted {
+		name += EncryptedSuffix
+	}
+	return name
+}
+
+func compressionLevel(level int) int {
+	if level < flate.HuffmanOnly || level > flate.BestCompression || level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+// nopWriteCloser lets uncompressed parts share the compressed write path
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newPartWriter wraps w with the part-level compression, if any. Zip
+// archives are built after the export completes, so parts are plain CSV.
+func newPartWriter(w io.Writer, compression string, level int) (io.WriteCloser, error) {
+	if compression == CompressionGzip {
+		return gzip.NewWriterLevel(w, compressionLevel(level))
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// archiveParts bundles the completed parts into archive and removes them
+func archiveParts(dir, archive string, chunks []ChunkInfo, level int) error {
+	path := filepath.Join(dir, archive)
+	tmp := path + ".partial"
+
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error creating archive: %w", err)
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, compressionLevel(level))
+	})
+
+	for _, chunk := range chunks {
+		if err := addToArchive(zw, filepath.Join(dir, chunk.File), chunk.File); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("error finalising archive: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		os.Remove(filepath.Join(dir, chunk.File))
+	}
+	return nil
+}
+
+func addToArchive(zw *zip.Writer, path, name string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", name, err)
+	}
+	defer in.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return fmt.Errorf("error archiving %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+// EncryptedSuffix is appended to the name of a file encrypted with age
+const EncryptedSuffix = ".age"
+
+// RecipientsFromEnv reads EXPORT_RECIPIENTS, a comma separated list of age
+// public keys (age1...), SSH public keys, or files holding such keys one per
+// line. Export files are encrypted for every recipient, any of whom can
+// decrypt them with `age -d -i KEY`. It returns nil when none are set.
+func RecipientsFromEnv() ([]string, error) {
+	raw := os.Getenv("EXPORT_RECIPIENTS")
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var keys []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case isRecipientKey(entry):
+			keys = append(keys, entry)
+		default:
+			fileKeys, err := readRecipientsFile(entry)
+			if err != nil {
+				return nil, fmt.Errorf("EXPORT_RECIPIENTS: %w", err)
+			}
+			keys = append(keys, fileKeys...)
+		}
+	}
+	if _, err := ParseRecipients(keys); err != nil {
+		return nil, fmt.Errorf("EXPORT_RECIPIENTS: %w", err)
+	}
+	return keys, nil
+}
+
+func isRecipientKey(s string) bool {
+	return strings.HasPrefix(s, "age1") || strings.HasPrefix(s, "ssh-")
+}
+
+// readRecipientsFile reads keys one per line, skipping blank lines and
+// # comments, as in an age recipients file
+func readRecipientsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a key nor a readable recipients file: %w", path, err)
+	}
+	defer file.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s holds no recipient keys", path)
+	}
+	return keys, nil
+}
+
+// ParseRecipients parses age and SSH public keys
+func ParseRecipients(keys []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, key := range keys {
+		var r age.Recipient
+		var err error
+		if strings.HasPrefix(key, "ssh-") {
+			r, err = agessh.ParseRecipient(key)
+		} else {
+			r, err = age.ParseX25519Recipient(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient key %q: %w", shortKey(key), err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+func shortKey(key string) string {
+	if len(key) > 24 {
+		return key[:24] + "..."
+	}
+	return key
+}
+
+// newEncryptWriter encrypts what is written to w for the recipients; with
+// none it writes plain text
+func newEncryptWriter(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+	enc, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("error starting encryption: %w", err)
+	}
+	return enc, nil
+}
+
+// EncryptFile encrypts path for the recipient keys into path.age and
+// removes the plain file, returning the new path
+func EncryptFile(path string, keys []string) (string, error) {
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no recipient keys to encrypt %s for", path)
+	}
+	recipients, err := ParseRecipients(keys)
+	if err != nil {
+		return "", err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	encrypted := path + EncryptedSuffix
+	tmp := encrypted + ".partial"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", fmt.Errorf("error creating %s: %w", tmp, err)
+	}
+	defer out.Close()
+
+	enc, err := newEncryptWriter(out, recipients)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(enc, in); err != nil {
+		return "", fmt.Errorf("error encrypting %s: %w", path, err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, encrypted); err != nil {
+		return "", err
+	}
+	in.Close()
+	if err := os.Remove(path); err != nil {
+		return encrypted, fmt.Errorf("encrypted to %s but could not remove the plain file: %w", encrypted, err)
+	}
+	return encrypted, nil
+}
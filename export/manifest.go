@@ -0,0 +1,141 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestFile is the name of the manifest written alongside export parts
+const ManifestFile = "manifest.json"
+
+// ChunkInfo records a completed export part
+type ChunkInfo struct {
+	Index       int       `json:"index"`
+	File        string    `json:"file"`
+	Rows        int       `json:"rows"`
+	LastKey     string    `json:"last_key"`
+	CompletedAt time.Time `json:"completed_at"`
+	// SHA256 is the hex digest of the part file as written, after any
+	// compression and encryption
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Manifest describes an export job and its completed parts. It is rewritten
+// after every chunk so an interrupted export can resume where it stopped.
+type Manifest struct {
+	Name      string   `json:"name"`
+	Filter    string   `json:"filter,omitempty"`
+	Columns   []string `json:"columns"`
+	Headers   []string `json:"headers,omitempty"`
+	ChunkSize int      `json:"chunk_size"`
+	// Format is the part format; manifests written before Parquet parts
+	// have none, and are CSV
+	Format string `json:"format,omitempty"`
+	// Compression is the mode the parts were written with; Archive names the
+	// zip bundle once a zip export completes.
+	Compression   string      `json:"compression,omitempty"`
+	Archive       string      `json:"archive,omitempty"`
+	ArchiveSHA256 string      `json:"archive_sha256,omitempty"`
+	StartedAt     time.Time   `json:"started_at"`
+	FinishedAt    *time.Time  `json:"finished_at,omitempty"`
+	Completed     bool        `json:"completed"`
+	TotalRows     int         `json:"total_rows"`
+	Chunks        []ChunkInfo `json:"chunks"`
+	// GeneratedBy is the user and host that ran the export
+	GeneratedBy string `json:"generated_by,omitempty"`
+	// Masking is the privacy.Pseudonymizer spec personal data columns were
+	// masked with, empty when they were not
+	Masking string `json:"masking,omitempty"`
+	// Recipients are the public keys the parts were encrypted for, empty
+	// when they are plain
+	Recipients []string `json:"recipients,omitempty"`
+	// SignedBy is the KeyID of the key the completed manifest is signed
+	// with, in SignatureFile; empty when it is not signed
+	SignedBy string `json:"signed_by,omitempty"`
+}
+
+// PartFormat returns the format the parts are written in
+func (m *Manifest) PartFormat() string {
+	if m.Format == "" {
+		return FormatCSV
+	}
+	return m.Format
+}
+
+// LastKey returns the key of the last exported row, or "" if none
+func (m *Manifest) LastKey() string {
+	if len(m.Chunks) == 0 {
+		return ""
+	}
+	return m.Chunks[len(m.Chunks)-1].LastKey
+}
+
+// ColumnSpec returns the exported columns with their output headers
+func (m *Manifest) ColumnSpec() []ColumnSpec {
+	columns := make([]ColumnSpec, len(m.Columns))
+	for i, col := range m.Columns {
+		header := col
+		if i < len(m.Headers) {
+			header = m.Headers[i]
+		}
+		columns[i] = ColumnSpec{Column: col, Header: header}
+	}
+	return columns
+}
+
+// LoadManifest reads the manifest in dir. It returns nil without error if
+// the directory has no manifest.
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// Save atomically writes the manifest into dir
+func (m *Manifest) Save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(dir, ManifestFile), data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Files returns the paths of the export's output files in dir, including the
+// manifest itself and its signature: the zip archive if one was written,
+// otherwise every part.
+func (m *Manifest) Files(dir string) []string {
+	var files []string
+	if m.Archive != "" {
+		files = append(files, filepath.Join(dir, m.Archive))
+	} else {
+		for _, chunk := range m.Chunks {
+			files = append(files, filepath.Join(dir, chunk.File))
+		}
+	}
+	files = append(files, filepath.Join(dir, ManifestFile))
+	if m.SignedBy != "" {
+		files = append(files, filepath.Join(dir, SignatureFile))
+	}
+	return files
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// Supported part formats
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet" // typed columns, Snappy compressed, for pandas and Spark
+)
+
+// parquetRowGroupRows is the number of rows buffered before a row group is
+// written, which bounds the memory a Parquet part takes however many rows
+// it holds
+const parquetRowGroupRows = 65536
+
+// ParseFormat normalises a user supplied part format
+func ParseFormat(name string) (string, error) {
+	switch name {
+	case "", "csv":
+		return FormatCSV, nil
+	case "parquet", "pq":
+		return FormatParquet, nil
+	}
+	return "", fmt.Errorf("unsupported export format %q (use csv or parquet)", name)
+}
+
+// writeParquetPart writes the rows after the regnumber key column as a
+// Parquet file, typed from the database columns. Personal data columns
+// are masked as in CSV parts, and masked values that are removed are
+// written as nulls.
+func (j *Job) writeParquetPart(rows *sql.Rows, w io.Writer, chunk *ChunkInfo) error {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	columnTypes = columnTypes[1:]
+	fields := make([]arrow.Field, len(columnTypes))
+	for i, ct := range columnTypes {
+		fields[i] = arrow.Field{Name: j.Columns[i].Header, Type: ArrowType(ct), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+	// The writer closes its sink; the caller closes w after the footer
+	fw, err := pqarrow.NewFileWriter(schema, struct{ io.Writer }{w}, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		return fmt.Errorf("error starting Parquet part: %w", err)
+	}
+	defer fw.Close()
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+	flush := func() error {
+		record := builder.NewRecord()
+		defer record.Release()
+		if record.NumRows() == 0 {
+			return nil
+		}
+		return fw.Write(record)
+	}
+
+	var key string
+	values := make([]interface{}, len(columnTypes))
+	ptrs := make([]interface{}, len(columnTypes)+1)
+	ptrs[0] = &key
+	for i := range values {
+		ptrs[i+1] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			if s, ok := v.(string); ok && j.Pseudonymizer != nil {
+				if masked, kept := j.Pseudonymizer.Apply(j.Columns[i].Column, s); kept {
+					v = masked
+				} else {
+					v = nil
+				}
+			}
+			if err := AppendArrowValue(builder.Field(i), v); err != nil {
+				return fmt.Errorf("column %s: %w", j.Columns[i].Column, err)
+			}
+		}
+		chunk.Rows++
+		chunk.LastKey = key
+		if chunk.Rows%parquetRowGroupRows == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return fw.Close()
+}
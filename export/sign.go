@@ -0,0 +1,269 @@
+package export
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureFile is the detached Ed25519 signature of the manifest, written
+// next to it when the export is signed. It holds the raw 64 byte signature
+// of manifest.json as written, so it can also be checked with
+// `openssl pkeyutl -verify -pubin -inkey KEY.pub -rawin -in manifest.json -sigfile manifest.json.sig`.
+const SignatureFile = ManifestFile + ".sig"
+
+// SigningKeyFromEnv reads EXPORT_SIGNING_KEY, the path of a PEM encoded
+// Ed25519 private key (PKCS #8, as written by `spk2 manifest keygen` or
+// `openssl genpkey -algorithm ed25519`). Completed exports are signed with
+// it. It returns nil when none is set.
+func SigningKeyFromEnv() (ed25519.PrivateKey, error) {
+	path := strings.TrimSpace(os.Getenv("EXPORT_SIGNING_KEY"))
+	if path == "" {
+		return nil, nil
+	}
+	key, err := ReadSigningKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("EXPORT_SIGNING_KEY: %w", err)
+	}
+	return key, nil
+}
+
+// ReadSigningKey reads a PEM encoded Ed25519 private key
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return key, nil
+}
+
+// ReadPublicKey reads a PEM encoded Ed25519 public key, or the public half
+// of a private key file
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil && block.Type == "PRIVATE KEY" {
+		key, err := ReadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return key, nil
+}
+
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s holds no PEM %s", path, blockType)
+	}
+	return block, nil
+}
+
+// GenerateSigningKey writes a new Ed25519 key pair: the private key to path,
+// readable by its owner only, and the public key, to give to recipients, to
+// path.pub. It does not overwrite an existing key.
+func GenerateSigningKey(path string) (ed25519.PublicKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}); err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	if err := os.WriteFile(path+".pub", publicPEM, 0644); err != nil {
+		return nil, err
+	}
+	return public, nil
+}
+
+// KeyID is the fingerprint a manifest names its signing key by: the first
+// 16 hex digits of the SHA-256 of the raw public key
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// signManifest writes the detached signature of the manifest in dir
+func signManifest(dir string, key ed25519.PrivateKey) error {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return fmt.Errorf("error reading manifest to sign: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(dir, SignatureFile), ed25519.Sign(key, data))
+}
+
+// FileCheck is the result of checking one exported file against the
+// manifest
+type FileCheck struct {
+	File string
+	Rows int
+	Err  error // nil when the file's SHA-256 matches
+}
+
+// Verification is the result of VerifyExport
+type Verification struct {
+	Manifest *Manifest
+	// KeyID is the fingerprint of the key the manifest was signed with
+	KeyID string
+	Files []FileCheck
+}
+
+// Failed returns the number of files that do not match the manifest
+func (v *Verification) Failed() int {
+	failed := 0
+	for _, f := range v.Files {
+		if f.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// VerifyExport checks that the manifest in dir was signed by key and that
+// every file it lists, the parts or the zip archive and the parts inside
+// it, has the SHA-256 it records. An error means the manifest itself can't
+// be trusted; files that don't match are reported in the Verification.
+func VerifyExport(dir string, key ed25519.PublicKey) (*Verification, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	signature, err := os.ReadFile(filepath.Join(dir, SignatureFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("the export in %s is not signed", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return nil, fmt.Errorf("the manifest signature does not match key %s; the manifest was altered or signed with another key", KeyID(key))
+	}
+
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Completed {
+		return nil, errors.New("the manifest is for an incomplete export")
+	}
+	v := &Verification{Manifest: m, KeyID: m.SignedBy}
+
+	if m.Archive != "" {
+		v.Files = append(v.Files, FileCheck{File: m.Archive, Rows: m.TotalRows,
+			Err: checkSum(m.ArchiveSHA256, func() (io.ReadCloser, error) { return os.Open(filepath.Join(dir, m.Archive)) })})
+		zr, err := zip.OpenReader(filepath.Join(dir, m.Archive))
+		if err != nil {
+			return v, nil
+		}
+		defer zr.Close()
+		entries := map[string]*zip.File{}
+		for _, f := range zr.File {
+			entries[f.Name] = f
+		}
+		for _, chunk := range m.Chunks {
+			check := FileCheck{File: m.Archive + "/" + chunk.File, Rows: chunk.Rows}
+			if f, ok := entries[chunk.File]; ok {
+				check.Err = checkSum(chunk.SHA256, f.Open)
+			} else {
+				check.Err = errors.New("missing from the archive")
+			}
+			v.Files = append(v.Files, check)
+		}
+		return v, nil
+	}
+
+	for _, chunk := range m.Chunks {
+		path := filepath.Join(dir, chunk.File)
+		v.Files = append(v.Files, FileCheck{File: chunk.File, Rows: chunk.Rows,
+			Err: checkSum(chunk.SHA256, func() (io.ReadCloser, error) { return os.Open(path) })})
+	}
+	return v, nil
+}
+
+func checkSum(want string, open func() (io.ReadCloser, error)) error {
+	if want == "" {
+		return errors.New("no SHA-256 in the manifest")
+	}
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("SHA-256 is %s, the manifest has %s", got, want)
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/delivery"
+	"github.com/nonsonwune/spk2_db/export"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/joblog"
+)
+
+func handleCandidateExport(ctx context.Context, db *sql.DB) error {
+	if publicOutput != nil {
+		return fmt.Errorf("candidate-level exports are disabled while public output mode is on")
+	}
+	fmt.Print("Export directory: ")
+	dir := readString()
+	if dir == "" {
+		return fmt.Errorf("export directory is required")
+	}
+
+	job := &export.Job{Dir: dir, Name: "candidates"}
+
+	manifest, err := export.LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	if manifest != nil && !manifest.Completed {
+		fmt.Printf("Found an interrupted export (%d parts, %d rows written).\n",
+			len(manifest.Chunks), manifest.TotalRows)
+		fmt.Print("Resume it? (y/n): ")
+		if strings.ToLower(readString()) != "y" {
+			return fmt.Errorf("choose an empty directory to start a new export")
+		}
+		if manifest.Filter != "" {
+			if job.Filter, err = filter.Parse(manifest.Filter); err != nil {
+				return fmt.Errorf("invalid filter in manifest: %w", err)
+			}
+		}
+		job.Columns = manifest.ColumnSpec()
+		job.Name = manifest.Name
+		job.Format = manifest.PartFormat()
+		job.Compression = manifest.Compression
+		if manifest.Masking != "" {
+			if job.Pseudonymizer, err = newPseudonymizer(manifest.Masking); err != nil {
+				return err
+			}
+		}
+		job.Recipients = manifest.Recipients
+	} else {
+		if input := readFilter(ctx, db, "Filter, e.g. year=2023 AND state=LAGOS (blank for all): "); input != "" {
+			if job.Filter, err = filter.Parse(input); err != nil {
+				return fmt.Errorf("invalid filter: %w", err)
+			}
+		}
+		fmt.Println("Columns as column[:Header] in output order, or @file with one per line")
+		fmt.Printf("e.g. regnumber:RegNo,surname:Surname,aggregate:Score (blank for %s)\n",
+			strings.Join(export.DefaultColumns, ","))
+		fmt.Print("Columns: ")
+		if spec := readString(); spec != "" {
+			if job.Columns, err = export.ParseColumnSpec(spec); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Rows per part (default %d): ", export.DefaultChunkSize)
+		if size, err := strconv.Atoi(readString()); err == nil && size > 0 {
+			job.ChunkSize = size
+		}
+		fmt.Print("Mask personal data (names, email, gsmno, address, exam number) for sharing? (y/n): ")
+		if strings.ToLower(readString()) == "y" {
+			fmt.Print("Changes to the default masking, e.g. address=mask,regnumber=pseudonym (blank for none): ")
+			if job.Pseudonymizer, err = newPseudonymizer(readString()); err != nil {
+				return err
+			}
+			fmt.Printf("Masking %s\n", describeMasking(job.Pseudonymizer))
+		}
+		fmt.Print("Format (csv, parquet) [csv]: ")
+		if job.Format, err = export.ParseFormat(strings.ToLower(readString())); err != nil {
+			return err
+		}
+		if job.Format == export.FormatParquet {
+			fmt.Print("Compression (none, zip): ")
+		} else {
+			fmt.Print("Compression (none, gzip, zip): ")
+		}
+		if job.Compression, err = export.ParseCompression(strings.ToLower(readString())); err != nil {
+			return err
+		}
+		recipients, err := export.RecipientsFromEnv()
+		if err != nil {
+			return err
+		}
+		if len(recipients) > 0 {
+			fmt.Printf("Encrypt the parts for the %d EXPORT_RECIPIENTS keys? (y/n): ", len(recipients))
+			if strings.ToLower(readString()) == "y" {
+				job.Recipients = recipients
+			}
+		}
+	}
+	if job.Compression != export.CompressionNone {
+		fmt.Print("Compression level 1-9 (blank for default): ")
+		if level, err := strconv.Atoi(readString()); err == nil {
+			job.Level = level
+		}
+	}
+
+	if currentSession.table != "" {
+		fmt.Printf("Limit export to the session working set (%s, %d candidates)? (y/n): ",
+			currentSession.Describe(), currentSession.rows)
+		if strings.ToLower(readString()) == "y" {
+			job.Source = currentSession.CandidateSource()
+		}
+	}
+
+	if job.SigningKey, err = export.SigningKeyFromEnv(); err != nil {
+		return err
+	}
+	job.OnChunk = func(chunk export.ChunkInfo) {
+		fmt.Printf("Wrote %s (%d rows)\n", chunk.File, chunk.Rows)
+	}
+
+	manifest, err = job.Run(ctx, db)
+	if err != nil {
+		if manifest != nil {
+			color.Yellow("Export stopped after %d rows; run the export again on %s to resume", manifest.TotalRows, dir)
+		}
+		return err
+	}
+
+	color.Green("Exported %d candidates in %d parts to %s", manifest.TotalRows, len(manifest.Chunks), dir)
+	if manifest.Archive != "" {
+		color.Green("Parts bundled into %s", manifest.Archive)
+	}
+	if len(manifest.Recipients) > 0 {
+		color.Green("Parts are encrypted; recipients decrypt them with age -d -i KEY")
+	}
+	if manifest.SignedBy != "" {
+		color.Green("Manifest signed with key %s; recipients check it with spk2 manifest verify", manifest.SignedBy)
+	}
+	return deliverFiles(ctx, db, "export:"+manifest.Name, manifest.Files(dir))
+}
+
+// deliverFiles offers to upload generated files to the DELIVERY_URL
+// destination, if one is configured
+func deliverFiles(ctx context.Context, db *sql.DB, job string, files []string) error {
+	cfg, err := delivery.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		return nil
+	}
+
+	fmt.Printf("Deliver %d files to %s? (y/n): ", len(files), cfg.Destination())
+	if strings.ToLower(readString()) != "y" {
+		return nil
+	}
+
+	jobs := joblog.New(db)
+
+	failed := 0
+	for _, result := range delivery.NewDeliverer(cfg, jobs).Deliver(ctx, job, files) {
+		if result.Err != nil {
+			failed++
+			color.Red("Failed to deliver %s after %d attempts: %v", result.File, result.Attempts, result.Err)
+			continue
+		}
+		fmt.Printf("Delivered %s\n", result.Remote)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files were not delivered; see job_log for details", failed, len(files))
+	}
+	color.Green("Delivered %d files to %s", len(files), cfg.Destination())
+	return nil
+}
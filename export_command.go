@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/export"
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+// runExport is the scriptable form of the candidate export: it streams the
+// matching candidates into CSV or Parquet parts in a directory, resuming an
+// interrupted export run with the same flags, and prints the files written
+func runExport(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("export")
+	dir := fs.String("dir", "", "directory the parts and manifest are written to (required)")
+	year := fs.Int("year", 0, "only candidates of this year")
+	state := fs.String("state", "", "only candidates from this state")
+	course := fs.String("course", "", "only candidates whose first choice is this course code")
+	admitted := fs.String("admitted", "", "only admitted (true) or not admitted (false) candidates")
+	filterText := fs.String("filter", "", "only candidates matching this filter, with the flags above")
+	columns := fs.String("columns", "", "columns as column[:Header], or @file (default "+strings.Join(export.DefaultColumns, ",")+")")
+	format := fs.String("format", "csv", "part format: csv or parquet")
+	chunk := fs.Int("chunk", export.DefaultChunkSize, "rows per part")
+	compression := fs.String("compression", "none", "none, gzip (CSV only) or zip")
+	level := fs.Int("level", 0, "compression level 1-9 (default 0, the codec's default)")
+	pseudo := fs.Bool("pseudonymize", false, "mask names, email, gsmno, address and exam number with $PSEUDONYM_KEY")
+	masking := fs.String("mask", "", "with -pseudonymize, column=method changes to the default masking, e.g. regnumber=pseudonym")
+	encrypt := fs.Bool("encrypt", false, "encrypt every part for the EXPORT_RECIPIENTS keys")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" || fs.NArg() > 0 {
+		return usageError{errors.New("export needs -dir and no other arguments")}
+	}
+	if publicOutput != nil {
+		return fmt.Errorf("candidate-level exports are disabled while public output mode is on")
+	}
+	if *masking != "" && !*pseudo {
+		return usageError{errors.New("-mask needs -pseudonymize")}
+	}
+
+	job := &export.Job{Dir: *dir, Name: "candidates", ChunkSize: *chunk, Level: *level}
+	var err error
+	if job.Format, err = export.ParseFormat(strings.ToLower(*format)); err != nil {
+		return usageError{err}
+	}
+	if job.Compression, err = export.ParseCompression(strings.ToLower(*compression)); err != nil {
+		return usageError{err}
+	}
+	if *columns != "" {
+		if job.Columns, err = export.ParseColumnSpec(*columns); err != nil {
+			return usageError{err}
+		}
+	}
+
+	var terms []string
+	if *year != 0 {
+		terms = append(terms, fmt.Sprintf("year=%d", *year))
+	}
+	if *state != "" {
+		terms = append(terms, "state="+filterString(*state))
+	}
+	if *course != "" {
+		terms = append(terms, "course="+filterString(*course))
+	}
+	if *admitted != "" {
+		terms = append(terms, "admitted="+filterString(*admitted))
+	}
+	if *filterText != "" {
+		terms = append(terms, "("+*filterText+")")
+	}
+	if job.Filter, err = parseFilter(strings.Join(terms, " AND ")); err != nil {
+		return err
+	}
+
+	if *pseudo {
+		var pseudonymizer *privacy.Pseudonymizer
+		if pseudonymizer, err = newPseudonymizer(*masking); err != nil {
+			return usageError{err}
+		}
+		job.Pseudonymizer = pseudonymizer
+	}
+	if *encrypt {
+		if job.Recipients, err = export.RecipientsFromEnv(); err != nil {
+			return err
+		}
+		if len(job.Recipients) == 0 {
+			return usageError{errors.New("-encrypt needs EXPORT_RECIPIENTS")}
+		}
+	}
+
+	if job.SigningKey, err = export.SigningKeyFromEnv(); err != nil {
+		return err
+	}
+	job.OnChunk = func(chunk export.ChunkInfo) {
+		fmt.Fprintf(os.Stderr, "Wrote %s (%d rows)\n", chunk.File, chunk.Rows)
+	}
+	manifest, err := job.Run(ctx, db)
+	if err != nil {
+		if manifest != nil {
+			fmt.Fprintf(os.Stderr, "Export stopped after %d rows; run the same command again to resume\n", manifest.TotalRows)
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d candidates in %d parts to %s\n", manifest.TotalRows, len(manifest.Chunks), *dir)
+	if manifest.SignedBy != "" {
+		fmt.Fprintf(os.Stderr, "Manifest signed with key %s\n", manifest.SignedBy)
+	}
+	for _, file := range manifest.Files(*dir) {
+		fmt.Println(file)
+	}
+	return nil
+}
+
+// filterString quotes a value for a filter expression, so codes starting
+// with digits and names with spaces are read as text
+func filterString(value string) string {
+	if strings.Contains(value, `"`) {
+		return "'" + value + "'"
+	}
+	return `"` + value + `"`
+}
@@ -0,0 +1,392 @@
+// Package filter implements a small filtering language for scoping candidate
+// queries without writing raw SQL, e.g.
+//
+//	year=2023 AND state IN (LAGOS,OGUN) AND aggregate>250
+//
+// Expressions are parsed and validated against a fixed set of fields and
+// compiled to a parameterised SQL condition.
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind describes the type of values a field accepts
+type Kind int
+
+const (
+	KindInt Kind = iota
+	KindString
+	KindBool
+)
+
+// Field describes a filterable attribute of a candidate
+type Field struct {
+	Name   string
+	Column string // column on the candidate table
+	Kind   Kind
+	// Lookup, when set, resolves a name to the key stored in Column, e.g.
+	// "SELECT st_id FROM state WHERE st_name". Values are upper-cased.
+	Lookup string
+	Help   string
+}
+
+// Fields lists the attributes that may be used in filter expressions
+var Fields = map[string]Field{
+	"year":         {Name: "year", Column: "year", Kind: KindInt, Help: "application year"},
+	"aggregate":    {Name: "aggregate", Column: "aggregate", Kind: KindInt, Help: "total aggregate score"},
+	"gender":       {Name: "gender", Column: "gender", Kind: KindString, Help: "M or F"},
+	"state":        {Name: "state", Column: "statecode", Kind: KindString, Lookup: "SELECT st_id FROM state WHERE st_name", Help: "state of origin name"},
+	"statecode":    {Name: "statecode", Column: "statecode", Kind: KindInt, Help: "state of origin ID"},
+	"lga":          {Name: "lga", Column: "lg_id", Kind: KindString, Lookup: "SELECT lg_id FROM lga WHERE lg_name", Help: "local government area name"},
+	"institution":  {Name: "institution", Column: "inid", Kind: KindString, Help: "institution ID"},
+	"course":       {Name: "course", Column: "app_course1", Kind: KindString, Help: "first choice course code"},
+	"regnumber":    {Name: "regnumber", Column: "regnumber", Kind: KindString, Help: "registration number"},
+	"surname":      {Name: "surname", Column: "surname", Kind: KindString, Help: "surname"},
+	"admitted":     {Name: "admitted", Column: "is_admitted", Kind: KindBool, Help: "admission status"},
+	"direct_entry": {Name: "direct_entry", Column: "is_direct_entry", Kind: KindBool, Help: "direct entry status"},
+	"sittings":     {Name: "sittings", Column: "noofsittings", Kind: KindInt, Help: "number of sittings"},
+	"tag":          {Name: "tag", Column: "regnumber", Kind: KindString, Lookup: "SELECT ct.regnumber FROM candidate_tags ct JOIN tags t ON t.id = ct.tag_id WHERE UPPER(t.name)", Help: "cohort tag name"},
+}
+
+// FieldNames returns the filterable field names in sorted order
+func FieldNames() []string {
+	names := make([]string, 0, len(Fields))
+	for name := range Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Filter is a parsed and validated filter expression
+type Filter struct {
+	source string
+	root   node
+}
+
+// Parse parses and validates a filter expression
+func Parse(input string) (*Filter, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	return &Filter{source: strings.TrimSpace(input), root: root}, nil
+}
+
+// String returns the expression as entered
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.source
+}
+
+// SQL compiles the filter to a condition over the candidate table aliased as
+// alias. Placeholders are numbered from argOffset+1 so the condition can be
+// appended to a query that already has arguments.
+func (f *Filter) SQL(alias string, argOffset int) (string, []interface{}) {
+	if f == nil || f.root == nil {
+		return "TRUE", nil
+	}
+	c := &compiler{alias: alias, offset: argOffset}
+	return f.root.compile(c), c.args
+}
+
+// Fields lists the columns the filter compares, in the order they appear
+func (f *Filter) Fields() []string {
+	var columns []string
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *logicalNode:
+			walk(n.left)
+			walk(n.right)
+		case *notNode:
+			walk(n.inner)
+		case *comparisonNode:
+			columns = append(columns, n.field.Column)
+		}
+	}
+	if f != nil {
+		walk(f.root)
+	}
+	return columns
+}
+
+type compiler struct {
+	alias  string
+	offset int
+	args   []interface{}
+}
+
+func (c *compiler) placeholder(v interface{}) string {
+	c.args = append(c.args, v)
+	return fmt.Sprintf("$%d", c.offset+len(c.args))
+}
+
+func (c *compiler) column(f Field) string {
+	if c.alias == "" {
+		return f.Column
+	}
+	return c.alias + "." + f.Column
+}
+
+type node interface {
+	compile(c *compiler) string
+}
+
+type logicalNode struct {
+	op          string // AND or OR
+	left, right node
+}
+
+func (n *logicalNode) compile(c *compiler) string {
+	return "(" + n.left.compile(c) + " " + n.op + " " + n.right.compile(c) + ")"
+}
+
+type notNode struct {
+	inner node
+}
+
+func (n *notNode) compile(c *compiler) string {
+	return "NOT " + n.inner.compile(c)
+}
+
+type comparisonNode struct {
+	field  Field
+	op     string // =, !=, <, <=, >, >=, LIKE, IN, NOT IN
+	values []interface{}
+}
+
+func (n *comparisonNode) compile(c *compiler) string {
+	col := c.column(n.field)
+
+	placeholders := make([]string, len(n.values))
+	for i, v := range n.values {
+		placeholders[i] = c.placeholder(v)
+	}
+
+	if n.field.Lookup != "" {
+		// Resolve names through the lookup table, negating outside the subquery
+		// so candidates without a match are still excluded/included correctly.
+		not := ""
+		if n.op == "!=" || n.op == "NOT IN" {
+			not = "NOT "
+		}
+		return fmt.Sprintf("%s %sIN (%s IN (%s))", col, not, n.field.Lookup, strings.Join(placeholders, ", "))
+	}
+
+	switch n.op {
+	case "IN", "NOT IN":
+		return fmt.Sprintf("%s %s (%s)", col, n.op, strings.Join(placeholders, ", "))
+	case "LIKE":
+		return fmt.Sprintf("%s ILIKE %s", col, placeholders[0])
+	case "!=":
+		return fmt.Sprintf("%s <> %s", col, placeholders[0])
+	default:
+		return fmt.Sprintf("%s %s %s", col, n.op, placeholders[0])
+	}
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, word)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{inner: inner}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at position %d", tok.pos)
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return nil, fmt.Errorf("expected field name at position %d", tok.pos)
+	}
+	field, ok := Fields[strings.ToLower(tok.text)]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q (available: %s)", tok.text, strings.Join(FieldNames(), ", "))
+	}
+
+	var op string
+	switch {
+	case p.peek().kind == tokOp:
+		op = p.next().text
+	case p.isKeyword("IN"):
+		p.next()
+		op = "IN"
+	case p.isKeyword("NOT"):
+		p.next()
+		if !p.isKeyword("IN") {
+			return nil, fmt.Errorf("expected IN after NOT at position %d", p.peek().pos)
+		}
+		p.next()
+		op = "NOT IN"
+	case p.isKeyword("LIKE"):
+		p.next()
+		op = "LIKE"
+	default:
+		return nil, fmt.Errorf("expected operator after %s at position %d", field.Name, p.peek().pos)
+	}
+
+	if err := validateOperator(field, op); err != nil {
+		return nil, err
+	}
+
+	var raw []token
+	if op == "IN" || op == "NOT IN" {
+		if tok := p.next(); tok.kind != tokLParen {
+			return nil, fmt.Errorf("expected ( after %s at position %d", op, tok.pos)
+		}
+		for {
+			v := p.next()
+			if v.kind != tokIdent && v.kind != tokNumber && v.kind != tokString {
+				return nil, fmt.Errorf("expected value at position %d", v.pos)
+			}
+			raw = append(raw, v)
+			sep := p.next()
+			if sep.kind == tokRParen {
+				break
+			}
+			if sep.kind != tokComma {
+				return nil, fmt.Errorf("expected , or ) at position %d", sep.pos)
+			}
+		}
+	} else {
+		v := p.next()
+		if v.kind != tokIdent && v.kind != tokNumber && v.kind != tokString {
+			return nil, fmt.Errorf("expected value for %s at position %d", field.Name, v.pos)
+		}
+		raw = append(raw, v)
+	}
+
+	values := make([]interface{}, len(raw))
+	for i, v := range raw {
+		value, err := convertValue(field, v.text)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	return &comparisonNode{field: field, op: op, values: values}, nil
+}
+
+func validateOperator(field Field, op string) error {
+	switch op {
+	case "=", "!=", "IN", "NOT IN":
+		return nil
+	case "LIKE":
+		if field.Kind == KindString && field.Lookup == "" {
+			return nil
+		}
+	case "<", "<=", ">", ">=":
+		if field.Kind == KindInt {
+			return nil
+		}
+	}
+	return fmt.Errorf("operator %s is not supported for field %s", op, field.Name)
+}
+
+func convertValue(field Field, text string) (interface{}, error) {
+	switch field.Kind {
+	case KindInt:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("field %s expects a number, got %q", field.Name, text)
+		}
+		return n, nil
+	case KindBool:
+		switch strings.ToLower(text) {
+		case "true", "yes", "y", "1":
+			return true, nil
+		case "false", "no", "n", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("field %s expects true or false, got %q", field.Name, text)
+	default:
+		if field.Lookup != "" || field.Name == "gender" {
+			return strings.ToUpper(text), nil
+		}
+		return text, nil
+	}
+}
@@ -0,0 +1,154 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []token
+	}{
+		{"year=2023", []token{
+			{tokIdent, "year", 0}, {tokOp, "=", 4}, {tokNumber, "2023", 5}, {tokEOF, "end of input", 9},
+		}},
+		{"aggregate >= 250", []token{
+			{tokIdent, "aggregate", 0}, {tokOp, ">=", 10}, {tokNumber, "250", 13}, {tokEOF, "end of input", 16},
+		}},
+		{"gender<>F", []token{
+			{tokIdent, "gender", 0}, {tokOp, "!=", 6}, {tokIdent, "F", 8}, {tokEOF, "end of input", 9},
+		}},
+		{"state IN ('Cross River',ogun)", []token{
+			{tokIdent, "state", 0}, {tokIdent, "IN", 6}, {tokLParen, "(", 9}, {tokString, "Cross River", 10},
+			{tokComma, ",", 23}, {tokIdent, "ogun", 24}, {tokRParen, ")", 28}, {tokEOF, "end of input", 29},
+		}},
+		{`surname LIKE "ade%"`, []token{
+			{tokIdent, "surname", 0}, {tokIdent, "LIKE", 8}, {tokString, "ade%", 13}, {tokEOF, "end of input", 19},
+		}},
+		{"regnumber = 2023ab-7", []token{
+			{tokIdent, "regnumber", 0}, {tokOp, "=", 10}, {tokNumber, "2023ab", 12}, {tokNumber, "-7", 18},
+			{tokEOF, "end of input", 20},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := lex(tt.input)
+		if err != nil {
+			t.Errorf("lex(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lex(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"year ! 2023", "expected = after ! at position 5"},
+		{"surname = 'ade", "unterminated string at position 10"},
+		{"year = 2023;", `unexpected character ';' at position 11`},
+	}
+	for _, tt := range tests {
+		_, err := lex(tt.input)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("lex(%q) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		sql   string
+		args  []interface{}
+	}{
+		{"year=2023", "c.year = $1", []interface{}{2023}},
+		{"year=2023 AND aggregate>250", "(c.year = $1 AND c.aggregate > $2)", []interface{}{2023, 250}},
+		{"year = 2023 or (sittings >= 2 and direct_entry = no)",
+			"(c.year = $1 OR (c.noofsittings >= $2 AND c.is_direct_entry = $3))", []interface{}{2023, 2, false}},
+		{"year = 1 OR sittings = 2 AND aggregate = 3",
+			"(c.year = $1 OR (c.noofsittings = $2 AND c.aggregate = $3))", []interface{}{1, 2, 3}},
+		{"NOT admitted = yes", "NOT c.is_admitted = $1", []interface{}{true}},
+		{"gender <> f", "c.gender <> $1", []interface{}{"F"}},
+		{"surname LIKE 'ade%'", "c.surname ILIKE $1", []interface{}{"ade%"}},
+		{"course NOT IN (ENG101, 'MTH 102')", "c.app_course1 NOT IN ($1, $2)", []interface{}{"ENG101", "MTH 102"}},
+		{"state IN (lagos, 'cross river')",
+			"c.statecode IN (SELECT st_id FROM state WHERE st_name IN ($1, $2))", []interface{}{"LAGOS", "CROSS RIVER"}},
+		{"state != ogun", "c.statecode NOT IN (SELECT st_id FROM state WHERE st_name IN ($1))", []interface{}{"OGUN"}},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		sql, args := f.SQL("c", 0)
+		if sql != tt.sql {
+			t.Errorf("Parse(%q).SQL = %q, want %q", tt.input, sql, tt.sql)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Parse(%q) args = %v, want %v", tt.input, args, tt.args)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "expected field name at position 0"},
+		{"height = 3", `unknown field "height"`},
+		{"year 2023", "expected operator after year at position 5"},
+		{"year = abc", `field year expects a number, got "abc"`},
+		{"admitted = maybe", `field admitted expects true or false, got "maybe"`},
+		{"gender > M", "operator > is not supported for field gender"},
+		{"state LIKE LAG%", "operator LIKE is not supported for field state"},
+		{"year = 2023 AND", "expected field name at position 15"},
+		{"year = 2023 year = 2024", `unexpected "year" at position 12`},
+		{"(year = 2023", "expected ) at position 12"},
+		{"year IN 2023", "expected ( after IN at position 8"},
+		{"year IN (2023 2024)", "expected , or ) at position 14"},
+		{"year NOT 2023", "expected IN after NOT at position 9"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestSQLOffsetAndAlias(t *testing.T) {
+	f, err := Parse("year = 2023 AND gender = m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, args := f.SQL("", 2)
+	if want := "(year = $3 AND gender = $4)"; sql != want {
+		t.Errorf("SQL = %q, want %q", sql, want)
+	}
+	if want := []interface{}{2023, "M"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+
+	var none *Filter
+	if sql, args := none.SQL("c", 0); sql != "TRUE" || args != nil {
+		t.Errorf("nil filter SQL = %q, %v", sql, args)
+	}
+}
+
+func TestFields(t *testing.T) {
+	f, err := Parse("state = lagos AND NOT (tag = scholars OR aggregate > 200)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Fields(), []string{"statecode", "regnumber", "aggregate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Fields() = %v, want %v", got, want)
+	}
+}
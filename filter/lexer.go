@@ -0,0 +1,97 @@
+package filter
+
+import (
+	"fmt"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits a filter expression into tokens. Bare words are identifiers and
+// may be used as values (e.g. LAGOS); quoted strings allow spaces.
+func lex(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case r == '=':
+			tokens = append(tokens, token{kind: tokOp, text: "=", pos: i})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			} else if r == '<' && i < len(runes) && runes[i] == '>' {
+				i++
+			} else if r == '!' {
+				return nil, fmt.Errorf("expected = after ! at position %d", start)
+			}
+			text := string(runes[start:i])
+			if text == "<>" {
+				text = "!="
+			}
+			tokens = append(tokens, token{kind: tokOp, text: text, pos: start})
+		case r == '\'' || r == '"':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			tokens = append(tokens, token{kind: tokString, text: string(runes[start+1 : i]), pos: start})
+			i++
+		case unicode.IsDigit(r) || r == '-':
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || unicode.IsLetter(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_' || r == '%':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				runes[i] == '_' || runes[i] == '%' || runes[i] == '.' || runes[i] == '-') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokEOF, text: "end of input", pos: len(runes)})
+	return tokens, nil
+}
@@ -0,0 +1,317 @@
+// Package formula implements per-year aggregate formulas: arithmetic
+// expressions over subject scores such as
+//
+//	round((ENG * 1.2 + MTH + PHY + CHM) / 4.2 * 4, 2)
+//
+// Subjects are referenced by abbreviation or name (quoted if it contains
+// spaces). Formulas compile to SQL so aggregates are recomputed in the
+// database rather than row by row.
+package formula
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Functions lists the functions a formula may call with their arity; -1
+// accepts one or more arguments.
+var Functions = map[string]int{
+	"min":      -1, // smallest argument
+	"max":      -1, // largest argument
+	"round":    2,  // round(x, digits)
+	"coalesce": -1, // first non-missing argument, e.g. coalesce(BIO, AGR)
+	"abs":      1,
+}
+
+// Total is a variable holding the sum of all subject scores a candidate sat
+const Total = "total"
+
+type node interface{}
+
+type numberNode struct{ value float64 }
+
+type subjectNode struct {
+	name string
+	pos  int
+}
+
+type totalNode struct{}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+type callNode struct {
+	fn   string
+	args []node
+}
+
+// Formula is a parsed aggregate formula
+type Formula struct {
+	source string
+	root   node
+}
+
+// Parse parses a formula
+func Parse(input string) (*Formula, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	return &Formula{source: strings.TrimSpace(input), root: root}, nil
+}
+
+// String returns the formula as written
+func (f *Formula) String() string {
+	return f.source
+}
+
+// Subjects returns the subject names the formula references, in order of
+// first use
+func (f *Formula) Subjects() []string {
+	var names []string
+	seen := make(map[string]bool)
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case subjectNode:
+			key := strings.ToLower(n.name)
+			if !seen[key] {
+				seen[key] = true
+				names = append(names, n.name)
+			}
+		case unaryNode:
+			walk(n.operand)
+		case binaryNode:
+			walk(n.left)
+			walk(n.right)
+		case callNode:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(f.root)
+	return names
+}
+
+// SQL compiles the formula to an aggregate expression over candidate_scores
+// rows aliased cs and grouped by candidate. resolve maps a subject reference
+// to its subject ID. Placeholders are numbered from argOffset+1.
+func (f *Formula) SQL(resolve func(name string) (int, error), argOffset int) (string, []interface{}, error) {
+	c := &compiler{resolve: resolve, argOffset: argOffset}
+	sql, err := c.compile(f.root)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, c.args, nil
+}
+
+type compiler struct {
+	resolve   func(name string) (int, error)
+	argOffset int
+	args      []interface{}
+}
+
+func (c *compiler) placeholder(v interface{}) string {
+	c.args = append(c.args, v)
+	return fmt.Sprintf("$%d", c.argOffset+len(c.args))
+}
+
+func (c *compiler) compile(n node) (string, error) {
+	switch n := n.(type) {
+	case numberNode:
+		return strconv.FormatFloat(n.value, 'f', -1, 64) + "::numeric", nil
+	case totalNode:
+		return "SUM(cs.score)::numeric", nil
+	case subjectNode:
+		id, err := c.resolve(n.name)
+		if err != nil {
+			return "", fmt.Errorf("%w at position %d", err, n.pos)
+		}
+		return fmt.Sprintf("(MAX(cs.score) FILTER (WHERE cs.subject_id = %s))::numeric", c.placeholder(id)), nil
+	case unaryNode:
+		operand, err := c.compile(n.operand)
+		if err != nil {
+			return "", err
+		}
+		return "(-" + operand + ")", nil
+	case binaryNode:
+		left, err := c.compile(n.left)
+		if err != nil {
+			return "", err
+		}
+		right, err := c.compile(n.right)
+		if err != nil {
+			return "", err
+		}
+		if n.op == "/" {
+			// Division by a zero score yields a missing aggregate rather than an error
+			right = "NULLIF(" + right + ", 0)"
+		}
+		return "(" + left + " " + n.op + " " + right + ")", nil
+	case callNode:
+		args := make([]string, len(n.args))
+		for i, arg := range n.args {
+			var err error
+			if args[i], err = c.compile(arg); err != nil {
+				return "", err
+			}
+		}
+		switch n.fn {
+		case "min":
+			return "LEAST(" + strings.Join(args, ", ") + ")", nil
+		case "max":
+			return "GREATEST(" + strings.Join(args, ", ") + ")", nil
+		case "round":
+			return "ROUND(" + args[0] + ", (" + args[1] + ")::int)", nil
+		case "coalesce":
+			return "COALESCE(" + strings.Join(args, ", ") + ")", nil
+		case "abs":
+			return "ABS(" + args[0] + ")", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported formula node %T", n)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseExpr handles + and -
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok.kind == tokOp && (tok.text == "+" || tok.text == "-"); tok = p.peek() {
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: tok.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseTerm handles * and /
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok.kind == tokOp && (tok.text == "*" || tok.text == "/"); tok = p.peek() {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: tok.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: "-", operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return numberNode{value: v}, nil
+	case tokString:
+		return subjectNode{name: tok.text, pos: tok.pos}, nil
+	case tokIdent:
+		if p.peek().kind == tokLParen {
+			return p.parseCall(tok)
+		}
+		if strings.EqualFold(tok.text, Total) {
+			return totalNode{}, nil
+		}
+		return subjectNode{name: tok.text, pos: tok.pos}, nil
+	case tokLParen:
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at position %d", closing.pos)
+		}
+		return expr, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn := strings.ToLower(name.text)
+	arity, ok := Functions[fn]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	p.next() // (
+
+	var args []node
+	if p.peek().kind != tokRParen {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if closing := p.next(); closing.kind != tokRParen {
+		return nil, fmt.Errorf("expected ) at position %d", closing.pos)
+	}
+
+	if (arity < 0 && len(args) == 0) || (arity >= 0 && len(args) != arity) {
+		return nil, fmt.Errorf("wrong number of arguments to %s at position %d", fn, name.pos)
+	}
+	return callNode{fn: fn, args: args}, nil
+}
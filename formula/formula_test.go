@@ -0,0 +1,118 @@
+package formula
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// subjectIDs resolves the subjects used in these tests
+func subjectIDs(name string) (int, error) {
+	ids := map[string]int{"eng": 1, "mth": 2, "phy": 3, "use of english": 4}
+	if id, ok := ids[strings.ToLower(name)]; ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("unknown subject %q", name)
+}
+
+func score(placeholder string) string {
+	return "(MAX(cs.score) FILTER (WHERE cs.subject_id = " + placeholder + "))::numeric"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		sql      string
+		args     []interface{}
+		subjects []string
+	}{
+		{"ENG", score("$1"), []interface{}{1}, []string{"ENG"}},
+		{"total / 4", "(SUM(cs.score)::numeric / NULLIF(4::numeric, 0))", nil, nil},
+		{"ENG + MTH * 2",
+			"(" + score("$1") + " + (" + score("$2") + " * 2::numeric))", []interface{}{1, 2}, []string{"ENG", "MTH"}},
+		{"(ENG + MTH) * 2",
+			"((" + score("$1") + " + " + score("$2") + ") * 2::numeric)", []interface{}{1, 2}, []string{"ENG", "MTH"}},
+		{"ENG - MTH - PHY",
+			"((" + score("$1") + " - " + score("$2") + ") - " + score("$3") + ")", []interface{}{1, 2, 3}, []string{"ENG", "MTH", "PHY"}},
+		{"-ENG", "(-" + score("$1") + ")", []interface{}{1}, []string{"ENG"}},
+		{`"Use of English" * 1.5`, "(" + score("$1") + " * 1.5::numeric)", []interface{}{4}, []string{"Use of English"}},
+		{"max(ENG, eng, MTH)",
+			"GREATEST(" + score("$1") + ", " + score("$2") + ", " + score("$3") + ")", []interface{}{1, 1, 2}, []string{"ENG", "MTH"}},
+		{"ROUND(total / 4, 2)", "ROUND((SUM(cs.score)::numeric / NULLIF(4::numeric, 0)), (2::numeric)::int)", nil, nil},
+		{"coalesce(PHY, 0) + abs(min(ENG, 10))",
+			"(COALESCE(" + score("$1") + ", 0::numeric) + ABS(LEAST(" + score("$2") + ", 10::numeric)))",
+			[]interface{}{3, 1}, []string{"PHY", "ENG"}},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		sql, args, err := f.SQL(subjectIDs, 0)
+		if err != nil {
+			t.Errorf("Parse(%q).SQL: %v", tt.input, err)
+			continue
+		}
+		if sql != tt.sql {
+			t.Errorf("Parse(%q).SQL = %q, want %q", tt.input, sql, tt.sql)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Parse(%q) args = %v, want %v", tt.input, args, tt.args)
+		}
+		if got := f.Subjects(); !reflect.DeepEqual(got, tt.subjects) {
+			t.Errorf("Parse(%q).Subjects() = %v, want %v", tt.input, got, tt.subjects)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", `unexpected "end of input" at position 0`},
+		{"ENG +", `unexpected "end of input" at position 5`},
+		{"ENG MTH", `unexpected "MTH" at position 4`},
+		{"(ENG + MTH", "expected ) at position 10"},
+		{"ENG % 2", `unexpected character '%' at position 4`},
+		{`"Use of English`, "unterminated string at position 0"},
+		{"1.2.3", `invalid number "1.2.3" at position 0`},
+		{"sqrt(ENG)", `unknown function "sqrt" at position 0`},
+		{"round(ENG)", "wrong number of arguments to round at position 0"},
+		{"max()", "wrong number of arguments to max at position 0"},
+		{"abs(ENG, MTH", "expected ) at position 12"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestSQLOffsetAndUnknownSubject(t *testing.T) {
+	f, err := Parse("ENG + MTH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, args, err := f.SQL(subjectIDs, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "(" + score("$4") + " + " + score("$5") + ")"; sql != want {
+		t.Errorf("SQL = %q, want %q", sql, want)
+	}
+	if want := []interface{}{1, 2}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+
+	f, err = Parse("ENG + BIO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.SQL(subjectIDs, 0); err == nil || err.Error() != `unknown subject "BIO" at position 6` {
+		t.Errorf("SQL with unknown subject: error = %v", err)
+	}
+}
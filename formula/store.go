@@ -0,0 +1,169 @@
+package formula
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Definition is the aggregate formula configured for a year
+type Definition struct {
+	Year        int
+	Expression  string
+	Description string
+	UpdatedAt   time.Time
+}
+
+// Store keeps formulas in aggregate_formulas and their results in
+// normalized_aggregates
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// List returns all configured formulas ordered by year
+func (s *Store) List(ctx context.Context) ([]Definition, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT year, expression, COALESCE(description, ''), updated_at
+        FROM aggregate_formulas
+        ORDER BY year`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var defs []Definition
+	for rows.Next() {
+		var d Definition
+		if err := rows.Scan(&d.Year, &d.Expression, &d.Description, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		defs = append(defs, d)
+	}
+	return defs, rows.Err()
+}
+
+// Get returns the formula for year, or nil if none is configured
+func (s *Store) Get(ctx context.Context, year int) (*Definition, error) {
+	d := Definition{Year: year}
+	err := s.db.QueryRowContext(ctx, `
+        SELECT expression, COALESCE(description, ''), updated_at
+        FROM aggregate_formulas WHERE year = $1`, year).Scan(&d.Expression, &d.Description, &d.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Set validates and saves the formula for year, replacing any existing one
+func (s *Store) Set(ctx context.Context, year int, expression, description string) error {
+	f, err := Parse(expression)
+	if err != nil {
+		return fmt.Errorf("invalid formula: %w", err)
+	}
+	resolve, err := s.subjectResolver(ctx)
+	if err != nil {
+		return err
+	}
+	if _, _, err := f.SQL(resolve, 0); err != nil {
+		return fmt.Errorf("invalid formula: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+        INSERT INTO aggregate_formulas (year, expression, description, updated_at)
+        VALUES ($1, $2, $3, NOW())
+        ON CONFLICT (year) DO UPDATE
+        SET expression = EXCLUDED.expression,
+            description = EXCLUDED.description,
+            updated_at = NOW()`, year, f.String(), description)
+	return err
+}
+
+// Recompute replaces the normalized aggregates for year using its formula
+// and returns the number of candidates computed
+func (s *Store) Recompute(ctx context.Context, year int) (int64, error) {
+	def, err := s.Get(ctx, year)
+	if err != nil {
+		return 0, err
+	}
+	if def == nil {
+		return 0, fmt.Errorf("no aggregate formula configured for %d", year)
+	}
+	f, err := Parse(def.Expression)
+	if err != nil {
+		return 0, fmt.Errorf("stored formula for %d is invalid: %w", year, err)
+	}
+	resolve, err := s.subjectResolver(ctx)
+	if err != nil {
+		return 0, err
+	}
+	expr, args, err := f.SQL(resolve, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM normalized_aggregates WHERE year = $1", year); err != nil {
+		return 0, fmt.Errorf("error clearing normalized aggregates: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(`
+        INSERT INTO normalized_aggregates (cand_reg_number, year, aggregate)
+        SELECT cs.cand_reg_number, cs.year, ROUND(%s, 2)
+        FROM candidate_scores cs
+        WHERE cs.year = $1
+        GROUP BY cs.cand_reg_number, cs.year`, expr), append([]interface{}{year}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("error computing normalized aggregates: %w", err)
+	}
+	count, _ := result.RowsAffected()
+	return count, tx.Commit()
+}
+
+// subjectResolver resolves subject references case-insensitively by
+// abbreviation or full name
+func (s *Store) subjectResolver(ctx context.Context) (func(string) (int, error), error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT su_id, COALESCE(su_abrv, ''), COALESCE(su_name, '') FROM subject")
+	if err != nil {
+		return nil, fmt.Errorf("error loading subjects: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var abrv, name string
+		if err := rows.Scan(&id, &abrv, &name); err != nil {
+			return nil, err
+		}
+		if abrv != "" {
+			ids[strings.ToLower(strings.TrimSpace(abrv))] = id
+		}
+		if name != "" {
+			ids[strings.ToLower(strings.TrimSpace(name))] = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return func(name string) (int, error) {
+		id, ok := ids[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown subject %q", name)
+		}
+		return id, nil
+	}, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/importer"
+)
+
+// handleGenderAudit reports rows whose gender was nulled during import and
+// lets the raw values be mapped to M or F
+func handleGenderAudit(ctx context.Context, db *sql.DB) error {
+	values, err := importer.UnresolvedGenderValues(ctx, db)
+	if err != nil {
+		return fmt.Errorf("error loading gender audit: %w", err)
+	}
+	if len(values) == 0 {
+		color.Green("No unresolved gender values")
+		return nil
+	}
+
+	color.Yellow("\nUnrecognised Gender Values")
+	table := newResultTable("gender-values")
+	table.SetHeader([]string{"#", "Raw Value", "Rows", "Sample Reg Numbers"})
+	for i, v := range values {
+		table.Append([]string{
+			fmt.Sprintf("%d", i+1),
+			fmt.Sprintf("%q", v.RawValue),
+			fmt.Sprintf("%d", v.Rows),
+			strings.Join(v.Sample, ", "),
+		})
+	}
+	table.Render()
+
+	fmt.Print("\nReclassify these values now? (y/n): ")
+	if strings.ToLower(readString()) != "y" {
+		return nil
+	}
+
+	fmt.Println("For each value enter M or F, or press Enter to skip. Mappings are also used by future imports.")
+	for _, v := range values {
+		fmt.Printf("%q (%d rows): ", v.RawValue, v.Rows)
+		gender := strings.ToUpper(readString())
+		if gender == "" {
+			continue
+		}
+		if gender != "M" && gender != "F" {
+			color.Red("Skipping %q: enter M or F", v.RawValue)
+			continue
+		}
+		n, err := importer.MapGenderValue(ctx, db, v.RawValue, gender)
+		if err != nil {
+			return err
+		}
+		color.Green("Mapped %q to %s; updated %d candidates", v.RawValue, gender, n)
+	}
+	return nil
+}
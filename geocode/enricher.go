@@ -0,0 +1,175 @@
+package geocode
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/nonsonwune/spk2_db/joblog"
+)
+
+// Location sources
+const (
+	SourceAddress  = "address"
+	SourceExamTown = "exam_town"
+)
+
+// sourceQueries yield (regnumber, query) pairs for each location source.
+// Queries are normalised so identical locations are geocoded once.
+var sourceQueries = map[string]string{
+	SourceAddress: `
+        SELECT c.regnumber,
+               UPPER(REGEXP_REPLACE(TRIM(c.address), '\s+', ' ', 'g')) ||
+               COALESCE(', ' || s.st_name, '') || ', NIGERIA' AS query
+        FROM candidate c
+        LEFT JOIN state s ON s.st_id = c.statecode
+        WHERE NULLIF(TRIM(c.address), '') IS NOT NULL`,
+	SourceExamTown: `
+        SELECT e.cand_reg_number AS regnumber,
+               UPPER(REGEXP_REPLACE(TRIM(e.exam_town), '\s+', ' ', 'g')) || ', NIGERIA' AS query
+        FROM candidate_exam_info e
+        WHERE NULLIF(TRIM(e.exam_town), '') IS NOT NULL`,
+}
+
+// Progress counts the work done by a running enrichment
+type Progress struct {
+	Queried  atomic.Int64
+	Found    atomic.Int64
+	NotFound atomic.Int64
+	Failed   atomic.Int64
+	Linked   atomic.Int64
+}
+
+// Enricher geocodes candidate locations into candidate_locations, caching
+// every distinct query in geocode_cache so reruns only look up new places
+type Enricher struct {
+	db       *sql.DB
+	geocoder Geocoder
+	jobs     *joblog.Log
+	Progress Progress
+}
+
+func NewEnricher(db *sql.DB, geocoder Geocoder, jobs *joblog.Log) *Enricher {
+	return &Enricher{db: db, geocoder: geocoder, jobs: jobs}
+}
+
+// Run geocodes up to limit distinct locations not yet in the cache, most
+// common first, then links every candidate whose location is now known.
+func (e *Enricher) Run(ctx context.Context, source string, limit int) error {
+	query, ok := sourceQueries[source]
+	if !ok {
+		return fmt.Errorf("unknown location source %q", source)
+	}
+	job := "geocode:" + source
+	e.record(ctx, job, "start", joblog.StatusStarted, fmt.Sprintf("provider %s, limit %d", e.geocoder.Name(), limit))
+
+	pending, err := e.pendingQueries(ctx, query, limit)
+	if err != nil {
+		e.record(ctx, job, "select", joblog.StatusFailed, err.Error())
+		return err
+	}
+
+	for _, q := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		point, err := e.geocoder.Geocode(ctx, q)
+		e.Progress.Queried.Add(1)
+		switch {
+		case err == nil:
+			e.Progress.Found.Add(1)
+			err = e.cache(ctx, q, &point, "found")
+		case errors.Is(err, ErrNotFound):
+			e.Progress.NotFound.Add(1)
+			err = e.cache(ctx, q, nil, "not_found")
+		default:
+			// Provider errors aren't cached so the location is retried next run
+			e.Progress.Failed.Add(1)
+			log.Printf("Geocoding %q failed: %v", q, err)
+			err = nil
+		}
+		if err != nil {
+			e.record(ctx, job, "cache", joblog.StatusFailed, err.Error())
+			return err
+		}
+	}
+
+	linked, err := e.link(ctx, source, query)
+	if err != nil {
+		e.record(ctx, job, "link", joblog.StatusFailed, err.Error())
+		return err
+	}
+	e.Progress.Linked.Store(linked)
+
+	e.record(ctx, job, "finish", joblog.StatusSucceeded, fmt.Sprintf(
+		"%d queried, %d found, %d not found, %d failed, %d candidates located",
+		e.Progress.Queried.Load(), e.Progress.Found.Load(), e.Progress.NotFound.Load(),
+		e.Progress.Failed.Load(), linked))
+	return ctx.Err()
+}
+
+func (e *Enricher) pendingQueries(ctx context.Context, sourceQuery string, limit int) ([]string, error) {
+	rows, err := e.db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT src.query
+        FROM (%s) src
+        LEFT JOIN geocode_cache g ON g.query = src.query
+        WHERE g.query IS NULL
+        GROUP BY src.query
+        ORDER BY COUNT(*) DESC
+        LIMIT $1`, sourceQuery), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting locations to geocode: %w", err)
+	}
+	defer rows.Close()
+
+	var queries []string
+	for rows.Next() {
+		var q string
+		if err := rows.Scan(&q); err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+func (e *Enricher) cache(ctx context.Context, query string, point *Point, status string) error {
+	var lat, lng interface{}
+	if point != nil {
+		lat, lng = point.Lat, point.Lng
+	}
+	_, err := e.db.ExecContext(ctx, `
+        INSERT INTO geocode_cache (query, lat, lng, provider, status)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (query) DO UPDATE
+        SET lat = EXCLUDED.lat, lng = EXCLUDED.lng, provider = EXCLUDED.provider,
+            status = EXCLUDED.status, geocoded_at = NOW()`,
+		query, lat, lng, e.geocoder.Name(), status)
+	return err
+}
+
+func (e *Enricher) link(ctx context.Context, source, sourceQuery string) (int64, error) {
+	res, err := e.db.ExecContext(ctx, fmt.Sprintf(`
+        INSERT INTO candidate_locations (regnumber, source, lat, lng)
+        SELECT src.regnumber, $1, g.lat, g.lng
+        FROM (%s) src
+        JOIN geocode_cache g ON g.query = src.query AND g.status = 'found'
+        ON CONFLICT (regnumber, source) DO UPDATE
+        SET lat = EXCLUDED.lat, lng = EXCLUDED.lng, updated_at = NOW()`, sourceQuery), source)
+	if err != nil {
+		return 0, fmt.Errorf("error linking candidate locations: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (e *Enricher) record(ctx context.Context, job, step, status, detail string) {
+	if e.jobs == nil {
+		return
+	}
+	if err := e.jobs.Record(context.WithoutCancel(ctx), joblog.Entry{Job: job, Step: step, Status: status, Detail: detail}); err != nil {
+		log.Printf("Warning: failed to record geocoding status: %v", err)
+	}
+}
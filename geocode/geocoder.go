@@ -0,0 +1,200 @@
+// Package geocode resolves candidate addresses and exam towns to
+// coordinates through a pluggable geocoding provider.
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when the provider has no result for a query
+var ErrNotFound = errors.New("location not found")
+
+// Point is a WGS84 coordinate
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+// Geocoder resolves a free-text location to a point
+type Geocoder interface {
+	Name() string
+	Geocode(ctx context.Context, query string) (Point, error)
+}
+
+// FromEnv builds the geocoder selected by GEOCODER (nominatim or google).
+// Nominatim uses GEOCODER_URL and GEOCODER_USER_AGENT; Google uses
+// GOOGLE_MAPS_API_KEY. There is no default Nominatim server, as candidate
+// addresses must not be sent to the public OSM instance without someone
+// choosing to: GEOCODER_URL should name a self-hosted server.
+func FromEnv() (Geocoder, error) {
+	switch provider := os.Getenv("GEOCODER"); provider {
+	case "", "nominatim":
+		baseURL := os.Getenv("GEOCODER_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("GEOCODER_URL is required for the nominatim geocoder; set it to a self-hosted Nominatim server")
+		}
+		userAgent := os.Getenv("GEOCODER_USER_AGENT")
+		if userAgent == "" {
+			userAgent = "spk2_db-geocoder"
+		}
+		return NewNominatim(baseURL, userAgent), nil
+	case "google":
+		key := os.Getenv("GOOGLE_MAPS_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("GOOGLE_MAPS_API_KEY is required for the google geocoder")
+		}
+		return NewGoogle(key), nil
+	default:
+		return nil, fmt.Errorf("unknown GEOCODER %q (use nominatim or google)", provider)
+	}
+}
+
+// limiter spaces requests at least interval apart
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if d := l.interval - time.Since(l.last); d > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	l.last = time.Now()
+	return nil
+}
+
+// Nominatim queries an OpenStreetMap Nominatim server at most once a
+// second, the rate the public instance allows.
+type Nominatim struct {
+	baseURL   string
+	userAgent string
+	client    *http.Client
+	limit     *limiter
+}
+
+func NewNominatim(baseURL, userAgent string) *Nominatim {
+	return &Nominatim{
+		baseURL:   baseURL,
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		limit:     &limiter{interval: time.Second},
+	}
+}
+
+func (n *Nominatim) Name() string { return "nominatim" }
+
+func (n *Nominatim) Geocode(ctx context.Context, query string) (Point, error) {
+	if err := n.limit.wait(ctx); err != nil {
+		return Point{}, err
+	}
+	params := url.Values{"q": {query}, "format": {"jsonv2"}, "limit": {"1"}, "countrycodes": {"ng"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.baseURL+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return Point{}, err
+	}
+	req.Header.Set("User-Agent", n.userAgent)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return Point{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Point{}, fmt.Errorf("nominatim returned %s", resp.Status)
+	}
+
+	var results []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return Point{}, fmt.Errorf("error decoding nominatim response: %w", err)
+	}
+	if len(results) == 0 {
+		return Point{}, ErrNotFound
+	}
+	lat, err := strconv.ParseFloat(results[0].Lat, 64)
+	if err != nil {
+		return Point{}, err
+	}
+	lng, err := strconv.ParseFloat(results[0].Lon, 64)
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{Lat: lat, Lng: lng}, nil
+}
+
+// Google queries the Google Maps Geocoding API
+type Google struct {
+	apiKey string
+	client *http.Client
+	limit  *limiter
+}
+
+func NewGoogle(apiKey string) *Google {
+	return &Google{
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 30 * time.Second},
+		limit:  &limiter{interval: 50 * time.Millisecond},
+	}
+}
+
+func (g *Google) Name() string { return "google" }
+
+func (g *Google) Geocode(ctx context.Context, query string) (Point, error) {
+	if err := g.limit.wait(ctx); err != nil {
+		return Point{}, err
+	}
+	params := url.Values{"address": {query}, "region": {"ng"}, "key": {g.apiKey}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"https://maps.googleapis.com/maps/api/geocode/json?"+params.Encode(), nil)
+	if err != nil {
+		return Point{}, err
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return Point{}, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status  string `json:"status"`
+		Error   string `json:"error_message"`
+		Results []struct {
+			Geometry struct {
+				Location struct {
+					Lat float64 `json:"lat"`
+					Lng float64 `json:"lng"`
+				} `json:"location"`
+			} `json:"geometry"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Point{}, fmt.Errorf("error decoding google response: %w", err)
+	}
+	switch body.Status {
+	case "OK":
+		loc := body.Results[0].Geometry.Location
+		return Point{Lat: loc.Lat, Lng: loc.Lng}, nil
+	case "ZERO_RESULTS":
+		return Point{}, ErrNotFound
+	}
+	return Point{}, fmt.Errorf("google geocoder: %s %s", body.Status, body.Error)
+}
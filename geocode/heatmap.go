@@ -0,0 +1,52 @@
+package geocode
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteHeatmap writes candidate locations from source binned to a grid of
+// cellSize degrees as CSV rows of lat,lng,candidates, the format heatmap
+// layers in QGIS, Kepler.gl and Leaflet.heat import directly. Cells with
+// fewer than minCount candidates are left out, which keeps a published
+// heatmap from pinpointing individuals.
+func WriteHeatmap(ctx context.Context, db *sql.DB, w io.Writer, source string, cellSize float64, minCount int) (int, error) {
+	if cellSize <= 0 {
+		return 0, fmt.Errorf("cell size must be positive")
+	}
+	rows, err := db.QueryContext(ctx, `
+        SELECT ROUND((FLOOR(lat / $2) * $2 + $2 / 2)::numeric, 5) AS cell_lat,
+               ROUND((FLOOR(lng / $2) * $2 + $2 / 2)::numeric, 5) AS cell_lng,
+               COUNT(*)
+        FROM candidate_locations
+        WHERE source = $1
+        GROUP BY 1, 2
+        HAVING COUNT(*) >= $3
+        ORDER BY 3 DESC`, source, cellSize, minCount)
+	if err != nil {
+		return 0, fmt.Errorf("error building heatmap: %w", err)
+	}
+	defer rows.Close()
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"lat", "lng", "candidates"})
+	cells := 0
+	for rows.Next() {
+		var lat, lng string
+		var count int
+		if err := rows.Scan(&lat, &lng, &count); err != nil {
+			return cells, err
+		}
+		out.Write([]string{lat, lng, strconv.Itoa(count)})
+		cells++
+	}
+	if err := rows.Err(); err != nil {
+		return cells, err
+	}
+	out.Flush()
+	return cells, out.Error()
+}
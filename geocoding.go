@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/geocode"
+	"github.com/nonsonwune/spk2_db/joblog"
+	"github.com/olekukonko/tablewriter"
+)
+
+// geocodeJob is the enrichment running in the background, if any
+var geocodeJob struct {
+	enricher *geocode.Enricher
+	source   string
+	started  time.Time
+	done     chan struct{}
+	err      error
+}
+
+// handleGeocoding starts the background geocoding job and reports coverage
+func handleGeocoding(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nGeocoding")
+	fmt.Println("1. Start geocoding job")
+	fmt.Println("2. Show status and coverage")
+	fmt.Println("3. Export heatmap CSV")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		return startGeocoding(ctx, db)
+	case "2":
+		return showGeocodingStatus(ctx, db)
+	case "3":
+		return exportHeatmap(ctx, db)
+	case "0":
+		return nil
+	default:
+		return fmt.Errorf("invalid choice")
+	}
+}
+
+func readLocationSource() string {
+	fmt.Print("Locate by (1) candidate address or (2) exam town: ")
+	if readString() == "2" {
+		return geocode.SourceExamTown
+	}
+	return geocode.SourceAddress
+}
+
+func startGeocoding(ctx context.Context, db *sql.DB) error {
+	if geocodeJob.done != nil {
+		select {
+		case <-geocodeJob.done:
+		default:
+			return fmt.Errorf("a geocoding job is already running")
+		}
+	}
+
+	geocoder, err := geocode.FromEnv()
+	if err != nil {
+		return err
+	}
+	source := readLocationSource()
+	fmt.Print("Maximum distinct locations to look up (0 for 1000): ")
+	limit := readInt()
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	jobs := joblog.New(db)
+	geocodeJob.enricher = geocode.NewEnricher(db, geocoder, jobs)
+	geocodeJob.source = source
+	geocodeJob.started = time.Now()
+	geocodeJob.err = nil
+	geocodeJob.done = make(chan struct{})
+
+	go func(e *geocode.Enricher, done chan struct{}) {
+		defer close(done)
+		if err := e.Run(ctx, source, limit); err != nil {
+			geocodeJob.err = err
+			log.Printf("Geocoding job failed: %v", err)
+		}
+	}(geocodeJob.enricher, geocodeJob.done)
+
+	color.Green("Geocoding %s locations with %s in the background; check progress with option 2", source, geocoder.Name())
+	return nil
+}
+
+func showGeocodingStatus(ctx context.Context, db *sql.DB) error {
+	if geocodeJob.enricher != nil {
+		state := "running"
+		select {
+		case <-geocodeJob.done:
+			state = "finished"
+			if geocodeJob.err != nil {
+				state = "failed: " + geocodeJob.err.Error()
+			}
+		default:
+		}
+		p := &geocodeJob.enricher.Progress
+		fmt.Printf("\nLast job (%s, started %s): %s\n", geocodeJob.source, geocodeJob.started.Format("15:04:05"), state)
+		fmt.Printf("Looked up %d locations: %d found, %d not found, %d failed; %d candidates located\n",
+			p.Queried.Load(), p.Found.Load(), p.NotFound.Load(), p.Failed.Load(), p.Linked.Load())
+	}
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT src.source, src.total, COALESCE(l.located, 0)
+        FROM (
+            SELECT 'address' AS source, COUNT(*) AS total
+            FROM candidate WHERE NULLIF(TRIM(address), '') IS NOT NULL
+            UNION ALL
+            SELECT 'exam_town', COUNT(*)
+            FROM candidate_exam_info WHERE NULLIF(TRIM(exam_town), '') IS NOT NULL
+        ) src
+        LEFT JOIN (
+            SELECT source, COUNT(*) AS located FROM candidate_locations GROUP BY source
+        ) l ON l.source = src.source`)
+	if err != nil {
+		return fmt.Errorf("error calculating geocoding coverage: %w", err)
+	}
+	defer rows.Close()
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Source", "Candidates With Location", "Geocoded", "Coverage"})
+	for rows.Next() {
+		var source string
+		var total, located int
+		if err := rows.Scan(&source, &total, &located); err != nil {
+			return err
+		}
+		coverage := 0.0
+		if total > 0 {
+			coverage = float64(located) * 100 / float64(total)
+		}
+		table.Append([]string{source, fmt.Sprintf("%d", total), fmt.Sprintf("%d", located), fmt.Sprintf("%.1f%%", coverage)})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	table.Render()
+	return nil
+}
+
+func exportHeatmap(ctx context.Context, db *sql.DB) error {
+	source := readLocationSource()
+	fmt.Print("Output file (e.g. heatmap.csv): ")
+	path := readString()
+	if path == "" {
+		path = "heatmap_" + source + ".csv"
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	// Cells of 0.01 degrees are roughly 1km, fine enough for town-level patterns
+	minCount := 1
+	if publicOutput != nil {
+		minCount = publicOutput.K
+	}
+	cells, err := geocode.WriteHeatmap(ctx, db, f, source, 0.01, minCount)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	color.Green("Wrote %d heatmap cells to %s", cells, path)
+	return nil
+}
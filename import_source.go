@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/importer"
+)
+
+// openImportSource opens a CSV file or an Excel workbook for import, asking
+// which sheet to read when a workbook has several. size is the CSV file's
+// size in bytes, used for progress; it is 0 for workbooks, whose rows are
+// converted as they are read.
+func openImportSource(filename string) (reader *csv.Reader, size int64, closer io.Closer, err error) {
+	if !importer.IsExcel(filename) {
+		return openImportSheet(filename, "")
+	}
+
+	sheets, err := importer.SheetNames(filename)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	sheet := ""
+	if len(sheets) > 1 {
+		fmt.Println("Sheets in the workbook:")
+		for i, name := range sheets {
+			fmt.Printf("%d. %s\n", i+1, name)
+		}
+		fmt.Print("Sheet to import [1]: ")
+		choice := 1
+		if input := readString(); input != "" {
+			if choice, err = strconv.Atoi(input); err != nil || choice < 1 || choice > len(sheets) {
+				return nil, 0, nil, fmt.Errorf("invalid sheet %q", input)
+			}
+		}
+		sheet = sheets[choice-1]
+	}
+	return openImportSheet(filename, sheet)
+}
+
+// openImportSheet is openImportSource without the prompt: sheet names the
+// workbook sheet to read, the first when empty, and is ignored for CSV files
+func openImportSheet(filename, sheet string) (reader *csv.Reader, size int64, closer io.Closer, err error) {
+	if !importer.IsExcel(filename) {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("error opening file: %w", err)
+		}
+		if info, err := file.Stat(); err == nil {
+			size = info.Size()
+		}
+		// Buffer reads for better performance
+		return csv.NewReader(bufio.NewReader(file)), size, file, nil
+	}
+	reader, closer, err = importer.OpenExcel(filename, sheet)
+	return reader, 0, closer, err
+}
+
+// localSource is the source DSN that reads from this database, e.g. from
+// foreign tables a DBA set up with postgres_fdw or mysql_fdw
+const localSource = "local"
+
+// openQuerySource runs query against a staging database and returns its
+// rows as CSV for import. dsn is a Postgres URL or key=value string,
+// IMPORT_SOURCE_DSN when blank, or "local" for this database. A query
+// starting with @ is read from the named file. label names the source in
+// import logs, e.g. "db:staging".
+func openQuerySource(ctx context.Context, db *sql.DB, dsn, query string) (reader *csv.Reader, closer io.Closer, label string, err error) {
+	if dsn == "" {
+		dsn = os.Getenv("IMPORT_SOURCE_DSN")
+	}
+	if dsn == "" {
+		return nil, nil, "", fmt.Errorf("no source database: give a DSN, set IMPORT_SOURCE_DSN or use %q", localSource)
+	}
+	if strings.HasPrefix(query, "@") {
+		data, err := os.ReadFile(query[1:])
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("error reading query file: %w", err)
+		}
+		query = string(data)
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, nil, "", fmt.Errorf("a source query is required")
+	}
+
+	if dsn == localSource {
+		reader, closer, err = importer.OpenQuery(ctx, db, query)
+		return reader, closer, "db:" + localSource, err
+	}
+	source, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error opening source database: %w", err)
+	}
+	reader, rows, err := importer.OpenQuery(ctx, source, query)
+	if err != nil {
+		source.Close()
+		return nil, nil, "", err
+	}
+	return reader, sourceCloser{rows, source}, "db:" + sourceName(dsn), nil
+}
+
+// sourceCloser stops the source query, then disconnects
+type sourceCloser struct {
+	rows io.Closer
+	db   *sql.DB
+}
+
+func (c sourceCloser) Close() error {
+	c.rows.Close()
+	return c.db.Close()
+}
+
+// sourceName is the database a DSN names, without credentials, for logs
+func sourceName(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if name := strings.TrimPrefix(u.Path, "/"); name != "" {
+			return u.Hostname() + "/" + name
+		}
+		return u.Hostname()
+	}
+	for _, field := range strings.Fields(dsn) {
+		if name, ok := strings.CutPrefix(field, "dbname="); ok {
+			return strings.Trim(name, "'")
+		}
+	}
+	return "source"
+}
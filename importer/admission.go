@@ -0,0 +1,74 @@
+package importer
+
+import (
+	"context"
+
+	"github.com/nonsonwune/spk2_db/db"
+)
+
+type admissionRecord struct {
+	regnumber string
+	admitted  bool
+	inid      interface{}
+	course    interface{}
+}
+
+// noteAdmission remembers an imported admission row. A row without an
+// is_admitted value counts as an admission, since the file lists it.
+func (di *DataImporter) noteAdmission(values []interface{}) {
+	rec := admissionRecord{admitted: true}
+	for i, mapping := range di.config.ColumnMappings {
+		switch mapping.DestinationColumn {
+		case "regnumber":
+			rec.regnumber, _ = values[i].(string)
+		case "is_admitted":
+			if b, ok := values[i].(bool); ok {
+				rec.admitted = b
+			}
+		case "inid":
+			rec.inid = values[i]
+		case "app_course1":
+			rec.course = values[i]
+		}
+	}
+	if rec.regnumber == "" {
+		return
+	}
+	di.mu.Lock()
+	di.admissions = append(di.admissions, rec)
+	di.mu.Unlock()
+}
+
+// flushAdmissions copies the admission rows noted during the import into
+// admission_records
+func (di *DataImporter) flushAdmissions(ctx context.Context) error {
+	di.mu.Lock()
+	records := di.admissions
+	di.admissions = nil
+	di.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+
+	conn, err := di.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows := make([][]interface{}, len(records))
+	for i, r := range records {
+		rows[i] = []interface{}{r.regnumber, di.config.Year, di.config.SourceFile, r.admitted, r.inid, r.course}
+	}
+	err = db.CopyIn(ctx, conn, tx, "admission_records",
+		[]string{"regnumber", "year", "source_file", "is_admitted", "inid", "app_course1"}, rows)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,74 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/db"
+)
+
+// Strategy selects how ImportData writes rows to candidate
+type Strategy string
+
+const (
+	// StrategyInsert upserts rows one at a time with a prepared INSERT
+	StrategyInsert Strategy = "insert"
+	// StrategyCopy streams each batch into a staging table with COPY and
+	// merges it into candidate with a single upsert
+	StrategyCopy Strategy = "copy"
+)
+
+// ParseStrategy parses "insert" or "copy"; blank selects insert
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(strings.ToLower(strings.TrimSpace(s))) {
+	case "", StrategyInsert:
+		return StrategyInsert, nil
+	case StrategyCopy:
+		return StrategyCopy, nil
+	}
+	return "", fmt.Errorf("unknown import strategy %q (use insert or copy)", s)
+}
+
+// stagingTable holds a batch during a COPY import. It is dropped when the
+// batch's transaction ends.
+const stagingTable = "candidate_staging"
+
+// copyRows writes already transformed rows to candidate within tx, open on
+// conn, by
+// copying them into a staging table and upserting from there. Later rows
+// win when a registration number appears twice in the batch, as they would
+// with row-by-row inserts.
+func (di *DataImporter) copyRows(ctx context.Context, conn *sql.Conn, tx *sql.Tx, rows []transformedRow) error {
+	columns := di.destinationColumns()
+
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TEMP TABLE %s (LIKE candidate INCLUDING DEFAULTS, staging_row INTEGER) ON COMMIT DROP",
+		stagingTable))
+	if err != nil {
+		return fmt.Errorf("error creating staging table: %w", err)
+	}
+
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = append(row.values, i)
+	}
+	if err := db.CopyIn(ctx, conn, tx, stagingTable, append(columns, "staging_row"), values); err != nil {
+		return fmt.Errorf("error copying rows: %w", err)
+	}
+
+	list := strings.Join(columns, ", ")
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+        INSERT INTO candidate (%s)
+        SELECT DISTINCT ON (regnumber) %s
+        FROM %s
+        ORDER BY regnumber, staging_row DESC
+        ON CONFLICT (regnumber)
+        DO UPDATE SET %s`,
+		list, list, stagingTable, strings.Join(upsertClauses(columns), ", ")))
+	if err != nil {
+		return fmt.Errorf("error merging staged rows: %w", err)
+	}
+	return nil
+}
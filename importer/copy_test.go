@@ -0,0 +1,177 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var copyHeaders = []string{"REGNUMBER", "SURNAME", "AGGREGATE"}
+
+// newBatchImporter builds an importer over three columns that need no
+// lookups, with its insert statement prepared on a connection of mock
+func newBatchImporter(t *testing.T, strategy Strategy) (sqlmock.Sqlmock, func(importBatch) (ImportResult, error)) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	di := NewDataImporter(db, ImportConfig{
+		Strategy:   strategy,
+		SourceFile: "2023.csv",
+		Year:       2023,
+		ColumnMappings: []ColumnMapping{
+			{SourceColumn: "REGNUMBER", DestinationColumn: "regnumber"},
+			{SourceColumn: "SURNAME", DestinationColumn: "surname"},
+			{SourceColumn: "AGGREGATE", DestinationColumn: "aggregate"},
+		},
+	})
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	mock.ExpectPrepare("INSERT INTO candidate")
+	stmt, err := di.prepareInsertStatement(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stmt.Close() })
+	return mock, func(batch importBatch) (ImportResult, error) {
+		return di.importBatch(ctx, conn, stmt, batch, copyHeaders)
+	}
+}
+
+// expectBatchBegin expects a batch transaction, on which the insert
+// statement is prepared again
+func expectBatchBegin(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO candidate")
+}
+
+// copyBatchRows repeats a registration number
+var copyBatchRows = importBatch{
+	records: [][]string{
+		{"2023A", "ADEBAYO", "250"},
+		{"2023B", "OKAFOR", "231"},
+		{"2023A", "ADEBAYO", "260"},
+	},
+	lines: []int{2, 3, 4},
+}
+
+// rejectedBatchRows has a malformed aggregate on its second row
+var rejectedBatchRows = importBatch{
+	records: [][]string{
+		{"2023A", "ADEBAYO", "250"},
+		{"2023B", "OKAFOR", "2x0"},
+		{"2023C", "BELLO", "260"},
+	},
+	lines: []int{2, 3, 4},
+}
+
+const copyStatement = `COPY "candidate_staging" ("regnumber", "surname", "aggregate", "staging_row") FROM STDIN`
+
+func TestCopyBatch(t *testing.T) {
+	mock, importBatch := newBatchImporter(t, StrategyCopy)
+	expectBatchBegin(mock)
+	mock.ExpectExec("SAVEPOINT copy_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TEMP TABLE candidate_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+	staged := mock.ExpectPrepare(regexp.QuoteMeta(copyStatement))
+	for i, record := range copyBatchRows.records {
+		staged.ExpectExec().WithArgs(record[0], record[1], record[2], i).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	staged.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	// Later rows win when a registration number repeats
+	mock.ExpectExec(regexp.QuoteMeta("SELECT DISTINCT ON (regnumber) regnumber, surname, aggregate\n" +
+		"        FROM candidate_staging\n        ORDER BY regnumber, staging_row DESC")).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	result, err := importBatch(copyBatchRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessCount != 3 || result.FailedCount != 0 {
+		t.Errorf("imported %d, failed %d; want 3 and 0", result.SuccessCount, result.FailedCount)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCopyBatchFallsBackRowByRow(t *testing.T) {
+	mock, importBatch := newBatchImporter(t, StrategyCopy)
+	rejected := errors.New(`invalid input syntax for type numeric: "2x0"`)
+	expectBatchBegin(mock)
+	mock.ExpectExec("SAVEPOINT copy_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TEMP TABLE candidate_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+	staged := mock.ExpectPrepare(regexp.QuoteMeta(copyStatement))
+	staged.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	staged.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	staged.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	staged.ExpectExec().WithArgs().WillReturnError(rejected)
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT copy_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	expectRowByRow(t, mock, importBatch, rejected)
+}
+
+// expectRowByRow inserts rejectedBatchRows one at a time, each in its own
+// savepoint so that the rejected row leaves the transaction usable for
+// the next, and checks that only the rejected row is recorded as failed
+func expectRowByRow(t *testing.T, mock sqlmock.Sqlmock, importBatch func(importBatch) (ImportResult, error), rejected error) {
+	t.Helper()
+	for _, record := range rejectedBatchRows.records {
+		mock.ExpectExec("SAVEPOINT insert_row").WillReturnResult(sqlmock.NewResult(0, 0))
+		insert := mock.ExpectExec("INSERT INTO candidate").WithArgs(record[0], record[1], record[2])
+		if record[2] == "2x0" {
+			insert.WillReturnError(rejected)
+			mock.ExpectExec("ROLLBACK TO SAVEPOINT insert_row").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("INSERT INTO import_errors").
+				WithArgs("2023B", "2023.csv", 2023, 3, rejected.Error(), "REGNUMBER,SURNAME,AGGREGATE", "2023B,OKAFOR,2x0").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			continue
+		}
+		insert.WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT insert_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	result, err := importBatch(rejectedBatchRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessCount != 2 || result.FailedCount != 1 {
+		t.Errorf("imported %d, failed %d; want 2 and 1", result.SuccessCount, result.FailedCount)
+	}
+	if len(result.Errors) != 1 || result.Errors[0] != rejected {
+		t.Errorf("errors = %v, want only %v", result.Errors, rejected)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Strategy
+		ok    bool
+	}{
+		{"", StrategyInsert, true},
+		{"insert", StrategyInsert, true},
+		{" COPY ", StrategyCopy, true},
+		{"bulk", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseStrategy(tt.input)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseStrategy(%q) = %q, %v", tt.input, got, err)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxMappingHops bounds how many mappings are followed from an old code,
+// for codes renamed more than once
+const maxMappingHops = 5
+
+// CourseMapping replaces an old course code with the current one in the
+// files of the years between From and To. A zero From or To leaves that
+// end open; an empty Institution applies the mapping to every institution.
+type CourseMapping struct {
+	ID          int
+	OldCode     string
+	NewCode     string
+	Institution string
+	From, To    time.Time
+	Reason      string
+}
+
+func (m CourseMapping) inEffect(year int) bool {
+	return year == 0 || (m.From.IsZero() || m.From.Year() <= year) && (m.To.IsZero() || year <= m.To.Year())
+}
+
+// loadMappings reads course_code_mappings into the mapper
+func (cm *CourseMapper) loadMappings() error {
+	cm.mappings = make(map[string][]CourseMapping)
+	cm.remaps = make(map[[2]string]int)
+	cm.unmapped = make(map[string]bool)
+
+	list, err := ListCourseMappings(context.Background(), cm.db)
+	if err != nil {
+		return err
+	}
+	for _, m := range list {
+		cm.mappings[m.OldCode] = append(cm.mappings[m.OldCode], m)
+	}
+	return nil
+}
+
+// Resolve returns the current course code for code in a file of year.
+// Codes not in the course table are looked up in course_code_mappings,
+// following a code renamed more than once, and preferring the mapping for
+// institution when mappings of the code disagree. A code no mapping
+// resolves is recorded in historical_course_codes and returned as a
+// *HistoricalCourseError.
+func (cm *CourseMapper) Resolve(code string, year int, institution string) (string, error) {
+	if !cm.prepared {
+		if err := cm.init(); err != nil {
+			return "", fmt.Errorf("failed to initialize course mapper: %v", err)
+		}
+	}
+	if cm.courseCodes[code] {
+		return code, nil
+	}
+	current := code
+	for hop := 0; hop < maxMappingHops; hop++ {
+		next, ok := cm.mapOnce(current, year, institution)
+		if !ok {
+			break
+		}
+		if cm.courseCodes[next] {
+			cm.mu.Lock()
+			cm.remaps[[2]string{code, next}]++
+			cm.mu.Unlock()
+			return next, nil
+		}
+		current = next
+	}
+
+	institutionID, _ := strconv.Atoi(institution)
+	cm.mu.Lock()
+	first := !cm.unmapped[code]
+	cm.unmapped[code] = true
+	cm.mu.Unlock()
+	if first {
+		if err := cm.storeHistoricalCode(code, year, institutionID); err != nil {
+			log.Printf("Warning: Failed to store historical code: %v", err)
+		}
+	}
+	return "", &HistoricalCourseError{CourseCode: code, Year: year, InstitutionID: institutionID}
+}
+
+// mapOnce applies the mappings of code in effect for year, when they all
+// agree or one of them is for institution
+func (cm *CourseMapper) mapOnce(code string, year int, institution string) (string, bool) {
+	var codes, forInstitution []string
+	for _, m := range cm.mappings[code] {
+		if !m.inEffect(year) {
+			continue
+		}
+		if !containsString(codes, m.NewCode) {
+			codes = append(codes, m.NewCode)
+		}
+		if institution != "" && m.Institution == institution && !containsString(forInstitution, m.NewCode) {
+			forInstitution = append(forInstitution, m.NewCode)
+		}
+	}
+	switch {
+	case len(codes) == 1:
+		return codes[0], true
+	case len(forInstitution) == 1:
+		return forInstitution[0], true
+	}
+	return "", false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// logCourseRemaps reports the old course codes this import replaced
+func (di *DataImporter) logCourseRemaps() {
+	cm := di.courseMapper
+	cm.mu.Lock()
+	remaps := cm.remaps
+	cm.remaps = make(map[[2]string]int)
+	cm.mu.Unlock()
+
+	pairs := make([][2]string, 0, len(remaps))
+	total := 0
+	for pair, n := range remaps {
+		pairs = append(pairs, pair)
+		total += n
+	}
+	if total == 0 {
+		return
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	for _, pair := range pairs {
+		log.Printf("Course code %s mapped to %s for %d rows", pair[0], pair[1], remaps[pair])
+	}
+	log.Printf("Mapped %d old course codes in %d rows through course_code_mappings", len(pairs), total)
+}
+
+// ListCourseMappings returns every course code mapping by old code
+func ListCourseMappings(ctx context.Context, db *sql.DB) ([]CourseMapping, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, TRIM(old_course_code), TRIM(new_course_code), COALESCE(institution_id::text, ''),
+               effective_from, effective_to, COALESCE(mapping_reason, '')
+        FROM course_code_mappings
+        WHERE COALESCE(TRIM(old_course_code), '') <> '' AND COALESCE(TRIM(new_course_code), '') <> ''
+        ORDER BY old_course_code, effective_from NULLS FIRST, id`)
+	if err != nil {
+		return nil, fmt.Errorf("error loading course code mappings: %w", err)
+	}
+	defer rows.Close()
+
+	var mappings []CourseMapping
+	for rows.Next() {
+		var m CourseMapping
+		var from, to sql.NullTime
+		if err := rows.Scan(&m.ID, &m.OldCode, &m.NewCode, &m.Institution, &from, &to, &m.Reason); err != nil {
+			return nil, err
+		}
+		m.From, m.To = from.Time, to.Time
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// mappingColumns are the headers ReadCourseMappings accepts for each field
+var mappingColumns = map[string][]string{
+	"old":         {"old_course_code", "old_code", "old"},
+	"new":         {"new_course_code", "new_code", "new"},
+	"institution": {"institution_id", "institution", "inid"},
+	"from":        {"effective_from", "from"},
+	"to":          {"effective_to", "to"},
+	"reason":      {"mapping_reason", "reason"},
+}
+
+// ReadCourseMappings reads mappings from CSV: a header naming at least
+// old_course_code and new_course_code, and optionally institution_id,
+// effective_from, effective_to and mapping_reason, or rows of old and new
+// code pairs without a header. Dates are 2006-01-02 or a year, which runs
+// from January 1 to December 31.
+func ReadCourseMappings(r io.Reader) ([]CourseMapping, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no mappings in file")
+	}
+
+	index := map[string]int{"old": 0, "new": 1, "institution": -1, "from": -1, "to": -1, "reason": -1}
+	line := 1
+	if headerIndex, ok := mappingHeader(records[0]); ok {
+		index = headerIndex
+		records = records[1:]
+		line = 2
+	}
+
+	field := func(record []string, name string) string {
+		if i := index[name]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var mappings []CourseMapping
+	for i, record := range records {
+		m := CourseMapping{
+			OldCode:     field(record, "old"),
+			NewCode:     field(record, "new"),
+			Institution: field(record, "institution"),
+			Reason:      field(record, "reason"),
+		}
+		if m.OldCode == "" && m.NewCode == "" {
+			continue
+		}
+		if m.OldCode == "" || m.NewCode == "" {
+			return nil, fmt.Errorf("line %d: both the old and the new course code are required", line+i)
+		}
+		if m.From, err = ParseMappingDate(field(record, "from"), false); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+i, err)
+		}
+		if m.To, err = ParseMappingDate(field(record, "to"), true); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+i, err)
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// mappingHeader finds the columns of a header row, if record is one
+func mappingHeader(record []string) (map[string]int, bool) {
+	index := map[string]int{}
+	for field, names := range mappingColumns {
+		index[field] = -1
+		for i, cell := range record {
+			if containsString(names, strings.ToLower(strings.TrimSpace(cell))) {
+				index[field] = i
+			}
+		}
+	}
+	return index, index["old"] >= 0 && index["new"] >= 0
+}
+
+// ParseMappingDate parses an effective date as 2006-01-02 or a year, the
+// year's first day, or its last when end is set. Blank is the zero time.
+func ParseMappingDate(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if year, err := strconv.Atoi(s); err == nil && year > 1900 && year < 3000 {
+		if end {
+			return time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC), nil
+		}
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: use YYYY-MM-DD or a year", s)
+	}
+	return t, nil
+}
+
+// MappingProblem is a mapping AddCourseMappings left out, and why
+type MappingProblem struct {
+	Mapping CourseMapping
+	Reason  string
+}
+
+// AddCourseMappings checks mappings and adds the valid ones in one
+// statement, skipping any already recorded. A mapping must lead from a
+// code to a different one, in the course table or itself mapped, with its
+// dates in order. In a dry run nothing is added and the number that would
+// be is returned. It also returns the mappings left out.
+func AddCourseMappings(ctx context.Context, db *sql.DB, mappings []CourseMapping, dryRun bool) (int, []MappingProblem, error) {
+	// A new code must be current, or be mapped in turn
+	known := map[string]bool{}
+	for _, m := range mappings {
+		known[m.OldCode] = true
+	}
+	rows, err := db.QueryContext(ctx, `
+        SELECT course_code FROM course WHERE course_code = ANY($1)
+        UNION
+        SELECT TRIM(old_course_code) FROM course_code_mappings WHERE TRIM(old_course_code) = ANY($1)`,
+		pq.Array(newCodes(mappings)))
+	if err != nil {
+		return 0, nil, fmt.Errorf("error checking course codes: %w", err)
+	}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		known[code] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	var problems []MappingProblem
+	var valid []CourseMapping
+	for _, m := range mappings {
+		switch {
+		case m.OldCode == m.NewCode:
+			problems = append(problems, MappingProblem{m, "maps a code to itself"})
+		case !known[m.NewCode]:
+			problems = append(problems, MappingProblem{m, "new code is neither in the course table nor mapped"})
+		case m.Institution != "" && !isDigits(m.Institution):
+			problems = append(problems, MappingProblem{m, "institution_id must be a number"})
+		case !m.From.IsZero() && !m.To.IsZero() && m.To.Before(m.From):
+			problems = append(problems, MappingProblem{m, "effective_to is before effective_from"})
+		default:
+			valid = append(valid, m)
+		}
+	}
+	if dryRun || len(valid) == 0 {
+		return len(valid), problems, nil
+	}
+
+	oldCodes := make([]string, len(valid))
+	newCodes := make([]string, len(valid))
+	institutions := make([]sql.NullString, len(valid))
+	from := make([]sql.NullString, len(valid))
+	to := make([]sql.NullString, len(valid))
+	reasons := make([]sql.NullString, len(valid))
+	for i, m := range valid {
+		oldCodes[i], newCodes[i] = m.OldCode, m.NewCode
+		institutions[i] = sql.NullString{String: m.Institution, Valid: m.Institution != ""}
+		reasons[i] = sql.NullString{String: m.Reason, Valid: m.Reason != ""}
+		if !m.From.IsZero() {
+			from[i] = sql.NullString{String: m.From.Format("2006-01-02"), Valid: true}
+		}
+		if !m.To.IsZero() {
+			to[i] = sql.NullString{String: m.To.Format("2006-01-02"), Valid: true}
+		}
+	}
+	result, err := db.ExecContext(ctx, `
+        INSERT INTO course_code_mappings
+            (old_course_code, new_course_code, institution_id, effective_from, effective_to, mapping_reason)
+        SELECT DISTINCT o, n, i, f, t, r
+        FROM unnest($1::text[], $2::text[], $3::int[], $4::date[], $5::date[], $6::text[]) AS s(o, n, i, f, t, r)
+        WHERE NOT EXISTS (
+            SELECT 1 FROM course_code_mappings m
+            WHERE m.old_course_code = s.o AND m.new_course_code = s.n
+              AND m.institution_id IS NOT DISTINCT FROM s.i
+              AND m.effective_from IS NOT DISTINCT FROM s.f
+              AND m.effective_to IS NOT DISTINCT FROM s.t
+        )`,
+		pq.Array(oldCodes), pq.Array(newCodes), pq.Array(institutions), pq.Array(from), pq.Array(to), pq.Array(reasons))
+	if err != nil {
+		return 0, problems, fmt.Errorf("error adding course code mappings: %w", err)
+	}
+	added, err := result.RowsAffected()
+	return int(added), problems, err
+}
+
+func newCodes(mappings []CourseMapping) []string {
+	codes := make([]string, len(mappings))
+	for i, m := range mappings {
+		codes[i] = m.NewCode
+	}
+	return codes
+}
+
+func isDigits(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// DeleteCourseMapping removes a mapping by ID
+func DeleteCourseMapping(ctx context.Context, db *sql.DB, id int) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM course_code_mappings WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting course code mapping: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no course code mapping with ID %d", id)
+	}
+	return nil
+}
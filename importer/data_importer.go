@@ -4,12 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"sort"
 	"strings"
 	"sync"
+
+	"github.com/nonsonwune/spk2_db/privacy"
 )
 
 // Constants for configuration
@@ -35,22 +38,48 @@ type ImportConfig struct {
 	BatchSize        int
 	ValidateOnly     bool
 	ColumnMappings   []ColumnMapping
-	WorkerCount      int // Number of parallel workers to use
+	WorkerCount      int // Number of parallel workers, each holding a connection for the import
 	InstitutionID    int
+	MappingGenerator TextGenerator // Optional; proposes a mapping when headers don't match
+	ProfileDir       string        // Where proposed mapping profiles are saved
+	MappingProfile   string        // Optional YAML or JSON profile file; replaces ColumnMappings
+	NonInteractive   bool          // Never prompt; unresolved headers fail with a *HeaderError
+	// Fuzzy header match confidence accepted without asking; 0 selects DefaultAutoAcceptThreshold
+	AutoAcceptThreshold float64
+	OnComplete       []CompletionHook // Run after rows for Year have been committed
+	LookupMode       LookupMode       // Strict rejects rows with unresolved references; lenient nulls them
+	Progress         ProgressReporter // Optional; receives progress after each batch
+	SourceSize       int64            // Size of the source file in bytes, used for the ETA
+	Strategy         Strategy         // How rows are written; blank selects StrategyInsert
+	// Pseudonymizer, when set, masks personal data columns before rows are
+	// written, and failed rows are recorded without their raw line
+	Pseudonymizer *privacy.Pseudonymizer
+	// StateMatchDistance is the largest edit distance a misspelt state is
+	// matched within; 0 selects StateMatchDistanceFromEnv and a negative
+	// value turns fuzzy state matching off
+	StateMatchDistance int
 }
 
+// CompletionHook is notified when an import has committed rows for a year,
+// e.g. to invalidate caches or refresh statistics
+type CompletionHook func(ctx context.Context, year int) error
+
 // StateMapper handles conversion between state names and IDs
 type StateMapper struct {
-	db        *sql.DB
-	nameToID  map[string]int
-	prepared  bool
-	initOnce  sync.Once
+	db          *sql.DB
+	nameToID    map[string]int
+	reviewed    map[string]int // spellings confirmed in the state match review
+	maxDistance int            // largest edit distance a fuzzy match accepts; 0 turns them off
+	prepared    bool
+	initOnce    sync.Once
 }
 
 func NewStateMapper(db *sql.DB) *StateMapper {
 	return &StateMapper{
-		db:       db,
-		nameToID: make(map[string]int),
+		db:          db,
+		nameToID:    make(map[string]int),
+		reviewed:    make(map[string]int),
+		maxDistance: DefaultStateMatchDistance,
 	}
 }
 
@@ -82,15 +111,40 @@ func (sm *StateMapper) init() error {
 			// Add debug logging
 			log.Printf("Loaded state mapping: %s -> %d", name, id)
 		}
+		if err = rows.Err(); err != nil {
+			return
+		}
+		if err = sm.loadReviewed(); err != nil {
+			return
+		}
 		sm.prepared = true
 	})
 	return err
 }
 
+// HasID reports whether id is a known state ID
+func (sm *StateMapper) HasID(id int) bool {
+	for _, known := range sm.nameToID {
+		if known == id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetStateID returns the ID of the state a name refers to, matching
+// misspellings within the mapper's edit distance
 func (sm *StateMapper) GetStateID(stateName string) (int, error) {
+	id, _, err := sm.Match(stateName)
+	return id, err
+}
+
+// Match returns the ID of the state a name refers to and the edit distance
+// of the match, 0 for exact names, special cases and reviewed spellings
+func (sm *StateMapper) Match(stateName string) (int, int, error) {
 	if !sm.prepared {
 		if err := sm.init(); err != nil {
-			return 0, fmt.Errorf("failed to initialize state mapper: %v", err)
+			return 0, 0, fmt.Errorf("failed to initialize state mapper: %v", err)
 		}
 	}
 
@@ -116,60 +170,17 @@ func (sm *StateMapper) GetStateID(stateName string) (int, error) {
 		cleanName = mapped
 	}
 
-	// Try direct lookup first
-	if id, ok := sm.nameToID[cleanName]; ok {
-		return id, nil
-	}
-
-	// If no exact match, try fuzzy matching
-	rows, err := sm.db.Query("SELECT st_id, st_name FROM state")
-	if err != nil {
-		return 0, fmt.Errorf("error querying states: %v", err)
-	}
-	defer rows.Close()
-
-	// Store all state mappings for logging
-	stateMappings := make(map[string]int)
-	var closestMatch string
-	var closestID int
-	var minDistance int = 1000
-
-	for rows.Next() {
-		var id int
-		var name string
-		if err := rows.Scan(&id, &name); err != nil {
-			continue
-		}
-		stateMappings[name] = id
-
-		// Calculate Levenshtein distance
-		distance := levenshteinDistance(cleanName, name)
-		if distance < minDistance {
-			minDistance = distance
-			closestMatch = name
-			closestID = id
-		}
-	}
-
-	// If we found a reasonably close match (distance <= 2)
-	if minDistance <= 2 {
-		log.Printf("State %s matched to %s with ID: %d", stateName, closestMatch, closestID)
-		return closestID, nil
-	}
-
-	// Log available mappings for debugging
-	log.Printf("State not found: %s. Available mappings:", cleanName)
-	for name, id := range stateMappings {
-		log.Printf("  %s -> %d", name, id)
-	}
-
-	return 0, fmt.Errorf("state not found: %s", cleanName)
+	return sm.match(cleanName, stateName)
 }
 
 // CourseMapper handles validation of course codes and manages historical code tracking.
 type CourseMapper struct {
 	db          *sql.DB
 	courseCodes map[string]bool
+	mappings    map[string][]CourseMapping // course_code_mappings by old code
+	remaps      map[[2]string]int          // rows whose old code was mapped, by old and new code
+	unmapped    map[string]bool            // codes already recorded as historical
+	mu          sync.Mutex
 	prepared    bool
 	initOnce    sync.Once
 }
@@ -206,6 +217,12 @@ func (cm *CourseMapper) init() error {
 			// Add debug logging
 			log.Printf("Loaded course code: %s", code)
 		}
+		if err = rows.Err(); err != nil {
+			return
+		}
+		if mapErr := cm.loadMappings(); mapErr != nil {
+			log.Printf("Warning: old course codes will not be mapped: %v", mapErr)
+		}
 		cm.prepared = true
 	})
 	return err
@@ -287,6 +304,10 @@ func (e *HistoricalCourseError) Error() string {
 type InstitutionMapper struct {
 	db           *sql.DB
 	institutions map[string]string  // maps input codes to valid institution IDs
+	aliases      map[string][]institutionAlias // former abbreviations and names, by institutionKey
+	byCourse     map[string]string // institutions of course codes course_code_mappings ties to one
+	remaps       map[InstitutionRemap]int // rows resolved through history, for the audit
+	mu           sync.Mutex
 	prepared     bool
 	initOnce     sync.Once
 }
@@ -328,6 +349,12 @@ func (im *InstitutionMapper) init() error {
 			// Add debug logging
 			log.Printf("Loaded institution mapping: %s -> %s (abbrev: %s)", id, id, abbrev)
 		}
+		if err = rows.Err(); err != nil {
+			return
+		}
+		if histErr := im.loadHistory(); histErr != nil {
+			log.Printf("Warning: historical institution codes will not be resolved: %v", histErr)
+		}
 		im.prepared = true
 	})
 	return err
@@ -360,6 +387,13 @@ type DataImporter struct {
 	stateMapper      *StateMapper
 	courseMapper     *CourseMapper
 	institutionMapper *InstitutionMapper
+	genderMapper     *GenderMapper
+	lgaMapper        *LGAMapper
+	script           *rowScript     // The mapping profile's per-row script, if any
+	nulledLookups    map[string]int // Unresolved references nulled in lenient mode, by column
+	unknownGenders   []unknownGender // Rows whose gender was nulled, for the audit
+	stateMatches     []stateMatch    // Rows whose state was matched fuzzily, for review
+	admissions       []admissionRecord // Rows of an admission file, for admission_records
 	failedIndices    map[int]error  // Track failed record indices
 	mu               sync.Mutex     // Protect concurrent access to failedIndices
 	columnMapping    map[string]string
@@ -375,13 +409,27 @@ func NewDataImporter(db *sql.DB, config ImportConfig) *DataImporter {
 	if config.ColumnMappings == nil {
 		config.ColumnMappings = DefaultColumnMappings()
 	}
+	stateMapper := NewStateMapper(db)
+	switch {
+	case config.StateMatchDistance > 0:
+		stateMapper.maxDistance = config.StateMatchDistance
+	case config.StateMatchDistance < 0:
+		stateMapper.maxDistance = 0
+	default:
+		if distance, err := StateMatchDistanceFromEnv(); err == nil {
+			stateMapper.maxDistance = distance
+		}
+	}
 
 	return &DataImporter{
 		db:               db,
 		config:           config,
-		stateMapper:      NewStateMapper(db),
+		stateMapper:      stateMapper,
 		courseMapper:     NewCourseMapper(db),
 		institutionMapper: NewInstitutionMapper(db),
+		genderMapper:     NewGenderMapper(db),
+		lgaMapper:        NewLGAMapper(db),
+		nulledLookups:    make(map[string]int),
 		failedIndices:    make(map[int]error),
 	}
 }
@@ -426,11 +474,42 @@ func (di *DataImporter) initInstitutionMapper() error {
 
 // ColumnMatch represents a potential column match with confidence score
 type ColumnMatch struct {
-	SourceColumn      string
-	DestinationColumn string
-	Confidence       float64
+	SourceColumn      string  `json:"source_column"`
+	DestinationColumn string  `json:"destination_column"`
+	Confidence        float64 `json:"confidence"`
+}
+
+// UnresolvedColumn is a required column no header could be matched to,
+// with the headers that came closest
+type UnresolvedColumn struct {
+	Column     string        `json:"column"`
+	Candidates []ColumnMatch `json:"candidates,omitempty"`
+}
+
+// HeaderError reports required columns that could not be resolved. It
+// marshals to JSON for scripts running non-interactive imports.
+type HeaderError struct {
+	Unresolved []UnresolvedColumn `json:"unresolved"`
+}
+
+func (e *HeaderError) Error() string {
+	columns := make([]string, len(e.Unresolved))
+	for i, u := range e.Unresolved {
+		columns[i] = u.Column
+	}
+	return fmt.Sprintf("missing required columns: %v", columns)
+}
+
+// JSON renders the error for machine consumption
+func (e *HeaderError) JSON() string {
+	data, _ := json.Marshal(e)
+	return string(data)
 }
 
+// DefaultAutoAcceptThreshold is the confidence above which a single fuzzy
+// header match is accepted without asking
+const DefaultAutoAcceptThreshold = 0.8
+
 // findBestColumnMatch uses fuzzy matching to find the best column match
 func (di *DataImporter) findBestColumnMatch(sourceColumn string, requiredColumns []string) []ColumnMatch {
 	matches := make([]ColumnMatch, 0)
@@ -468,64 +547,47 @@ func (di *DataImporter) findBestColumnMatch(sourceColumn string, requiredColumns
 	return matches
 }
 
-// validateHeaders checks if all required columns are present with user interaction
+// validateHeaders matches the source column of each mapping, and each
+// required column, to a header. Columns without an exact header are fuzzy
+// matched, and a single match at or above AutoAcceptThreshold is proposed.
+// Interactively, all proposals are shown on one review screen where any of
+// them can be changed; in non-interactive mode they are used as they are.
+// Accepted matches replace the source column of the mappings reading it.
+// Unresolved required columns, including the registration number, are
+// returned as a *HeaderError.
 func (di *DataImporter) validateHeaders(headers []string) error {
-	missingColumns := make([]string, 0)
+	var unresolved []UnresolvedColumn
 	di.columnMapping = make(map[string]string)
-	
-	for _, required := range di.config.RequiredColumns {
-		found := false
-		exactMatch := getColumnIndex(headers, required) != -1
-		
-		if exactMatch {
-			di.columnMapping[required] = required
-			found = true
+	threshold := di.config.AutoAcceptThreshold
+	if threshold == 0 {
+		threshold = DefaultAutoAcceptThreshold
+	}
+
+	proposals := di.proposeMappings(headers, threshold)
+	if !di.config.NonInteractive && needsReview(proposals) {
+		reviewMappings(proposals, headers)
+	}
+
+	for _, p := range proposals {
+		if p.Header == "" {
+			if p.Required {
+				unresolved = append(unresolved, UnresolvedColumn{Column: p.Column, Candidates: p.Matches})
+			}
 			continue
 		}
-		
-		// Try fuzzy matching
-		matches := di.findBestColumnMatch(required, headers)
-		if len(matches) > 0 {
-			// Ask user for confirmation if multiple matches found
-			if len(matches) > 1 {
-				fmt.Printf("\nMultiple potential matches found for column '%s':\n", required)
-				for i, match := range matches {
-					fmt.Printf("%d. %s (confidence: %.2f%%)\n", i+1, match.SourceColumn, match.Confidence*100)
-				}
-				fmt.Print("Enter number to select match (0 to skip): ")
-				var choice int
-				fmt.Scanln(&choice)
-				
-				if choice > 0 && choice <= len(matches) {
-					di.columnMapping[required] = matches[choice-1].SourceColumn
-					found = true
-				}
-			} else if matches[0].Confidence > 0.8 { // Auto-accept high confidence matches
-				di.columnMapping[required] = matches[0].SourceColumn
-				found = true
-				fmt.Printf("Automatically mapped '%s' to '%s' (%.2f%% confidence)\n", 
-					required, matches[0].SourceColumn, matches[0].Confidence*100)
-			} else {
-				// Ask for confirmation for lower confidence matches
-				fmt.Printf("\nPotential match found for column '%s':\n", required)
-				fmt.Printf("'%s' (confidence: %.2f%%)\n", matches[0].SourceColumn, matches[0].Confidence*100)
-				fmt.Print("Accept this match? (y/n): ")
-				var response string
-				fmt.Scanln(&response)
-				if strings.ToLower(response) == "y" {
-					di.columnMapping[required] = matches[0].SourceColumn
-					found = true
-				}
-			}
+		if p.Header != p.Column {
+			log.Printf("Mapped '%s' to '%s'", p.Column, p.Header)
 		}
-		
-		if !found {
-			missingColumns = append(missingColumns, required)
+		di.columnMapping[p.Column] = p.Header
+		for i := range di.config.ColumnMappings {
+			if strings.EqualFold(di.config.ColumnMappings[i].SourceColumn, p.Column) {
+				di.config.ColumnMappings[i].SourceColumn = p.Header
+			}
 		}
 	}
-	
-	if len(missingColumns) > 0 {
-		return fmt.Errorf("missing required columns: %v", missingColumns)
+
+	if len(unresolved) > 0 {
+		return &HeaderError{Unresolved: unresolved}
 	}
 	
 	return nil
@@ -559,6 +621,9 @@ func (di *DataImporter) ImportData(ctx context.Context, reader *csv.Reader) erro
     if err != nil {
         return fmt.Errorf("error reading headers: %v", err)
     }
+    if err := di.applyMappingProfile(); err != nil {
+        return err
+    }
 
     // Initialize mappers
     if err := di.initStateMapper(); err != nil {
@@ -570,111 +635,120 @@ func (di *DataImporter) ImportData(ctx context.Context, reader *csv.Reader) erro
     if err := di.initInstitutionMapper(); err != nil {
         return fmt.Errorf("error initializing institution mapper: %v", err)
     }
-
-    // Prepare column mappings
-    if err := di.validateHeaders(headers); err != nil {
-        return fmt.Errorf("invalid headers: %v", err)
+    if err := di.genderMapper.init(); err != nil {
+        return fmt.Errorf("error initializing gender mapper: %v", err)
     }
-
-    // Start a transaction
-    tx, err := di.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
-    if err != nil {
-        return fmt.Errorf("error starting transaction: %v", err)
+    if err := di.lgaMapper.init(); err != nil {
+        return fmt.Errorf("error initializing LGA mapper: %v", err)
     }
-    defer tx.Rollback() // Will be ignored if transaction is committed
 
-    // Prepare the insert statement
-    stmt, err := di.prepareInsertStatement(tx)
-    if err != nil {
-        return fmt.Errorf("error preparing statement: %v", err)
+    // Prepare column mappings, falling back to a proposed mapping if configured.
+    // Rows read as a sample for the proposal are imported first.
+    var pending [][]string
+    if err := di.validateHeaders(headers); err != nil {
+        if di.config.MappingGenerator == nil || di.config.NonInteractive {
+            return fmt.Errorf("invalid headers: %w", err)
+        }
+        fmt.Printf("\nHeader validation failed: %v\n", err)
+        pending, err = di.proposeMapping(ctx, reader, headers)
+        if err != nil {
+            return fmt.Errorf("invalid headers: %v", err)
+        }
+        if err := di.validateHeaders(headers); err != nil {
+            return fmt.Errorf("invalid headers with the proposed mapping: %w", err)
+        }
     }
-    defer stmt.Close()
 
-    // Process records in batches
-    batchSize := 1000 // Adjust based on your needs
-    batch := make([][]string, 0, batchSize)
+    // Read batches on a separate goroutine and hand them to a pool of
+    // workers, each inserting on its own connection and transaction
+    importCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    batches := make(chan importBatch, di.config.WorkerCount)
+    results := di.runWorkers(importCtx, di.config.WorkerCount, headers, batches)
+
+    var readFailures int
+    var readErr error
+    go func() {
+        defer close(batches)
+        readFailures, readErr = di.readBatches(importCtx, reader, headers, pending, batches)
+    }()
+
+    // Aggregate results as batches complete, stopping all workers on the
+    // first batch that could not be committed
+    progress := di.newProgressTracker()
     totalProcessed := 0
     successCount := 0
     failedCount := 0
-    var lastError error
-
-    for {
-        // Check context cancellation
-        select {
-        case <-ctx.Done():
-            return fmt.Errorf("import cancelled: %v", ctx.Err())
-        default:
-        }
-
-        // Read record
-        record, err := reader.Read()
-        if err == io.EOF {
-            break
-        }
-        if err != nil {
-            log.Printf("Error reading record: %v", err)
-            failedCount++
-            continue
-        }
-
-        batch = append(batch, record)
-        
-        // Process batch when it's full or on last record
-        if len(batch) >= batchSize {
-            result := di.processBatch(ctx, batch, headers, totalProcessed, stmt)
-            successCount += result.SuccessCount
-            failedCount += result.FailedCount
-            if len(result.Errors) > 0 {
-                lastError = result.Errors[len(result.Errors)-1]
+    var bytesRead int64
+    var lastError, fatalErr error
+
+    for result := range results {
+        if result.Err != nil {
+            if fatalErr == nil {
+                fatalErr = result.Err
+                cancel()
             }
-            
-            // Log progress
-            totalProcessed += len(batch)
-            if totalProcessed%10000 == 0 {
-                log.Printf("Processed %d records. Success: %d, Failed: %d", 
-                    totalProcessed, successCount, failedCount)
-            }
-            
-            // Commit batch transaction
-            if err := tx.Commit(); err != nil {
-                return fmt.Errorf("error committing batch: %v", err)
-            }
-            
-            // Start new transaction for next batch
-            tx, err = di.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
-            if err != nil {
-                return fmt.Errorf("error starting new batch transaction: %v", err)
-            }
-            
-            // Prepare new statement for next batch
-            stmt, err = di.prepareInsertStatement(tx)
-            if err != nil {
-                return fmt.Errorf("error preparing statement for new batch: %v", err)
-            }
-            
-            batch = batch[:0] // Clear batch
+            continue
         }
-    }
-
-    // Process remaining records
-    if len(batch) > 0 {
-        result := di.processBatch(ctx, batch, headers, totalProcessed, stmt)
         successCount += result.SuccessCount
         failedCount += result.FailedCount
         if len(result.Errors) > 0 {
             lastError = result.Errors[len(result.Errors)-1]
         }
-        totalProcessed += len(batch)
-        
-        // Commit final batch
-        if err := tx.Commit(); err != nil {
-            return fmt.Errorf("error committing final batch: %v", err)
+
+        // Report progress, logging it when nobody is listening
+        previous := totalProcessed
+        totalProcessed += result.rows
+        if result.offset > bytesRead {
+            bytesRead = result.offset
+        }
+        if di.config.Progress != nil {
+            progress.report(totalProcessed, successCount, failedCount, bytesRead, false)
+        } else if totalProcessed/10000 > previous/10000 {
+            log.Printf("Processed %d records. Success: %d, Failed: %d",
+                totalProcessed, successCount, failedCount)
         }
     }
 
+    // Cancellation of the caller's context takes precedence over the
+    // errors it caused in the workers
+    if err := ctx.Err(); err != nil {
+        return fmt.Errorf("import cancelled: %v", err)
+    }
+    if fatalErr != nil {
+        return fatalErr
+    }
+    if readErr != nil {
+        return readErr
+    }
+    failedCount += readFailures
+    progress.report(totalProcessed, successCount, failedCount, bytesRead, true)
+
     // Print summary
     di.printImportSummary(successCount, failedCount, []error{lastError})
 
+    di.logNulledLookups()
+    di.logCourseRemaps()
+    if n := len(di.unknownGenders); n > 0 {
+        log.Printf("Gender was unrecognised and left empty for %d rows; see the gender audit", n)
+    }
+    if err := di.flushGenderAudit(ctx); err != nil {
+        log.Printf("Warning: failed to record gender audit: %v", err)
+    }
+    if err := di.flushInstitutionRemaps(ctx); err != nil {
+        log.Printf("Warning: failed to record institution remaps: %v", err)
+    }
+    if err := di.flushStateMatches(ctx); err != nil {
+        log.Printf("Warning: failed to record state matches: %v", err)
+    }
+    if err := di.flushAdmissions(ctx); err != nil {
+        log.Printf("Warning: failed to record admission rows: %v", err)
+    }
+
+    if successCount > 0 {
+        di.runCompletionHooks(ctx)
+    }
+
     if failedCount > 0 {
         return fmt.Errorf("import completed with %d failures, last error: %v", 
             failedCount, lastError)
@@ -683,52 +757,171 @@ func (di *DataImporter) ImportData(ctx context.Context, reader *csv.Reader) erro
     return nil
 }
 
-func (di *DataImporter) processBatch(ctx context.Context, records [][]string, headers []string, startIndex int, stmt *sql.Stmt) ImportResult {
+func (di *DataImporter) runCompletionHooks(ctx context.Context) {
+    for _, hook := range di.config.OnComplete {
+        if err := hook(ctx, di.config.Year); err != nil {
+            log.Printf("Warning: import completion hook failed: %v", err)
+        }
+    }
+}
+
+func (di *DataImporter) processBatch(ctx context.Context, tx *sql.Tx, batch importBatch, headers []string, stmt *sql.Stmt) ImportResult {
+    rows, result := di.transformBatch(ctx, batch, headers)
+    di.insertRows(ctx, tx, rows, headers, stmt, &result)
+    return result
+}
+
+// transformedRow is a source row ready to be written to candidate
+type transformedRow struct {
+    values []interface{}
+    record []string
+    line   int
+}
+
+// transformBatch transforms records and resolves their references. Rows
+// that fail are counted in the result and recorded in import_errors.
+func (di *DataImporter) transformBatch(ctx context.Context, batch importBatch, headers []string) ([]transformedRow, ImportResult) {
     result := ImportResult{
-        ChunkIndex: startIndex,
+        ChunkIndex: batch.start,
     }
+    rows := make([]transformedRow, 0, len(batch.records))
 
-    for _, record := range records {
+    for i, record := range batch.records {
         // Check context cancellation
         select {
         case <-ctx.Done():
             result.Errors = append(result.Errors, ctx.Err())
-            return result
+            return rows, result
         default:
         }
 
-        // Transform and insert record
+        line := batch.lines[i]
         values, err := di.transformRecord(headers, record)
+        if err == nil {
+            err = di.applyLookupMode(ctx, values, record)
+        }
         if err != nil {
             result.FailedCount++
             result.Errors = append(result.Errors, err)
-            log.Printf("Error transforming record at index %d: %v", startIndex+result.FailedCount+result.SuccessCount, err)
+            log.Printf("Error transforming record on line %d: %v", line, err)
+            di.recordFailedRow(ctx, headers, record, line, err.Error())
             continue
         }
+        rows = append(rows, transformedRow{values: values, record: record, line: line})
+    }
+
+    return rows, result
+}
 
-        // Execute insert
-        if _, err := stmt.Exec(values...); err != nil {
+// insertRows upserts transformed rows one at a time with stmt, prepared on
+// tx. Each row is written inside a savepoint, so a rejected row doesn't
+// abort the transaction and only its own error is recorded.
+func (di *DataImporter) insertRows(ctx context.Context, tx *sql.Tx, rows []transformedRow, headers []string, stmt *sql.Stmt, result *ImportResult) {
+    for _, row := range rows {
+        if err := insertRow(ctx, tx, stmt, row.values); err != nil {
             result.FailedCount++
             result.Errors = append(result.Errors, err)
-            log.Printf("Error inserting record at index %d: %v", startIndex+result.FailedCount+result.SuccessCount, err)
+            log.Printf("Error inserting record on line %d: %v", row.line, err)
+            di.recordFailedRow(ctx, headers, row.record, row.line, err.Error())
         } else {
             result.SuccessCount++
+            if di.config.IsAdmission {
+                di.noteAdmission(row.values)
+            }
         }
     }
+}
 
-    return result
+// insertRow runs stmt for one row inside a savepoint
+func insertRow(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, values []interface{}) error {
+    if _, err := tx.ExecContext(ctx, "SAVEPOINT insert_row"); err != nil {
+        return err
+    }
+    if _, err := stmt.ExecContext(ctx, values...); err != nil {
+        tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT insert_row")
+        return err
+    }
+    _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT insert_row")
+    return err
+}
+
+// readBatches reads rows, draining any sample rows first, and sends them to
+// batches in groups of BatchSize. Rows that cannot be parsed are recorded in
+// import_errors and counted in the returned total.
+func (di *DataImporter) readBatches(ctx context.Context, reader *csv.Reader, headers []string, pending [][]string, batches chan<- importBatch) (int, error) {
+    batch := importBatch{records: make([][]string, 0, di.config.BatchSize)}
+    read, failed := 0, 0
+    send := func() error {
+        batch.start = read - len(batch.records)
+        batch.offset = reader.InputOffset()
+        select {
+        case batches <- batch:
+        case <-ctx.Done():
+            return fmt.Errorf("import cancelled: %v", ctx.Err())
+        }
+        batch = importBatch{records: make([][]string, 0, di.config.BatchSize)}
+        return nil
+    }
+
+    for {
+        if err := ctx.Err(); err != nil {
+            return failed, fmt.Errorf("import cancelled: %v", err)
+        }
+
+        // Sample rows were the first read, directly after the header
+        var record []string
+        var line int
+        if len(pending) > 0 {
+            record, pending = pending[0], pending[1:]
+            line = read + 2
+        } else {
+            var err error
+            record, err = reader.Read()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                log.Printf("Error reading record: %v", err)
+                failed++
+                if parseErr, ok := err.(*csv.ParseError); ok {
+                    line = parseErr.StartLine
+                }
+                di.recordFailedRow(ctx, headers, record, line, err.Error())
+                continue
+            }
+            line, _ = reader.FieldPos(0)
+        }
+
+        batch.records = append(batch.records, record)
+        batch.lines = append(batch.lines, line)
+        read++
+        if len(batch.records) >= di.config.BatchSize {
+            if err := send(); err != nil {
+                return failed, err
+            }
+        }
+    }
+
+    if len(batch.records) > 0 {
+        if err := send(); err != nil {
+            return failed, err
+        }
+    }
+    return failed, nil
 }
 
-func (di *DataImporter) prepareInsertStatement(tx *sql.Tx) (*sql.Stmt, error) {
-    // Build column list
+// destinationColumns lists the candidate columns rows are written to
+func (di *DataImporter) destinationColumns() []string {
     columns := make([]string, 0, len(di.config.ColumnMappings))
-    placeholders := make([]string, 0, len(di.config.ColumnMappings))
-    for i, mapping := range di.config.ColumnMappings {
+    for _, mapping := range di.config.ColumnMappings {
         columns = append(columns, mapping.DestinationColumn)
-        placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
     }
+    return columns
+}
 
-    // Build COALESCE-based update clause for each column
+// upsertClauses builds the ON CONFLICT updates for columns, keeping
+// existing non-null values where the new value is empty
+func upsertClauses(columns []string) []string {
     updateClauses := make([]string, 0, len(columns))
     for _, col := range columns {
         if col != "regnumber" { // Skip primary key in updates
@@ -738,6 +931,15 @@ func (di *DataImporter) prepareInsertStatement(tx *sql.Tx) (*sql.Stmt, error) {
                     col, col, "candidate", col))
         }
     }
+    return updateClauses
+}
+
+func (di *DataImporter) prepareInsertStatement(ctx context.Context, conn *sql.Conn) (*sql.Stmt, error) {
+    columns := di.destinationColumns()
+    placeholders := make([]string, 0, len(columns))
+    for i := range columns {
+        placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+    }
 
     // Prepare the statement with COALESCE-based updates
     query := fmt.Sprintf(
@@ -747,10 +949,10 @@ func (di *DataImporter) prepareInsertStatement(tx *sql.Tx) (*sql.Stmt, error) {
          DO UPDATE SET %s`,
         strings.Join(columns, ", "),
         strings.Join(placeholders, ", "),
-        strings.Join(updateClauses, ", "),
+        strings.Join(upsertClauses(columns), ", "),
     )
 
-    stmt, err := tx.Prepare(query)
+    stmt, err := conn.PrepareContext(ctx, query)
     if err != nil {
         return nil, fmt.Errorf("error preparing statement: %v", err)
     }
@@ -828,30 +1030,76 @@ func getColumnIndex(headers []string, columnName string) int {
 
 func (di *DataImporter) transformRecord(headers []string, record []string) ([]interface{}, error) {
     values := make([]interface{}, len(di.config.ColumnMappings))
+    var rawGender, regnumber string
+
+    var scripted map[string]interface{}
+    if di.script != nil {
+        var err error
+        if scripted, err = di.script.apply(headers, record); err != nil {
+            return nil, err
+        }
+    }
     
     for i, mapping := range di.config.ColumnMappings {
-        idx := getColumnIndex(headers, mapping.SourceColumn)
-        if idx == -1 || idx >= len(record) {
-            values[i] = nil
-            continue
+        var value string
+        v, fromScript := scripted[mapping.DestinationColumn]
+        if fromScript {
+            s, isString := v.(string)
+            if !isString {
+                values[i] = v
+                continue
+            }
+            value = strings.TrimSpace(s)
+        } else {
+            idx := -1
+            if mapping.SourceColumn != "" {
+                idx = getColumnIndex(headers, mapping.SourceColumn)
+            }
+            if idx == -1 || idx >= len(record) {
+                values[i] = nil
+                continue
+            }
+            value = strings.TrimSpace(record[idx])
+        }
+        if !fromScript && mapping.TransformFunc != nil {
+            transformed, err := mapping.TransformFunc(value)
+            if err != nil {
+                return nil, fmt.Errorf("column %s: %v", mapping.SourceColumn, err)
+            }
+            s, ok := transformed.(string)
+            if !ok {
+                // Typed values, including nil, are used as they are
+                values[i] = transformed
+                continue
+            }
+            value = strings.TrimSpace(s)
         }
-        
-        value := strings.TrimSpace(record[idx])
         if value == "" {
             values[i] = nil
             continue
         }
         
+        if di.config.Pseudonymizer != nil {
+            masked, ok := di.config.Pseudonymizer.Apply(mapping.DestinationColumn, value)
+            if !ok {
+                values[i] = nil
+                continue
+            }
+            value = masked
+        }
+
         switch mapping.DestinationColumn {
         case "regnumber", "surname", "firstname", "middlename", "email", "gsmno":
             values[i] = value
+            if mapping.DestinationColumn == "regnumber" {
+                regnumber = value
+            }
         case "gender":
-            if strings.EqualFold(value, "M") || strings.EqualFold(value, "MALE") {
-                values[i] = "M"
-            } else if strings.EqualFold(value, "F") || strings.EqualFold(value, "FEMALE") {
-                values[i] = "F"
+            if g, ok := di.genderMapper.Resolve(value); ok {
+                values[i] = g
             } else {
                 values[i] = nil
+                rawGender = value
             }
         case "is_admitted", "is_direct_entry", "is_blind", "is_deaf", "is_mock_candidate":
             if strings.EqualFold(value, "yes") || strings.EqualFold(value, "true") || value == "1" {
@@ -863,6 +1111,10 @@ func (di *DataImporter) transformRecord(headers []string, record []string) ([]in
             values[i] = value
         }
     }
+
+    if rawGender != "" && regnumber != "" {
+        di.noteUnknownGender(regnumber, rawGender)
+    }
     
     return values, nil
 }
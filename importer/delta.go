@@ -0,0 +1,339 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// DeltaSummary reports what a delta import changed
+type DeltaSummary struct {
+	Rows          int            // data rows read
+	Changed       int            // existing candidates with at least one change
+	Unchanged     int            // existing candidates whose values already matched
+	Inserted      int            // regnumbers not yet in the database
+	Failed        int            // rows that could not be processed
+	ColumnChanges map[string]int // changed values per column
+	Applied       bool           // false for a dry run
+}
+
+// Columns returns the changed columns ordered by number of changes
+func (s *DeltaSummary) Columns() []string {
+	columns := make([]string, 0, len(s.ColumnChanges))
+	for col := range s.ColumnChanges {
+		columns = append(columns, col)
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		if s.ColumnChanges[columns[i]] != s.ColumnChanges[columns[j]] {
+			return s.ColumnChanges[columns[i]] > s.ColumnChanges[columns[j]]
+		}
+		return columns[i] < columns[j]
+	})
+	return columns
+}
+
+// columnChange is one differing value for a candidate
+type columnChange struct {
+	column   string
+	oldValue sql.NullString
+	newValue interface{}
+}
+
+// ImportDelta applies a file containing only changed candidates. Each row is
+// compared with the current database values for the columns present in the
+// file, and only values that actually differ are updated; empty cells leave
+// the current value alone, as in a full import. Unknown regnumbers are
+// inserted. With ValidateOnly set the changes are reported but not applied.
+func ImportDelta(ctx context.Context, db *sql.DB, config ImportConfig, reader *csv.Reader) (*DeltaSummary, error) {
+	di := NewDataImporter(db, config)
+	return di.ImportDelta(ctx, reader)
+}
+
+func (di *DataImporter) ImportDelta(ctx context.Context, reader *csv.Reader) (*DeltaSummary, error) {
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading headers: %v", err)
+	}
+	if err := di.applyMappingProfile(); err != nil {
+		return nil, err
+	}
+	if err := di.validateHeaders(headers); err != nil {
+		return nil, fmt.Errorf("invalid headers: %w", err)
+	}
+	for _, init := range []func() error{
+		di.stateMapper.init, di.courseMapper.init, di.institutionMapper.init,
+		di.genderMapper.init, di.lgaMapper.init,
+	} {
+		if err := init(); err != nil {
+			return nil, fmt.Errorf("error initializing lookups: %v", err)
+		}
+	}
+
+	// Only columns present in the file take part in the comparison
+	var present []int
+	keyIndex := -1
+	for i, mapping := range di.config.ColumnMappings {
+		if mapping.SourceColumn != "" && getColumnIndex(headers, mapping.SourceColumn) == -1 {
+			continue
+		}
+		if mapping.DestinationColumn == "regnumber" {
+			keyIndex = i
+			continue
+		}
+		present = append(present, i)
+	}
+	if keyIndex == -1 {
+		return nil, fmt.Errorf("delta file has no regnumber column")
+	}
+
+	summary := &DeltaSummary{ColumnChanges: make(map[string]int), Applied: !di.config.ValidateOnly}
+	batchSize := di.config.BatchSize
+	batch := make([][]string, 0, batchSize)
+	progress := di.newProgressTracker()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return summary, fmt.Errorf("delta import cancelled: %v", err)
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Error reading record: %v", err)
+			summary.Failed++
+			continue
+		}
+		summary.Rows++
+		batch = append(batch, record)
+		if len(batch) >= batchSize {
+			if err := di.processDeltaBatch(ctx, headers, batch, keyIndex, present, summary); err != nil {
+				return summary, err
+			}
+			batch = batch[:0]
+			progress.report(summary.Rows, summary.Changed+summary.Unchanged+summary.Inserted, summary.Failed, reader.InputOffset(), false)
+		}
+	}
+	if len(batch) > 0 {
+		if err := di.processDeltaBatch(ctx, headers, batch, keyIndex, present, summary); err != nil {
+			return summary, err
+		}
+	}
+	progress.report(summary.Rows, summary.Changed+summary.Unchanged+summary.Inserted, summary.Failed, reader.InputOffset(), true)
+
+	di.logNulledLookups()
+	di.logCourseRemaps()
+	if summary.Applied {
+		if err := di.flushGenderAudit(ctx); err != nil {
+			log.Printf("Warning: failed to record gender audit: %v", err)
+		}
+		if err := di.flushInstitutionRemaps(ctx); err != nil {
+			log.Printf("Warning: failed to record institution remaps: %v", err)
+		}
+		if err := di.flushStateMatches(ctx); err != nil {
+			log.Printf("Warning: failed to record state matches: %v", err)
+		}
+		if summary.Changed+summary.Inserted > 0 {
+			di.runCompletionHooks(ctx)
+		}
+	}
+	return summary, nil
+}
+
+func (di *DataImporter) processDeltaBatch(ctx context.Context, headers []string, records [][]string, keyIndex int, present []int, summary *DeltaSummary) error {
+	type row struct {
+		reg    string
+		values []interface{}
+	}
+	rows := make([]row, 0, len(records))
+	regs := make([]string, 0, len(records))
+	for _, record := range records {
+		values, err := di.transformRecord(headers, record)
+		if err == nil {
+			err = di.applyLookupMode(ctx, values, record)
+		}
+		if err != nil {
+			summary.Failed++
+			di.recordFailedRow(ctx, headers, record, 0, err.Error())
+			continue
+		}
+		reg, _ := values[keyIndex].(string)
+		if reg == "" {
+			summary.Failed++
+			continue
+		}
+		rows = append(rows, row{reg: reg, values: values})
+		regs = append(regs, reg)
+	}
+
+	current, err := di.currentValues(ctx, regs, present)
+	if err != nil {
+		return err
+	}
+
+	tx, err := di.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range rows {
+		existing, ok := current[r.reg]
+		if !ok {
+			summary.Inserted++
+			if summary.Applied {
+				if err := di.insertDeltaCandidate(ctx, tx, r.values); err != nil {
+					log.Printf("Error inserting %s: %v", r.reg, err)
+					summary.Inserted--
+					summary.Failed++
+				}
+			}
+			continue
+		}
+
+		var changes []columnChange
+		for j, idx := range present {
+			newValue := r.values[idx]
+			if newValue == nil {
+				continue
+			}
+			if existing[j].Valid && existing[j].String == deltaText(newValue) {
+				continue
+			}
+			changes = append(changes, columnChange{
+				column:   di.config.ColumnMappings[idx].DestinationColumn,
+				oldValue: existing[j],
+				newValue: newValue,
+			})
+		}
+		if len(changes) == 0 {
+			summary.Unchanged++
+			continue
+		}
+
+		summary.Changed++
+		for _, c := range changes {
+			summary.ColumnChanges[c.column]++
+		}
+		if summary.Applied {
+			if err := di.applyDeltaChanges(ctx, tx, r.reg, changes); err != nil {
+				return fmt.Errorf("error updating %s: %w", r.reg, err)
+			}
+		}
+	}
+
+	if !summary.Applied {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// currentValues loads the text form of the given columns for each regnumber
+func (di *DataImporter) currentValues(ctx context.Context, regs []string, present []int) (map[string][]sql.NullString, error) {
+	selects := make([]string, len(present))
+	for j, idx := range present {
+		selects[j] = di.config.ColumnMappings[idx].DestinationColumn + "::text"
+	}
+	query := "SELECT regnumber"
+	if len(selects) > 0 {
+		query += ", " + strings.Join(selects, ", ")
+	}
+	query += " FROM candidate WHERE regnumber = ANY($1)"
+
+	rows, err := di.db.QueryContext(ctx, query, pq.Array(regs))
+	if err != nil {
+		return nil, fmt.Errorf("error loading current values: %w", err)
+	}
+	defer rows.Close()
+
+	current := make(map[string][]sql.NullString, len(regs))
+	for rows.Next() {
+		var reg string
+		values := make([]sql.NullString, len(present))
+		dest := make([]interface{}, 0, len(present)+1)
+		dest = append(dest, &reg)
+		for j := range values {
+			dest = append(dest, &values[j])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		current[reg] = values
+	}
+	return current, rows.Err()
+}
+
+func (di *DataImporter) applyDeltaChanges(ctx context.Context, tx *sql.Tx, reg string, changes []columnChange) error {
+	sets := make([]string, len(changes))
+	args := make([]interface{}, 0, len(changes)+1)
+	for i, c := range changes {
+		sets[i] = fmt.Sprintf("%s = $%d", c.column, i+1)
+		args = append(args, c.newValue)
+	}
+	args = append(args, reg)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE candidate SET %s, updated_at = NOW() WHERE regnumber = $%d",
+		strings.Join(sets, ", "), len(args)), args...)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		var oldValue interface{}
+		if c.oldValue.Valid {
+			oldValue = c.oldValue.String
+		}
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO candidate_changes (regnumber, column_name, old_value, new_value, source_file)
+            VALUES ($1, $2, $3, $4, $5)`,
+			reg, c.column, oldValue, deltaText(c.newValue), di.config.SourceFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertDeltaCandidate inserts a new candidate inside a savepoint so a
+// rejected row doesn't abort the rest of the batch
+func (di *DataImporter) insertDeltaCandidate(ctx context.Context, tx *sql.Tx, values []interface{}) error {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT delta_insert"); err != nil {
+		return err
+	}
+	columns := make([]string, len(di.config.ColumnMappings))
+	placeholders := make([]string, len(di.config.ColumnMappings))
+	for i, mapping := range di.config.ColumnMappings {
+		columns[i] = mapping.DestinationColumn
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO candidate (%s) VALUES (%s)",
+		strings.Join(columns, ", "), strings.Join(placeholders, ", ")), values...)
+	if err != nil {
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT delta_insert")
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT delta_insert")
+	return err
+}
+
+// deltaText renders a transformed value the way PostgreSQL casts the stored
+// value to text, so the two can be compared
+func deltaText(v interface{}) string {
+	switch val := v.(type) {
+	case bool:
+		if val {
+			return "true"
+		}
+		return "false"
+	case string:
+		return val
+	}
+	return fmt.Sprint(v)
+}
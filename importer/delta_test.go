@@ -0,0 +1,168 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var deltaHeaders = []string{"REGNUMBER", "SURNAME", "AGGREGATE", "IS_ADMITTED"}
+
+// newDeltaImporter builds a delta importer over columns that need no
+// lookups; every mapped column is present in deltaHeaders
+func newDeltaImporter(t *testing.T, validateOnly bool) (*DataImporter, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewDataImporter(db, ImportConfig{
+		SourceFile:   "delta.csv",
+		ValidateOnly: validateOnly,
+		ColumnMappings: []ColumnMapping{
+			{SourceColumn: "REGNUMBER", DestinationColumn: "regnumber"},
+			{SourceColumn: "SURNAME", DestinationColumn: "surname"},
+			{SourceColumn: "AGGREGATE", DestinationColumn: "aggregate"},
+			{SourceColumn: "IS_ADMITTED", DestinationColumn: "is_admitted"},
+		},
+	}), mock
+}
+
+var deltaRecords = [][]string{
+	{"2023A", "ADEBAYO", "250", "1"},   // as stored
+	{"2023B", "OKAFOR", "231", "no"},   // surname corrected
+	{"2023C", "BELLO", "", "false"},    // empty cells leave values alone
+	{"2023D", "NWOSU", "200", "false"}, // not yet imported
+	{"2023E", "EZE", "199", "true"},    // aggregate was missing
+	{"", "UNKNOWN", "180", "false"},    // no regnumber
+}
+
+// expectCurrentValues returns the stored values of the candidates in
+// deltaRecords other than 2023D
+func expectCurrentValues(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT regnumber, surname::text, aggregate::text, is_admitted::text FROM candidate WHERE regnumber = ANY($1)")).
+		WithArgs(`{"2023A","2023B","2023C","2023D","2023E"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"regnumber", "surname", "aggregate", "is_admitted"}).
+			AddRow("2023A", "ADEBAYO", "250", "true").
+			AddRow("2023B", "OKAFFOR", "231", "false").
+			AddRow("2023C", "BELLO", nil, "false").
+			AddRow("2023E", "EZE", nil, "true"))
+}
+
+// deltaPresent lists the compared columns: every mapping but regnumber
+var deltaPresent = []int{1, 2, 3}
+
+func TestProcessDeltaBatch(t *testing.T) {
+	di, mock := newDeltaImporter(t, false)
+	expectCurrentValues(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE candidate SET surname = $1, updated_at = NOW() WHERE regnumber = $2")).
+		WithArgs("OKAFOR", "2023B").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO candidate_changes").
+		WithArgs("2023B", "surname", "OKAFFOR", "OKAFOR", "delta.csv").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("SAVEPOINT delta_insert").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO candidate (regnumber, surname, aggregate, is_admitted) VALUES ($1, $2, $3, $4)")).
+		WithArgs("2023D", "NWOSU", "200", false).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT delta_insert").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE candidate SET aggregate = $1, updated_at = NOW() WHERE regnumber = $2")).
+		WithArgs("199", "2023E").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO candidate_changes").
+		WithArgs("2023E", "aggregate", nil, "199", "delta.csv").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	summary := &DeltaSummary{ColumnChanges: make(map[string]int), Applied: true}
+	if err := di.processDeltaBatch(context.Background(), deltaHeaders, deltaRecords, 0, deltaPresent, summary); err != nil {
+		t.Fatal(err)
+	}
+	want := &DeltaSummary{
+		Changed: 2, Unchanged: 2, Inserted: 1, Failed: 1,
+		ColumnChanges: map[string]int{"surname": 1, "aggregate": 1},
+		Applied:       true,
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessDeltaBatchDryRun(t *testing.T) {
+	di, mock := newDeltaImporter(t, true)
+	expectCurrentValues(mock)
+	// Nothing is written, and the transaction is rolled back
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	summary := &DeltaSummary{ColumnChanges: make(map[string]int)}
+	if err := di.processDeltaBatch(context.Background(), deltaHeaders, deltaRecords, 0, deltaPresent, summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Changed != 2 || summary.Unchanged != 2 || summary.Inserted != 1 || summary.Failed != 1 {
+		t.Errorf("summary = %+v, want 2 changed, 2 unchanged, 1 inserted and 1 failed", summary)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessDeltaBatchRejectedInsert(t *testing.T) {
+	di, mock := newDeltaImporter(t, false)
+	mock.ExpectQuery("SELECT regnumber").
+		WillReturnRows(sqlmock.NewRows([]string{"regnumber", "surname", "aggregate", "is_admitted"}))
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT delta_insert").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO candidate").WithArgs("2023A", "ADEBAYO", "2x0", true).
+		WillReturnError(errors.New(`invalid input syntax for type numeric: "2x0"`))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT delta_insert").WillReturnResult(sqlmock.NewResult(0, 0))
+	// The rejected row doesn't stop the next one
+	mock.ExpectExec("SAVEPOINT delta_insert").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO candidate").WithArgs("2023B", "OKAFOR", "231", false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT delta_insert").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	records := [][]string{{"2023A", "ADEBAYO", "2x0", "yes"}, {"2023B", "OKAFOR", "231", "no"}}
+	summary := &DeltaSummary{ColumnChanges: make(map[string]int), Applied: true}
+	if err := di.processDeltaBatch(context.Background(), deltaHeaders, records, 0, deltaPresent, summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Inserted != 1 || summary.Failed != 1 {
+		t.Errorf("inserted %d, failed %d; want 1 and 1", summary.Inserted, summary.Failed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeltaText(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{true, "true"},
+		{false, "false"},
+		{" ADEBAYO", " ADEBAYO"},
+		{250, "250"},
+		{int64(7), "7"},
+	}
+	for _, tt := range tests {
+		if got := deltaText(tt.value); got != tt.want {
+			t.Errorf("deltaText(%#v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDeltaSummaryColumns(t *testing.T) {
+	s := &DeltaSummary{ColumnChanges: map[string]int{"surname": 2, "aggregate": 5, "email": 2}}
+	want := []string{"aggregate", "email", "surname"}
+	if got := s.Columns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns() = %v, want %v", got, want)
+	}
+}
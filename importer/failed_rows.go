@@ -0,0 +1,240 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// csvLine encodes fields as a single CSV line
+func csvLine(fields []string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(fields)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// parseCSVLine decodes a line written by csvLine
+func parseCSVLine(line string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.FieldsPerRecord = -1
+	return r.Read()
+}
+
+// sourceRegnumber returns the registration number in a raw record, or nil
+func (di *DataImporter) sourceRegnumber(headers, record []string) interface{} {
+	for _, mapping := range di.config.ColumnMappings {
+		if mapping.DestinationColumn != "regnumber" {
+			continue
+		}
+		idx := getColumnIndex(headers, mapping.SourceColumn)
+		if idx == -1 || idx >= len(record) {
+			return nil
+		}
+		reg := strings.TrimSpace(record[idx])
+		if reg == "" {
+			return nil
+		}
+		if len(reg) > 20 {
+			reg = reg[:20]
+		}
+		return reg
+	}
+	return nil
+}
+
+// recordFailedRow writes a row that could not be imported to import_errors.
+// line is the row's line in the source file, 0 if unknown, and record is
+// nil when the line could not be parsed. Nothing is written when only
+// validating.
+func (di *DataImporter) recordFailedRow(ctx context.Context, headers, record []string, line int, message string) {
+	if di.config.ValidateOnly {
+		return
+	}
+	var raw, lineNumber interface{}
+	if record != nil && di.config.Pseudonymizer == nil {
+		raw = csvLine(record)
+	}
+	if line > 0 {
+		lineNumber = line
+	}
+	_, err := di.db.ExecContext(ctx, `
+        INSERT INTO import_errors (regnumber, source_file, year, line_number, error_message, header, raw_record)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		di.sourceRegnumber(headers, record), di.config.SourceFile, di.config.Year, lineNumber,
+		message, csvLine(headers), raw)
+	if err != nil {
+		log.Printf("Warning: failed to record import error: %v", err)
+	}
+}
+
+// RetrySummary counts the outcome of retrying failed rows
+type RetrySummary struct {
+	Attempted int
+	Resolved  int
+	Failed    int
+	// Skipped rows could not be retried, e.g. because the line itself could
+	// not be parsed or was recorded before raw rows were kept
+	Skipped int
+}
+
+type failedRow struct {
+	id     int
+	line   int
+	record string
+}
+
+type failedGroup struct {
+	sourceFile string
+	year       int
+	header     string
+	rows       []failedRow
+}
+
+// RetryFailedRows re-processes the unresolved rows in import_errors,
+// limited to sourceFile and year when they are set. Each row is transformed
+// and upserted again with config's mappings and lookup mode, using the year
+// and source file it was first imported with. Rows that now succeed are
+// marked resolved; rows that still fail keep their latest error.
+func RetryFailedRows(ctx context.Context, db *sql.DB, config ImportConfig, sourceFile string, year int) (*RetrySummary, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, COALESCE(source_file, ''), COALESCE(year, 0), COALESCE(line_number, 0),
+               COALESCE(header, ''), COALESCE(raw_record, '')
+        FROM import_errors
+        WHERE resolved_at IS NULL
+            AND ($1 = '' OR source_file = $1)
+            AND ($2 = 0 OR year = $2)
+        ORDER BY source_file, year, header, line_number, id`, sourceFile, year)
+	if err != nil {
+		return nil, fmt.Errorf("error loading failed rows: %w", err)
+	}
+	var groups []*failedGroup
+	summary := &RetrySummary{}
+	for rows.Next() {
+		var row failedRow
+		var group failedGroup
+		if err := rows.Scan(&row.id, &group.sourceFile, &group.year, &row.line, &group.header, &row.record); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		summary.Attempted++
+		if group.header == "" || row.record == "" {
+			summary.Skipped++
+			continue
+		}
+		if n := len(groups); n == 0 || groups[n-1].sourceFile != group.sourceFile ||
+			groups[n-1].year != group.year || groups[n-1].header != group.header {
+			groups = append(groups, &group)
+		}
+		last := groups[len(groups)-1]
+		last.rows = append(last.rows, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		groupConfig := config
+		groupConfig.SourceFile = group.sourceFile
+		groupConfig.Year = group.year
+		if err := NewDataImporter(db, groupConfig).retryGroup(ctx, group, summary); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// retryGroup retries rows that share a source file, year and header
+func (di *DataImporter) retryGroup(ctx context.Context, group *failedGroup, summary *RetrySummary) error {
+	for _, init := range []func() error{
+		di.stateMapper.init, di.courseMapper.init, di.institutionMapper.init,
+		di.genderMapper.init, di.lgaMapper.init,
+	} {
+		if err := init(); err != nil {
+			return fmt.Errorf("error initializing lookups: %v", err)
+		}
+	}
+
+	headers, err := parseCSVLine(group.header)
+	if err == nil {
+		err = di.validateHeaders(headers)
+	}
+	if err != nil {
+		log.Printf("Skipping %d failed rows from %s: %v", len(group.rows), group.sourceFile, err)
+		summary.Skipped += len(group.rows)
+		return nil
+	}
+
+	conn, err := di.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stmt, err := di.prepareInsertStatement(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	resolved := 0
+	for _, row := range group.rows {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("retry cancelled: %v", err)
+		}
+		record, err := parseCSVLine(row.record)
+		var values []interface{}
+		if err == nil {
+			values, err = di.transformRecord(headers, record)
+		}
+		if err == nil {
+			err = di.applyLookupMode(ctx, values, record)
+		}
+		if err == nil {
+			_, err = stmt.ExecContext(ctx, values...)
+		}
+
+		if err != nil {
+			summary.Failed++
+			_, updateErr := di.db.ExecContext(ctx,
+				"UPDATE import_errors SET error_message = $2, retried_at = NOW() WHERE id = $1", row.id, err.Error())
+			if updateErr != nil {
+				return fmt.Errorf("error updating failed row %d: %w", row.id, updateErr)
+			}
+			continue
+		}
+		summary.Resolved++
+		resolved++
+		if di.config.IsAdmission {
+			di.noteAdmission(values)
+		}
+		_, err = di.db.ExecContext(ctx,
+			"UPDATE import_errors SET retried_at = NOW(), resolved_at = NOW() WHERE id = $1", row.id)
+		if err != nil {
+			return fmt.Errorf("error marking row %d resolved: %w", row.id, err)
+		}
+	}
+
+	di.logNulledLookups()
+	di.logCourseRemaps()
+	if err := di.flushGenderAudit(ctx); err != nil {
+		log.Printf("Warning: failed to record gender audit: %v", err)
+	}
+	if err := di.flushInstitutionRemaps(ctx); err != nil {
+		log.Printf("Warning: failed to record institution remaps: %v", err)
+	}
+	if err := di.flushStateMatches(ctx); err != nil {
+		log.Printf("Warning: failed to record state matches: %v", err)
+	}
+	if err := di.flushAdmissions(ctx); err != nil {
+		log.Printf("Warning: failed to record admission rows: %v", err)
+	}
+	if resolved > 0 {
+		di.runCompletionHooks(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package importer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInsertBatchRecordsOnlyRejectedRow(t *testing.T) {
+	mock, importBatch := newBatchImporter(t, StrategyInsert)
+	expectBatchBegin(mock)
+	expectRowByRow(t, mock, importBatch, errors.New(`invalid input syntax for type numeric: "2x0"`))
+}
@@ -0,0 +1,194 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	"github.com/nonsonwune/spk2_db/db"
+)
+
+// builtinGenders are the encodings recognised without a stored mapping
+var builtinGenders = map[string]string{
+	"M": "M", "MALE": "M",
+	"F": "F", "FEMALE": "F",
+}
+
+// GenderMapper resolves raw gender values using the built-in encodings and
+// reviewed mappings stored in gender_value_mappings
+type GenderMapper struct {
+	db       *sql.DB
+	mappings map[string]string
+	initOnce sync.Once
+}
+
+func NewGenderMapper(db *sql.DB) *GenderMapper {
+	return &GenderMapper{
+		db:       db,
+		mappings: make(map[string]string),
+	}
+}
+
+func (gm *GenderMapper) init() error {
+	var err error
+	gm.initOnce.Do(func() {
+		rows, queryErr := gm.db.Query(`SELECT raw_value, gender FROM gender_value_mappings`)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var raw, gender string
+			if scanErr := rows.Scan(&raw, &gender); scanErr != nil {
+				err = scanErr
+				return
+			}
+			gm.mappings[raw] = gender
+		}
+		err = rows.Err()
+	})
+	return err
+}
+
+// Resolve returns M or F for a raw value, or false if it is unrecognised
+func (gm *GenderMapper) Resolve(raw string) (string, bool) {
+	key := normalizeGenderValue(raw)
+	if g, ok := builtinGenders[key]; ok {
+		return g, true
+	}
+	g, ok := gm.mappings[key]
+	return g, ok
+}
+
+func normalizeGenderValue(raw string) string {
+	return strings.ToUpper(strings.TrimSpace(raw))
+}
+
+// unknownGender is a row whose gender was nulled during import
+type unknownGender struct {
+	regnumber string
+	raw       string
+}
+
+func (di *DataImporter) noteUnknownGender(regnumber, raw string) {
+	di.mu.Lock()
+	di.unknownGenders = append(di.unknownGenders, unknownGender{regnumber: regnumber, raw: raw})
+	di.mu.Unlock()
+}
+
+// flushGenderAudit records the rows whose gender was nulled during this
+// import in gender_audit
+func (di *DataImporter) flushGenderAudit(ctx context.Context) error {
+	di.mu.Lock()
+	entries := di.unknownGenders
+	di.unknownGenders = nil
+	di.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	conn, err := di.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows := make([][]interface{}, len(entries))
+	for i, e := range entries {
+		raw := e.raw
+		if len(raw) > 50 {
+			raw = raw[:50]
+		}
+		rows[i] = []interface{}{e.regnumber, raw, di.config.SourceFile, di.config.Year}
+	}
+	err = db.CopyIn(ctx, conn, tx, "gender_audit", []string{"regnumber", "raw_value", "source_file", "year"}, rows)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GenderValueCount summarises an unresolved raw gender value
+type GenderValueCount struct {
+	RawValue string
+	Rows     int
+	Sample   []string // a few affected regnumbers
+}
+
+// UnresolvedGenderValues lists the raw values that were nulled and not yet
+// reclassified, most frequent first
+func UnresolvedGenderValues(ctx context.Context, db *sql.DB) ([]GenderValueCount, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT raw_value, COUNT(*),
+               (array_agg(regnumber ORDER BY regnumber))[1:5]
+        FROM gender_audit
+        WHERE resolved_at IS NULL
+        GROUP BY raw_value
+        ORDER BY COUNT(*) DESC, raw_value`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []GenderValueCount
+	for rows.Next() {
+		var c GenderValueCount
+		if err := rows.Scan(&c.RawValue, &c.Rows, pq.Array(&c.Sample)); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// MapGenderValue saves a mapping for raw and reclassifies the audited
+// candidates whose gender is still missing. It returns the number of
+// candidates updated.
+func MapGenderValue(ctx context.Context, db *sql.DB, raw, gender string) (int64, error) {
+	gender = strings.ToUpper(gender)
+	if gender != "M" && gender != "F" {
+		return 0, fmt.Errorf("gender must be M or F")
+	}
+	raw = normalizeGenderValue(raw)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO gender_value_mappings (raw_value, gender) VALUES ($1, $2)
+        ON CONFLICT (raw_value) DO UPDATE SET gender = EXCLUDED.gender`, raw, gender); err != nil {
+		return 0, fmt.Errorf("error saving mapping: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+        UPDATE candidate c SET gender = $2, updated_at = NOW()
+        FROM gender_audit a
+        WHERE a.regnumber = c.regnumber
+        AND UPPER(TRIM(a.raw_value)) = $1
+        AND a.resolved_at IS NULL
+        AND c.gender IS NULL`, raw, gender)
+	if err != nil {
+		return 0, fmt.Errorf("error reclassifying candidates: %w", err)
+	}
+	n, _ := res.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE gender_audit SET resolved_at = NOW()
+        WHERE UPPER(TRIM(raw_value)) = $1 AND resolved_at IS NULL`, raw); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
@@ -0,0 +1,217 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// How a remapped institution code was resolved
+const (
+	RemapNameHistory   = "institution_names"
+	RemapCourseMapping = "course_code_mappings"
+)
+
+// institutionAlias is a former abbreviation or name of an institution, in
+// use from one year to another; to is 0 while it is still in use
+type institutionAlias struct {
+	inid     string
+	from, to int
+}
+
+func (a institutionAlias) inUse(year int) bool {
+	return year == 0 || (a.from == 0 || a.from <= year) && (a.to == 0 || year <= a.to)
+}
+
+// InstitutionRemap counts the rows of an import whose institution code was
+// resolved through history rather than the institution table
+type InstitutionRemap struct {
+	RawValue  string
+	InID      string
+	MatchedBy string
+	Rows      int
+}
+
+// loadHistory reads former abbreviations and names from institution_names,
+// and the institution of each course code course_code_mappings assigns to
+// exactly one. Either table may be missing from older databases.
+func (im *InstitutionMapper) loadHistory() error {
+	im.aliases = make(map[string][]institutionAlias)
+	im.byCourse = make(map[string]string)
+	im.remaps = make(map[InstitutionRemap]int)
+
+	var names, mappings bool
+	err := im.db.QueryRow(`SELECT to_regclass('institution_names') IS NOT NULL, to_regclass('course_code_mappings') IS NOT NULL`).
+		Scan(&names, &mappings)
+	if err != nil {
+		return err
+	}
+
+	if names {
+		rows, err := im.db.Query(`
+            SELECT inid, COALESCE(inabv, ''), COALESCE(inname, ''),
+                   COALESCE(EXTRACT(YEAR FROM effective_from)::int, 0),
+                   COALESCE(EXTRACT(YEAR FROM effective_to)::int, 0)
+            FROM institution_names`)
+		if err != nil {
+			return fmt.Errorf("error reading institution_names: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var abbrev, name string
+			var alias institutionAlias
+			if err := rows.Scan(&alias.inid, &abbrev, &name, &alias.from, &alias.to); err != nil {
+				return err
+			}
+			if _, ok := im.institutions[alias.inid]; !ok {
+				continue
+			}
+			for _, key := range []string{abbrev, name} {
+				if key = institutionKey(key); key != "" {
+					im.aliases[key] = append(im.aliases[key], alias)
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	if mappings {
+		rows, err := im.db.Query(`
+            SELECT code, MIN(m.institution_id::text)
+            FROM course_code_mappings m,
+                 unnest(ARRAY[m.old_course_code::text, m.new_course_code::text]) AS code
+            WHERE m.institution_id IS NOT NULL AND COALESCE(code, '') <> ''
+            GROUP BY code
+            HAVING COUNT(DISTINCT m.institution_id) = 1`)
+		if err != nil {
+			return fmt.Errorf("error reading course_code_mappings: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var code, inid string
+			if err := rows.Scan(&code, &inid); err != nil {
+				return err
+			}
+			if _, ok := im.institutions[inid]; ok {
+				im.byCourse[strings.TrimSpace(code)] = inid
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func institutionKey(s string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(s), " "))
+}
+
+// Resolve returns the current institution ID for code in a file of year.
+// A code not in the institution table is looked up among the former
+// abbreviations and names in use that year, then through course, the
+// row's course code, when course_code_mappings ties it to one institution.
+// Each remap is counted for the audit.
+func (im *InstitutionMapper) Resolve(code string, year int, course string) (string, error) {
+	if !im.prepared {
+		if err := im.init(); err != nil {
+			return "", fmt.Errorf("failed to initialize institution mapper: %v", err)
+		}
+	}
+
+	code = strings.TrimSpace(code)
+	if id, exists := im.institutions[code]; exists {
+		return id, nil
+	}
+
+	if id, ok := im.fromHistory(institutionKey(code), year); ok {
+		im.noteRemap(InstitutionRemap{RawValue: code, InID: id, MatchedBy: RemapNameHistory})
+		return id, nil
+	}
+	if id, ok := im.byCourse[strings.TrimSpace(course)]; ok && course != "" {
+		im.noteRemap(InstitutionRemap{RawValue: code, InID: id, MatchedBy: RemapCourseMapping})
+		return id, nil
+	}
+
+	log.Printf("Warning: No matching institution found for code: %s", code)
+	return "", fmt.Errorf("invalid institution code: %s", code)
+}
+
+// fromHistory finds the institution whose former abbreviation or name key
+// was in use in year. Keys that named different institutions that year are
+// ambiguous and not resolved.
+func (im *InstitutionMapper) fromHistory(key string, year int) (string, bool) {
+	id := ""
+	for _, alias := range im.aliases[key] {
+		if !alias.inUse(year) {
+			continue
+		}
+		if id != "" && id != alias.inid {
+			return "", false
+		}
+		id = alias.inid
+	}
+	return id, id != ""
+}
+
+func (im *InstitutionMapper) noteRemap(r InstitutionRemap) {
+	im.mu.Lock()
+	im.remaps[r]++
+	im.mu.Unlock()
+}
+
+// takeRemaps returns the remaps counted since the last call, most rows
+// first
+func (im *InstitutionMapper) takeRemaps() []InstitutionRemap {
+	im.mu.Lock()
+	counts := im.remaps
+	im.remaps = make(map[InstitutionRemap]int)
+	im.mu.Unlock()
+
+	remaps := make([]InstitutionRemap, 0, len(counts))
+	for r, n := range counts {
+		r.Rows = n
+		remaps = append(remaps, r)
+	}
+	sort.Slice(remaps, func(i, j int) bool {
+		if remaps[i].Rows != remaps[j].Rows {
+			return remaps[i].Rows > remaps[j].Rows
+		}
+		return remaps[i].RawValue < remaps[j].RawValue
+	})
+	return remaps
+}
+
+// flushInstitutionRemaps records the institution codes this import
+// resolved through history in institution_remaps
+func (di *DataImporter) flushInstitutionRemaps(ctx context.Context) error {
+	remaps := di.institutionMapper.takeRemaps()
+	if len(remaps) == 0 {
+		return nil
+	}
+
+	raw := make([]string, len(remaps))
+	ids := make([]string, len(remaps))
+	matchedBy := make([]string, len(remaps))
+	counts := make([]int64, len(remaps))
+	total := 0
+	for i, r := range remaps {
+		raw[i], ids[i], matchedBy[i], counts[i] = r.RawValue, r.InID, r.MatchedBy, int64(r.Rows)
+		total += r.Rows
+		log.Printf("Institution %q resolved to %s through %s for %d rows", r.RawValue, r.InID, r.MatchedBy, r.Rows)
+	}
+	log.Printf("Remapped %d historical institution codes in %d rows; see institution_remaps", len(remaps), total)
+
+	_, err := di.db.ExecContext(ctx, `
+        INSERT INTO institution_remaps (source_file, year, raw_value, inid, matched_by, row_count)
+        SELECT $1, $2, r, i, m, n
+        FROM unnest($3::text[], $4::text[], $5::text[], $6::int[]) AS s(r, i, m, n)`,
+		di.config.SourceFile, di.config.Year, pq.Array(raw), pq.Array(ids), pq.Array(matchedBy), pq.Array(counts))
+	return err
+}
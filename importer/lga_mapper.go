@@ -0,0 +1,172 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxLGADistance is the largest edit distance accepted for a fuzzy LGA match
+const maxLGADistance = 2
+
+// LGAMapper resolves LGA names or IDs to lga.lg_id. Many LGA names repeat
+// across states (e.g. Surulere, Obi, Nasarawa), so names are resolved within
+// the candidate's state; without a state a name only resolves if it is
+// unique nationally. Spelling variants can be registered in lga_aliases.
+type LGAMapper struct {
+	db       *sql.DB
+	stateOf  map[int]int            // lg_id -> lg_st_id
+	byState  map[int]map[string]int // state -> normalized name -> lg_id
+	byName   map[string][]int       // normalized name -> lg_ids in any state
+	aliases  map[int]map[string]int // state (0 for any) -> alias -> lg_id
+	initOnce sync.Once
+}
+
+func NewLGAMapper(db *sql.DB) *LGAMapper {
+	return &LGAMapper{db: db}
+}
+
+// AddLGAAlias registers alias for an LGA, optionally scoped to a state
+func AddLGAAlias(ctx context.Context, db *sql.DB, alias string, stateID, lgID int) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO lga_aliases (alias, state_id, lg_id) VALUES ($1, $2, $3)
+        ON CONFLICT (alias, state_id) DO UPDATE SET lg_id = EXCLUDED.lg_id`,
+		normalizeLGAName(alias), stateID, lgID)
+	return err
+}
+
+func (lm *LGAMapper) init() error {
+	var err error
+	lm.initOnce.Do(func() {
+		lm.stateOf = make(map[int]int)
+		lm.byState = make(map[int]map[string]int)
+		lm.byName = make(map[string][]int)
+		lm.aliases = make(map[int]map[string]int)
+
+		rows, queryErr := lm.db.Query(`SELECT lg_id, COALESCE(lg_st_id, 0), COALESCE(lg_name, '') FROM lga`)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, stateID int
+			var name string
+			if scanErr := rows.Scan(&id, &stateID, &name); scanErr != nil {
+				err = scanErr
+				return
+			}
+			lm.stateOf[id] = stateID
+			key := normalizeLGAName(name)
+			if key == "" {
+				continue
+			}
+			if lm.byState[stateID] == nil {
+				lm.byState[stateID] = make(map[string]int)
+			}
+			lm.byState[stateID][key] = id
+			lm.byName[key] = append(lm.byName[key], id)
+		}
+		if err = rows.Err(); err != nil {
+			return
+		}
+
+		aliasRows, queryErr := lm.db.Query(`SELECT alias, state_id, lg_id FROM lga_aliases`)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		defer aliasRows.Close()
+		for aliasRows.Next() {
+			var alias string
+			var stateID, id int
+			if scanErr := aliasRows.Scan(&alias, &stateID, &id); scanErr != nil {
+				err = scanErr
+				return
+			}
+			if lm.aliases[stateID] == nil {
+				lm.aliases[stateID] = make(map[string]int)
+			}
+			lm.aliases[stateID][normalizeLGAName(alias)] = id
+		}
+		err = aliasRows.Err()
+	})
+	return err
+}
+
+// Resolve returns the lg_id for value, which may be an ID or a name. stateID
+// is the candidate's resolved state, or 0 if unknown. A numeric ID must
+// exist and, when the state is known, belong to it.
+func (lm *LGAMapper) Resolve(stateID int, value string) (int, error) {
+	if id, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		lgaState, ok := lm.stateOf[id]
+		if !ok {
+			return 0, fmt.Errorf("no LGA with ID %d", id)
+		}
+		if stateID != 0 && lgaState != stateID {
+			return 0, fmt.Errorf("LGA %d belongs to state %d, not %d", id, lgaState, stateID)
+		}
+		return id, nil
+	}
+
+	key := normalizeLGAName(value)
+	if key == "" {
+		return 0, fmt.Errorf("empty LGA name")
+	}
+
+	if stateID != 0 {
+		if id, ok := lm.byState[stateID][key]; ok {
+			return id, nil
+		}
+		if id, ok := lm.aliases[stateID][key]; ok {
+			return id, nil
+		}
+	}
+	if id, ok := lm.aliases[0][key]; ok {
+		if stateID == 0 || lm.stateOf[id] == stateID {
+			return id, nil
+		}
+	}
+
+	if stateID == 0 {
+		switch ids := lm.byName[key]; len(ids) {
+		case 1:
+			return ids[0], nil
+		case 0:
+		default:
+			return 0, fmt.Errorf("LGA %q exists in %d states; a state is needed to choose", value, len(ids))
+		}
+		return 0, fmt.Errorf("unknown LGA %q", value)
+	}
+
+	// Fuzzy match within the state, accepting only an unambiguous best match
+	best, bestID, ties := maxLGADistance+1, 0, 0
+	for name, id := range lm.byState[stateID] {
+		d := levenshteinDistance(key, name)
+		switch {
+		case d < best:
+			best, bestID, ties = d, id, 1
+		case d == best:
+			ties++
+		}
+	}
+	if best <= maxLGADistance && ties == 1 {
+		return bestID, nil
+	}
+	return 0, fmt.Errorf("unknown LGA %q in state %d", value, stateID)
+}
+
+var lgaNoise = regexp.MustCompile(`\b(L\.?G\.?A\.?|LOCAL GOVERNMENT( AREA)?)\b|[^A-Z0-9 ]`)
+
+// normalizeLGAName upper-cases a name and strips "LGA" suffixes and
+// punctuation, so "Ife-North L.G.A." and "IFE NORTH" compare equal
+func normalizeLGAName(name string) string {
+	name = strings.ToUpper(name)
+	name = strings.NewReplacer("-", " ", "/", " ").Replace(name)
+	name = lgaNoise.ReplaceAllString(name, "")
+	return strings.Join(strings.Fields(name), " ")
+}
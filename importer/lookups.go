@@ -0,0 +1,160 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// LookupMode controls what happens to a row whose state, LGA, course or
+// institution cannot be resolved against the reference tables
+type LookupMode string
+
+const (
+	// LookupLenient imports the row with the unresolved reference set to NULL
+	LookupLenient LookupMode = "lenient"
+	// LookupStrict rejects the row into import_errors
+	LookupStrict LookupMode = "strict"
+)
+
+// ParseLookupMode parses "strict" or "lenient"; blank selects lenient
+func ParseLookupMode(s string) (LookupMode, error) {
+	switch LookupMode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", LookupLenient:
+		return LookupLenient, nil
+	case LookupStrict:
+		return LookupStrict, nil
+	}
+	return "", fmt.Errorf("unknown lookup mode %q (use strict or lenient)", s)
+}
+
+// LookupError is an unresolved reference in an import row
+type LookupError struct {
+	Column string
+	Value  string
+	Err    error
+}
+
+func (e *LookupError) Error() string {
+	return fmt.Sprintf("unresolved %s %q: %v", e.Column, e.Value, e.Err)
+}
+
+// resolveReferences replaces state, LGA, course and institution values with
+// the keys stored on candidate, returning an error for each value that
+// cannot be resolved. Unresolved values are left in place for the caller to
+// reject or null according to the lookup mode. The LGA is resolved last,
+// within the row's resolved state.
+func (di *DataImporter) resolveReferences(values []interface{}) []*LookupError {
+	var errs []*LookupError
+	stateID, lgaIndex := 0, -1
+	course, institution, regnumber := "", "", ""
+	for i, mapping := range di.config.ColumnMappings {
+		switch mapping.DestinationColumn {
+		case "regnumber":
+			regnumber, _ = values[i].(string)
+		case "app_course1":
+			course, _ = values[i].(string)
+		case "inid":
+			institution, _ = values[i].(string)
+			institution = strings.TrimSpace(institution)
+			if id, ok := di.institutionMapper.institutions[institution]; ok {
+				institution = id
+			}
+		}
+	}
+	for i, mapping := range di.config.ColumnMappings {
+		raw, ok := values[i].(string)
+		if !ok || raw == "" {
+			continue
+		}
+
+		var resolved interface{}
+		var err error
+		switch mapping.DestinationColumn {
+		case "statecode":
+			if id, convErr := strconv.Atoi(raw); convErr == nil {
+				if !di.stateMapper.HasID(id) {
+					err = fmt.Errorf("no state with ID %d", id)
+				}
+				resolved = id
+			} else {
+				var id, distance int
+				if id, distance, err = di.stateMapper.Match(raw); err == nil && distance > 0 && regnumber != "" {
+					di.noteStateMatch(stateMatch{regnumber: regnumber, raw: raw, stateID: id, distance: distance})
+				}
+				resolved = id
+			}
+		case "lg_id":
+			lgaIndex = i
+			continue
+		case "app_course1":
+			if resolved, err = di.courseMapper.Resolve(raw, di.config.Year, institution); err != nil {
+				err = fmt.Errorf("unknown course code")
+			}
+		case "inid":
+			resolved, err = di.institutionMapper.Resolve(raw, di.config.Year, course)
+		default:
+			continue
+		}
+
+		if err != nil {
+			errs = append(errs, &LookupError{Column: mapping.DestinationColumn, Value: raw, Err: err})
+			continue
+		}
+		values[i] = resolved
+		if id, ok := resolved.(int); ok && mapping.DestinationColumn == "statecode" {
+			stateID = id
+		}
+	}
+
+	if lgaIndex >= 0 {
+		if raw, ok := values[lgaIndex].(string); ok && raw != "" {
+			id, err := di.lgaMapper.Resolve(stateID, raw)
+			if err != nil {
+				errs = append(errs, &LookupError{Column: "lg_id", Value: raw, Err: err})
+			} else {
+				values[lgaIndex] = id
+			}
+		}
+	}
+	return errs
+}
+
+// applyLookupMode resolves references in values. In lenient mode
+// unresolved references are set to NULL and the row proceeds; in strict mode
+// an error is returned and the caller records the row as failed.
+func (di *DataImporter) applyLookupMode(ctx context.Context, values []interface{}, record []string) error {
+	errs := di.resolveReferences(values)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	if di.config.LookupMode == LookupStrict {
+		messages := make([]string, len(errs))
+		for i, e := range errs {
+			messages[i] = e.Error()
+		}
+		return fmt.Errorf("row rejected: %s", strings.Join(messages, "; "))
+	}
+
+	for _, e := range errs {
+		for i, mapping := range di.config.ColumnMappings {
+			if mapping.DestinationColumn == e.Column {
+				values[i] = nil
+			}
+		}
+		di.mu.Lock()
+		di.nulledLookups[e.Column]++
+		di.mu.Unlock()
+	}
+	return nil
+}
+
+// logNulledLookups reports the references lenient mode set to NULL
+func (di *DataImporter) logNulledLookups() {
+	for column, n := range di.nulledLookups {
+		log.Printf("Lenient lookups: %d unresolved %s values imported as NULL", n, column)
+	}
+}
@@ -0,0 +1,360 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProfileDir is where mapping profiles are saved when no directory is configured
+const DefaultProfileDir = "mapping_profiles"
+
+// TextGenerator produces free text from a prompt, e.g. an LLM client
+type TextGenerator interface {
+	GenerateText(ctx context.Context, prompt string) (string, error)
+}
+
+// ProfileMapping maps one CSV header to a candidate column. Transform
+// optionally names how the value is converted first; see ParseTransform.
+type ProfileMapping struct {
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+	Transform   string `json:"transform,omitempty" yaml:"transform,omitempty"`
+}
+
+// MappingProfile is a saved column mapping for a particular CSV layout.
+// Profiles are JSON or YAML files, e.g.
+//
+//	name: jamb-2019
+//	mappings:
+//	  - source: REG_NO
+//	    destination: regnumber
+//	  - source: SEX
+//	    destination: gender
+//	    transform: upper
+//	  - source: BLIND
+//	    destination: is_blind
+//	    transform: null_if:NIL|bool
+//
+// Transforms that need more than one column go in a Starlark script, which
+// sets mapped columns and those listed under derives; see rowScript. The
+// script is versioned with the rest of the profile, e.g.
+//
+//	version: 2
+//	derives: [middlename]
+//	script: |
+//	  def transform(row):
+//	      if not row["REG_NO"].startswith("9"):
+//	          fail("not a 2019 regnumber")
+//	      names = row["OTHER_NAMES"].split(" ", 1)
+//	      return {"firstname": names[0], "middlename": names[1] if len(names) > 1 else None}
+type MappingProfile struct {
+	Name      string           `json:"name" yaml:"name"`
+	Version   int              `json:"version,omitempty" yaml:"version,omitempty"`
+	CreatedAt time.Time        `json:"created_at" yaml:"created_at,omitempty"`
+	Headers   []string         `json:"headers" yaml:"headers,omitempty"`
+	Mappings  []ProfileMapping `json:"mappings" yaml:"mappings"`
+	Derives   []string         `json:"derives,omitempty" yaml:"derives,omitempty"`
+	Script    string           `json:"script,omitempty" yaml:"script,omitempty"`
+}
+
+// Validate checks every mapping names a source, a known candidate column
+// used once, and a valid transform, that derived columns are known and not
+// mapped, and that the script compiles
+func (p *MappingProfile) Validate() error {
+	known := make(map[string]bool)
+	for _, m := range DefaultColumnMappings() {
+		known[m.DestinationColumn] = true
+	}
+	if len(p.Mappings) == 0 {
+		return fmt.Errorf("profile has no mappings")
+	}
+	used := make(map[string]bool)
+	hasKey := false
+	for _, m := range p.Mappings {
+		if strings.TrimSpace(m.Source) == "" {
+			return fmt.Errorf("mapping to %s has no source column", m.Destination)
+		}
+		if !known[m.Destination] {
+			return fmt.Errorf("unknown destination column %q for %s", m.Destination, m.Source)
+		}
+		if used[m.Destination] {
+			return fmt.Errorf("destination column %s is mapped twice", m.Destination)
+		}
+		used[m.Destination] = true
+		hasKey = hasKey || m.Destination == "regnumber"
+		if _, err := ParseTransform(m.Transform); err != nil {
+			return fmt.Errorf("mapping %s: %v", m.Source, err)
+		}
+	}
+	if !hasKey {
+		return fmt.Errorf("profile does not map the regnumber column")
+	}
+	for _, column := range p.Derives {
+		if !known[column] {
+			return fmt.Errorf("unknown derived column %q", column)
+		}
+		if used[column] {
+			return fmt.Errorf("derived column %s is also mapped", column)
+		}
+		used[column] = true
+	}
+	if len(p.Derives) > 0 && strings.TrimSpace(p.Script) == "" {
+		return fmt.Errorf("profile derives columns but has no script")
+	}
+	if _, err := compileRowScript(p); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ColumnMappings converts the profile into importer column mappings.
+// Derived columns have no source column; only the script sets them.
+func (p *MappingProfile) ColumnMappings() ([]ColumnMapping, error) {
+	mappings := make([]ColumnMapping, 0, len(p.Mappings))
+	for _, m := range p.Mappings {
+		transform, err := ParseTransform(m.Transform)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %s: %v", m.Source, err)
+		}
+		mappings = append(mappings, ColumnMapping{
+			SourceColumn:      m.Source,
+			DestinationColumn: m.Destination,
+			TransformFunc:     transform,
+		})
+	}
+	for _, column := range p.Derives {
+		mappings = append(mappings, ColumnMapping{DestinationColumn: column})
+	}
+	return mappings, nil
+}
+
+// SaveMappingProfile writes the profile as JSON into dir and returns the file path
+func SaveMappingProfile(dir string, profile *MappingProfile) (string, error) {
+	if dir == "" {
+		dir = DefaultProfileDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating profile directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding profile: %v", err)
+	}
+
+	path := filepath.Join(dir, profileFileName(profile.Name))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("error writing profile: %v", err)
+	}
+	return path, nil
+}
+
+// LoadMappingProfile reads a profile written by SaveMappingProfile or by
+// hand. Files ending in .yaml or .yml are read as YAML, others as JSON.
+func LoadMappingProfile(path string) (*MappingProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading profile: %v", err)
+	}
+	var profile MappingProfile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &profile)
+	default:
+		err = json.Unmarshal(data, &profile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing profile %s: %v", path, err)
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %v", path, err)
+	}
+	return &profile, nil
+}
+
+// applyMappingProfile replaces the column mappings with those of the
+// configured profile, if any
+func (di *DataImporter) applyMappingProfile() error {
+	if di.config.MappingProfile == "" {
+		return nil
+	}
+	profile, err := LoadMappingProfile(di.config.MappingProfile)
+	if err != nil {
+		return err
+	}
+	mappings, err := profile.ColumnMappings()
+	if err != nil {
+		return err
+	}
+	script, err := compileRowScript(profile)
+	if err != nil {
+		return err
+	}
+	if script != nil {
+		log.Printf("Mapping profile %s version %d runs a script (sha256 %s)", script.profile, profile.Version, script.hash)
+	}
+	di.config.ColumnMappings = mappings
+	di.script = script
+	return nil
+}
+
+var unsafeProfileChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func profileFileName(name string) string {
+	name = unsafeProfileChars.ReplaceAllString(strings.TrimSpace(name), "_")
+	if name == "" {
+		name = "profile_" + time.Now().Format("20060102_150405")
+	}
+	return name + ".json"
+}
+
+// ProposeMapping asks the generator to map the CSV headers onto the candidate
+// columns, using a few data rows as context. Only mappings that reference an
+// existing header and a known destination column are kept, and the result
+// must pass Validate, so a proposal without regnumber or with an unknown
+// transform is refused.
+func ProposeMapping(ctx context.Context, gen TextGenerator, headers []string, sample [][]string) (*MappingProfile, error) {
+	destinations := make([]string, 0)
+	for _, m := range DefaultColumnMappings() {
+		destinations = append(destinations, m.DestinationColumn)
+	}
+
+	resp, err := gen.GenerateText(ctx, buildMappingPrompt(headers, sample, destinations))
+	if err != nil {
+		return nil, fmt.Errorf("error requesting mapping: %v", err)
+	}
+
+	resp = strings.ReplaceAll(resp, "```json", "")
+	resp = strings.ReplaceAll(resp, "```", "")
+	var proposed []ProfileMapping
+	if err := json.Unmarshal([]byte(strings.TrimSpace(resp)), &proposed); err != nil {
+		return nil, fmt.Errorf("error parsing proposed mapping: %v\nResponse was: %s", err, resp)
+	}
+
+	knownHeaders := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		knownHeaders[h] = true
+	}
+	knownDest := make(map[string]bool, len(destinations))
+	for _, d := range destinations {
+		knownDest[d] = true
+	}
+
+	profile := &MappingProfile{
+		CreatedAt: time.Now(),
+		Headers:   headers,
+	}
+	used := make(map[string]bool)
+	for _, m := range proposed {
+		if !knownHeaders[m.Source] || !knownDest[m.Destination] || used[m.Destination] {
+			continue
+		}
+		used[m.Destination] = true
+		profile.Mappings = append(profile.Mappings, m)
+	}
+
+	if len(profile.Mappings) == 0 {
+		return nil, fmt.Errorf("no usable mappings proposed")
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, fmt.Errorf("proposed mapping is not usable: %v", err)
+	}
+	return profile, nil
+}
+
+func buildMappingPrompt(headers []string, sample [][]string, destinations []string) string {
+	var rows strings.Builder
+	for _, record := range sample {
+		rows.WriteString(strings.Join(record, " | "))
+		rows.WriteString("\n")
+	}
+
+	return fmt.Sprintf(`You are mapping a CSV file of JAMB candidate records onto a database table.
+
+CSV headers:
+%s
+
+Sample rows (pipe separated, same order as headers):
+%s
+Destination columns:
+%s
+
+Map each CSV header that corresponds to a destination column. Use each destination at most once
+and use the CSV header text exactly as given. Leave out headers with no matching column.
+
+Return ONLY a JSON array with NO markdown formatting:
+[{"source": "CSV HEADER", "destination": "column_name"}]`,
+		strings.Join(headers, " | "), rows.String(), strings.Join(destinations, ", "))
+}
+
+// proposeMapping reads a few sample rows, asks the configured generator for a
+// mapping and, once the user confirms it, saves it as a profile and uses it
+// for this import. The sample rows are returned so they can still be
+// imported; the caller validates the headers again against the new mapping.
+func (di *DataImporter) proposeMapping(ctx context.Context, reader *csv.Reader, headers []string) ([][]string, error) {
+	sample := make([][]string, 0, 5)
+	for len(sample) < 5 {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading sample rows: %v", err)
+		}
+		sample = append(sample, record)
+	}
+
+	fmt.Print("Ask the LLM to propose a column mapping? (y/n): ")
+	if !strings.EqualFold(readLine(), "y") {
+		return nil, fmt.Errorf("no mapping available")
+	}
+
+	profile, err := ProposeMapping(ctx, di.config.MappingGenerator, headers, sample)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("\nProposed mapping:")
+	for _, m := range profile.Mappings {
+		fmt.Printf("  %-30s -> %s\n", m.Source, m.Destination)
+	}
+	fmt.Print("Use this mapping? (y/n): ")
+	if !strings.EqualFold(readLine(), "y") {
+		return nil, fmt.Errorf("proposed mapping rejected")
+	}
+
+	fmt.Print("Save as profile named: ")
+	profile.Name = readLine()
+	if path, err := SaveMappingProfile(di.config.ProfileDir, profile); err != nil {
+		log.Printf("Warning: %v", err)
+	} else {
+		fmt.Printf("Saved mapping profile to %s\n", path)
+	}
+
+	mappings, err := profile.ColumnMappings()
+	if err != nil {
+		return nil, err
+	}
+	di.config.ColumnMappings = mappings
+	return sample, nil
+}
+
+// readLine reads a whole line from stdin, so answers such as profile names
+// may contain spaces
+func readLine() string {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
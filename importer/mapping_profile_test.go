@@ -0,0 +1,52 @@
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// cannedGenerator answers every prompt with the same text
+type cannedGenerator string
+
+func (g cannedGenerator) GenerateText(ctx context.Context, prompt string) (string, error) {
+	return string(g), nil
+}
+
+func TestProposeMapping(t *testing.T) {
+	headers := []string{"REG NO", "LAST NAME", "SCORE"}
+	tests := []struct {
+		name     string
+		response string
+		want     int // mappings kept
+		wantErr  string
+	}{
+		{"usable", "```json\n" + `[{"source": "REG NO", "destination": "regnumber"},
+			{"source": "LAST NAME", "destination": "surname"},
+			{"source": "SCORE", "destination": "aggregate"}]` + "\n```", 3, ""},
+		{"unknown header and repeated destination dropped", `[{"source": "REG NO", "destination": "regnumber"},
+			{"source": "FIRST NAME", "destination": "firstname"},
+			{"source": "LAST NAME", "destination": "regnumber"}]`, 1, ""},
+		{"nothing usable", `[{"source": "EMAIL", "destination": "email"}]`, 0, "no usable mappings"},
+		{"no regnumber", `[{"source": "LAST NAME", "destination": "surname"}]`, 0, "does not map the regnumber column"},
+		{"unknown transform", `[{"source": "REG NO", "destination": "regnumber"},
+			{"source": "SCORE", "destination": "aggregate", "transform": "shout"}]`, 0, "mapping SCORE"},
+		{"not JSON", "REG NO maps to regnumber", 0, "error parsing proposed mapping"},
+	}
+	for _, tt := range tests {
+		profile, err := ProposeMapping(context.Background(), cannedGenerator(tt.response), headers, nil)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(profile.Mappings) != tt.want {
+			t.Errorf("%s: kept %v, want %d mappings", tt.name, profile.Mappings, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// proposedMapping is one expected column and the header proposed for it.
+// Header is blank when no match was good enough to propose.
+type proposedMapping struct {
+	Column      string // the source column the mapping expects
+	Destination string // the candidate column it fills; blank for a required column no mapping reads
+	Required    bool
+	Header      string
+	Confidence  float64
+	Matches     []ColumnMatch // fuzzy matches, best first; nil for exact headers
+}
+
+// proposeMappings matches the source column of every mapping, and every
+// required column, to a header: exact headers first, then a single fuzzy
+// match at or above threshold that is not tied. Headers that are another
+// column's exact name are never fuzzy matched. The registration number is
+// always required.
+func (di *DataImporter) proposeMappings(headers []string, threshold float64) []proposedMapping {
+	required := make(map[string]bool)
+	for _, column := range di.config.RequiredColumns {
+		required[strings.ToUpper(column)] = true
+	}
+	claimed := make(map[string]bool)
+	for _, m := range di.config.ColumnMappings {
+		claimed[strings.ToUpper(m.SourceColumn)] = true
+	}
+	var free []string
+	for _, h := range headers {
+		if !claimed[strings.ToUpper(strings.TrimSpace(h))] && !required[strings.ToUpper(strings.TrimSpace(h))] {
+			free = append(free, h)
+		}
+	}
+
+	var proposals []proposedMapping
+	seen := make(map[string]bool)
+	propose := func(column, destination string) {
+		key := strings.ToUpper(column)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		p := proposedMapping{Column: column, Destination: destination, Required: required[key] || destination == "regnumber"}
+		if getColumnIndex(headers, column) != -1 {
+			p.Header, p.Confidence = column, 1
+			proposals = append(proposals, p)
+			return
+		}
+		// Each match's destination is a header
+		p.Matches = di.findBestColumnMatch(column, free)
+		if len(p.Matches) > 0 {
+			tied := len(p.Matches) > 1 && p.Matches[1].Confidence == p.Matches[0].Confidence
+			if p.Matches[0].Confidence >= threshold && !tied {
+				p.Header, p.Confidence = p.Matches[0].DestinationColumn, p.Matches[0].Confidence
+			}
+		}
+		proposals = append(proposals, p)
+	}
+	for _, m := range di.config.ColumnMappings {
+		if m.SourceColumn == "" {
+			continue // set by the profile script
+		}
+		propose(m.SourceColumn, m.DestinationColumn)
+	}
+	for _, column := range di.config.RequiredColumns {
+		propose(column, "")
+	}
+	return proposals
+}
+
+// needsReview reports whether any required column was not found under its
+// own name, or any optional column has a near match worth checking
+func needsReview(proposals []proposedMapping) bool {
+	for _, p := range proposals {
+		if p.Header != p.Column && (p.Required || len(p.Matches) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// reviewMappings shows every proposed mapping on one screen and lets the
+// user change any of them before the import starts
+func reviewMappings(proposals []proposedMapping, headers []string) {
+	for {
+		fmt.Println("\nProposed column mapping:")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"#", "Column", "Destination", "Source Header", "Confidence", "Other Matches"})
+		for i, p := range proposals {
+			header, confidence := p.Header, ""
+			switch {
+			case header == "" && p.Required:
+				header = "(unmapped, required)"
+			case header == "":
+				header = "(unmapped)"
+			case p.Confidence == 1 && p.Matches == nil:
+				confidence = "exact"
+			case p.Confidence == 0:
+				confidence = "chosen"
+			default:
+				confidence = fmt.Sprintf("%.0f%%", p.Confidence*100)
+			}
+			var others []string
+			for _, m := range p.Matches {
+				if m.DestinationColumn != p.Header {
+					others = append(others, fmt.Sprintf("%s (%.0f%%)", m.DestinationColumn, m.Confidence*100))
+				}
+			}
+			table.Append([]string{strconv.Itoa(i + 1), p.Column, p.Destination, header, confidence, strings.Join(others, ", ")})
+		}
+		table.Render()
+
+		fmt.Print("Enter a row number to change its mapping, or press Enter to continue: ")
+		var input string
+		fmt.Scanln(&input)
+		if input == "" {
+			return
+		}
+		row, err := strconv.Atoi(input)
+		if err != nil || row < 1 || row > len(proposals) {
+			fmt.Printf("Enter a row between 1 and %d\n", len(proposals))
+			continue
+		}
+		editMapping(&proposals[row-1], headers)
+	}
+}
+
+// editMapping asks which header a column should be read from
+func editMapping(p *proposedMapping, headers []string) {
+	fmt.Printf("\nHeaders in the file:\n")
+	for i, h := range headers {
+		fmt.Printf("%d. %s\n", i+1, h)
+	}
+	fmt.Printf("Header for '%s' (0 to leave unmapped): ", p.Column)
+	var choice int
+	if _, err := fmt.Scanln(&choice); err != nil || choice < 0 || choice > len(headers) {
+		fmt.Println("Mapping unchanged")
+		return
+	}
+	if choice == 0 {
+		p.Header, p.Confidence = "", 0
+		return
+	}
+	p.Header, p.Confidence = headers[choice-1], 0
+	for _, m := range p.Matches {
+		if m.DestinationColumn == p.Header {
+			p.Confidence = m.Confidence
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// ScriptMaxSteps bounds the Starlark steps a profile script may take for
+// one row, so a runaway loop fails the row instead of the import
+const ScriptMaxSteps = 100000
+
+// rowScript is a mapping profile's Starlark script, compiled once and
+// called for every row. The script defines transform(row), where row maps
+// each CSV header to its raw text, and returns None or a dict of
+// destination columns to values that replace the mapped ones. Calling
+// fail(msg) rejects the row with msg.
+//
+// Scripts are sandboxed: they cannot load other files, read the clock or
+// the filesystem, recurse or use while loops, and each call is limited to
+// ScriptMaxSteps.
+type rowScript struct {
+	profile   string
+	transform starlark.Callable
+	sets      map[string]bool // destinations the script may set
+	hash      string
+}
+
+// compileRowScript compiles the profile's script, checking it defines
+// transform(row). A profile without a script returns nil.
+func compileRowScript(p *MappingProfile) (*rowScript, error) {
+	if strings.TrimSpace(p.Script) == "" {
+		return nil, nil
+	}
+	name := p.Name
+	if name == "" {
+		name = "profile"
+	}
+	thread := &starlark.Thread{Name: name, Print: func(_ *starlark.Thread, msg string) {
+		log.Printf("%s script: %s", name, msg)
+	}}
+	thread.SetMaxExecutionSteps(ScriptMaxSteps)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, name+".star", p.Script, nil)
+	if err != nil {
+		return nil, fmt.Errorf("script: %v", err)
+	}
+	fn, ok := globals["transform"].(*starlark.Function)
+	if !ok {
+		return nil, fmt.Errorf("script does not define transform(row)")
+	}
+	if fn.NumParams() != 1 {
+		return nil, fmt.Errorf("script transform must take one argument, the row")
+	}
+
+	script := &rowScript{profile: name, transform: fn, sets: make(map[string]bool)}
+	for _, m := range p.Mappings {
+		script.sets[m.Destination] = true
+	}
+	for _, column := range p.Derives {
+		script.sets[column] = true
+	}
+	sum := sha256.Sum256([]byte(p.Script))
+	script.hash = hex.EncodeToString(sum[:])[:12]
+	return script, nil
+}
+
+// apply calls the script on one record and returns the values it set, by
+// destination column. Strings go through the column's usual conversion;
+// None, ints, floats and bools are used as they are.
+func (s *rowScript) apply(headers, record []string) (map[string]interface{}, error) {
+	row := starlark.NewDict(len(headers))
+	for i, h := range headers {
+		value := ""
+		if i < len(record) {
+			value = strings.TrimSpace(record[i])
+		}
+		if err := row.SetKey(starlark.String(strings.TrimSpace(h)), starlark.String(value)); err != nil {
+			return nil, err
+		}
+	}
+
+	thread := &starlark.Thread{Name: s.profile, Print: func(_ *starlark.Thread, msg string) {
+		log.Printf("%s script: %s", s.profile, msg)
+	}}
+	thread.SetMaxExecutionSteps(ScriptMaxSteps)
+	result, err := starlark.Call(thread, s.transform, starlark.Tuple{row}, nil)
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return nil, fmt.Errorf("script: %s", evalErr.Msg)
+		}
+		return nil, fmt.Errorf("script: %v", err)
+	}
+	if result == starlark.None {
+		return nil, nil
+	}
+	dict, ok := result.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("script: transform returned %s, not a dict or None", result.Type())
+	}
+
+	values := make(map[string]interface{}, dict.Len())
+	for _, item := range dict.Items() {
+		column, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("script: column name %s is not a string", item[0])
+		}
+		if !s.sets[column] {
+			return nil, fmt.Errorf("script: sets %s, which the profile neither maps nor derives", column)
+		}
+		switch v := item[1].(type) {
+		case starlark.NoneType:
+			values[column] = nil
+		case starlark.String:
+			values[column] = string(v)
+		case starlark.Bool:
+			values[column] = bool(v)
+		case starlark.Int:
+			n, ok := v.Int64()
+			if !ok {
+				return nil, fmt.Errorf("script: %s value %s is too large", column, v)
+			}
+			values[column] = n
+		case starlark.Float:
+			values[column] = float64(v)
+		default:
+			return nil, fmt.Errorf("script: %s value has type %s", column, v.Type())
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,94 @@
+package importer
+
+import "time"
+
+// ImportProgress is a snapshot of a running import
+type ImportProgress struct {
+	Year      int
+	Processed int // rows read so far
+	Succeeded int
+	Failed    int
+	// BytesRead and TotalBytes measure progress through the source file;
+	// TotalBytes is zero when the size is unknown
+	BytesRead  int64
+	TotalBytes int64
+	Elapsed    time.Duration
+	// ETA estimates the time remaining, zero when it can't be estimated
+	ETA  time.Duration
+	Done bool
+}
+
+// Percent returns how far through the source file the import is, or -1
+// when the size is unknown
+func (p ImportProgress) Percent() float64 {
+	if p.TotalBytes <= 0 {
+		return -1
+	}
+	return float64(p.BytesRead) * 100 / float64(p.TotalBytes)
+}
+
+// ProgressReporter receives progress after each batch and once more with
+// Done set when the import finishes. Reports arrive on the importing
+// goroutine, so implementations should return quickly.
+type ProgressReporter interface {
+	ReportProgress(ImportProgress)
+}
+
+// ProgressFunc adapts a function to ProgressReporter
+type ProgressFunc func(ImportProgress)
+
+func (f ProgressFunc) ReportProgress(p ImportProgress) { f(p) }
+
+// ProgressChannel returns a reporter that sends to ch without blocking;
+// snapshots are dropped while the receiver is busy, except the final one
+func ProgressChannel(ch chan<- ImportProgress) ProgressReporter {
+	return ProgressFunc(func(p ImportProgress) {
+		if p.Done {
+			ch <- p
+			return
+		}
+		select {
+		case ch <- p:
+		default:
+		}
+	})
+}
+
+// progressTracker turns the importer's counters into ImportProgress reports
+type progressTracker struct {
+	reporter ProgressReporter
+	year     int
+	total    int64
+	start    time.Time
+}
+
+func (di *DataImporter) newProgressTracker() *progressTracker {
+	return &progressTracker{
+		reporter: di.config.Progress,
+		year:     di.config.Year,
+		total:    di.config.SourceSize,
+		start:    time.Now(),
+	}
+}
+
+// report sends a snapshot; bytesRead is how far the reader had got when the
+// last completed batch was read
+func (t *progressTracker) report(processed, succeeded, failed int, bytesRead int64, done bool) {
+	if t.reporter == nil {
+		return
+	}
+	p := ImportProgress{
+		Year:       t.year,
+		Processed:  processed,
+		Succeeded:  succeeded,
+		Failed:     failed,
+		BytesRead:  bytesRead,
+		TotalBytes: t.total,
+		Elapsed:    time.Since(t.start),
+		Done:       done,
+	}
+	if !done && p.TotalBytes > 0 && p.BytesRead > 0 && p.BytesRead < p.TotalBytes {
+		p.ETA = time.Duration(float64(p.Elapsed) * float64(p.TotalBytes-p.BytesRead) / float64(p.BytesRead))
+	}
+	t.reporter.ReportProgress(p)
+}
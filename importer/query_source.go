@@ -0,0 +1,116 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// querySource streams a query's rows as CSV into a pipe
+type querySource struct {
+	cancel context.CancelFunc
+	pipe   *io.PipeReader
+	done   chan struct{}
+}
+
+func (s *querySource) Close() error {
+	s.cancel()
+	s.pipe.Close()
+	<-s.done
+	return nil
+}
+
+// OpenQuery returns a CSV reader over the rows query returns from db, with
+// the column names as the header row, so a staging database goes through
+// the same header mapping and import as a file. Alias columns in the query
+// to match the import headers, e.g. SELECT reg_no AS regnumber. The query
+// runs in a read-only transaction and NULLs become empty cells. Rows are
+// streamed rather than loaded at once; close the returned Closer when done.
+func OpenQuery(ctx context.Context, db *sql.DB, query string) (*csv.Reader, io.Closer, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("error connecting to source database: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		tx.Rollback()
+		cancel()
+		return nil, nil, fmt.Errorf("error running source query: %w", err)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		tx.Rollback()
+		cancel()
+		return nil, nil, fmt.Errorf("error reading source columns: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer tx.Rollback()
+		defer rows.Close()
+		w := csv.NewWriter(pw)
+		if err := w.Write(columns); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		record := make([]string, len(columns))
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				pw.CloseWithError(fmt.Errorf("error reading source row: %w", err))
+				return
+			}
+			for i, v := range values {
+				record[i] = queryCell(v)
+			}
+			if err := w.Write(record); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			pw.CloseWithError(fmt.Errorf("error reading source rows: %w", err))
+			return
+		}
+		w.Flush()
+		pw.CloseWithError(w.Error())
+	}()
+
+	reader := csv.NewReader(pr)
+	reader.FieldsPerRecord = -1
+	return reader, &querySource{cancel: cancel, pipe: pr, done: done}, nil
+}
+
+// queryCell formats a source value as the text a CSV file would hold
+func queryCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
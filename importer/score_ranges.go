@@ -0,0 +1,121 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Scores outside a subject's configured range are flagged during imports.
+// Subjects without a range are scored DefaultMinScore-DefaultMaxScore.
+const (
+	DefaultMinScore = 0
+	DefaultMaxScore = 100
+)
+
+// ScoreRange is the valid score range of a subject, for one year or, when
+// Year is 0, for every year without a range of its own
+type ScoreRange struct {
+	SubjectID int
+	Subject   string // abbreviation or name, for display
+	Year      int
+	Min, Max  int
+}
+
+func (r ScoreRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// scoreRanges looks up the range a score is checked against
+type scoreRanges map[[2]int]ScoreRange
+
+func (sr scoreRanges) forSubject(subjectID, year int) ScoreRange {
+	if r, ok := sr[[2]int{subjectID, year}]; ok {
+		return r
+	}
+	if r, ok := sr[[2]int{subjectID, 0}]; ok {
+		return r
+	}
+	return ScoreRange{SubjectID: subjectID, Year: year, Min: DefaultMinScore, Max: DefaultMaxScore}
+}
+
+func loadScoreRanges(ctx context.Context, db *sql.DB) (scoreRanges, error) {
+	list, err := ListScoreRanges(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	ranges := make(scoreRanges, len(list))
+	for _, r := range list {
+		ranges[[2]int{r.SubjectID, r.Year}] = r
+	}
+	return ranges, nil
+}
+
+// ListScoreRanges returns the configured ranges by subject and year
+func ListScoreRanges(ctx context.Context, db *sql.DB) ([]ScoreRange, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT r.subject_id, COALESCE(NULLIF(s.su_abrv, ''), s.su_name, r.subject_id::text),
+               COALESCE(r.year, 0), r.min_score, r.max_score
+        FROM subject_score_ranges r
+        LEFT JOIN subject s ON s.su_id = r.subject_id
+        ORDER BY 2, r.year NULLS FIRST`)
+	if err != nil {
+		return nil, fmt.Errorf("error loading subject score ranges: %w", err)
+	}
+	defer rows.Close()
+
+	var ranges []ScoreRange
+	for rows.Next() {
+		var r ScoreRange
+		if err := rows.Scan(&r.SubjectID, &r.Subject, &r.Year, &r.Min, &r.Max); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, rows.Err()
+}
+
+// SetScoreRange adds or replaces the range of a subject for a year, or for
+// every year when Year is 0
+func SetScoreRange(ctx context.Context, db *sql.DB, r ScoreRange) error {
+	if r.Min < 0 || r.Min > r.Max {
+		return fmt.Errorf("invalid score range %s", r)
+	}
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO subject_score_ranges (subject_id, year, min_score, max_score)
+        VALUES ($1, NULLIF($2, 0), $3, $4)
+        ON CONFLICT (subject_id, COALESCE(year, 0)) DO UPDATE
+        SET min_score = EXCLUDED.min_score, max_score = EXCLUDED.max_score`,
+		r.SubjectID, r.Year, r.Min, r.Max)
+	if err != nil {
+		return fmt.Errorf("error saving score range: %w", err)
+	}
+	return nil
+}
+
+// DeleteScoreRange removes the range of a subject for a year, or its range
+// for every year when year is 0
+func DeleteScoreRange(ctx context.Context, db *sql.DB, subjectID, year int) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM subject_score_ranges WHERE subject_id = $1 AND COALESCE(year, 0) = $2`, subjectID, year)
+	if err != nil {
+		return fmt.Errorf("error deleting score range: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no score range for that subject and year")
+	}
+	return nil
+}
+
+// ResolveSubject returns the id of a subject given by id, abbreviation or
+// name
+func ResolveSubject(ctx context.Context, db *sql.DB, value string) (int, error) {
+	subjects, err := newSubjectMapper(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	id, ok := subjects.resolve(value)
+	if !ok {
+		return 0, fmt.Errorf("unknown subject %q", value)
+	}
+	return id, nil
+}
@@ -0,0 +1,410 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ScoreLayout is how subject scores are arranged in a file
+type ScoreLayout string
+
+const (
+	// ScoreLayoutWide has one row per candidate with SUBJ1-SUBJ4 and
+	// SCORE1-SCORE4 columns
+	ScoreLayoutWide ScoreLayout = "wide"
+	// ScoreLayoutLong has one row per candidate and subject with SUBJECT
+	// and SCORE columns
+	ScoreLayoutLong ScoreLayout = "long"
+)
+
+// WideScoreSubjects is the number of subject/score column pairs in a wide file
+const WideScoreSubjects = 4
+
+// ScoreMappings returns the default column mappings for a layout. Wide
+// files map SUBJn/SCOREn to the destinations subjectN/scoreN; long files
+// map SUBJECT/SCORE to subject_id/score. A YEAR column, when present,
+// overrides the configured year for its row.
+func ScoreMappings(layout ScoreLayout) []ColumnMapping {
+	mappings := []ColumnMapping{
+		{SourceColumn: "REGNUMBER", DestinationColumn: "cand_reg_number"},
+		{SourceColumn: "YEAR", DestinationColumn: "year"},
+	}
+	if layout == ScoreLayoutLong {
+		return append(mappings,
+			ColumnMapping{SourceColumn: "SUBJECT", DestinationColumn: "subject_id"},
+			ColumnMapping{SourceColumn: "SCORE", DestinationColumn: "score"})
+	}
+	for n := 1; n <= WideScoreSubjects; n++ {
+		mappings = append(mappings,
+			ColumnMapping{SourceColumn: fmt.Sprintf("SUBJ%d", n), DestinationColumn: fmt.Sprintf("subject%d", n)},
+			ColumnMapping{SourceColumn: fmt.Sprintf("SCORE%d", n), DestinationColumn: fmt.Sprintf("score%d", n)})
+	}
+	return mappings
+}
+
+// DetectScoreLayout guesses a file's layout from its headers
+func DetectScoreLayout(headers []string) ScoreLayout {
+	if getColumnIndex(headers, "SUBJ1") != -1 || getColumnIndex(headers, "SCORE1") != -1 {
+		return ScoreLayoutWide
+	}
+	return ScoreLayoutLong
+}
+
+// ScoreSummary reports what a score import did
+type ScoreSummary struct {
+	Rows              int            // data rows read
+	Scores            int            // subject scores written
+	Failed            int            // rows without a single usable score
+	Invalid           int            // individual scores that were missing or not a number
+	UnknownCandidates int            // scores whose regnumber is not in candidate
+	UnknownSubjects   map[string]int // subject values that matched no subject
+	// OutOfRange counts the scores left out for falling outside their
+	// subject's range, by subject, year and range, e.g. "ENG 2019 (0-60)"
+	OutOfRange map[string]int
+	Applied    bool // false when only validating
+}
+
+// Subjects returns the unknown subject values, most frequent first
+func (s *ScoreSummary) Subjects() []string {
+	return byCount(s.UnknownSubjects)
+}
+
+// OutOfRangeSubjects returns the keys of OutOfRange, most frequent first
+func (s *ScoreSummary) OutOfRangeSubjects() []string {
+	return byCount(s.OutOfRange)
+}
+
+func byCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// scoreColumns locates the mapped columns of a score file
+type scoreColumns struct {
+	regnumber int
+	year      int
+	pairs     [][2]int // subject and score column of each pair
+}
+
+func (di *DataImporter) scoreColumns(headers []string) (scoreColumns, error) {
+	cols := scoreColumns{regnumber: -1, year: -1}
+	subjects := make(map[string]int)
+	scores := make(map[string]int)
+	for _, mapping := range di.config.ColumnMappings {
+		idx := getColumnIndex(headers, mapping.SourceColumn)
+		if idx == -1 {
+			continue
+		}
+		switch dest := mapping.DestinationColumn; {
+		case dest == "cand_reg_number":
+			cols.regnumber = idx
+		case dest == "year":
+			cols.year = idx
+		case strings.HasPrefix(dest, "subject"):
+			subjects[strings.TrimPrefix(strings.TrimPrefix(dest, "subject"), "_id")] = idx
+		case strings.HasPrefix(dest, "score"):
+			scores[strings.TrimPrefix(dest, "score")] = idx
+		}
+	}
+	if cols.regnumber == -1 {
+		return cols, fmt.Errorf("score file has no registration number column")
+	}
+
+	keys := make([]string, 0, len(subjects))
+	for key := range subjects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		score, ok := scores[key]
+		if !ok {
+			continue
+		}
+		cols.pairs = append(cols.pairs, [2]int{subjects[key], score})
+	}
+	if len(cols.pairs) == 0 {
+		return cols, fmt.Errorf("score file has no subject and score columns")
+	}
+	return cols, nil
+}
+
+// subjectMapper resolves subject ids, abbreviations and names to su_id
+type subjectMapper struct {
+	ids    map[string]int
+	labels map[int]string // abbreviation, or name, of each subject
+}
+
+func newSubjectMapper(ctx context.Context, db *sql.DB) (*subjectMapper, error) {
+	rows, err := db.QueryContext(ctx, "SELECT su_id, COALESCE(su_abrv, ''), COALESCE(su_name, '') FROM subject")
+	if err != nil {
+		return nil, fmt.Errorf("error loading subjects: %w", err)
+	}
+	defer rows.Close()
+
+	sm := &subjectMapper{ids: make(map[string]int), labels: make(map[int]string)}
+	for rows.Next() {
+		var id int
+		var abbreviation, name string
+		if err := rows.Scan(&id, &abbreviation, &name); err != nil {
+			return nil, err
+		}
+		sm.ids[strconv.Itoa(id)] = id
+		sm.labels[id] = strconv.Itoa(id)
+		for _, key := range []string{abbreviation, name} {
+			if key = strings.ToUpper(strings.TrimSpace(key)); key != "" {
+				sm.ids[key] = id
+			}
+		}
+		if label := strings.TrimSpace(abbreviation); label != "" {
+			sm.labels[id] = strings.ToUpper(label)
+		} else if label := strings.TrimSpace(name); label != "" {
+			sm.labels[id] = label
+		}
+	}
+	return sm, rows.Err()
+}
+
+func (sm *subjectMapper) resolve(value string) (int, bool) {
+	id, ok := sm.ids[strings.ToUpper(strings.TrimSpace(value))]
+	return id, ok
+}
+
+// subjectScore is one normalized candidate_scores row
+type subjectScore struct {
+	regnumber string
+	subjectID int
+	score     int
+	year      int
+}
+
+// ImportScores loads per-subject scores into candidate_scores. Files may be
+// wide or long (see ScoreLayout); without ColumnMappings the layout is
+// detected from the headers. Each candidate, subject and year in the file
+// replaces any score already stored for it, so a file can be re-imported.
+// Scores are only written for candidates already imported. Scores outside
+// their subject's range for the year (see ScoreRange) are left out and
+// counted in the summary.
+func ImportScores(ctx context.Context, db *sql.DB, config ImportConfig, reader *csv.Reader) (*ScoreSummary, error) {
+	mappings := config.ColumnMappings
+	di := NewDataImporter(db, config)
+	di.config.ColumnMappings = mappings
+	return di.ImportScores(ctx, reader)
+}
+
+func (di *DataImporter) ImportScores(ctx context.Context, reader *csv.Reader) (*ScoreSummary, error) {
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading headers: %v", err)
+	}
+	if di.config.ColumnMappings == nil {
+		di.config.ColumnMappings = ScoreMappings(DetectScoreLayout(headers))
+	}
+	cols, err := di.scoreColumns(headers)
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := newSubjectMapper(ctx, di.db)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := loadScoreRanges(ctx, di.db)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ScoreSummary{UnknownSubjects: make(map[string]int), OutOfRange: make(map[string]int), Applied: !di.config.ValidateOnly}
+	batch := make([]subjectScore, 0, di.config.BatchSize)
+	progress := di.newProgressTracker()
+	flush := func() error {
+		if err := di.writeScores(ctx, batch, summary); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return summary, fmt.Errorf("score import cancelled: %v", err)
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Error reading record: %v", err)
+			summary.Failed++
+			continue
+		}
+		summary.Rows++
+		line, _ := reader.FieldPos(0)
+
+		scores, err := di.transformScores(record, line, cols, subjects, ranges, summary)
+		if err != nil {
+			log.Printf("Line %d: %v", line, err)
+			summary.Failed++
+			continue
+		}
+		batch = append(batch, scores...)
+		if len(batch) >= di.config.BatchSize {
+			if err := flush(); err != nil {
+				return summary, err
+			}
+			progress.report(summary.Rows, summary.Rows-summary.Failed, summary.Failed, reader.InputOffset(), false)
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return summary, err
+		}
+	}
+	progress.report(summary.Rows, summary.Rows-summary.Failed, summary.Failed, reader.InputOffset(), true)
+
+	if summary.Applied && summary.Scores > 0 {
+		di.runCompletionHooks(ctx)
+	}
+	return summary, nil
+}
+
+// transformScores normalizes a row into one score per usable subject
+func (di *DataImporter) transformScores(record []string, line int, cols scoreColumns, subjects *subjectMapper, ranges scoreRanges, summary *ScoreSummary) ([]subjectScore, error) {
+	field := func(idx int) string {
+		if idx == -1 || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+
+	regnumber := field(cols.regnumber)
+	if regnumber == "" {
+		return nil, fmt.Errorf("missing registration number")
+	}
+	year := di.config.Year
+	if raw := field(cols.year); raw != "" {
+		y, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid year %q", raw)
+		}
+		year = y
+	}
+	if year == 0 {
+		return nil, fmt.Errorf("no year for %s", regnumber)
+	}
+
+	var scores []subjectScore
+	for _, pair := range cols.pairs {
+		subject, raw := field(pair[0]), field(pair[1])
+		if subject == "" && raw == "" {
+			continue
+		}
+		id, ok := subjects.resolve(subject)
+		if !ok {
+			summary.UnknownSubjects[strings.ToUpper(subject)]++
+			continue
+		}
+		score, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			summary.Invalid++
+			continue
+		}
+		if r := ranges.forSubject(id, year); score < float64(r.Min) || score > float64(r.Max) {
+			log.Printf("Line %d: %s score %s for %s is outside %s for %d", line, subjects.labels[id], raw, regnumber, r, year)
+			summary.OutOfRange[fmt.Sprintf("%s %d (%s)", subjects.labels[id], year, r)]++
+			continue
+		}
+		scores = append(scores, subjectScore{regnumber: regnumber, subjectID: id, score: int(math.Round(score)), year: year})
+	}
+	if len(scores) == 0 {
+		return nil, fmt.Errorf("no usable scores for %s", regnumber)
+	}
+	return scores, nil
+}
+
+// writeScores replaces the stored scores for a batch in one transaction.
+// When only validating it just counts the scores that would be written.
+func (di *DataImporter) writeScores(ctx context.Context, batch []subjectScore, summary *ScoreSummary) error {
+	regs := make([]string, len(batch))
+	subjectIDs := make([]int64, len(batch))
+	scores := make([]int64, len(batch))
+	years := make([]int64, len(batch))
+	for i, s := range batch {
+		regs[i] = s.regnumber
+		subjectIDs[i] = int64(s.subjectID)
+		scores[i] = int64(s.score)
+		years[i] = int64(s.year)
+	}
+
+	tx, err := di.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// A file may list the same subject twice for a candidate; the last wins
+	_, err = tx.ExecContext(ctx, `
+        CREATE TEMP TABLE IF NOT EXISTS score_staging (
+            row_number BIGINT, cand_reg_number VARCHAR(20), subject_id INTEGER, score INTEGER, year INTEGER
+        ) ON COMMIT DELETE ROWS;
+        TRUNCATE score_staging`)
+	if err != nil {
+		return fmt.Errorf("error creating score staging table: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO score_staging
+        SELECT DISTINCT ON (s.reg, s.subject_id, s.year) s.n, s.reg, s.subject_id, s.score, s.year
+        FROM unnest($1::text[], $2::int[], $3::int[], $4::int[]) WITH ORDINALITY AS s(reg, subject_id, score, year, n)
+        JOIN candidate c ON c.regnumber = s.reg
+        ORDER BY s.reg, s.subject_id, s.year, s.n DESC`,
+		pq.Array(regs), pq.Array(subjectIDs), pq.Array(scores), pq.Array(years))
+	if err != nil {
+		return fmt.Errorf("error staging scores: %w", err)
+	}
+
+	var staged, known int
+	err = tx.QueryRowContext(ctx, `
+        SELECT COUNT(*),
+               (SELECT COUNT(*) FROM unnest($1::text[]) AS r(reg) WHERE EXISTS (SELECT 1 FROM candidate c WHERE c.regnumber = r.reg))
+        FROM score_staging`, pq.Array(regs)).Scan(&staged, &known)
+	if err != nil {
+		return err
+	}
+	summary.UnknownCandidates += len(batch) - known
+	summary.Scores += staged
+	if !summary.Applied {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        DELETE FROM candidate_scores cs
+        USING score_staging s
+        WHERE cs.cand_reg_number = s.cand_reg_number AND cs.subject_id = s.subject_id AND cs.year = s.year`)
+	if err != nil {
+		return fmt.Errorf("error replacing scores: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO candidate_scores (cand_reg_number, subject_id, score, year, created_at, updated_at)
+        SELECT cand_reg_number, subject_id, score, year, NOW(), NOW() FROM score_staging`)
+	if err != nil {
+		return fmt.Errorf("error inserting scores: %w", err)
+	}
+	return tx.Commit()
+}
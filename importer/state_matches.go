@@ -0,0 +1,244 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/nonsonwune/spk2_db/db"
+)
+
+// DefaultStateMatchDistance is the largest edit distance at which a
+// misspelt state name is matched, unless STATE_MATCH_DISTANCE says
+// otherwise
+const DefaultStateMatchDistance = 2
+
+// StateMatchDistanceFromEnv reads STATE_MATCH_DISTANCE, where 0 turns
+// fuzzy state matching off
+func StateMatchDistanceFromEnv() (int, error) {
+	raw := os.Getenv("STATE_MATCH_DISTANCE")
+	if raw == "" {
+		return DefaultStateMatchDistance, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return DefaultStateMatchDistance, fmt.Errorf("STATE_MATCH_DISTANCE %q is not a whole number of at least 0", raw)
+	}
+	return n, nil
+}
+
+// loadReviewed reads the spellings confirmed or corrected in the review,
+// which later imports match exactly
+func (sm *StateMapper) loadReviewed() error {
+	rows, err := sm.db.Query(`SELECT raw_value, state_id FROM state_value_mappings`)
+	if err != nil {
+		return fmt.Errorf("error loading reviewed state spellings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw string
+		var id int
+		if err := rows.Scan(&raw, &id); err != nil {
+			return err
+		}
+		sm.reviewed[normalizeStateValue(raw)] = id
+	}
+	return rows.Err()
+}
+
+func normalizeStateValue(raw string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(raw)), " ")
+}
+
+// match looks name up exactly, among reviewed spellings, then by edit
+// distance, accepting only an unambiguous best match within maxDistance
+func (sm *StateMapper) match(name, raw string) (int, int, error) {
+	if id, ok := sm.nameToID[name]; ok {
+		return id, 0, nil
+	}
+	if id, ok := sm.reviewed[normalizeStateValue(raw)]; ok {
+		return id, 0, nil
+	}
+	if sm.maxDistance == 0 {
+		return 0, 0, fmt.Errorf("state not found: %s", name)
+	}
+
+	best, bestID, ties := sm.maxDistance+1, 0, 0
+	for state, id := range sm.nameToID {
+		d := levenshteinDistance(name, state)
+		switch {
+		case d < best:
+			best, bestID, ties = d, id, 1
+		case d == best && id != bestID:
+			ties++
+		}
+	}
+	switch {
+	case best > sm.maxDistance:
+		return 0, 0, fmt.Errorf("state not found: %s", name)
+	case ties > 1:
+		return 0, 0, fmt.Errorf("state %s is as close to %d states", name, ties)
+	}
+	return bestID, best, nil
+}
+
+// stateMatch is a row whose state was resolved by fuzzy matching
+type stateMatch struct {
+	regnumber string
+	raw       string
+	stateID   int
+	distance  int
+}
+
+func (di *DataImporter) noteStateMatch(m stateMatch) {
+	di.mu.Lock()
+	di.stateMatches = append(di.stateMatches, m)
+	di.mu.Unlock()
+}
+
+// flushStateMatches records the states this import resolved by fuzzy
+// matching in state_matches, for review
+func (di *DataImporter) flushStateMatches(ctx context.Context) error {
+	di.mu.Lock()
+	matches := di.stateMatches
+	di.stateMatches = nil
+	di.mu.Unlock()
+	if len(matches) == 0 {
+		return nil
+	}
+	log.Printf("States of %d rows were matched by spelling distance; see the state match review", len(matches))
+
+	conn, err := di.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows := make([][]interface{}, len(matches))
+	for i, m := range matches {
+		raw := m.raw
+		if len(raw) > 100 {
+			raw = raw[:100]
+		}
+		rows[i] = []interface{}{m.regnumber, raw, m.stateID, m.distance, di.config.SourceFile, di.config.Year}
+	}
+	err = db.CopyIn(ctx, conn, tx, "state_matches", []string{"regnumber", "raw_value", "state_id", "distance", "source_file", "year"}, rows)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StateMatchGroup summarizes the rows whose state was resolved from one
+// raw value to one state
+type StateMatchGroup struct {
+	RawValue string
+	StateID  int
+	State    string
+	Distance int
+	Rows     int
+	Sample   []string // a few affected regnumbers
+}
+
+// PendingStateMatches lists the fuzzy state matches not yet reviewed, the
+// most distant and then the most frequent first
+func PendingStateMatches(ctx context.Context, db *sql.DB) ([]StateMatchGroup, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT m.raw_value, m.state_id, COALESCE(s.st_name, m.state_id::text), MAX(m.distance), COUNT(*),
+               (array_agg(m.regnumber ORDER BY m.regnumber))[1:5]
+        FROM state_matches m
+        LEFT JOIN state s ON s.st_id = m.state_id
+        WHERE m.reviewed_at IS NULL
+        GROUP BY m.raw_value, m.state_id, s.st_name
+        ORDER BY MAX(m.distance) DESC, COUNT(*) DESC, m.raw_value`)
+	if err != nil {
+		return nil, fmt.Errorf("error loading state matches: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []StateMatchGroup
+	for rows.Next() {
+		var g StateMatchGroup
+		if err := rows.Scan(&g.RawValue, &g.StateID, &g.State, &g.Distance, &g.Rows, pq.Array(&g.Sample)); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// ResolveState finds a state by ID or exact name
+func ResolveState(ctx context.Context, db *sql.DB, value string) (int, string, error) {
+	var id int
+	var name string
+	err := db.QueryRowContext(ctx, `
+        SELECT st_id, st_name FROM state
+        WHERE st_id::text = $1 OR UPPER(TRIM(st_name)) = UPPER(TRIM($1))
+        ORDER BY st_id::text = $1 DESC
+        LIMIT 1`, value).Scan(&id, &name)
+	if err == sql.ErrNoRows {
+		return 0, "", fmt.Errorf("unknown state %q", value)
+	}
+	return id, name, err
+}
+
+// ReviewStateMatch settles the pending matches of raw: stateID confirms
+// the chosen state or corrects it. Candidates still holding the wrongly
+// chosen state get the corrected one, and an LGA outside it is cleared;
+// later imports match raw exactly. It returns the number of candidates
+// corrected and of LGAs cleared.
+func ReviewStateMatch(ctx context.Context, db *sql.DB, raw string, stateID int) (corrected, lgasCleared int64, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO state_value_mappings (raw_value, state_id) VALUES ($1, $2)
+        ON CONFLICT (raw_value) DO UPDATE SET state_id = EXCLUDED.state_id, created_at = NOW()`,
+		normalizeStateValue(raw), stateID); err != nil {
+		return 0, 0, fmt.Errorf("error saving state spelling: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+        UPDATE candidate c SET statecode = $2, updated_at = NOW()
+        FROM state_matches m
+        WHERE m.regnumber = c.regnumber AND m.raw_value = $1 AND m.reviewed_at IS NULL
+          AND m.state_id <> $2 AND c.statecode = m.state_id`, raw, stateID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error correcting candidates: %w", err)
+	}
+	corrected, _ = res.RowsAffected()
+
+	if corrected > 0 {
+		res, err = tx.ExecContext(ctx, `
+            UPDATE candidate c SET lg_id = NULL, updated_at = NOW()
+            FROM state_matches m, lga l
+            WHERE m.regnumber = c.regnumber AND m.raw_value = $1 AND m.reviewed_at IS NULL
+              AND m.state_id <> $2 AND c.statecode = $2
+              AND l.lg_id = c.lg_id AND l.lg_st_id <> $2`, raw, stateID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error clearing LGAs outside the corrected state: %w", err)
+		}
+		lgasCleared, _ = res.RowsAffected()
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE state_matches SET reviewed_at = NOW(),
+            corrected_state_id = CASE WHEN state_id <> $2 THEN $2 END
+        WHERE raw_value = $1 AND reviewed_at IS NULL`, raw, stateID); err != nil {
+		return 0, 0, err
+	}
+	return corrected, lgasCleared, tx.Commit()
+}
@@ -0,0 +1,158 @@
+package importer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransformNames lists the transforms a mapping profile may name. Several
+// can be chained with "|", e.g. "null_if:N/A|upper"; each receives the
+// previous one's output.
+var TransformNames = []string{
+	"upper", "lower", "title", "int", "bool",
+	"null_if:<value>", "default:<value>", "prefix:<text>", "strip_prefix:<text>",
+	"replace:<old>=<new>", "date:<Go layout>",
+}
+
+// ParseTransform compiles a transform spec into a column TransformFunc. An
+// empty spec returns nil.
+func ParseTransform(spec string) (func(string) (interface{}, error), error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	var steps []func(string) (interface{}, error)
+	for _, part := range strings.Split(spec, "|") {
+		step, err := parseTransformStep(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return func(value string) (interface{}, error) {
+		var out interface{} = value
+		for _, step := range steps {
+			s, ok := out.(string)
+			if !ok {
+				if out == nil {
+					return nil, nil
+				}
+				s = fmt.Sprint(out)
+			}
+			var err error
+			if out, err = step(s); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}, nil
+}
+
+func parseTransformStep(step string) (func(string) (interface{}, error), error) {
+	name, arg, hasArg := strings.Cut(step, ":")
+	needArg := func() error {
+		if !hasArg {
+			return fmt.Errorf("transform %s needs an argument, e.g. %s:value", name, name)
+		}
+		return nil
+	}
+
+	switch strings.ToLower(name) {
+	case "upper":
+		return func(v string) (interface{}, error) { return strings.ToUpper(v), nil }, nil
+	case "lower":
+		return func(v string) (interface{}, error) { return strings.ToLower(v), nil }, nil
+	case "title":
+		return func(v string) (interface{}, error) {
+			words := strings.Fields(strings.ToLower(v))
+			for i, w := range words {
+				words[i] = strings.ToUpper(w[:1]) + w[1:]
+			}
+			return strings.Join(words, " "), nil
+		}, nil
+	case "int":
+		return func(v string) (interface{}, error) {
+			if v == "" {
+				return nil, nil
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("not a number: %q", v)
+			}
+			return int(f), nil
+		}, nil
+	case "bool":
+		return func(v string) (interface{}, error) {
+			switch strings.ToUpper(v) {
+			case "":
+				return nil, nil
+			case "1", "Y", "YES", "T", "TRUE":
+				return true, nil
+			case "0", "N", "NO", "F", "FALSE":
+				return false, nil
+			}
+			return nil, fmt.Errorf("not a yes/no value: %q", v)
+		}, nil
+	case "null_if":
+		if err := needArg(); err != nil {
+			return nil, err
+		}
+		return func(v string) (interface{}, error) {
+			if strings.EqualFold(v, arg) {
+				return "", nil
+			}
+			return v, nil
+		}, nil
+	case "default":
+		if err := needArg(); err != nil {
+			return nil, err
+		}
+		return func(v string) (interface{}, error) {
+			if v == "" {
+				return arg, nil
+			}
+			return v, nil
+		}, nil
+	case "prefix":
+		if err := needArg(); err != nil {
+			return nil, err
+		}
+		return func(v string) (interface{}, error) {
+			if v == "" || strings.HasPrefix(v, arg) {
+				return v, nil
+			}
+			return arg + v, nil
+		}, nil
+	case "strip_prefix":
+		if err := needArg(); err != nil {
+			return nil, err
+		}
+		return func(v string) (interface{}, error) { return strings.TrimPrefix(v, arg), nil }, nil
+	case "replace":
+		if err := needArg(); err != nil {
+			return nil, err
+		}
+		old, replacement, ok := strings.Cut(arg, "=")
+		if !ok || old == "" {
+			return nil, fmt.Errorf("transform replace needs old=new, got %q", arg)
+		}
+		return func(v string) (interface{}, error) { return strings.ReplaceAll(v, old, replacement), nil }, nil
+	case "date":
+		if err := needArg(); err != nil {
+			return nil, err
+		}
+		return func(v string) (interface{}, error) {
+			if v == "" {
+				return nil, nil
+			}
+			t, err := time.Parse(arg, v)
+			if err != nil {
+				return nil, fmt.Errorf("not a date in layout %s: %q", arg, v)
+			}
+			return t, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown transform %q (known: %s)", name, strings.Join(TransformNames, ", "))
+}
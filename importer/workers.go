@@ -0,0 +1,137 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// importBatch is a run of source rows handed to a worker
+type importBatch struct {
+	records [][]string
+	lines   []int // line in the source file each record starts on
+	start   int   // index of the first row in the source
+	offset  int64 // bytes of the source read once the batch was complete
+}
+
+// batchResult is a worker's outcome for one batch. Err is set when the
+// batch could not be committed, which stops the import.
+type batchResult struct {
+	ImportResult
+	rows   int
+	offset int64
+	Err    error
+}
+
+// runWorkers starts n workers, each holding its own connection, that import
+// batches until the channel is closed. Results are delivered on the returned
+// channel, which is closed once every worker has finished.
+func (di *DataImporter) runWorkers(ctx context.Context, n int, headers []string, batches <-chan importBatch) <-chan batchResult {
+	results := make(chan batchResult, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			di.worker(ctx, headers, batches, results)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// worker imports each batch in its own transaction on a dedicated
+// connection. Once the context is cancelled remaining batches are drained
+// unprocessed so the reader never blocks.
+func (di *DataImporter) worker(ctx context.Context, headers []string, batches <-chan importBatch, results chan<- batchResult) {
+	conn, err := di.db.Conn(ctx)
+	if err != nil {
+		err = fmt.Errorf("error acquiring connection: %v", err)
+	}
+	var stmt *sql.Stmt
+	if err == nil {
+		defer conn.Close()
+		if stmt, err = di.prepareInsertStatement(ctx, conn); err == nil {
+			defer stmt.Close()
+		}
+	}
+
+	for batch := range batches {
+		result := batchResult{rows: len(batch.records), offset: batch.offset}
+		switch {
+		case err != nil:
+			result.Err = err
+		case ctx.Err() != nil:
+			result.Err = fmt.Errorf("import cancelled: %v", ctx.Err())
+		default:
+			result.ImportResult, result.Err = di.importBatch(ctx, conn, stmt, batch, headers)
+		}
+		results <- result
+	}
+}
+
+// importBatch inserts a batch in a single transaction on conn
+func (di *DataImporter) importBatch(ctx context.Context, conn *sql.Conn, stmt *sql.Stmt, batch importBatch, headers []string) (ImportResult, error) {
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("error starting batch transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	txStmt := tx.StmtContext(ctx, stmt)
+	defer txStmt.Close()
+
+	var result ImportResult
+	if di.config.Strategy == StrategyCopy {
+		result = di.copyBatch(ctx, conn, tx, txStmt, batch, headers)
+	} else {
+		result = di.processBatch(ctx, tx, batch, headers, txStmt)
+	}
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("import cancelled: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("error committing batch at row %d: %v", batch.start, err)
+	}
+	return result, nil
+}
+
+// copyBatch writes a batch with COPY. If the batch as a whole is rejected,
+// e.g. by one malformed value, it is retried row by row, each row in its
+// own savepoint, so that only the offending rows fail.
+func (di *DataImporter) copyBatch(ctx context.Context, conn *sql.Conn, tx *sql.Tx, stmt *sql.Stmt, batch importBatch, headers []string) ImportResult {
+	rows, result := di.transformBatch(ctx, batch, headers)
+	if len(rows) == 0 {
+		return result
+	}
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT copy_batch"); err != nil {
+		result.FailedCount += len(rows)
+		result.Errors = append(result.Errors, err)
+		return result
+	}
+	err := di.copyRows(ctx, conn, tx, rows)
+	if err == nil {
+		result.SuccessCount += len(rows)
+		if di.config.IsAdmission {
+			for _, row := range rows {
+				di.noteAdmission(row.values)
+			}
+		}
+		return result
+	}
+
+	log.Printf("COPY of batch at row %d failed, inserting row by row: %v", batch.start, err)
+	if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT copy_batch"); err != nil {
+		result.FailedCount += len(rows)
+		result.Errors = append(result.Errors, err)
+		return result
+	}
+	di.insertRows(ctx, tx, rows, headers, stmt, &result)
+	return result
+}
@@ -0,0 +1,114 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// IsExcel reports whether path names an Excel workbook the importer reads
+func IsExcel(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".xlsx")
+}
+
+// SheetNames lists the worksheets of an Excel workbook in tab order
+func SheetNames(path string) ([]string, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening workbook: %w", err)
+	}
+	defer f.Close()
+	return f.GetSheetList(), nil
+}
+
+// excelSource streams a worksheet as CSV into a pipe
+type excelSource struct {
+	file *excelize.File
+	pipe *io.PipeReader
+}
+
+func (s *excelSource) Close() error {
+	s.pipe.Close()
+	return s.file.Close()
+}
+
+// OpenExcel returns a CSV reader over a worksheet of an Excel workbook, the
+// first sheet when sheet is blank, so workbooks go through the same header
+// mapping and import as CSV files. Cells are read as displayed in Excel and
+// fully empty rows are skipped. Rows are streamed rather than loaded at
+// once; close the returned Closer when done.
+func OpenExcel(path, sheet string) (*csv.Reader, io.Closer, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening workbook: %w", err)
+	}
+	if sheet == "" {
+		sheet = f.GetSheetName(0)
+	}
+	if index, err := f.GetSheetIndex(sheet); err != nil || index < 0 {
+		f.Close()
+		return nil, nil, fmt.Errorf("workbook has no sheet %q", sheet)
+	}
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("error reading sheet %q: %w", sheet, err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer rows.Close()
+		w := csv.NewWriter(pw)
+		width := 0
+		for rows.Next() {
+			cells, err := rows.Columns()
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("error reading sheet %q: %w", sheet, err))
+				return
+			}
+			// Blank rows become empty lines, which the CSV reader skips
+			// while still counting, so line numbers match Excel's rows
+			if isBlankRow(cells) {
+				w.Flush()
+				if _, err := io.WriteString(pw, "\n"); err != nil {
+					return
+				}
+				continue
+			}
+			// Excel drops trailing empty cells; pad rows to the header's width
+			if width == 0 {
+				width = len(cells)
+			}
+			for len(cells) < width {
+				cells = append(cells, "")
+			}
+			if err := w.Write(cells); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if err := rows.Error(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		w.Flush()
+		pw.CloseWithError(w.Error())
+	}()
+
+	reader := csv.NewReader(pr)
+	reader.FieldsPerRecord = -1
+	return reader, &excelSource{file: f, pipe: pr}, nil
+}
+
+func isBlankRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
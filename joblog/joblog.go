@@ -0,0 +1,45 @@
+// Package joblog records the steps of background and generated-file jobs
+// (exports, deliveries, scheduled reports) in the job_log table.
+package joblog
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Status values
+const (
+	StatusStarted   = "started"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusRetrying  = "retrying"
+)
+
+// Entry is a single job log record
+type Entry struct {
+	Job      string
+	Step     string
+	Status   string
+	Detail   string
+	Attempts int
+}
+
+// Log writes entries to the job_log table
+type Log struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Log {
+	return &Log{db: db}
+}
+
+// Record appends an entry to the log
+func (l *Log) Record(ctx context.Context, e Entry) error {
+	if e.Attempts == 0 {
+		e.Attempts = 1
+	}
+	_, err := l.db.ExecContext(ctx, `
+        INSERT INTO job_log (job, step, status, detail, attempts)
+        VALUES ($1, $2, $3, $4, $5)`, e.Job, e.Step, e.Status, e.Detail, e.Attempts)
+	return err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/joblog"
+	"github.com/nonsonwune/spk2_db/scheduler"
+)
+
+// newScheduler registers the scheduled jobs enabled in the environment.
+// SCHEDULE_REFRESH_STATS (e.g. 24h) refreshes the statistics tables and
+// views; SCHEDULE_REPORTS names a file of saved reports to run on cron
+// schedules (see reportSchedule); SCHEDULE_SLOW_QUERY_REPORT sends the slow
+// query report (see slowQueryReport); SCHEDULE_CATCH_UP=true runs a job at startup if a run was missed
+// while the process was down.
+func newScheduler(db *sql.DB) (*scheduler.Scheduler, error) {
+	s := scheduler.New(db, joblog.New(db))
+	catchUp, err := scheduleCatchUp()
+	if err != nil {
+		return nil, err
+	}
+	if every, err := scheduleInterval("SCHEDULE_REFRESH_STATS"); err != nil {
+		return nil, err
+	} else if every > 0 {
+		err := s.Add(scheduler.Job{
+			Name:     "refresh-stats",
+			Schedule: scheduler.Every(every),
+			CatchUp:  catchUp,
+			Run: func(ctx context.Context) error {
+				return statsRefresher.Refresh(ctx, 0)
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := addScheduledReports(s, db, catchUp); err != nil {
+		return nil, err
+	}
+	if err := addSlowQueryReport(s, db, catchUp); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func scheduleInterval(key string) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf("%s %q is not a duration of at least 1m", key, raw)
+	}
+	return d, nil
+}
+
+func scheduleCatchUp() (bool, error) {
+	raw := os.Getenv("SCHEDULE_CATCH_UP")
+	if raw == "" {
+		return false, nil
+	}
+	catchUp, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("SCHEDULE_CATCH_UP %q is not true or false", raw)
+	}
+	return catchUp, nil
+}
+
+// runJobs lists, runs and shows the history of the scheduled jobs. The API
+// server runs them on schedule; "jobs start" runs them without the server.
+func runJobs(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("jobs")
+	job := fs.String("job", "", "with history, show only this job")
+	limit := fs.Int("limit", 20, "with history, number of entries to show")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError{errors.New("jobs needs list, history, run NAME or start")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	s, err := newScheduler(db)
+	if err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "list":
+		states, err := scheduler.States(ctx, db)
+		if err != nil {
+			return err
+		}
+		var rows [][]interface{}
+		for _, j := range s.Jobs() {
+			st := states[j.Name]
+			last, next, status := "never", j.Schedule.Next(time.Now()), st.LastStatus
+			if st.LastStarted.Valid {
+				last = st.LastStarted.Time.Format("2006-01-02 15:04:05")
+				next = j.Schedule.Next(st.LastStarted.Time)
+			}
+			if st.RunningSince.Valid {
+				status = "running on " + st.RunningOwner
+			} else if st.LastError != "" {
+				status += ": " + st.LastError
+			}
+			rows = append(rows, []interface{}{j.Name, j.Schedule.String(), j.CatchUp, last, status, next.Format("2006-01-02 15:04:05")})
+		}
+		if len(rows) == 0 {
+			fmt.Fprintln(os.Stderr, "No scheduled jobs; set SCHEDULE_REFRESH_STATS or SCHEDULE_REPORTS to enable one")
+			return nil
+		}
+		return writeResult("jobs", []string{"job", "schedule", "catch_up", "last_run", "last_status", "next_due"}, rows, *format, *output)
+	case "history":
+		if *limit <= 0 {
+			return usageError{errors.New("-limit must be positive")}
+		}
+		runs, err := scheduler.History(ctx, db, *job, *limit)
+		if err != nil {
+			return err
+		}
+		rows := make([][]interface{}, len(runs))
+		for i, r := range runs {
+			rows[i] = []interface{}{r.At.Format("2006-01-02 15:04:05"), r.Job, r.Status, r.Detail}
+		}
+		return writeResult("job-history", []string{"at", "job", "status", "detail"}, rows, *format, *output)
+	case "run":
+		if fs.NArg() != 2 {
+			return usageError{errors.New("jobs run needs a job name")}
+		}
+		if err := s.RunNow(ctx, fs.Arg(1)); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Job %s finished\n", fs.Arg(1))
+		return nil
+	case "start":
+		if len(s.Jobs()) == 0 {
+			return errors.New("no scheduled jobs; set SCHEDULE_REFRESH_STATS or SCHEDULE_REPORTS to enable one")
+		}
+		s.Start(ctx)
+		fmt.Fprintf(os.Stderr, "Running %d scheduled jobs; press Ctrl+C to stop\n", len(s.Jobs()))
+		s.Wait()
+		return nil
+	default:
+		return usageError{fmt.Errorf("unknown jobs action %q", fs.Arg(0))}
+	}
+}
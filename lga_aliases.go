@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/importer"
+	"github.com/olekukonko/tablewriter"
+)
+
+// handleLGAAliases lists and adds the LGA name variants the importer accepts
+func handleLGAAliases(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nLGA Aliases")
+	fmt.Println("1. List aliases")
+	fmt.Println("2. Add alias")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		rows, err := db.QueryContext(ctx, `
+            SELECT a.alias, COALESCE(s.st_name, 'ANY'), l.lg_id, l.lg_name
+            FROM lga_aliases a
+            JOIN lga l ON l.lg_id = a.lg_id
+            LEFT JOIN state s ON s.st_id = NULLIF(a.state_id, 0)
+            ORDER BY a.alias`)
+		if err != nil {
+			return fmt.Errorf("error listing aliases: %w", err)
+		}
+		defer rows.Close()
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Alias", "State", "LGA ID", "LGA"})
+		for rows.Next() {
+			var alias, state, name string
+			var id int
+			if err := rows.Scan(&alias, &state, &id, &name); err != nil {
+				return err
+			}
+			table.Append([]string{alias, state, fmt.Sprintf("%d", id), name})
+		}
+		table.Render()
+		return rows.Err()
+	case "2":
+		fmt.Print("Alias as it appears in import files: ")
+		alias := readString()
+		lgID, err := readLGA(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Print("Only apply within the LGA's state? (y/n): ")
+		stateID := 0
+		if readString() == "y" {
+			if err := db.QueryRowContext(ctx, "SELECT lg_st_id FROM lga WHERE lg_id = $1", lgID).Scan(&stateID); err != nil {
+				return fmt.Errorf("unknown LGA %d: %w", lgID, err)
+			}
+		}
+		if err := importer.AddLGAAlias(ctx, db, alias, stateID, lgID); err != nil {
+			return fmt.Errorf("error adding alias: %w", err)
+		}
+		color.Green("Alias %q now resolves to LGA %d", alias, lgID)
+	}
+	return nil
+}
+
+// readLGA asks for the state and then the LGA within it, both with
+// completion, and returns the LGA's ID
+func readLGA(ctx context.Context, db *sql.DB) (int, error) {
+	state, err := readEntity(ctx, db, "State of the LGA: ", entityState)
+	if err != nil {
+		return 0, err
+	}
+	var stateID int
+	err = db.QueryRowContext(ctx, "SELECT st_id FROM state WHERE UPPER(st_name) = UPPER($1)", state).Scan(&stateID)
+	if err != nil {
+		return 0, fmt.Errorf("unknown state %q: %w", state, err)
+	}
+
+	name, err := readEntity(ctx, db, "LGA it refers to: ",
+		fmt.Sprintf("SELECT lg_name FROM lga WHERE lg_st_id = %d ORDER BY 1", stateID))
+	if err != nil {
+		return 0, err
+	}
+	var lgID int
+	err = db.QueryRowContext(ctx,
+		"SELECT lg_id FROM lga WHERE lg_st_id = $1 AND UPPER(lg_name) = UPPER($2)", stateID, name).Scan(&lgID)
+	if err != nil {
+		return 0, fmt.Errorf("no LGA %q in %s: %w", name, state, err)
+	}
+	return lgID, nil
+}
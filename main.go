@@ -6,6 +6,7 @@ import (
     "database/sql"
     "encoding/csv"
     "fmt"
+    "io"
     "log"
     "os"
     "os/signal"
@@ -15,79 +16,96 @@ import (
     "time"
 
     "github.com/fatih/color"
-    "github.com/joho/godotenv"
-    _ "github.com/lib/pq"
+    "github.com/nonsonwune/spk2_db/api"
+    "github.com/nonsonwune/spk2_db/db"
     "github.com/nonsonwune/spk2_db/importer"
+    "github.com/nonsonwune/spk2_db/joblog"
     "github.com/nonsonwune/spk2_db/migrations"
+    "github.com/nonsonwune/spk2_db/models"
     "github.com/nonsonwune/spk2_db/nlquery"
+    "github.com/nonsonwune/spk2_db/notes"
+    "github.com/nonsonwune/spk2_db/privacy"
+    "github.com/nonsonwune/spk2_db/quality"
+    "github.com/nonsonwune/spk2_db/reports"
+    "github.com/nonsonwune/spk2_db/slowlog"
+    "github.com/nonsonwune/spk2_db/stats"
     "github.com/olekukonko/tablewriter"
 )
 
-// Config holds application configuration
-type Config struct {
-    DBHost     string
-    DBPort     string
-    DBUser     string
-    DBPassword string
-    DBName     string
+// DSN returns the PostgreSQL connection string for the primary database
+func (c *Config) DSN() string {
+    return c.DB.DSN()
 }
 
-func loadConfig() (*Config, error) {
-    if err := godotenv.Load(); err != nil {
-        return nil, fmt.Errorf("error loading .env file: %w", err)
-    }
-
-    return &Config{
-        DBHost:     os.Getenv("DB_HOST"),
-        DBPort:     os.Getenv("DB_PORT"),
-        DBUser:     os.Getenv("DB_USER"),
-        DBPassword: os.Getenv("DB_PASSWORD"),
-        DBName:     os.Getenv("DB_NAME"),
-    }, nil
-}
+// analyticsDB runs natural language queries; it is a read-only replica
+// when DB_REPLICA_HOST is set and the primary otherwise
+var analyticsDB *sql.DB
 
-func connectDB(cfg *Config) (*sql.DB, error) {
-    psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-        cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
-
-    db, err := sql.Open("postgres", psqlInfo)
+func main() {
+    argv, waitForDB, err := globalFlags(os.Args[1:])
     if err != nil {
-        return nil, fmt.Errorf("error opening database: %w", err)
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(2)
     }
-
-    // Set connection pool settings
-    db.SetMaxOpenConns(25)
-    db.SetMaxIdleConns(5)
-    db.SetConnMaxLifetime(5 * time.Minute)
-
-    // Test connection
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-
-    if err := db.PingContext(ctx); err != nil {
-        return nil, fmt.Errorf("error connecting to database: %w", err)
+    name, args := commandLine(argv)
+    cmd := findCommand(name)
+    if cmd == nil {
+        fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+        printUsage(os.Stderr)
+        os.Exit(2)
+    }
+    if cmd.name == "help" {
+        printUsage(os.Stdout)
+        return
+    }
+    if offlineCommands[cmd.name] {
+        if err := cmd.run(context.Background(), nil, nil, args); err != nil {
+            os.Exit(commandFailed(cmd, err))
+        }
+        return
     }
 
-    return db, nil
-}
-
-func main() {
     // Load configuration
-    cfg, err := loadConfig()
+    cfg, err := loadConfig(cmd.name)
     if err != nil {
         log.Fatalf("Failed to load configuration: %v", err)
     }
+    tableNumbers = cfg.Numbers
+    consoleRender = cfg.Render
+    consoleLayout = cfg.Layout
+    if waitForDB >= 0 {
+        cfg.DB.WaitTimeout = waitForDB
+    }
+    var slow *slowlog.Recorder
+    if cfg.DB.SlowQueryThreshold > 0 {
+        slow = slowlog.NewRecorder(cfg.DB.SlowQueryThreshold, cmd.name)
+        slow.SetRecordText(cfg.DB.SlowQueryParams)
+        cfg.DB.Tracer = slow
+    }
 
     // Connect to database
-    db, err := connectDB(cfg)
+    pools, err := db.Open(context.Background(), cfg.DB)
     if err != nil {
         log.Fatalf("Failed to connect to database: %v", err)
     }
-    defer db.Close()
-
-    // Initialize database schema
-    if err := migrations.InitSchema(db); err != nil {
-        log.Printf("Warning: Error initializing schema: %v", err)
+    defer pools.Close()
+    if slow != nil {
+        // Before the pools close, so the queued slow queries are written
+        slow.Start(pools.Primary)
+        defer slow.Close()
+    }
+    db, analytics := pools.Primary, pools.Analytics
+    analyticsDB = analytics
+    defer currentSession.Close(context.Background(), db)
+
+    // Initialize database schema; the migrate command manages it itself
+    if cmd.name != "migrate" {
+        if err := migrations.InitSchema(context.Background(), db); err != nil {
+            log.Printf("Warning: Error initializing schema: %v", err)
+        }
+        if err := dropStaleSessionTables(context.Background(), db); err != nil {
+            log.Printf("Warning: Error dropping stale session tables: %v", err)
+        }
     }
 
     // Setup signal handling for graceful shutdown
@@ -103,8 +121,125 @@ func main() {
         cancel()
     }()
 
-    // Start menu loop
+    if cfg.Secrets != nil {
+        go cfg.Secrets.Watch(ctx)
+    }
+
+    jobs := joblog.New(db)
+    statsRefresher = stats.NewRefresher(db, jobs)
+
+    err = cmd.run(ctx, db, cfg, args)
+
+    // Let a post-import statistics refresh finish rather than abandon it
+    statsRefresher.Wait()
+    if err != nil {
+        if code := commandFailed(cmd, err); code != 0 {
+            os.Exit(code)
+        }
+    }
+}
+
+// runInteractive runs the numbered menu until the user exits
+func runInteractive(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+    // The menu used to be the only mode, with --serve selecting the API
+    fs := newFlagSet("interactive")
+    serve := fs.Bool("serve", false, "run the HTTP API server instead (same as the serve command)")
+    addr := fs.String("addr", "", "with --serve, address to listen on")
+    public := fs.Bool("public", false, "with --serve, expose only the public statistics")
+    if err := parseFlags(fs, args); err != nil {
+        return err
+    }
+    if *serve {
+        return serveAPI(ctx, db, cfg, *addr, *public, false)
+    }
+
     menuLoop(ctx, db)
+    return nil
+}
+
+// runServe runs the HTTP API server until interrupted
+func runServe(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+    fs := newFlagSet("serve")
+    addr := fs.String("addr", "", "address for the API server to listen on (default $API_ADDR or localhost:8080)")
+    public := fs.Bool("public", false, "expose only the k-anonymised public statistics ($PUBLIC_MIN_GROUP_SIZE, $PRIVACY_MODE)")
+    verify := fs.Bool("verify", false, "expose only applicant verification for institutions ($VERIFY_RATE_LIMIT)")
+    if err := parseFlags(fs, args); err != nil {
+        return err
+    }
+    if *public && *verify {
+        return usageError{fmt.Errorf("-public and -verify are separate servers; choose one")}
+    }
+    return serveAPI(ctx, db, cfg, *addr, *public, *verify)
+}
+
+func serveAPI(ctx context.Context, db *sql.DB, cfg *Config, addr string, public, verify bool) error {
+    if addr == "" {
+        addr = envOrDefault("API_ADDR", "localhost:8080")
+    }
+    server := api.NewServer(db)
+    server.SetAnalyticsDB(analyticsDB)
+    if public {
+        guard, err := privacy.FromEnv()
+        if err != nil {
+            return err
+        }
+        server = api.NewPublicServer(db, guard)
+    } else if verify {
+        server = api.NewVerifyServer(db)
+        // Tokens are optional: without one a client is rate limited by
+        // its address
+        tokens, err := api.ParseTokens(os.Getenv("API_TOKENS"))
+        if err != nil {
+            return fmt.Errorf("invalid API_TOKENS: %w", err)
+        }
+        server.SetTokens(tokens)
+        if raw := os.Getenv("VERIFY_RATE_LIMIT"); raw != "" {
+            limit, err := strconv.Atoi(raw)
+            if err != nil || limit <= 0 {
+                return fmt.Errorf("invalid VERIFY_RATE_LIMIT %q", raw)
+            }
+            server.SetVerifyRateLimit(limit)
+        }
+    } else {
+        // The full API serves candidate records, so off this machine it
+        // needs tokens
+        tokens, err := api.ParseTokens(os.Getenv("API_TOKENS"))
+        if err != nil {
+            return fmt.Errorf("invalid API_TOKENS: %w", err)
+        }
+        if len(tokens) == 0 && !api.IsLoopback(addr) {
+            return fmt.Errorf("set API_TOKENS to serve the API on %s, or listen on localhost", addr)
+        }
+        server.SetTokens(tokens)
+    }
+    if raw := os.Getenv("NL_SESSION_TTL"); raw != "" {
+        ttl, err := time.ParseDuration(raw)
+        if err != nil || ttl <= 0 {
+            return fmt.Errorf("invalid NL_SESSION_TTL %q", raw)
+        }
+        server.SetNLSessionTTL(ttl)
+    }
+    if err := server.WatchInvalidations(ctx, cfg.DSN()); err != nil {
+        log.Printf("Warning: cache invalidation unavailable: %v", err)
+    }
+    jobs, err := newScheduler(db)
+    if err != nil {
+        return err
+    }
+    jobs.Start(ctx)
+    defer jobs.Wait()
+    log.Printf("API server listening on %s", addr)
+    return server.ListenAndServe(ctx, addr)
+}
+
+// statsRefresher analyzes tables and refreshes views in the background after imports
+var statsRefresher *stats.Refresher
+
+func envOrDefault(key, def string) string {
+    if v := os.Getenv(key); v != "" {
+        return v
+    }
+    return def
 }
 
 func menuLoop(ctx context.Context, db *sql.DB) {
@@ -138,6 +273,10 @@ func handleMenuChoice(ctx context.Context, db *sql.DB, choice string) error {
         return handleCourseImport(ctx, db)
     case "3":
         return handleAnalyzeFailedImports(ctx, db)
+    case "24":
+        return handleCourseNameEnrichment(ctx, db)
+    case "25":
+        return handleCandidateExport(ctx, db)
     case "4":
         return displayTopPerformers(ctx, db)
     case "5":
@@ -174,6 +313,68 @@ func handleMenuChoice(ctx context.Context, db *sql.DB, choice string) error {
         return displayCourseCompetitiveness(ctx, db)
     case "21":
         return handleNaturalLanguageQuery(db)
+    case "22":
+        return handleSessionFilter(ctx, db)
+    case "23":
+        return handleSQLConsole(ctx, db)
+    case "26":
+        return handleAggregateFormulas(ctx, db)
+    case "27":
+        return handleScoreEquating(ctx, db)
+    case "28":
+        return handleGenderAudit(ctx, db)
+    case "29":
+        return handleLGAAliases(ctx, db)
+    case "30":
+        return handleGeocoding(ctx, db)
+    case "31":
+        return handleSpatialAnalysis(ctx, db)
+    case "32":
+        return handleSyntheticData(ctx, db)
+    case "33":
+        return handleAdmissionReconciliation(ctx, db)
+    case "34":
+        return handleCourseRecommender(ctx, db)
+    case "35":
+        return handleCutoffSimulator(ctx, db)
+    case "36":
+        return handleQuotaAllocation(ctx, db)
+    case "37":
+        return handleScoreImport(ctx, db)
+    case "38":
+        return handleResultOutput()
+    case "39":
+        return handleViewCandidate(ctx, db)
+    case "40":
+        return handleDrillDown(ctx, db)
+    case "41":
+        return handleNLQueryLog(ctx, db)
+    case "42":
+        return handleTags(ctx, db)
+    case "43":
+        return handleNotes(ctx, db)
+    case "44":
+        return handleCAPSExport(ctx, db)
+    case "45":
+        return handleSavedReports(ctx, db)
+    case "46":
+        return handleDataQuality(ctx, db)
+    case "47":
+        return handleRepeatTakers(ctx, db)
+    case "48":
+        return handleAdmissionLetters(ctx, db)
+    case "49":
+        return handleExplain(ctx, db)
+    case "50":
+        return handleCandidateStanding(ctx, db)
+    case "51":
+        return handleSlowQueries(ctx, db)
+    case "52":
+        return handleResultSlip(ctx, db)
+    case "c":
+        return handleCopy(false)
+    case "cs":
+        return handleCopy(true)
     case "0":
         return errExit
     default:
@@ -183,10 +384,32 @@ func handleMenuChoice(ctx context.Context, db *sql.DB, choice string) error {
 
 func displayMenu() {
     color.Cyan("\nJAMB Database Analysis System")
+    if currentSession.table != "" {
+        color.Yellow("Session filter: %s", currentSession.Describe())
+    }
+    if publicOutput != nil {
+        color.Yellow("Public output mode: groups under %d candidates withheld", publicOutput.K)
+    }
+    if savedResults.format != "" {
+        color.Yellow("Saving results as %s in %s", savedResults.format, savedResults.dir)
+    }
     fmt.Println("\nData Management:")
     fmt.Println("1. Import Candidate Data")
     fmt.Println("2. Import Course Data")
-    fmt.Println("3. Analyze Failed Imports")
+    fmt.Println("3. Failed Imports")
+    fmt.Println("24. Course Name Enrichment")
+    fmt.Println("25. Export Candidates")
+    fmt.Println("28. Gender Value Audit")
+    fmt.Println("29. LGA Aliases")
+    fmt.Println("30. Geocoding")
+    fmt.Println("32. Synthetic Data")
+    fmt.Println("33. Admission Reconciliation")
+    fmt.Println("44. CAPS Admission Upload File")
+    fmt.Println("48. Admission Letters")
+    fmt.Println("37. Import Subject Scores")
+    fmt.Println("39. View Candidate")
+    fmt.Println("\nData Quality:")
+    fmt.Println("46. Data Quality Checks (orphaned codes, NULL rates, duplicate regnumbers, aggregates)")
     fmt.Println("\nData Analysis:")
     fmt.Println("4. Top Performers")
     fmt.Println("5. Gender Statistics")
@@ -199,6 +422,9 @@ func displayMenu() {
     fmt.Println("12. Geographic Analysis")
     fmt.Println("13. Year-over-Year Comparison")
     fmt.Println("14. Admission Trends")
+    fmt.Println("47. Repeat Candidates")
+    fmt.Println("50. Candidate Standing (percentile and z-score)")
+    fmt.Println("52. Result Slip (text or PDF)")
     fmt.Println("\nAdvanced Analysis:")
     fmt.Println("15. Import Candidates")
     fmt.Println("16. Performance Metrics")
@@ -206,68 +432,32 @@ func displayMenu() {
     fmt.Println("18. Subject Correlation")
     fmt.Println("19. Regional Performance")
     fmt.Println("20. Course Competitiveness")
+    fmt.Println("26. Aggregate Formulas")
+    fmt.Println("27. Score Equating")
+    fmt.Println("31. Spatial Analysis")
+    fmt.Println("34. Course Recommender")
+    fmt.Println("35. What-if Cutoff Simulator")
+    fmt.Println("36. Quota Allocation")
+    fmt.Println("40. Drill-down Analysis")
+    fmt.Println("45. Saved Reports")
     fmt.Println("\nNatural Language Query:")
     fmt.Println("21. Natural Language Query")
+    fmt.Println("41. Natural Language Query History")
+    fmt.Println("\nSession:")
+    fmt.Println("22. Session Filter")
+    fmt.Println("23. SQL Console")
+    fmt.Println("49. Explain a Report Query")
+    fmt.Println("51. Slow Queries")
+    fmt.Println("42. Candidate Tags")
+    fmt.Println("43. Notes on Institutions, Courses and Imports")
+    fmt.Println("38. Result Output (save results as CSV, JSON or Excel)")
+    fmt.Println("c. Copy the last table to the clipboard (cs copies its SQL)")
     fmt.Println("\n0. Exit")
     fmt.Print("\nEnter your choice: ")
 }
 
-func searchCandidates(ctx context.Context, db *sql.DB) error {
-    var searchTerm string
-    fmt.Print("Enter registration number or surname to search: ")
-    scanner := bufio.NewScanner(os.Stdin)
-    if scanner.Scan() {
-        searchTerm = scanner.Text()
-    }
-
-    query := `
-        SELECT regnumber, surname, firstname, gender, aggregate 
-        FROM candidate 
-        WHERE regnumber LIKE $1 OR LOWER(surname) LIKE LOWER($1)
-        LIMIT 10
-    `
-
-    rows, err := db.QueryContext(ctx, query, "%"+searchTerm+"%")
-    if err != nil {
-        log.Printf("Error searching candidates: %v", err)
-        return err
-    }
-    defer rows.Close()
-
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"Reg Number", "Surname", "First Name", "Gender", "Aggregate"})
-
-    for rows.Next() {
-        var reg, surname, firstname, gender sql.NullString
-        var aggregate sql.NullInt64
-
-        err := rows.Scan(&reg, &surname, &firstname, &gender, &aggregate)
-        if err != nil {
-            log.Printf("Error scanning row: %v", err)
-            continue
-        }
-
-        table.Append([]string{
-            getString(reg),
-            getString(surname),
-            getString(firstname),
-            getString(gender),
-            fmt.Sprintf("%d", getInt64(aggregate)),
-        })
-    }
-
-    table.Render()
-    return nil
-}
-
 func displayTopPerformers(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT regnumber, surname, firstname, aggregate 
-        FROM candidate 
-        WHERE aggregate IS NOT NULL 
-        ORDER BY aggregate DESC 
-        LIMIT 10
-    `
+    query := reports.TopPerformers.SQL(currentSession.CandidateSource())
 
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
@@ -277,7 +467,7 @@ func displayTopPerformers(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nTop 10 Performers")
-    table := tablewriter.NewWriter(os.Stdout)
+    table := newResultTable(reports.TopPerformers.Name)
     table.SetHeader([]string{"Rank", "Reg Number", "Name", "Aggregate"})
 
     rank := 1
@@ -305,14 +495,9 @@ func displayTopPerformers(ctx context.Context, db *sql.DB) error {
 }
 
 func displayGenderStats(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT gender, COUNT(*) as count 
-        FROM candidate 
-        WHERE gender IS NOT NULL 
-        GROUP BY gender
-    `
+    plan := planReport(ctx, db, reports.GenderStats)
 
-    rows, err := db.QueryContext(ctx, query)
+    rows, err := db.QueryContext(ctx, plan.SQL)
     if err != nil {
         log.Printf("Error getting gender stats: %v", err)
         return err
@@ -320,14 +505,15 @@ func displayGenderStats(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nGender Distribution")
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"Gender", "Count"})
+    table := newReportTable(reports.GenderStats.Name, []string{"Gender", "Count", "Share %"},
+        privacy.Spec{Size: "Count", Label: "Gender", Shares: []string{"Share %"}})
 
     for rows.Next() {
         var gender string
         var count int
+        var share float64
 
-        err := rows.Scan(&gender, &count)
+        err := rows.Scan(&gender, &count, &share)
         if err != nil {
             continue
         }
@@ -335,24 +521,19 @@ func displayGenderStats(ctx context.Context, db *sql.DB) error {
         table.Append([]string{
             gender,
             fmt.Sprintf("%d", count),
+            fmt.Sprintf("%.2f", share),
         })
     }
 
     table.Render()
+    color.White(plan.Footer())
     return nil
 }
 
 func displayStateDistribution(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT s.st_name, COUNT(c.*) as count 
-        FROM candidate c
-        JOIN state s ON c.statecode = s.st_id
-        GROUP BY s.st_name 
-        ORDER BY count DESC
-        LIMIT 10
-    `
+    plan := planReport(ctx, db, reports.StateDistribution)
 
-    rows, err := db.QueryContext(ctx, query)
+    rows, err := db.QueryContext(ctx, plan.SQL)
     if err != nil {
         log.Printf("Error getting state distribution: %v", err)
         return err
@@ -360,14 +541,15 @@ func displayStateDistribution(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nTop 10 States by Number of Candidates")
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"State", "Number of Candidates"})
+    table := newReportTable(reports.StateDistribution.Name, []string{"State", "Number of Candidates", "Share %", "Cumulative %"},
+        privacy.Spec{Size: "Number of Candidates", Label: "State", Shares: []string{"Share %"}, Running: []string{"Cumulative %"}})
 
     for rows.Next() {
         var state string
         var count int
+        var share, cumulative float64
 
-        err := rows.Scan(&state, &count)
+        err := rows.Scan(&state, &count, &share, &cumulative)
         if err != nil {
             continue
         }
@@ -375,37 +557,18 @@ func displayStateDistribution(ctx context.Context, db *sql.DB) error {
         table.Append([]string{
             state,
             fmt.Sprintf("%d", count),
+            fmt.Sprintf("%.2f", share),
+            fmt.Sprintf("%.2f", cumulative),
         })
     }
 
     table.Render()
-    return nil
+    color.White(plan.Footer())
+    return offerDrillDown(ctx, db, reports.EntityState, false)
 }
 
 func displaySubjectStats(ctx context.Context, db *sql.DB) error {
-    query := `
-        WITH RankedSubjects AS (
-            SELECT 
-                s.su_name,
-                cs.score,
-                COUNT(*) as count,
-                RANK() OVER (PARTITION BY cs.cand_reg_number ORDER BY cs.score DESC) as score_rank
-            FROM candidate c
-            JOIN candidate_scores cs ON c.regnumber = cs.cand_reg_number AND c.year = cs.year
-            JOIN subject s ON cs.subject_id = s.su_id
-            WHERE c.year = (SELECT MAX(year) FROM candidate)
-            GROUP BY s.su_name, cs.score, cs.cand_reg_number
-        )
-        SELECT 
-            su_name,
-            COUNT(*) as total_candidates,
-            ROUND(AVG(score)::numeric, 2) as avg_score
-        FROM RankedSubjects
-        WHERE score_rank = 1
-        GROUP BY su_name
-        ORDER BY total_candidates DESC
-        LIMIT 5;
-    `
+    query := reports.SubjectStats.SQL(currentSession.CandidateSource())
 
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
@@ -415,7 +578,7 @@ func displaySubjectStats(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nAverage Scores by Subject")
-    table := tablewriter.NewWriter(os.Stdout)
+    table := newResultTable(reports.SubjectStats.Name)
     table.SetHeader([]string{"Subject", "Total Candidates", "Average Score"})
 
     for rows.Next() {
@@ -440,23 +603,9 @@ func displaySubjectStats(ctx context.Context, db *sql.DB) error {
 }
 
 func displayAggregateDistribution(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT 
-            CASE 
-                WHEN aggregate >= 300 THEN '300+'
-                WHEN aggregate >= 250 THEN '250-299'
-                WHEN aggregate >= 200 THEN '200-249'
-                WHEN aggregate >= 150 THEN '150-199'
-                ELSE 'Below 150'
-            END as range,
-            COUNT(*) as count
-        FROM candidate
-        WHERE aggregate IS NOT NULL
-        GROUP BY range
-        ORDER BY range DESC
-    `
+    plan := planReport(ctx, db, reports.AggregateDistribution)
 
-    rows, err := db.QueryContext(ctx, query)
+    rows, err := db.QueryContext(ctx, plan.SQL)
     if err != nil {
         log.Printf("Error getting aggregate distribution: %v", err)
         return err
@@ -464,14 +613,15 @@ func displayAggregateDistribution(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nAggregate Score Distribution")
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"Score Range", "Number of Candidates"})
+    table := newReportTable(reports.AggregateDistribution.Name, []string{"Score Range", "Number of Candidates", "Share %", "Cumulative %"},
+        privacy.Spec{Size: "Number of Candidates", Label: "Score Range", Shares: []string{"Share %"}, Running: []string{"Cumulative %"}})
 
     for rows.Next() {
         var scoreRange string
         var count int
+        var share, cumulative float64
 
-        err := rows.Scan(&scoreRange, &count)
+        err := rows.Scan(&scoreRange, &count, &share, &cumulative)
         if err != nil {
             continue
         }
@@ -479,25 +629,18 @@ func displayAggregateDistribution(ctx context.Context, db *sql.DB) error {
         table.Append([]string{
             scoreRange,
             fmt.Sprintf("%d", count),
+            fmt.Sprintf("%.2f", share),
+            fmt.Sprintf("%.2f", cumulative),
         })
     }
 
     table.Render()
+    color.White(plan.Footer())
     return nil
 }
 
 func displayCourseAnalysis(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT c.course_name, COUNT(ca.regnumber) as applicants,
-               ROUND(AVG(ca.aggregate)::numeric, 2) as avg_score,
-               f.name as faculty
-        FROM course c
-        LEFT JOIN candidate ca ON c.course_code = ca.app_course1
-        LEFT JOIN faculty f ON c.faculty_id = f.id
-        GROUP BY c.course_name, f.name
-        ORDER BY applicants DESC
-        LIMIT 15
-    `
+    query := reports.CourseAnalysis.SQL(currentSession.CandidateSource())
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
         log.Printf("Error getting course analysis: %v", err)
@@ -506,8 +649,8 @@ func displayCourseAnalysis(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nTop 15 Courses by Number of Applicants")
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"Course", "Faculty", "Applicants", "Average Score"})
+    table := newReportTable(reports.CourseAnalysis.Name, []string{"Course", "Faculty", "Applicants", "Average Score"},
+        privacy.Spec{Size: "Applicants", Label: "Course", Means: []string{"Average Score"}})
 
     for rows.Next() {
         var course, faculty string
@@ -532,17 +675,7 @@ func displayCourseAnalysis(ctx context.Context, db *sql.DB) error {
 }
 
 func displayInstitutionStats(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT i.inname, COUNT(c.regnumber) as applicants,
-               ROUND(AVG(c.aggregate)::numeric, 2) as avg_score,
-               it.name as institution_type
-        FROM institution i
-        LEFT JOIN candidate c ON i.inid = c.inid
-        LEFT JOIN institution_type it ON i.institution_type_id = it.id
-        GROUP BY i.inname, it.name
-        ORDER BY applicants DESC
-        LIMIT 15
-    `
+    query := reports.InstitutionStats.SQL(currentSession.CandidateSource())
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
         log.Printf("Error getting institution stats: %v", err)
@@ -551,8 +684,8 @@ func displayInstitutionStats(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nTop 15 Institutions by Number of Applicants")
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"Institution", "Type", "Applicants", "Average Score"})
+    table := newReportTable(reports.InstitutionStats.Name, []string{"Institution", "Type", "Applicants", "Average Score"},
+        privacy.Spec{Size: "Applicants", Label: "Institution", Means: []string{"Average Score"}})
 
     for rows.Next() {
         var institution, instType string
@@ -573,19 +706,11 @@ func displayInstitutionStats(ctx context.Context, db *sql.DB) error {
     }
 
     table.Render()
-    return nil
+    return offerDrillDown(ctx, db, reports.EntityInstitution, false)
 }
 
 func displayFacultyPerformance(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT f.name, COUNT(c.regnumber) as applicants,
-               ROUND(AVG(c.aggregate)::numeric, 2) as avg_score
-        FROM faculty f
-        JOIN course co ON f.id = co.faculty_id
-        LEFT JOIN candidate c ON co.course_code = c.app_course1
-        GROUP BY f.name
-        ORDER BY avg_score DESC
-    `
+    query := reports.FacultyPerformance.SQL(currentSession.CandidateSource())
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
         log.Printf("Error getting faculty performance: %v", err)
@@ -594,7 +719,7 @@ func displayFacultyPerformance(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nFaculty Performance Analysis")
-    table := tablewriter.NewWriter(os.Stdout)
+    table := newResultTable(reports.FacultyPerformance.Name)
     table.SetHeader([]string{"Faculty", "Total Applicants", "Average Score"})
 
     for rows.Next() {
@@ -619,18 +744,7 @@ func displayFacultyPerformance(ctx context.Context, db *sql.DB) error {
 }
 
 func displayGeographicAnalysis(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT s.st_name as state, l.lg_name as lga,
-               COUNT(c.regnumber) as candidates,
-               ROUND(AVG(c.aggregate)::numeric, 2) as avg_score
-        FROM state s
-        JOIN lga l ON s.st_id = l.state_id
-        JOIN candidate c ON l.lg_id = c.lgaid
-        GROUP BY s.st_name, l.lg_name
-        HAVING COUNT(c.regnumber) > 1000
-        ORDER BY candidates DESC
-        LIMIT 15
-    `
+    query := reports.GeographicAnalysis.SQL(currentSession.CandidateSource())
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
         log.Printf("Error getting geographic analysis: %v", err)
@@ -639,8 +753,8 @@ func displayGeographicAnalysis(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nTop 15 LGAs by Number of Candidates")
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"State", "LGA", "Candidates", "Average Score"})
+    table := newReportTable(reports.GeographicAnalysis.Name, []string{"State", "LGA", "Candidates", "Average Score"},
+        privacy.Spec{Size: "Candidates", Label: "LGA", Means: []string{"Average Score"}})
 
     for rows.Next() {
         var state, lga string
@@ -665,16 +779,11 @@ func displayGeographicAnalysis(ctx context.Context, db *sql.DB) error {
 }
 
 func displayYearComparison(ctx context.Context, db *sql.DB) error {
-    query := `
-        SELECT year,
-               COUNT(*) as total_candidates,
-               ROUND(AVG(aggregate)::numeric, 2) as avg_score,
-               COUNT(CASE WHEN gender = 'F' THEN 1 END) as female_candidates,
-               COUNT(CASE WHEN gender = 'M' THEN 1 END) as male_candidates
-        FROM candidate
-        GROUP BY year
-        ORDER BY year
-    `
+    // Equated averages are shown once score equating has been run
+    source := currentSession.CandidateSource()
+    equated := tableHasColumn(ctx, db, source, "equated_aggregate")
+
+    query := reports.YearComparison(equated).SQL(source)
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
         log.Printf("Error getting year comparison: %v", err)
@@ -683,22 +792,29 @@ func displayYearComparison(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nYear-wise Statistics")
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"Year", "Total Candidates", "Average Score", "Female", "Male"})
+    table := newResultTable("year-comparison")
+    table.SetHeader([]string{"Year", "Total Candidates", "Average Score", "Equated Average", "Female", "Male"})
 
     for rows.Next() {
         var year, totalCandidates, femaleCandidates, maleCandidates int
         var avgScore float64
+        var avgEquated sql.NullFloat64
 
-        err := rows.Scan(&year, &totalCandidates, &avgScore, &femaleCandidates, &maleCandidates)
+        err := rows.Scan(&year, &totalCandidates, &avgScore, &avgEquated, &femaleCandidates, &maleCandidates)
         if err != nil {
             continue
         }
 
+        equatedText := "-"
+        if avgEquated.Valid {
+            equatedText = fmt.Sprintf("%.2f", avgEquated.Float64)
+        }
+
         table.Append([]string{
             fmt.Sprintf("%d", year),
             fmt.Sprintf("%d", totalCandidates),
             fmt.Sprintf("%.2f", avgScore),
+            equatedText,
             fmt.Sprintf("%d", femaleCandidates),
             fmt.Sprintf("%d", maleCandidates),
         })
@@ -709,24 +825,7 @@ func displayYearComparison(ctx context.Context, db *sql.DB) error {
 }
 
 func displayAdmissionTrends(ctx context.Context, db *sql.DB) error {
-    query := `
-        WITH course_stats AS (
-            SELECT 
-                c.course_name,
-                COUNT(*) as applicants,
-                PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY ca.aggregate) as cutoff_score
-            FROM course c
-            JOIN candidate ca ON c.course_code = ca.app_course1
-            GROUP BY c.course_name
-            HAVING COUNT(*) > 100
-        )
-        SELECT name,
-               applicants,
-               ROUND(cutoff_score::numeric, 2) as cutoff_score
-        FROM course_stats
-        ORDER BY applicants DESC
-        LIMIT 15
-    `
+    query := reports.AdmissionTrends.SQL(currentSession.CandidateSource())
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
         log.Printf("Error getting admission trends: %v", err)
@@ -735,7 +834,7 @@ func displayAdmissionTrends(ctx context.Context, db *sql.DB) error {
     defer rows.Close()
 
     color.Yellow("\nAdmission Trends (Top 15 Courses)")
-    table := tablewriter.NewWriter(os.Stdout)
+    table := newResultTable(reports.AdmissionTrends.Name)
     table.SetHeader([]string{"Course", "Total Applicants", "Estimated Cutoff Score"})
 
     for rows.Next() {
@@ -801,8 +900,17 @@ func handleCandidateImport(ctx context.Context, db *sql.DB) error {
     default:
     }
 
-    fmt.Print("Enter the CSV file path: ")
+    fmt.Print("Enter the CSV or .xlsx file path (or db to import a query on a staging database): ")
     filename := readString()
+    fromDB := strings.EqualFold(filename, "db")
+    var sourceDSN, query string
+    if fromDB {
+        fmt.Printf("Source database DSN (blank for IMPORT_SOURCE_DSN, %s for foreign tables in this database): ", localSource)
+        sourceDSN = readString()
+        fmt.Print("Query, with columns named as the import headers (or @file.sql): ")
+        query = readString()
+        filename = "the source database query"
+    }
 
     // Check context after user input
     select {
@@ -824,6 +932,35 @@ func handleCandidateImport(ctx context.Context, db *sql.DB) error {
     fmt.Print("Is this admission data? (y/n): ")
     isAdmission := strings.ToLower(readString()) == "y"
 
+    fmt.Print("Mapping profile file (YAML or JSON, blank for the standard headers): ")
+    profilePath := readString()
+    if profilePath != "" {
+        if _, err := importer.LoadMappingProfile(profilePath); err != nil {
+            return err
+        }
+    }
+
+    fmt.Print("Lookup mode for unknown state/LGA/course/institution values (strict rejects the row, lenient imports it with the value empty) [lenient]: ")
+    lookupMode, err := importer.ParseLookupMode(readString())
+    if err != nil {
+        return err
+    }
+
+    fmt.Print("Is this a delta file containing only changed candidates? (y/n): ")
+    isDelta := strings.ToLower(readString()) == "y"
+    previewDelta := false
+    if isDelta {
+        fmt.Print("Preview the changes without applying them? (y/n): ")
+        previewDelta = strings.ToLower(readString()) == "y"
+    }
+    strategy := importer.StrategyInsert
+    if !isDelta {
+        fmt.Print("Import strategy (insert upserts row by row, copy bulk-loads each batch with COPY) [insert]: ")
+        if strategy, err = importer.ParseStrategy(readString()); err != nil {
+            return err
+        }
+    }
+
     // Check context after user input
     select {
     case <-ctx.Done():
@@ -844,6 +981,16 @@ func handleCandidateImport(ctx context.Context, db *sql.DB) error {
     if isAdmission {
         fmt.Println("This will be imported as admission data")
     }
+    if isDelta {
+        fmt.Println("Only values that differ from the database will be updated")
+    }
+    if profilePath != "" {
+        fmt.Printf("Mapping profile: %s\n", profilePath)
+    }
+    fmt.Printf("Lookup mode: %s\n", lookupMode)
+    if !isDelta {
+        fmt.Printf("Import strategy: %s\n", strategy)
+    }
     fmt.Print("Proceed with import? (y/n): ")
 
     if strings.ToLower(readString()) == "y" {
@@ -854,17 +1001,22 @@ func handleCandidateImport(ctx context.Context, db *sql.DB) error {
         default:
         }
 
-        // Open the CSV file
-        file, err := os.Open(filename)
+        // Open the CSV file, workbook or source query
+        var (
+            reader *csv.Reader
+            size   int64
+            source io.Closer
+        )
+        if fromDB {
+            reader, source, filename, err = openQuerySource(ctx, db, sourceDSN, query)
+        } else {
+            reader, size, source, err = openImportSource(filename)
+        }
         if err != nil {
-            color.Red("Error opening file: %v", err)
-            return fmt.Errorf("error opening file: %w", err)
+            color.Red("%v", err)
+            return err
         }
-        defer file.Close()
-
-        // Create a buffered reader for better performance
-        bufferedReader := bufio.NewReader(file)
-        reader := csv.NewReader(bufferedReader)
+        defer source.Close()
 
         config := importer.ImportConfig{
             Year:        year,
@@ -872,32 +1024,33 @@ func handleCandidateImport(ctx context.Context, db *sql.DB) error {
             IsAdmission: isAdmission,
             BatchSize:   1000,
             WorkerCount: workerCount,
+            LookupMode:  lookupMode,
+            Strategy:    strategy,
+            OnComplete:  candidateImportHooks(db),
+            Progress:    importer.ProgressFunc(printImportProgress),
+            SourceSize:  size,
+            MappingProfile: profilePath,
+        }
+
+        // Offer an LLM-proposed mapping for unrecognised layouts if API keys are configured
+        if engine, err := nlquery.NewNLQueryEngine(db); err == nil {
+            config.MappingGenerator = engine
+            config.ProfileDir = os.Getenv("MAPPING_PROFILE_DIR")
         }
 
         // Create a child context with timeout for the import operation
         importCtx, cancel := context.WithTimeout(ctx, 30*time.Minute)
         defer cancel()
 
-        // Create a progress indicator
-        go func() {
-            ticker := time.NewTicker(5 * time.Second)
-            defer ticker.Stop()
-
-            for {
-                select {
-                case <-importCtx.Done():
-                    return
-                case <-ticker.C:
-                    fmt.Print(".")
-                }
-            }
-        }()
+        if isDelta {
+            config.ValidateOnly = previewDelta
+            return runDeltaImport(importCtx, db, config, reader)
+        }
 
-        fmt.Print("\nImporting data")
+        fmt.Println()
 
         // Pass the context to ImportData
         if err := importer.ImportData(importCtx, db, config, reader); err != nil {
-            fmt.Println() // New line after progress dots
             switch {
             case err == context.DeadlineExceeded:
                 color.Red("Import timed out after 30 minutes")
@@ -911,19 +1064,80 @@ func handleCandidateImport(ctx context.Context, db *sql.DB) error {
             }
         }
         
-        fmt.Println() // New line after progress dots
         color.Green("Import completed successfully!")
+        fmt.Println("Refreshing statistics in the background; progress is recorded in job_log.")
     } else {
         fmt.Println("Import cancelled.")
     }
     return nil
 }
 
+// printImportProgress redraws a one-line progress bar for a running import
+func printImportProgress(p importer.ImportProgress) {
+    line := fmt.Sprintf("Importing: %d rows, %d ok, %d failed", p.Processed, p.Succeeded, p.Failed)
+    if pct := p.Percent(); pct >= 0 {
+        const width = 30
+        filled := min(int(pct/100*width), width)
+        line = fmt.Sprintf("[%s%s] %5.1f%% %s", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), pct, line)
+    }
+    if p.ETA > 0 {
+        line += fmt.Sprintf(", ETA %v", p.ETA.Round(time.Second))
+    }
+    if p.Done {
+        line += fmt.Sprintf(" in %v", p.Elapsed.Round(time.Second))
+    }
+    fmt.Printf("\r%-100s", line)
+    if p.Done {
+        fmt.Println()
+    }
+}
+
+// candidateImportHooks are run after candidate rows have been committed,
+// whether by an import or a retry of failed rows
+func candidateImportHooks(db *sql.DB) []importer.CompletionHook {
+    return []importer.CompletionHook{
+        func(ctx context.Context, year int) error {
+            return api.NotifyImportComplete(ctx, db, year)
+        },
+        statsRefresher.AfterImport,
+        // Imports still write the legacy wide columns; mirror the year's
+        // into the dedicated disability and exam info tables
+        func(ctx context.Context, year int) error {
+            _, _, err := models.NewCandidateRepository(db).ConvertLegacyColumns(ctx, year)
+            return err
+        },
+        // Recorded so the next import of the year can be checked for new NULLs
+        func(ctx context.Context, year int) error {
+            return quality.Snapshot(ctx, db, year)
+        },
+    }
+}
+
 func handleAnalyzeFailedImports(ctx context.Context, db *sql.DB) error {
+    color.Cyan("\nFailed Imports")
+    fmt.Println("1. Most common errors")
+    fmt.Println("2. Failed rows by file")
+    fmt.Println("3. Retry failed rows")
+    fmt.Println("0. Back")
+    fmt.Print("\nEnter your choice: ")
+
+    switch readChoice() {
+    case "1":
+        return displayFailedImportErrors(ctx, db)
+    case "2":
+        return displayFailedImportFiles(ctx, db)
+    case "3":
+        return retryFailedImports(ctx, db)
+    }
+    return nil
+}
+
+func displayFailedImportErrors(ctx context.Context, db *sql.DB) error {
     // Use context for database queries
     query := `
         SELECT error_message, COUNT(*) as count
         FROM import_errors
+        WHERE resolved_at IS NULL
         GROUP BY error_message
         ORDER BY count DESC
         LIMIT 10
@@ -962,28 +1176,81 @@ func handleAnalyzeFailedImports(ctx context.Context, db *sql.DB) error {
     return nil
 }
 
+func displayFailedImportFiles(ctx context.Context, db *sql.DB) error {
+    rows, err := db.QueryContext(ctx, `
+        SELECT COALESCE(source_file, ''), COALESCE(year, 0),
+               COUNT(*) FILTER (WHERE resolved_at IS NULL),
+               COUNT(*) FILTER (WHERE resolved_at IS NOT NULL),
+               MAX(created_at)
+        FROM import_errors
+        GROUP BY 1, 2
+        ORDER BY MAX(created_at) DESC`)
+    if err != nil {
+        return fmt.Errorf("error listing failed rows: %w", err)
+    }
+    defer rows.Close()
+
+    table := tablewriter.NewWriter(os.Stdout)
+    table.SetHeader([]string{"Source File", "Year", "Unresolved", "Resolved", "Last Failure"})
+    var files []string
+    for rows.Next() {
+        var file string
+        var year, unresolved, resolved int
+        var last time.Time
+        if err := rows.Scan(&file, &year, &unresolved, &resolved, &last); err != nil {
+            return err
+        }
+        table.Append([]string{file, strconv.Itoa(year), strconv.Itoa(unresolved), strconv.Itoa(resolved),
+            last.Format("2006-01-02 15:04")})
+        files = append(files, file)
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    table.Render()
+    for _, file := range files {
+        if file != "" {
+            printNotes(ctx, db, notes.Import, file, notes.Import.Key(file))
+        }
+    }
+    return nil
+}
+
+// retryFailedImports re-processes failed rows, e.g. after adding an alias
+// or reference data that was missing when they were imported
+func retryFailedImports(ctx context.Context, db *sql.DB) error {
+    fmt.Print("Source file to retry (blank for all): ")
+    sourceFile := readString()
+    fmt.Print("Year to retry (0 for all): ")
+    year := readInt()
+    fmt.Print("Are these rows admission data? (y/n): ")
+    isAdmission := strings.ToLower(readString()) == "y"
+    fmt.Print("Lookup mode for unknown state/LGA/course/institution values (strict/lenient) [lenient]: ")
+    lookupMode, err := importer.ParseLookupMode(readString())
+    if err != nil {
+        return err
+    }
+
+    config := importer.ImportConfig{
+        IsAdmission: isAdmission,
+        LookupMode:  lookupMode,
+        OnComplete:  candidateImportHooks(db),
+    }
+    summary, err := importer.RetryFailedRows(ctx, db, config, sourceFile, year)
+    if err != nil {
+        return fmt.Errorf("error retrying failed rows: %w", err)
+    }
+    if summary.Attempted == 0 {
+        color.Yellow("No unresolved failed rows match")
+        return nil
+    }
+    color.Green("Retried %d rows: %d imported, %d still failing, %d skipped",
+        summary.Attempted, summary.Resolved, summary.Failed, summary.Skipped)
+    return nil
+}
+
 func displayPerformanceMetrics(ctx context.Context, db *sql.DB) error {
-    query := `
-        WITH ScoreStats AS (
-            SELECT 
-                year,
-                COUNT(*) as total_candidates,
-                AVG(NULLIF(aggregate, 0)) as avg_score,
-                PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY NULLIF(aggregate, 0)) as median_score,
-                STDDEV(NULLIF(aggregate, 0)) as std_dev
-            FROM candidate 
-            WHERE aggregate IS NOT NULL AND aggregate > 0
-            GROUP BY year
-        )
-        SELECT 
-            year,
-            total_candidates,
-            COALESCE(ROUND(avg_score::numeric, 2), 0) as average_score,
-            COALESCE(ROUND(median_score::numeric, 2), 0) as median_score,
-            COALESCE(ROUND(std_dev::numeric, 2), 0) as standard_deviation
-        FROM ScoreStats
-        ORDER BY year DESC;
-    `
+    query := reports.PerformanceMetrics.SQL(currentSession.CandidateSource())
     
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
@@ -992,7 +1259,7 @@ func displayPerformanceMetrics(ctx context.Context, db *sql.DB) error {
     }
     defer rows.Close()
 
-    table := tablewriter.NewWriter(os.Stdout)
+    table := newResultTable(reports.PerformanceMetrics.Name)
     table.SetHeader([]string{"Year", "Total Candidates", "Average Score", "Median Score", "Std Deviation"})
 
     for rows.Next() {
@@ -1019,33 +1286,16 @@ func displayPerformanceMetrics(ctx context.Context, db *sql.DB) error {
 }
 
 func displayInstitutionRanking(ctx context.Context, db *sql.DB) error {
-    query := `
-        WITH AdmissionStats AS (
-            SELECT 
-                i.inname as institution_name,
-                i.inabv as abbreviation,
-                COUNT(c.regnumber) as total_applicants,
-                COUNT(CASE WHEN c.is_admitted = true THEN 1 END) as admitted_count,
-                AVG(NULLIF(c.aggregate, 0)) as avg_score
-            FROM institution i
-            LEFT JOIN candidate c ON i.inid = c.inid
-            WHERE c.year = (SELECT MAX(year) FROM candidate)
-                AND c.aggregate IS NOT NULL 
-                AND c.aggregate > 0
-            GROUP BY i.inname, i.inabv
-            HAVING COUNT(c.regnumber) > 100
-        )
-        SELECT 
-            institution_name,
-            abbreviation,
-            total_applicants,
-            admitted_count,
-            COALESCE(ROUND(avg_score::numeric, 2), 0) as average_score,
-            ROUND((admitted_count::float / total_applicants * 100)::numeric, 2) as admission_rate
-        FROM AdmissionStats
-        ORDER BY avg_score DESC
-        LIMIT 20;
-    `
+    fmt.Printf("Ranking weights (score, selectivity, volume, gender) [%s]: ", reports.DefaultRankingWeights)
+    weights := reports.DefaultRankingWeights
+    if input := readString(); input != "" {
+        var err error
+        if weights, err = reports.ParseRankingWeights(input); err != nil {
+            return err
+        }
+    }
+
+    query := reports.CompositeRanking(weights).SQL(currentSession.CandidateSource())
     
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
@@ -1054,167 +1304,46 @@ func displayInstitutionRanking(ctx context.Context, db *sql.DB) error {
     }
     defer rows.Close()
 
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{"Institution", "Abbrev", "Total Applicants", "Admitted", "Avg Score", "Admission Rate (%)"})
+    table := newResultTable("institution-composite")
+    table.SetHeader([]string{"Rank", "Institution", "Abbrev", "Applicants", "Admitted", "Avg Score",
+        "Score Pts", "Selectivity Pts", "Volume Pts", "Gender Pts", "Composite"})
 
+    rank := 0
     for rows.Next() {
         var name, abbrev string
         var totalApplicants, admitted int
-        var avgScore, admissionRate float64
+        var avgScore, scorePts, selectivityPts, volumePts, genderPts, composite float64
         
-        if err := rows.Scan(&name, &abbrev, &totalApplicants, &admitted, &avgScore, &admissionRate); err != nil {
+        if err := rows.Scan(&name, &abbrev, &totalApplicants, &admitted, &avgScore,
+            &scorePts, &selectivityPts, &volumePts, &genderPts, &composite); err != nil {
             color.Red("Error scanning row: %v", err)
             continue
         }
         
+        rank++
         table.Append([]string{
+            strconv.Itoa(rank),
             name,
             abbrev,
             strconv.Itoa(totalApplicants),
             strconv.Itoa(admitted),
             fmt.Sprintf("%.2f", avgScore),
-            fmt.Sprintf("%.2f%%", admissionRate),
+            fmt.Sprintf("%.1f", scorePts),
+            fmt.Sprintf("%.1f", selectivityPts),
+            fmt.Sprintf("%.1f", volumePts),
+            fmt.Sprintf("%.1f", genderPts),
+            fmt.Sprintf("%.1f", composite),
         })
     }
 
-    color.Cyan("\nTop 20 Institutions by Average Score (Latest Year)")
+    color.Cyan("\nTop 20 Institutions by Composite Score (Latest Year)")
+    fmt.Printf("Weights: %s; each component is scaled 0-100 across institutions with over 100 applicants\n", weights)
     table.Render()
-    return nil
-}
-
-func displaySubjectCorrelation(ctx context.Context, db *sql.DB) error {
-    query := `
-        WITH EnglishScores AS (
-            SELECT 
-                cs.cand_reg_number,
-                cs.score as english_score
-            FROM candidate_scores cs
-            JOIN subject s ON cs.subject_id = s.su_id
-            WHERE s.su_name = 'USE OF ENGLISH'
-            AND cs.year = (SELECT MAX(year) FROM candidate)
-        ),
-        OtherSubjectScores AS (
-            SELECT 
-                cs.cand_reg_number,
-                s.su_name as subject_name,
-                cs.score as subject_score
-            FROM candidate_scores cs
-            JOIN subject s ON cs.subject_id = s.su_id
-            WHERE s.su_name != 'USE OF ENGLISH'
-            AND cs.year = (SELECT MAX(year) FROM candidate)
-        ),
-        SubjectCorrelations AS (
-            SELECT 
-                o.subject_name,
-                COUNT(*) as sample_size,
-                CORR(e.english_score, o.subject_score) as correlation,
-                AVG(e.english_score) as avg_english,
-                AVG(o.subject_score) as avg_subject,
-                STDDEV(e.english_score) as stddev_english,
-                STDDEV(o.subject_score) as stddev_subject
-            FROM EnglishScores e
-            JOIN OtherSubjectScores o ON e.cand_reg_number = o.cand_reg_number
-            GROUP BY o.subject_name
-            HAVING COUNT(*) >= 1000  -- Ensure statistical significance
-            AND STDDEV(e.english_score) > 0 
-            AND STDDEV(o.subject_score) > 0
-        )
-        SELECT 
-            'USE OF ENGLISH' as subject1,
-            subject_name as subject2,
-            ROUND(correlation::numeric, 3) as correlation,
-            sample_size,
-            ROUND(avg_english::numeric, 2) as avg_score1,
-            ROUND(avg_subject::numeric, 2) as avg_score2,
-            ROUND(stddev_english::numeric, 2) as stddev1,
-            ROUND(stddev_subject::numeric, 2) as stddev2
-        FROM SubjectCorrelations
-        WHERE correlation IS NOT NULL
-        ORDER BY ABS(correlation) DESC;
-    `
-
-    rows, err := db.QueryContext(ctx, query)
-    if err != nil {
-        color.Red("Error fetching subject correlations: %v", err)
-        return err
-    }
-    defer rows.Close()
-
-    table := tablewriter.NewWriter(os.Stdout)
-    table.SetHeader([]string{
-        "Subject 1", 
-        "Subject 2", 
-        "Correlation", 
-        "Sample Size",
-        "Avg Score 1", 
-        "Avg Score 2", 
-        "StdDev 1", 
-        "StdDev 2",
-    })
-
-    hasRows := false
-    for rows.Next() {
-        hasRows = true
-        var (
-            subject1, subject2 string
-            correlation        float64
-            sampleSize        int
-            avgScore1, avgScore2, stdDev1, stdDev2 float64
-        )
-
-        if err := rows.Scan(&subject1, &subject2, &correlation, &sampleSize,
-            &avgScore1, &avgScore2, &stdDev1, &stdDev2); err != nil {
-            color.Red("Error scanning row: %v", err)
-            continue
-        }
-
-        table.Append([]string{
-            subject1,
-            subject2,
-            fmt.Sprintf("%.3f", correlation),
-            fmt.Sprintf("%d", sampleSize),
-            fmt.Sprintf("%.2f", avgScore1),
-            fmt.Sprintf("%.2f", avgScore2),
-            fmt.Sprintf("%.2f", stdDev1),
-            fmt.Sprintf("%.2f", stdDev2),
-        })
-    }
-
-    color.Cyan("\nSubject Score Correlations (Latest Year)\n")
-    if !hasRows {
-        color.Yellow("No significant correlations found between subjects.")
-    } else {
-        table.Render()
-    }
-
-    return nil
+    return rows.Err()
 }
 
 func displayRegionalPerformance(ctx context.Context, db *sql.DB) error {
-    query := `
-        WITH RegionalStats AS (
-            SELECT 
-                s.st_name as state_name,
-                COUNT(c.regnumber) as total_candidates,
-                AVG(NULLIF(c.aggregate, 0)) as avg_score,
-                COUNT(CASE WHEN c.is_admitted = true THEN 1 END) as admitted_count,
-                COUNT(CASE WHEN c.gender = 'F' THEN 1 END) as female_count
-            FROM candidate c
-            JOIN state s ON c.statecode = s.st_id
-            WHERE c.year = (SELECT MAX(year) FROM candidate)
-                AND c.aggregate IS NOT NULL 
-                AND c.aggregate > 0
-            GROUP BY s.st_name
-        )
-        SELECT 
-            state_name,
-            total_candidates,
-            COALESCE(ROUND(avg_score::numeric, 2), 0) as average_score,
-            admitted_count,
-            ROUND((female_count::float / total_candidates * 100)::numeric, 2) as female_percentage
-        FROM RegionalStats
-        ORDER BY total_candidates DESC;
-    `
+    query := reports.RegionalPerformance.SQL(currentSession.CandidateSource())
     
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
@@ -1223,7 +1352,7 @@ func displayRegionalPerformance(ctx context.Context, db *sql.DB) error {
     }
     defer rows.Close()
 
-    table := tablewriter.NewWriter(os.Stdout)
+    table := newResultTable(reports.RegionalPerformance.Name)
     table.SetHeader([]string{"State", "Total Candidates", "Avg Score", "Admitted", "Female %"})
 
     for rows.Next() {
@@ -1247,39 +1376,11 @@ func displayRegionalPerformance(ctx context.Context, db *sql.DB) error {
 
     color.Cyan("\nRegional Performance Analysis (Latest Year)")
     table.Render()
-    return nil
+    return offerDrillDown(ctx, db, reports.EntityState, true)
 }
 
 func displayCourseCompetitiveness(ctx context.Context, db *sql.DB) error {
-    query := `
-        WITH CourseStats AS (
-            SELECT 
-                c.app_course1 as course_code,
-                co.course_name as course_name,
-                COUNT(c.regnumber) as total_applicants,
-                MIN(NULLIF(c.aggregate, 0)) as min_score,
-                MAX(NULLIF(c.aggregate, 0)) as max_score,
-                AVG(NULLIF(c.aggregate, 0)) as avg_score,
-                COUNT(CASE WHEN c.is_admitted = true THEN 1 END) as admitted_count
-            FROM candidate c
-            JOIN course co ON c.app_course1 = co.course_code
-            WHERE c.year = (SELECT MAX(year) FROM candidate)
-                AND c.aggregate IS NOT NULL 
-                AND c.aggregate > 0
-            GROUP BY c.app_course1, co.course_name
-            HAVING COUNT(c.regnumber) > 50
-        )
-        SELECT 
-            course_name,
-            total_applicants,
-            COALESCE(ROUND(min_score::numeric, 2), 0) as minimum_score,
-            COALESCE(ROUND(max_score::numeric, 2), 0) as maximum_score,
-            COALESCE(ROUND(avg_score::numeric, 2), 0) as average_score,
-            ROUND((admitted_count::float / total_applicants * 100)::numeric, 2) as admission_rate
-        FROM CourseStats
-        ORDER BY avg_score DESC
-        LIMIT 20;
-    `
+    query := reports.CourseCompetitiveness.SQL(currentSession.CandidateSource())
     
     rows, err := db.QueryContext(ctx, query)
     if err != nil {
@@ -1288,7 +1389,7 @@ func displayCourseCompetitiveness(ctx context.Context, db *sql.DB) error {
     }
     defer rows.Close()
 
-    table := tablewriter.NewWriter(os.Stdout)
+    table := newResultTable(reports.CourseCompetitiveness.Name)
     table.SetHeader([]string{"Course", "Applicants", "Min Score", "Max Score", "Avg Score", "Admission Rate (%)"})
 
     for rows.Next() {
@@ -1317,17 +1418,15 @@ func displayCourseCompetitiveness(ctx context.Context, db *sql.DB) error {
 }
 
 func handleCourseImport(ctx context.Context, db *sql.DB) error {
-    fmt.Print("Enter the path to the courses CSV file: ")
+    fmt.Print("Enter the path to the courses CSV or .xlsx file: ")
     filename := readString()
 
-    file, err := os.Open(filename)
+    reader, _, source, err := openImportSource(filename)
     if err != nil {
         color.Red("Failed to open file: %v", err)
         return err
     }
-    defer file.Close()
-
-    reader := csv.NewReader(file)
+    defer source.Close()
 
     // Create a context with timeout
     importCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
@@ -1385,29 +1484,114 @@ func handleCourseImport(ctx context.Context, db *sql.DB) error {
     return nil
 }
 
+// cutPrefixFold is strings.CutPrefix ignoring case
+func cutPrefixFold(s, prefix string) (string, bool) {
+    if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+        return s[len(prefix):], true
+    }
+    return s, false
+}
+
 func handleNaturalLanguageQuery(db *sql.DB) error {
     fmt.Println("\nNatural Language Query")
     fmt.Println("=====================")
 
     // Initialize the NL query engine
-    engine, err := nlquery.NewNLQueryEngine(db)
+    engine, err := nlquery.NewNLQueryEngineWithOptions(db, nlquery.Options{QueryDB: analyticsDB, Source: "menu"})
     if err != nil {
         fmt.Printf("Error initializing query engine: %v\n", err)
         return err
     }
 
-    fmt.Println("Enter your question (or 'exit' to return to menu):")
+    fmt.Printf("Providers: %s\n", strings.Join(engine.Providers(), " -> "))
+    fmt.Println("Enter your question, 'history' to search past questions, or 'exit' to return to menu:")
+    fmt.Println("(start a question with 'fresh:' to skip the translation cache; 'clear cache' empties it;")
+    fmt.Println(" 'refresh schema' rereads the tables after a migration; 'keys' shows Gemini API key health)")
+    fmt.Println("('copy' puts the last answer's rows on the clipboard, 'copy sql' its SQL;")
+    fmt.Println(" 'export FILE.csv' saves them as CSV, JSON or Excel by the file's extension;")
+    fmt.Println(" 'save NAME' keeps the last answer's SQL as a saved report)")
+    fmt.Println("Follow-up questions such as 'now break that down by gender' build on earlier answers; 'reset' starts over.")
+
+    // lastQuestion is the question whose answer is in lastShown
+    lastQuestion := ""
 
     for {
-        fmt.Print("\nQuery: ")
+        if n := len(engine.Conversation()); n > 0 {
+            fmt.Printf("\nQuery (follow-up to %d earlier questions): ", n)
+        } else {
+            fmt.Print("\nQuery: ")
+        }
         query := readString()
         if strings.ToLower(query) == "exit" {
             return nil
         }
+        if strings.ToLower(query) == "history" {
+            if err := searchQueryHistory(context.Background(), engine); err != nil {
+                color.Red("Error searching history: %v", err)
+            }
+            continue
+        }
+
+        if strings.EqualFold(query, "refresh schema") {
+            if err := engine.RefreshSchema(context.Background()); err != nil {
+                color.Red("Error refreshing schema: %v", err)
+            } else {
+                color.Green("Schema description refreshed")
+            }
+            continue
+        }
+
+        if strings.EqualFold(query, "keys") {
+            showKeyHealth()
+            continue
+        }
+
+        if strings.EqualFold(query, "reset") {
+            engine.Reset()
+            color.Green("Conversation cleared; the next question starts afresh")
+            continue
+        }
+
+        if path, ok := cutPrefixFold(query, "export "); ok {
+            if err := exportNLResult(strings.TrimSpace(path)); err != nil {
+                color.Red("Error exporting: %v", err)
+            }
+            continue
+        }
+
+        if name, ok := cutPrefixFold(query, "save "); ok {
+            if lastQuestion == "" || lastShown.name != "nl-query" {
+                color.Red("No natural language answer to save yet")
+            } else if err := saveReport(context.Background(), db, strings.TrimSpace(name), lastQuestion, lastShown.sql); err != nil {
+                color.Red("Error saving report: %v", err)
+            }
+            continue
+        }
+
+        if strings.EqualFold(query, "copy") || strings.EqualFold(query, "copy sql") {
+            if err := handleCopy(strings.EqualFold(query, "copy sql")); err != nil {
+                color.Red("Error copying: %v", err)
+            }
+            continue
+        }
+
+        if strings.EqualFold(query, "clear cache") {
+            n, err := nlquery.ClearCache(context.Background(), db)
+            if err != nil {
+                color.Red("Error clearing cache: %v", err)
+            } else {
+                color.Green("Translation cache cleared (%d stored entries removed)", n)
+            }
+            continue
+        }
+        queryCtx := context.Background()
+        if rest, ok := cutPrefixFold(query, "fresh:"); ok {
+            query, queryCtx = strings.TrimSpace(rest), nlquery.WithoutCache(queryCtx)
+        }
 
         // Process the query using the NLQueryEngine
         fmt.Println("\nProcessing query... (this may take a few seconds)")
-        result, err := engine.ProcessQuery(query)
+        result, err := engine.ProcessQueryContext(queryCtx, query)
         if err != nil {
             fmt.Printf("\nError processing query: %v\n", err)
             continue
@@ -1415,6 +1599,8 @@ func handleNaturalLanguageQuery(db *sql.DB) error {
 
         fmt.Println("\nResults:")
         fmt.Println("--------")
-        fmt.Println(result)
+        fmt.Println(result.Results)
+        rememberShown("nl-query", result.Columns, nlRows(result.Rows), result.SQLQuery)
+        lastQuestion = query
     }
 }
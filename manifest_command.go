@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/export"
+)
+
+// runManifest creates export signing keys and verifies signed exports. It
+// needs no database, so recipients of an export can run it too.
+func runManifest(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("manifest")
+	keyFile := fs.String("key", "", "with verify, the signer's public key file (default the public half of $EXPORT_SIGNING_KEY)")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 || (positional[0] != "keygen" && positional[0] != "verify") {
+		return usageError{errors.New("manifest needs keygen FILE or verify DIR")}
+	}
+
+	if positional[0] == "keygen" {
+		public, err := export.GenerateSigningKey(positional[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote the private key to %s and the public key to %s.pub (key %s)\n",
+			positional[1], positional[1], export.KeyID(public))
+		fmt.Fprintf(os.Stderr, "Set EXPORT_SIGNING_KEY=%s to sign exports and give %s.pub to recipients\n",
+			positional[1], positional[1])
+		return nil
+	}
+
+	var key ed25519.PublicKey
+	if *keyFile != "" {
+		if key, err = export.ReadPublicKey(*keyFile); err != nil {
+			return err
+		}
+	} else {
+		private, err := export.SigningKeyFromEnv()
+		if err != nil {
+			return err
+		}
+		if private == nil {
+			return usageError{errors.New("manifest verify needs -key or EXPORT_SIGNING_KEY")}
+		}
+		key = private.Public().(ed25519.PublicKey)
+	}
+
+	v, err := export.VerifyExport(positional[1], key)
+	if err != nil {
+		return err
+	}
+	m := v.Manifest
+	fmt.Printf("Manifest signed by key %s\n", v.KeyID)
+	fmt.Printf("Export %s of %d rows, generated by %s", m.Name, m.TotalRows, m.GeneratedBy)
+	if m.FinishedAt != nil {
+		fmt.Printf(" at %s", m.FinishedAt.Format("2006-01-02 15:04:05 MST"))
+	}
+	fmt.Println()
+	if m.Filter != "" {
+		fmt.Printf("Filter: %s\n", m.Filter)
+	}
+	for _, f := range v.Files {
+		if f.Err != nil {
+			color.Red("FAILED %s: %v", f.File, f.Err)
+		} else {
+			fmt.Printf("OK     %s (%d rows)\n", f.File, f.Rows)
+		}
+	}
+	if failed := v.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d files do not match the signed manifest", failed, len(v.Files))
+	}
+	color.Green("All %d files match the signed manifest", len(v.Files))
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nonsonwune/spk2_db/migrations"
+)
+
+// runMigrate applies or rolls back the embedded schema migrations. Other
+// commands apply pending migrations on startup; migrate is the only way to
+// roll one back, and only with -yes. The migrations that adopt the core
+// tables refuse to roll back.
+func runMigrate(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("migrate")
+	steps := fs.Int("steps", 0, "number of migrations to apply or roll back (default all for up, 1 for down)")
+	yes := fs.Bool("yes", false, "confirm rolling back with down, which may drop tables and their data")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{errors.New("migrate needs up, down or status")}
+	}
+	if *steps < 0 {
+		return usageError{errors.New("-steps must not be negative")}
+	}
+
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	switch fs.Arg(0) {
+	case "up":
+		done, err := migrator.Up(ctx, *steps)
+		if len(done) == 0 && err == nil {
+			fmt.Fprintln(os.Stderr, "No pending migrations")
+		}
+		return err
+	case "down":
+		if *steps == 0 {
+			*steps = 1
+		}
+		if !*yes {
+			return usageError{fmt.Errorf("rolling back %d migration(s) may drop tables and their data; add -yes to confirm", *steps)}
+		}
+		done, err := migrator.Down(ctx, *steps)
+		if len(done) == 0 && err == nil {
+			fmt.Fprintln(os.Stderr, "No applied migrations")
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return usageError{fmt.Errorf("unknown migrate action %q", fs.Arg(0))}
+	}
+}
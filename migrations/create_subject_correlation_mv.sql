@@ -0,0 +1,27 @@
+-- Precomputed subject-pair score correlations per year.
+-- Refresh after each scores import:
+--   REFRESH MATERIALIZED VIEW subject_correlation_mv;
+CREATE MATERIALIZED VIEW IF NOT EXISTS subject_correlation_mv AS
+SELECT
+    a.year,
+    sa.su_name AS subject1,
+    sb.su_name AS subject2,
+    COUNT(*) AS sample_size,
+    ROUND(CORR(a.score, b.score)::numeric, 3)::float8 AS correlation,
+    ROUND(AVG(a.score)::numeric, 2)::float8 AS avg_score1,
+    ROUND(AVG(b.score)::numeric, 2)::float8 AS avg_score2,
+    ROUND(STDDEV(a.score)::numeric, 2)::float8 AS stddev1,
+    ROUND(STDDEV(b.score)::numeric, 2)::float8 AS stddev2
+FROM candidate_scores a
+JOIN candidate_scores b
+    ON a.cand_reg_number = b.cand_reg_number
+    AND a.year = b.year
+    AND a.subject_id < b.subject_id
+JOIN subject sa ON a.subject_id = sa.su_id
+JOIN subject sb ON b.subject_id = sb.su_id
+GROUP BY a.year, sa.su_name, sb.su_name
+HAVING COUNT(*) >= 1000
+    AND STDDEV(a.score) > 0
+    AND STDDEV(b.score) > 0;
+
+CREATE INDEX IF NOT EXISTS idx_subject_correlation_mv_year ON subject_correlation_mv(year);
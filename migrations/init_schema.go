@@ -1,33 +1,54 @@
 package migrations
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 )
 
-// InitSchema verifies that all required tables exist
-func InitSchema(db *sql.DB) error {
-	// We're using existing tables, so just verify they exist
-	tables := []string{"state", "course", "institution", "lga", "subject"}
-	
-	for _, table := range tables {
-		var exists bool
-		query := `
-			SELECT EXISTS (
-				SELECT FROM information_schema.tables 
-				WHERE table_schema = 'public' 
-				AND table_name = $1
-			)`
-		
-		err := db.QueryRow(query, table).Scan(&exists)
+// coreTables are the JAMB reference tables loaded from outside. They are
+// only checked for, never created or altered.
+var coreTables = []string{"state", "course", "institution", "lga", "subject"}
+
+// InitSchema applies any pending migrations, which create the candidate
+// and application-owned tables, and verifies that the core tables exist.
+// A missing core table is reported after the migrations have run.
+func InitSchema(ctx context.Context, db *sql.DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	if _, err := migrator.Up(ctx, 0); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, table := range coreTables {
+		exists, err := tableExists(ctx, db, table)
 		if err != nil {
 			return err
 		}
-		
 		if !exists {
-			return fmt.Errorf("required table %s does not exist", table)
+			missing = append(missing, table)
 		}
 	}
-	
+	if len(missing) > 0 {
+		return fmt.Errorf("required tables do not exist: %s", strings.Join(missing, ", "))
+	}
 	return nil
 }
+
+func tableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT FROM information_schema.tables
+            WHERE table_schema = 'public'
+            AND table_name = $1
+        )`, table).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking for table %s: %w", table, err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,7 @@
+-- candidate and its detail tables hold the core data and were adopted, not
+-- created, on databases older than migrations, so this is never rolled back
+DO $$
+BEGIN
+    RAISE EXCEPTION 'migration 0001 adopts the core candidate tables and cannot be rolled back';
+END
+$$;
@@ -0,0 +1,51 @@
+-- Candidates and the per-candidate detail tables. IF NOT EXISTS lets
+-- databases created before migrations adopt this version unchanged.
+CREATE TABLE IF NOT EXISTS candidate (
+    regnumber VARCHAR(20) PRIMARY KEY,
+    year INTEGER NOT NULL,
+    maritalstatus VARCHAR(20),
+    address TEXT,
+    email VARCHAR(255),
+    gsmno VARCHAR(20),
+    surname VARCHAR(100),
+    firstname VARCHAR(100),
+    middlename VARCHAR(100),
+    date_of_birth DATE,
+    gender VARCHAR(10),
+    statecode INTEGER,
+    lg_id INTEGER,
+    aggregate INTEGER,
+    app_course1 VARCHAR(20),
+    inid VARCHAR(20),
+    noofsittings INTEGER,
+    is_admitted BOOLEAN DEFAULT false,
+    is_direct_entry BOOLEAN DEFAULT false,
+    is_blind BOOLEAN DEFAULT false,
+    is_deaf BOOLEAN DEFAULT false,
+    is_mock_candidate BOOLEAN DEFAULT false,
+    malpractice VARCHAR(50),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_candidate_year ON candidate (year);
+
+CREATE TABLE IF NOT EXISTS candidate_disabilities (
+    cand_reg_number VARCHAR(20) PRIMARY KEY REFERENCES candidate (regnumber) ON DELETE CASCADE,
+    is_blind BOOLEAN NOT NULL DEFAULT false,
+    is_deaf BOOLEAN NOT NULL DEFAULT false,
+    other_challenges TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS candidate_exam_info (
+    cand_reg_number VARCHAR(20) PRIMARY KEY REFERENCES candidate (regnumber) ON DELETE CASCADE,
+    exam_town VARCHAR(100),
+    exam_centre VARCHAR(255),
+    exam_number VARCHAR(50),
+    mock_state_id INTEGER,
+    mock_town VARCHAR(100),
+    is_mock_candidate BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
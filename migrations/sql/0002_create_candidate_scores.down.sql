@@ -0,0 +1,7 @@
+-- candidate_scores holds the core data and was adopted, not created, on
+-- databases older than migrations, so this is never rolled back
+DO $$
+BEGIN
+    RAISE EXCEPTION 'migration 0002 adopts the core candidate_scores table and cannot be rolled back';
+END
+$$;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS candidate_scores (
+    cand_reg_number VARCHAR(20) NOT NULL,
+    subject_id INTEGER NOT NULL,
+    score INTEGER,
+    year INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (cand_reg_number, subject_id, year)
+);
+CREATE INDEX IF NOT EXISTS idx_candidate_scores_year_subject ON candidate_scores (year, subject_id);
@@ -0,0 +1,16 @@
+-- Failed import rows, kept with their header and raw line for retries
+CREATE TABLE IF NOT EXISTS import_errors (
+    id SERIAL PRIMARY KEY,
+    regnumber VARCHAR(20),
+    source_file TEXT,
+    year INTEGER,
+    error_message TEXT NOT NULL,
+    raw_record TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE import_errors ADD COLUMN IF NOT EXISTS line_number INTEGER;
+ALTER TABLE import_errors ADD COLUMN IF NOT EXISTS header TEXT;
+ALTER TABLE import_errors ADD COLUMN IF NOT EXISTS retried_at TIMESTAMP;
+ALTER TABLE import_errors ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_import_errors_unresolved
+    ON import_errors (source_file, year) WHERE resolved_at IS NULL;
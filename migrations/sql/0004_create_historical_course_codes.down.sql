@@ -0,0 +1,7 @@
+-- historical_course_codes predates migrations on older databases, where
+-- this adopted it, so this is never rolled back
+DO $$
+BEGIN
+    RAISE EXCEPTION 'migration 0004 adopts the historical_course_codes table and cannot be rolled back';
+END
+$$;
@@ -0,0 +1,11 @@
+-- Course codes seen in imports that are no longer in course
+CREATE TABLE IF NOT EXISTS historical_course_codes (
+    id SERIAL PRIMARY KEY,
+    year INTEGER NOT NULL,
+    old_course_code TEXT NOT NULL,
+    institution_id INTEGER NOT NULL,
+    course_name TEXT,
+    notes TEXT,
+    import_timestamp TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (year, old_course_code, institution_id)
+);
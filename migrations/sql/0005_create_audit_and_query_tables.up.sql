@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS import_audit (
+    id SERIAL PRIMARY KEY,
+    source_file TEXT NOT NULL,
+    import_type TEXT NOT NULL,
+    year INTEGER,
+    rows_imported INTEGER NOT NULL DEFAULT 0,
+    rows_failed INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'running',
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_import_audit_started_at ON import_audit (started_at);
+
+CREATE TABLE IF NOT EXISTS saved_queries (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT,
+    sql_query TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS query_history (
+    id SERIAL PRIMARY KEY,
+    source TEXT NOT NULL,
+    query_text TEXT NOT NULL,
+    sql_query TEXT,
+    row_count INTEGER,
+    duration_ms INTEGER,
+    error TEXT,
+    executed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_query_history_executed_at ON query_history (executed_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    name TEXT PRIMARY KEY,
+    last_started_at TIMESTAMPTZ,
+    last_finished_at TIMESTAMPTZ,
+    last_status TEXT,
+    last_error TEXT,
+    running_since TIMESTAMPTZ,
+    running_owner TEXT
+);
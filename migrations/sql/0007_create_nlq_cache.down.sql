@@ -0,0 +1 @@
+DROP TABLE IF EXISTS nlq_cache;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS nlq_cache (
+    question_key TEXT NOT NULL,
+    schema_hash TEXT NOT NULL,
+    question TEXT NOT NULL,
+    sql_query TEXT NOT NULL,
+    thought_process TEXT NOT NULL DEFAULT '',
+    explanation TEXT NOT NULL DEFAULT '',
+    provider TEXT NOT NULL DEFAULT '',
+    hits INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_hit_at TIMESTAMP,
+    PRIMARY KEY (question_key, schema_hash)
+);
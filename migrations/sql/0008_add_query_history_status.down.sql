@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_query_history_source;
+ALTER TABLE query_history DROP COLUMN IF EXISTS provider;
+ALTER TABLE query_history DROP COLUMN IF EXISTS status;
@@ -0,0 +1,3 @@
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'succeeded';
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS provider TEXT;
+CREATE INDEX IF NOT EXISTS idx_query_history_source ON query_history (source, executed_at);
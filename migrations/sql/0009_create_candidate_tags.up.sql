@@ -0,0 +1,17 @@
+-- Named cohorts of candidates, e.g. a scholarship shortlist. Filters match
+-- them with tag=NAME.
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS candidate_tags (
+    tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    regnumber VARCHAR(20) NOT NULL REFERENCES candidate (regnumber) ON DELETE CASCADE,
+    tagged_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tag_id, regnumber)
+);
+
+CREATE INDEX IF NOT EXISTS idx_candidate_tags_regnumber ON candidate_tags (regnumber);
@@ -0,0 +1,11 @@
+-- Free-text notes on institutions, courses and import jobs (by source file
+-- name), e.g. "course code changed in 2022, see mapping 314"
+CREATE TABLE IF NOT EXISTS notes (
+    id SERIAL PRIMARY KEY,
+    entity_type TEXT NOT NULL CHECK (entity_type IN ('institution', 'course', 'import')),
+    entity_key TEXT NOT NULL,
+    note TEXT NOT NULL,
+    author TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_notes_entity ON notes (entity_type, entity_key);
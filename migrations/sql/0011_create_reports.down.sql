@@ -0,0 +1,5 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS last_run_at;
+ALTER TABLE reports DROP COLUMN IF EXISTS created_by;
+ALTER TABLE reports DROP COLUMN IF EXISTS defaults;
+ALTER TABLE reports DROP COLUMN IF EXISTS question;
+ALTER TABLE reports RENAME TO saved_queries;
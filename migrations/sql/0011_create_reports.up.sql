@@ -0,0 +1,16 @@
+-- Saved reports: named, parameterised queries written by hand or generated
+-- from a natural language question. saved_queries was never used, so it
+-- becomes the reports table.
+ALTER TABLE IF EXISTS saved_queries RENAME TO reports;
+CREATE TABLE IF NOT EXISTS reports (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT,
+    sql_query TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS question TEXT;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS defaults JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS created_by TEXT;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS last_run_at TIMESTAMP;
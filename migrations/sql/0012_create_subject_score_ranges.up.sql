@@ -0,0 +1,14 @@
+-- Valid score range of each subject, checked during score imports. A row
+-- without a year applies to every year that has no row of its own; subjects
+-- without any row are scored 0-100.
+CREATE TABLE IF NOT EXISTS subject_score_ranges (
+    id SERIAL PRIMARY KEY,
+    subject_id INTEGER NOT NULL,
+    year INTEGER,
+    min_score INTEGER NOT NULL DEFAULT 0,
+    max_score INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (min_score <= max_score)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subject_score_ranges_subject_year
+    ON subject_score_ranges (subject_id, COALESCE(year, 0));
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS null_snapshots;
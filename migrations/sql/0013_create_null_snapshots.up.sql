@@ -0,0 +1,12 @@
+-- NULL counts of each candidate column per year, recorded after every
+-- candidate import so a data quality check can tell which columns got
+-- worse with the latest one
+CREATE TABLE IF NOT EXISTS null_snapshots (
+    id SERIAL PRIMARY KEY,
+    year INTEGER NOT NULL,
+    column_name TEXT NOT NULL,
+    total_rows BIGINT NOT NULL,
+    null_rows BIGINT NOT NULL,
+    taken_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_null_snapshots_year_column ON null_snapshots (year, column_name, taken_at);
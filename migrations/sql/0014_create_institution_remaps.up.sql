@@ -0,0 +1,14 @@
+-- Institution codes an import resolved through institution_names or
+-- course_code_mappings rather than the institution table, one row per
+-- code and institution per import
+CREATE TABLE IF NOT EXISTS institution_remaps (
+    id SERIAL PRIMARY KEY,
+    source_file TEXT,
+    year INTEGER,
+    raw_value TEXT NOT NULL,
+    inid VARCHAR(20) NOT NULL,
+    matched_by TEXT NOT NULL,
+    row_count INTEGER NOT NULL,
+    remapped_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_institution_remaps_remapped_at ON institution_remaps (remapped_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_course_code_mappings_old_code;
+ALTER TABLE course_code_mappings DROP COLUMN IF EXISTS mapping_reason;
+ALTER TABLE course_code_mappings DROP COLUMN IF EXISTS effective_to;
+ALTER TABLE course_code_mappings DROP COLUMN IF EXISTS effective_from;
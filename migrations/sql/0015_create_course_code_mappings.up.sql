@@ -0,0 +1,16 @@
+-- Old course codes and the codes that replaced them. Databases that
+-- predate migrations already have the table; the effective dates limit a
+-- mapping to the files of the years it applies to.
+CREATE TABLE IF NOT EXISTS course_code_mappings (
+    id SERIAL PRIMARY KEY,
+    year INTEGER,
+    old_course_code VARCHAR(100) NOT NULL,
+    new_course_code VARCHAR(100) NOT NULL,
+    course_name VARCHAR(200),
+    institution_id INTEGER,
+    mapping_date TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE course_code_mappings ADD COLUMN IF NOT EXISTS effective_from DATE;
+ALTER TABLE course_code_mappings ADD COLUMN IF NOT EXISTS effective_to DATE;
+ALTER TABLE course_code_mappings ADD COLUMN IF NOT EXISTS mapping_reason TEXT;
+CREATE INDEX IF NOT EXISTS idx_course_code_mappings_old_code ON course_code_mappings (old_course_code);
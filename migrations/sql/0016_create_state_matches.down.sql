@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS state_value_mappings;
+DROP TABLE IF EXISTS state_matches;
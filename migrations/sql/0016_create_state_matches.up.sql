@@ -0,0 +1,21 @@
+-- State values imports resolved by fuzzy matching, one row per candidate,
+-- kept for review; and the reviewed spellings later imports match exactly
+CREATE TABLE IF NOT EXISTS state_matches (
+    id SERIAL PRIMARY KEY,
+    regnumber VARCHAR(20) NOT NULL,
+    raw_value VARCHAR(100) NOT NULL,
+    state_id INTEGER NOT NULL,
+    distance INTEGER NOT NULL,
+    source_file TEXT,
+    year INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP,
+    corrected_state_id INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_state_matches_pending ON state_matches (raw_value) WHERE reviewed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS state_value_mappings (
+    raw_value VARCHAR(100) PRIMARY KEY,
+    state_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
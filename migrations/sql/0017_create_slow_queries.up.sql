@@ -0,0 +1,16 @@
+-- Application queries that took longer than DB_SLOW_QUERY_THRESHOLD, with
+-- the plan the statement had when it was recorded
+CREATE TABLE IF NOT EXISTS slow_queries (
+    id BIGSERIAL PRIMARY KEY,
+    fingerprint VARCHAR(16) NOT NULL,
+    statement TEXT NOT NULL,
+    params TEXT[],
+    duration_ms DOUBLE PRECISION NOT NULL,
+    rows_affected BIGINT,
+    error TEXT,
+    plan JSONB,
+    source VARCHAR(50),
+    recorded_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_slow_queries_recorded_at ON slow_queries (recorded_at);
+CREATE INDEX IF NOT EXISTS idx_slow_queries_fingerprint ON slow_queries (fingerprint, recorded_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gender_audit;
+DROP TABLE IF EXISTS gender_value_mappings;
@@ -0,0 +1,18 @@
+-- Gender values imports could not read, kept for review; and the reviewed
+-- spellings later imports map exactly
+CREATE TABLE IF NOT EXISTS gender_value_mappings (
+    raw_value VARCHAR(50) PRIMARY KEY,
+    gender CHAR(1) NOT NULL CHECK (gender IN ('M', 'F')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS gender_audit (
+    id SERIAL PRIMARY KEY,
+    regnumber VARCHAR(20) NOT NULL,
+    raw_value VARCHAR(50) NOT NULL,
+    source_file TEXT,
+    year INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_gender_audit_raw_value ON gender_audit (raw_value) WHERE resolved_at IS NULL;
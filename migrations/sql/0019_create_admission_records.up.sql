@@ -0,0 +1,13 @@
+-- Every row of every imported admission file, so admission flags can be
+-- recomputed from them later
+CREATE TABLE IF NOT EXISTS admission_records (
+    id SERIAL PRIMARY KEY,
+    regnumber VARCHAR(20) NOT NULL,
+    year INTEGER NOT NULL,
+    source_file TEXT NOT NULL,
+    is_admitted BOOLEAN NOT NULL,
+    inid VARCHAR(20),
+    app_course1 VARCHAR(100),
+    imported_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_admission_records_year_reg ON admission_records (year, regnumber);
@@ -0,0 +1,9 @@
+-- Other spellings of LGA names; state_id 0 makes an alias apply in every
+-- state
+CREATE TABLE IF NOT EXISTS lga_aliases (
+    id SERIAL PRIMARY KEY,
+    alias VARCHAR(100) NOT NULL,
+    state_id INTEGER NOT NULL DEFAULT 0,
+    lg_id INTEGER NOT NULL REFERENCES lga(lg_id),
+    UNIQUE (alias, state_id)
+);
@@ -0,0 +1,10 @@
+-- Candidate values changed by delta imports and admission reconciliation
+CREATE TABLE IF NOT EXISTS candidate_changes (
+    id SERIAL PRIMARY KEY,
+    regnumber VARCHAR(20) NOT NULL,
+    column_name VARCHAR(50) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    source_file TEXT,
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
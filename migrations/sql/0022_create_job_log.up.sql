@@ -0,0 +1,10 @@
+-- Steps of background and scheduled jobs, with their outcome
+CREATE TABLE IF NOT EXISTS job_log (
+    id SERIAL PRIMARY KEY,
+    job VARCHAR(200) NOT NULL,
+    step VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    detail TEXT,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
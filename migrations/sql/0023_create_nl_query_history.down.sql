@@ -0,0 +1 @@
+DROP TABLE IF EXISTS nl_query_history;
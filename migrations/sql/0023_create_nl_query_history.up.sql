@@ -0,0 +1,11 @@
+-- Natural language questions and the SQL answering them, with embeddings
+-- stored as plain arrays and compared in Go, so no extension is needed
+CREATE TABLE IF NOT EXISTS nl_query_history (
+    id BIGSERIAL PRIMARY KEY,
+    question TEXT NOT NULL,
+    sql_query TEXT NOT NULL,
+    embedding REAL[],
+    asked_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reused INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_nl_query_history_asked_at ON nl_query_history (asked_at);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS subject_scores_by_year;
+DROP TABLE IF EXISTS aggregate_bands_by_year;
+DROP TABLE IF EXISTS applicants_by_institution_year;
+DROP TABLE IF EXISTS applicants_by_state_year;
+DROP TABLE IF EXISTS applicants_by_state_course_year;
+DROP TABLE IF EXISTS relation_freshness;
+DROP SEQUENCE IF EXISTS candidate_data_generation;
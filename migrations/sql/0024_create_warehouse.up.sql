@@ -0,0 +1,68 @@
+-- The warehouse: pre-aggregated yearly fact tables that trend questions read
+-- instead of scanning every candidate, and when each fact table or view was
+-- last rebuilt. Candidate changes bump candidate_data_generation; a
+-- relation is fresh when it was rebuilt at or after the current generation.
+CREATE SEQUENCE IF NOT EXISTS candidate_data_generation;
+CREATE TABLE IF NOT EXISTS relation_freshness (
+    relation TEXT PRIMARY KEY,
+    generation BIGINT NOT NULL,
+    refreshed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS applicants_by_state_course_year (
+    year INTEGER NOT NULL,
+    st_id INTEGER,
+    state_name VARCHAR(100),
+    course_code VARCHAR(100),
+    course_name VARCHAR(200),
+    applicants INTEGER NOT NULL,
+    admitted INTEGER NOT NULL,
+    female INTEGER NOT NULL,
+    male INTEGER NOT NULL,
+    avg_aggregate NUMERIC(6, 2)
+);
+CREATE INDEX IF NOT EXISTS idx_applicants_by_state_course_year_year ON applicants_by_state_course_year (year);
+
+CREATE TABLE IF NOT EXISTS applicants_by_state_year (
+    year INTEGER NOT NULL,
+    st_id INTEGER,
+    state_name VARCHAR(100),
+    applicants INTEGER NOT NULL,
+    admitted INTEGER NOT NULL,
+    female INTEGER NOT NULL,
+    male INTEGER NOT NULL,
+    avg_aggregate NUMERIC(6, 2),
+    median_aggregate NUMERIC(6, 2)
+);
+CREATE INDEX IF NOT EXISTS idx_applicants_by_state_year_year ON applicants_by_state_year (year);
+
+CREATE TABLE IF NOT EXISTS applicants_by_institution_year (
+    year INTEGER NOT NULL,
+    inid VARCHAR(20),
+    institution_name VARCHAR(200),
+    institution_abbreviation VARCHAR(50),
+    applicants INTEGER NOT NULL,
+    admitted INTEGER NOT NULL,
+    female INTEGER NOT NULL,
+    avg_aggregate NUMERIC(6, 2)
+);
+CREATE INDEX IF NOT EXISTS idx_applicants_by_institution_year_year ON applicants_by_institution_year (year);
+
+CREATE TABLE IF NOT EXISTS aggregate_bands_by_year (
+    year INTEGER NOT NULL,
+    band_start INTEGER NOT NULL,
+    candidates INTEGER NOT NULL,
+    admitted INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_aggregate_bands_by_year_year ON aggregate_bands_by_year (year);
+
+CREATE TABLE IF NOT EXISTS subject_scores_by_year (
+    year INTEGER NOT NULL,
+    subject_id INTEGER,
+    subject_name VARCHAR(100),
+    candidates INTEGER NOT NULL,
+    avg_score NUMERIC(6, 2),
+    stddev_score NUMERIC(6, 2),
+    pass_count INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_subject_scores_by_year_year ON subject_scores_by_year (year);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS course_name_audit;
+DROP TABLE IF EXISTS course_name_suggestions;
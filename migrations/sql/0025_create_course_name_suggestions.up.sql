@@ -0,0 +1,25 @@
+-- Suggested names for courses still named after their code, queued for
+-- review, and the renames applied from them
+CREATE TABLE IF NOT EXISTS course_name_suggestions (
+    id SERIAL PRIMARY KEY,
+    course_code VARCHAR(100) NOT NULL REFERENCES course(course_code),
+    current_name VARCHAR(200),
+    suggested_name VARCHAR(200) NOT NULL,
+    source VARCHAR(30) NOT NULL,
+    confidence NUMERIC(4,3),
+    rationale TEXT,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP,
+    UNIQUE (course_code, suggested_name)
+);
+
+CREATE TABLE IF NOT EXISTS course_name_audit (
+    id SERIAL PRIMARY KEY,
+    course_code VARCHAR(100) NOT NULL,
+    old_name VARCHAR(200),
+    new_name VARCHAR(200) NOT NULL,
+    suggestion_id INTEGER REFERENCES course_name_suggestions(id),
+    changed_by VARCHAR(100),
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS normalized_aggregates;
+DROP TABLE IF EXISTS aggregate_formulas;
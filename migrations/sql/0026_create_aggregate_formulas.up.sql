@@ -0,0 +1,16 @@
+-- Per-year aggregate formulas and the aggregates they compute
+CREATE TABLE IF NOT EXISTS aggregate_formulas (
+    year INTEGER PRIMARY KEY,
+    expression TEXT NOT NULL,
+    description TEXT,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS normalized_aggregates (
+    cand_reg_number VARCHAR(20) NOT NULL,
+    year INTEGER NOT NULL,
+    aggregate NUMERIC(10,2),
+    computed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (cand_reg_number, year)
+);
+CREATE INDEX IF NOT EXISTS idx_normalized_aggregates_year ON normalized_aggregates (year);
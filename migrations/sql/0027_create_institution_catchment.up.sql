@@ -0,0 +1,6 @@
+-- Catchment states of an institution beyond its own and affiliated states
+CREATE TABLE IF NOT EXISTS institution_catchment (
+    inid VARCHAR(20) NOT NULL REFERENCES institution(inid),
+    st_id INTEGER NOT NULL REFERENCES state(st_id),
+    PRIMARY KEY (inid, st_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS equating_runs;
+ALTER TABLE candidate DROP COLUMN IF EXISTS equated_aggregate;
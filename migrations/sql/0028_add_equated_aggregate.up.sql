@@ -0,0 +1,11 @@
+-- Aggregates equated to a reference year's scale, NULL for years not
+-- equated, and how each year was equated
+ALTER TABLE candidate ADD COLUMN IF NOT EXISTS equated_aggregate NUMERIC(7,2);
+
+CREATE TABLE IF NOT EXISTS equating_runs (
+    year INTEGER PRIMARY KEY,
+    reference_year INTEGER NOT NULL,
+    method VARCHAR(20) NOT NULL,
+    candidates BIGINT NOT NULL,
+    computed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
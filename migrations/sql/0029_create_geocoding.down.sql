@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS candidate_locations;
+DROP TABLE IF EXISTS geocode_cache;
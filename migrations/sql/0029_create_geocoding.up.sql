@@ -0,0 +1,19 @@
+-- Geocoder answers by query, so a location is only looked up once, and the
+-- coordinates found for candidates by location source
+CREATE TABLE IF NOT EXISTS geocode_cache (
+    query TEXT PRIMARY KEY,
+    lat DOUBLE PRECISION,
+    lng DOUBLE PRECISION,
+    provider VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    geocoded_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS candidate_locations (
+    regnumber VARCHAR(20) NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    lat DOUBLE PRECISION NOT NULL,
+    lng DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (regnumber, source)
+);
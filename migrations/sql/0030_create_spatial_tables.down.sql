@@ -0,0 +1,4 @@
+ALTER TABLE IF EXISTS candidate_locations DROP COLUMN IF EXISTS geom;
+DROP TABLE IF EXISTS institution_locations;
+DROP TABLE IF EXISTS lga_boundaries;
+DROP TABLE IF EXISTS state_boundaries;
@@ -0,0 +1,35 @@
+-- Boundary and location tables for spatial analysis, and a point geometry
+-- on geocoded candidate locations. They need PostGIS, which is optional:
+-- without it this does nothing, and enabling PostGIS from the spatial menu
+-- runs it again. The statements are executed as strings so they are only
+-- parsed once the geometry type is known to exist.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'postgis') THEN
+        RAISE NOTICE 'PostGIS is not installed; the spatial tables are created when it is enabled';
+        RETURN;
+    END IF;
+    EXECUTE 'CREATE TABLE IF NOT EXISTS state_boundaries (
+        st_id INTEGER PRIMARY KEY,
+        geom geometry(MultiPolygon, 4326) NOT NULL,
+        loaded_at TIMESTAMP NOT NULL DEFAULT NOW()
+    )';
+    EXECUTE 'CREATE TABLE IF NOT EXISTS lga_boundaries (
+        lg_id INTEGER PRIMARY KEY,
+        geom geometry(MultiPolygon, 4326) NOT NULL,
+        loaded_at TIMESTAMP NOT NULL DEFAULT NOW()
+    )';
+    EXECUTE 'CREATE TABLE IF NOT EXISTS institution_locations (
+        inid VARCHAR(20) PRIMARY KEY,
+        geom geometry(Point, 4326) NOT NULL,
+        source VARCHAR(20) NOT NULL,
+        updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+    )';
+    EXECUTE 'ALTER TABLE candidate_locations ADD COLUMN IF NOT EXISTS geom geometry(Point, 4326)
+        GENERATED ALWAYS AS (ST_SetSRID(ST_MakePoint(lng, lat), 4326)) STORED';
+    EXECUTE 'CREATE INDEX IF NOT EXISTS idx_state_boundaries_geom ON state_boundaries USING GIST (geom)';
+    EXECUTE 'CREATE INDEX IF NOT EXISTS idx_lga_boundaries_geom ON lga_boundaries USING GIST (geom)';
+    EXECUTE 'CREATE INDEX IF NOT EXISTS idx_institution_locations_geom ON institution_locations USING GIST (geom)';
+    EXECUTE 'CREATE INDEX IF NOT EXISTS idx_candidate_locations_geom ON candidate_locations USING GIST (geom)';
+END
+$$;
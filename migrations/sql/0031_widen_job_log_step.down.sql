@@ -0,0 +1 @@
+ALTER TABLE job_log ALTER COLUMN step TYPE VARCHAR(50) USING LEFT(step, 50);
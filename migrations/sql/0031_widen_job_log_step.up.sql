@@ -0,0 +1,3 @@
+-- Steps name the file they deliver, e.g. delivery:<export file>, which
+-- can be longer than 50 characters
+ALTER TABLE job_log ALTER COLUMN step TYPE TEXT;
@@ -1,35 +1,41 @@
 package models
 
-import (
-	"database/sql"
-	"time"
-)
+import "database/sql"
 
-// Candidate represents the candidates table
+// Candidate represents the candidate table. Disability, exam and score
+// details live in their own tables and are loaded into the sub-structs by
+// CandidateRepository; the legacy is_blind, is_deaf and is_mock_candidate
+// columns on candidate are only read as a fallback.
 type Candidate struct {
-	RegNumber     string         `db:"reg_number" json:"reg_number"`
+	RegNumber     string         `db:"regnumber" json:"reg_number"`
 	Year          int            `db:"year" json:"year"`
-	MaritalStatus sql.NullString `db:"marital_status" json:"marital_status,omitempty"`
+	MaritalStatus sql.NullString `db:"maritalstatus" json:"marital_status,omitempty"`
 	Address       sql.NullString `db:"address" json:"address,omitempty"`
 	Email         sql.NullString `db:"email" json:"email,omitempty"`
-	GSMNo         sql.NullString `db:"gsm_no" json:"gsm_no,omitempty"`
+	GSMNo         sql.NullString `db:"gsmno" json:"gsm_no,omitempty"`
 	Surname       sql.NullString `db:"surname" json:"surname,omitempty"`
-	FirstName     sql.NullString `db:"first_name" json:"first_name,omitempty"`
-	MiddleName    sql.NullString `db:"middle_name" json:"middle_name,omitempty"`
-	DateOfBirth   time.Time      `db:"date_of_birth" json:"date_of_birth"`
+	FirstName     sql.NullString `db:"firstname" json:"first_name,omitempty"`
+	MiddleName    sql.NullString `db:"middlename" json:"middle_name,omitempty"`
+	DateOfBirth   sql.NullTime   `db:"date_of_birth" json:"date_of_birth,omitempty"`
 	Gender        sql.NullString `db:"gender" json:"gender,omitempty"`
-	StateCode     sql.NullInt64  `db:"state_code" json:"state_code,omitempty"`
+	StateCode     sql.NullInt64  `db:"statecode" json:"state_code,omitempty"`
 	LGID          sql.NullInt64  `db:"lg_id" json:"lg_id,omitempty"`
+	Aggregate     sql.NullInt64  `db:"aggregate" json:"aggregate,omitempty"`
+	AppCourse1    sql.NullString `db:"app_course1" json:"app_course1,omitempty"`
+	InID          sql.NullString `db:"inid" json:"inid,omitempty"`
+	NoOfSittings  sql.NullInt64  `db:"noofsittings" json:"no_of_sittings,omitempty"`
 	IsAdmitted    sql.NullBool   `db:"is_admitted" json:"is_admitted,omitempty"`
 	IsDirectEntry sql.NullBool   `db:"is_direct_entry" json:"is_direct_entry,omitempty"`
 	Malpractice   sql.NullString `db:"malpractice" json:"malpractice,omitempty"`
-	CreatedAt     time.Time      `db:"created_at" json:"created_at"`
-	UpdatedAt     time.Time      `db:"updated_at" json:"updated_at"`
+	CreatedAt     sql.NullTime   `db:"created_at" json:"created_at,omitempty"`
+	UpdatedAt     sql.NullTime   `db:"updated_at" json:"updated_at,omitempty"`
 
 	// Relationships
-	State           *State                 `db:"-" json:"state,omitempty"`
-	LGA             *LGA                   `db:"-" json:"lga,omitempty"`
-	Scores          []CandidateScore       `db:"-" json:"scores,omitempty"`
-	Disabilities    *CandidateDisabilities `db:"-" json:"disabilities,omitempty"`
-	ExamInfo        *CandidateExamInfo     `db:"-" json:"exam_info,omitempty"`
+	State        *State                 `db:"-" json:"state,omitempty"`
+	LGA          *LGA                   `db:"-" json:"lga,omitempty"`
+	Institution  *Institution           `db:"-" json:"institution,omitempty"`
+	Course       *Course                `db:"-" json:"course,omitempty"`
+	Scores       []CandidateScore       `db:"-" json:"scores,omitempty"`
+	Disabilities *CandidateDisabilities `db:"-" json:"disabilities,omitempty"`
+	ExamInfo     *CandidateExamInfo     `db:"-" json:"exam_info,omitempty"`
 }
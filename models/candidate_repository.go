@@ -0,0 +1,222 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrCandidateNotFound is returned when no candidate has the registration number
+var ErrCandidateNotFound = errors.New("candidate not found")
+
+// CandidateRepository loads candidates together with their related records
+type CandidateRepository struct {
+	db *sql.DB
+}
+
+func NewCandidateRepository(db *sql.DB) *CandidateRepository {
+	return &CandidateRepository{db: db}
+}
+
+// Get loads a candidate's own columns. Use Load or the individual loaders
+// to fill in scores, disabilities and exam information.
+func (r *CandidateRepository) Get(ctx context.Context, regNumber string) (*Candidate, error) {
+	c := &Candidate{}
+	err := r.db.QueryRowContext(ctx, `
+        SELECT regnumber, year, maritalstatus, address, email, gsmno, surname,
+               firstname, middlename, date_of_birth, gender, statecode, lg_id,
+               aggregate, app_course1, inid, noofsittings, is_admitted,
+               is_direct_entry, malpractice, created_at, updated_at
+        FROM candidate
+        WHERE regnumber = $1`, regNumber).Scan(
+		&c.RegNumber, &c.Year, &c.MaritalStatus, &c.Address, &c.Email, &c.GSMNo, &c.Surname,
+		&c.FirstName, &c.MiddleName, &c.DateOfBirth, &c.Gender, &c.StateCode, &c.LGID,
+		&c.Aggregate, &c.AppCourse1, &c.InID, &c.NoOfSittings, &c.IsAdmitted,
+		&c.IsDirectEntry, &c.Malpractice, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCandidateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading candidate %s: %w", regNumber, err)
+	}
+	return c, nil
+}
+
+// Load gets a candidate with scores, disabilities, exam information and the
+// state, LGA, institution and course they refer to
+func (r *CandidateRepository) Load(ctx context.Context, regNumber string) (*Candidate, error) {
+	c, err := r.Get(ctx, regNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, load := range []func(context.Context, *Candidate) error{r.LoadScores, r.LoadDisabilities, r.LoadExamInfo, r.LoadReferences} {
+		if err := load(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// LoadScores fills in the candidate's subject scores for their year
+func (r *CandidateRepository) LoadScores(ctx context.Context, c *Candidate) error {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT cs.subject_id, COALESCE(cs.score, 0), cs.year, s.su_id,
+               COALESCE(s.su_abrv, ''), COALESCE(s.su_name, '')
+        FROM candidate_scores cs
+        JOIN subject s ON s.su_id = cs.subject_id
+        WHERE cs.cand_reg_number = $1 AND cs.year = $2
+        ORDER BY s.su_name`, c.RegNumber, c.Year)
+	if err != nil {
+		return fmt.Errorf("error loading scores for %s: %w", c.RegNumber, err)
+	}
+	defer rows.Close()
+
+	c.Scores = nil
+	for rows.Next() {
+		score := CandidateScore{CandRegNumber: c.RegNumber, Subject: &Subject{}}
+		if err := rows.Scan(&score.SubjectID, &score.Score, &score.Year,
+			&score.Subject.ID, &score.Subject.Abbreviation, &score.Subject.Name); err != nil {
+			return err
+		}
+		c.Scores = append(c.Scores, score)
+	}
+	return rows.Err()
+}
+
+// LoadDisabilities fills in the candidate's disability information, reading
+// the legacy columns on candidate when there is no candidate_disabilities row
+func (r *CandidateRepository) LoadDisabilities(ctx context.Context, c *Candidate) error {
+	d := &CandidateDisabilities{CandRegNumber: c.RegNumber}
+	err := r.db.QueryRowContext(ctx, `
+        SELECT COALESCE(d.is_blind, c.is_blind, false),
+               COALESCE(d.is_deaf, c.is_deaf, false),
+               COALESCE(d.other_challenges, '')
+        FROM candidate c
+        LEFT JOIN candidate_disabilities d ON d.cand_reg_number = c.regnumber
+        WHERE c.regnumber = $1`, c.RegNumber).Scan(&d.IsBlind, &d.IsDeaf, &d.OtherChallenges)
+	if err != nil {
+		return fmt.Errorf("error loading disabilities for %s: %w", c.RegNumber, err)
+	}
+	c.Disabilities = d
+	return nil
+}
+
+// LoadExamInfo fills in where the candidate sat the exam, reading the legacy
+// is_mock_candidate column on candidate when there is no exam info row
+func (r *CandidateRepository) LoadExamInfo(ctx context.Context, c *Candidate) error {
+	e := &CandidateExamInfo{CandRegNumber: c.RegNumber}
+	var mockState sql.NullInt64
+	err := r.db.QueryRowContext(ctx, `
+        SELECT COALESCE(e.exam_town, ''), COALESCE(e.exam_centre, ''),
+               COALESCE(e.exam_number, ''), e.mock_state_id,
+               COALESCE(e.mock_town, ''),
+               COALESCE(e.is_mock_candidate, c.is_mock_candidate, false)
+        FROM candidate c
+        LEFT JOIN candidate_exam_info e ON e.cand_reg_number = c.regnumber
+        WHERE c.regnumber = $1`, c.RegNumber).Scan(
+		&e.ExamTown, &e.ExamCentre, &e.ExamNumber, &mockState, &e.MockTown, &e.IsMockCandidate)
+	if err != nil {
+		return fmt.Errorf("error loading exam info for %s: %w", c.RegNumber, err)
+	}
+	e.MockStateID = int(mockState.Int64)
+	c.ExamInfo = e
+	return nil
+}
+
+// referencesSQL reads the state, LGA, institution and course candidate $1
+// refers to
+const referencesSQL = `
+    SELECT s.st_id, s.st_abreviation, s.st_name, s.st_elds,
+           l.lg_id, l.lg_name, l.lg_st_id,
+           i.inid, i.inabv, i.inname, i.inst_state_id, i.inst_cat,
+           co.course_code, co.course_name, co.course_abbreviation,
+           co.facid, co.duration, co.degree
+    FROM candidate c
+    LEFT JOIN state s ON s.st_id = c.statecode
+    LEFT JOIN lga l ON l.lg_id = c.lg_id
+    LEFT JOIN institution i ON i.inid = c.inid
+    LEFT JOIN course co ON co.course_code = c.app_course1
+    WHERE c.regnumber = $1`
+
+// LoadReferences fills in the candidate's state, LGA, first choice
+// institution and course. Codes that match no row leave the field nil.
+func (r *CandidateRepository) LoadReferences(ctx context.Context, c *Candidate) error {
+	var stateID, lgaID, lgaStateID sql.NullInt64
+	var stateAbbr, stateName, lgaName sql.NullString
+	var stateELDS sql.NullBool
+	var inID, inAbv, inName, instCat sql.NullString
+	var instStateID sql.NullInt64
+	var courseCode, courseName, courseAbbr, degree sql.NullString
+	var facultyID, duration sql.NullInt64
+	err := r.db.QueryRowContext(ctx, referencesSQL, c.RegNumber).Scan(
+		&stateID, &stateAbbr, &stateName, &stateELDS,
+		&lgaID, &lgaName, &lgaStateID,
+		&inID, &inAbv, &inName, &instStateID, &instCat,
+		&courseCode, &courseName, &courseAbbr, &facultyID, &duration, &degree)
+	if err != nil {
+		return fmt.Errorf("error loading state, LGA, institution and course for %s: %w", c.RegNumber, err)
+	}
+
+	c.State, c.LGA, c.Institution, c.Course = nil, nil, nil, nil
+	if stateID.Valid {
+		c.State = &State{ID: int(stateID.Int64), Abbreviation: stateAbbr.String, Name: stateName.String, ELDS: stateELDS.Bool}
+	}
+	if lgaID.Valid {
+		c.LGA = &LGA{ID: int(lgaID.Int64), Name: lgaName.String, StateID: int(lgaStateID.Int64)}
+	}
+	if inID.Valid {
+		c.Institution = &Institution{InID: inID.String, InAbv: inAbv.String, InName: inName.String,
+			InstStateID: int(instStateID.Int64), InstCat: instCat.String}
+	}
+	if courseCode.Valid {
+		c.Course = &Course{CourseCode: courseCode.String, CourseName: courseName.String, Abbreviation: courseAbbr.String,
+			FacultyID: int(facultyID.Int64), Duration: int(duration.Int64), Degree: degree.String}
+	}
+	return nil
+}
+
+// ConvertLegacyColumns copies the wide is_blind, is_deaf and
+// is_mock_candidate columns of year's candidates into candidate_disabilities
+// and candidate_exam_info, so the dedicated tables become the single source
+// for these details. The legacy columns were just imported, so they
+// overwrite rows already there; a NULL legacy column leaves the row alone.
+func (r *CandidateRepository) ConvertLegacyColumns(ctx context.Context, year int) (disabilities, examInfo int64, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+        INSERT INTO candidate_disabilities (cand_reg_number, is_blind, is_deaf)
+        SELECT c.regnumber, COALESCE(c.is_blind, d.is_blind, false), COALESCE(c.is_deaf, d.is_deaf, false)
+        FROM candidate c
+        LEFT JOIN candidate_disabilities d ON d.cand_reg_number = c.regnumber
+        WHERE c.year = $1 AND (c.is_blind OR c.is_deaf OR d.cand_reg_number IS NOT NULL)
+        ON CONFLICT (cand_reg_number) DO UPDATE
+        SET is_blind = EXCLUDED.is_blind, is_deaf = EXCLUDED.is_deaf, updated_at = NOW()
+        WHERE (candidate_disabilities.is_blind, candidate_disabilities.is_deaf)
+            IS DISTINCT FROM (EXCLUDED.is_blind, EXCLUDED.is_deaf)`, year)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error converting disability columns: %w", err)
+	}
+	disabilities, _ = res.RowsAffected()
+
+	res, err = tx.ExecContext(ctx, `
+        INSERT INTO candidate_exam_info (cand_reg_number, is_mock_candidate)
+        SELECT c.regnumber, c.is_mock_candidate
+        FROM candidate c
+        LEFT JOIN candidate_exam_info e ON e.cand_reg_number = c.regnumber
+        WHERE c.year = $1 AND c.is_mock_candidate IS NOT NULL
+            AND (c.is_mock_candidate OR e.cand_reg_number IS NOT NULL)
+        ON CONFLICT (cand_reg_number) DO UPDATE
+        SET is_mock_candidate = EXCLUDED.is_mock_candidate, updated_at = NOW()
+        WHERE candidate_exam_info.is_mock_candidate IS DISTINCT FROM EXCLUDED.is_mock_candidate`, year)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error converting mock candidate column: %w", err)
+	}
+	examInfo, _ = res.RowsAffected()
+
+	return disabilities, examInfo, tx.Commit()
+}
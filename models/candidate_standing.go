@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrNoAggregate is returned for the standing of a candidate without an
+// aggregate score
+var ErrNoAggregate = errors.New("candidate has no aggregate")
+
+// ErrOtherYear is returned for the standing of a candidate in a year they
+// did not sit in
+var ErrOtherYear = errors.New("candidate did not sit in that year")
+
+// Standing scopes
+const (
+	ScopeNational = "national"
+	ScopeState    = "state"
+	ScopeCourse   = "course"
+)
+
+// StandingLevel is where a candidate's aggregate falls among the year's
+// candidates nationally, from their state, or applying for their course
+type StandingLevel struct {
+	Scope string `json:"scope"`
+	// Group names the state or course, empty for the national level
+	Group      string `json:"group,omitempty"`
+	Candidates int    `json:"candidates"`
+	// Rank is 1 for the highest aggregate, tied aggregates sharing a rank
+	Rank int `json:"rank"`
+	// Percentile is the share of the other candidates with a lower
+	// aggregate, 0-100
+	Percentile float64 `json:"percentile"`
+	Mean       float64 `json:"mean"`
+	StdDev     float64 `json:"stddev"`
+	// ZScore is the aggregate's distance from the mean in standard
+	// deviations; nil when every aggregate is the same
+	ZScore *float64 `json:"z_score"`
+}
+
+// Standing is how a candidate compares with the rest of a year's
+// candidates
+type Standing struct {
+	RegNumber string          `json:"regnumber"`
+	Year      int             `json:"year"`
+	Aggregate int             `json:"aggregate"`
+	Levels    []StandingLevel `json:"levels"`
+}
+
+// standingSQL ranks year $2's candidates with an aggregate nationally, by
+// state and by first choice course in one pass of window functions, and
+// keeps candidate $1's row. Candidates without a state or course are not
+// grouped together at that level.
+const standingSQL = `
+    WITH cohort AS (
+        SELECT regnumber, statecode, app_course1, aggregate
+        FROM candidate
+        WHERE year = $2 AND aggregate IS NOT NULL
+    ), ranked AS (
+        SELECT regnumber, statecode, app_course1, aggregate,
+               COUNT(*) OVER () AS national_n,
+               RANK() OVER (ORDER BY aggregate DESC) AS national_rank,
+               PERCENT_RANK() OVER (ORDER BY aggregate) AS national_pct,
+               AVG(aggregate) OVER () AS national_mean,
+               STDDEV_POP(aggregate) OVER () AS national_sd,
+               COUNT(*) OVER s AS state_n,
+               RANK() OVER (s ORDER BY aggregate DESC) AS state_rank,
+               PERCENT_RANK() OVER (s ORDER BY aggregate) AS state_pct,
+               AVG(aggregate) OVER s AS state_mean,
+               STDDEV_POP(aggregate) OVER s AS state_sd,
+               COUNT(*) OVER co AS course_n,
+               RANK() OVER (co ORDER BY aggregate DESC) AS course_rank,
+               PERCENT_RANK() OVER (co ORDER BY aggregate) AS course_pct,
+               AVG(aggregate) OVER co AS course_mean,
+               STDDEV_POP(aggregate) OVER co AS course_sd
+        FROM cohort
+        WINDOW s AS (PARTITION BY statecode), co AS (PARTITION BY app_course1)
+    )
+    SELECT r.aggregate,
+           r.national_n, r.national_rank, r.national_pct, r.national_mean, r.national_sd,
+           r.statecode IS NOT NULL, COALESCE(st.st_name, r.statecode::text, ''),
+           r.state_n, r.state_rank, r.state_pct, r.state_mean, r.state_sd,
+           COALESCE(r.app_course1, '') <> '', COALESCE(co.course_name, r.app_course1, ''),
+           r.course_n, r.course_rank, r.course_pct, r.course_mean, r.course_sd
+    FROM ranked r
+    LEFT JOIN state st ON st.st_id = r.statecode
+    LEFT JOIN course co ON co.course_code = r.app_course1
+    WHERE r.regnumber = $1`
+
+// Standing computes the candidate's national, state and course percentile
+// and z-score among the candidates of year, or of the candidate's own year
+// when year is 0
+func (r *CandidateRepository) Standing(ctx context.Context, regNumber string, year int) (*Standing, error) {
+	c, err := r.Get(ctx, regNumber)
+	if err != nil {
+		return nil, err
+	}
+	if year == 0 {
+		year = c.Year
+	}
+	if !c.Aggregate.Valid {
+		return nil, ErrNoAggregate
+	}
+	if year != c.Year {
+		return nil, fmt.Errorf("%w: %s sat in %d, not %d", ErrOtherYear, c.RegNumber, c.Year, year)
+	}
+
+	s := &Standing{RegNumber: c.RegNumber, Year: year}
+	national := StandingLevel{Scope: ScopeNational}
+	state := StandingLevel{Scope: ScopeState}
+	course := StandingLevel{Scope: ScopeCourse}
+	var hasState, hasCourse bool
+	err = r.db.QueryRowContext(ctx, standingSQL, c.RegNumber, year).Scan(&s.Aggregate,
+		&national.Candidates, &national.Rank, &national.Percentile, &national.Mean, &national.StdDev,
+		&hasState, &state.Group, &state.Candidates, &state.Rank, &state.Percentile, &state.Mean, &state.StdDev,
+		&hasCourse, &course.Group, &course.Candidates, &course.Rank, &course.Percentile, &course.Mean, &course.StdDev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoAggregate
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error ranking candidate %s: %w", c.RegNumber, err)
+	}
+
+	levels := []StandingLevel{national}
+	if hasState {
+		levels = append(levels, state)
+	}
+	if hasCourse {
+		levels = append(levels, course)
+	}
+	for _, l := range levels {
+		l.Percentile *= 100
+		if l.StdDev > 0 {
+			z := (float64(s.Aggregate) - l.Mean) / l.StdDev
+			l.ZScore = &z
+		}
+		s.Levels = append(s.Levels, l)
+	}
+	return s, nil
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Admission statuses of a verified candidate
+const (
+	AdmissionAdmitted    = "admitted"
+	AdmissionNotAdmitted = "not_admitted"
+	// AdmissionPending is a candidate without an admission decision yet
+	AdmissionPending = "pending"
+)
+
+// VerificationQuery is what an institution knows of an applicant
+type VerificationQuery struct {
+	RegNumber   string
+	Surname     string
+	DateOfBirth time.Time
+}
+
+// Verification answers a VerificationQuery. A candidate exists only when
+// the registration number, surname and date of birth all match, so a
+// mismatch never reveals which of them was wrong.
+type Verification struct {
+	RegNumber       string `json:"regnumber"`
+	Exists          bool   `json:"exists"`
+	Year            int    `json:"year,omitempty"`
+	AdmissionStatus string `json:"admission_status,omitempty"`
+}
+
+// verifySQL matches the queries, numbered from 1 in the order given,
+// against the candidates. Surnames compare without regard to case or
+// spacing.
+const verifySQL = `
+    SELECT q.i, c.year, c.is_admitted
+    FROM unnest($1::text[], $2::text[], $3::date[]) WITH ORDINALITY AS q(regnumber, surname, dob, i)
+    JOIN candidate c ON c.regnumber = q.regnumber
+     AND c.date_of_birth = q.dob
+     AND UPPER(BTRIM(REGEXP_REPLACE(c.surname, '\s+', ' ', 'g'))) = q.surname`
+
+// Verify checks a batch of applicants against the candidates in one query,
+// returning a Verification for each query in order
+func (r *CandidateRepository) Verify(ctx context.Context, queries []VerificationQuery) ([]Verification, error) {
+	results := make([]Verification, len(queries))
+	regNumbers := make([]string, len(queries))
+	surnames := make([]string, len(queries))
+	dates := make([]string, len(queries))
+	for i, q := range queries {
+		results[i].RegNumber = q.RegNumber
+		regNumbers[i] = strings.ToUpper(strings.TrimSpace(q.RegNumber))
+		surnames[i] = strings.ToUpper(strings.Join(strings.Fields(q.Surname), " "))
+		dates[i] = q.DateOfBirth.Format("2006-01-02")
+	}
+	if len(queries) == 0 {
+		return results, nil
+	}
+
+	rows, err := r.db.QueryContext(ctx, verifySQL, pq.Array(regNumbers), pq.Array(surnames), pq.Array(dates))
+	if err != nil {
+		return nil, fmt.Errorf("error verifying candidates: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i, year int
+		var admitted *bool
+		if err := rows.Scan(&i, &year, &admitted); err != nil {
+			return nil, fmt.Errorf("error verifying candidates: %w", err)
+		}
+		v := &results[i-1]
+		v.Exists, v.Year = true, year
+		switch {
+		case admitted == nil:
+			v.AdmissionStatus = AdmissionPending
+		case *admitted:
+			v.AdmissionStatus = AdmissionAdmitted
+		default:
+			v.AdmissionStatus = AdmissionNotAdmitted
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error verifying candidates: %w", err)
+	}
+	return results, nil
+}
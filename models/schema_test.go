@@ -0,0 +1,37 @@
+package models
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nonsonwune/spk2_db/sqllint"
+)
+
+// loadSchemaDump reads the column listing in current_db_state.txt, the
+// schema of the production database
+func loadSchemaDump(t *testing.T) sqllint.Schema {
+	t.Helper()
+	f, err := os.Open("../current_db_state.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	schema, err := sqllint.ReadSchema(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestQueriesMatchSchema(t *testing.T) {
+	schema := loadSchemaDump(t)
+	for name, query := range map[string]string{
+		"references": referencesSQL,
+		"standing":   standingSQL,
+		"verify":     verifySQL,
+	} {
+		if result := schema.Lint(query); !result.OK() {
+			t.Errorf("%s: %s", name, result.Error())
+		}
+	}
+}
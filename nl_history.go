@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/nlquery"
+	"github.com/olekukonko/tablewriter"
+)
+
+// searchQueryHistory finds past natural language questions by meaning and
+// optionally re-runs the SQL that answered one of them
+func searchQueryHistory(ctx context.Context, engine *nlquery.NLQueryEngine) error {
+	fmt.Print("Search past questions for: ")
+	text := readString()
+	if text == "" {
+		return nil
+	}
+	fmt.Print("Only questions from the last N days (blank for all): ")
+	var since time.Time
+	if days := readString(); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of days %q", days)
+		}
+		since = time.Now().AddDate(0, 0, -n)
+	}
+
+	matches, err := engine.SearchHistory(ctx, text, since, 10)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		color.Yellow("No similar past questions found")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Asked", "Similarity", "Question"})
+	for _, m := range matches {
+		table.Append([]string{
+			strconv.FormatInt(m.ID, 10),
+			m.AskedAt.Format("2006-01-02 15:04"),
+			fmt.Sprintf("%.0f%%", m.Similarity*100),
+			m.Question,
+		})
+	}
+	table.Render()
+
+	fmt.Print("Enter an ID to re-run its saved SQL (blank to skip): ")
+	input := readString()
+	if input == "" {
+		return nil
+	}
+	id, err := strconv.ParseInt(input, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid ID %q", input)
+	}
+	for _, m := range matches {
+		if m.ID == id {
+			fmt.Printf("\nSaved SQL:\n%s\n", m.SQL)
+		}
+	}
+	result, err := engine.RunHistory(ctx, id)
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nResults:")
+	fmt.Println("--------")
+	fmt.Println(result)
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/nlquery"
+)
+
+// nlKeyRows lays out Gemini key health for a table
+func nlKeyRows(statuses []nlquery.KeyStatus) ([]string, [][]interface{}) {
+	header := []string{"key", "ends", "status", "requests", "failures", "error_rate", "rest_until", "last_error"}
+	rows := make([][]interface{}, len(statuses))
+	for i, s := range statuses {
+		until := ""
+		if s.RestUntil != nil {
+			until = s.RestUntil.Format("2006-01-02 15:04:05")
+		}
+		rows[i] = []interface{}{s.Name, s.Key, s.Status, s.Requests, s.Failures,
+			fmt.Sprintf("%.1f%%", 100*s.ErrorRate), until, s.LastError}
+	}
+	return header, rows
+}
+
+// showKeyHealth prints the health of the Gemini keys used this session
+func showKeyHealth() {
+	statuses := nlquery.KeyHealth()
+	if len(statuses) == 0 {
+		color.Yellow("No Gemini API keys are set")
+		return
+	}
+	header, rows := nlKeyRows(statuses)
+	table := newResultTable("nl-keys")
+	table.SetHeader([]string{"Key", "Ends", "Status", "Requests", "Failures", "Error Rate", "Resting Until", "Last Error"})
+	for _, row := range rows {
+		cells := make([]string, len(header))
+		for i, v := range row {
+			cells[i] = fmt.Sprint(v)
+		}
+		if len(cells[7]) > 60 {
+			cells[7] = cells[7][:57] + "..."
+		}
+		table.Append(cells)
+	}
+	table.Render()
+	fmt.Printf("Rate-limited keys rest for NL_KEY_COOLDOWN (default %s), doubling on repeats; rejected keys rest for an hour\n",
+		nlquery.DefaultKeyCooldown)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/nlquery"
+)
+
+// nlRows converts natural language query rows for the renderers; the
+// engine writes NULL as "NULL"
+func nlRows(rows [][]string) [][]interface{} {
+	cells := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		cells[i] = make([]interface{}, len(row))
+		for j, v := range row {
+			if v != "NULL" {
+				cells[i][j] = v
+			}
+		}
+	}
+	return cells
+}
+
+// exportNLResult writes the last answer's rows to path as CSV, JSON or a
+// workbook, chosen by its extension
+func exportNLResult(path string) error {
+	if lastShown.name != "nl-query" {
+		return errors.New("no natural language answer to export yet")
+	}
+	format, err := parseResultFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil || format == "table" {
+		return fmt.Errorf("export to a .csv, .json or .xlsx file")
+	}
+	renderer, file, err := newFileRenderer(format, path)
+	if err != nil {
+		return err
+	}
+	err = renderer.Render(lastShown.name, lastShown.header, lastShown.rows)
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	color.Green("Saved %d rows to %s", len(lastShown.rows), path)
+	return nil
+}
+
+// nlQueryLogRows lays logged questions out for writeResult and the menu
+func nlQueryLogRows(entries []nlquery.LoggedQuery) ([]string, [][]interface{}) {
+	header := []string{"id", "asked_at", "source", "status", "rows", "duration_ms", "provider", "question", "sql", "error"}
+	rows := make([][]interface{}, len(entries))
+	for i, q := range entries {
+		rows[i] = []interface{}{q.ID, q.At.Format("2006-01-02 15:04:05"), q.Source, q.Status, q.Rows,
+			q.Duration.Milliseconds(), q.Provider, q.Question, q.SQL, q.Error}
+	}
+	return header, rows
+}
+
+// handleNLQueryLog shows the latest natural language questions asked from
+// the menu, the command line and the API, and the SQL behind one of them
+func handleNLQueryLog(ctx context.Context, db *sql.DB) error {
+	fmt.Print("Only questions from source (menu, cli, api; Enter for all): ")
+	source := strings.ToLower(readString())
+	entries, err := nlquery.QueryLog(ctx, db, source, 30)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		color.Yellow("No questions logged yet")
+		return nil
+	}
+
+	table := newResultTable("nl-query-log")
+	table.SetHeader([]string{"ID", "Asked", "Source", "Status", "Rows", "Time (ms)", "Question"})
+	for _, q := range entries {
+		rows := strconv.Itoa(q.Rows)
+		if q.Status != nlquery.LogSucceeded {
+			rows = "-"
+		}
+		table.Append([]string{
+			strconv.FormatInt(q.ID, 10),
+			q.At.Format("2006-01-02 15:04"),
+			q.Source,
+			q.Status,
+			rows,
+			strconv.FormatInt(q.Duration.Milliseconds(), 10),
+			q.Question,
+		})
+	}
+	color.Cyan("\nNatural Language Query History")
+	table.Render()
+
+	fmt.Print("Enter an ID to see its SQL (blank to return): ")
+	input := readString()
+	if input == "" {
+		return nil
+	}
+	id, err := strconv.ParseInt(input, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid ID %q", input)
+	}
+	for _, q := range entries {
+		if q.ID != id {
+			continue
+		}
+		if q.SQL != "" {
+			fmt.Printf("\nSQL (%s):\n%s\n", q.Provider, q.SQL)
+		}
+		if q.Error != "" {
+			color.Red("Error: %s", q.Error)
+		}
+		return nil
+	}
+	return fmt.Errorf("ID %d is not in the list", id)
+}
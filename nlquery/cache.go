@@ -0,0 +1,230 @@
+package nlquery
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/nonsonwune/spk2_db/sqllint"
+)
+
+// Cache modes, set with NL_CACHE
+const (
+	CacheOff        = "off"
+	CacheMemory     = "memory"     // the default
+	CachePersistent = "persistent" // memory backed by the nlq_cache table
+)
+
+// DefaultCacheSize is how many translations the in-memory cache holds
+const DefaultCacheSize = 500
+
+// translation is a question's generated SQL and what came with it
+type translation struct {
+	SQL            string
+	ThoughtProcess string
+	Explanation    string
+	Provider       string
+}
+
+// translationCache is a least recently used cache of translations shared by
+// every engine in the process, keyed by normalized question and schema hash
+type translationCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type cacheItem struct {
+	key string
+	t   translation
+}
+
+func newTranslationCache(size int) *translationCache {
+	return &translationCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *translationCache) get(key string) (translation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return translation{}, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheItem).t, true
+}
+
+func (c *translationCache) put(key string, t translation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheItem).t = t
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheItem{key: key, t: t})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheItem).key)
+	}
+}
+
+func (c *translationCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+var (
+	sharedCacheOnce sync.Once
+	sharedCache     *translationCache
+	cacheMode       string
+)
+
+// cacheFromEnv returns the process-wide cache and mode from NL_CACHE and
+// NL_CACHE_SIZE; the cache is nil when caching is off
+func cacheFromEnv() (*translationCache, string) {
+	sharedCacheOnce.Do(func() {
+		cacheMode = strings.ToLower(os.Getenv("NL_CACHE"))
+		switch cacheMode {
+		case "":
+			cacheMode = CacheMemory
+		case CacheOff, CacheMemory, CachePersistent:
+		default:
+			log.Printf("Warning: ignoring invalid NL_CACHE %q", cacheMode)
+			cacheMode = CacheMemory
+		}
+		size := DefaultCacheSize
+		if raw := os.Getenv("NL_CACHE_SIZE"); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+				size = n
+			} else {
+				log.Printf("Warning: ignoring invalid NL_CACHE_SIZE %q", raw)
+			}
+		}
+		if cacheMode != CacheOff {
+			sharedCache = newTranslationCache(size)
+		}
+	})
+	return sharedCache, cacheMode
+}
+
+type noCacheKey struct{}
+
+// WithoutCache returns a context whose questions are always sent to the
+// model; the fresh translation still replaces the cached one
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(noCacheKey{}).(bool)
+	return bypass
+}
+
+// normalizeQuestion folds case, whitespace and trailing punctuation so
+// trivially different phrasings of a question share a cache entry
+func normalizeQuestion(q string) string {
+	q = strings.ToLower(strings.Join(strings.Fields(q), " "))
+	return strings.TrimRightFunc(q, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSpace(r) })
+}
+
+// schemaHash identifies the schema a translation was made against, so SQL
+// is not reused after tables or columns change
+func schemaHash(schema sqllint.Schema) string {
+	tables := make([]string, 0, len(schema))
+	for table, columns := range schema {
+		names := make([]string, 0, len(columns))
+		for column := range columns {
+			names = append(names, column)
+		}
+		sort.Strings(names)
+		tables = append(tables, table+"("+strings.Join(names, ",")+")")
+	}
+	sort.Strings(tables)
+	sum := sha256.Sum256([]byte(strings.Join(tables, ";")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// cachedTranslation looks a question up in memory and then, when the cache
+// is persistent, in nlq_cache
+func (e *NLQueryEngine) cachedTranslation(ctx context.Context, question string) (translation, bool) {
+	if e.cache == nil || e.schemaHash == "" || cacheBypassed(ctx) {
+		return translation{}, false
+	}
+	key := normalizeQuestion(question)
+	if t, ok := e.cache.get(e.schemaHash + ":" + key); ok {
+		return t, true
+	}
+	if e.cacheMode != CachePersistent {
+		return translation{}, false
+	}
+	var t translation
+	err := e.db.QueryRowContext(ctx, `
+        UPDATE nlq_cache SET hits = hits + 1, last_hit_at = NOW()
+        WHERE question_key = $1 AND schema_hash = $2
+        RETURNING sql_query, thought_process, explanation, provider`,
+		key, e.schemaHash).Scan(&t.SQL, &t.ThoughtProcess, &t.Explanation, &t.Provider)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Warning: could not read NL cache: %v", err)
+		}
+		return translation{}, false
+	}
+	e.cache.put(e.schemaHash+":"+key, t)
+	return t, true
+}
+
+// cacheTranslation stores the translation of a question that ran
+func (e *NLQueryEngine) cacheTranslation(ctx context.Context, question string, t translation) {
+	if e.cache == nil || e.schemaHash == "" {
+		return
+	}
+	key := normalizeQuestion(question)
+	e.cache.put(e.schemaHash+":"+key, t)
+	if e.cacheMode != CachePersistent {
+		return
+	}
+	_, err := e.db.ExecContext(ctx, `
+        INSERT INTO nlq_cache (question_key, schema_hash, question, sql_query, thought_process, explanation, provider)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (question_key, schema_hash) DO UPDATE
+        SET question = EXCLUDED.question, sql_query = EXCLUDED.sql_query,
+            thought_process = EXCLUDED.thought_process, explanation = EXCLUDED.explanation,
+            provider = EXCLUDED.provider, created_at = NOW()`,
+		key, e.schemaHash, question, t.SQL, t.ThoughtProcess, t.Explanation, t.Provider)
+	if err != nil {
+		log.Printf("Warning: could not save NL cache entry: %v", err)
+	}
+}
+
+// ClearCache empties the in-memory translation cache and, when the cache is
+// persistent, the nlq_cache table, returning how many stored rows were
+// deleted
+func ClearCache(ctx context.Context, db *sql.DB) (int64, error) {
+	cache, mode := cacheFromEnv()
+	if cache != nil {
+		cache.clear()
+	}
+	if mode != CachePersistent {
+		return 0, nil
+	}
+	res, err := db.ExecContext(ctx, "DELETE FROM nlq_cache")
+	if err != nil {
+		return 0, fmt.Errorf("error clearing NL cache: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,61 @@
+package nlquery
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/nlquery/prompts"
+)
+
+// MaxConversationTurns is how many earlier questions are sent with a
+// follow-up; older ones drop out of the conversation
+const MaxConversationTurns = 5
+
+// history returns a copy of the engine's conversation so far
+func (e *NLQueryEngine) history() []prompts.Turn {
+	e.turnsMu.Lock()
+	defer e.turnsMu.Unlock()
+	return append([]prompts.Turn(nil), e.turns...)
+}
+
+// addTurn records an answered question so later questions can refer to it
+func (e *NLQueryEngine) addTurn(question, sql string, set resultSet) {
+	e.turnsMu.Lock()
+	defer e.turnsMu.Unlock()
+	e.turns = append(e.turns, prompts.Turn{Question: question, SQL: sql, Result: summarizeResult(set)})
+	if len(e.turns) > MaxConversationTurns {
+		e.turns = e.turns[len(e.turns)-MaxConversationTurns:]
+	}
+}
+
+// Reset forgets the conversation, so the next question starts afresh
+func (e *NLQueryEngine) Reset() {
+	e.turnsMu.Lock()
+	defer e.turnsMu.Unlock()
+	e.turns = nil
+}
+
+// Conversation returns the questions the engine will treat the next one as
+// a follow-up to, oldest first
+func (e *NLQueryEngine) Conversation() []string {
+	turns := e.history()
+	questions := make([]string, len(turns))
+	for i, t := range turns {
+		questions[i] = t.Question
+	}
+	return questions
+}
+
+// summarizeResult describes a result in a line for the prompt: its size,
+// columns and, when small, its rows
+func summarizeResult(set resultSet) string {
+	summary := fmt.Sprintf("%d rows with columns %s", len(set.rows), strings.Join(set.columns, ", "))
+	if len(set.rows) == 0 || len(set.rows) > 3 {
+		return summary
+	}
+	rows := make([]string, len(set.rows))
+	for i, row := range set.rows {
+		rows[i] = "(" + strings.Join(row, ", ") + ")"
+	}
+	return summary + ": " + strings.Join(rows, " ")
+}
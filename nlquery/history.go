@@ -0,0 +1,145 @@
+package nlquery
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/lib/pq"
+	"github.com/nonsonwune/spk2_db/sqllint"
+)
+
+// embeddingModel embeds questions for semantic search of the query history
+const embeddingModel = "text-embedding-004"
+
+// minHistorySimilarity is the cosine similarity below which a past question
+// is not considered a match
+const minHistorySimilarity = 0.6
+
+// HistoryEntry is a past question with the SQL that answered it
+type HistoryEntry struct {
+	ID         int64
+	Question   string
+	SQL        string
+	AskedAt    time.Time
+	Similarity float64 // to the search text; 1 for a text match without an embedding
+}
+
+// embed returns the embedding of text for the given task
+func (e *NLQueryEngine) embed(ctx context.Context, text string, task genai.TaskType) ([]float32, error) {
+	if e.gemini == nil {
+		return nil, fmt.Errorf("embeddings need a Gemini API key")
+	}
+	return e.gemini.embed(ctx, text, task)
+}
+
+// remember stores a successfully answered question. The question is kept
+// without an embedding if one cannot be generated; it can still be found
+// by its text.
+func (e *NLQueryEngine) remember(ctx context.Context, question, query string) {
+	var embedding interface{}
+	if values, err := e.embed(ctx, question, genai.TaskTypeRetrievalDocument); err != nil {
+		log.Printf("Warning: could not embed question for history: %v", err)
+	} else {
+		embedding = pq.Array(values)
+	}
+	_, err := e.db.ExecContext(ctx,
+		"INSERT INTO nl_query_history (question, sql_query, embedding) VALUES ($1, $2, $3)",
+		question, query, embedding)
+	if err != nil {
+		log.Printf("Warning: could not save query history: %v", err)
+	}
+}
+
+// SearchHistory finds past questions similar in meaning to text, most
+// similar first. Only questions asked since since are considered, unless it
+// is zero. Questions stored without an embedding match when they contain
+// the search text.
+func (e *NLQueryEngine) SearchHistory(ctx context.Context, text string, since time.Time, limit int) ([]HistoryEntry, error) {
+	target, err := e.embed(ctx, text, genai.TaskTypeRetrievalQuery)
+	if err != nil {
+		log.Printf("Warning: could not embed search text, matching words only: %v", err)
+	}
+
+	rows, err := e.db.QueryContext(ctx, `
+        SELECT id, question, sql_query, embedding, asked_at
+        FROM nl_query_history
+        WHERE asked_at >= $1
+        ORDER BY asked_at DESC`, since)
+	if err != nil {
+		return nil, fmt.Errorf("error reading query history: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []HistoryEntry
+	for rows.Next() {
+		var h HistoryEntry
+		var embedding pq.Float32Array
+		if err := rows.Scan(&h.ID, &h.Question, &h.SQL, &embedding, &h.AskedAt); err != nil {
+			return nil, err
+		}
+		switch {
+		case target != nil && len(embedding) == len(target):
+			h.Similarity = cosineSimilarity(target, embedding)
+		case strings.Contains(strings.ToLower(h.Question), strings.ToLower(strings.TrimSpace(text))):
+			h.Similarity = 1
+		}
+		if h.Similarity >= minHistorySimilarity {
+			matches = append(matches, h)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Similarity > matches[j].Similarity })
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// RunHistory re-runs the stored SQL of a past question without asking the
+// model again
+func (e *NLQueryEngine) RunHistory(ctx context.Context, id int64) (string, error) {
+	var query string
+	err := e.db.QueryRowContext(ctx,
+		"UPDATE nl_query_history SET reused = reused + 1 WHERE id = $1 RETURNING sql_query", id).Scan(&query)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no saved query %d", id)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error loading saved query: %w", err)
+	}
+
+	// Saved queries were guarded when generated, but the history table is
+	// writable, so they are checked again
+	query, err = sqllint.Guard(query, e.maxRows)
+	if err != nil {
+		return "", fmt.Errorf("refused saved query: %v", err)
+	}
+	set, err := e.runReadOnly(ctx, query)
+	if err != nil {
+		return "", fmt.Errorf("query failed: %v", err)
+	}
+	return set.text, nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -1,56 +1,287 @@
 package nlquery
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-// KeyManager handles API key rotation
+// DefaultKeyCooldown is how long a rate-limited key rests before it is
+// tried again; NL_KEY_COOLDOWN overrides it. Each further rate limit in a
+// row doubles the rest, up to 16 times the cooldown.
+const DefaultKeyCooldown = time.Minute
+
+const (
+	maxCooldownDoublings = 4
+	// invalidKeyCooldown rests keys the API rejected as invalid or
+	// unauthorised; a replaced key is tracked afresh
+	invalidKeyCooldown = time.Hour
+	// keyFailureStreak other errors in a row also rest a key for the cooldown
+	keyFailureStreak = 3
+)
+
+// Key health statuses
+const (
+	KeyHealthy     = "healthy"
+	KeyCoolingDown = "cooling down"
+	KeyInvalid     = "invalid"
+)
+
+type keyFailure int
+
+const (
+	keyFailureOther keyFailure = iota
+	keyFailureRateLimit
+	keyFailureInvalid
+)
+
+// keyStats is one API key's health, shared by every engine that uses the
+// key so a key rested by one question is skipped by the next
+type keyStats struct {
+	requests   int
+	failures   int
+	streak     int // failures in a row
+	rateLimits int // rate limits in a row
+	lastError  string
+	until      time.Time // resting until
+	invalid    bool
+}
+
+var (
+	keyHealthMu sync.Mutex
+	keyHealth   = map[string]*keyStats{}
+)
+
+type apiKey struct {
+	name  string // the variable it came from, e.g. GEMINI_API_KEY_2
+	value string
+	stats *keyStats
+}
+
+// KeyStatus is a key's health for diagnostics. Only the last four
+// characters of the key are shown.
+type KeyStatus struct {
+	Name      string     `json:"name"`
+	Key       string     `json:"key"`
+	Status    string     `json:"status"`
+	Requests  int        `json:"requests"`
+	Failures  int        `json:"failures"`
+	ErrorRate float64    `json:"error_rate"`
+	RestUntil *time.Time `json:"rest_until,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// KeyManager rotates through the Gemini API keys, skipping keys that are
+// resting after rate limits or rejections
 type KeyManager struct {
-	keys     []string
+	keys     []*apiKey
 	current  uint32
-	mu       sync.RWMutex
+	cooldown time.Duration
 }
 
 // NewKeyManager creates a new key manager with available API keys
 func NewKeyManager() *KeyManager {
-	keys := make([]string, 0)
-	
-	// Load all available API keys
+	km := &KeyManager{cooldown: keyCooldown()}
+
+	keyHealthMu.Lock()
+	defer keyHealthMu.Unlock()
 	for i := 1; i <= 4; i++ {
-		key := os.Getenv(fmt.Sprintf("GEMINI_API_KEY_%d", i))
-		if key != "" {
-			keys = append(keys, key)
+		name := fmt.Sprintf("GEMINI_API_KEY_%d", i)
+		key := os.Getenv(name)
+		if key == "" {
+			continue
+		}
+		stats := keyHealth[key]
+		if stats == nil {
+			stats = &keyStats{}
+			keyHealth[key] = stats
 		}
+		km.keys = append(km.keys, &apiKey{name: name, value: key, stats: stats})
 	}
-	
-	return &KeyManager{
-		keys:    keys,
-		current: 0,
+	return km
+}
+
+// keyCooldown reads NL_KEY_COOLDOWN, e.g. "2m"
+func keyCooldown() time.Duration {
+	if raw := os.Getenv("NL_KEY_COOLDOWN"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: ignoring invalid NL_KEY_COOLDOWN %q", raw)
 	}
+	return DefaultKeyCooldown
 }
 
-// GetNextKey returns the next API key in rotation
+// GetNextKey returns the next API key in rotation that is not resting, or
+// "" if there is none
 func (km *KeyManager) GetNextKey() string {
-	km.mu.RLock()
-	defer km.mu.RUnlock()
-	
-	if len(km.keys) == 0 {
+	key, _ := km.next()
+	if key == nil {
 		return ""
 	}
-	
-	// Atomically increment and wrap around
-	current := atomic.AddUint32(&km.current, 1)
-	index := (current - 1) % uint32(len(km.keys))
-	
-	return km.keys[index]
+	return key.value
+}
+
+// next returns the next key that is not resting. When every key is resting
+// it returns nil and how long until the first is usable again.
+func (km *KeyManager) next() (*apiKey, time.Duration) {
+	if len(km.keys) == 0 {
+		return nil, 0
+	}
+	keyHealthMu.Lock()
+	defer keyHealthMu.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(-1)
+	for range km.keys {
+		// Atomically increment and wrap around
+		current := atomic.AddUint32(&km.current, 1)
+		key := km.keys[(current-1)%uint32(len(km.keys))]
+		if !now.Before(key.stats.until) {
+			return key, 0
+		}
+		if d := key.stats.until.Sub(now); wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	return nil, wait
 }
 
-// MarkKeyFailed can be used to temporarily disable a key that's failing
-// This is a placeholder for future implementation of key health tracking
-func (km *KeyManager) MarkKeyFailed(key string) {
-	// TODO: Implement key health tracking and temporary disablement
-	// For now, we just continue rotating
+func (km *KeyManager) find(key string) *apiKey {
+	for _, k := range km.keys {
+		if k.value == key {
+			return k
+		}
+	}
+	return nil
+}
+
+// MarkKeySucceeded records a successful call with key, ending any rest
+func (km *KeyManager) MarkKeySucceeded(key string) {
+	k := km.find(key)
+	if k == nil {
+		return
+	}
+	keyHealthMu.Lock()
+	defer keyHealthMu.Unlock()
+	k.stats.requests++
+	k.stats.streak, k.stats.rateLimits = 0, 0
+	k.stats.until, k.stats.invalid = time.Time{}, false
+}
+
+// MarkKeyFailed records a failed call with key. Rate-limited and rejected
+// keys, and keys failing repeatedly, are rested so the next call rotates to
+// another key; it reports whether key was rested.
+func (km *KeyManager) MarkKeyFailed(key string, err error) bool {
+	k := km.find(key)
+	if k == nil || err == nil {
+		return false
+	}
+	keyHealthMu.Lock()
+	defer keyHealthMu.Unlock()
+
+	s := k.stats
+	s.requests++
+	s.failures++
+	s.streak++
+	s.lastError = err.Error()
+
+	var rest time.Duration
+	switch classifyKeyError(err) {
+	case keyFailureRateLimit:
+		rest = km.cooldown << min(s.rateLimits, maxCooldownDoublings)
+		s.rateLimits++
+	case keyFailureInvalid:
+		rest = invalidKeyCooldown
+		s.invalid = true
+	default:
+		if s.streak >= keyFailureStreak {
+			rest = km.cooldown
+		}
+	}
+	if rest == 0 {
+		return false
+	}
+	s.until = time.Now().Add(rest)
+	log.Printf("Warning: resting %s for %s after: %v", k.name, rest, err)
+	return true
+}
+
+// classifyKeyError tells rate limits and rejected keys, which are the key's
+// fault, from other errors
+func classifyKeyError(err error) keyFailure {
+	var coded interface{ HTTPCode() int }
+	if errors.As(err, &coded) {
+		switch coded.HTTPCode() {
+		case 429:
+			return keyFailureRateLimit
+		case 401, 403:
+			return keyFailureInvalid
+		}
+	}
+	text := strings.ToLower(err.Error())
+	for _, marker := range []string{"resourceexhausted", "resource_exhausted", "rate limit", "quota"} {
+		if strings.Contains(text, marker) {
+			return keyFailureRateLimit
+		}
+	}
+	for _, marker := range []string{"api key not valid", "api_key_invalid", "permissiondenied", "permission_denied", "unauthenticated"} {
+		if strings.Contains(text, marker) {
+			return keyFailureInvalid
+		}
+	}
+	return keyFailureOther
+}
+
+// Health reports each key's calls, errors and rest in this process
+func (km *KeyManager) Health() []KeyStatus {
+	keyHealthMu.Lock()
+	defer keyHealthMu.Unlock()
+
+	now := time.Now()
+	statuses := make([]KeyStatus, len(km.keys))
+	for i, k := range km.keys {
+		s := k.stats
+		st := KeyStatus{
+			Name:      k.name,
+			Key:       maskKey(k.value),
+			Status:    KeyHealthy,
+			Requests:  s.requests,
+			Failures:  s.failures,
+			LastError: s.lastError,
+		}
+		if s.requests > 0 {
+			st.ErrorRate = float64(s.failures) / float64(s.requests)
+		}
+		if now.Before(s.until) {
+			until := s.until
+			st.RestUntil = &until
+			st.Status = KeyCoolingDown
+			if s.invalid {
+				st.Status = KeyInvalid
+			}
+		}
+		statuses[i] = st
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// KeyHealth reports the health of the configured Gemini keys as seen by the
+// engines in this process
+func KeyHealth() []KeyStatus {
+	return NewKeyManager().Health()
+}
+
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "..." + key[len(key)-4:]
 }
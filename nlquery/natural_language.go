@@ -3,23 +3,35 @@ package nlquery
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"regexp"
+	"log"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/google/generative-ai-go/genai"
 	"github.com/nonsonwune/spk2_db/nlquery/prompts"
-	"google.golang.org/api/option"
+	"github.com/nonsonwune/spk2_db/sqllint"
+	"github.com/nonsonwune/spk2_db/stats"
 )
 
 type NLQueryEngine struct {
-	client        *genai.Client
-	model         *genai.GenerativeModel
-	db            *sql.DB
-	promptBuilder *prompts.PromptBuilder
-	keyManager    *KeyManager
+	providers       []provider
+	providerTimeout time.Duration
+	gemini          *geminiProvider // nil without Gemini keys; needed for embeddings
+	db              *sql.DB
+	queryDB         *sql.DB // runs generated SQL; db unless SetQueryDB chose a replica
+	promptBuilder   *prompts.PromptBuilder
+	schema          sqllint.Schema // nil if introspection failed; linting is then skipped
+	maxRows         int            // LIMIT cap on generated SQL
+	cache           *translationCache
+	cacheMode       string
+	schemaHash      string // blank without a schema; translations are then not cached
+
+	turnsMu sync.Mutex
+	turns   []prompts.Turn // the conversation so far, for follow-up questions
+
+	source     string // recorded with each question in query_history
+	logQueries bool
 }
 
 type QueryResult struct {
@@ -27,83 +39,121 @@ type QueryResult struct {
 	SQLQuery      string
 	Explanation   string
 	Results       string
+	Provider      string // which provider in the chain generated SQLQuery
+
+	// Columns and Rows are the rows behind Results, with NULL as "NULL"
+	Columns []string
+	Rows    [][]string
+}
+
+// Options adjusts an engine built by NewNLQueryEngineWithOptions. The zero
+// value gives the engine NewNLQueryEngine builds.
+type Options struct {
+	QueryDB         *sql.DB       // runs generated SQL; the engine's db when nil
+	Providers       string        // provider chain, e.g. "rules"; NL_PROVIDERS when blank
+	ProviderTimeout time.Duration // per-attempt limit; NL_PROVIDER_TIMEOUT when 0
+	MaxRows         int           // LIMIT cap on generated SQL; NL_MAX_ROWS when 0
+	Source          string        // logged with each question, e.g. "menu"; DefaultLogSource when blank
+
+	// SkipIntrospection leaves out the fact table and schema lookups made at
+	// construction, for callers such as tests whose db cannot answer them.
+	// Generated SQL is then not linted, and questions are not logged.
+	SkipIntrospection bool
 }
 
+// NewNLQueryEngine builds an engine on an open database with the default
+// options
 func NewNLQueryEngine(db *sql.DB) (*NLQueryEngine, error) {
-	keyManager := NewKeyManager()
-	if len(keyManager.keys) == 0 {
-		return nil, fmt.Errorf("no API keys available")
-	}
+	return NewNLQueryEngineWithOptions(db, Options{})
+}
 
-	client, err := genai.NewClient(context.Background(), option.WithAPIKey(keyManager.GetNextKey()))
+// NewNLQueryEngineWithOptions builds an engine on an open database, such
+// as the application's pool or a sqlmock. The engine never opens or closes
+// connections itself.
+func NewNLQueryEngineWithOptions(db *sql.DB, opts Options) (*NLQueryEngine, error) {
+	providers, err := newProviders(opts.Providers)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Gemini client: %v", err)
+		return nil, err
+	}
+	timeout := opts.ProviderTimeout
+	if timeout <= 0 {
+		timeout = providerTimeout()
+	}
+	maxRows := opts.MaxRows
+	if maxRows <= 0 {
+		maxRows = maxRowsFromEnv()
 	}
 
-	model := client.GenerativeModel("gemini-1.5-flash")
-	model.SetTemperature(0.2)
-
-	return &NLQueryEngine{
-		client:        client,
-		model:         model,
-		db:            db,
-		promptBuilder: prompts.NewPromptBuilder(),
-		keyManager:    keyManager,
-	}, nil
-}
+	promptBuilder := prompts.NewPromptBuilder()
+	var schema sqllint.Schema
+	if !opts.SkipIntrospection {
+		if facts, err := stats.AvailableFacts(context.Background(), db); err != nil {
+			log.Printf("Warning: could not check for fact tables: %v", err)
+		} else if len(facts) > 0 {
+			promptBuilder.SetFactTables(stats.DescribeFacts(facts))
+		}
 
-func (e *NLQueryEngine) generateWithRetry(ctx context.Context, prompt string) (string, error) {
-	var lastErr error
-	maxRetries := 3
-	baseDelay := 2 * time.Second
-
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		if attempt > 1 {
-			fmt.Printf("\nRetrying API call (attempt %d/%d)...\n", attempt, maxRetries)
-			
-			// Get a new API key for the retry
-			key := e.keyManager.GetNextKey()
-			client, err := genai.NewClient(ctx, option.WithAPIKey(key))
-			if err != nil {
-				continue
-			}
-			e.client = client
-			e.model = client.GenerativeModel("gemini-1.5-flash")
-			e.model.SetTemperature(0.2)
+		if description, err := schemaContext(context.Background(), db); err != nil {
+			log.Printf("Warning: could not introspect schema, using the built-in description: %v", err)
+		} else {
+			promptBuilder.SetSchema(description)
 		}
 
-		// Create a context with timeout for this attempt
-		timeoutCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
-		defer cancel()
-
-		resp, err := e.model.GenerateContent(timeoutCtx, genai.Text(prompt))
-		if err != nil {
-			lastErr = err
-			// Mark the current key as failed and try the next one
-			e.keyManager.MarkKeyFailed("")
-			time.Sleep(baseDelay * time.Duration(attempt))
-			continue
+		if schema, err = sqllint.LoadSchema(context.Background(), db); err != nil {
+			log.Printf("Warning: could not load schema for SQL linting: %v", err)
 		}
+	}
 
-		if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-			if text, ok := resp.Candidates[0].Content.Parts[0].(genai.Text); ok {
-				return string(text), nil
-			}
+	queryDB := opts.QueryDB
+	if queryDB == nil {
+		queryDB = db
+	}
+	engine := &NLQueryEngine{
+		providers:       providers,
+		providerTimeout: timeout,
+		db:              db,
+		queryDB:         queryDB,
+		promptBuilder:   promptBuilder,
+		schema:          schema,
+		maxRows:         maxRows,
+		source:          opts.Source,
+		logQueries:      queryLogEnabled() && !opts.SkipIntrospection,
+	}
+	if engine.source == "" {
+		engine.source = DefaultLogSource
+	}
+	engine.cache, engine.cacheMode = cacheFromEnv()
+	if schema != nil {
+		engine.schemaHash = schemaHash(schema)
+	}
+	for _, p := range providers {
+		if gemini, ok := p.(*geminiProvider); ok {
+			engine.gemini = gemini
 		}
-		lastErr = fmt.Errorf("unexpected response type")
-		time.Sleep(baseDelay * time.Duration(attempt))
 	}
-	return "", fmt.Errorf("all retries failed: %v", lastErr)
+	return engine, nil
+}
+
+// SetQueryDB runs generated SQL on db, e.g. a read-only replica. Question
+// history is still written through the database the engine was created with.
+func (e *NLQueryEngine) SetQueryDB(db *sql.DB) {
+	e.queryDB = db
+}
+
+// Providers names the providers in the engine's fallback chain, in order
+func (e *NLQueryEngine) Providers() []string {
+	names := make([]string, len(e.providers))
+	for i, p := range e.providers {
+		names[i] = p.name()
+	}
+	return names
 }
 
-func cleanJSONResponse(resp string) string {
-    // Remove any markdown formatting
-    resp = strings.ReplaceAll(resp, "```json", "")
-    resp = strings.ReplaceAll(resp, "```", "")
-    resp = strings.ReplaceAll(resp, "`", "")
-    // Trim any whitespace before and after
-    resp = strings.TrimSpace(resp)
-    return resp
+// GenerateText sends a free-form prompt to the provider chain, with the same
+// retries and fallback used for query generation.
+func (e *NLQueryEngine) GenerateText(ctx context.Context, prompt string) (string, error) {
+	text, _, err := e.generate(ctx, generationRequest{task: taskText, prompt: prompt})
+	return text, err
 }
 
 func cleanSQLQuery(sql string) string {
@@ -114,32 +164,6 @@ func cleanSQLQuery(sql string) string {
     return sql
 }
 
-func extractSQLFromResponse(resp string) (string, error) {
-    // Clean the response first
-    resp = cleanJSONResponse(resp)
-    
-    // Try to parse as JSON
-    var result struct {
-        SQLQuery string `json:"sql_query"`
-    }
-    
-    if err := json.Unmarshal([]byte(resp), &result); err != nil {
-        // If JSON parsing fails, try to extract SQL directly using regex
-        sqlPattern := `(?i)SELECT\s+.*?(?:;|$)`
-        re := regexp.MustCompile(sqlPattern)
-        if matches := re.FindString(resp); matches != "" {
-            return cleanSQLQuery(matches), nil
-        }
-        return "", fmt.Errorf("failed to extract SQL query: %v\nResponse was: %s", err, resp)
-    }
-    
-    if result.SQLQuery == "" {
-        return "", fmt.Errorf("no SQL query found in response")
-    }
-    
-    return cleanSQLQuery(result.SQLQuery), nil
-}
-
 func (e *NLQueryEngine) cleanSQLResponse(sql string) string {
 	// Remove markdown code block markers
 	sql = strings.TrimPrefix(sql, "```sql")
@@ -153,82 +177,184 @@ func (e *NLQueryEngine) cleanSQLResponse(sql string) string {
 }
 
 func (e *NLQueryEngine) ProcessQuery(query string) (string, error) {
-    ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+    result, err := e.ProcessQueryContext(context.Background(), query)
+    if err != nil {
+        return "", err
+    }
+    return result.Results, nil
+}
+
+// ProcessQueryContext is ProcessQuery under ctx, e.g. one made by
+// WithoutCache, returning the whole result
+func (e *NLQueryEngine) ProcessQueryContext(ctx context.Context, query string) (*QueryResult, error) {
+    ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
     defer cancel()
 
     fmt.Println("\nAnalyzing query...")
-    
+    result, err := e.Answer(ctx, query)
+    if result != nil {
+        if result.ThoughtProcess != "" {
+            fmt.Printf("\nThought Process:\n%s\n", result.ThoughtProcess)
+        }
+        if result.SQLQuery != "" {
+            fmt.Printf("\nGenerated SQL (%s):\n%s\n", result.Provider, result.SQLQuery)
+        }
+        if result.Explanation != "" {
+            fmt.Printf("\nExplanation:\n%s\n", result.Explanation)
+        }
+    }
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// Answer generates SQL for a question, has the model validate it, runs it
+// and formats the rows. On failure the result holds whatever was generated
+// before the error. Every question is logged to query_history unless
+// NL_QUERY_LOG is off. Answer does not write to stdout, so it can serve API
+// requests.
+func (e *NLQueryEngine) Answer(ctx context.Context, query string) (*QueryResult, error) {
+    start := time.Now()
+    result, err := e.answer(ctx, query)
+    e.logQuery(ctx, query, result, err, time.Since(start))
+    return result, err
+}
+
+func (e *NLQueryEngine) answer(ctx context.Context, query string) (*QueryResult, error) {
+    result := &QueryResult{}
+
+    // A follow-up depends on the questions before it, so only the first
+    // question of a conversation uses the translation cache
+    history := e.history()
+    if len(history) == 0 {
+        if cached, ok := e.cachedTranslation(ctx, query); ok {
+            result.ThoughtProcess, result.Explanation = cached.ThoughtProcess, cached.Explanation
+            result.SQLQuery, result.Provider = cached.SQL, "cache ("+cached.Provider+")"
+            guarded, err := sqllint.Guard(cached.SQL, e.maxRows)
+            if err != nil {
+                return result, fmt.Errorf("refused cached SQL: %v", err)
+            }
+            set, err := e.runReadOnly(ctx, guarded)
+            if err != nil {
+                return result, fmt.Errorf("query failed: %v", err)
+            }
+            result.Results, result.Columns, result.Rows = set.text, set.columns, set.rows
+            e.addTurn(query, guarded, set)
+            return result, nil
+        }
+    }
+
     // Generate SQL query with retry
-    prompt := e.promptBuilder.BuildQueryPrompt(query)
-    resp, err := e.generateWithRetry(ctx, prompt)
+    prompt := e.promptBuilder.BuildFollowUpPrompt(query, history)
+    resp, provider, err := e.generate(ctx, generationRequest{task: taskQuery, prompt: prompt, question: query, followUp: len(history) > 0})
     if err != nil {
-        return "", fmt.Errorf("failed to generate SQL: %v", err)
+        return result, fmt.Errorf("failed to generate SQL: %v", err)
     }
 
-    // Extract and display thought process if available
-    if strings.Contains(resp, "thought_process") {
-        var result struct {
-            ThoughtProcess string `json:"thought_process"`
+    // Parse the structured response, recovering the SQL if it is malformed
+    parsed, err := ParseQueryResponse(resp)
+    if err != nil {
+        return result, fmt.Errorf("failed to extract SQL: %v\nResponse was: %s", err, resp)
+    }
+    if parsed.Fallback != "" {
+        log.Printf("Warning: %s response was not valid JSON; used the %s", provider, parsed.Fallback)
+    }
+    result.ThoughtProcess = parsed.ThoughtProcess
+    result.Explanation = parsed.Explanation
+    sql := parsed.SQLQuery
+    result.SQLQuery = sql
+    result.Provider = provider
+
+    // Lint locally first so the validation call is only spent on SQL that
+    // parses and references real tables and columns
+    if e.schema != nil {
+        lint := e.schema.Lint(sql)
+        if len(lint.Fixes) > 0 {
+            log.Printf("SQL lint fixed: %s", strings.Join(lint.Fixes, "; "))
+            sql = lint.SQL
+            result.SQLQuery = sql
         }
-        cleanResp := cleanJSONResponse(resp)
-        if err := json.Unmarshal([]byte(cleanResp), &result); err == nil && result.ThoughtProcess != "" {
-            fmt.Printf("\nThought Process:\n%s\n", result.ThoughtProcess)
+        if !lint.OK() {
+            return result, fmt.Errorf("invalid SQL generated: %s", lint.Error())
         }
     }
 
-    // Extract SQL query
-    sql, err := extractSQLFromResponse(resp)
+    // Only a single read-only SELECT with a bounded row count is run,
+    // whatever the model returned
+    guarded, err := sqllint.Guard(sql, e.maxRows)
     if err != nil {
-        return "", fmt.Errorf("failed to extract SQL: %v\nResponse was: %s", err, resp)
+        return result, fmt.Errorf("refused generated SQL: %v", err)
     }
+    sql = guarded
+    result.SQLQuery = sql
 
-    fmt.Printf("\nGenerated SQL:\n%s\n", sql)
-
-    fmt.Println("\nValidating query...")
-    
     // Validate the generated SQL with retry
-    validationPrompt := e.promptBuilder.BuildValidationPrompt(query, sql)
-    validation, err := e.generateWithRetry(ctx, validationPrompt)
+    validationPrompt := e.promptBuilder.BuildFollowUpValidationPrompt(query, sql, history)
+    validation, _, err := e.generate(ctx, generationRequest{task: taskValidate, prompt: validationPrompt, question: query, sql: sql})
     if err != nil {
-        return "", fmt.Errorf("failed to validate SQL: %v", err)
+        return result, fmt.Errorf("failed to validate SQL: %v", err)
     }
 
     validation = strings.TrimSpace(validation)
     if !strings.EqualFold(validation, "VALID") {
-        return "", fmt.Errorf("invalid SQL generated: %s", validation)
+        return result, fmt.Errorf("invalid SQL generated: %s", validation)
     }
 
-    fmt.Println("\nExecuting query...")
-    
     // Execute the SQL query
-    rows, err := e.db.QueryContext(ctx, sql)
+    set, err := e.runReadOnly(ctx, sql)
     if err != nil {
         // Generate user-friendly error message with retry
         errorPrompt := e.promptBuilder.BuildErrorPrompt(query, err)
-        errorMsg, genErr := e.generateWithRetry(ctx, errorPrompt)
+        errorMsg, _, genErr := e.generate(ctx, generationRequest{task: taskExplainError, prompt: errorPrompt, question: query, sql: sql})
         if genErr == nil {
-            return "", fmt.Errorf(errorMsg)
+            return result, fmt.Errorf("%s", errorMsg)
         }
-        return "", fmt.Errorf("query failed: %v", err)
+        return result, fmt.Errorf("query failed: %v", err)
     }
-    defer rows.Close()
+    result.Results, result.Columns, result.Rows = set.text, set.columns, set.rows
 
-    fmt.Println("\nFormatting results...")
-    
-    // Format results
-    results, err := formatResults(rows)
-    if err != nil {
-        return "", fmt.Errorf("failed to format results: %v", err)
+    if len(history) == 0 {
+        e.cacheTranslation(ctx, query, translation{
+            SQL: sql, ThoughtProcess: result.ThoughtProcess, Explanation: result.Explanation, Provider: provider,
+        })
     }
+    e.addTurn(query, sql, set)
+    e.remember(ctx, query, sql)
+    return result, nil
+}
+
+// runReadOnly runs query in a read-only transaction on the query database
+// and formats the rows, so a statement that slipped past Guard still
+// cannot write
+func (e *NLQueryEngine) runReadOnly(ctx context.Context, query string) (resultSet, error) {
+	tx, err := e.queryDB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return resultSet{}, err
+	}
+	// Nothing is written, so the transaction is always rolled back
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return resultSet{}, err
+	}
+	defer rows.Close()
+	return formatResults(rows)
+}
 
-    return results, nil
+// resultSet is a query's rows as text and as cells
+type resultSet struct {
+	text    string
+	columns []string
+	rows    [][]string
 }
 
-func formatResults(rows *sql.Rows) (string, error) {
+func formatResults(rows *sql.Rows) (resultSet, error) {
     // Get column names
     columns, err := rows.Columns()
     if err != nil {
-        return "", fmt.Errorf("failed to get column names: %v", err)
+        return resultSet{}, fmt.Errorf("failed to get column names: %v", err)
     }
 
     // Prepare values holder
@@ -266,7 +392,7 @@ func formatResults(rows *sql.Rows) (string, error) {
     for rows.Next() {
         err = rows.Scan(valuePtrs...)
         if err != nil {
-            return "", fmt.Errorf("failed to scan row: %v", err)
+            return resultSet{}, fmt.Errorf("failed to scan row: %v", err)
         }
 
         // Convert row to strings
@@ -290,7 +416,7 @@ func formatResults(rows *sql.Rows) (string, error) {
     }
 
     if err = rows.Err(); err != nil {
-        return "", fmt.Errorf("error iterating rows: %v", err)
+        return resultSet{}, fmt.Errorf("error iterating rows: %v", err)
     }
 
     // Write rows with proper padding
@@ -310,5 +436,5 @@ func formatResults(rows *sql.Rows) (string, error) {
         result.WriteString(fmt.Sprintf("\nTotal rows: %d\n", len(allRows)))
     }
 
-    return result.String(), nil
+    return resultSet{text: result.String(), columns: columns, rows: allRows}, nil
 }
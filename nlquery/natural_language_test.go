@@ -0,0 +1,177 @@
+package nlquery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// unavailableModel points the OpenAI provider at a server that is always
+// down, so questions fall back to the rule-based provider without leaving
+// the machine
+func unavailableModel(t *testing.T) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+}
+
+// newMockEngine builds an engine whose history is written to one sqlmock
+// and whose generated SQL runs on another
+func newMockEngine(t *testing.T, maxRows int) (*NLQueryEngine, sqlmock.Sqlmock, sqlmock.Sqlmock) {
+	t.Helper()
+	unavailableModel(t)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	queryDB, queryMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Error creating mock query database: %v", err)
+	}
+	t.Cleanup(func() { queryDB.Close() })
+
+	engine, err := NewNLQueryEngineWithOptions(db, Options{
+		QueryDB:           queryDB,
+		Providers:         "openai,rules",
+		MaxRows:           maxRows,
+		Source:            "test",
+		SkipIntrospection: true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating NL query engine: %v", err)
+	}
+	return engine, mock, queryMock
+}
+
+func TestNewNLQueryEngineWithOptions(t *testing.T) {
+	engine, mock, queryMock := newMockEngine(t, 25)
+	if got, want := engine.Providers(), []string{"openai", "rules"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Providers() = %v, want %v", got, want)
+	}
+	if engine.maxRows != 25 || engine.source != "test" {
+		t.Errorf("maxRows = %d, source = %q; want 25 and test", engine.maxRows, engine.source)
+	}
+	if engine.schema != nil || engine.logQueries {
+		t.Error("engine built without introspection lints or logs questions")
+	}
+	// Nothing is queried while the engine is built
+	for _, m := range []sqlmock.Sqlmock{mock, queryMock} {
+		if err := m.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := NewNLQueryEngineWithOptions(nil, Options{Providers: "rules,oracle", SkipIntrospection: true}); err == nil {
+		t.Error("engine built with an unknown provider")
+	}
+	if _, err := NewNLQueryEngineWithOptions(nil, Options{Providers: "rules", SkipIntrospection: true}); err == nil {
+		t.Error("engine built without a language model")
+	}
+}
+
+func TestNewNLQueryEngineWithOptionsDefaultsQueryDB(t *testing.T) {
+	unavailableModel(t)
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+	engine, err := NewNLQueryEngineWithOptions(db, Options{Providers: "openai,rules", SkipIntrospection: true})
+	if err != nil {
+		t.Fatalf("Error creating NL query engine: %v", err)
+	}
+	if engine.queryDB != db {
+		t.Error("generated SQL does not run on the engine's database by default")
+	}
+	if engine.source != DefaultLogSource {
+		t.Errorf("source = %q, want %q", engine.source, DefaultLogSource)
+	}
+}
+
+func TestNLQueryEngine_Answer(t *testing.T) {
+	const (
+		question = "How many candidates applied in 2023?"
+		want     = "SELECT COUNT(*) AS candidates FROM candidate c WHERE c.year = 2023\nLIMIT 25"
+	)
+	engine, mock, queryMock := newMockEngine(t, 25)
+
+	queryMock.ExpectBegin()
+	queryMock.ExpectQuery(want).WillReturnRows(sqlmock.NewRows([]string{"candidates"}).AddRow(42))
+	queryMock.ExpectRollback()
+	// Without a Gemini key the question is remembered without an embedding
+	mock.ExpectExec("INSERT INTO nl_query_history (question, sql_query, embedding) VALUES ($1, $2, $3)").
+		WithArgs(question, want, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	result, err := engine.Answer(context.Background(), question)
+	if err != nil {
+		t.Fatalf("Answer() error = %v", err)
+	}
+	if result.SQLQuery != want || result.Provider != "rules" {
+		t.Errorf("SQL = %q from %q, want %q from rules", result.SQLQuery, result.Provider, want)
+	}
+	if !reflect.DeepEqual(result.Columns, []string{"candidates"}) || !reflect.DeepEqual(result.Rows, [][]string{{"42"}}) {
+		t.Errorf("result = %v %v, want [candidates] [[42]]", result.Columns, result.Rows)
+	}
+
+	for _, m := range []sqlmock.Sqlmock{mock, queryMock} {
+		if err := m.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	}
+}
+
+func TestNLQueryEngine_AnswerErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		query   string
+		dbError error // returned by the generated query; nothing is run when nil
+		wantErr string
+	}{
+		{
+			name:    "No rule matches",
+			query:   "What is the meaning of life?",
+			wantErr: "failed to generate SQL",
+		},
+		{
+			name:    "Query fails",
+			query:   "How many candidates are there?",
+			dbError: errors.New("canceling statement due to statement timeout"),
+			wantErr: "query failed: canceling statement due to statement timeout",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, mock, queryMock := newMockEngine(t, 25)
+			if tc.dbError != nil {
+				queryMock.ExpectBegin()
+				queryMock.ExpectQuery("SELECT COUNT(*) AS candidates FROM candidate c WHERE TRUE\nLIMIT 25").
+					WillReturnError(tc.dbError)
+				queryMock.ExpectRollback()
+			}
+
+			_, err := engine.Answer(context.Background(), tc.query)
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantErr) {
+				t.Errorf("Answer() error = %v, want %q", err, tc.wantErr)
+			}
+			// A failed question is not remembered
+			for _, m := range []sqlmock.Sqlmock{mock, queryMock} {
+				if err := m.ExpectationsWereMet(); err != nil {
+					t.Errorf("there were unfulfilled expectations: %s", err)
+				}
+			}
+		})
+	}
+}
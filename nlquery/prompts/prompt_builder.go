@@ -107,6 +107,7 @@ func (a *SchemaAgent) Process(query string) (string, error) {
 // PromptBuilder handles the construction of prompts for the LLM
 type PromptBuilder struct {
     schemaContext string
+    factContext   string
 }
 
 func NewPromptBuilder() *PromptBuilder {
@@ -117,12 +118,73 @@ func NewPromptBuilder() *PromptBuilder {
     }
 }
 
+// SetSchema replaces the built-in schema description with one read from
+// the database
+func (pb *PromptBuilder) SetSchema(description string) {
+    pb.schemaContext = description
+}
+
+// SetFactTables describes the pre-aggregated yearly fact tables available
+// to queries; trend questions are steered towards them
+func (pb *PromptBuilder) SetFactTables(description string) {
+    pb.factContext = description
+}
+
+// factSection is the prompt text introducing the fact tables, empty when
+// there are none
+func (pb *PromptBuilder) factSection() string {
+    if pb.factContext == "" {
+        return ""
+    }
+    return fmt.Sprintf(`
+Pre-aggregated fact tables (one row per group and year, kept current after every import):
+%s
+Prefer these fact tables for trends across years and for totals or averages by year, state, course,
+institution, aggregate band or subject. They are far smaller than candidate, so queries on them are
+much faster. Add up their count columns, e.g. SUM(applicants), rather than using COUNT(*), and weight averages by
+their counts when combining groups. Use candidate only when the question needs individual candidates
+or filters on a column the fact tables do not have.
+`, pb.factContext)
+}
+
+// Turn is an earlier question in a conversation, the SQL that answered it
+// and a summary of its result
+type Turn struct {
+    Question string
+    SQL      string
+    Result   string
+}
+
+// conversationSection is the prompt text giving the earlier turns of a
+// conversation, empty for its first question
+func conversationSection(history []Turn) string {
+    if len(history) == 0 {
+        return ""
+    }
+    var b strings.Builder
+    b.WriteString(`
+Conversation so far, oldest first. The question may be a follow-up that refers to these, e.g.
+"now break that down by gender" or "only for 2022"; if so, build on the most recent SQL and keep
+its filters unless the question changes them:
+`)
+    for i, t := range history {
+        fmt.Fprintf(&b, "%d. Question: %s\n   SQL: %s\n   Result: %s\n", i+1, t.Question, t.SQL, t.Result)
+    }
+    return b.String()
+}
+
 func (pb *PromptBuilder) BuildQueryPrompt(query string) string {
+    return pb.BuildFollowUpPrompt(query, nil)
+}
+
+// BuildFollowUpPrompt is BuildQueryPrompt for a question asked after the
+// turns in history
+func (pb *PromptBuilder) BuildFollowUpPrompt(query string, history []Turn) string {
     return fmt.Sprintf(`You are a SQL query generator for a JAMB database system. Your task is to convert natural language questions into SQL queries.
 
 Database Schema:
 %s
-
+%s%s
 User Question: %s
 
 Instructions:
@@ -181,7 +243,7 @@ Example Responses:
     "thought_process": "1. User wants list of candidates\n2. Join state table\n3. Filter by state\n4. No grouping needed",
     "sql_query": "SELECT c.regnumber, c.firstname, c.surname, c.gender FROM candidate c JOIN state s ON c.statecode = s.st_id WHERE s.st_name = 'LAGOS' AND c.year = 2023",
     "explanation": "Lists all candidates from Lagos state in 2023"
-}`, pb.schemaContext, query)
+}`, pb.schemaContext, pb.factSection(), conversationSection(history), query)
 }
 
 func (pb *PromptBuilder) BuildErrorPrompt(query string, err error) string {
@@ -194,8 +256,14 @@ Return ONLY the explanation with NO markdown formatting or code blocks.`, err, q
 }
 
 func (pb *PromptBuilder) BuildValidationPrompt(query, sql string) string {
-    return fmt.Sprintf(`Validate this SQL query for the JAMB database:
+    return pb.BuildFollowUpValidationPrompt(query, sql, nil)
+}
 
+// BuildFollowUpValidationPrompt is BuildValidationPrompt for a question
+// asked after the turns in history
+func (pb *PromptBuilder) BuildFollowUpValidationPrompt(query, sql string, history []Turn) string {
+    return fmt.Sprintf(`Validate this SQL query for the JAMB database:
+%s
 Original Question: %s
 
 Generated SQL:
@@ -203,7 +271,7 @@ Generated SQL:
 
 Database Schema:
 %s
-
+%s
 Return "VALID" if the query is correct, or explain the specific issues if invalid. Check for:
 1. Correct table and column names
 2. Proper JOIN conditions
@@ -211,7 +279,7 @@ Return "VALID" if the query is correct, or explain the specific issues if invali
 4. Appropriate GROUP BY if using aggregations
 5. No syntax errors
 
-Return ONLY "VALID" or a specific error message.`, query, sql, pb.schemaContext)
+Return ONLY "VALID" or a specific error message.`, conversationSection(history), query, sql, pb.schemaContext, pb.factSection())
 }
 
 func (pb *PromptBuilder) ExtractYear(query string) string {
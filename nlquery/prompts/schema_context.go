@@ -98,6 +98,7 @@ Tables:
     - course_name: varchar(200), Course name
     - institution_id: integer, Institution identifier
     - mapping_date: timestamp, When mapping was created
+    - effective_from, effective_to: date, Years of the files the mapping applies to (NULL for open-ended)
 
 Common Query Patterns:
 
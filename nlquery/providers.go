@@ -0,0 +1,495 @@
+package nlquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// DefaultProviders is the provider chain used when NL_PROVIDERS is not set
+const DefaultProviders = "gemini,openai,rules"
+
+// defaultProviderTimeout bounds each provider's attempt, retries included,
+// before the next provider in the chain is tried
+const defaultProviderTimeout = 45 * time.Second
+
+// task says what a generation request is for, so providers that do not use
+// the prompt text can still answer it
+type task int
+
+const (
+	taskText task = iota
+	taskQuery
+	taskValidate
+	taskExplainError
+)
+
+type generationRequest struct {
+	task     task
+	prompt   string
+	question string // the user's question, for query and validation tasks
+	sql      string // the generated SQL, for validation tasks
+	followUp bool   // the question refers to earlier ones in the prompt
+}
+
+// provider generates text for the engine's prompts
+type provider interface {
+	name() string
+	// languageModel reports whether the provider is a language model, as opposed to
+	// a fallback that can only handle some tasks
+	languageModel() bool
+	generate(ctx context.Context, req generationRequest) (string, error)
+}
+
+// newProviders builds the chain named by spec, or by NL_PROVIDERS when spec
+// is blank, e.g. "gemini,openai,rules", skipping providers whose API keys
+// are not set
+func newProviders(spec string) ([]provider, error) {
+	if spec == "" {
+		spec = os.Getenv("NL_PROVIDERS")
+	}
+	if spec == "" {
+		spec = DefaultProviders
+	}
+
+	var chain []provider
+	for _, name := range strings.Split(spec, ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "gemini":
+			if keys := NewKeyManager(); len(keys.keys) > 0 {
+				chain = append(chain, newGeminiProvider(keys))
+			}
+		case "openai":
+			if key := os.Getenv("OPENAI_API_KEY"); key != "" {
+				chain = append(chain, newOpenAIProvider(key))
+			}
+		case "rules":
+			chain = append(chain, rulesProvider{})
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown NL provider %q in NL_PROVIDERS", name)
+		}
+	}
+
+	for _, p := range chain {
+		if p.languageModel() {
+			return chain, nil
+		}
+	}
+	return nil, fmt.Errorf("no API keys available")
+}
+
+// CheckSettings reports every problem with the NL_* and API key settings
+// without building the providers, so they can be listed at startup rather
+// than on the first question
+func CheckSettings() []string {
+	var problems []string
+	spec := os.Getenv("NL_PROVIDERS")
+	if spec == "" {
+		spec = DefaultProviders
+	}
+	languageModel := false
+	for _, name := range strings.Split(spec, ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "gemini":
+			if len(NewKeyManager().keys) > 0 {
+				languageModel = true
+			}
+		case "openai":
+			if os.Getenv("OPENAI_API_KEY") != "" {
+				languageModel = true
+			}
+		case "rules", "":
+		default:
+			problems = append(problems, fmt.Sprintf("NL_PROVIDERS names unknown provider %q (use gemini, openai or rules)", name))
+		}
+	}
+	if !languageModel {
+		problems = append(problems, fmt.Sprintf("no API key for the providers in NL_PROVIDERS (%s): set GEMINI_API_KEY_1 (up to GEMINI_API_KEY_4) or OPENAI_API_KEY", spec))
+	}
+	if raw := os.Getenv("NL_PROVIDER_TIMEOUT"); raw != "" {
+		if d, err := time.ParseDuration(raw); err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("NL_PROVIDER_TIMEOUT %q is not a duration such as 30s", raw))
+		}
+	}
+	if raw := os.Getenv("NL_KEY_COOLDOWN"); raw != "" {
+		if d, err := time.ParseDuration(raw); err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("NL_KEY_COOLDOWN %q is not a duration such as 1m", raw))
+		}
+	}
+	if raw := os.Getenv("NL_MAX_ROWS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil || n <= 0 {
+			problems = append(problems, fmt.Sprintf("NL_MAX_ROWS %q is not a positive whole number", raw))
+		}
+	}
+	switch mode := strings.ToLower(os.Getenv("NL_CACHE")); mode {
+	case "", CacheOff, CacheMemory, CachePersistent:
+	default:
+		problems = append(problems, fmt.Sprintf("NL_CACHE %q must be off, memory or persistent", mode))
+	}
+	if raw := os.Getenv("NL_CACHE_SIZE"); raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil || n <= 0 {
+			problems = append(problems, fmt.Sprintf("NL_CACHE_SIZE %q is not a positive whole number", raw))
+		}
+	}
+	switch raw := strings.ToLower(os.Getenv("NL_QUERY_LOG")); raw {
+	case "", "on", "true", "1", "off", "false", "0":
+	default:
+		problems = append(problems, fmt.Sprintf("NL_QUERY_LOG %q must be on or off", raw))
+	}
+	return problems
+}
+
+// DefaultMaxRows caps the rows generated SQL may return
+const DefaultMaxRows = 1000
+
+// maxRowsFromEnv reads NL_MAX_ROWS
+func maxRowsFromEnv() int {
+	if raw := os.Getenv("NL_MAX_ROWS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Warning: ignoring invalid NL_MAX_ROWS %q", raw)
+	}
+	return DefaultMaxRows
+}
+
+// providerTimeout reads NL_PROVIDER_TIMEOUT, e.g. "30s"
+func providerTimeout() time.Duration {
+	if raw := os.Getenv("NL_PROVIDER_TIMEOUT"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: ignoring invalid NL_PROVIDER_TIMEOUT %q", raw)
+	}
+	return defaultProviderTimeout
+}
+
+// generate tries each provider in order until one answers, returning the
+// text and the name of the provider that produced it
+func (e *NLQueryEngine) generate(ctx context.Context, req generationRequest) (string, string, error) {
+	var failures []string
+	for _, p := range e.providers {
+		if ctx.Err() != nil {
+			break
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, e.providerTimeout)
+		text, err := p.generate(attemptCtx, req)
+		cancel()
+		if err == nil {
+			return text, p.name(), nil
+		}
+		log.Printf("Warning: %s provider failed: %v", p.name(), err)
+		failures = append(failures, fmt.Sprintf("%s: %v", p.name(), err))
+	}
+	if err := ctx.Err(); err != nil {
+		failures = append(failures, err.Error())
+	}
+	return "", "", fmt.Errorf("all providers failed (%s)", strings.Join(failures, "; "))
+}
+
+// geminiProvider calls Gemini, rotating to the next API key when a key is
+// rate limited or rejected
+type geminiProvider struct {
+	keyManager *KeyManager
+	mu         sync.Mutex
+	clients    map[string]*genai.Client // by API key, created on first use
+}
+
+func newGeminiProvider(keys *KeyManager) *geminiProvider {
+	return &geminiProvider{keyManager: keys, clients: make(map[string]*genai.Client)}
+}
+
+// client returns the client for key, connecting on its first use
+func (p *geminiProvider) client(ctx context.Context, key *apiKey) (*genai.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c := p.clients[key.value]; c != nil {
+		return c, nil
+	}
+	c, err := genai.NewClient(ctx, option.WithAPIKey(key.value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %v", err)
+	}
+	p.clients[key.value] = c
+	return c, nil
+}
+
+func (p *geminiProvider) name() string { return "gemini" }
+
+func (p *geminiProvider) languageModel() bool { return true }
+
+func (p *geminiProvider) generate(ctx context.Context, req generationRequest) (string, error) {
+	var lastErr error
+	maxRetries := 3
+	baseDelay := 2 * time.Second
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		key, wait := p.keyManager.next()
+		if key == nil {
+			if lastErr == nil {
+				lastErr = fmt.Errorf("every API key is resting after errors")
+			}
+			return "", fmt.Errorf("%v; next key available in %s", lastErr, wait.Round(time.Second))
+		}
+		if attempt > 1 {
+			log.Printf("Retrying Gemini call with %s (attempt %d/%d)", key.name, attempt, maxRetries)
+		}
+
+		text, err := p.call(ctx, key, req.prompt)
+		if err == nil {
+			return text, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		lastErr = fmt.Errorf("%s: %w", key.name, err)
+		if p.keyManager.MarkKeyFailed(key.value, err) {
+			// The key is resting; the next one can be tried straight away
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(baseDelay * time.Duration(attempt)):
+		}
+	}
+	return "", fmt.Errorf("all retries failed: %v", lastErr)
+}
+
+// call sends prompt with key, recording a success against the key. The
+// caller records failures.
+func (p *geminiProvider) call(ctx context.Context, key *apiKey, prompt string) (string, error) {
+	client, err := p.client(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	model := client.GenerativeModel("gemini-1.5-flash")
+	model.SetTemperature(0.2)
+	// Create a context with timeout for this attempt
+	timeoutCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	resp, err := model.GenerateContent(timeoutCtx, genai.Text(prompt))
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	p.keyManager.MarkKeySucceeded(key.value)
+	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil && len(resp.Candidates[0].Content.Parts) > 0 {
+		if text, ok := resp.Candidates[0].Content.Parts[0].(genai.Text); ok {
+			return string(text), nil
+		}
+	}
+	return "", fmt.Errorf("unexpected response type")
+}
+
+// embed returns the embedding of text with the next usable key
+func (p *geminiProvider) embed(ctx context.Context, text string, task genai.TaskType) ([]float32, error) {
+	key, wait := p.keyManager.next()
+	if key == nil {
+		return nil, fmt.Errorf("every Gemini API key is resting; next available in %s", wait.Round(time.Second))
+	}
+	client, err := p.client(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	em := client.EmbeddingModel(embeddingModel)
+	em.TaskType = task
+	timeoutCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	resp, err := em.EmbedContent(timeoutCtx, genai.Text(text))
+	if err != nil {
+		if ctx.Err() == nil {
+			p.keyManager.MarkKeyFailed(key.value, err)
+		}
+		return nil, err
+	}
+	p.keyManager.MarkKeySucceeded(key.value)
+	if resp.Embedding == nil || len(resp.Embedding.Values) == 0 {
+		return nil, fmt.Errorf("empty embedding")
+	}
+	return resp.Embedding.Values, nil
+}
+
+// CheckKeys sends a short prompt with every Gemini key, resting keys included,
+// and returns their health afterwards. A key that answers is no longer rested.
+func CheckKeys(ctx context.Context) ([]KeyStatus, error) {
+	keys := NewKeyManager()
+	if len(keys.keys) == 0 {
+		return nil, fmt.Errorf("no Gemini API keys are set (GEMINI_API_KEY_1 to GEMINI_API_KEY_4)")
+	}
+	p := newGeminiProvider(keys)
+	for _, key := range keys.keys {
+		if _, err := p.call(ctx, key, "Reply with the word OK."); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			keys.MarkKeyFailed(key.value, err)
+		}
+	}
+	return keys.Health(), nil
+}
+
+// openAIProvider calls an OpenAI-compatible chat completions endpoint;
+// OPENAI_BASE_URL and OPENAI_MODEL select another server or model
+type openAIProvider struct {
+	apiKey    string
+	baseURL   string
+	modelName string
+	client    *http.Client
+}
+
+func newOpenAIProvider(apiKey string) *openAIProvider {
+	p := &openAIProvider{
+		apiKey:    apiKey,
+		baseURL:   strings.TrimRight(os.Getenv("OPENAI_BASE_URL"), "/"),
+		modelName: os.Getenv("OPENAI_MODEL"),
+		client:    &http.Client{},
+	}
+	if p.baseURL == "" {
+		p.baseURL = "https://api.openai.com/v1"
+	}
+	if p.modelName == "" {
+		p.modelName = "gpt-4o-mini"
+	}
+	return p
+}
+
+func (p *openAIProvider) name() string { return "openai" }
+
+func (p *openAIProvider) languageModel() bool { return true }
+
+func (p *openAIProvider) generate(ctx context.Context, req generationRequest) (string, error) {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	body, err := json.Marshal(struct {
+		Model       string    `json:"model"`
+		Messages    []message `json:"messages"`
+		Temperature float64   `json:"temperature"`
+	}{p.modelName, []message{{Role: "user", Content: req.prompt}}, 0.2})
+	if err != nil {
+		return "", err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	var parsed struct {
+		Choices []struct {
+			Message message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+	if len(parsed.Choices) == 0 || parsed.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("empty response")
+	}
+	return parsed.Choices[0].Message.Content, nil
+}
+
+// rulesProvider answers a few common questions from SQL templates, so basic
+// counts still work when every model provider is down. It only checks that
+// SQL is a single read-only statement and cannot answer free-form prompts.
+type rulesProvider struct{}
+
+func (rulesProvider) name() string { return "rules" }
+
+func (rulesProvider) languageModel() bool { return false }
+
+var (
+	ruleYear  = regexp.MustCompile(`\b(19|20)\d{2}\b`)
+	ruleTopN  = regexp.MustCompile(`\btop\s+(\d+)\b`)
+	ruleWrite = regexp.MustCompile(`(?i)\b(insert|update|delete|drop|alter|create|truncate|grant|revoke|copy)\b`)
+)
+
+func (r rulesProvider) generate(ctx context.Context, req generationRequest) (string, error) {
+	switch req.task {
+	case taskQuery:
+		if req.followUp {
+			return "", fmt.Errorf("rule-based fallback cannot answer follow-up questions")
+		}
+		return r.query(req.question)
+	case taskValidate:
+		sql := strings.TrimSuffix(strings.TrimSpace(req.sql), ";")
+		lower := strings.ToLower(sql)
+		if strings.Contains(sql, ";") || ruleWrite.MatchString(sql) ||
+			!(strings.HasPrefix(lower, "select") || strings.HasPrefix(lower, "with")) {
+			return "only single read-only SELECT statements are accepted without a model", nil
+		}
+		return "VALID", nil
+	default:
+		return "", fmt.Errorf("rule-based fallback cannot answer this prompt")
+	}
+}
+
+// query matches the question against the templates
+func (rulesProvider) query(question string) (string, error) {
+	q := strings.ToLower(question)
+	where := "TRUE"
+	if year := ruleYear.FindString(q); year != "" {
+		where = "c.year = " + year
+	}
+	counting := strings.Contains(q, "how many") || strings.Contains(q, "count") || strings.Contains(q, "number of")
+
+	var rule, sql string
+	switch {
+	case ruleTopN.MatchString(q) && (strings.Contains(q, "candidate") || strings.Contains(q, "score") || strings.Contains(q, "performer")):
+		n, _ := strconv.Atoi(ruleTopN.FindStringSubmatch(q)[1])
+		if n < 1 || n > 100 {
+			n = 10
+		}
+		rule = "top candidates by aggregate"
+		sql = fmt.Sprintf("SELECT c.regnumber, c.surname, c.firstname, c.aggregate FROM candidate c WHERE %s AND c.aggregate IS NOT NULL ORDER BY c.aggregate DESC LIMIT %d", where, n)
+	case (strings.Contains(q, "average") || strings.Contains(q, "mean")) && (strings.Contains(q, "score") || strings.Contains(q, "aggregate")):
+		rule = "average aggregate"
+		sql = fmt.Sprintf("SELECT ROUND(AVG(c.aggregate), 2) AS average_aggregate FROM candidate c WHERE %s", where)
+	case counting && (strings.Contains(q, "gender") || strings.Contains(q, "male")):
+		rule = "candidates by gender"
+		sql = fmt.Sprintf("SELECT c.gender, COUNT(*) AS candidates FROM candidate c WHERE %s GROUP BY c.gender ORDER BY candidates DESC", where)
+	case counting && strings.Contains(q, "state"):
+		rule = "candidates by state"
+		sql = fmt.Sprintf("SELECT s.st_name AS state, COUNT(*) AS candidates FROM candidate c JOIN state s ON s.st_id = c.statecode WHERE %s GROUP BY s.st_name ORDER BY candidates DESC", where)
+	case counting && (strings.Contains(q, "candidate") || strings.Contains(q, "student") || strings.Contains(q, "applicant")):
+		rule = "candidate count"
+		sql = fmt.Sprintf("SELECT COUNT(*) AS candidates FROM candidate c WHERE %s", where)
+	default:
+		return "", fmt.Errorf("no built-in rule matches the question")
+	}
+
+	resp, err := json.Marshal(map[string]string{
+		"thought_process": "Matched the question to the built-in \"" + rule + "\" template",
+		"sql_query":       sql,
+		"explanation":     "Answered without a language model, so only the year in the question is taken into account",
+	})
+	return string(resp), err
+}
@@ -0,0 +1,109 @@
+package nlquery
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Query log statuses
+const (
+	LogSucceeded = "succeeded"
+	LogFailed    = "failed"
+)
+
+// DefaultLogSource marks logged questions from engines given no Source
+const DefaultLogSource = "nl"
+
+// LoggedQuery is a question's entry in query_history
+type LoggedQuery struct {
+	ID       int64
+	Source   string // where the question was asked: menu, cli, api
+	Question string
+	SQL      string
+	Provider string
+	Status   string
+	Error    string
+	Rows     int
+	Duration time.Duration
+	At       time.Time
+}
+
+// queryLogEnabled reads NL_QUERY_LOG; questions are logged unless it is off
+func queryLogEnabled() bool {
+	switch strings.ToLower(os.Getenv("NL_QUERY_LOG")) {
+	case "off", "false", "0":
+		return false
+	}
+	return true
+}
+
+// logQuery appends a processed question to query_history, whether or not
+// it was answered
+func (e *NLQueryEngine) logQuery(ctx context.Context, question string, result *QueryResult, answerErr error, took time.Duration) {
+	if !e.logQueries {
+		return
+	}
+	entry := LoggedQuery{Source: e.source, Question: question, Status: LogSucceeded, Duration: took}
+	if result != nil {
+		entry.SQL, entry.Provider, entry.Rows = result.SQLQuery, result.Provider, len(result.Rows)
+	}
+	var errText, sqlText, provider sql.NullString
+	var rows sql.NullInt64
+	if answerErr != nil {
+		entry.Status = LogFailed
+		errText = sql.NullString{String: answerErr.Error(), Valid: true}
+	} else {
+		rows = sql.NullInt64{Int64: int64(entry.Rows), Valid: true}
+	}
+	if entry.SQL != "" {
+		sqlText = sql.NullString{String: entry.SQL, Valid: true}
+	}
+	if entry.Provider != "" {
+		provider = sql.NullString{String: entry.Provider, Valid: true}
+	}
+
+	// The question's own context may have been cancelled or timed out
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	_, err := e.db.ExecContext(ctx, `
+        INSERT INTO query_history (source, query_text, sql_query, row_count, duration_ms, error, status, provider)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		entry.Source, question, sqlText, rows, took.Milliseconds(), errText, entry.Status, provider)
+	if err != nil {
+		log.Printf("Warning: could not log NL query: %v", err)
+	}
+}
+
+// QueryLog returns the latest limit logged questions, newest first, from
+// one source or from all when source is blank
+func QueryLog(ctx context.Context, db *sql.DB, source string, limit int) ([]LoggedQuery, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, source, query_text, COALESCE(sql_query, ''), COALESCE(provider, ''), status,
+               COALESCE(error, ''), COALESCE(row_count, 0), COALESCE(duration_ms, 0), executed_at
+        FROM query_history
+        WHERE $1 = '' OR source = $1
+        ORDER BY executed_at DESC, id DESC
+        LIMIT $2`, source, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error reading query history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []LoggedQuery
+	for rows.Next() {
+		var q LoggedQuery
+		var ms int64
+		if err := rows.Scan(&q.ID, &q.Source, &q.Question, &q.SQL, &q.Provider, &q.Status,
+			&q.Error, &q.Rows, &ms, &q.At); err != nil {
+			return nil, err
+		}
+		q.Duration = time.Duration(ms) * time.Millisecond
+		entries = append(entries, q)
+	}
+	return entries, rows.Err()
+}
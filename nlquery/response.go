@@ -0,0 +1,188 @@
+package nlquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// QueryResponse is the JSON object BuildQueryPrompt asks the model for
+type QueryResponse struct {
+	ThoughtProcess string `json:"thought_process"`
+	SQLQuery       string `json:"sql_query"`
+	Explanation    string `json:"explanation"`
+	// Fallback names how the SQL was recovered when the response was not a
+	// valid object; empty when it was
+	Fallback string `json:"-"`
+}
+
+// queryResponseFields are the fields of the response schema; sql_query is
+// required and all of them must be strings
+var queryResponseFields = []string{"thought_process", "sql_query", "explanation"}
+
+var (
+	jsonFieldPattern = `"%s"\s*:\s*"((?:[^"\\]|\\.)*)"`
+	sqlFencePattern  = regexp.MustCompile("(?is)```sql\\s*(.*?)```")
+	bareSQLPattern   = regexp.MustCompile(`(?is)\b(?:WITH|SELECT)\s.*?(?:;|$)`)
+)
+
+// ParseQueryResponse reads a query generation response. It looks for a JSON
+// object matching the schema anywhere in the text, tolerating code fences,
+// surrounding prose and raw newlines inside strings; a well-formed object
+// that does not match the schema is an error. If there is no object it
+// falls back to the quoted sql_query field, a ```sql block, then a bare
+// SELECT statement, and records which in Fallback.
+func ParseQueryResponse(text string) (*QueryResponse, error) {
+	var schemaErr error
+	for _, object := range jsonObjects(text) {
+		if !json.Valid([]byte(object)) {
+			// Models often put literal newlines inside strings
+			if object = escapeControlChars(object); !json.Valid([]byte(object)) {
+				continue
+			}
+		}
+		resp, err := decodeQueryResponse(object)
+		if err == nil {
+			return resp, nil
+		}
+		if schemaErr == nil {
+			schemaErr = err
+		}
+	}
+	if schemaErr != nil {
+		// A well-formed answer with the wrong shape is not worth scraping
+		return nil, fmt.Errorf("response does not match the query schema: %v", schemaErr)
+	}
+
+	resp := &QueryResponse{
+		ThoughtProcess: jsonField(text, "thought_process"),
+		Explanation:    jsonField(text, "explanation"),
+	}
+	switch {
+	case jsonField(text, "sql_query") != "":
+		resp.SQLQuery, resp.Fallback = jsonField(text, "sql_query"), "sql_query field"
+	case sqlFencePattern.MatchString(text):
+		resp.SQLQuery, resp.Fallback = sqlFencePattern.FindStringSubmatch(text)[1], "sql code block"
+	case bareSQLPattern.MatchString(text):
+		resp.SQLQuery, resp.Fallback = bareSQLPattern.FindString(text), "bare SQL"
+	default:
+		return nil, fmt.Errorf("no SQL query found in response")
+	}
+	resp.SQLQuery = cleanSQLQuery(resp.SQLQuery)
+	if err := checkQuerySQL(resp.SQLQuery); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// decodeQueryResponse decodes object and validates it against the schema
+func decodeQueryResponse(object string) (*QueryResponse, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(object), &fields); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(queryResponseFields))
+	for _, name := range queryResponseFields {
+		raw, ok := fields[name]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%s must be a string", name)
+		}
+		values[name] = strings.TrimSpace(value)
+	}
+	if values["sql_query"] == "" {
+		return nil, fmt.Errorf("sql_query is missing")
+	}
+
+	resp := &QueryResponse{
+		ThoughtProcess: values["thought_process"],
+		SQLQuery:       cleanSQLQuery(values["sql_query"]),
+		Explanation:    values["explanation"],
+	}
+	if err := checkQuerySQL(resp.SQLQuery); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// checkQuerySQL rejects SQL that is not a query
+func checkQuerySQL(sql string) error {
+	word := strings.ToUpper(strings.SplitN(strings.TrimLeft(sql, "( "), " ", 2)[0])
+	if word != "SELECT" && word != "WITH" {
+		return fmt.Errorf("sql_query is not a SELECT statement: %.60s", sql)
+	}
+	return nil
+}
+
+// jsonObjects returns the balanced {...} spans in text, outermost first
+func jsonObjects(text string) []string {
+	var objects []string
+	depth, start := 0, -1
+	inString, escaped := false, false
+	for i, r := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && r == '\\':
+			escaped = true
+		case r == '"' && depth > 0:
+			inString = !inString
+		case inString:
+		case r == '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case r == '}' && depth > 0:
+			depth--
+			if depth == 0 {
+				objects = append(objects, text[start:i+1])
+			}
+		}
+	}
+	return objects
+}
+
+// escapeControlChars escapes raw newlines, returns and tabs inside JSON
+// strings, which strict decoding rejects
+func escapeControlChars(object string) string {
+	var b strings.Builder
+	inString, escaped := false, false
+	for _, r := range object {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && r == '\\':
+			escaped = true
+		case r == '"':
+			inString = !inString
+		case inString && r == '\n':
+			b.WriteString(`\n`)
+			continue
+		case inString && r == '\r':
+			continue
+		case inString && r == '\t':
+			b.WriteString(`\t`)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// jsonField finds a quoted string field in text that is not valid JSON
+func jsonField(text, name string) string {
+	match := regexp.MustCompile(fmt.Sprintf(jsonFieldPattern, regexp.QuoteMeta(name))).FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	var value string
+	if err := json.Unmarshal([]byte(`"`+match[1]+`"`), &value); err != nil {
+		return strings.TrimSpace(match[1])
+	}
+	return strings.TrimSpace(value)
+}
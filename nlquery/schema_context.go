@@ -0,0 +1,241 @@
+package nlquery
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/sqllint"
+	"github.com/nonsonwune/spk2_db/stats"
+)
+
+// DefaultSchemaContextTTL is how long the introspected schema description
+// is reused before it is read again; NL_SCHEMA_TTL overrides it
+const DefaultSchemaContextTTL = time.Hour
+
+// internalTables are the application's bookkeeping tables, left out of the
+// prompts so the model only sees candidate data and its reference tables
+var internalTables = map[string]bool{
+	"schema_migrations": true, "job_log": true, "scheduled_jobs": true,
+	"nlq_cache": true, "query_history": true, "reports": true,
+	"relation_freshness": true, "import_errors": true, "import_audit": true,
+	"candidate_changes": true, "course_name_audit": true, "course_name_suggestions": true,
+	"gender_audit": true, "geocode_cache": true, "equating_runs": true, "notes": true,
+	"null_snapshots": true, "institution_remaps": true, "state_matches": true, "state_value_mappings": true,
+}
+
+// joinHint is a join the prompts suggest. The reference tables declare no
+// foreign keys, so the usual joins are listed here and only offered when
+// both columns exist.
+type joinHint struct {
+	left, right string // table.column
+	sql         string
+}
+
+var joinHints = []joinHint{
+	{"candidate.statecode", "state.st_id", "JOIN state s ON c.statecode = s.st_id"},
+	{"candidate.app_course1", "course.course_code", "JOIN course co ON c.app_course1 = co.course_code"},
+	{"candidate.inid", "institution.inid", "JOIN institution i ON c.inid = i.inid"},
+	{"institution.intyp", "institution_type.intyp_id", "JOIN institution_type it ON i.intyp = it.intyp_id"},
+	{"course.facid", "faculty.fac_id", "JOIN faculty f ON co.facid = f.fac_id"},
+	{"candidate.lg_id", "lga.lg_id", "JOIN lga l ON c.lg_id = l.lg_id"},
+	{"lga.lg_st_id", "state.st_id", "JOIN state s ON l.lg_st_id = s.st_id"},
+	{"candidate_scores.cand_reg_number", "candidate.regnumber", "LEFT JOIN candidate_scores cs ON c.regnumber = cs.cand_reg_number"},
+	{"candidate_scores.subject_id", "subject.su_id", "LEFT JOIN subject sub ON cs.subject_id = sub.su_id"},
+	{"candidate_scores.subject_id", "subject.subject_id", "LEFT JOIN subject sub ON cs.subject_id = sub.subject_id"},
+	{"candidate_disabilities.cand_reg_number", "candidate.regnumber", "LEFT JOIN candidate_disabilities cd ON c.regnumber = cd.cand_reg_number"},
+}
+
+type schemaTable struct {
+	name    string
+	view    bool
+	rows    int64 // planner estimate
+	columns []string
+	refs    []string
+}
+
+var (
+	schemaContextMu   sync.Mutex
+	schemaContextText string
+	schemaContextAt   time.Time
+)
+
+// schemaContext returns the description of db's schema for the prompts,
+// reading it again once NL_SCHEMA_TTL has passed
+func schemaContext(ctx context.Context, db *sql.DB) (string, error) {
+	schemaContextMu.Lock()
+	defer schemaContextMu.Unlock()
+	if schemaContextText != "" && time.Since(schemaContextAt) < schemaContextTTL() {
+		return schemaContextText, nil
+	}
+	return loadSchemaContext(ctx, db)
+}
+
+// RefreshSchemaContext reads db's schema again, e.g. after a migration, so
+// engines created afterwards describe the current tables
+func RefreshSchemaContext(ctx context.Context, db *sql.DB) (string, error) {
+	schemaContextMu.Lock()
+	defer schemaContextMu.Unlock()
+	return loadSchemaContext(ctx, db)
+}
+
+// loadSchemaContext introspects db; the caller holds schemaContextMu
+func loadSchemaContext(ctx context.Context, db *sql.DB) (string, error) {
+	tables, err := introspectTables(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	schemaContextText, schemaContextAt = describeTables(tables), time.Now()
+	return schemaContextText, nil
+}
+
+func schemaContextTTL() time.Duration {
+	raw := os.Getenv("NL_SCHEMA_TTL")
+	if raw == "" {
+		return DefaultSchemaContextTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("Warning: ignoring invalid NL_SCHEMA_TTL %q", raw)
+		return DefaultSchemaContextTTL
+	}
+	return ttl
+}
+
+// introspectTables reads the public tables and views with their columns,
+// types, estimated row counts and foreign keys
+func introspectTables(ctx context.Context, db *sql.DB) ([]*schemaTable, error) {
+	// Fact tables have their own section in the prompts
+	facts := make(map[string]bool, len(stats.FactTables))
+	for _, t := range stats.FactTables {
+		facts[t.Name] = true
+	}
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT c.relname, c.relkind IN ('v', 'm'), GREATEST(c.reltuples, 0)::bigint,
+               a.attname, format_type(a.atttypid, a.atttypmod)
+        FROM pg_class c
+        JOIN pg_namespace n ON n.oid = c.relnamespace
+        JOIN pg_attribute a ON a.attrelid = c.oid
+        WHERE n.nspname = 'public'
+          AND c.relkind IN ('r', 'v', 'm', 'p', 'f')
+          AND a.attnum > 0 AND NOT a.attisdropped
+        ORDER BY c.relname, a.attnum`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []*schemaTable
+	byName := make(map[string]*schemaTable)
+	for rows.Next() {
+		var name, column, typ string
+		var view bool
+		var estimate int64
+		if err := rows.Scan(&name, &view, &estimate, &column, &typ); err != nil {
+			return nil, fmt.Errorf("error reading schema: %w", err)
+		}
+		if internalTables[name] || facts[name] {
+			continue
+		}
+		t := byName[name]
+		if t == nil {
+			t = &schemaTable{name: name, view: view, rows: estimate}
+			byName[name] = t
+			tables = append(tables, t)
+		}
+		t.columns = append(t.columns, column+" "+typ)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading schema: %w", err)
+	}
+
+	fks, err := db.QueryContext(ctx, `
+        SELECT src.relname, dst.relname,
+               (SELECT string_agg(a.attname, ', ' ORDER BY k.n)
+                FROM unnest(con.conkey) WITH ORDINALITY k(attnum, n)
+                JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum),
+               (SELECT string_agg(a.attname, ', ' ORDER BY k.n)
+                FROM unnest(con.confkey) WITH ORDINALITY k(attnum, n)
+                JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum)
+        FROM pg_constraint con
+        JOIN pg_class src ON src.oid = con.conrelid
+        JOIN pg_class dst ON dst.oid = con.confrelid
+        JOIN pg_namespace n ON n.oid = src.relnamespace
+        WHERE con.contype = 'f' AND n.nspname = 'public'
+        ORDER BY src.relname, con.conname`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading foreign keys: %w", err)
+	}
+	defer fks.Close()
+	for fks.Next() {
+		var from, to, columns, refColumns string
+		if err := fks.Scan(&from, &to, &columns, &refColumns); err != nil {
+			return nil, fmt.Errorf("error reading foreign keys: %w", err)
+		}
+		if t := byName[from]; t != nil {
+			t.refs = append(t.refs, fmt.Sprintf("%s -> %s(%s)", columns, to, refColumns))
+		}
+	}
+	return tables, fks.Err()
+}
+
+// describeTables writes the schema section of the prompts
+func describeTables(tables []*schemaTable) string {
+	columns := make(map[string]bool)
+	var b strings.Builder
+	for _, t := range tables {
+		kind := "table"
+		if t.view {
+			kind = "view"
+		}
+		size := ""
+		if t.rows > 0 {
+			size = fmt.Sprintf(", about %d rows", t.rows)
+		}
+		fmt.Fprintf(&b, "- %s (%s%s): %s\n", t.name, kind, size, strings.Join(t.columns, ", "))
+		if len(t.refs) > 0 {
+			fmt.Fprintf(&b, "  foreign keys: %s\n", strings.Join(t.refs, "; "))
+		}
+		for _, c := range t.columns {
+			name, _, _ := strings.Cut(c, " ")
+			columns[t.name+"."+name] = true
+		}
+	}
+
+	var joins []string
+	for _, h := range joinHints {
+		if columns[h.left] && columns[h.right] {
+			joins = append(joins, "  "+h.sql)
+		}
+	}
+	if len(joins) > 0 {
+		sort.Strings(joins)
+		b.WriteString("Usual joins (c is candidate):\n")
+		b.WriteString(strings.Join(joins, "\n"))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// RefreshSchema reads the schema again for the engine's later questions,
+// both for the prompts and for linting generated SQL
+func (e *NLQueryEngine) RefreshSchema(ctx context.Context) error {
+	description, err := RefreshSchemaContext(ctx, e.db)
+	if err != nil {
+		return err
+	}
+	schema, err := sqllint.LoadSchema(ctx, e.db)
+	if err != nil {
+		return err
+	}
+	e.promptBuilder.SetSchema(description)
+	e.schema, e.schemaHash = schema, schemaHash(schema)
+	return nil
+}
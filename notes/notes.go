@@ -0,0 +1,150 @@
+// Package notes keeps free-text notes on institutions, courses and import
+// jobs, e.g. "course code changed in 2022, see mapping 314", for detail
+// views and exports.
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Kind is a type of entity notes can be attached to
+type Kind struct {
+	Name  string
+	Title string
+	Help  string // what identifies an entity of this kind
+	// exists, when set, checks that a key names an entity
+	exists string
+}
+
+var (
+	Institution = Kind{Name: "institution", Title: "Institution", Help: "institution ID (inid)",
+		exists: "SELECT EXISTS (SELECT 1 FROM institution WHERE inid = $1)"}
+	Course = Kind{Name: "course", Title: "Course", Help: "course code",
+		exists: "SELECT EXISTS (SELECT 1 FROM course WHERE course_code = $1)"}
+	// Import notes are keyed by the imported file's name, as in the failed
+	// imports listing
+	Import = Kind{Name: "import", Title: "Import", Help: "imported file name"}
+)
+
+// Kinds lists the entity kinds in menu order
+var Kinds = []Kind{Institution, Course, Import}
+
+// ParseKind returns the kind with the given name
+func ParseKind(name string) (Kind, error) {
+	for _, k := range Kinds {
+		if strings.EqualFold(k.Name, strings.TrimSpace(name)) {
+			return k, nil
+		}
+	}
+	return Kind{}, fmt.Errorf("unknown note kind %q (use institution, course or import)", name)
+}
+
+// Key normalises an entity key: codes are upper-cased and import paths
+// reduced to the file name
+func (k Kind) Key(key string) string {
+	key = strings.TrimSpace(key)
+	if k.Name == Import.Name {
+		return filepath.Base(key)
+	}
+	return strings.ToUpper(key)
+}
+
+// Note is a note on one entity
+type Note struct {
+	ID        int64
+	Kind      string
+	Key       string
+	Text      string
+	Author    string
+	CreatedAt time.Time
+}
+
+// Add attaches text to the entity of kind k with key, returning the note's ID
+func Add(ctx context.Context, db *sql.DB, k Kind, key, text, author string) (int64, error) {
+	key, text = k.Key(key), strings.TrimSpace(text)
+	if key == "" || key == "." {
+		return 0, fmt.Errorf("a %s is required", k.Help)
+	}
+	if text == "" {
+		return 0, fmt.Errorf("the note is empty")
+	}
+	if k.exists != "" {
+		var found bool
+		if err := db.QueryRowContext(ctx, k.exists, key).Scan(&found); err != nil {
+			return 0, fmt.Errorf("error checking %s: %w", k.Name, err)
+		}
+		if !found {
+			return 0, fmt.Errorf("no %s with %s %s", k.Name, k.Help, key)
+		}
+	}
+
+	var id int64
+	err := db.QueryRowContext(ctx, `
+        INSERT INTO notes (entity_type, entity_key, note, author)
+        VALUES ($1, $2, $3, NULLIF($4, ''))
+        RETURNING id`, k.Name, key, text, author).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error saving note: %w", err)
+	}
+	return id, nil
+}
+
+// List returns the notes on one entity, oldest first. A blank key lists
+// every note of the kind, and a zero Kind every note.
+func List(ctx context.Context, db *sql.DB, k Kind, key string) ([]Note, error) {
+	if key != "" {
+		key = k.Key(key)
+	}
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, entity_type, entity_key, note, COALESCE(author, ''), created_at
+        FROM notes
+        WHERE ($1 = '' OR entity_type = $1) AND ($2 = '' OR entity_key = $2)
+        ORDER BY entity_type, entity_key, created_at, id`, k.Name, key)
+	if err != nil {
+		return nil, fmt.Errorf("error reading notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []Note
+	for rows.Next() {
+		var n Note
+		if err := rows.Scan(&n.ID, &n.Kind, &n.Key, &n.Text, &n.Author, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// Delete removes the note with the given ID
+func Delete(ctx context.Context, db *sql.DB, id int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM notes WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting note: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no note with ID %d", id)
+	}
+	return nil
+}
+
+// Column returns an SQL expression joining the notes on the entity of kind
+// k whose key is keyExpr into one value, for export columns
+func Column(k Kind, keyExpr string) string {
+	return fmt.Sprintf(`(SELECT string_agg(n.note, ' | ' ORDER BY n.created_at, n.id)
+            FROM notes n WHERE n.entity_type = '%s' AND n.entity_key = %s)`, k.Name, keyExpr)
+}
+
+// String formats a note for display, e.g. "2024-03-01 (ada): text"
+func (n Note) String() string {
+	by := ""
+	if n.Author != "" {
+		by = " (" + n.Author + ")"
+	}
+	return fmt.Sprintf("%s%s: %s", n.CreatedAt.Format("2006-01-02"), by, n.Text)
+}
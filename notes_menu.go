@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/models"
+	"github.com/nonsonwune/spk2_db/notes"
+)
+
+// handleNotes shows, adds and deletes notes on institutions, courses and
+// import jobs
+func handleNotes(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nNotes")
+	fmt.Println("1. Show notes on an institution, course or import")
+	fmt.Println("2. Add a note")
+	fmt.Println("3. Delete a note")
+	fmt.Println("4. List all notes")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		kind, key, err := readNoteEntity()
+		if err != nil {
+			return err
+		}
+		return showNoteTable(ctx, db, kind, key)
+	case "2":
+		kind, key, err := readNoteEntity()
+		if err != nil {
+			return err
+		}
+		fmt.Print("Note: ")
+		id, err := notes.Add(ctx, db, kind, key, readString(), noteAuthor())
+		if err != nil {
+			return err
+		}
+		color.Green("Saved note %d on %s %s", id, kind.Name, kind.Key(key))
+	case "3":
+		fmt.Print("Note ID: ")
+		id, err := strconv.ParseInt(readString(), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid note ID")
+		}
+		if err := notes.Delete(ctx, db, id); err != nil {
+			return err
+		}
+		color.Green("Deleted note %d", id)
+	case "4":
+		return showNoteTable(ctx, db, notes.Kind{}, "")
+	}
+	return nil
+}
+
+func readNoteEntity() (notes.Kind, string, error) {
+	for i, k := range notes.Kinds {
+		fmt.Printf("%d. %s\n", i+1, k.Title)
+	}
+	fmt.Print("Kind: ")
+	n, err := strconv.Atoi(readString())
+	if err != nil || n < 1 || n > len(notes.Kinds) {
+		return notes.Kind{}, "", fmt.Errorf("invalid choice")
+	}
+	kind := notes.Kinds[n-1]
+	fmt.Printf("%s: ", strings.ToUpper(kind.Help[:1])+kind.Help[1:])
+	key := readString()
+	if key == "" {
+		return notes.Kind{}, "", fmt.Errorf("a %s is required", kind.Help)
+	}
+	return kind, key, nil
+}
+
+// noteAuthor names the person adding a note, from the login name
+func noteAuthor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return os.Getenv("USERNAME")
+}
+
+func noteRows(list []notes.Note) [][]interface{} {
+	rows := make([][]interface{}, len(list))
+	for i, n := range list {
+		rows[i] = []interface{}{n.ID, n.Kind, n.Key, n.Text, n.Author, n.CreatedAt.Format("2006-01-02 15:04")}
+	}
+	return rows
+}
+
+func showNoteTable(ctx context.Context, db *sql.DB, kind notes.Kind, key string) error {
+	list, err := notes.List(ctx, db, kind, key)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		color.Yellow("No notes")
+		return nil
+	}
+	table := newResultTable("notes")
+	table.SetHeader([]string{"ID", "Kind", "Key", "Note", "Author", "Added"})
+	for _, row := range noteRows(list) {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = fmt.Sprint(v)
+		}
+		table.Append(cells)
+	}
+	table.Render()
+	return nil
+}
+
+// printNotes lists the notes on an entity under a detail view. Errors are
+// only warnings: the view has already been shown.
+func printNotes(ctx context.Context, db *sql.DB, kind notes.Kind, key, label string) {
+	list, err := notes.List(ctx, db, kind, key)
+	if err != nil {
+		color.Yellow("Could not read notes: %v", err)
+		return
+	}
+	for _, n := range list {
+		color.Yellow("Note on %s: %s", label, n)
+	}
+}
+
+// candidateNoteRows are the notes on a candidate's institution and course,
+// as field/value rows for the candidate detail view
+func candidateNoteRows(ctx context.Context, db *sql.DB, c *models.Candidate) ([][]interface{}, error) {
+	var rows [][]interface{}
+	for _, entity := range []struct {
+		kind notes.Kind
+		key  sql.NullString
+	}{{notes.Institution, c.InID}, {notes.Course, c.AppCourse1}} {
+		if !entity.key.Valid || entity.key.String == "" {
+			continue
+		}
+		list, err := notes.List(ctx, db, entity.kind, entity.key.String)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range list {
+			rows = append(rows, []interface{}{entity.kind.Title + " Note", n.String()})
+		}
+	}
+	return rows, nil
+}
+
+// runNote is the scriptable form of menu item 43
+func runNote(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("note")
+	format := fs.String("format", "table", "with list, output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "with list, write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError{errors.New("note needs list, add KIND KEY TEXT or delete ID")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "list":
+		var kind notes.Kind
+		var key string
+		if fs.NArg() > 3 {
+			return usageError{errors.New("note list takes at most a kind and a key")}
+		}
+		if fs.NArg() > 1 {
+			var err error
+			if kind, err = notes.ParseKind(fs.Arg(1)); err != nil {
+				return usageError{err}
+			}
+			key = fs.Arg(2)
+		}
+		list, err := notes.List(ctx, db, kind, key)
+		if err != nil {
+			return err
+		}
+		return writeResult("notes", []string{"id", "kind", "key", "note", "author", "added"}, noteRows(list), *format, *output)
+	case "add":
+		if fs.NArg() < 4 {
+			return usageError{errors.New("note add needs a kind (institution, course or import), a key and the note")}
+		}
+		kind, err := notes.ParseKind(fs.Arg(1))
+		if err != nil {
+			return usageError{err}
+		}
+		id, err := notes.Add(ctx, db, kind, fs.Arg(2), strings.Join(fs.Args()[3:], " "), noteAuthor())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Saved note %d on %s %s\n", id, kind.Name, kind.Key(fs.Arg(2)))
+		return nil
+	case "delete":
+		if fs.NArg() != 2 {
+			return usageError{errors.New("note delete needs a note ID")}
+		}
+		id, err := strconv.ParseInt(fs.Arg(1), 10, 64)
+		if err != nil {
+			return usageError{fmt.Errorf("invalid note ID %q", fs.Arg(1))}
+		}
+		if err := notes.Delete(ctx, db, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Deleted note %d\n", id)
+		return nil
+	default:
+		return usageError{fmt.Errorf("unknown note action %q", fs.Arg(0))}
+	}
+}
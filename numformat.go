@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// numberFormat is how console tables show numbers, e.g. 1,234,567.89.
+// CSV, JSON, Excel and clipboard output keep plain numbers for other tools.
+type numberFormat struct {
+	thousands string
+	decimal   string
+	decimals  int // places for numbers with a fraction; -1 keeps them as they are
+}
+
+// numberLocales are the separators REPORT_LOCALE can choose
+var numberLocales = map[string]numberFormat{
+	"en":   {thousands: ",", decimal: "."},
+	"de":   {thousands: ".", decimal: ","},
+	"es":   {thousands: ".", decimal: ","},
+	"it":   {thousands: ".", decimal: ","},
+	"pt":   {thousands: ".", decimal: ","},
+	"fr":   {thousands: " ", decimal: ","},
+	"ch":   {thousands: "'", decimal: "."},
+	"none": {thousands: "", decimal: "."},
+}
+
+// tableNumbers is the format console tables use, set from the environment
+// at startup
+var tableNumbers = numberFormat{thousands: ",", decimal: ".", decimals: -1}
+
+// numberFormatFromEnv reads REPORT_LOCALE (en by default; de, es, it, pt,
+// fr, ch or none) and REPORT_DECIMALS (unset keeps each report's own
+// precision). Region suffixes such as en_NG or fr-FR are accepted.
+func numberFormatFromEnv() (numberFormat, error) {
+	f := tableNumbers
+	if raw := os.Getenv("REPORT_LOCALE"); raw != "" {
+		lang := strings.ToLower(strings.FieldsFunc(raw, func(r rune) bool { return r == '_' || r == '-' || r == '.' })[0])
+		locale, ok := numberLocales[lang]
+		if !ok {
+			return f, fmt.Errorf("REPORT_LOCALE %q is not one of en, de, es, it, pt, fr, ch or none", raw)
+		}
+		f.thousands, f.decimal = locale.thousands, locale.decimal
+	}
+	if raw := os.Getenv("REPORT_DECIMALS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > 6 {
+			return f, fmt.Errorf("REPORT_DECIMALS %q is not a whole number from 0 to 6", raw)
+		}
+		f.decimals = n
+	}
+	return f, nil
+}
+
+var consoleNumber = regexp.MustCompile(`^(-?)(0|[1-9][0-9]*)(\.[0-9]+)?(%?)$`)
+
+// identifierWords mark columns holding codes rather than quantities
+var identifierWords = map[string]bool{"year": true, "code": true, "id": true, "regnumber": true}
+
+// isIdentifierColumn reports whether a column's numbers are labels, such as
+// years and codes, which are shown as they are
+func isIdentifierColumn(column string) bool {
+	if column == "#" {
+		return true
+	}
+	words := strings.FieldsFunc(strings.ToLower(column), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if identifierWords[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// localize formats a row of console cells, leaving text, codes and years
+// alone
+func (f numberFormat) localize(header []string, cells []string) []string {
+	for i, cell := range cells {
+		if i < len(header) && isIdentifierColumn(header[i]) {
+			continue
+		}
+		cells[i] = f.number(cell)
+	}
+	return cells
+}
+
+// number formats numeric text, including percentages, returning anything
+// else unchanged. Whole numbers are never given decimals.
+func (f numberFormat) number(text string) string {
+	m := consoleNumber.FindStringSubmatch(text)
+	if m == nil {
+		return text
+	}
+	sign, whole, fraction, percent := m[1], m[2], m[3], m[4]
+	if fraction != "" && f.decimals >= 0 {
+		v, err := strconv.ParseFloat(whole+fraction, 64)
+		if err != nil {
+			return text
+		}
+		rounded := strconv.FormatFloat(v, 'f', f.decimals, 64)
+		whole, fraction, _ = strings.Cut(rounded, ".")
+		if fraction != "" {
+			fraction = "." + fraction
+		}
+	}
+	if f.thousands != "" {
+		var b strings.Builder
+		for i, d := range whole {
+			if i > 0 && (len(whole)-i)%3 == 0 {
+				b.WriteString(f.thousands)
+			}
+			b.WriteRune(d)
+		}
+		whole = b.String()
+	}
+	if fraction != "" {
+		fraction = f.decimal + fraction[1:]
+	}
+	return sign + whole + fraction + percent
+}
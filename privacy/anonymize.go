@@ -0,0 +1,202 @@
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// anonymizeBatchSize is the number of rows read and updated at a time
+const anonymizeBatchSize = 5000
+
+// rawCopyTables keep personal data as it was imported, or the text of
+// questions and statements that may name candidates, so an anonymized
+// database has them emptied
+var rawCopyTables = []string{
+	"import_errors", "candidate_changes", "gender_audit", "state_matches", "nlq_cache",
+	"query_history", "nl_query_history", "slow_queries",
+}
+
+// AnonymizeSummary reports what Anonymize changed
+type AnonymizeSummary struct {
+	Candidates int
+	ExamInfo   int
+	Emptied    []string // tables holding raw copies that were emptied
+	Locations  int      // candidate coordinates found from addresses, removed
+}
+
+// Anonymize masks the personal data columns of every candidate in place,
+// or only those of year when it is not 0, and when masking every year
+// empties the tables that keep raw copies of imported rows. It is meant for
+// a copy of the database shared with researchers. Everything happens in one
+// transaction, so an interrupted run leaves the data as it was and can be
+// started again without masking a value twice. regnumber cannot be masked
+// in place, as the other candidate tables join on it. When address is
+// masked the coordinates geocoded from addresses are removed as well, and
+// geocode_cache, which holds the addresses looked up, is emptied.
+func Anonymize(ctx context.Context, db *sql.DB, p *Pseudonymizer, year int, progress func(done int)) (*AnonymizeSummary, error) {
+	if err := p.CheckInPlace(); err != nil {
+		return nil, err
+	}
+	var columns []string
+	examNumber := false
+	for _, c := range p.Columns() {
+		if c == "exam_number" {
+			examNumber = true
+		} else {
+			columns = append(columns, c)
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	summary := &AnonymizeSummary{}
+	if len(columns) > 0 {
+		if summary.Candidates, err = anonymizeTable(ctx, tx, p, "candidate", "regnumber", "t.year", columns, year, progress); err != nil {
+			return nil, err
+		}
+	}
+	if examNumber {
+		yearOf := "(SELECT c.year FROM candidate c WHERE c.regnumber = t.cand_reg_number)"
+		if summary.ExamInfo, err = anonymizeTable(ctx, tx, p, "candidate_exam_info", "cand_reg_number", yearOf, []string{"exam_number"}, year, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var emptied []string
+	if year == 0 {
+		emptied = append(emptied, rawCopyTables...)
+	}
+	if p.Method("address") != MethodKeep {
+		if summary.Locations, err = removeAddressLocations(ctx, tx, year); err != nil {
+			return nil, err
+		}
+		emptied = append(emptied, "geocode_cache")
+	}
+	for _, table := range emptied {
+		exists, err := tableExists(ctx, tx, table)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "TRUNCATE "+table); err != nil {
+			return nil, fmt.Errorf("error emptying %s: %w", table, err)
+		}
+		summary.Emptied = append(summary.Emptied, table)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// removeAddressLocations deletes the coordinates geocoded from the
+// addresses of candidates of year, or of every year when it is 0
+func removeAddressLocations(ctx context.Context, tx *sql.Tx, year int) (int, error) {
+	exists, err := tableExists(ctx, tx, "candidate_locations")
+	if err != nil || !exists {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `
+        DELETE FROM candidate_locations cl
+        WHERE cl.source = 'address'
+            AND ($1 = 0 OR EXISTS (
+                SELECT 1 FROM candidate c WHERE c.regnumber = cl.regnumber AND c.year = $1))`, year)
+	if err != nil {
+		return 0, fmt.Errorf("error removing address locations: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func tableExists(ctx context.Context, tx *sql.Tx, table string) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists)
+	return exists, err
+}
+
+// anonymizeTable masks columns of table in batches ordered by its key.
+// yearOf is the SQL for a row's year.
+func anonymizeTable(ctx context.Context, tx *sql.Tx, p *Pseudonymizer, table, key, yearOf string, columns []string, year int, progress func(int)) (int, error) {
+	selectCols := make([]string, len(columns))
+	sets := make([]string, len(columns))
+	unnests := make([]string, len(columns)+1)
+	unnests[0] = "$1::text[]"
+	for i, col := range columns {
+		selectCols[i] = "t." + col
+		sets[i] = fmt.Sprintf("%s = u.%s", col, col)
+		unnests[i+1] = fmt.Sprintf("$%d::text[]", i+2)
+	}
+	read := fmt.Sprintf(`
+        SELECT t.%s, %s FROM %s t
+        WHERE t.%s > $1 AND ($2 = 0 OR %s = $2)
+        ORDER BY t.%s LIMIT %d`,
+		key, strings.Join(selectCols, ", "), table, key, yearOf, key, anonymizeBatchSize)
+	write := fmt.Sprintf(`
+        UPDATE %s t SET %s
+        FROM unnest(%s) AS u(key, %s)
+        WHERE t.%s = u.key`,
+		table, strings.Join(sets, ", "), strings.Join(unnests, ", "), strings.Join(columns, ", "), key)
+
+	done, last := 0, ""
+	for {
+		keys, values, err := readBatch(ctx, tx, p, read, columns, last, year)
+		if err != nil {
+			return done, fmt.Errorf("error reading %s: %w", table, err)
+		}
+		if len(keys) == 0 {
+			return done, nil
+		}
+		args := []interface{}{pq.Array(keys)}
+		for _, v := range values {
+			args = append(args, pq.Array(v))
+		}
+		if _, err := tx.ExecContext(ctx, write, args...); err != nil {
+			return done, fmt.Errorf("error anonymizing %s: %w", table, err)
+		}
+		done += len(keys)
+		last = keys[len(keys)-1]
+		if progress != nil {
+			progress(done)
+		}
+	}
+}
+
+// readBatch reads the next batch of keys and returns them with the masked
+// values of each column
+func readBatch(ctx context.Context, tx *sql.Tx, p *Pseudonymizer, query string, columns []string, after string, year int) ([]string, [][]sql.NullString, error) {
+	rows, err := tx.QueryContext(ctx, query, after, year)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	values := make([][]sql.NullString, len(columns))
+	row := make([]sql.NullString, len(columns))
+	for rows.Next() {
+		var key string
+		ptrs := []interface{}{&key}
+		for i := range row {
+			ptrs = append(ptrs, &row[i])
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		for i, col := range columns {
+			masked, ok := p.Apply(col, row[i].String)
+			values[i] = append(values[i], sql.NullString{String: masked, Valid: ok})
+		}
+	}
+	return keys, values, rows.Err()
+}
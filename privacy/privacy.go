@@ -0,0 +1,274 @@
+// Package privacy guards output that leaves the organisation. Reports
+// flagged public must not reveal groups of fewer than k candidates, so
+// small cells are either suppressed or merged into an "Other" group, and
+// candidate-level data shared with researchers has its personal data
+// columns masked (see Pseudonymizer).
+package privacy
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultK is the smallest group size published when none is configured
+const DefaultK = 10
+
+// Mode decides what happens to groups smaller than k
+type Mode string
+
+const (
+	// ModeSuppress drops small groups
+	ModeSuppress Mode = "suppress"
+	// ModeMerge combines small groups into one "Other" group, which is
+	// itself dropped if still smaller than k
+	ModeMerge Mode = "merge"
+)
+
+// Guard enforces a minimum group size on aggregate tables
+type Guard struct {
+	K    int
+	Mode Mode
+}
+
+// FromEnv reads PUBLIC_MIN_GROUP_SIZE and PRIVACY_MODE (suppress or merge)
+func FromEnv() (Guard, error) {
+	g := Guard{K: DefaultK, Mode: ModeSuppress}
+	if raw := os.Getenv("PUBLIC_MIN_GROUP_SIZE"); raw != "" {
+		k, err := strconv.Atoi(raw)
+		if err != nil || k < 1 {
+			return g, fmt.Errorf("invalid PUBLIC_MIN_GROUP_SIZE %q", raw)
+		}
+		g.K = k
+	}
+	if raw := os.Getenv("PRIVACY_MODE"); raw != "" {
+		mode, err := ParseMode(raw)
+		if err != nil {
+			return g, err
+		}
+		g.Mode = mode
+	}
+	return g, nil
+}
+
+// ParseMode parses suppress or merge
+func ParseMode(s string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(s))) {
+	case ModeSuppress:
+		return ModeSuppress, nil
+	case ModeMerge:
+		return ModeMerge, nil
+	}
+	return "", fmt.Errorf("unknown privacy mode %q (use suppress or merge)", s)
+}
+
+// Spec identifies the columns of an aggregate table by name
+type Spec struct {
+	// Size holds the number of candidates in each group
+	Size string
+	// Label is set to "Other" on the merged group
+	Label string
+	// Counts are further candidate counts within a group, such as the
+	// number admitted; nonzero counts below k are blanked, as are counts
+	// leaving fewer than k of the group outside them, and further counts
+	// are blanked so that none can be rebuilt from Size and the rest
+	Counts []string
+	// Means are per-group averages, recomputed weighted by Size when
+	// groups are merged
+	Means []string
+	// Shares are percentages of the total, added up when groups are merged
+	Shares []string
+	// Running are cumulative percentages down the table; the merged group,
+	// listed last, carries the largest of them
+	Running []string
+}
+
+// Result reports what the guard withheld
+type Result struct {
+	Suppressed int // groups dropped entirely
+	Merged     int // groups folded into "Other"
+	Blanked    int // individual counts blanked
+}
+
+// Apply enforces the guard on rows in place and returns the rows to
+// publish. Values may be integers, floats, numeric strings or nil; merged
+// counts are int64 and merged means float64. Blanked cells become nil.
+func (g Guard) Apply(columns []string, rows [][]interface{}, spec Spec) ([][]interface{}, Result, error) {
+	var res Result
+	index := func(name string) int {
+		for i, c := range columns {
+			if c == name {
+				return i
+			}
+		}
+		return -1
+	}
+	size := index(spec.Size)
+	if size < 0 {
+		return nil, res, fmt.Errorf("group size column %q not found", spec.Size)
+	}
+	label := index(spec.Label)
+	counts := indexes(index, spec.Counts)
+	means := indexes(index, spec.Means)
+	shares := indexes(index, spec.Shares)
+	running := indexes(index, spec.Running)
+
+	k := float64(g.K)
+	published := rows[:0:0]
+	var small [][]interface{}
+	for _, row := range rows {
+		if n, _ := Number(row[size]); n < k {
+			small = append(small, row)
+			continue
+		}
+		published = append(published, row)
+	}
+
+	if g.Mode == ModeMerge && len(small) > 1 {
+		other := make([]interface{}, len(columns))
+		var total float64
+		sums := make(map[int]float64)
+		for _, row := range small {
+			n, _ := Number(row[size])
+			total += n
+			for _, i := range counts {
+				v, _ := Number(row[i])
+				sums[i] += v
+			}
+			for _, i := range means {
+				v, _ := Number(row[i])
+				sums[i] += v * n
+			}
+			for _, i := range shares {
+				v, _ := Number(row[i])
+				sums[i] += v
+			}
+		}
+		for _, i := range running {
+			for _, row := range rows {
+				if v, ok := Number(row[i]); ok && v > sums[i] {
+					sums[i] = v
+				}
+			}
+		}
+		other[size] = int64(total)
+		for _, i := range counts {
+			other[i] = int64(sums[i])
+		}
+		for _, i := range means {
+			if total > 0 {
+				other[i] = math.Round(sums[i]/total*100) / 100
+			}
+		}
+		for _, i := range append(shares, running...) {
+			other[i] = math.Round(sums[i]*100) / 100
+		}
+		if label >= 0 {
+			other[label] = fmt.Sprintf("Other (%d groups)", len(small))
+		}
+		if total >= k {
+			published = append(published, other)
+			res.Merged = len(small)
+		} else {
+			res.Suppressed = len(small)
+		}
+	} else {
+		res.Suppressed = len(small)
+	}
+
+	for _, row := range published {
+		res.Blanked += blankCounts(row, size, counts, k)
+	}
+	return published, res, nil
+}
+
+// blankCounts blanks the counts of a published row that describe fewer
+// than k candidates, either themselves or as the rest of the group, and
+// returns how many cells it blanked. The group size stays visible, so a
+// lone blank among several counts could be rebuilt by subtracting the
+// others from it; further counts are blanked, smallest first, until at
+// least two are blank and together they hold k candidates.
+func blankCounts(row []interface{}, size int, counts []int, k float64) int {
+	total, _ := Number(row[size])
+	blanked := 0
+	var hidden float64
+	for _, i := range counts {
+		n, ok := Number(row[i])
+		if !ok {
+			continue
+		}
+		if (n > 0 && n < k) || (total-n > 0 && total-n < k) {
+			row[i] = nil
+			blanked++
+			hidden += n
+		}
+	}
+	if blanked == 0 || len(counts) < 2 {
+		return blanked
+	}
+	for blanked < 2 || hidden < k {
+		next := -1
+		var least float64
+		for _, i := range counts {
+			if n, ok := Number(row[i]); ok && (next < 0 || n < least) {
+				next, least = i, n
+			}
+		}
+		if next < 0 {
+			break
+		}
+		row[next] = nil
+		blanked++
+		hidden += least
+	}
+	return blanked
+}
+
+// Describe summarises a result for a footnote under a published table
+func (g Guard) Describe(res Result) string {
+	var parts []string
+	if res.Merged > 0 {
+		parts = append(parts, fmt.Sprintf("%d groups merged into Other", res.Merged))
+	}
+	if res.Suppressed > 0 {
+		parts = append(parts, fmt.Sprintf("%d groups suppressed", res.Suppressed))
+	}
+	if res.Blanked > 0 {
+		parts = append(parts, fmt.Sprintf("%d counts blanked", res.Blanked))
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("All groups have at least %d candidates", g.K)
+	}
+	return fmt.Sprintf("Groups under %d candidates withheld: %s", g.K, strings.Join(parts, ", "))
+}
+
+func indexes(index func(string) int, names []string) []int {
+	var out []int
+	for _, name := range names {
+		if i := index(name); i >= 0 {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// Number converts a numeric cell to float64
+func Number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case []byte:
+		f, err := strconv.ParseFloat(string(n), 64)
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.ReplaceAll(n, ",", ""), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
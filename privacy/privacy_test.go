@@ -0,0 +1,247 @@
+package privacy
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testColumns = []string{"state", "candidates", "admitted", "avg_score", "share", "cumulative"}
+
+var testSpec = Spec{
+	Size:    "candidates",
+	Label:   "state",
+	Counts:  []string{"admitted"},
+	Means:   []string{"avg_score"},
+	Shares:  []string{"share"},
+	Running: []string{"cumulative"},
+}
+
+// testRows is a fresh table on each call, as Apply changes rows in place
+func testRows(sizes ...int64) [][]interface{} {
+	names := []string{"Lagos", "Ogun", "Oyo", "Ekiti"}
+	admitted := []interface{}{int64(40), int64(2), int64(1), "4"}
+	means := []interface{}{210.5, 200.0, 220.0, "195.25"}
+	shares := []interface{}{62.5, 6.25, 8.75, 22.5}
+	running := []interface{}{62.5, 68.75, 77.5, 100.0}
+	rows := make([][]interface{}, len(sizes))
+	for i, n := range sizes {
+		rows[i] = []interface{}{names[i], n, admitted[i], means[i], shares[i], running[i]}
+	}
+	return rows
+}
+
+func TestGuardApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		guard Guard
+		rows  [][]interface{}
+		want  [][]interface{}
+		res   Result
+	}{
+		{
+			name:  "all groups large enough",
+			guard: Guard{K: 1, Mode: ModeSuppress},
+			rows:  testRows(50, 5),
+			want:  testRows(50, 5),
+		},
+		{
+			name:  "suppress small groups and blank small counts",
+			guard: Guard{K: 10, Mode: ModeSuppress},
+			rows:  testRows(50, 5, 7, 18),
+			want: [][]interface{}{
+				{"Lagos", int64(50), int64(40), 210.5, 62.5, 62.5},
+				{"Ekiti", int64(18), nil, "195.25", 22.5, 100.0},
+			},
+			res: Result{Suppressed: 2, Blanked: 1},
+		},
+		{
+			name:  "merge small groups into Other",
+			guard: Guard{K: 10, Mode: ModeMerge},
+			rows:  testRows(50, 5, 7, 18),
+			want: [][]interface{}{
+				{"Lagos", int64(50), int64(40), 210.5, 62.5, 62.5},
+				{"Ekiti", int64(18), nil, "195.25", 22.5, 100.0},
+				{"Other (2 groups)", int64(12), nil, 211.67, 15.0, 100.0},
+			},
+			res: Result{Merged: 2, Blanked: 2},
+		},
+		{
+			name:  "merged group still too small",
+			guard: Guard{K: 20, Mode: ModeMerge},
+			rows:  testRows(50, 5, 7),
+			want: [][]interface{}{
+				// Only 10 of Lagos were not admitted
+				{"Lagos", int64(50), nil, 210.5, 62.5, 62.5},
+			},
+			res: Result{Suppressed: 2, Blanked: 1},
+		},
+		{
+			name:  "a single small group is not merged",
+			guard: Guard{K: 10, Mode: ModeMerge},
+			rows:  testRows(50, 5),
+			want: [][]interface{}{
+				{"Lagos", int64(50), int64(40), 210.5, 62.5, 62.5},
+			},
+			res: Result{Suppressed: 1},
+		},
+		{
+			name:  "missing sizes count as small",
+			guard: Guard{K: 10, Mode: ModeSuppress},
+			rows: [][]interface{}{
+				{"Lagos", nil, nil, nil, nil, nil},
+				{"Ogun", "12", int64(0), nil, nil, nil},
+			},
+			want: [][]interface{}{
+				{"Ogun", "12", int64(0), nil, nil, nil},
+			},
+			res: Result{Suppressed: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, res, err := tt.guard.Apply(testColumns, tt.rows, testSpec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %v, want %v", got, tt.want)
+			}
+			if res != tt.res {
+				t.Errorf("result = %+v, want %+v", res, tt.res)
+			}
+		})
+	}
+}
+
+func TestGuardApplyComplementary(t *testing.T) {
+	columns := []string{"state", "candidates", "band_high", "band_mid", "band_low"}
+	spec := Spec{Size: "candidates", Label: "state", Counts: []string{"band_high", "band_mid", "band_low"}}
+	tests := []struct {
+		name    string
+		row     []interface{}
+		want    []interface{}
+		blanked int
+	}{
+		{"no small counts",
+			[]interface{}{"Edo", int64(40), int64(10), int64(20), int64(10)},
+			[]interface{}{"Edo", int64(40), int64(10), int64(20), int64(10)}, 0},
+		{"next smallest count blanked with a small one",
+			[]interface{}{"Lagos", int64(50), int64(3), int64(30), int64(17)},
+			[]interface{}{"Lagos", int64(50), nil, int64(30), nil}, 2},
+		{"count leaving a small rest of the group",
+			[]interface{}{"Oyo", int64(30), int64(1), int64(0), int64(29)},
+			[]interface{}{"Oyo", int64(30), nil, int64(0), nil}, 2},
+		{"blanked counts together below k",
+			[]interface{}{"Ogun", int64(40), int64(2), int64(36), int64(2)},
+			[]interface{}{"Ogun", int64(40), nil, nil, nil}, 3},
+		{"small count and the rest of the group both blanked",
+			[]interface{}{"Kano", int64(25), int64(0), int64(20), int64(5)},
+			[]interface{}{"Kano", int64(25), int64(0), nil, nil}, 2},
+		{"a blanked zero hides nothing",
+			[]interface{}{"Kaduna", int64(60), int64(0), int64(48), int64(8)},
+			[]interface{}{"Kaduna", int64(60), nil, nil, nil}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]interface{}(nil), tt.row...)
+			got, res, err := Guard{K: 10, Mode: ModeSuppress}.Apply(columns, [][]interface{}{tt.row}, spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || !reflect.DeepEqual(got[0], tt.want) {
+				t.Fatalf("rows = %v, want [%v]", got, tt.want)
+			}
+			if res.Blanked != tt.blanked {
+				t.Errorf("blanked = %d, want %d", res.Blanked, tt.blanked)
+			}
+
+			// The bands add up to the group, so subtracting the visible
+			// bands from it must not give back any single blanked band
+			row := got[0]
+			rest, _ := Number(row[1])
+			var blanks []int
+			var hidden float64
+			for i := 2; i < len(row); i++ {
+				if row[i] == nil {
+					blanks = append(blanks, i)
+					n, _ := Number(original[i])
+					hidden += n
+					continue
+				}
+				n, _ := Number(row[i])
+				rest -= n
+			}
+			if len(blanks) == 1 {
+				t.Errorf("band %s rebuilt as %v", columns[blanks[0]], rest)
+			}
+			if len(blanks) > 0 && hidden < 10 {
+				t.Errorf("blanked bands together hold %v candidates, fewer than k", hidden)
+			}
+		})
+	}
+}
+
+func TestGuardApplySingleCount(t *testing.T) {
+	columns := []string{"gender", "candidates", "admitted"}
+	spec := Spec{Size: "candidates", Label: "gender", Counts: []string{"admitted"}}
+	rows := [][]interface{}{
+		{"F", int64(50), int64(45)},
+		{"M", int64(40), int64(30)},
+		{"X", int64(12), int64(12)},
+	}
+	got, res, err := Guard{K: 10, Mode: ModeSuppress}.Apply(columns, rows, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 5 women were not admitted, so the 45 admitted is withheld
+	want := [][]interface{}{
+		{"F", int64(50), nil},
+		{"M", int64(40), int64(30)},
+		{"X", int64(12), int64(12)},
+	}
+	if !reflect.DeepEqual(got, want) || res.Blanked != 1 {
+		t.Errorf("rows = %v blanking %d, want %v blanking 1", got, res.Blanked, want)
+	}
+}
+
+func TestGuardApplyMissingSize(t *testing.T) {
+	_, _, err := Guard{K: 10}.Apply(testColumns, testRows(50), Spec{Size: "total"})
+	if err == nil {
+		t.Error("Apply without the size column succeeded")
+	}
+}
+
+func TestGuardDescribe(t *testing.T) {
+	g := Guard{K: 10, Mode: ModeMerge}
+	tests := []struct {
+		res  Result
+		want string
+	}{
+		{Result{}, "All groups have at least 10 candidates"},
+		{Result{Suppressed: 2}, "Groups under 10 candidates withheld: 2 groups suppressed"},
+		{Result{Merged: 3, Blanked: 1}, "Groups under 10 candidates withheld: 3 groups merged into Other, 1 counts blanked"},
+	}
+	for _, tt := range tests {
+		if got := g.Describe(tt.res); got != tt.want {
+			t.Errorf("Describe(%+v) = %q, want %q", tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Mode
+		ok    bool
+	}{
+		{"suppress", ModeSuppress, true},
+		{" Merge ", ModeMerge, true},
+		{"drop", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMode(tt.input)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseMode(%q) = %q, %v", tt.input, got, err)
+		}
+	}
+}
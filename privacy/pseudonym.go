@@ -0,0 +1,207 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Method is how a personal data column is masked
+type Method string
+
+const (
+	// MethodPseudonym replaces a value with a keyed hash of it, so equal
+	// values get equal pseudonyms and rows can still be joined and counted
+	MethodPseudonym Method = "pseudonym"
+	// MethodMask keeps the first and last characters, and an email's domain
+	MethodMask Method = "mask"
+	// MethodNull removes the value
+	MethodNull Method = "null"
+	// MethodKeep leaves the value as it is
+	MethodKeep Method = "keep"
+)
+
+// PersonalColumns are the candidate columns that identify a person.
+// exam_number is in candidate_exam_info.
+var PersonalColumns = []string{"regnumber", "surname", "firstname", "middlename", "email", "gsmno", "address", "exam_number"}
+
+// DefaultMethods masks every personal column but regnumber, which other
+// tables join on
+var DefaultMethods = map[string]Method{
+	"surname": MethodPseudonym, "firstname": MethodPseudonym, "middlename": MethodPseudonym,
+	"email": MethodPseudonym, "gsmno": MethodPseudonym, "address": MethodNull,
+	"exam_number": MethodPseudonym,
+}
+
+// MinKeyLength is the shortest PSEUDONYM_KEY accepted. Without a secret
+// key, pseudonyms of names could be reversed by hashing a list of names.
+const MinKeyLength = 16
+
+// pseudonymPrefixes make a pseudonym's column recognisable
+var pseudonymPrefixes = map[string]string{
+	"regnumber": "RN", "surname": "SN", "firstname": "FN", "middlename": "MN",
+	"address": "AD", "exam_number": "EX",
+}
+
+// Pseudonymizer masks personal data columns. The same key and value always
+// give the same pseudonym, so datasets masked separately can be joined.
+type Pseudonymizer struct {
+	key     []byte
+	methods map[string]Method
+}
+
+// NewPseudonymizer masks columns as DefaultMethods, changed by spec, a
+// comma separated list of column=method, e.g. "address=mask,regnumber=pseudonym".
+// key is usually PSEUDONYM_KEY.
+func NewPseudonymizer(key, spec string) (*Pseudonymizer, error) {
+	if len(key) < MinKeyLength {
+		return nil, fmt.Errorf("set PSEUDONYM_KEY to a secret of at least %d characters; the same key gives the same pseudonyms", MinKeyLength)
+	}
+	p := &Pseudonymizer{key: []byte(key), methods: map[string]Method{}}
+	for column, method := range DefaultMethods {
+		p.methods[column] = method
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		column, raw, ok := strings.Cut(entry, "=")
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !ok {
+			return nil, fmt.Errorf("invalid masking %q: use column=method", entry)
+		}
+		if !isPersonalColumn(column) {
+			return nil, fmt.Errorf("unknown personal data column %q (use %s)", column, strings.Join(PersonalColumns, ", "))
+		}
+		method, err := ParseMethod(raw)
+		if err != nil {
+			return nil, err
+		}
+		if method == MethodKeep {
+			delete(p.methods, column)
+		} else {
+			p.methods[column] = method
+		}
+	}
+	return p, nil
+}
+
+// PseudonymKeyFromEnv returns PSEUDONYM_KEY
+func PseudonymKeyFromEnv() string {
+	return os.Getenv("PSEUDONYM_KEY")
+}
+
+// ParseMethod parses pseudonym, mask, null or keep
+func ParseMethod(s string) (Method, error) {
+	switch m := Method(strings.ToLower(strings.TrimSpace(s))); m {
+	case MethodPseudonym, MethodMask, MethodNull, MethodKeep:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown masking method %q (use pseudonym, mask, null or keep)", s)
+}
+
+func isPersonalColumn(column string) bool {
+	for _, c := range PersonalColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// Method returns how column is masked, MethodKeep for columns left alone
+func (p *Pseudonymizer) Method(column string) Method {
+	if m, ok := p.methods[column]; ok {
+		return m
+	}
+	return MethodKeep
+}
+
+// Columns lists the masked columns in PersonalColumns order
+func (p *Pseudonymizer) Columns() []string {
+	var columns []string
+	for _, c := range PersonalColumns {
+		if _, ok := p.methods[c]; ok {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// Spec describes the masking of every personal column as NewPseudonymizer's
+// spec, e.g. for a manifest. The key is not included.
+func (p *Pseudonymizer) Spec() string {
+	parts := make([]string, len(PersonalColumns))
+	for i, column := range PersonalColumns {
+		parts[i] = column + "=" + string(p.Method(column))
+	}
+	return strings.Join(parts, ",")
+}
+
+// CheckInPlace reports an error if regnumber is masked, which is only
+// possible in exports: the candidate tables join on it, and later imports
+// and score files refer to candidates by it
+func (p *Pseudonymizer) CheckInPlace() error {
+	if p.Method("regnumber") != MethodKeep {
+		return fmt.Errorf("regnumber can only be masked in exports")
+	}
+	return nil
+}
+
+// Apply masks one value of column. It returns false when the value is
+// removed. Empty values stay empty.
+func (p *Pseudonymizer) Apply(column, value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", false
+	}
+	switch p.Method(column) {
+	case MethodPseudonym:
+		return p.pseudonym(column, value), true
+	case MethodMask:
+		return mask(column, value), true
+	case MethodNull:
+		return "", false
+	}
+	return value, true
+}
+
+// pseudonym hashes the value with the key, case-insensitively so that
+// "Okafor" and "OKAFOR" match, in a form that fits the column
+func (p *Pseudonymizer) pseudonym(column, value string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(column + "\x00" + strings.ToUpper(value)))
+	sum := mac.Sum(nil)
+	token := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum)
+
+	switch column {
+	case "email":
+		return strings.ToLower(token[:12]) + "@example.invalid"
+	case "gsmno":
+		// An 11 digit number, as Nigerian mobile numbers are written
+		return fmt.Sprintf("0%010d", binary.BigEndian.Uint64(sum)%10000000000)
+	case "regnumber", "exam_number":
+		// regnumber is VARCHAR(20)
+		return pseudonymPrefixes[column] + token[:12]
+	}
+	return pseudonymPrefixes[column] + "-" + token[:10]
+}
+
+// mask keeps the first and last characters of a value, and the domain of
+// an email address
+func mask(column, value string) string {
+	if column == "email" {
+		if local, domain, ok := strings.Cut(value, "@"); ok {
+			return mask("", local) + "@" + domain
+		}
+	}
+	runes := []rune(value)
+	if len(runes) <= 2 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[0]) + strings.Repeat("*", len(runes)-2) + string(runes[len(runes)-1])
+}
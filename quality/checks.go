@@ -0,0 +1,306 @@
+package quality
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// MaxAggregate is the highest UTME aggregate a candidate can score
+const MaxAggregate = 400
+
+// sampleSize is how many offending values a finding names
+const sampleSize = 5
+
+// Finding is the result of one check, for one year or, when Year is 0,
+// across every year checked
+type Finding struct {
+	Check  string
+	Year   int
+	Count  int64 // rows, columns or regnumbers found; 0 when the check passed
+	Detail string
+}
+
+// Report is the outcome of RunChecks
+type Report struct {
+	Findings    []Finding
+	Nulls       []ColumnNulls
+	Regressions []Regression
+}
+
+// Problems counts the findings that did not pass
+func (r *Report) Problems() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// reference is a candidate column that must match a row of another table
+type reference struct {
+	column string
+	table  string
+	key    string
+	text   bool
+}
+
+var references = []reference{
+	{column: "statecode", table: "state", key: "st_id"},
+	{column: "lg_id", table: "lga", key: "lg_id"},
+	{column: "inid", table: "institution", key: "inid", text: true},
+	{column: "app_course1", table: "course", key: "course_code", text: true},
+}
+
+// RunChecks checks the candidates of year, or of every year when year is
+// 0, for codes missing from their reference tables, NULL rates and their
+// regressions by threshold points, regnumbers held by more than one
+// candidate or moved between years, and aggregates outside 0-MaxAggregate
+func RunChecks(ctx context.Context, db *sql.DB, year int, threshold float64) (*Report, error) {
+	report := &Report{}
+	for _, ref := range references {
+		findings, err := orphans(ctx, db, ref, year)
+		if err != nil {
+			return nil, err
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+
+	// Every year is counted, as a year is compared with the one before it
+	counts, err := NullRatios(ctx, db, 0)
+	if err != nil {
+		return nil, err
+	}
+	regressions, err := Regressions(ctx, db, counts, threshold)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		if year == 0 || c.Year == year {
+			report.Nulls = append(report.Nulls, c)
+		}
+	}
+	for _, r := range regressions {
+		if year == 0 || r.Year == year {
+			report.Regressions = append(report.Regressions, r)
+		}
+	}
+	report.Findings = append(report.Findings, nullFindings(report.Nulls, report.Regressions)...)
+
+	for _, check := range []func(context.Context, *sql.DB, int) (Finding, error){duplicateRegnumbers, movedRegnumbers} {
+		f, err := check(ctx, db, year)
+		if err != nil {
+			return nil, err
+		}
+		report.Findings = append(report.Findings, f)
+	}
+
+	findings, err := aggregatesOutOfRange(ctx, db, year)
+	if err != nil {
+		return nil, err
+	}
+	report.Findings = append(report.Findings, findings...)
+	return report, nil
+}
+
+func tableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error looking up table %s: %w", table, err)
+	}
+	return exists, nil
+}
+
+// orphans finds candidates whose ref column holds a code missing from the
+// reference table, per year
+func orphans(ctx context.Context, db *sql.DB, ref reference, year int) ([]Finding, error) {
+	check := "orphaned " + ref.column
+	exists, err := tableExists(ctx, db, ref.table)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []Finding{{Check: check, Year: year, Detail: fmt.Sprintf("not checked: table %s does not exist", ref.table)}}, nil
+	}
+
+	value := "c." + ref.column
+	if ref.text {
+		value = fmt.Sprintf("NULLIF(TRIM(c.%s), '')", ref.column)
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT c.year, COUNT(*), COUNT(DISTINCT %[1]s),
+               (array_agg(DISTINCT %[1]s::text))[1:%[5]d]
+        FROM candidate c
+        WHERE %[1]s IS NOT NULL
+          AND NOT EXISTS (SELECT 1 FROM %[2]s r WHERE r.%[3]s = c.%[4]s)
+          AND ($1 = 0 OR c.year = $1)
+        GROUP BY c.year
+        ORDER BY c.year`, value, ref.table, ref.key, ref.column, sampleSize), year)
+	if err != nil {
+		return nil, fmt.Errorf("error checking %s against %s: %w", ref.column, ref.table, err)
+	}
+	defer rows.Close()
+
+	var findings []Finding
+	for rows.Next() {
+		var f Finding
+		var codes int64
+		var sample []string
+		if err := rows.Scan(&f.Year, &f.Count, &codes, pq.Array(&sample)); err != nil {
+			return nil, err
+		}
+		f.Check = check
+		f.Detail = fmt.Sprintf("%d codes not in %s, e.g. %s", codes, ref.table, strings.Join(sample, ", "))
+		findings = append(findings, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: check, Year: year, Detail: "every code found in " + ref.table})
+	}
+	return findings, nil
+}
+
+// nullFindings summarizes the NULL counts of each year: the emptiest column
+// and the columns that regressed
+func nullFindings(counts []ColumnNulls, regressions []Regression) []Finding {
+	var findings []Finding
+	worst := map[int]ColumnNulls{}
+	for _, c := range counts {
+		w, ok := worst[c.Year]
+		if !ok {
+			findings = append(findings, Finding{Check: "null rate", Year: c.Year})
+		}
+		if !ok || c.Percent() > w.Percent() {
+			worst[c.Year] = c
+		}
+	}
+	regressed := map[int][]string{}
+	for _, r := range regressions {
+		regressed[r.Year] = append(regressed[r.Year], fmt.Sprintf("%s %.1f%% (+%.1f since %s)", r.Column, r.Percent(), r.Rise(), r.Baseline))
+	}
+	for i := range findings {
+		f := &findings[i]
+		if w := worst[f.Year]; w.Nulls > 0 {
+			f.Detail = fmt.Sprintf("emptiest column %s at %.1f%%", w.Column, w.Percent())
+		} else {
+			f.Detail = "no missing values"
+		}
+		if list := regressed[f.Year]; len(list) > 0 {
+			f.Count = int64(len(list))
+			f.Detail = fmt.Sprintf("%d columns regressed: %s; %s", len(list), strings.Join(list, ", "), f.Detail)
+		}
+	}
+	return findings
+}
+
+// duplicateRegnumbers finds regnumbers that differ only in case or
+// surrounding spaces, which the regnumber key lets through as separate
+// candidates
+func duplicateRegnumbers(ctx context.Context, db *sql.DB, year int) (Finding, error) {
+	f := Finding{Check: "duplicate regnumber", Year: year}
+	rows, err := db.QueryContext(ctx, `
+        SELECT array_agg(regnumber || ' (' || year || ')' ORDER BY year, regnumber)
+        FROM candidate
+        GROUP BY UPPER(TRIM(regnumber))
+        HAVING COUNT(*) > 1 AND bool_or($1 = 0 OR year = $1)
+        ORDER BY UPPER(TRIM(regnumber))`, year)
+	if err != nil {
+		return f, fmt.Errorf("error checking for duplicate regnumbers: %w", err)
+	}
+	defer rows.Close()
+
+	var sample []string
+	for rows.Next() {
+		var group []string
+		if err := rows.Scan(pq.Array(&group)); err != nil {
+			return f, err
+		}
+		f.Count++
+		if len(sample) < sampleSize {
+			sample = append(sample, strings.Join(group, " = "))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return f, err
+	}
+	if f.Count == 0 {
+		f.Detail = "no regnumber is held by more than one candidate"
+	} else {
+		f.Detail = "e.g. " + strings.Join(sample, "; ")
+	}
+	return f, nil
+}
+
+// movedRegnumbers finds regnumbers a delta import moved from one year to
+// another, which usually means the number was reissued and the earlier
+// candidate overwritten
+func movedRegnumbers(ctx context.Context, db *sql.DB, year int) (Finding, error) {
+	f := Finding{Check: "regnumber in two years", Year: year}
+	exists, err := tableExists(ctx, db, "candidate_changes")
+	if err != nil {
+		return f, err
+	}
+	if !exists {
+		f.Detail = "no delta imports recorded"
+		return f, nil
+	}
+	var sample []string
+	err = db.QueryRowContext(ctx, `
+        SELECT COUNT(DISTINCT regnumber),
+               (array_agg(DISTINCT regnumber || ' ' || COALESCE(old_value, '?') || '->' || COALESCE(new_value, '?')))[1:$2]
+        FROM candidate_changes
+        WHERE column_name = 'year'
+          AND ($1 = 0 OR old_value = $1::text OR new_value = $1::text)`, year, sampleSize).Scan(&f.Count, pq.Array(&sample))
+	if err != nil {
+		return f, fmt.Errorf("error checking for regnumbers moved between years: %w", err)
+	}
+	if f.Count == 0 {
+		f.Detail = "no regnumber moved between years"
+	} else {
+		f.Detail = "e.g. " + strings.Join(sample, ", ")
+	}
+	return f, nil
+}
+
+// aggregatesOutOfRange finds aggregates below 0 or above MaxAggregate, per
+// year
+func aggregatesOutOfRange(ctx context.Context, db *sql.DB, year int) ([]Finding, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT year, COUNT(*), MIN(aggregate), MAX(aggregate)
+        FROM candidate
+        WHERE (aggregate < 0 OR aggregate > $2)
+          AND ($1 = 0 OR year = $1)
+        GROUP BY year
+        ORDER BY year`, year, MaxAggregate)
+	if err != nil {
+		return nil, fmt.Errorf("error checking aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	var findings []Finding
+	for rows.Next() {
+		var f Finding
+		var lowest, highest int
+		if err := rows.Scan(&f.Year, &f.Count, &lowest, &highest); err != nil {
+			return nil, err
+		}
+		f.Check = "aggregate out of range"
+		f.Detail = fmt.Sprintf("outside 0-%d, from %d to %d", MaxAggregate, lowest, highest)
+		findings = append(findings, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: "aggregate out of range", Year: year, Detail: fmt.Sprintf("every aggregate within 0-%d", MaxAggregate)})
+	}
+	return findings, nil
+}
@@ -0,0 +1,204 @@
+// Package quality measures how complete candidate data is and whether an
+// import made it worse.
+package quality
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// DefaultRegressionThreshold is the rise in a column's NULL percentage,
+// in points, reported as a regression
+const DefaultRegressionThreshold = 5.0
+
+// alwaysSet are the candidate columns that cannot be NULL
+var alwaysSet = map[string]bool{"regnumber": true, "year": true, "created_at": true, "updated_at": true}
+
+// ColumnNulls counts the missing values of one candidate column in a year
+type ColumnNulls struct {
+	Year   int
+	Column string
+	Rows   int64
+	Nulls  int64
+}
+
+// Percent is the share of rows missing the column, from 0 to 100
+func (c ColumnNulls) Percent() float64 {
+	if c.Rows == 0 {
+		return 0
+	}
+	return 100 * float64(c.Nulls) / float64(c.Rows)
+}
+
+type candidateColumn struct {
+	name string
+	text bool
+}
+
+func candidateColumns(ctx context.Context, db *sql.DB) ([]candidateColumn, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT column_name, data_type IN ('text', 'character varying', 'character')
+        FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'candidate'
+        ORDER BY ordinal_position`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading candidate columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []candidateColumn
+	for rows.Next() {
+		var c candidateColumn
+		if err := rows.Scan(&c.name, &c.text); err != nil {
+			return nil, err
+		}
+		if !alwaysSet[c.name] {
+			columns = append(columns, c)
+		}
+	}
+	return columns, rows.Err()
+}
+
+// NullRatios counts the missing values of every candidate column in each
+// year, or only in year when it is not 0, ordered by year and column.
+// Blank text counts as missing: imports store empty values as NULL, but
+// rows written by other means may not.
+func NullRatios(ctx context.Context, db *sql.DB, year int) ([]ColumnNulls, error) {
+	columns, err := candidateColumns(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, nil
+	}
+	counts := make([]string, len(columns))
+	for i, c := range columns {
+		name := pq.QuoteIdentifier(c.name)
+		if c.text {
+			counts[i] = fmt.Sprintf("COUNT(*) FILTER (WHERE NULLIF(TRIM(%s), '') IS NULL)", name)
+		} else {
+			counts[i] = fmt.Sprintf("COUNT(*) FILTER (WHERE %s IS NULL)", name)
+		}
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT year, COUNT(*), %s
+        FROM candidate
+        WHERE $1 = 0 OR year = $1
+        GROUP BY year
+        ORDER BY year`, strings.Join(counts, ", ")), year)
+	if err != nil {
+		return nil, fmt.Errorf("error counting NULLs: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ColumnNulls
+	for rows.Next() {
+		var y int
+		var total int64
+		nulls := make([]int64, len(columns))
+		ptrs := []interface{}{&y, &total}
+		for i := range nulls {
+			ptrs = append(ptrs, &nulls[i])
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, c := range columns {
+			result = append(result, ColumnNulls{Year: y, Column: c.name, Rows: total, Nulls: nulls[i]})
+		}
+	}
+	return result, rows.Err()
+}
+
+// Snapshot records the NULL counts of year's candidates, so the next
+// import of the year can be compared with this one
+func Snapshot(ctx context.Context, db *sql.DB, year int) error {
+	counts, err := NullRatios(ctx, db, year)
+	if err != nil || len(counts) == 0 {
+		return err
+	}
+	columns := make([]string, len(counts))
+	totals := make([]int64, len(counts))
+	nulls := make([]int64, len(counts))
+	for i, c := range counts {
+		columns[i], totals[i], nulls[i] = c.Column, c.Rows, c.Nulls
+	}
+	_, err = db.ExecContext(ctx, `
+        INSERT INTO null_snapshots (year, column_name, total_rows, null_rows)
+        SELECT $1, c, t, n FROM unnest($2::text[], $3::bigint[], $4::bigint[]) AS s(c, t, n)`,
+		year, pq.Array(columns), pq.Array(totals), pq.Array(nulls))
+	if err != nil {
+		return fmt.Errorf("error recording NULL counts: %w", err)
+	}
+	return nil
+}
+
+// Regression is a column whose share of NULLs rose by at least the
+// threshold compared with its baseline
+type Regression struct {
+	ColumnNulls
+	Before   float64 // NULL percentage of the baseline
+	Baseline string  // "previous import" or the year compared with
+}
+
+// Rise is the increase in the NULL percentage, in points
+func (r Regression) Rise() float64 {
+	return r.Percent() - r.Before
+}
+
+// Regressions finds the columns in counts whose NULL percentage rose by
+// at least threshold points. A year is compared with the snapshot taken
+// before its latest import; a year imported only once is compared with the
+// year before it.
+func Regressions(ctx context.Context, db *sql.DB, counts []ColumnNulls, threshold float64) ([]Regression, error) {
+	type key struct {
+		year   int
+		column string
+	}
+	previous := map[key]ColumnNulls{}
+	rows, err := db.QueryContext(ctx, `
+        SELECT year, column_name, total_rows, null_rows
+        FROM (
+            SELECT *, ROW_NUMBER() OVER (PARTITION BY year, column_name ORDER BY taken_at DESC, id DESC) AS n
+            FROM null_snapshots
+        ) s
+        WHERE n = 2`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading NULL snapshots: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ColumnNulls
+		if err := rows.Scan(&c.Year, &c.Column, &c.Rows, &c.Nulls); err != nil {
+			return nil, err
+		}
+		previous[key{c.Year, c.Column}] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byYear := map[key]ColumnNulls{}
+	for _, c := range counts {
+		byYear[key{c.Year, c.Column}] = c
+	}
+	var regressions []Regression
+	for _, c := range counts {
+		var r Regression
+		if before, ok := previous[key{c.Year, c.Column}]; ok {
+			r = Regression{ColumnNulls: c, Before: before.Percent(), Baseline: "previous import"}
+		} else if before, ok := byYear[key{c.Year - 1, c.Column}]; ok {
+			r = Regression{ColumnNulls: c, Before: before.Percent(), Baseline: fmt.Sprint(c.Year - 1)}
+		} else {
+			continue
+		}
+		if r.Rise() >= threshold {
+			regressions = append(regressions, r)
+		}
+	}
+	return regressions, nil
+}
@@ -0,0 +1,179 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Applicant is a candidate competing for a programme
+type Applicant struct {
+	RegNumber string
+	Aggregate int
+	StateID   int
+}
+
+// Admission is an applicant offered a place under a quota
+type Admission struct {
+	Applicant
+	InstitutionID string
+	CourseCode    string
+	Category      Category
+}
+
+// CategoryResult is how one quota on a programme was filled
+type CategoryResult struct {
+	Category Category
+	Slots    int
+	Filled   int
+	// Cutoff is the lowest aggregate admitted under the quota, 0 if none
+	Cutoff int
+}
+
+// Programme is the allocation for one course at one institution
+type Programme struct {
+	Capacity
+	Applicants int
+	Categories []CategoryResult
+	Admissions []Admission
+}
+
+// Allocate fills a programme's places from applicants, who must be sorted
+// best first. Merit places go to the best applicants regardless of state,
+// then catchment places to the best remaining from catchment states, then
+// ELDS places to the best remaining from ELDS states. Catchment and ELDS
+// places left empty for want of eligible applicants revert to merit.
+func Allocate(c Capacity, policy Policy, applicants []Applicant, catchment, elds map[int]bool) *Programme {
+	p := &Programme{Capacity: c, Applicants: len(applicants)}
+	slots := policy.Slots(c.Places)
+	taken := make([]bool, len(applicants))
+	results := make(map[Category]*CategoryResult)
+	for _, category := range Categories {
+		results[category] = &CategoryResult{Category: category, Slots: slots[category]}
+	}
+
+	fill := func(category Category, places int, eligible func(Applicant) bool) int {
+		filled := 0
+		for i, a := range applicants {
+			if filled == places {
+				break
+			}
+			if taken[i] || !eligible(a) {
+				continue
+			}
+			taken[i] = true
+			filled++
+			p.Admissions = append(p.Admissions, Admission{
+				Applicant: a, InstitutionID: c.InstitutionID, CourseCode: c.CourseCode, Category: category,
+			})
+			r := results[category]
+			r.Filled++
+			if r.Cutoff == 0 || a.Aggregate < r.Cutoff {
+				r.Cutoff = a.Aggregate
+			}
+		}
+		return filled
+	}
+
+	anyone := func(Applicant) bool { return true }
+	fill(Merit, slots[Merit], anyone)
+	unfilled := slots[Catchment] - fill(Catchment, slots[Catchment], func(a Applicant) bool { return catchment[a.StateID] })
+	unfilled += slots[ELDS] - fill(ELDS, slots[ELDS], func(a Applicant) bool { return elds[a.StateID] })
+	if unfilled > 0 {
+		results[Merit].Slots += unfilled
+		results[Catchment].Slots = results[Catchment].Filled
+		results[ELDS].Slots = results[ELDS].Filled
+		fill(Merit, unfilled, anyone)
+	}
+
+	for _, category := range Categories {
+		p.Categories = append(p.Categories, *results[category])
+	}
+	return p
+}
+
+// Run allocates places on every programme in capacities among the year's
+// applicants who chose it as their first choice
+func Run(ctx context.Context, db *sql.DB, year int, policy Policy, capacities []Capacity) ([]*Programme, error) {
+	elds, err := stateSet(ctx, db, "SELECT st_id FROM state WHERE st_elds")
+	if err != nil {
+		return nil, fmt.Errorf("error loading ELDS states: %w", err)
+	}
+
+	institutions := make([]string, len(capacities))
+	courses := make([]string, len(capacities))
+	for i, c := range capacities {
+		institutions[i] = c.InstitutionID
+		courses[i] = c.CourseCode
+	}
+	pools, err := loadApplicants(ctx, db, year, policy.MinAggregate, institutions, courses)
+	if err != nil {
+		return nil, err
+	}
+
+	catchments := make(map[string]map[int]bool)
+	programmes := make([]*Programme, 0, len(capacities))
+	for _, c := range capacities {
+		catchment, ok := catchments[c.InstitutionID]
+		if !ok {
+			catchment, err = stateSet(ctx, db, `
+                SELECT inst_state_id FROM institution WHERE inid = $1 AND inst_state_id IS NOT NULL
+                UNION SELECT affiliated_state_id FROM institution WHERE inid = $1 AND affiliated_state_id IS NOT NULL
+                UNION SELECT st_id FROM institution_catchment WHERE inid = $1`, c.InstitutionID)
+			if err != nil {
+				return nil, fmt.Errorf("error loading catchment of %s: %w", c.InstitutionID, err)
+			}
+			catchments[c.InstitutionID] = catchment
+		}
+		programmes = append(programmes, Allocate(c, policy, pools[c.InstitutionID+"\x00"+c.CourseCode], catchment, elds))
+	}
+	return programmes, nil
+}
+
+// loadApplicants returns each programme's applicants best first, keyed by
+// institution and course
+func loadApplicants(ctx context.Context, db *sql.DB, year, minAggregate int, institutions, courses []string) (map[string][]Applicant, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT c.inid, c.app_course1, c.regnumber, c.aggregate, COALESCE(c.statecode, 0)
+        FROM candidate c
+        JOIN UNNEST($2::text[], $3::text[]) AS p(inid, course_code)
+            ON c.inid = p.inid AND c.app_course1 = p.course_code
+        WHERE c.year = $1 AND c.aggregate > 0 AND c.aggregate >= $4
+        ORDER BY c.inid, c.app_course1, c.aggregate DESC, c.regnumber`,
+		year, pq.Array(institutions), pq.Array(courses), minAggregate)
+	if err != nil {
+		return nil, fmt.Errorf("error loading applicants: %w", err)
+	}
+	defer rows.Close()
+
+	pools := make(map[string][]Applicant)
+	for rows.Next() {
+		var inid, course string
+		var a Applicant
+		if err := rows.Scan(&inid, &course, &a.RegNumber, &a.Aggregate, &a.StateID); err != nil {
+			return nil, err
+		}
+		key := inid + "\x00" + course
+		pools[key] = append(pools[key], a)
+	}
+	return pools, rows.Err()
+}
+
+func stateSet(ctx context.Context, db *sql.DB, query string, args ...interface{}) (map[int]bool, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	states := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		states[id] = true
+	}
+	return states, rows.Err()
+}
@@ -0,0 +1,106 @@
+package quota
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Catchment applicants come from state 1 and ELDS applicants from state 2
+var (
+	testCatchment = map[int]bool{1: true}
+	testELDS      = map[int]bool{2: true}
+	testPolicy    = Policy{Merit: 0.5, Catchment: 0.3, ELDS: 0.2}
+)
+
+// applicants returns one applicant from each of states, best first and
+// lettered from A
+func applicants(states ...int) []Applicant {
+	var pool []Applicant
+	for i, state := range states {
+		pool = append(pool, Applicant{RegNumber: string(rune('A' + i)), Aggregate: 300 - 5*i, StateID: state})
+	}
+	return pool
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name       string
+		places     int
+		pool       []Applicant
+		admitted   map[Category]string
+		categories []CategoryResult
+	}{
+		{
+			// B is from a catchment state but good enough for merit, and F
+			// and H miss out to weaker applicants from quota states
+			name:   "every quota filled",
+			places: 10,
+			pool:   applicants(9, 1, 9, 9, 2, 9, 1, 9, 1, 2, 1, 2, 9),
+			admitted: map[Category]string{
+				Merit: "ABCDE", Catchment: "GIK", ELDS: "JL",
+			},
+			categories: []CategoryResult{
+				{Category: Merit, Slots: 5, Filled: 5, Cutoff: 280},
+				{Category: Catchment, Slots: 3, Filled: 3, Cutoff: 250},
+				{Category: ELDS, Slots: 2, Filled: 2, Cutoff: 245},
+			},
+		},
+		{
+			// Two catchment and both ELDS places revert to merit, which
+			// runs out of applicants before they are all filled
+			name:   "empty quotas revert to merit",
+			places: 10,
+			pool:   applicants(9, 1, 9, 9, 9, 9, 1, 9),
+			admitted: map[Category]string{
+				Merit: "ABCDEFH", Catchment: "G",
+			},
+			categories: []CategoryResult{
+				{Category: Merit, Slots: 9, Filled: 7, Cutoff: 265},
+				{Category: Catchment, Slots: 1, Filled: 1, Cutoff: 270},
+				{Category: ELDS, Slots: 0, Filled: 0, Cutoff: 0},
+			},
+		},
+		{
+			name:   "no applicants",
+			places: 4,
+			categories: []CategoryResult{
+				{Category: Merit, Slots: 4},
+				{Category: Catchment, Slots: 0},
+				{Category: ELDS, Slots: 0},
+			},
+		},
+		{
+			name:   "no places",
+			places: 0,
+			pool:   applicants(9, 1, 2),
+			categories: []CategoryResult{
+				{Category: Merit},
+				{Category: Catchment},
+				{Category: ELDS},
+			},
+		},
+	}
+	for _, tt := range tests {
+		c := Capacity{InstitutionID: "UNILAG", CourseCode: "MED", Places: tt.places}
+		p := Allocate(c, testPolicy, tt.pool, testCatchment, testELDS)
+		if p.Applicants != len(tt.pool) {
+			t.Errorf("%s: %d applicants, want %d", tt.name, p.Applicants, len(tt.pool))
+		}
+		admitted := make(map[Category]string)
+		for _, a := range p.Admissions {
+			if a.InstitutionID != "UNILAG" || a.CourseCode != "MED" {
+				t.Errorf("%s: %s admitted to %s at %s", tt.name, a.RegNumber, a.CourseCode, a.InstitutionID)
+			}
+			admitted[a.Category] += a.RegNumber
+		}
+		if tt.admitted == nil {
+			tt.admitted = map[Category]string{}
+		}
+		if !reflect.DeepEqual(admitted, tt.admitted) {
+			t.Errorf("%s: admitted %v, want %v", tt.name, admitted, tt.admitted)
+		}
+		if !reflect.DeepEqual(p.Categories, tt.categories) {
+			t.Errorf("%s: categories %+v, want %+v", tt.name, p.Categories, tt.categories)
+		}
+	}
+}
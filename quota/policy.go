@@ -0,0 +1,134 @@
+// Package quota allocates admission places on each programme between the
+// merit, catchment and educationally less developed states (ELDS) quotas.
+package quota
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Category is an admission quota
+type Category string
+
+const (
+	Merit     Category = "merit"
+	Catchment Category = "catchment"
+	ELDS      Category = "elds"
+)
+
+// Categories lists the quotas in the order places are filled
+var Categories = []Category{Merit, Catchment, ELDS}
+
+// Policy sets the share of each programme's places given to each quota
+type Policy struct {
+	Merit     float64
+	Catchment float64
+	ELDS      float64
+	// MinAggregate is the lowest aggregate that may be admitted at all
+	MinAggregate int
+}
+
+// DefaultPolicy is the national 45/35/20 split
+var DefaultPolicy = Policy{Merit: 0.45, Catchment: 0.35, ELDS: 0.20}
+
+// ParseRatios parses shares written as merit/catchment/elds, either as
+// fractions or percentages, e.g. "45/35/20"
+func ParseRatios(s string) (Policy, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return Policy{}, fmt.Errorf("expected merit/catchment/elds, e.g. 45/35/20")
+	}
+	var shares [3]float64
+	total := 0.0
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || v < 0 {
+			return Policy{}, fmt.Errorf("invalid share %q", part)
+		}
+		shares[i] = v
+		total += v
+	}
+	if total == 0 {
+		return Policy{}, fmt.Errorf("at least one share must be positive")
+	}
+	return Policy{Merit: shares[0] / total, Catchment: shares[1] / total, ELDS: shares[2] / total}, nil
+}
+
+// String formats the shares as percentages
+func (p Policy) String() string {
+	return fmt.Sprintf("%.0f/%.0f/%.0f", p.Merit*100, p.Catchment*100, p.ELDS*100)
+}
+
+// Slots divides capacity between the quotas. Catchment and ELDS are
+// rounded down so any remainder goes to merit.
+func (p Policy) Slots(capacity int) map[Category]int {
+	total := p.Merit + p.Catchment + p.ELDS
+	catchment := int(math.Floor(float64(capacity) * p.Catchment / total))
+	elds := int(math.Floor(float64(capacity) * p.ELDS / total))
+	return map[Category]int{
+		Merit:     capacity - catchment - elds,
+		Catchment: catchment,
+		ELDS:      elds,
+	}
+}
+
+// Capacity is the number of places on a course at an institution
+type Capacity struct {
+	InstitutionID string
+	CourseCode    string
+	Places        int
+}
+
+// ReadCapacities reads a CSV with a header row and the columns inid,
+// course_code and capacity
+func ReadCapacities(r io.Reader) ([]Capacity, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading capacities header: %w", err)
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"inid", "course_code", "capacity"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("capacities file has no %s column", required)
+		}
+	}
+
+	var capacities []Capacity
+	seen := make(map[string]bool)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c := Capacity{
+			InstitutionID: strings.TrimSpace(record[index["inid"]]),
+			CourseCode:    strings.TrimSpace(record[index["course_code"]]),
+		}
+		c.Places, err = strconv.Atoi(strings.TrimSpace(record[index["capacity"]]))
+		if err != nil || c.Places < 0 {
+			return nil, fmt.Errorf("line %d: invalid capacity %q", line, record[index["capacity"]])
+		}
+		key := c.InstitutionID + "\x00" + c.CourseCode
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: %s at %s is listed twice", line, c.CourseCode, c.InstitutionID)
+		}
+		seen[key] = true
+		capacities = append(capacities, c)
+	}
+	if len(capacities) == 0 {
+		return nil, fmt.Errorf("capacities file lists no programmes")
+	}
+	return capacities, nil
+}
+
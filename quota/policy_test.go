@@ -0,0 +1,92 @@
+package quota
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPolicySlots(t *testing.T) {
+	tests := []struct {
+		policy   Policy
+		capacity int
+		want     [3]int // merit, catchment, ELDS
+	}{
+		{DefaultPolicy, 100, [3]int{45, 35, 20}},
+		// Catchment and ELDS round down, leaving the remainder to merit
+		{DefaultPolicy, 10, [3]int{5, 3, 2}},
+		{DefaultPolicy, 7, [3]int{4, 2, 1}},
+		{DefaultPolicy, 1, [3]int{1, 0, 0}},
+		{DefaultPolicy, 0, [3]int{0, 0, 0}},
+		// Shares need not add up to one
+		{Policy{Merit: 45, Catchment: 35, ELDS: 20}, 20, [3]int{9, 7, 4}},
+		{Policy{Catchment: 1}, 3, [3]int{0, 3, 0}},
+	}
+	for _, tt := range tests {
+		slots := tt.policy.Slots(tt.capacity)
+		got := [3]int{slots[Merit], slots[Catchment], slots[ELDS]}
+		if got != tt.want {
+			t.Errorf("%v.Slots(%d) = %v, want %v", tt.policy, tt.capacity, got, tt.want)
+		}
+	}
+}
+
+func TestParseRatios(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // the policy as percentages, or the error
+	}{
+		{"45/35/20", "45/35/20"},
+		{"0.45 / 0.35 / 0.2", "45/35/20"},
+		{"9/7/4", "45/35/20"},
+		{"1/0/0", "100/0/0"},
+		{"45/55", "expected merit/catchment/elds"},
+		{"45/35/20/0", "expected merit/catchment/elds"},
+		{"45/x/20", `invalid share "x"`},
+		{"45/-5/60", `invalid share "-5"`},
+		{"0/0/0", "at least one share must be positive"},
+	}
+	for _, tt := range tests {
+		policy, err := ParseRatios(tt.input)
+		got := policy.String()
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("ParseRatios(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestReadCapacities(t *testing.T) {
+	capacities, err := ReadCapacities(strings.NewReader(
+		"Capacity, INID ,course_code,faculty\n120,UNILAG,MED,Medicine\n0, UNIBEN ,LAW,Law\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Capacity{
+		{InstitutionID: "UNILAG", CourseCode: "MED", Places: 120},
+		{InstitutionID: "UNIBEN", CourseCode: "LAW", Places: 0},
+	}
+	if !reflect.DeepEqual(capacities, want) {
+		t.Errorf("capacities = %+v, want %+v", capacities, want)
+	}
+
+	invalid := []struct {
+		input string
+		want  string
+	}{
+		{"", "error reading capacities header"},
+		{"inid,capacity\nUNILAG,120\n", "no course_code column"},
+		{"inid,course_code,capacity\n", "lists no programmes"},
+		{"inid,course_code,capacity\nUNILAG,MED,many\n", `line 2: invalid capacity "many"`},
+		{"inid,course_code,capacity\nUNILAG,MED,-1\n", `line 2: invalid capacity "-1"`},
+		{"inid,course_code,capacity\nUNILAG,MED,120\nUNILAG,LAW,80\nUNILAG, MED,10\n", "line 4: MED at UNILAG is listed twice"},
+	}
+	for _, tt := range invalid {
+		_, err := ReadCapacities(strings.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ReadCapacities(%q) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/quota"
+)
+
+// handleQuotaAllocation allocates programme places between the merit,
+// catchment and ELDS quotas and writes the resulting admission list
+func handleQuotaAllocation(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nQuota Allocation")
+	fmt.Print("Year of applicants: ")
+	year := readInt()
+	if year == 0 {
+		return fmt.Errorf("a year is required")
+	}
+
+	fmt.Print("Capacities CSV (columns inid, course_code, capacity): ")
+	path := readString()
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening capacities: %w", err)
+	}
+	capacities, err := quota.ReadCapacities(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	policy := quota.DefaultPolicy
+	fmt.Printf("Merit/catchment/ELDS shares [%s]: ", policy)
+	if input := readString(); input != "" {
+		if policy, err = quota.ParseRatios(input); err != nil {
+			return err
+		}
+	}
+	fmt.Print("Minimum aggregate to admit (0 for none): ")
+	policy.MinAggregate = readInt()
+
+	programmes, err := quota.Run(ctx, db, year, policy, capacities)
+	if err != nil {
+		return err
+	}
+
+	table := newResultTable("quota-allocation")
+	table.SetHeader([]string{"Institution", "Course", "Capacity", "Applicants", "Quota", "Places", "Filled", "Cutoff"})
+	admitted := 0
+	for _, p := range programmes {
+		admitted += len(p.Admissions)
+		for _, c := range p.Categories {
+			cutoff := "-"
+			if c.Filled > 0 {
+				cutoff = strconv.Itoa(c.Cutoff)
+			}
+			table.Append([]string{p.InstitutionID, p.CourseCode, strconv.Itoa(p.Places), strconv.Itoa(p.Applicants),
+				string(c.Category), strconv.Itoa(c.Slots), strconv.Itoa(c.Filled), cutoff})
+		}
+	}
+	color.Cyan("\nAllocation for %d (%s)", year, policy)
+	table.Render()
+	fmt.Printf("%d applicants offered places on %d programmes\n", admitted, len(programmes))
+
+	if publicOutput != nil {
+		color.Yellow("The admission list is not written while public output mode is on")
+		return nil
+	}
+	fmt.Print("Save the admission list to CSV (blank to skip): ")
+	out := readString()
+	if out == "" {
+		return nil
+	}
+	if err := writeAllocation(out, programmes); err != nil {
+		return err
+	}
+	color.Green("Wrote %d admissions to %s", admitted, out)
+	return nil
+}
+
+func writeAllocation(path string, programmes []*quota.Programme) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"regnumber", "inid", "course_code", "category", "aggregate", "statecode"})
+	for _, p := range programmes {
+		for _, a := range p.Admissions {
+			w.Write([]string{a.RegNumber, a.InstitutionID, a.CourseCode, string(a.Category),
+				strconv.Itoa(a.Aggregate), strconv.Itoa(a.StateID)})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
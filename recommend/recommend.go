@@ -0,0 +1,263 @@
+// Package recommend suggests courses and institutions a candidate's UTME
+// result is likely to get them into, judged against past admissions.
+package recommend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SubjectCount is the number of subjects a UTME aggregate is summed from
+	SubjectCount = 4
+	// DefaultYears is how many of the latest years of admissions are used
+	DefaultYears = 3
+	// DefaultBand is how far either side of the candidate's aggregate past
+	// applicants count as comparable
+	DefaultBand = 10
+	// DefaultLimit is how many recommendations are returned
+	DefaultLimit = 20
+	// minStateSample is how many comparable applicants from the candidate's
+	// state are needed before their admission rate is used instead of the
+	// rate across all states
+	minStateSample = 20
+)
+
+// ErrInvalidRequest is wrapped by errors in the request itself, as opposed
+// to errors querying the database
+var ErrInvalidRequest = errors.New("invalid request")
+
+// Request describes the candidate to recommend courses for
+type Request struct {
+	// Scores maps subject abbreviations or names to scores out of 100
+	Scores map[string]int
+	// StateID is the candidate's state of origin, 0 if unknown
+	StateID int
+	Years   int
+	Band    int
+	Limit   int
+}
+
+// Recommendation is a course at an institution whose historical cutoff
+// the candidate's aggregate clears
+type Recommendation struct {
+	CourseCode    string `json:"course_code"`
+	CourseName    string `json:"course_name"`
+	InstitutionID string `json:"institution_id"`
+	Institution   string `json:"institution"`
+	// Cutoff is the lowest admitted aggregate, averaged over the years
+	// the course admitted anyone
+	Cutoff float64 `json:"cutoff"`
+	Years  int     `json:"years"`
+	Margin float64 `json:"margin"`
+	// Probability is the smoothed share of comparable past applicants who
+	// were admitted; Comparable is how many there were and Basis whether
+	// they were drawn from the candidate's state or all states
+	Probability float64 `json:"probability"`
+	Comparable  int     `json:"comparable"`
+	Basis       string  `json:"basis"`
+}
+
+// Result is the candidate's aggregate and their recommendations, most
+// likely first
+type Result struct {
+	Aggregate       int              `json:"aggregate"`
+	Recommendations []Recommendation `json:"recommendations"`
+}
+
+// ParseScores parses scores written as "ENG=70,MTH=65,PHY=60,CHM=58"
+func ParseScores(s string) (map[string]int, error) {
+	scores := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		subject, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		subject = strings.TrimSpace(subject)
+		if !ok || subject == "" {
+			return nil, fmt.Errorf("invalid score %q, expected SUBJECT=SCORE", part)
+		}
+		score, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid score for %s: %q", subject, value)
+		}
+		scores[subject] = score
+	}
+	return scores, nil
+}
+
+// Validate checks there are four distinct subjects scored 0-100 and fills
+// in defaults
+func (r *Request) Validate() error {
+	if len(r.Scores) != SubjectCount {
+		return fmt.Errorf("%w: %d subject scores are needed, got %d", ErrInvalidRequest, SubjectCount, len(r.Scores))
+	}
+	for subject, score := range r.Scores {
+		if score < 0 || score > 100 {
+			return fmt.Errorf("%w: score for %s must be between 0 and 100", ErrInvalidRequest, subject)
+		}
+	}
+	if r.Years <= 0 {
+		r.Years = DefaultYears
+	}
+	if r.Band <= 0 {
+		r.Band = DefaultBand
+	}
+	if r.Limit <= 0 {
+		r.Limit = DefaultLimit
+	}
+	return nil
+}
+
+// Aggregate is the sum of the candidate's subject scores
+func (r *Request) Aggregate() int {
+	total := 0
+	for _, score := range r.Scores {
+		total += score
+	}
+	return total
+}
+
+// StateID resolves a state given by ID or name
+func StateID(ctx context.Context, db *sql.DB, state string) (int, error) {
+	if id, err := strconv.Atoi(strings.TrimSpace(state)); err == nil {
+		return id, nil
+	}
+	var id int
+	err := db.QueryRowContext(ctx, "SELECT st_id FROM state WHERE UPPER(st_name) = UPPER($1)", strings.TrimSpace(state)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: unknown state %q", ErrInvalidRequest, state)
+	}
+	return id, err
+}
+
+// checkSubjects confirms every subject is known by abbreviation or name
+func checkSubjects(ctx context.Context, db *sql.DB, scores map[string]int) error {
+	known := make(map[string]bool)
+	rows, err := db.QueryContext(ctx, "SELECT COALESCE(su_abrv, ''), COALESCE(su_name, '') FROM subject")
+	if err != nil {
+		return fmt.Errorf("error loading subjects: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var abrv, name string
+		if err := rows.Scan(&abrv, &name); err != nil {
+			return err
+		}
+		known[strings.ToLower(strings.TrimSpace(abrv))] = true
+		known[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for subject := range scores {
+		key := strings.ToLower(strings.TrimSpace(subject))
+		if !known[key] {
+			return fmt.Errorf("%w: unknown subject %q", ErrInvalidRequest, subject)
+		}
+		if seen[key] {
+			return fmt.Errorf("%w: subject %q is given twice", ErrInvalidRequest, subject)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// recommendSQL finds every course and institution whose average yearly
+// cutoff $1 clears over the latest $2 years, with the admissions of past
+// applicants within $4 of $1 overall and from state $3
+const recommendSQL = `
+    WITH history AS (
+        SELECT c.app_course1, c.inid, c.year, c.aggregate, c.is_admitted, c.statecode
+        FROM candidate c
+        WHERE c.year > (SELECT MAX(latest.year) FROM candidate latest) - $2
+            AND c.aggregate > 0
+            AND c.app_course1 IS NOT NULL
+            AND c.inid IS NOT NULL
+    ),
+    cutoffs AS (
+        SELECT app_course1, inid, AVG(cutoff) AS cutoff, COUNT(*) AS years
+        FROM (
+            SELECT app_course1, inid, year, MIN(aggregate) AS cutoff
+            FROM history
+            WHERE is_admitted
+            GROUP BY app_course1, inid, year
+        ) yearly
+        GROUP BY app_course1, inid
+    ),
+    comparable AS (
+        SELECT app_course1, inid,
+            COUNT(*) AS applicants,
+            COUNT(*) FILTER (WHERE is_admitted) AS admitted,
+            COUNT(*) FILTER (WHERE statecode = $3) AS state_applicants,
+            COUNT(*) FILTER (WHERE statecode = $3 AND is_admitted) AS state_admitted
+        FROM history
+        WHERE aggregate BETWEEN $1 - $4 AND $1 + $4
+        GROUP BY app_course1, inid
+    )
+    SELECT co.course_code, co.course_name, i.inid, i.inname,
+        cc.cutoff::float8, cc.years,
+        COALESCE(n.applicants, 0), COALESCE(n.admitted, 0),
+        COALESCE(n.state_applicants, 0), COALESCE(n.state_admitted, 0)
+    FROM cutoffs cc
+    JOIN course co ON co.course_code = cc.app_course1
+    JOIN institution i ON i.inid = cc.inid
+    LEFT JOIN comparable n ON n.app_course1 = cc.app_course1 AND n.inid = cc.inid
+    WHERE $1 >= cc.cutoff`
+
+// Recommend lists courses whose historical cutoff the candidate clears,
+// ranked by the estimated probability of admission
+func Recommend(ctx context.Context, db *sql.DB, req Request) (*Result, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkSubjects(ctx, db, req.Scores); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Aggregate: req.Aggregate(), Recommendations: []Recommendation{}}
+	rows, err := db.QueryContext(ctx, recommendSQL, result.Aggregate, req.Years, req.StateID, req.Band)
+	if err != nil {
+		return nil, fmt.Errorf("error finding courses: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec Recommendation
+		var applicants, admitted, stateApplicants, stateAdmitted int
+		err := rows.Scan(&rec.CourseCode, &rec.CourseName, &rec.InstitutionID, &rec.Institution,
+			&rec.Cutoff, &rec.Years, &applicants, &admitted, &stateApplicants, &stateAdmitted)
+		if err != nil {
+			return nil, err
+		}
+		rec.Margin = float64(result.Aggregate) - rec.Cutoff
+		rec.Basis = "all states"
+		if req.StateID != 0 && stateApplicants >= minStateSample {
+			applicants, admitted = stateApplicants, stateAdmitted
+			rec.Basis = "same state"
+		}
+		// Laplace smoothing keeps a handful of applicants from giving 0% or 100%
+		rec.Probability = float64(admitted+1) / float64(applicants+2)
+		rec.Comparable = applicants
+		result.Recommendations = append(result.Recommendations, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result.Recommendations, func(i, j int) bool {
+		a, b := result.Recommendations[i], result.Recommendations[j]
+		if a.Probability != b.Probability {
+			return a.Probability > b.Probability
+		}
+		return a.Margin > b.Margin
+	})
+	if len(result.Recommendations) > req.Limit {
+		result.Recommendations = result.Recommendations[:req.Limit]
+	}
+	return result, nil
+}
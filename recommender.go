@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/recommend"
+)
+
+// handleCourseRecommender suggests courses a candidate's UTME scores are
+// likely to get them into
+func handleCourseRecommender(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nCourse Recommender")
+	fmt.Print("Subject scores, e.g. ENG=70,MTH=65,PHY=60,CHM=58: ")
+	scores, err := recommend.ParseScores(readString())
+	if err != nil {
+		return err
+	}
+	req := recommend.Request{Scores: scores}
+
+	state, err := readEntity(ctx, db, "State of origin (blank to compare with all states): ", entityState)
+	if err != nil {
+		return err
+	}
+	if state != "" {
+		if req.StateID, err = recommend.StateID(ctx, db, state); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Years of admissions to consider (default %d): ", recommend.DefaultYears)
+	req.Years = readInt()
+
+	result, err := recommend.Recommend(ctx, db, req)
+	if err != nil {
+		return err
+	}
+	if len(result.Recommendations) == 0 {
+		color.Yellow("An aggregate of %d clears no course's historical cutoff", result.Aggregate)
+		return nil
+	}
+
+	table := newResultTable("course-recommendations")
+	table.SetHeader([]string{"Course", "Institution", "Cutoff", "Margin", "Admission Chance", "Comparable Applicants"})
+	for _, rec := range result.Recommendations {
+		table.Append([]string{
+			fmt.Sprintf("%s (%s)", rec.CourseName, rec.CourseCode),
+			rec.Institution,
+			fmt.Sprintf("%.0f", rec.Cutoff),
+			fmt.Sprintf("+%.0f", rec.Margin),
+			fmt.Sprintf("%.0f%%", rec.Probability*100),
+			strconv.Itoa(rec.Comparable) + " (" + rec.Basis + ")",
+		})
+	}
+
+	color.Cyan("\nCourses an aggregate of %d is likely to get into", result.Aggregate)
+	table.Render()
+	fmt.Printf("Chances are the share admitted among past applicants within %d points of %d\n",
+		recommend.DefaultBand, result.Aggregate)
+	return nil
+}
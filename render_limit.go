@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/fatih/color"
+)
+
+const (
+	defaultRenderRows  = 2000
+	defaultRenderBytes = 8 << 20
+)
+
+// renderLimit caps what is drawn as a console table. The table is built
+// in memory before it is drawn, and nobody reads thousands of rows in a
+// terminal, so larger results are drawn up to the cap and written whole to
+// a file instead.
+type renderLimit struct {
+	rows  int
+	bytes int // approximate size of the cell text
+}
+
+// consoleRender is the limit console tables use, set from the environment
+// at startup
+var consoleRender = renderLimit{rows: defaultRenderRows, bytes: defaultRenderBytes}
+
+// renderLimitFromEnv reads RENDER_MAX_ROWS (default 2000) and
+// RENDER_MAX_BYTES (default 8 MiB)
+func renderLimitFromEnv() (renderLimit, error) {
+	limit := consoleRender
+	if raw := os.Getenv("RENDER_MAX_ROWS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return limit, fmt.Errorf("RENDER_MAX_ROWS %q is not a positive whole number", raw)
+		}
+		limit.rows = n
+	}
+	if raw := os.Getenv("RENDER_MAX_BYTES"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1024 {
+			return limit, fmt.Errorf("RENDER_MAX_BYTES %q is not a whole number of at least 1024", raw)
+		}
+		limit.bytes = n
+	}
+	return limit, nil
+}
+
+// fit returns how many of rows can be drawn within the limit
+func (l renderLimit) fit(rows [][]interface{}) int {
+	size := 0
+	for i, row := range rows {
+		if i == l.rows {
+			return i
+		}
+		for _, cell := range row {
+			switch v := cell.(type) {
+			case string:
+				size += len(v)
+			case []byte:
+				size += len(v)
+			default:
+				size += 8
+			}
+		}
+		if size > l.bytes {
+			return i
+		}
+	}
+	return len(rows)
+}
+
+// limitForConsole returns the rows of a result to draw. When the result is
+// over the limit, the rows are written whole to a CSV file, unless saved
+// already is where Result Output saved them, and a warning on stderr names
+// the file.
+func limitForConsole(name string, header []string, rows [][]interface{}, saved string) [][]interface{} {
+	n := consoleRender.fit(rows)
+	if n == len(rows) {
+		return rows
+	}
+	path := saved
+	if path == "" {
+		dir := savedResults.dir
+		if dir == "" {
+			dir = "results"
+		}
+		var err error
+		if path, err = (resultOutput{format: "csv", dir: dir}).save(name, header, rows); err != nil {
+			color.New(color.FgRed).Fprintf(os.Stderr, "Result has %d rows, too many to show; showing the first %d. Writing them all failed: %v\n", len(rows), n, err)
+			return rows[:n]
+		}
+	}
+	color.New(color.FgYellow).Fprintf(os.Stderr, "Result has %d rows, too many to show; showing the first %d. All rows are in %s\n", len(rows), n, path)
+	return rows[:n]
+}
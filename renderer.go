@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/xuri/excelize/v2"
+)
+
+// Renderer writes one analysis result: a named table of rows. Cells are
+// usually preformatted strings from the display functions, but may be
+// typed values from a query.
+type Renderer interface {
+	Render(name string, header []string, rows [][]interface{}) error
+}
+
+// TableRenderer draws the aligned console table every menu item shows
+type TableRenderer struct {
+	W        io.Writer
+	NoWrap   bool // keep long cells on one line
+	Width    int  // draw records instead when the table is wider; 0 for no limit
+	Vertical bool // always draw records
+}
+
+func (r TableRenderer) Render(name string, header []string, rows [][]interface{}) error {
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		cells[i] = tableNumbers.localize(header, cellStrings(row, "NULL"))
+	}
+	if r.Vertical || r.Width > 0 && len(header) > 2 && tableWidth(header, cells, r.NoWrap) > r.Width {
+		return renderRecords(r.W, header, cells, r.Width)
+	}
+	table := tablewriter.NewWriter(r.W)
+	table.SetHeader(header)
+	if r.NoWrap {
+		table.SetAutoWrapText(false)
+	}
+	table.AppendBulk(cells)
+	table.Render()
+	return nil
+}
+
+// CSVRenderer writes a header row and one record per row
+type CSVRenderer struct {
+	W io.Writer
+}
+
+func (r CSVRenderer) Render(name string, header []string, rows [][]interface{}) error {
+	w := csv.NewWriter(r.W)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := w.Write(cellStrings(row, "")); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// TSVRenderer writes tab-separated lines, which spreadsheets split into
+// cells when pasted. Tabs and line breaks inside cells become spaces.
+type TSVRenderer struct {
+	W io.Writer
+}
+
+var tsvCleaner = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+
+func (r TSVRenderer) Render(name string, header []string, rows [][]interface{}) error {
+	line := func(cells []string) error {
+		for i, c := range cells {
+			cells[i] = tsvCleaner.Replace(c)
+		}
+		_, err := fmt.Fprintln(r.W, strings.Join(cells, "\t"))
+		return err
+	}
+	if err := line(append([]string(nil), header...)); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := line(cellStrings(row, "")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JSONRenderer writes an array of objects keyed by column name. Numeric
+// text is written as numbers.
+type JSONRenderer struct {
+	W io.Writer
+}
+
+func (r JSONRenderer) Render(name string, header []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		_, err := io.WriteString(r.W, "[]\n")
+		return err
+	}
+	// Objects are encoded one at a time rather than building them all, so
+	// large results are not held in memory twice
+	w := bufio.NewWriter(r.W)
+	w.WriteString("[\n")
+	record := make(map[string]interface{}, len(header))
+	for i, row := range rows {
+		for j, column := range header {
+			if j < len(row) {
+				record[column] = cellValue(row[j])
+			} else {
+				delete(record, column)
+			}
+		}
+		data, err := json.MarshalIndent(record, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		w.WriteString("  ")
+		w.Write(data)
+		if i < len(rows)-1 {
+			w.WriteString(",")
+		}
+		w.WriteString("\n")
+	}
+	w.WriteString("]\n")
+	return w.Flush()
+}
+
+// XLSXRenderer writes a workbook at Path with the result on one sheet.
+// Numeric text is stored as numbers so the sheet can be analysed directly.
+type XLSXRenderer struct {
+	Path string
+}
+
+func (r XLSXRenderer) Render(name string, header []string, rows [][]interface{}) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := sheetName(name)
+	if err := f.SetSheetName(f.GetSheetName(0), sheet); err != nil {
+		return err
+	}
+	headerCells := make([]interface{}, len(header))
+	for i, h := range header {
+		headerCells[i] = h
+	}
+	if err := f.SetSheetRow(sheet, "A1", &headerCells); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		cells := make([]interface{}, len(row))
+		for j, v := range row {
+			cells[j] = cellValue(v)
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		if err := f.SetSheetRow(sheet, cell, &cells); err != nil {
+			return err
+		}
+	}
+	return f.SaveAs(r.Path)
+}
+
+// resultFormats are the formats a result can be saved in
+var resultFormats = []string{"table", "csv", "json", "xlsx"}
+
+func parseResultFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	for _, f := range resultFormats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q; use one of %s", format, strings.Join(resultFormats, ", "))
+}
+
+// newFileRenderer returns a renderer for format writing to path, and the
+// file to close afterwards (nil for workbooks, which are saved whole)
+func newFileRenderer(format, path string) (Renderer, io.Closer, error) {
+	if format == "xlsx" {
+		return XLSXRenderer{Path: path}, nil, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating %s: %w", path, err)
+	}
+	switch format {
+	case "csv":
+		return CSVRenderer{W: file}, file, nil
+	case "json":
+		return JSONRenderer{W: file}, file, nil
+	default:
+		return TableRenderer{W: file}, file, nil
+	}
+}
+
+// resultOutput is where the menu saves analysis results besides showing
+// them. The zero value only shows them.
+type resultOutput struct {
+	format string // csv, json or xlsx; empty for the console only
+	dir    string
+}
+
+var savedResults resultOutput
+
+// save writes a result to a new timestamped file in the output directory
+func (o resultOutput) save(name string, header []string, rows [][]interface{}) (string, error) {
+	if err := os.MkdirAll(o.dir, 0o755); err != nil {
+		return "", fmt.Errorf("error creating %s: %w", o.dir, err)
+	}
+	path := filepath.Join(o.dir, fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), o.format))
+	renderer, file, err := newFileRenderer(o.format, path)
+	if err != nil {
+		return "", err
+	}
+	err = renderer.Render(name, header, rows)
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// resultTable collects an analysis result, then shows it on the console
+// and saves it in the format chosen under Result Output. It has the
+// methods of the tablewriter tables the display functions used before.
+type resultTable struct {
+	name   string
+	header []string
+	rows   [][]interface{}
+	noWrap bool
+}
+
+// newResultTable starts a result; name is used for saved file names
+func newResultTable(name string) *resultTable {
+	return &resultTable{name: name}
+}
+
+func (t *resultTable) SetHeader(header []string) { t.header = header }
+
+func (t *resultTable) SetAutoWrapText(wrap bool) { t.noWrap = !wrap }
+
+func (t *resultTable) Append(row []string) {
+	cells := make([]interface{}, len(row))
+	for i, v := range row {
+		cells[i] = v
+	}
+	t.rows = append(t.rows, cells)
+}
+
+func (t *resultTable) Render() {
+	showResult(t.name, t.header, t.rows, t.noWrap)
+}
+
+func showResult(name string, header []string, rows [][]interface{}, noWrap bool) {
+	var path string
+	var saveErr error
+	if savedResults.format != "" {
+		path, saveErr = savedResults.save(name, header, rows)
+	}
+	consoleTable(noWrap).Render(name, header, limitForConsole(name, header, rows, path))
+	rememberShown(name, header, rows, reportSQL(name))
+	if saveErr != nil {
+		color.Red("%v", saveErr)
+	} else if path != "" {
+		color.Green("Saved %d rows to %s", len(rows), path)
+	}
+}
+
+// handleResultOutput chooses whether analysis results are also saved
+func handleResultOutput() error {
+	color.Cyan("\nResult Output")
+	if savedResults.format == "" {
+		fmt.Println("Results are shown on the console only.")
+	} else {
+		fmt.Printf("Results are also saved as %s in %s.\n", savedResults.format, savedResults.dir)
+	}
+	fmt.Print("Save results as (table, csv, json, xlsx; table for the console only) [table]: ")
+	input := readString()
+	if input == "" {
+		input = "table"
+	}
+	format, err := parseResultFormat(input)
+	if err != nil {
+		return err
+	}
+	if format == "table" {
+		savedResults = resultOutput{}
+		color.Green("Results will be shown on the console only")
+		return nil
+	}
+
+	dir := savedResults.dir
+	if dir == "" {
+		dir = "results"
+	}
+	fmt.Printf("Directory for saved results [%s]: ", dir)
+	if input := readString(); input != "" {
+		dir = input
+	}
+	savedResults = resultOutput{format: format, dir: dir}
+	color.Green("Results will also be saved as %s in %s", format, dir)
+	return nil
+}
+
+func cellStrings(row []interface{}, null string) []string {
+	cells := make([]string, len(row))
+	for i, v := range row {
+		if v == nil {
+			cells[i] = null
+		} else {
+			cells[i] = formatConsoleValue(v)
+		}
+	}
+	return cells
+}
+
+var numericText = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?$`)
+
+// cellValue converts numeric text to a number for typed formats; codes with
+// leading zeros stay text
+func cellValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return cellValue(string(v))
+	case string:
+		if numericText.MatchString(v) {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return v
+	}
+}
+
+// sheetName makes a valid worksheet name, which is at most 31 characters
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	if name == "" {
+		name = "Result"
+	}
+	return name
+}
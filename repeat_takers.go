@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/tracking"
+)
+
+var repeaterSummaryHeader = []string{"attempts", "people", "improved", "avg_improvement", "admitted", "admitted_pct"}
+
+var repeaterHeader = []string{"regnumbers", "name", "years", "attempts", "first_aggregate", "best_aggregate", "last_aggregate", "improvement", "admitted_year", "admitted_course", "matched_by"}
+
+func repeaterSummaryRows(people []tracking.Person) [][]interface{} {
+	summaries := tracking.Summarize(people)
+	rows := make([][]interface{}, len(summaries))
+	for i, s := range summaries {
+		rows[i] = []interface{}{s.Attempts, s.People, s.Improved, fmt.Sprintf("%+.1f", s.AvgImprovement), s.Admitted, fmt.Sprintf("%.1f", s.AdmittedPercent())}
+	}
+	return rows
+}
+
+// repeaterRows lays out one row per repeat taker, with their attempts'
+// regnumbers and years joined in order
+func repeaterRows(people []tracking.Person) [][]interface{} {
+	rows := make([][]interface{}, len(people))
+	for i := range people {
+		p := &people[i]
+		regnumbers := make([]string, len(p.Attempts))
+		years := make([]string, len(p.Attempts))
+		for j, a := range p.Attempts {
+			regnumbers[j], years[j] = a.Regnumber, strconv.Itoa(a.Year)
+		}
+		row := []interface{}{strings.Join(regnumbers, " > "), p.Last().Name, strings.Join(years, ", "), len(p.Attempts),
+			nullInt(p.First().Aggregate), nullInt(p.Best()), nullInt(p.Last().Aggregate), nil, nil, nil, strings.Join(p.MatchedBy, ", ")}
+		if diff, ok := p.Improvement(); ok {
+			row[7] = diff
+		}
+		if year := p.AdmittedYear(); year != 0 {
+			row[8] = year
+			for _, a := range p.Attempts {
+				if a.Year == year && a.Admitted {
+					row[9] = a.Course
+					break
+				}
+			}
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+func nullInt(n sql.NullInt64) interface{} {
+	if !n.Valid {
+		return nil
+	}
+	return n.Int64
+}
+
+// handleRepeatTakers reports candidates who sat in more than one year,
+// how their aggregates moved and whether they were admitted in the end
+func handleRepeatTakers(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nRepeat Candidates")
+	fmt.Printf("Match by (%s, or all) [all]: ", strings.Join(tracking.Methods, ", "))
+	methods, err := tracking.ParseMethods(readString())
+	if err != nil {
+		return err
+	}
+	fmt.Print("Only people who sat in year (blank for any): ")
+	year := 0
+	if input := readString(); input != "" {
+		if year, err = strconv.Atoi(input); err != nil {
+			return fmt.Errorf("invalid year %q", input)
+		}
+	}
+
+	fmt.Println("Linking candidates across years...")
+	people, err := tracking.FindRepeaters(ctx, db, methods, year)
+	if err != nil {
+		return err
+	}
+	if len(people) == 0 {
+		color.Yellow("No candidate was found in more than one year")
+		return nil
+	}
+	color.Green("%d people sat in more than one year", len(people))
+	showResult("repeat-takers-summary", repeaterSummaryHeader, repeaterSummaryRows(people), false)
+
+	fmt.Print("\nList the people? (y/n): ")
+	if strings.ToLower(readString()) != "y" {
+		return nil
+	}
+	showResult("repeat-takers", repeaterHeader, repeaterRows(people), false)
+	return nil
+}
+
+// runRepeaters is the scriptable form of the repeat candidates report
+func runRepeaters(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("repeaters")
+	match := fs.String("match", "all", "how candidates are linked: "+strings.Join(tracking.Methods, ", ")+" or all")
+	year := fs.Int("year", 0, "only people who sat in this year")
+	list := fs.Bool("list", false, "list each person instead of the summary by number of attempts")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{errors.New("repeaters takes no arguments")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	methods, err := tracking.ParseMethods(*match)
+	if err != nil {
+		return usageError{err}
+	}
+
+	people, err := tracking.FindRepeaters(ctx, db, methods, *year)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d people sat in more than one year\n", len(people))
+	if *list {
+		return writeResult("repeat-takers", repeaterHeader, repeaterRows(people), *format, *output)
+	}
+	return writeResult("repeat-takers-summary", repeaterSummaryHeader, repeaterSummaryRows(people), *format, *output)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/nonsonwune/spk2_db/delivery"
+	"github.com/nonsonwune/spk2_db/export"
+	"github.com/nonsonwune/spk2_db/reports"
+	"github.com/nonsonwune/spk2_db/scheduler"
+)
+
+// defaultReportDir is where scheduled reports are written when the
+// schedule file names no directory
+const defaultReportDir = "scheduled-reports"
+
+// scheduledReport runs one saved report on a cron schedule
+type scheduledReport struct {
+	Name      string            `yaml:"name"`
+	Report    string            `yaml:"report"`
+	Cron      string            `yaml:"cron"`
+	Params    map[string]string `yaml:"params"`
+	Format    string            `yaml:"format"`
+	OutputDir string            `yaml:"output_dir"`
+	Email     []string          `yaml:"email"`
+	// Encrypt writes the result encrypted for EXPORT_RECIPIENTS
+	Encrypt bool `yaml:"encrypt"`
+
+	schedule *scheduler.Cron
+}
+
+// reportSchedule is the file SCHEDULE_REPORTS names, e.g.
+//
+//	output_dir: /srv/spk2/reports
+//	reports:
+//	  - report: top-engineering-lagos
+//	    cron: "0 7 * * MON"
+//	    params: {year: current}
+//	    format: xlsx
+//	    email: [admissions@example.edu.ng]
+//	    encrypt: true
+type reportSchedule struct {
+	OutputDir string            `yaml:"output_dir"`
+	Reports   []scheduledReport `yaml:"reports"`
+}
+
+// loadReportSchedule reads and checks the file SCHEDULE_REPORTS names. It
+// returns nil when none is set.
+func loadReportSchedule() (*reportSchedule, error) {
+	path := os.Getenv("SCHEDULE_REPORTS")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("SCHEDULE_REPORTS: %w", err)
+	}
+	var sched reportSchedule
+	if err := yaml.Unmarshal(data, &sched); err != nil {
+		return nil, fmt.Errorf("SCHEDULE_REPORTS %s: %w", path, err)
+	}
+	if sched.OutputDir == "" {
+		sched.OutputDir = defaultReportDir
+	}
+	mail, err := delivery.MailConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	recipients, err := export.RecipientsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for i := range sched.Reports {
+		r := &sched.Reports[i]
+		if r.Report == "" {
+			return nil, fmt.Errorf("SCHEDULE_REPORTS %s: entry %d names no report", path, i+1)
+		}
+		if r.Report, err = reports.NormalizeName(r.Report); err != nil {
+			return nil, fmt.Errorf("SCHEDULE_REPORTS %s: %v", path, err)
+		}
+		if r.Name == "" {
+			r.Name = "report-" + r.Report
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("SCHEDULE_REPORTS %s: job %s is scheduled twice; give one a different name", path, r.Name)
+		}
+		names[r.Name] = true
+		if r.schedule, err = scheduler.ParseCron(r.Cron); err != nil {
+			return nil, fmt.Errorf("SCHEDULE_REPORTS %s: %s: %v", path, r.Name, err)
+		}
+		if r.Format == "" {
+			r.Format = "csv"
+		}
+		if r.Format, err = parseResultFormat(r.Format); err != nil || r.Format == "table" {
+			return nil, fmt.Errorf("SCHEDULE_REPORTS %s: %s: format must be csv, json or xlsx", path, r.Name)
+		}
+		for key := range r.Params {
+			if !contains(reports.Params, key) {
+				return nil, fmt.Errorf("SCHEDULE_REPORTS %s: %s: unknown parameter %q (use %s)", path, r.Name, key, strings.Join(reports.Params, ", "))
+			}
+		}
+		if r.OutputDir == "" {
+			r.OutputDir = sched.OutputDir
+		}
+		if len(r.Email) > 0 && mail == nil {
+			return nil, fmt.Errorf("SCHEDULE_REPORTS %s: %s emails its output but SMTP_HOST is not set", path, r.Name)
+		}
+		if r.Encrypt && len(recipients) == 0 {
+			return nil, fmt.Errorf("SCHEDULE_REPORTS %s: %s is encrypted but EXPORT_RECIPIENTS is not set", path, r.Name)
+		}
+	}
+	return &sched, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// addScheduledReports registers a job for each report in SCHEDULE_REPORTS
+func addScheduledReports(s *scheduler.Scheduler, db *sql.DB, catchUp bool) error {
+	sched, err := loadReportSchedule()
+	if err != nil || sched == nil {
+		return err
+	}
+	mail, err := delivery.MailConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	for _, r := range sched.Reports {
+		r := r
+		err := s.Add(scheduler.Job{
+			Name:     r.Name,
+			Schedule: r.schedule,
+			CatchUp:  catchUp,
+			// Room to send the email after the report itself
+			Timeout: savedReportTimeout + 2*time.Minute,
+			Run: func(ctx context.Context) error {
+				return r.run(ctx, db, mail)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs the report, writes its result to the output directory,
+// encrypted if the report asks, and emails the file to the recipients, if
+// any
+func (r scheduledReport) run(ctx context.Context, db *sql.DB, mail *delivery.MailConfig) error {
+	report, err := reports.GetSaved(ctx, db, r.Report)
+	if err != nil {
+		return err
+	}
+	params := map[string]string{}
+	for key, value := range r.Params {
+		// "current" keeps a weekly report on this year's candidates
+		if key == "year" && strings.EqualFold(value, "current") {
+			value = strconv.Itoa(time.Now().Year())
+		}
+		params[key] = value
+	}
+	runCtx, cancel := context.WithTimeout(ctx, savedReportTimeout)
+	defer cancel()
+	columns, rows, err := reports.RunSaved(runCtx, db, report, params)
+	if err != nil {
+		return err
+	}
+	path, err := resultOutput{format: r.Format, dir: r.OutputDir}.save("report-"+report.Name, columns, rows)
+	if err != nil {
+		return err
+	}
+	if r.Encrypt {
+		recipients, err := export.RecipientsFromEnv()
+		if err != nil {
+			return err
+		}
+		if path, err = export.EncryptFile(path, recipients); err != nil {
+			return err
+		}
+	}
+	if len(r.Email) == 0 {
+		return nil
+	}
+
+	body := fmt.Sprintf("The scheduled report %s (%s) ran at %s and returned %d rows; the result is attached.\n",
+		report.Name, r.schedule, time.Now().Format("2006-01-02 15:04"), len(rows))
+	if report.Description != "" {
+		body = report.Description + "\n\n" + body
+	}
+	subject := fmt.Sprintf("Report %s, %s", report.Name, time.Now().Format("2 Jan 2006"))
+	if err := mail.SendFile(ctx, r.Email, subject, body, path); err != nil {
+		return fmt.Errorf("report saved to %s but not emailed: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/privacy"
+	"github.com/nonsonwune/spk2_db/reports"
+)
+
+// publicOutput, when set, marks report output as public: aggregate reports
+// withhold groups smaller than its k and candidate-level exports are refused
+var publicOutput *privacy.Guard
+
+// reportTable buffers an aggregate report so the privacy guard can see
+// every group before anything is printed
+type reportTable struct {
+	name   string
+	header []string
+	spec   privacy.Spec
+	rows   [][]interface{}
+}
+
+// newReportTable starts an aggregate report; spec names the header columns
+// holding group sizes, labels, counts and averages, and name is used for
+// saved result files
+func newReportTable(name string, header []string, spec privacy.Spec) *reportTable {
+	return &reportTable{name: name, header: header, spec: spec}
+}
+
+func (t *reportTable) Append(row []string) {
+	cells := make([]interface{}, len(row))
+	for i, v := range row {
+		cells[i] = v
+	}
+	t.rows = append(t.rows, cells)
+}
+
+func (t *reportTable) Render() {
+	rows := t.rows
+	var footnote string
+	if publicOutput != nil {
+		published, res, err := publicOutput.Apply(t.header, rows, t.spec)
+		if err != nil {
+			color.Red("Report withheld: %v", err)
+			return
+		}
+		rows = published
+		footnote = publicOutput.Describe(res)
+	}
+
+	lines := make([][]interface{}, len(rows))
+	for r, row := range rows {
+		line := make([]interface{}, len(row))
+		for i, v := range row {
+			switch v := v.(type) {
+			case nil:
+				line[i] = fmt.Sprintf("<%d", publicOutput.K)
+			case int64:
+				line[i] = fmt.Sprintf("%d", v)
+			case float64:
+				line[i] = fmt.Sprintf("%.2f", v)
+			default:
+				line[i] = fmt.Sprint(v)
+			}
+		}
+		lines[r] = line
+	}
+	showResult(t.name, t.header, lines, false)
+	if footnote != "" {
+		color.Yellow(footnote)
+	}
+}
+
+// planReport chooses between a report's summary-table and raw queries for
+// the current session
+func planReport(ctx context.Context, db *sql.DB, report reports.Report) reports.Plan {
+	plan, err := reports.Route(ctx, db, report, currentSession.CandidateSource())
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return plan
+}
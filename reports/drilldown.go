@@ -0,0 +1,76 @@
+package reports
+
+import "fmt"
+
+// Entity is what a row of a breakdown stands for. Drilling into a row
+// restricts the candidates to that entity and breaks them down further.
+type Entity struct {
+	Name   string
+	Title  string // plural, for headings
+	Column string // the candidate column identifying the entity
+	key    string
+	label  string
+	join   string
+	lookup string // finds the key from a name shown in another report
+}
+
+var (
+	EntityState = Entity{
+		Name: "state", Title: "States", Column: "statecode",
+		key: "s.st_id", label: "s.st_name", join: "JOIN state s ON s.st_id = c.statecode",
+		lookup: "SELECT st_id FROM state WHERE UPPER(st_name) = UPPER($1)",
+	}
+	EntityLGA = Entity{
+		Name: "lga", Title: "LGAs", Column: "lg_id",
+		key: "l.lg_id", label: "l.lg_name", join: "JOIN lga l ON l.lg_id = c.lg_id",
+	}
+	EntityInstitution = Entity{
+		Name: "institution", Title: "Institutions", Column: "inid",
+		key: "i.inid", label: "i.inname", join: "JOIN institution i ON i.inid = c.inid",
+		lookup: "SELECT inid FROM institution WHERE UPPER(inname) = UPPER($1)",
+	}
+	EntityCourse = Entity{
+		Name: "course", Title: "Courses", Column: "app_course1",
+		key: "co.course_code", label: "co.course_name", join: "JOIN course co ON co.course_code = c.app_course1",
+	}
+)
+
+// DrillDowns lists the breakdowns offered under a row of each entity, so
+// states lead to their LGAs, institutions and courses, and so on
+var DrillDowns = map[string][]Entity{
+	EntityState.Name:       {EntityLGA, EntityInstitution, EntityCourse},
+	EntityLGA.Name:         {EntityInstitution, EntityCourse},
+	EntityInstitution.Name: {EntityCourse, EntityState},
+	EntityCourse.Name:      {EntityInstitution, EntityState},
+}
+
+// BreakdownLimit is the most rows a breakdown lists
+const BreakdownLimit = 30
+
+// Breakdown returns the query grouping the candidates in source by e,
+// largest group first, with columns key, name, candidates, avg_score and
+// admitted
+func Breakdown(e Entity, source string) string {
+	return fmt.Sprintf(`
+        SELECT %[2]s AS key, %[3]s AS name,
+               COUNT(*) AS candidates,
+               ROUND(AVG(NULLIF(c.aggregate, 0))::numeric, 2) AS avg_score,
+               COUNT(CASE WHEN c.is_admitted THEN 1 END) AS admitted
+        FROM %[1]s c
+        %[4]s
+        GROUP BY %[2]s, %[3]s
+        ORDER BY candidates DESC, name
+        LIMIT %[5]d`, source, e.key, e.label, e.join, BreakdownLimit)
+}
+
+// Restrict returns source narrowed to the candidates whose column equals
+// placeholder $n
+func Restrict(source, column string, n int) string {
+	return fmt.Sprintf("(SELECT d.* FROM %s d WHERE d.%s = $%d)", source, column, n)
+}
+
+// Lookup returns the query finding an entity's key by its name, or "" if
+// the entity has no unique name
+func (e Entity) Lookup() string {
+	return e.lookup
+}
@@ -0,0 +1,144 @@
+package reports
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RankingWeights sets how much each component contributes to an
+// institution's composite ranking score. Weights are relative; they need
+// not sum to one.
+type RankingWeights struct {
+	Score         float64 // average aggregate of applicants
+	Selectivity   float64 // share of applicants not admitted
+	Volume        float64 // number of applicants
+	GenderBalance float64 // closeness of the female share to half
+}
+
+// DefaultRankingWeights favours applicant quality, with demand and balance
+// as tie-breakers
+var DefaultRankingWeights = RankingWeights{Score: 0.5, Selectivity: 0.2, Volume: 0.2, GenderBalance: 0.1}
+
+// rankingComponents names the weights as they are written and parsed
+var rankingComponents = []string{"score", "selectivity", "volume", "gender"}
+
+func (w *RankingWeights) component(name string) *float64 {
+	switch name {
+	case "score":
+		return &w.Score
+	case "selectivity":
+		return &w.Selectivity
+	case "volume":
+		return &w.Volume
+	case "gender":
+		return &w.GenderBalance
+	}
+	return nil
+}
+
+// String formats the weights as ParseRankingWeights accepts them
+func (w RankingWeights) String() string {
+	parts := make([]string, len(rankingComponents))
+	for i, name := range rankingComponents {
+		parts[i] = name + "=" + strconv.FormatFloat(*w.component(name), 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Validate checks the weights are non-negative and not all zero
+func (w RankingWeights) Validate() error {
+	total := 0.0
+	for _, name := range rankingComponents {
+		v := *w.component(name)
+		if v < 0 {
+			return fmt.Errorf("weight for %s must not be negative", name)
+		}
+		total += v
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one ranking weight must be positive")
+	}
+	return nil
+}
+
+// ParseRankingWeights parses weights such as "score=0.6,volume=0.4".
+// Components that are not mentioned get no weight.
+func ParseRankingWeights(s string) (RankingWeights, error) {
+	var w RankingWeights
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return w, fmt.Errorf("invalid weight %q, expected name=value", part)
+		}
+		field := w.component(strings.ToLower(strings.TrimSpace(name)))
+		if field == nil {
+			return w, fmt.Errorf("unknown ranking component %q (use %s)", name, strings.Join(rankingComponents, ", "))
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return w, fmt.Errorf("invalid weight for %s: %v", name, err)
+		}
+		*field = v
+	}
+	return w, w.Validate()
+}
+
+// CompositeRanking ranks institutions in the latest year by a weighted sum
+// of component scores, each scaled to 0-100 across the ranked institutions:
+// average score (min-max scaled), selectivity (100 minus the admission
+// rate), applicant volume (percentile) and gender balance (100 at an even
+// split, 0 when single-sex). Only institutions with over 100 applicants are
+// ranked. The weights must be valid.
+func CompositeRanking(w RankingWeights) Report {
+	total := w.Score + w.Selectivity + w.Volume + w.GenderBalance
+	weight := func(v float64) string {
+		return strconv.FormatFloat(v/total, 'f', -1, 64)
+	}
+	return Report{
+		Name:  "institution-composite",
+		Title: "Institution Composite Ranking",
+		query: `
+        WITH stats AS (
+            SELECT
+                i.inname as institution_name,
+                i.inabv as abbreviation,
+                COUNT(c.regnumber) as total_applicants,
+                COUNT(CASE WHEN c.is_admitted = true THEN 1 END) as admitted_count,
+                AVG(c.aggregate) as avg_score,
+                COUNT(CASE WHEN c.gender = 'F' THEN 1 END)::float / COUNT(c.regnumber) as female_share
+            FROM institution i
+            JOIN %[1]s c ON i.inid = c.inid
+            WHERE c.year = (SELECT MAX(latest.year) FROM %[1]s latest)
+                AND c.aggregate > 0
+            GROUP BY i.inname, i.inabv
+            HAVING COUNT(c.regnumber) > 100
+        ),
+        components AS (
+            SELECT *,
+                COALESCE(100 * (avg_score - MIN(avg_score) OVER ())
+                    / NULLIF(MAX(avg_score) OVER () - MIN(avg_score) OVER (), 0), 100) as score_points,
+                100 * (1 - admitted_count::float / total_applicants) as selectivity_points,
+                100 * PERCENT_RANK() OVER (ORDER BY total_applicants) as volume_points,
+                100 * (1 - 2 * ABS(female_share - 0.5)) as gender_points
+            FROM stats
+        )
+        SELECT
+            institution_name,
+            abbreviation,
+            total_applicants,
+            admitted_count,
+            ROUND(avg_score::numeric, 2) as average_score,
+            ROUND(score_points::numeric, 1) as score_points,
+            ROUND(selectivity_points::numeric, 1) as selectivity_points,
+            ROUND(volume_points::numeric, 1) as volume_points,
+            ROUND(gender_points::numeric, 1) as gender_points,
+            ROUND((` + weight(w.Score) + ` * score_points
+                + ` + weight(w.Selectivity) + ` * selectivity_points
+                + ` + weight(w.Volume) + ` * volume_points
+                + ` + weight(w.GenderBalance) + ` * gender_points)::numeric, 1) as composite_score
+        FROM components
+        ORDER BY composite_score DESC, institution_name
+        LIMIT 20`,
+	}
+}
@@ -0,0 +1,410 @@
+// Package reports holds the analytics queries shared by the interactive
+// menu and the HTTP API, so both always report the same figures.
+//
+// Each query reads candidates from the relation substituted for %[1]s: the
+// candidate table, a session working set, or a parenthesised subquery.
+// Every use of the relation is aliased so subqueries work too.
+package reports
+
+import "fmt"
+
+// Report is a named analytics query
+type Report struct {
+	// Name is the report's URL path segment in the API
+	Name  string
+	Title string
+	query string
+	// summary, when set, gives the same figures for the whole candidate
+	// table from the fact tables in reads; see Route
+	summary string
+	reads   []string
+}
+
+// SQL returns the report's query reading candidates from source
+func (r Report) SQL(source string) string {
+	return fmt.Sprintf(r.query, source)
+}
+
+// All lists the reports in menu order
+var All = []Report{
+	TopPerformers,
+	GenderStats,
+	StateDistribution,
+	SubjectStats,
+	AggregateDistribution,
+	CourseAnalysis,
+	InstitutionStats,
+	FacultyPerformance,
+	GeographicAnalysis,
+	AdmissionTrends,
+	PerformanceMetrics,
+	InstitutionRanking,
+	RegionalPerformance,
+	CourseCompetitiveness,
+}
+
+// TopPerformers lists the ten highest aggregates
+var TopPerformers = Report{
+	Name:  "top-performers",
+	Title: "Top 10 Performers",
+	query: `
+        SELECT regnumber, surname, firstname, aggregate
+        FROM %[1]s c
+        WHERE aggregate IS NOT NULL
+        ORDER BY aggregate DESC
+        LIMIT 10`,
+}
+
+// GenderStats lists candidates per gender with each gender's share of the
+// total
+var GenderStats = Report{
+	Name:  "gender",
+	Title: "Gender Distribution",
+	query: `
+        SELECT gender, COUNT(*) as count,
+               ROUND(100.0 * COUNT(*) / SUM(COUNT(*)) OVER (), 2) as share_pct
+        FROM %[1]s c
+        WHERE gender IS NOT NULL
+        GROUP BY gender
+        ORDER BY count DESC, gender`,
+	summary: `
+        SELECT gender, count,
+               ROUND(100.0 * count / SUM(count) OVER (), 2) as share_pct
+        FROM (
+            SELECT 'F' as gender, SUM(female) as count FROM applicants_by_state_year
+            UNION ALL
+            SELECT 'M', SUM(male) FROM applicants_by_state_year
+        ) g
+        WHERE count > 0
+        ORDER BY count DESC, gender`,
+	reads: []string{"applicants_by_state_year"},
+}
+
+// StateDistribution lists the ten states with most candidates. Shares and
+// the running total are of all candidates, not just the ten states shown.
+var StateDistribution = Report{
+	Name:  "states",
+	Title: "Top 10 States by Number of Candidates",
+	query: `
+        SELECT s.st_name, COUNT(c.*) as count,
+               ROUND(100.0 * COUNT(c.*) / SUM(COUNT(c.*)) OVER (), 2) as share_pct,
+               ROUND(100.0 * SUM(COUNT(c.*)) OVER (ORDER BY COUNT(c.*) DESC, s.st_name ROWS UNBOUNDED PRECEDING)
+                     / SUM(COUNT(c.*)) OVER (), 2) as cumulative_pct
+        FROM %[1]s c
+        JOIN state s ON c.statecode = s.st_id
+        GROUP BY s.st_name
+        ORDER BY count DESC, s.st_name
+        LIMIT 10`,
+	summary: `
+        SELECT state_name as st_name, SUM(applicants) as count,
+               ROUND(100.0 * SUM(applicants) / SUM(SUM(applicants)) OVER (), 2) as share_pct,
+               ROUND(100.0 * SUM(SUM(applicants)) OVER (ORDER BY SUM(applicants) DESC, state_name ROWS UNBOUNDED PRECEDING)
+                     / SUM(SUM(applicants)) OVER (), 2) as cumulative_pct
+        FROM applicants_by_state_year
+        WHERE state_name IS NOT NULL
+        GROUP BY state_name
+        ORDER BY count DESC, st_name
+        LIMIT 10`,
+	reads: []string{"applicants_by_state_year"},
+}
+
+// SubjectStats lists candidates and average score per best subject in the latest year
+var SubjectStats = Report{
+	Name:  "subjects",
+	Title: "Subject Statistics",
+	query: `
+        WITH RankedSubjects AS (
+            SELECT
+                s.su_name,
+                cs.score,
+                COUNT(*) as count,
+                RANK() OVER (PARTITION BY cs.cand_reg_number ORDER BY cs.score DESC) as score_rank
+            FROM %[1]s c
+            JOIN candidate_scores cs ON c.regnumber = cs.cand_reg_number AND c.year = cs.year
+            JOIN subject s ON cs.subject_id = s.su_id
+            WHERE c.year = (SELECT MAX(latest.year) FROM %[1]s latest)
+            GROUP BY s.su_name, cs.score, cs.cand_reg_number
+        )
+        SELECT
+            su_name,
+            COUNT(*) as total_candidates,
+            ROUND(AVG(score)::numeric, 2) as avg_score
+        FROM RankedSubjects
+        WHERE score_rank = 1
+        GROUP BY su_name
+        ORDER BY total_candidates DESC
+        LIMIT 5`,
+}
+
+// AggregateDistribution lists candidates per aggregate score band, highest
+// first, with each band's share of the total and the running share of
+// candidates at or above it
+var AggregateDistribution = Report{
+	Name:  "aggregate-distribution",
+	Title: "Aggregate Score Distribution",
+	query: `
+        SELECT
+            CASE
+                WHEN aggregate >= 300 THEN '300+'
+                WHEN aggregate >= 250 THEN '250-299'
+                WHEN aggregate >= 200 THEN '200-249'
+                WHEN aggregate >= 150 THEN '150-199'
+                ELSE 'Below 150'
+            END as range,
+            COUNT(*) as count,
+            ROUND(100.0 * COUNT(*) / SUM(COUNT(*)) OVER (), 2) as share_pct,
+            ROUND(100.0 * SUM(COUNT(*)) OVER (ORDER BY MIN(aggregate) DESC ROWS UNBOUNDED PRECEDING)
+                  / SUM(COUNT(*)) OVER (), 2) as cumulative_pct
+        FROM %[1]s c
+        WHERE aggregate IS NOT NULL
+        GROUP BY range
+        ORDER BY MIN(aggregate) DESC`,
+	summary: `
+        SELECT
+            CASE
+                WHEN band_start >= 300 THEN '300+'
+                WHEN band_start >= 250 THEN '250-299'
+                WHEN band_start >= 200 THEN '200-249'
+                WHEN band_start >= 150 THEN '150-199'
+                ELSE 'Below 150'
+            END as range,
+            SUM(candidates) as count,
+            ROUND(100.0 * SUM(candidates) / SUM(SUM(candidates)) OVER (), 2) as share_pct,
+            ROUND(100.0 * SUM(SUM(candidates)) OVER (ORDER BY MIN(band_start) DESC ROWS UNBOUNDED PRECEDING)
+                  / SUM(SUM(candidates)) OVER (), 2) as cumulative_pct
+        FROM aggregate_bands_by_year
+        GROUP BY range
+        ORDER BY MIN(band_start) DESC`,
+	reads: []string{"aggregate_bands_by_year"},
+}
+
+// CourseAnalysis lists the fifteen courses with most first-choice applicants
+var CourseAnalysis = Report{
+	Name:  "courses",
+	Title: "Top 15 Courses by Number of Applicants",
+	query: `
+        SELECT c.course_name, COUNT(ca.regnumber) as applicants,
+               ROUND(AVG(ca.aggregate)::numeric, 2) as avg_score,
+               f.fac_name as faculty
+        FROM course c
+        LEFT JOIN %[1]s ca ON c.course_code = ca.app_course1
+        LEFT JOIN faculty f ON c.facid = f.fac_id
+        GROUP BY c.course_name, f.fac_name
+        ORDER BY applicants DESC
+        LIMIT 15`,
+}
+
+// InstitutionStats lists the fifteen institutions with most applicants
+var InstitutionStats = Report{
+	Name:  "institutions",
+	Title: "Top 15 Institutions by Number of Applicants",
+	query: `
+        SELECT i.inname, COUNT(c.regnumber) as applicants,
+               ROUND(AVG(c.aggregate)::numeric, 2) as avg_score,
+               it.intyp_desc as institution_type
+        FROM institution i
+        LEFT JOIN %[1]s c ON i.inid = c.inid
+        LEFT JOIN institution_type it ON i.intyp = it.intyp_id
+        GROUP BY i.inname, it.intyp_desc
+        ORDER BY applicants DESC
+        LIMIT 15`,
+}
+
+// FacultyPerformance lists applicants and average score per faculty
+var FacultyPerformance = Report{
+	Name:  "faculties",
+	Title: "Faculty Performance",
+	query: `
+        SELECT f.fac_name, COUNT(c.regnumber) as applicants,
+               ROUND(AVG(c.aggregate)::numeric, 2) as avg_score
+        FROM faculty f
+        JOIN course co ON f.fac_id = co.facid
+        LEFT JOIN %[1]s c ON co.course_code = c.app_course1
+        GROUP BY f.fac_name
+        ORDER BY avg_score DESC`,
+}
+
+// GeographicAnalysis lists the fifteen largest LGAs with over 1000 candidates
+var GeographicAnalysis = Report{
+	Name:  "lgas",
+	Title: "Top 15 LGAs by Number of Candidates",
+	query: `
+        SELECT s.st_name as state, l.lg_name as lga,
+               COUNT(c.regnumber) as candidates,
+               ROUND(AVG(c.aggregate)::numeric, 2) as avg_score
+        FROM state s
+        JOIN lga l ON s.st_id = l.lg_st_id
+        JOIN %[1]s c ON l.lg_id = c.lg_id
+        GROUP BY s.st_name, l.lg_name
+        HAVING COUNT(c.regnumber) > 1000
+        ORDER BY candidates DESC
+        LIMIT 15`,
+}
+
+// AdmissionTrends lists estimated cutoffs of the fifteen most applied-for courses
+var AdmissionTrends = Report{
+	Name:  "admission-trends",
+	Title: "Admission Trends (Top 15 Courses)",
+	query: `
+        WITH course_stats AS (
+            SELECT
+                c.course_name,
+                COUNT(*) as applicants,
+                PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY ca.aggregate) as cutoff_score
+            FROM course c
+            JOIN %[1]s ca ON c.course_code = ca.app_course1
+            GROUP BY c.course_name
+            HAVING COUNT(*) > 100
+        )
+        SELECT course_name,
+               applicants,
+               ROUND(cutoff_score::numeric, 2) as cutoff_score
+        FROM course_stats
+        ORDER BY applicants DESC
+        LIMIT 15`,
+}
+
+// PerformanceMetrics lists aggregate score statistics per year
+var PerformanceMetrics = Report{
+	Name:  "performance",
+	Title: "Performance Metrics",
+	query: `
+        WITH ScoreStats AS (
+            SELECT
+                year,
+                COUNT(*) as total_candidates,
+                AVG(NULLIF(aggregate, 0)) as avg_score,
+                PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY NULLIF(aggregate, 0)) as median_score,
+                STDDEV(NULLIF(aggregate, 0)) as std_dev
+            FROM %[1]s c
+            WHERE aggregate IS NOT NULL AND aggregate > 0
+            GROUP BY year
+        )
+        SELECT
+            year,
+            total_candidates,
+            COALESCE(ROUND(avg_score::numeric, 2), 0) as average_score,
+            COALESCE(ROUND(median_score::numeric, 2), 0) as median_score,
+            COALESCE(ROUND(std_dev::numeric, 2), 0) as standard_deviation
+        FROM ScoreStats
+        ORDER BY year DESC`,
+}
+
+// InstitutionRanking lists the twenty institutions with the highest average score in the latest year
+var InstitutionRanking = Report{
+	Name:  "institution-ranking",
+	Title: "Institution Ranking",
+	query: `
+        WITH AdmissionStats AS (
+            SELECT
+                i.inname as institution_name,
+                i.inabv as abbreviation,
+                COUNT(c.regnumber) as total_applicants,
+                COUNT(CASE WHEN c.is_admitted = true THEN 1 END) as admitted_count,
+                AVG(NULLIF(c.aggregate, 0)) as avg_score
+            FROM institution i
+            LEFT JOIN %[1]s c ON i.inid = c.inid
+            WHERE c.year = (SELECT MAX(latest.year) FROM %[1]s latest)
+                AND c.aggregate IS NOT NULL
+                AND c.aggregate > 0
+            GROUP BY i.inname, i.inabv
+            HAVING COUNT(c.regnumber) > 100
+        )
+        SELECT
+            institution_name,
+            abbreviation,
+            total_applicants,
+            admitted_count,
+            COALESCE(ROUND(avg_score::numeric, 2), 0) as average_score,
+            ROUND((admitted_count::float / total_applicants * 100)::numeric, 2) as admission_rate
+        FROM AdmissionStats
+        ORDER BY avg_score DESC
+        LIMIT 20`,
+}
+
+// RegionalPerformance lists candidates, scores and admissions per state in the latest year
+var RegionalPerformance = Report{
+	Name:  "regions",
+	Title: "Regional Performance",
+	query: `
+        WITH RegionalStats AS (
+            SELECT
+                s.st_name as state_name,
+                COUNT(c.regnumber) as total_candidates,
+                AVG(NULLIF(c.aggregate, 0)) as avg_score,
+                COUNT(CASE WHEN c.is_admitted = true THEN 1 END) as admitted_count,
+                COUNT(CASE WHEN c.gender = 'F' THEN 1 END) as female_count
+            FROM %[1]s c
+            JOIN state s ON c.statecode = s.st_id
+            WHERE c.year = (SELECT MAX(latest.year) FROM %[1]s latest)
+                AND c.aggregate IS NOT NULL
+                AND c.aggregate > 0
+            GROUP BY s.st_name
+        )
+        SELECT
+            state_name,
+            total_candidates,
+            COALESCE(ROUND(avg_score::numeric, 2), 0) as average_score,
+            admitted_count,
+            ROUND((female_count::float / total_candidates * 100)::numeric, 2) as female_percentage
+        FROM RegionalStats
+        ORDER BY total_candidates DESC`,
+}
+
+// CourseCompetitiveness lists the twenty most competitive courses in the latest year
+var CourseCompetitiveness = Report{
+	Name:  "course-competitiveness",
+	Title: "Course Competitiveness",
+	query: `
+        WITH CourseStats AS (
+            SELECT
+                c.app_course1 as course_code,
+                co.course_name as course_name,
+                COUNT(c.regnumber) as total_applicants,
+                MIN(NULLIF(c.aggregate, 0)) as min_score,
+                MAX(NULLIF(c.aggregate, 0)) as max_score,
+                AVG(NULLIF(c.aggregate, 0)) as avg_score,
+                COUNT(CASE WHEN c.is_admitted = true THEN 1 END) as admitted_count
+            FROM %[1]s c
+            JOIN course co ON c.app_course1 = co.course_code
+            WHERE c.year = (SELECT MAX(latest.year) FROM %[1]s latest)
+                AND c.aggregate IS NOT NULL
+                AND c.aggregate > 0
+            GROUP BY c.app_course1, co.course_name
+            HAVING COUNT(c.regnumber) > 50
+        )
+        SELECT
+            course_name,
+            total_applicants,
+            COALESCE(ROUND(min_score::numeric, 2), 0) as minimum_score,
+            COALESCE(ROUND(max_score::numeric, 2), 0) as maximum_score,
+            COALESCE(ROUND(avg_score::numeric, 2), 0) as average_score,
+            ROUND((admitted_count::float / total_applicants * 100)::numeric, 2) as admission_rate
+        FROM CourseStats
+        ORDER BY avg_score DESC
+        LIMIT 20`,
+}
+
+// YearComparison compares candidate numbers and scores across years. The
+// equated average needs the equated_aggregate column, and is empty for
+// years score equating has not been run for.
+func YearComparison(equated bool) Report {
+	average := "NULL::numeric"
+	if equated {
+		average = "ROUND(AVG(equated_aggregate)::numeric, 2)"
+	}
+	return Report{
+		Name:  "year-comparison",
+		Title: "Year-wise Statistics",
+		query: `
+        SELECT year,
+               COUNT(*) as total_candidates,
+               ROUND(AVG(aggregate)::numeric, 2) as avg_score,
+               ` + average + ` as avg_equated_score,
+               COUNT(CASE WHEN gender = 'F' THEN 1 END) as female_candidates,
+               COUNT(CASE WHEN gender = 'M' THEN 1 END) as male_candidates
+        FROM %[1]s c
+        GROUP BY year
+        ORDER BY year`,
+	}
+}
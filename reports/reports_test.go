@@ -0,0 +1,25 @@
+package reports
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nonsonwune/spk2_db/sqllint"
+)
+
+func TestReportsMatchSchema(t *testing.T) {
+	f, err := os.Open("../current_db_state.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	schema, err := sqllint.ReadSchema(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range All {
+		if result := schema.Lint(r.SQL("candidate")); !result.OK() {
+			t.Errorf("%s: %s", r.Name, result.Error())
+		}
+	}
+}
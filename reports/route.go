@@ -0,0 +1,63 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/stats"
+)
+
+// Plan is the query chosen for a report and where its figures come from
+type Plan struct {
+	SQL string
+	// Relations are the summary tables the query reads; empty when it reads
+	// the candidate data directly
+	Relations []string
+	// RefreshedAt is when the oldest of the Relations was rebuilt
+	RefreshedAt time.Time
+	// Stale is set when the report has a summary form but it is out of date,
+	// so the candidate data was read instead
+	Stale bool
+}
+
+// Footer describes the plan's data source for display under the report
+func (p Plan) Footer() string {
+	switch {
+	case len(p.Relations) > 0:
+		return fmt.Sprintf("Source: %s, refreshed %s", strings.Join(p.Relations, ", "), p.RefreshedAt.Format("2006-01-02 15:04"))
+	case p.Stale:
+		return "Source: candidate data (summary tables are out of date until the next refresh)"
+	default:
+		return "Source: candidate data"
+	}
+}
+
+// Route plans report over source. Reports with a summary form are answered
+// from the yearly fact tables when source is the whole candidate table and
+// every table they read is fresh; anything else reads source directly. The
+// returned plan is usable even when checking freshness fails.
+func Route(ctx context.Context, db *sql.DB, report Report, source string) (Plan, error) {
+	plan := Plan{SQL: report.SQL(source)}
+	if report.summary == "" || source != "candidate" {
+		return plan, nil
+	}
+
+	freshness, err := stats.RelationFreshness(ctx, db, report.reads...)
+	if err != nil {
+		return plan, fmt.Errorf("error checking summary tables: %w", err)
+	}
+	var refreshedAt time.Time
+	for _, f := range freshness {
+		if !f.Fresh {
+			plan.Stale = true
+			return plan, nil
+		}
+		if refreshedAt.IsZero() || f.RefreshedAt.Before(refreshedAt) {
+			refreshedAt = f.RefreshedAt
+		}
+	}
+	return Plan{SQL: report.summary, Relations: report.reads, RefreshedAt: refreshedAt}, nil
+}
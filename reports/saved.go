@@ -0,0 +1,313 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/sqllint"
+)
+
+// Params are the parameters a saved report's SQL can refer to as :year,
+// :state and :course
+var Params = []string{"year", "state", "course"}
+
+// SavedMaxRows caps the rows a saved report returns
+const SavedMaxRows = 10000
+
+// paramPattern finds :name parameters, but not :: casts
+var paramPattern = regexp.MustCompile(`(^|[^:]):(year|state|course)\b`)
+
+var savedNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// Saved is a named, parameterised query kept in the reports table
+type Saved struct {
+	Name        string
+	Description string
+	// Question is the natural language question the SQL was generated
+	// from, empty for SQL written by hand
+	Question  string
+	SQL       string
+	Defaults  map[string]string // parameter values used when none is given
+	CreatedBy string
+	CreatedAt time.Time
+	LastRunAt sql.NullTime
+}
+
+// NormalizeName lower-cases a report name and checks it can be typed on
+// the command line, e.g. top-engineering-lagos
+func NormalizeName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) > 100 || !savedNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid report name %q: use letters, digits, '-', '_' and '.', starting with a letter or digit", name)
+	}
+	return name, nil
+}
+
+// Parameters lists the parameters the report's SQL refers to, in Params
+// order
+func (s *Saved) Parameters() []string {
+	used := map[string]bool{}
+	for _, m := range paramPattern.FindAllStringSubmatch(s.SQL, -1) {
+		used[m[2]] = true
+	}
+	var names []string
+	for _, p := range Params {
+		if used[p] {
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
+// Bind replaces the report's :parameters with placeholders, taking values
+// from params or else the report's defaults, and checks the result is a
+// single read-only SELECT. State and course values are upper-cased, as
+// they are stored.
+func (s *Saved) Bind(params map[string]string) (string, []interface{}, error) {
+	values := map[string]interface{}{}
+	var missing []string
+	for _, name := range s.Parameters() {
+		raw := strings.TrimSpace(params[name])
+		if raw == "" {
+			raw = s.Defaults[name]
+		}
+		if raw == "" {
+			missing = append(missing, name)
+			continue
+		}
+		if name == "year" {
+			year, err := strconv.Atoi(raw)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid year %q", raw)
+			}
+			values[name] = year
+		} else {
+			values[name] = strings.ToUpper(raw)
+		}
+	}
+	if len(missing) > 0 {
+		return "", nil, fmt.Errorf("report %s needs a value for %s", s.Name, strings.Join(missing, ", "))
+	}
+
+	var args []interface{}
+	position := map[string]int{}
+	query := paramPattern.ReplaceAllStringFunc(s.SQL, func(m string) string {
+		sub := paramPattern.FindStringSubmatch(m)
+		n, ok := position[sub[2]]
+		if !ok {
+			args = append(args, values[sub[2]])
+			n = len(args)
+			position[sub[2]] = n
+		}
+		return sub[1] + "$" + strconv.Itoa(n)
+	})
+	query, err := sqllint.Guard(query, SavedMaxRows)
+	if err != nil {
+		return "", nil, fmt.Errorf("report %s: %v", s.Name, err)
+	}
+	return query, args, nil
+}
+
+// Save stores a report, replacing any report of the same name. The SQL is
+// checked with example values for its parameters before it is kept.
+func Save(ctx context.Context, db *sql.DB, s Saved) error {
+	name, err := NormalizeName(s.Name)
+	if err != nil {
+		return err
+	}
+	s.Name = name
+	if strings.TrimSpace(s.SQL) == "" {
+		return fmt.Errorf("the report's SQL is empty")
+	}
+	for key := range s.Defaults {
+		if !isParam(key) {
+			return fmt.Errorf("unknown parameter %q (use %s)", key, strings.Join(Params, ", "))
+		}
+	}
+	example := map[string]string{"year": "2000", "state": "X", "course": "X"}
+	if _, _, err := s.Bind(example); err != nil {
+		return err
+	}
+	defaults, err := json.Marshal(s.Defaults)
+	if err != nil {
+		return err
+	}
+	if s.Defaults == nil {
+		defaults = []byte("{}")
+	}
+
+	_, err = db.ExecContext(ctx, `
+        INSERT INTO reports (name, description, question, sql_query, defaults, created_by)
+        VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, NULLIF($6, ''))
+        ON CONFLICT (name) DO UPDATE
+        SET description = EXCLUDED.description, question = EXCLUDED.question,
+            sql_query = EXCLUDED.sql_query, defaults = EXCLUDED.defaults,
+            updated_at = NOW()`,
+		s.Name, s.Description, s.Question, strings.TrimSpace(s.SQL), string(defaults), s.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("error saving report: %w", err)
+	}
+	return nil
+}
+
+func isParam(name string) bool {
+	for _, p := range Params {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+const savedColumns = `name, COALESCE(description, ''), COALESCE(question, ''), sql_query,
+        defaults, COALESCE(created_by, ''), created_at, last_run_at`
+
+func scanSaved(row interface{ Scan(...interface{}) error }) (*Saved, error) {
+	var s Saved
+	var defaults []byte
+	if err := row.Scan(&s.Name, &s.Description, &s.Question, &s.SQL, &defaults,
+		&s.CreatedBy, &s.CreatedAt, &s.LastRunAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(defaults, &s.Defaults); err != nil {
+		return nil, fmt.Errorf("report %s has invalid defaults: %w", s.Name, err)
+	}
+	return &s, nil
+}
+
+// ListSaved returns every saved report by name
+func ListSaved(ctx context.Context, db *sql.DB) ([]*Saved, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+savedColumns+` FROM reports ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing reports: %w", err)
+	}
+	defer rows.Close()
+
+	var saved []*Saved
+	for rows.Next() {
+		s, err := scanSaved(rows)
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, s)
+	}
+	return saved, rows.Err()
+}
+
+// GetSaved returns the saved report with the given name
+func GetSaved(ctx context.Context, db *sql.DB, name string) (*Saved, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	s, err := scanSaved(db.QueryRowContext(ctx, `SELECT `+savedColumns+` FROM reports WHERE name = $1`, name))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no report named %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading report: %w", err)
+	}
+	return s, nil
+}
+
+// DeleteSaved removes a saved report
+func DeleteSaved(ctx context.Context, db *sql.DB, name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	res, err := db.ExecContext(ctx, `DELETE FROM reports WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("error deleting report: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no report named %s", name)
+	}
+	return nil
+}
+
+// RunSaved runs a saved report with params in a read-only transaction and
+// returns its columns and rows
+func RunSaved(ctx context.Context, db *sql.DB, s *Saved, params map[string]string) ([]string, [][]interface{}, error) {
+	query, args, err := s.Bind(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("report %s failed: %w", s.Name, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var result [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result = append(result, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	rows.Close()
+	tx.Rollback()
+
+	// Outside the read-only transaction; a failure only loses the timestamp
+	db.ExecContext(ctx, `UPDATE reports SET last_run_at = NOW() WHERE name = $1`, s.Name)
+	return columns, result, nil
+}
+
+// DefaultsString formats a report's defaults for listing, e.g. "year=2023"
+func (s *Saved) DefaultsString() string {
+	keys := make([]string, 0, len(s.Defaults))
+	for k := range s.Defaults {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + s.Defaults[k]
+	}
+	return strings.Join(parts, " ")
+}
+
+// Parameterize replaces a literal value in query with the :param it stands
+// for, e.g. 2023 with :year or 'LAGOS' with :state, so a query generated
+// for one value can be saved as a report for any. It reports whether the
+// value was found.
+func Parameterize(query, param, value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" || !isParam(param) {
+		return query, false
+	}
+	var pattern *regexp.Regexp
+	if param == "year" {
+		pattern = regexp.MustCompile(`\b` + regexp.QuoteMeta(value) + `\b`)
+	} else {
+		pattern = regexp.MustCompile(`(?i)'` + regexp.QuoteMeta(strings.ReplaceAll(value, "'", "''")) + `'`)
+	}
+	if !pattern.MatchString(query) {
+		return query, false
+	}
+	return pattern.ReplaceAllLiteralString(query, ":"+param), true
+}
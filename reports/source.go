@@ -0,0 +1,26 @@
+package reports
+
+import (
+	"fmt"
+
+	"github.com/nonsonwune/spk2_db/filter"
+)
+
+// Source returns the candidate relation for a report restricted to year
+// (0 for every year) and expr (nil for no filter), with the arguments its
+// placeholders take. Unrestricted reports read the candidate table itself so
+// Route can answer them from the fact tables.
+func Source(year int, expr *filter.Filter) (string, []interface{}) {
+	var args []interface{}
+	yearCond := "TRUE"
+	if year != 0 {
+		yearCond = "f.year = $1"
+		args = append(args, year)
+	}
+	if expr == nil && len(args) == 0 {
+		return "candidate", nil
+	}
+
+	where, filterArgs := expr.SQL("f", len(args))
+	return fmt.Sprintf("(SELECT f.* FROM candidate f WHERE %s AND %s)", yearCond, where), append(args, filterArgs...)
+}
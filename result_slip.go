@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/models"
+	"github.com/nonsonwune/spk2_db/slip"
+)
+
+// handleResultSlip shows a candidate's result slip and saves it as a PDF
+// on request
+func handleResultSlip(ctx context.Context, db *sql.DB) error {
+	if publicOutput != nil {
+		return fmt.Errorf("candidate records are not shown while public output mode is on")
+	}
+	color.Cyan("\nResult Slip")
+	fmt.Print("Registration number: ")
+	regNumber := strings.ToUpper(readString())
+	if regNumber == "" {
+		return fmt.Errorf("registration number is required")
+	}
+
+	s, err := slip.Build(ctx, db, regNumber)
+	if errors.Is(err, models.ErrCandidateNotFound) {
+		color.Yellow("No candidate with registration number %s", regNumber)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	if err := s.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	fmt.Print("\nSave as PDF? (y/n): ")
+	if !strings.EqualFold(readString(), "y") {
+		return nil
+	}
+	fmt.Printf("File name [slip-%s.pdf]: ", s.RegNumber)
+	path := readString()
+	if path == "" {
+		path = "slip-" + s.RegNumber + ".pdf"
+	}
+	if err := writeSlip(s, "pdf", path); err != nil {
+		return err
+	}
+	color.Green("Result slip saved to %s", path)
+	return nil
+}
+
+// runSlip is the scriptable form of the result slip
+func runSlip(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("slip")
+	format := fs.String("format", "text", "output format: text or pdf")
+	output := fs.String("o", "", "write the slip to this file instead of stdout (default slip-REGNUMBER.pdf for pdf)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{errors.New("slip needs one registration number")}
+	}
+	if *format != "text" && *format != "pdf" {
+		return usageError{fmt.Errorf("unknown format %q; use text or pdf", *format)}
+	}
+
+	s, err := slip.Build(ctx, db, strings.ToUpper(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+	path := *output
+	if path == "" && *format == "pdf" {
+		path = "slip-" + s.RegNumber + ".pdf"
+	}
+	if path == "" {
+		return s.WriteText(os.Stdout)
+	}
+	if err := writeSlip(s, *format, path); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Result slip saved to %s\n", path)
+	return nil
+}
+
+func writeSlip(s *slip.Slip, format, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	write := s.WriteText
+	if format == "pdf" {
+		write = s.WritePDF
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/reports"
+)
+
+// savedReportTimeout bounds one run of a saved report
+const savedReportTimeout = 5 * time.Minute
+
+// handleSavedReports lists, runs, saves and deletes named reports
+func handleSavedReports(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nSaved Reports")
+	fmt.Println("1. List reports")
+	fmt.Println("2. Run a report")
+	fmt.Println("3. Save SQL as a report")
+	fmt.Println("4. Delete a report")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		return showSavedReports(ctx, db)
+	case "2":
+		fmt.Print("Report name: ")
+		report, err := reports.GetSaved(ctx, db, readString())
+		if err != nil {
+			return err
+		}
+		params := map[string]string{}
+		for _, name := range report.Parameters() {
+			if def := report.Defaults[name]; def != "" {
+				fmt.Printf("%s [%s]: ", name, def)
+			} else {
+				fmt.Printf("%s: ", name)
+			}
+			params[name] = readString()
+		}
+		return runSavedReport(ctx, db, report, params)
+	case "3":
+		fmt.Print("Report name (e.g. top-engineering-lagos): ")
+		name := readString()
+		fmt.Println("SQL, using :year, :state and :course for values given at each run (end with a line containing only ';'):")
+		var lines []string
+		for {
+			line := readString()
+			if line == ";" {
+				break
+			}
+			lines = append(lines, line)
+			if strings.HasSuffix(line, ";") {
+				break
+			}
+		}
+		return saveReport(ctx, db, name, "", strings.Join(lines, "\n"))
+	case "4":
+		fmt.Print("Report name: ")
+		name := readString()
+		fmt.Printf("Delete report %s? (y/n): ", name)
+		if strings.ToLower(readString()) != "y" {
+			fmt.Println("Delete cancelled.")
+			return nil
+		}
+		if err := reports.DeleteSaved(ctx, db, name); err != nil {
+			return err
+		}
+		color.Green("Deleted report %s", name)
+	}
+	return nil
+}
+
+// saveReport asks for a description and which literal values in query
+// should become parameters, then saves it. question is the natural language
+// question query answered, if any.
+func saveReport(ctx context.Context, db *sql.DB, name, question, query string) error {
+	report := reports.Saved{Name: name, Question: question, CreatedBy: noteAuthor(), Defaults: map[string]string{}}
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	for _, param := range reports.Params {
+		if strings.Contains(query, ":"+param) {
+			continue
+		}
+		fmt.Printf("Value in the SQL to replace with :%s (blank for none): ", param)
+		value := readString()
+		if value == "" {
+			continue
+		}
+		var found bool
+		if query, found = reports.Parameterize(query, param, value); !found {
+			color.Yellow("%s does not appear in the SQL", value)
+			continue
+		}
+		report.Defaults[param] = value
+	}
+	for _, param := range reports.Params {
+		if _, ok := report.Defaults[param]; ok || !strings.Contains(query, ":"+param) {
+			continue
+		}
+		fmt.Printf("Default %s (blank to require one at each run): ", param)
+		if value := readString(); value != "" {
+			report.Defaults[param] = value
+		}
+	}
+	fmt.Print("Description (optional): ")
+	report.Description = readString()
+	report.SQL = query
+	if err := reports.Save(ctx, db, report); err != nil {
+		return err
+	}
+	color.Green("Saved report %s; run it with 'spk2 report run %s' or from Saved Reports (45)", strings.ToLower(name), strings.ToLower(name))
+	return nil
+}
+
+func runSavedReport(ctx context.Context, db *sql.DB, report *reports.Saved, params map[string]string) error {
+	runCtx, cancel := context.WithTimeout(ctx, savedReportTimeout)
+	defer cancel()
+	columns, rows, err := reports.RunSaved(runCtx, db, report, params)
+	if err != nil {
+		return err
+	}
+	if report.Description != "" {
+		color.Yellow("\n%s", report.Description)
+	}
+	showResult("report-"+report.Name, columns, rows, false)
+	fmt.Printf("(%d rows)\n", len(rows))
+	return nil
+}
+
+func savedReportRows(list []*reports.Saved) [][]interface{} {
+	rows := make([][]interface{}, len(list))
+	for i, r := range list {
+		lastRun := ""
+		if r.LastRunAt.Valid {
+			lastRun = r.LastRunAt.Time.Format("2006-01-02 15:04")
+		}
+		rows[i] = []interface{}{r.Name, strings.Join(r.Parameters(), " "), r.DefaultsString(), r.Description, r.CreatedBy, lastRun}
+	}
+	return rows
+}
+
+func showSavedReports(ctx context.Context, db *sql.DB) error {
+	list, err := reports.ListSaved(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		color.Yellow("No saved reports yet; save one here or with 'save NAME' after a natural language answer")
+		return nil
+	}
+	showResult("saved-reports", []string{"Report", "Parameters", "Defaults", "Description", "Saved By", "Last Run"}, savedReportRows(list), false)
+	return nil
+}
+
+// parseInterspersed parses flags wherever they appear among args, so
+// "report run NAME -year 2023" works as well as "report -year 2023 run NAME",
+// and returns the other arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := parseFlags(fs, args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// runReport is the scriptable form of menu item 45
+func runReport(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("report")
+	params := map[string]*string{}
+	for _, name := range reports.Params {
+		params[name] = fs.String(name, "", "with run, the value of :"+name+"; with save, its default")
+	}
+	query := fs.String("sql", "", "with save, the report's SQL (or @file.sql)")
+	description := fs.String("description", "", "with save, describe the report")
+	format := fs.String("format", "table", "with list or run, output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "with list or run, write the result to this file instead of stdout")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError{errors.New("report needs list, run NAME, save NAME or delete NAME")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	action := positional[0]
+	if action == "list" {
+		list, err := reports.ListSaved(ctx, db)
+		if err != nil {
+			return err
+		}
+		return writeResult("saved-reports", []string{"report", "parameters", "defaults", "description", "saved_by", "last_run"}, savedReportRows(list), *format, *output)
+	}
+	if action != "run" && action != "save" && action != "delete" {
+		return usageError{fmt.Errorf("unknown report action %q", action)}
+	}
+	if len(positional) != 2 {
+		return usageError{fmt.Errorf("report %s needs a report name", action)}
+	}
+	name := positional[1]
+	values := map[string]string{}
+	for param, value := range params {
+		if *value != "" {
+			values[param] = *value
+		}
+	}
+
+	switch action {
+	case "run":
+		report, err := reports.GetSaved(ctx, db, name)
+		if err != nil {
+			return err
+		}
+		runCtx, cancel := context.WithTimeout(ctx, savedReportTimeout)
+		defer cancel()
+		columns, rows, err := reports.RunSaved(runCtx, db, report, values)
+		if err != nil {
+			return err
+		}
+		return writeResult("report-"+report.Name, columns, rows, *format, *output)
+	case "save":
+		text := *query
+		if strings.HasPrefix(text, "@") {
+			data, err := os.ReadFile(text[1:])
+			if err != nil {
+				return fmt.Errorf("error reading SQL file: %w", err)
+			}
+			text = string(data)
+		}
+		if strings.TrimSpace(text) == "" {
+			return usageError{errors.New("report save needs -sql")}
+		}
+		report := reports.Saved{Name: name, Description: *description, SQL: strings.TrimRight(strings.TrimSpace(text), ";"),
+			Defaults: values, CreatedBy: noteAuthor()}
+		if err := reports.Save(ctx, db, report); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Saved report %s\n", strings.ToLower(name))
+		return nil
+	default:
+		if err := reports.DeleteSaved(ctx, db, name); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Deleted report %s\n", strings.ToLower(name))
+		return nil
+	}
+}
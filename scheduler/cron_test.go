@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2024, 1, 31, 10, 17, 45, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want []time.Time // the next few runs after from
+	}{
+		{"*/15 * * * *", []time.Time{
+			time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC),
+			time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC),
+			time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC),
+		}},
+		{"0 7 * * MON", []time.Time{
+			time.Date(2024, 2, 5, 7, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 12, 7, 0, 0, 0, time.UTC),
+		}},
+		{"@daily", []time.Time{
+			time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC),
+		}},
+		{"5/20 9-10 * * *", []time.Time{
+			time.Date(2024, 1, 31, 10, 25, 0, 0, time.UTC),
+			time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC),
+			time.Date(2024, 2, 1, 9, 5, 0, 0, time.UTC),
+		}},
+		{"0 0 1-10/3 * *", []time.Time{
+			time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 7, 0, 0, 0, 0, time.UTC),
+		}},
+		// 7 is Sunday as well as 0
+		{"30 6 * * 7", []time.Time{
+			time.Date(2024, 2, 4, 6, 30, 0, 0, time.UTC),
+		}},
+		// Either the day of month or the day of week may match
+		{"0 12 15 * FRI", []time.Time{
+			time.Date(2024, 2, 2, 12, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 9, 12, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 15, 12, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 16, 12, 0, 0, 0, time.UTC),
+		}},
+		// The 31st is skipped in months without one
+		{"0 0 31 * *", []time.Time{
+			time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 29 feb *", []time.Time{
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 1 JAN,jul *", []time.Time{
+			time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		at := from
+		for _, want := range tt.want {
+			if at = c.Next(at); !at.Equal(want) {
+				t.Errorf("%q: next run %v, want %v", tt.expr, at, want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * *", "needs 5 fields"},
+		{"@fortnightly", "needs 5 fields"},
+		{"60 * * * *", `minute: "60" is not a value from 0 to 59`},
+		{"* 24 * * *", "hour"},
+		{"* * 0 * *", "day of month"},
+		{"* * * 13 *", "month"},
+		{"* * * * 8", "day of week"},
+		{"*/0 * * * *", `invalid step "0"`},
+		{"*/x * * * *", `invalid step "x"`},
+		{"0 17-9 * * *", `range "17-9" runs backwards`},
+		{"0 0 * * MONDAY", "day of week"},
+		{"0 0 30 2 *", "never matches"},
+	}
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseCron(%q) error = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
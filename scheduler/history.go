@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JobState is a job's row in scheduled_jobs
+type JobState struct {
+	Name         string
+	LastStarted  sql.NullTime
+	LastFinished sql.NullTime
+	LastStatus   string
+	LastError    string
+	RunningSince sql.NullTime
+	RunningOwner string
+}
+
+// States returns the persisted state of every job that has run
+func States(ctx context.Context, db *sql.DB) (map[string]JobState, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT name, last_started_at, last_finished_at, COALESCE(last_status, ''),
+               COALESCE(last_error, ''), running_since, COALESCE(running_owner, '')
+        FROM scheduled_jobs`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading scheduled jobs: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]JobState)
+	for rows.Next() {
+		var st JobState
+		if err := rows.Scan(&st.Name, &st.LastStarted, &st.LastFinished, &st.LastStatus,
+			&st.LastError, &st.RunningSince, &st.RunningOwner); err != nil {
+			return nil, err
+		}
+		states[st.Name] = st
+	}
+	return states, rows.Err()
+}
+
+// Run is one job log entry of a scheduled job
+type Run struct {
+	Job    string
+	Status string
+	Detail string
+	At     time.Time
+}
+
+// History returns the latest limit job log entries of scheduled jobs,
+// newest first, for one job or for all of them when job is blank
+func History(ctx context.Context, db *sql.DB, job string, limit int) ([]Run, error) {
+	pattern := logPrefix + "%"
+	if job != "" {
+		pattern = logPrefix + job
+	}
+	rows, err := db.QueryContext(ctx, `
+        SELECT job, status, COALESCE(detail, ''), created_at
+        FROM job_log
+        WHERE job LIKE $1
+        ORDER BY created_at DESC, id DESC
+        LIMIT $2`, pattern, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error reading job history: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.Job, &r.Status, &r.Detail, &r.At); err != nil {
+			return nil, err
+		}
+		r.Job = strings.TrimPrefix(r.Job, logPrefix)
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,258 @@
+// Package scheduler runs periodic jobs inside a long-running process. Each
+// job's last run is kept in scheduled_jobs, so a run missed while the
+// process was down can be caught up on restart, and a lease on the row
+// stops two processes running the same job at once. Every run is recorded
+// in the job log.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nonsonwune/spk2_db/joblog"
+)
+
+// Schedule gives the next time a job is due after a given time
+type Schedule interface {
+	Next(after time.Time) time.Time
+	String() string
+}
+
+// Every runs a job at a fixed interval after its previous run
+type Every time.Duration
+
+func (e Every) Next(after time.Time) time.Time { return after.Add(time.Duration(e)) }
+
+func (e Every) String() string { return "every " + time.Duration(e).String() }
+
+// DefaultTimeout bounds a run when the job sets none. A run still marked
+// as running after its timeout is taken to have died with its process.
+const DefaultTimeout = time.Hour
+
+// Job is a named task and when to run it
+type Job struct {
+	Name     string
+	Schedule Schedule
+	// CatchUp runs the job once at startup if a run fell due while the
+	// process was down; otherwise the missed run is skipped
+	CatchUp bool
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// Triggers, recorded with each run
+const (
+	TriggerScheduled = "scheduled"
+	TriggerCatchUp   = "catch-up"
+	TriggerManual    = "manual"
+)
+
+// logPrefix marks scheduled job entries in the job log
+const logPrefix = "schedule:"
+
+// Scheduler runs its jobs until the context passed to Start is done
+type Scheduler struct {
+	db    *sql.DB
+	jobs  *joblog.Log
+	owner string
+
+	mu      sync.Mutex
+	byName  map[string]Job
+	order   []string
+	running sync.WaitGroup
+}
+
+func New(db *sql.DB, jobs *joblog.Log) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		db:     db,
+		jobs:   jobs,
+		owner:  fmt.Sprintf("%s:%d", host, os.Getpid()),
+		byName: make(map[string]Job),
+	}
+}
+
+// Add registers a job; names must be unique
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return fmt.Errorf("a scheduled job needs a name, a schedule and a function")
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = DefaultTimeout
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byName[job.Name]; ok {
+		return fmt.Errorf("scheduled job %s is already registered", job.Name)
+	}
+	s.byName[job.Name] = job
+	s.order = append(s.order, job.Name)
+	return nil
+}
+
+// Jobs returns the registered jobs in the order they were added
+func (s *Scheduler) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, len(s.order))
+	for i, name := range s.order {
+		jobs[i] = s.byName[name]
+	}
+	return jobs
+}
+
+// Start runs every job on its schedule in the background until ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.Jobs() {
+		job := job
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			s.loop(ctx, job)
+		}()
+	}
+}
+
+// Wait blocks until the job loops have stopped after their context ended
+func (s *Scheduler) Wait() {
+	s.running.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	now := time.Now()
+	next := job.Schedule.Next(now)
+	trigger := TriggerScheduled
+
+	last, err := s.lastStarted(ctx, job.Name)
+	if err != nil {
+		log.Printf("Warning: could not read last run of %s: %v", job.Name, err)
+	} else if !last.IsZero() {
+		if due := job.Schedule.Next(last); !due.After(now) {
+			if job.CatchUp {
+				next, trigger = now, TriggerCatchUp
+			} else {
+				log.Printf("Scheduled job %s missed its run at %s; next run %s",
+					job.Name, due.Format(time.RFC3339), next.Format(time.RFC3339))
+			}
+		} else {
+			next = due
+		}
+	}
+
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.run(ctx, job, trigger); err != nil && ctx.Err() == nil {
+			log.Printf("Scheduled job %s: %v", job.Name, err)
+		}
+		next, trigger = job.Schedule.Next(time.Now()), TriggerScheduled
+	}
+}
+
+// RunNow runs a registered job immediately, unless it is already running
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.mu.Lock()
+	job, ok := s.byName[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no scheduled job named %s", name)
+	}
+	return s.run(ctx, job, TriggerManual)
+}
+
+// ErrAlreadyRunning is returned when another run of the job holds its lease
+var ErrAlreadyRunning = fmt.Errorf("job is already running")
+
+func (s *Scheduler) run(ctx context.Context, job Job, trigger string) error {
+	started, ok, err := s.claim(ctx, job)
+	if err != nil {
+		return fmt.Errorf("error claiming job: %w", err)
+	}
+	if !ok {
+		s.record(ctx, job.Name, joblog.StatusFailed, trigger+": skipped, previous run still in progress")
+		return ErrAlreadyRunning
+	}
+	s.record(ctx, job.Name, joblog.StatusStarted, trigger)
+
+	runCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	runErr := job.Run(runCtx)
+	cancel()
+
+	status, detail := joblog.StatusSucceeded, fmt.Sprintf("%s in %s", trigger, time.Since(started).Round(time.Millisecond))
+	var errText sql.NullString
+	if runErr != nil {
+		status = joblog.StatusFailed
+		detail = fmt.Sprintf("%s: %v", trigger, runErr)
+		errText = sql.NullString{String: runErr.Error(), Valid: true}
+	}
+	s.record(ctx, job.Name, status, detail)
+
+	// Release the lease even if ctx was cancelled during the run
+	releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancelRelease()
+	_, err = s.db.ExecContext(releaseCtx, `
+        UPDATE scheduled_jobs
+        SET last_started_at = $2, last_finished_at = NOW(), last_status = $3, last_error = $4,
+            running_since = NULL, running_owner = NULL
+        WHERE name = $1 AND running_owner = $5`,
+		job.Name, started, status, errText, s.owner)
+	if err != nil {
+		log.Printf("Warning: could not record run of %s: %v", job.Name, err)
+	}
+	return runErr
+}
+
+// claim takes the job's lease unless another run holds it. A lease older
+// than the job's timeout belonged to a process that died mid-run.
+func (s *Scheduler) claim(ctx context.Context, job Job) (time.Time, bool, error) {
+	if _, err := s.db.ExecContext(ctx,
+		"INSERT INTO scheduled_jobs (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", job.Name); err != nil {
+		return time.Time{}, false, err
+	}
+	var started time.Time
+	err := s.db.QueryRowContext(ctx, `
+        UPDATE scheduled_jobs SET running_since = NOW(), running_owner = $2
+        WHERE name = $1
+        AND (running_since IS NULL OR running_since < NOW() - make_interval(secs => $3))
+        RETURNING running_since`,
+		job.Name, s.owner, job.Timeout.Seconds()).Scan(&started)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return started, true, nil
+}
+
+func (s *Scheduler) lastStarted(ctx context.Context, name string) (time.Time, error) {
+	var last sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"SELECT last_started_at FROM scheduled_jobs WHERE name = $1", name).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return last.Time, err
+}
+
+func (s *Scheduler) record(ctx context.Context, name, status, detail string) {
+	if s.jobs == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	err := s.jobs.Record(ctx, joblog.Entry{Job: logPrefix + name, Step: "run", Status: status, Detail: detail})
+	if err != nil {
+		log.Printf("Warning: could not log run of %s: %v", name, err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/api"
+	"github.com/nonsonwune/spk2_db/importer"
+)
+
+// handleScoreImport loads per-subject scores into candidate_scores from a
+// wide (SUBJ1-4/SCORE1-4) or long (SUBJECT/SCORE) file
+func handleScoreImport(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nImport Subject Scores")
+	fmt.Println("Wide files have REGNUMBER, SUBJ1-SUBJ4 and SCORE1-SCORE4 columns;")
+	fmt.Println("long files have one REGNUMBER, SUBJECT, SCORE row per subject.")
+	fmt.Println("Subjects may be given by id, abbreviation or name. Import candidates first.")
+	fmt.Print("Enter the CSV or .xlsx file path: ")
+	filename := readString()
+	fmt.Print("Year, used for rows without a YEAR column (e.g., 2023): ")
+	year := readInt()
+	fmt.Print("Preview without writing scores? (y/n): ")
+	preview := strings.ToLower(readString()) == "y"
+
+	reader, size, source, err := openImportSource(filename)
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+	defer source.Close()
+
+	config := importer.ImportConfig{
+		Year:         year,
+		SourceFile:   filename,
+		BatchSize:    5000,
+		ValidateOnly: preview,
+		Progress:     importer.ProgressFunc(printImportProgress),
+		SourceSize:   size,
+		OnComplete:   scoreImportHooks(db),
+	}
+
+	importCtx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	fmt.Println()
+	summary, err := importer.ImportScores(importCtx, db, config, reader)
+	if err != nil {
+		return fmt.Errorf("score import error: %w", err)
+	}
+
+	printScoreSummary(summary)
+	return nil
+}
+
+// scoreImportHooks are run after scores have been written
+func scoreImportHooks(db *sql.DB) []importer.CompletionHook {
+	return []importer.CompletionHook{
+		func(ctx context.Context, year int) error {
+			return api.NotifyImportComplete(ctx, db, year)
+		},
+		statsRefresher.AfterImport,
+	}
+}
+
+func printScoreSummary(summary *importer.ScoreSummary) {
+	fmt.Printf("\nRows read: %d\n", summary.Rows)
+	if summary.Applied {
+		fmt.Printf("Scores written: %d\n", summary.Scores)
+	} else {
+		fmt.Printf("Scores that would be written: %d\n", summary.Scores)
+	}
+	fmt.Printf("Rows without a usable score: %d\n", summary.Failed)
+	fmt.Printf("Missing or non-numeric scores: %d\n", summary.Invalid)
+	fmt.Printf("Scores for candidates not in the database: %d\n", summary.UnknownCandidates)
+	if subjects := summary.Subjects(); len(subjects) > 0 {
+		color.Yellow("Unknown subjects:")
+		for _, subject := range subjects {
+			fmt.Printf("  %-20s %d\n", subject, summary.UnknownSubjects[subject])
+		}
+	}
+	if keys := summary.OutOfRangeSubjects(); len(keys) > 0 {
+		color.Yellow("Scores outside their subject's range, left out:")
+		for _, key := range keys {
+			fmt.Printf("  %-20s %d\n", key, summary.OutOfRange[key])
+		}
+	}
+	if summary.Applied && summary.Scores > 0 {
+		color.Green("Score import completed")
+	}
+}
+
+// runScoreRange lists and configures the per-subject score ranges score
+// imports check against
+func runScoreRange(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("score-range")
+	year := fs.Int("year", 0, "with set or delete, the year the range applies to (default every year without its own)")
+	minScore := fs.Int("min", importer.DefaultMinScore, "with set, the lowest valid score")
+	maxScore := fs.Int("max", 0, "with set, the highest valid score")
+	format := fs.String("format", "table", "with list, output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "with list, write the result to this file instead of stdout")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError{errors.New("score-range needs list, set SUBJECT -max N or delete SUBJECT")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	action := positional[0]
+	if action == "list" {
+		ranges, err := importer.ListScoreRanges(ctx, db)
+		if err != nil {
+			return err
+		}
+		rows := make([][]interface{}, len(ranges))
+		for i, r := range ranges {
+			year := "all"
+			if r.Year != 0 {
+				year = strconv.Itoa(r.Year)
+			}
+			rows[i] = []interface{}{r.Subject, year, r.Min, r.Max}
+		}
+		return writeResult("score-ranges", []string{"subject", "year", "min", "max"}, rows, *format, *output)
+	}
+	if action != "set" && action != "delete" {
+		return usageError{fmt.Errorf("unknown score-range action %q", action)}
+	}
+	if len(positional) != 2 {
+		return usageError{fmt.Errorf("score-range %s needs a subject", action)}
+	}
+	subjectID, err := importer.ResolveSubject(ctx, db, positional[1])
+	if err != nil {
+		return err
+	}
+	applies := "every year"
+	if *year != 0 {
+		applies = strconv.Itoa(*year)
+	}
+
+	if action == "delete" {
+		if err := importer.DeleteScoreRange(ctx, db, subjectID, *year); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Deleted the %s score range for %s\n", strings.ToUpper(positional[1]), applies)
+		return nil
+	}
+	if *maxScore <= 0 {
+		return usageError{errors.New("score-range set needs -max")}
+	}
+	r := importer.ScoreRange{SubjectID: subjectID, Year: *year, Min: *minScore, Max: *maxScore}
+	if err := importer.SetScoreRange(ctx, db, r); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s scores must be %s for %s\n", strings.ToUpper(positional[1]), r, applies)
+	return nil
+}
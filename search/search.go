@@ -0,0 +1,241 @@
+// Package search finds candidates by name or registration number with
+// optional filters, sorting and pagination. The interactive menu, the CLI and
+// the HTTP API share it so they always return the same pages.
+package search
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nonsonwune/spk2_db/filter"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 500
+)
+
+// Criteria selects candidates. Zero values do not restrict the search.
+type Criteria struct {
+	// Term matches part of the registration number, surname or first name
+	Term     string
+	Year     int
+	State    string // state name
+	LGA      string // local government area name
+	Gender   string
+	Course   string // first choice course code, or part of its name
+	MinScore *int   // aggregate bounds, inclusive
+	MaxScore *int
+	Admitted *bool
+	// Filter is an additional filter expression, as in the session filter
+	Filter *filter.Filter
+	// WithholdNames leaves names out of the results and matches Term on
+	// registration numbers only, for the API
+	WithholdNames bool
+}
+
+// sortColumns are the columns results may be sorted by
+var sortColumns = map[string]string{
+	"regnumber": "c.regnumber",
+	"surname":   "c.surname",
+	"aggregate": "c.aggregate",
+	"year":      "c.year",
+	"state":     "s.st_name",
+}
+
+// SortFields lists the names accepted by Options.Sort
+func SortFields() []string {
+	names := make([]string, 0, len(sortColumns))
+	for name := range sortColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options orders and pages the results
+type Options struct {
+	Sort     string // one of SortFields; regnumber when empty
+	Desc     bool
+	Page     int // from 1
+	PageSize int
+}
+
+// Candidate is one search result
+type Candidate struct {
+	RegNumber string `json:"regnumber"`
+	Surname   string `json:"surname,omitempty"`
+	FirstName string `json:"firstname,omitempty"`
+	Gender    string `json:"gender"`
+	Year      int    `json:"year"`
+	Aggregate *int   `json:"aggregate"`
+	State     string `json:"state"`
+	LGA       string `json:"lga"`
+	Course    string `json:"course"`
+	Admitted  bool   `json:"admitted"`
+}
+
+// Result is one page of matches
+type Result struct {
+	Candidates []Candidate `json:"candidates"`
+	Page       int         `json:"page"`
+	PageSize   int         `json:"page_size"`
+	Total      int         `json:"total"`
+}
+
+// Pages is the number of pages the matches fill
+func (r *Result) Pages() int {
+	return (r.Total + r.PageSize - 1) / r.PageSize
+}
+
+// HasNext reports whether there are matches after this page
+func (r *Result) HasNext() bool {
+	return r.Page*r.PageSize < r.Total
+}
+
+// Validate fills in defaults and checks the options
+func (o *Options) Validate() error {
+	if o.Sort == "" {
+		o.Sort = "regnumber"
+	}
+	if _, ok := sortColumns[o.Sort]; !ok {
+		return fmt.Errorf("cannot sort by %q (available: %s)", o.Sort, strings.Join(SortFields(), ", "))
+	}
+	if o.Page == 0 {
+		o.Page = 1
+	}
+	if o.Page < 1 {
+		return fmt.Errorf("page must be at least 1")
+	}
+	if o.PageSize == 0 {
+		o.PageSize = DefaultPageSize
+	}
+	if o.PageSize < 1 || o.PageSize > MaxPageSize {
+		return fmt.Errorf("page size must be between 1 and %d", MaxPageSize)
+	}
+	return nil
+}
+
+// where builds the condition for c and its arguments
+func (c Criteria) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if term := strings.TrimSpace(c.Term); term != "" {
+		p := arg("%" + term + "%")
+		if c.WithholdNames {
+			conds = append(conds, "c.regnumber ILIKE "+p)
+		} else {
+			conds = append(conds, fmt.Sprintf("(c.regnumber ILIKE %[1]s OR c.surname ILIKE %[1]s OR c.firstname ILIKE %[1]s)", p))
+		}
+	}
+	if c.Year != 0 {
+		conds = append(conds, "c.year = "+arg(c.Year))
+	}
+	if c.State != "" {
+		conds = append(conds, "UPPER(s.st_name) = UPPER("+arg(strings.TrimSpace(c.State))+")")
+	}
+	if c.LGA != "" {
+		conds = append(conds, "UPPER(l.lg_name) = UPPER("+arg(strings.TrimSpace(c.LGA))+")")
+	}
+	if c.Gender != "" {
+		conds = append(conds, "UPPER(c.gender) = UPPER("+arg(strings.TrimSpace(c.Gender))+")")
+	}
+	if c.Course != "" {
+		course := strings.TrimSpace(c.Course)
+		conds = append(conds, fmt.Sprintf("(c.app_course1 = %s OR co.course_name ILIKE %s)", arg(course), arg("%"+course+"%")))
+	}
+	if c.MinScore != nil {
+		conds = append(conds, "c.aggregate >= "+arg(*c.MinScore))
+	}
+	if c.MaxScore != nil {
+		conds = append(conds, "c.aggregate <= "+arg(*c.MaxScore))
+	}
+	if c.Admitted != nil {
+		conds = append(conds, "COALESCE(c.is_admitted, false) = "+arg(*c.Admitted))
+	}
+	if c.Filter != nil {
+		expr, filterArgs := c.Filter.SQL("c", len(args))
+		conds = append(conds, expr)
+		args = append(args, filterArgs...)
+	}
+
+	if len(conds) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Run returns the page of candidates matching c selected by opts
+func Run(ctx context.Context, db *sql.DB, c Criteria, opts Options) (*Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	where, args := c.where()
+	direction := "ASC"
+	if opts.Desc {
+		direction = "DESC"
+	}
+	query := fmt.Sprintf(`
+        SELECT c.regnumber, COALESCE(c.surname, ''), COALESCE(c.firstname, ''),
+               COALESCE(c.gender, ''), COALESCE(c.year, 0), c.aggregate,
+               COALESCE(s.st_name, ''), COALESCE(l.lg_name, ''),
+               COALESCE(co.course_name, c.app_course1, ''), COALESCE(c.is_admitted, false),
+               COUNT(*) OVER ()
+        FROM candidate c
+        LEFT JOIN state s ON s.st_id = c.statecode
+        LEFT JOIN lga l ON l.lg_id = c.lg_id
+        LEFT JOIN course co ON co.course_code = c.app_course1
+        WHERE %s
+        ORDER BY %s %s NULLS LAST, c.regnumber
+        LIMIT %d OFFSET %d`,
+		where, sortColumns[opts.Sort], direction, opts.PageSize, (opts.Page-1)*opts.PageSize)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching candidates: %w", err)
+	}
+	defer rows.Close()
+
+	result := &Result{Candidates: []Candidate{}, Page: opts.Page, PageSize: opts.PageSize}
+	for rows.Next() {
+		var cand Candidate
+		var aggregate sql.NullInt64
+		if err := rows.Scan(&cand.RegNumber, &cand.Surname, &cand.FirstName, &cand.Gender, &cand.Year,
+			&aggregate, &cand.State, &cand.LGA, &cand.Course, &cand.Admitted, &result.Total); err != nil {
+			return nil, fmt.Errorf("error reading search results: %w", err)
+		}
+		if aggregate.Valid {
+			n := int(aggregate.Int64)
+			cand.Aggregate = &n
+		}
+		if c.WithholdNames {
+			cand.Surname, cand.FirstName = "", ""
+		}
+		result.Candidates = append(result.Candidates, cand)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading search results: %w", err)
+	}
+
+	// A page past the end has no rows to carry the total
+	if len(result.Candidates) == 0 && opts.Page > 1 {
+		count := fmt.Sprintf(`
+            SELECT COUNT(*) FROM candidate c
+            LEFT JOIN state s ON s.st_id = c.statecode
+            LEFT JOIN lga l ON l.lg_id = c.lg_id
+            LEFT JOIN course co ON co.course_code = c.app_course1
+            WHERE %s`, where)
+		if err := db.QueryRowContext(ctx, count, args...).Scan(&result.Total); err != nil {
+			return nil, fmt.Errorf("error counting search results: %w", err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,141 @@
+// Package secrets fetches database credentials and API keys from a secret
+// store, so they need not be kept in .env. Secrets are read as the same
+// names the environment would use, e.g. DB_PASSWORD or GEMINI_API_KEY_1.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Source reads every secret the application uses from one store
+type Source interface {
+	Name() string
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// DefaultRefresh is how long fetched secrets are cached before the store is
+// asked again, which is how rotated credentials are picked up
+const DefaultRefresh = 5 * time.Minute
+
+// FromEnv builds the source selected by SECRETS_BACKEND, or returns nil when
+// it is blank and settings come only from .env and the environment.
+// Vault reads VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE and SECRETS_PATH, a
+// KV path such as secret/data/spk2. AWS Secrets Manager reads SECRETS_ID,
+// AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN; the secret must be a JSON object.
+func FromEnv() (Source, error) {
+	switch backend := os.Getenv("SECRETS_BACKEND"); backend {
+	case "", "env":
+		return nil, nil
+	case "vault":
+		addr, token, path := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"), os.Getenv("SECRETS_PATH")
+		if addr == "" || token == "" || path == "" {
+			return nil, fmt.Errorf("SECRETS_BACKEND=vault needs VAULT_ADDR, VAULT_TOKEN and SECRETS_PATH")
+		}
+		return NewVault(addr, token, os.Getenv("VAULT_NAMESPACE"), path), nil
+	case "aws":
+		creds := awsCredentials{
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		id := os.Getenv("SECRETS_ID")
+		if id == "" || region == "" || creds.accessKey == "" || creds.secretKey == "" {
+			return nil, fmt.Errorf("SECRETS_BACKEND=aws needs SECRETS_ID, AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return NewSecretsManager(region, id, creds, os.Getenv("SECRETS_AWS_ENDPOINT")), nil
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_BACKEND %q (use vault or aws)", backend)
+	}
+}
+
+// Store caches a source's secrets for a refresh interval and copies them
+// into the process environment, where the settings are read from
+type Store struct {
+	source  Source
+	refresh time.Duration
+
+	mu      sync.Mutex
+	values  map[string]string
+	fetched time.Time
+}
+
+func NewStore(source Source, refresh time.Duration) *Store {
+	if refresh <= 0 {
+		refresh = DefaultRefresh
+	}
+	return &Store{source: source, refresh: refresh}
+}
+
+// Load fetches the secrets and sets them in the environment, overriding
+// .env. It is called before the settings are read.
+func (s *Store) Load(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetch(ctx)
+}
+
+func (s *Store) fetch(ctx context.Context) error {
+	values, err := s.source.Fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching secrets from %s: %w", s.source.Name(), err)
+	}
+	for key, value := range values {
+		if s.values != nil && s.values[key] != value {
+			log.Printf("Secret %s rotated in %s", key, s.source.Name())
+		}
+		os.Setenv(key, value)
+	}
+	s.values, s.fetched = values, time.Now()
+	return nil
+}
+
+// Get returns a secret, fetching again when the cached copy is older than
+// the refresh interval. If the store cannot be reached the cached value is
+// kept, so an outage does not stop new database connections.
+func (s *Store) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil || time.Since(s.fetched) >= s.refresh {
+		if err := s.fetch(ctx); err != nil {
+			if s.values == nil {
+				return "", err
+			}
+			log.Printf("Warning: %v; using cached secrets", err)
+			s.fetched = time.Now()
+		}
+	}
+	value, ok := s.values[key]
+	if !ok {
+		return os.Getenv(key), nil
+	}
+	return value, nil
+}
+
+// Watch refreshes the secrets every interval until ctx is done, so API
+// keys rotated in the store reach engines created afterwards
+func (s *Store) Watch(ctx context.Context) {
+	ticker := time.NewTicker(s.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if err := s.fetch(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Warning: %v", err)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault reads one secret from a HashiCorp Vault KV engine, version 1 or 2
+type Vault struct {
+	addr      string
+	token     string
+	namespace string
+	path      string
+	client    *http.Client
+}
+
+func NewVault(addr, token, namespace, path string) *Vault {
+	return &Vault{
+		addr:      strings.TrimRight(addr, "/"),
+		token:     token,
+		namespace: namespace,
+		path:      strings.Trim(path, "/"),
+		client:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (v *Vault) Name() string { return "vault" }
+
+func (v *Vault) Fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+v.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s for %s", resp.Status, v.path)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding vault response: %w", err)
+	}
+	data := body.Data
+	// KV version 2 nests the secret under data.data beside data.metadata
+	if nested, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			data = nil
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return nil, fmt.Errorf("error decoding vault secret: %w", err)
+			}
+		}
+	}
+	return stringValues(data)
+}
+
+// stringValues converts a secret's JSON fields to strings; numbers such as
+// a port are kept as written
+func stringValues(fields map[string]json.RawMessage) (map[string]string, error) {
+	values := make(map[string]string, len(fields))
+	for key, raw := range fields {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			values[key] = s
+			continue
+		}
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, fmt.Errorf("secret field %s is not a string or number", key)
+		}
+		values[key] = n.String()
+	}
+	return values, nil
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/privacy"
+)
+
+// workingSet materialises the candidates matching the session filter once so
+// that subsequent reports read from a small table instead of re-filtering.
+//
+// A regular UNLOGGED table is used rather than a TEMP table because reports run
+// on pooled connections and a temp table is only visible to its own session.
+// While it has tables the process holds an advisory lock on sessionKey, so
+// that dropStaleSessionTables can tell them from those left by a crash.
+type workingSet struct {
+	filter *filter.Filter
+	list   *candidateList // optional regnumber list the set is restricted to
+	table  string         // empty when no working set is active
+	rows   int64
+	lock   *sql.Conn // holds the advisory lock; nil until a table is made
+}
+
+var currentSession = &workingSet{}
+
+// sessionKey tells this process's session tables apart from those of other
+// processes sharing the database. Process IDs can't: every container runs
+// its process as PID 1, and a crashed process's tables would be taken over
+// by the next one given its PID.
+var sessionKey = newSessionKey()
+
+func newSessionKey() int64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.BigEndian.Uint64(b[:]) >> 1)
+}
+
+// CandidateSource returns the relation reports should read candidates from
+func (ws *workingSet) CandidateSource() string {
+	if ws.table == "" {
+		return "candidate"
+	}
+	return ws.table
+}
+
+func (ws *workingSet) tableName() string {
+	return fmt.Sprintf("working_set_%x", sessionKey)
+}
+
+func (ws *workingSet) listTableName() string {
+	return fmt.Sprintf("candidate_list_%x", sessionKey)
+}
+
+// Describe summarises what the working set is restricted to
+func (ws *workingSet) Describe() string {
+	var parts []string
+	if ws.list != nil {
+		parts = append(parts, fmt.Sprintf("list %s (%d regnumbers)", filepath.Base(ws.list.file), ws.list.loaded))
+	}
+	if ws.filter != nil {
+		parts = append(parts, ws.filter.String())
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// Apply replaces the active working set with the candidates matching filter,
+// keeping any loaded regnumber list
+func (ws *workingSet) Apply(ctx context.Context, db *sql.DB, expr *filter.Filter) error {
+	return ws.build(ctx, db, expr, ws.list)
+}
+
+// ApplyList loads the registration numbers in path and restricts the working
+// set to them, combined with filter if one is given
+func (ws *workingSet) ApplyList(ctx context.Context, db *sql.DB, path string, expr *filter.Filter) error {
+	regs, err := readRegNumbers(path)
+	if err != nil {
+		return err
+	}
+	if err := ws.Clear(ctx, db); err != nil {
+		return err
+	}
+	if err := ws.claim(ctx, db); err != nil {
+		return err
+	}
+	list := &candidateList{file: path, table: ws.listTableName(), loaded: int64(len(regs))}
+	if err := loadCandidateList(ctx, db, list.table, regs); err != nil {
+		db.ExecContext(ctx, "DROP TABLE IF EXISTS "+list.table)
+		return err
+	}
+	if err := ws.build(ctx, db, expr, list); err != nil {
+		db.ExecContext(ctx, "DROP TABLE IF EXISTS "+list.table)
+		return err
+	}
+	return nil
+}
+
+func (ws *workingSet) build(ctx context.Context, db *sql.DB, expr *filter.Filter, list *candidateList) error {
+	// Detach the list first so Clear leaves its table in place
+	ws.list = nil
+	if err := ws.Clear(ctx, db); err != nil {
+		return err
+	}
+	if err := ws.claim(ctx, db); err != nil {
+		return err
+	}
+
+	table := ws.tableName()
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(
+		`CREATE UNLOGGED TABLE %s (LIKE candidate INCLUDING DEFAULTS)`, table)); err != nil {
+		return fmt.Errorf("error creating working set: %w", err)
+	}
+	// Drop the table again if any later step fails, including when ctx
+	// being cancelled is the failure
+	built := false
+	defer func() {
+		if !built {
+			db.ExecContext(context.WithoutCancel(ctx), "DROP TABLE IF EXISTS "+table)
+		}
+	}()
+
+	from := "candidate c"
+	if list != nil {
+		from = fmt.Sprintf("candidate c JOIN %s l ON l.regnumber = c.regnumber", list.table)
+	}
+	where, args := expr.SQL("c", 0)
+	res, err := db.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s SELECT c.* FROM %s WHERE %s`, table, from, where), args...)
+	if err != nil {
+		return fmt.Errorf("error populating working set: %w", err)
+	}
+	rows, _ := res.RowsAffected()
+
+	for _, stmt := range []string{
+		fmt.Sprintf(`CREATE INDEX ON %s (regnumber)`, table),
+		fmt.Sprintf(`CREATE INDEX ON %s (year)`, table),
+		fmt.Sprintf(`ANALYZE %s`, table),
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error preparing working set: %w", err)
+		}
+	}
+
+	built = true
+	ws.filter = expr
+	ws.list = list
+	ws.table = table
+	ws.rows = rows
+	return nil
+}
+
+// Clear drops the working set and any loaded list so reports read the full
+// candidate table again
+func (ws *workingSet) Clear(ctx context.Context, db *sql.DB) error {
+	if ws.list != nil {
+		if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+ws.list.table); err != nil {
+			return fmt.Errorf("error dropping candidate list: %w", err)
+		}
+		ws.list = nil
+	}
+	if ws.table == "" {
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+ws.table); err != nil {
+		return fmt.Errorf("error dropping working set: %w", err)
+	}
+	ws.filter = nil
+	ws.table = ""
+	ws.rows = 0
+	return nil
+}
+
+// Close clears the working set and releases the session's advisory lock
+func (ws *workingSet) Close(ctx context.Context, db *sql.DB) error {
+	err := ws.Clear(ctx, db)
+	if ws.lock != nil {
+		ws.lock.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", sessionKey)
+		ws.lock.Close()
+		ws.lock = nil
+	}
+	return err
+}
+
+// claim takes the advisory lock on sessionKey, on a connection kept for as
+// long as the process runs, before the session makes its first table
+func (ws *workingSet) claim(ctx context.Context, db *sql.DB) error {
+	if ws.lock != nil {
+		return nil
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", sessionKey); err != nil {
+		conn.Close()
+		return fmt.Errorf("error locking session tables: %w", err)
+	}
+	ws.lock = conn
+	return nil
+}
+
+// dropStaleSessionTables drops the working sets and candidate lists left
+// behind by processes that ended without clearing them, e.g. by crashing.
+// A table is stale when nobody holds the advisory lock on its key.
+func dropStaleSessionTables(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+        SELECT tablename FROM pg_tables
+        WHERE schemaname = current_schema()
+            AND tablename ~ '^(working_set|candidate_list)_[0-9a-f]+$'`)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(tables) == 0 {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, table := range tables {
+		key, err := strconv.ParseInt(table[strings.LastIndex(table, "_")+1:], 16, 64)
+		if err != nil {
+			continue
+		}
+		var free bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&free); err != nil {
+			return err
+		}
+		if !free {
+			continue
+		}
+		_, err = conn.ExecContext(ctx, "DROP TABLE IF EXISTS "+table)
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", key)
+		if err != nil {
+			return fmt.Errorf("error dropping %s: %w", table, err)
+		}
+		log.Printf("Dropped %s, left behind by an earlier session", table)
+	}
+	return nil
+}
+
+// BulkUpdate sets column to value on every candidate in the working set, in
+// both the candidate table and the working set itself
+func (ws *workingSet) BulkUpdate(ctx context.Context, db *sql.DB, column string, value interface{}) (int64, error) {
+	if ws.table == "" {
+		return 0, fmt.Errorf("no working set is active")
+	}
+	if _, ok := bulkUpdateFields[column]; !ok {
+		return 0, fmt.Errorf("column %s cannot be bulk updated", column)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`
+        UPDATE candidate c SET %[1]s = $1, updated_at = NOW()
+        FROM %[2]s w
+        WHERE c.regnumber = w.regnumber`, column, ws.table), value)
+	if err != nil {
+		return 0, fmt.Errorf("error updating candidates: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET %s = $1, updated_at = NOW()`, ws.table, column), value); err != nil {
+		return 0, fmt.Errorf("error updating working set: %w", err)
+	}
+	return n, tx.Commit()
+}
+
+func handleSessionFilter(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nSession Filter")
+	if currentSession.table != "" {
+		fmt.Printf("Active filter: %s (%d candidates)\n", currentSession.Describe(), currentSession.rows)
+	} else {
+		fmt.Println("Active filter: none")
+	}
+
+	fmt.Println("\n1. Set filter")
+	fmt.Println("2. Clear filter")
+	fmt.Println("3. Load regnumber list (CSV/TXT)")
+	fmt.Println("4. Bulk update candidates in working set")
+	fmt.Println("5. Public output mode (k-anonymity)")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		fmt.Printf("Fields: %s\n", strings.Join(filter.FieldNames(), ", "))
+		fmt.Println("Example: year=2023 AND state IN (LAGOS,OGUN) AND aggregate>250")
+		fmt.Println("Press Tab to complete field names and state, LGA, course or institution values")
+		input := readFilter(ctx, db, "Filter: ")
+		if input == "" {
+			return currentSession.Clear(ctx, db)
+		}
+
+		expr, err := filter.Parse(input)
+		if err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+
+		start := time.Now()
+		if err := currentSession.Apply(ctx, db, expr); err != nil {
+			return err
+		}
+		color.Green("Working set ready: %d candidates matching %s (%v)",
+			currentSession.rows, expr, time.Since(start).Round(time.Millisecond))
+	case "2":
+		if err := currentSession.Clear(ctx, db); err != nil {
+			return err
+		}
+		color.Green("Session filter cleared")
+	case "3":
+		return loadSessionList(ctx, db)
+	case "4":
+		return bulkUpdateSession(ctx, db)
+	case "5":
+		return togglePublicOutput()
+	}
+	return nil
+}
+
+func loadSessionList(ctx context.Context, db *sql.DB) error {
+	fmt.Print("List file (.csv or .txt): ")
+	path := readString()
+	if path == "" {
+		return fmt.Errorf("a list file is required")
+	}
+	var expr *filter.Filter
+	if input := readFilter(ctx, db, "Additional filter (blank for none): "); input != "" {
+		var err error
+		if expr, err = filter.Parse(input); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	start := time.Now()
+	if err := currentSession.ApplyList(ctx, db, path, expr); err != nil {
+		return err
+	}
+	color.Green("Working set ready: %d candidates from %s (%v)",
+		currentSession.rows, currentSession.Describe(), time.Since(start).Round(time.Millisecond))
+	if missing := currentSession.list.loaded - currentSession.rows; missing > 0 && expr == nil {
+		color.Yellow("%d registration numbers in the list were not found", missing)
+	}
+	return nil
+}
+
+func bulkUpdateSession(ctx context.Context, db *sql.DB) error {
+	if currentSession.table == "" {
+		return fmt.Errorf("set a filter or load a list first")
+	}
+
+	columns := make([]string, 0, len(bulkUpdateFields))
+	for column := range bulkUpdateFields {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	fmt.Printf("Columns: %s\n", strings.Join(columns, ", "))
+	fmt.Print("Column to update: ")
+	column := strings.ToLower(readString())
+	kind, ok := bulkUpdateFields[column]
+	if !ok {
+		return fmt.Errorf("column %s cannot be bulk updated", column)
+	}
+
+	fmt.Print("New value (blank for NULL): ")
+	input := readString()
+	var value interface{}
+	if input != "" {
+		value = input
+		if kind == "bool" {
+			b, err := strconv.ParseBool(input)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", input)
+			}
+			value = b
+		}
+	}
+
+	fmt.Printf("Set %s on %d candidates (%s)? (y/n): ", column, currentSession.rows, currentSession.Describe())
+	if strings.ToLower(readString()) != "y" {
+		fmt.Println("Update cancelled.")
+		return nil
+	}
+	n, err := currentSession.BulkUpdate(ctx, db, column, value)
+	if err != nil {
+		return err
+	}
+	color.Green("Updated %d candidates", n)
+	return nil
+}
+
+// togglePublicOutput switches public output mode, in which reports withhold
+// small groups so their output can be published
+func togglePublicOutput() error {
+	if publicOutput != nil {
+		publicOutput = nil
+		color.Green("Public output mode off")
+		return nil
+	}
+
+	guard, err := privacy.FromEnv()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Minimum group size k [%d]: ", guard.K)
+	if raw := readString(); raw != "" {
+		k, err := strconv.Atoi(raw)
+		if err != nil || k < 1 {
+			return fmt.Errorf("invalid group size %q", raw)
+		}
+		guard.K = k
+	}
+	fmt.Printf("Suppress or merge small groups [%s]: ", guard.Mode)
+	if raw := readString(); raw != "" {
+		if guard.Mode, err = privacy.ParseMode(raw); err != nil {
+			return err
+		}
+	}
+	publicOutput = &guard
+	color.Green("Public output mode on (k=%d, %s small groups)", guard.K, guard.Mode)
+	return nil
+}
@@ -0,0 +1,244 @@
+// Package slip renders a candidate's result slip, their subject scores,
+// aggregate and standing among the year's candidates, as text or PDF for
+// support desks answering candidates' inquiries.
+package slip
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/nonsonwune/spk2_db/models"
+)
+
+// Score is one subject on the slip
+type Score struct {
+	Subject string
+	Score   int
+}
+
+// Slip is what a result slip shows of a candidate
+type Slip struct {
+	RegNumber   string
+	Name        string
+	Gender      string
+	Year        int
+	State       string
+	LGA         string
+	ExamCentre  string
+	Course      string
+	Institution string
+	Scores      []Score
+	// Aggregate is nil for a candidate without one; Standing is then nil too
+	Aggregate   *int
+	Standing    []models.StandingLevel
+	Malpractice string
+	IssuedAt    time.Time
+}
+
+// Build loads a candidate's slip
+func Build(ctx context.Context, db *sql.DB, regNumber string) (*Slip, error) {
+	repo := models.NewCandidateRepository(db)
+	c, err := repo.Load(ctx, regNumber)
+	if err != nil {
+		return nil, err
+	}
+	s := &Slip{
+		RegNumber:   c.RegNumber,
+		Name:        fullName(c),
+		Gender:      c.Gender.String,
+		Year:        c.Year,
+		Course:      c.AppCourse1.String,
+		Institution: c.InID.String,
+		Malpractice: c.Malpractice.String,
+		IssuedAt:    time.Now(),
+	}
+	if c.State != nil {
+		s.State = c.State.Name
+	}
+	if c.LGA != nil {
+		s.LGA = c.LGA.Name
+	}
+	if c.Course != nil {
+		s.Course = fmt.Sprintf("%s (%s)", c.Course.CourseName, c.Course.CourseCode)
+	}
+	if c.Institution != nil {
+		s.Institution = fmt.Sprintf("%s (%s)", c.Institution.InName, c.Institution.InID)
+	}
+	if e := c.ExamInfo; e != nil {
+		s.ExamCentre = strings.Trim(e.ExamCentre+", "+e.ExamTown, ", ")
+	}
+	for _, score := range c.Scores {
+		s.Scores = append(s.Scores, Score{Subject: score.Subject.Name, Score: score.Score})
+	}
+	if !c.Aggregate.Valid {
+		return s, nil
+	}
+	aggregate := int(c.Aggregate.Int64)
+	s.Aggregate = &aggregate
+
+	standing, err := repo.Standing(ctx, c.RegNumber, c.Year)
+	if errors.Is(err, models.ErrNoAggregate) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Standing = standing.Levels
+	return s, nil
+}
+
+// fullName is the surname in capitals followed by the other names
+func fullName(c *models.Candidate) string {
+	return strings.Join(strings.Fields(strings.ToUpper(c.Surname.String)+" "+c.FirstName.String+" "+c.MiddleName.String), " ")
+}
+
+// details are the slip's label and value lines above the scores
+func (s *Slip) details() [][2]string {
+	details := [][2]string{
+		{"Registration number", s.RegNumber},
+		{"Name", s.Name},
+		{"Gender", s.Gender},
+		{"Year", fmt.Sprint(s.Year)},
+		{"State of origin", strings.Trim(s.State+" / "+s.LGA, " /")},
+		{"Exam centre", s.ExamCentre},
+		{"First choice course", s.Course},
+		{"First choice institution", s.Institution},
+	}
+	if s.Malpractice != "" {
+		details = append(details, [2]string{"Malpractice", s.Malpractice})
+	}
+	return details
+}
+
+func (s *Slip) aggregate() string {
+	if s.Aggregate == nil {
+		return "not computed"
+	}
+	return fmt.Sprint(*s.Aggregate)
+}
+
+// standingLine describes one level of the candidate's standing
+func standingLine(l models.StandingLevel) [4]string {
+	group := "All candidates"
+	switch l.Scope {
+	case models.ScopeState:
+		group = l.Group + " state"
+	case models.ScopeCourse:
+		group = l.Group
+	}
+	z := "-"
+	if l.ZScore != nil {
+		z = fmt.Sprintf("%+.2f", *l.ZScore)
+	}
+	return [4]string{group, fmt.Sprintf("%d of %d", l.Rank, l.Candidates), fmt.Sprintf("%.1f", l.Percentile), z}
+}
+
+// WriteText writes the slip as plain text for pasting into a reply
+func (s *Slip) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "UTME RESULT SLIP\n\n")
+	for _, d := range s.details() {
+		fmt.Fprintf(tw, "%s\t%s\n", d[0], d[1])
+	}
+
+	fmt.Fprintf(tw, "\nSubject\tScore\n")
+	if len(s.Scores) == 0 {
+		fmt.Fprintf(tw, "No scores recorded\t\n")
+	}
+	for _, score := range s.Scores {
+		fmt.Fprintf(tw, "%s\t%d\n", score.Subject, score.Score)
+	}
+	fmt.Fprintf(tw, "Aggregate\t%s\n", s.aggregate())
+
+	if len(s.Standing) > 0 {
+		fmt.Fprintf(tw, "\nAmong\tRank\tPercentile\tZ-score\n")
+		for _, l := range s.Standing {
+			line := standingLine(l)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", line[0], line[1], line[2], line[3])
+		}
+	}
+	fmt.Fprintf(tw, "\nIssued %s. The percentile is the share of the year's other candidates who scored lower.\n",
+		s.IssuedAt.Format("2 January 2006 15:04"))
+	return tw.Flush()
+}
+
+// WritePDF writes the slip as a one page A4 PDF. The core fonts are
+// Latin-1, so characters outside it print as dots.
+func (s *Slip) WritePDF(w io.Writer) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetTitle("UTME result slip "+s.RegNumber, true)
+	pdf.AddPage()
+	latin1 := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, "UTME Result Slip", "", 1, "C", false, 0, "")
+	pdf.Line(20, pdf.GetY()+1, 190, pdf.GetY()+1)
+	pdf.Ln(6)
+
+	for _, d := range s.details() {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(55, 6.5, d[0], "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 6.5, latin1(d[1]), "", "L", false)
+	}
+	pdf.Ln(6)
+
+	header := func(widths []float64, titles ...string) {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetFillColor(230, 230, 230)
+		for i, t := range titles {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], 7, t, "1", 0, align, true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 10)
+	}
+	row := func(widths []float64, cells ...string) {
+		for i, c := range cells {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], 7, latin1(c), "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	scoreWidths := []float64{120, 50}
+	header(scoreWidths, "Subject", "Score")
+	if len(s.Scores) == 0 {
+		row(scoreWidths, "No scores recorded", "")
+	}
+	for _, score := range s.Scores {
+		row(scoreWidths, score.Subject, fmt.Sprint(score.Score))
+	}
+	pdf.SetFont("Helvetica", "B", 10)
+	row(scoreWidths, "Aggregate", s.aggregate())
+	pdf.Ln(6)
+
+	if len(s.Standing) > 0 {
+		standingWidths := []float64{80, 35, 30, 25}
+		header(standingWidths, "Among", "Rank", "Percentile", "Z-score")
+		for _, l := range s.Standing {
+			line := standingLine(l)
+			row(standingWidths, line[:]...)
+		}
+		pdf.Ln(6)
+	}
+
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.MultiCell(0, 4.5, fmt.Sprintf("Issued %s. The percentile is the share of the year's other candidates who scored lower.",
+		s.IssuedAt.Format("2 January 2006 15:04")), "", "L", false)
+	return pdf.Output(w)
+}
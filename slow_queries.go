@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/delivery"
+	"github.com/nonsonwune/spk2_db/scheduler"
+	"github.com/nonsonwune/spk2_db/slowlog"
+)
+
+var slowQueryHeader = []string{"fingerprint", "runs", "total_s", "avg_ms", "p95_ms", "max_ms", "errors", "last_seen", "sources", "statement", "advice"}
+
+func slowQueryRows(offenders []slowlog.Offender) [][]interface{} {
+	rows := make([][]interface{}, len(offenders))
+	for i, o := range offenders {
+		rows[i] = []interface{}{o.Fingerprint, o.Runs, fmt.Sprintf("%.1f", o.TotalMs/1000),
+			fmt.Sprintf("%.0f", o.AvgMs), fmt.Sprintf("%.0f", o.P95Ms), fmt.Sprintf("%.0f", o.MaxMs), o.Errors,
+			o.LastSeen.Format("2006-01-02 15:04"), o.Sources, strings.Join(strings.Fields(o.Statement), " "),
+			strings.Join(o.Advice, "; ")}
+	}
+	return rows
+}
+
+// handleSlowQueries shows the queries that spent the most time over
+// DB_SLOW_QUERY_THRESHOLD, with what to look at for each
+func handleSlowQueries(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nSlow Queries")
+	fmt.Print("Days to look back [7]: ")
+	days := readInt()
+	if days <= 0 {
+		days = 7
+	}
+	offenders, err := slowlog.Summarize(ctx, db, time.Now().AddDate(0, 0, -days), 20)
+	if err != nil {
+		return err
+	}
+	if len(offenders) == 0 {
+		color.Yellow("No slow queries recorded in the last %d days", days)
+		if os.Getenv("DB_SLOW_QUERY_THRESHOLD") == "" {
+			fmt.Println("Set DB_SLOW_QUERY_THRESHOLD, e.g. 500ms, to record them")
+		}
+		return nil
+	}
+	for i, o := range offenders {
+		color.Yellow("\n%d. %s: %d runs, %.1fs in all, %.0f ms at worst", i+1, o.Fingerprint, o.Runs, o.TotalMs/1000, o.MaxMs)
+		fmt.Println(strings.TrimSpace(o.Statement))
+		if len(o.Params) > 0 {
+			fmt.Printf("Parameters: %s\n", strings.Join(o.Params, ", "))
+		}
+		for _, a := range o.Advice {
+			fmt.Printf("  - %s\n", a)
+		}
+	}
+	fmt.Println()
+	showResult("slow-queries", slowQueryHeader, slowQueryRows(offenders), true)
+	return nil
+}
+
+// runSlowQueries is the scriptable form of the slow query report
+func runSlowQueries(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("slow-queries")
+	days := fs.Int("days", 7, "summarise the slow queries of this many days")
+	limit := fs.Int("limit", 20, "number of queries to show")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return usageError{errors.New("slow-queries takes no arguments")}
+	}
+	if *days <= 0 || *limit <= 0 {
+		return usageError{errors.New("-days and -limit must be positive")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	offenders, err := slowlog.Summarize(ctx, db, time.Now().AddDate(0, 0, -*days), *limit)
+	if err != nil {
+		return err
+	}
+	return writeResult("slow-queries", slowQueryHeader, slowQueryRows(offenders), *format, *output)
+}
+
+// slowQueryReport is the weekly advisory report: SCHEDULE_SLOW_QUERY_REPORT
+// is its cron schedule, e.g. @weekly, and SLOW_QUERY_REPORT_EMAIL a comma
+// separated list of addresses to send it to. It covers the days since the
+// schedule's previous run and is saved to the scheduled reports directory.
+type slowQueryReport struct {
+	schedule *scheduler.Cron
+	email    []string
+	mail     *delivery.MailConfig
+}
+
+// slowQueryReportFromEnv returns nil when the report is not scheduled
+func slowQueryReportFromEnv() (*slowQueryReport, error) {
+	raw := os.Getenv("SCHEDULE_SLOW_QUERY_REPORT")
+	if raw == "" {
+		if os.Getenv("SLOW_QUERY_REPORT_EMAIL") != "" {
+			return nil, errors.New("SLOW_QUERY_REPORT_EMAIL is set but SCHEDULE_SLOW_QUERY_REPORT is not")
+		}
+		return nil, nil
+	}
+	schedule, err := scheduler.ParseCron(raw)
+	if err != nil {
+		return nil, fmt.Errorf("SCHEDULE_SLOW_QUERY_REPORT: %v", err)
+	}
+	r := &slowQueryReport{schedule: schedule}
+	for _, addr := range strings.Split(os.Getenv("SLOW_QUERY_REPORT_EMAIL"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			r.email = append(r.email, addr)
+		}
+	}
+	if r.mail, err = delivery.MailConfigFromEnv(); err != nil {
+		return nil, err
+	}
+	if len(r.email) > 0 && r.mail == nil {
+		return nil, errors.New("SLOW_QUERY_REPORT_EMAIL is set but SMTP_HOST is not")
+	}
+	return r, nil
+}
+
+// addSlowQueryReport registers the slow query report job, when scheduled
+func addSlowQueryReport(s *scheduler.Scheduler, db *sql.DB, catchUp bool) error {
+	r, err := slowQueryReportFromEnv()
+	if err != nil || r == nil {
+		return err
+	}
+	return s.Add(scheduler.Job{
+		Name:     "slow-query-report",
+		Schedule: r.schedule,
+		CatchUp:  catchUp,
+		Timeout:  5 * time.Minute,
+		Run: func(ctx context.Context) error {
+			return r.run(ctx, db)
+		},
+	})
+}
+
+// run saves the report and emails it to the recipients, if any
+func (r *slowQueryReport) run(ctx context.Context, db *sql.DB) error {
+	// The report covers the time between two runs: a week for @weekly
+	now := time.Now()
+	next := r.schedule.Next(now)
+	since := now.Add(-r.schedule.Next(next).Sub(next))
+	offenders, err := slowlog.Summarize(ctx, db, since, 20)
+	if err != nil {
+		return err
+	}
+	path, err := resultOutput{format: "csv", dir: defaultReportDir}.save("slow-queries", slowQueryHeader, slowQueryRows(offenders))
+	if err != nil {
+		return err
+	}
+	if len(r.email) == 0 {
+		return nil
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Queries slower than DB_SLOW_QUERY_THRESHOLD since %s, the most time in all first.\n\n", since.Format("2 Jan 2006 15:04"))
+	if len(offenders) == 0 {
+		body.WriteString("None were recorded.\n")
+	}
+	for i, o := range offenders {
+		fmt.Fprintf(&body, "%d. %s: %d runs, %.1fs in all, p95 %.0f ms, worst %.0f ms\n", i+1, o.Fingerprint, o.Runs, o.TotalMs/1000, o.P95Ms, o.MaxMs)
+		fmt.Fprintf(&body, "   %s\n", strings.Join(strings.Fields(o.Statement), " "))
+		for _, a := range o.Advice {
+			fmt.Fprintf(&body, "   - %s\n", a)
+		}
+		body.WriteString("\n")
+	}
+	body.WriteString("The full list is attached.\n")
+	subject := fmt.Sprintf("Slow queries to %s", now.Format("2 Jan 2006"))
+	if err := r.mail.SendFile(ctx, r.email, subject, body.String(), path); err != nil {
+		return fmt.Errorf("report saved to %s but not emailed: %w", path, err)
+	}
+	return nil
+}
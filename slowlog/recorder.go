@@ -0,0 +1,226 @@
+// Package slowlog records application queries that run longer than a
+// threshold into the slow_queries table, and summarises the worst of them
+// with advice on what to look at.
+package slowlog
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+)
+
+// marker tags the recorder's own statements, so they are never recorded
+const marker = "/* slowlog */"
+
+const (
+	// queueSize bounds the slow queries waiting to be written; more are
+	// dropped rather than slow the application down
+	queueSize = 256
+	// maxParamLength truncates long parameter values
+	maxParamLength = 100
+	// redacted stands in for a parameter that is not recorded
+	redacted = "<redacted>"
+	// explainTimeout bounds the EXPLAIN run for each slow query
+	explainTimeout = 5 * time.Second
+)
+
+// Entry is one slow query
+type Entry struct {
+	Statement    string
+	Params       []string
+	Duration     time.Duration
+	RowsAffected int64
+	Err          string
+	RecordedAt   time.Time
+	// args are the parameter values as given, to explain the statement with
+	args []any
+}
+
+// Recorder is a pgx query tracer that queues the queries slower than its
+// threshold and writes them, with their plans, to slow_queries. A query's
+// time runs until its rows have been read. Text parameters, such as names
+// and registration numbers, are redacted unless SetRecordText is called.
+type Recorder struct {
+	threshold  time.Duration
+	source     string
+	recordText bool
+	queue      chan Entry
+	wg         sync.WaitGroup
+	once       sync.Once
+}
+
+// NewRecorder records queries slower than threshold; source names the
+// command they came from, e.g. serve
+func NewRecorder(threshold time.Duration, source string) *Recorder {
+	return &Recorder{threshold: threshold, source: source, queue: make(chan Entry, queueSize)}
+}
+
+// SetRecordText records text parameters, truncated, and the literals in
+// plans instead of redacting them. Call it before the recorder is in use.
+func (r *Recorder) SetRecordText(record bool) {
+	r.recordText = record
+}
+
+type traceKey struct{}
+
+type traceStart struct {
+	sql   string
+	args  []any
+	start time.Time
+}
+
+// TraceQueryStart implements pgx.QueryTracer
+func (r *Recorder) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if strings.Contains(data.SQL, marker) {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey{}, &traceStart{sql: data.SQL, args: data.Args, start: time.Now()})
+}
+
+// TraceQueryEnd implements pgx.QueryTracer
+func (r *Recorder) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(traceKey{}).(*traceStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.start)
+	if elapsed < r.threshold {
+		return
+	}
+	e := Entry{
+		Statement:    strings.TrimSpace(start.sql),
+		Params:       formatParams(start.args, r.recordText),
+		Duration:     elapsed,
+		RowsAffected: data.CommandTag.RowsAffected(),
+		RecordedAt:   time.Now(),
+		args:         start.args,
+	}
+	if data.Err != nil {
+		e.Err = data.Err.Error()
+	}
+	select {
+	case r.queue <- e:
+	default:
+		// The writer is behind; losing an entry beats blocking a query
+	}
+}
+
+// formatParams renders args for slow_queries. Numbers, booleans and times
+// are kept; anything else may be personal data and is redacted unless
+// recordText is set.
+func formatParams(args []any, recordText bool) []string {
+	params := make([]string, len(args))
+	for i, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case nil:
+			s = "NULL"
+		case []byte:
+			s = fmt.Sprintf("<%d bytes>", len(v))
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Time:
+			s = fmt.Sprint(v)
+		default:
+			if !recordText {
+				s = redacted
+			} else {
+				s = fmt.Sprint(v)
+			}
+		}
+		if len(s) > maxParamLength {
+			s = s[:maxParamLength] + "..."
+		}
+		params[i] = s
+	}
+	return params
+}
+
+// Start writes queued slow queries to db until Close
+func (r *Recorder) Start(db *sql.DB) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for e := range r.queue {
+			if err := r.write(db, e); err != nil {
+				log.Printf("Warning: error recording slow query: %v", err)
+			}
+		}
+	}()
+}
+
+// Close writes the queries still queued and stops the writer
+func (r *Recorder) Close() {
+	r.once.Do(func() { close(r.queue) })
+	r.wg.Wait()
+}
+
+func (r *Recorder) write(db *sql.DB, e Entry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*explainTimeout)
+	defer cancel()
+	plan := explainPlan(ctx, db, e)
+	if plan != nil && !r.recordText {
+		// The plan is made with the parameters, so their values appear in
+		// its conditions as literals
+		plan = planLiterals.ReplaceAllString(plan.(string), "'"+redacted+"'")
+	}
+	_, err := db.ExecContext(ctx, marker+`
+        INSERT INTO slow_queries (fingerprint, statement, params, duration_ms, rows_affected, error, plan, source, recorded_at)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''), $9)`,
+		Fingerprint(e.Statement), e.Statement, pq.Array(e.Params),
+		float64(e.Duration.Microseconds())/1000, e.RowsAffected, e.Err, plan, r.source, e.RecordedAt)
+	return err
+}
+
+var planLiterals = regexp.MustCompile(`'(?:[^']|'')*'`)
+
+var explainable = regexp.MustCompile(`(?i)^\s*(?:/\*.*?\*/\s*)*(select|with|insert|update|delete|values)\b`)
+
+// explainPlan returns the statement's plan as EXPLAIN (FORMAT JSON) gives
+// it, without running the statement again, or nil when it can't be
+// explained, e.g. it reads a temporary table of another session
+func explainPlan(ctx context.Context, db *sql.DB, e Entry) interface{} {
+	if !explainable.MatchString(e.Statement) {
+		return nil
+	}
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("%s SET LOCAL statement_timeout = %d", marker, explainTimeout.Milliseconds())); err != nil {
+		return nil
+	}
+	var plan string
+	if err := tx.QueryRowContext(ctx, marker+" EXPLAIN (FORMAT JSON) "+e.Statement, e.args...).Scan(&plan); err != nil {
+		return nil
+	}
+	return plan
+}
+
+var (
+	fingerprintComments = regexp.MustCompile(`(?s)/\*.*?\*/|--[^\n]*`)
+	fingerprintStrings  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	fingerprintNumbers  = regexp.MustCompile(`(^|[^$\w.])-?\d+(?:\.\d+)?`)
+	fingerprintLists    = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)+\s*\)`)
+)
+
+// Fingerprint identifies a statement whatever its literal values and
+// spacing, so runs of the same query are summarised together
+func Fingerprint(statement string) string {
+	s := fingerprintComments.ReplaceAllString(statement, " ")
+	s = fingerprintStrings.ReplaceAllString(s, "?")
+	s = fingerprintNumbers.ReplaceAllString(s, "${1}?")
+	s = fingerprintLists.ReplaceAllString(s, "(?...)")
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,63 @@
+package slowlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatParams(t *testing.T) {
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	args := []any{nil, 2023, int64(-5), 249.5, true, at, []byte{1, 2, 3}, "ADEBAYO", strings.Repeat("x", 120)}
+	tests := []struct {
+		recordText bool
+		want       []string
+	}{
+		{false, []string{"NULL", "2023", "-5", "249.5", "true", at.String(), "<3 bytes>",
+			redacted, redacted}},
+		{true, []string{"NULL", "2023", "-5", "249.5", "true", at.String(), "<3 bytes>",
+			"ADEBAYO", strings.Repeat("x", maxParamLength) + "..."}},
+	}
+	for _, tt := range tests {
+		got := formatParams(args, tt.recordText)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("formatParams(recordText=%v) = %q, want %q", tt.recordText, got, tt.want)
+		}
+	}
+}
+
+func TestPlanLiterals(t *testing.T) {
+	plan := `[{"Plan": {"Filter": "((surname)::text = 'O''BRIEN'::text)", "Index Cond": "(year = 2023)"}}]`
+	want := `[{"Plan": {"Filter": "((surname)::text = '<redacted>'::text)", "Index Cond": "(year = 2023)"}}]`
+	if got := planLiterals.ReplaceAllString(plan, "'"+redacted+"'"); got != want {
+		t.Errorf("redacted plan = %s, want %s", got, want)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"SELECT * FROM candidate WHERE year = 2023", "select *\n  from candidate\twhere year = 2019", true},
+		{"SELECT * FROM candidate WHERE surname = 'Ade'", "SELECT * FROM candidate WHERE surname = 'O''Brien'", true},
+		{"SELECT * FROM candidate WHERE aggregate > 250.5", "SELECT * FROM candidate WHERE aggregate > -3", true},
+		{"SELECT * FROM candidate WHERE statecode IN (1, 2, 3)", "SELECT * FROM candidate WHERE statecode IN (25)", false},
+		{"SELECT * FROM candidate WHERE statecode IN (1, 2, 3)", "SELECT * FROM candidate WHERE statecode IN (4,5)", true},
+		{"/* slowlog */ SELECT 1 -- check", "SELECT 2", true},
+		{"SELECT * FROM candidate WHERE year = $1", "SELECT * FROM candidate WHERE year = $2", false},
+		{"SELECT * FROM candidate2023", "SELECT * FROM candidate2019", false},
+		{"SELECT * FROM candidate WHERE year = 2023", "SELECT * FROM candidate WHERE aggregate = 2023", false},
+		{"SELECT aggregate FROM candidate", "SELECT aggregate FROM candidate_scores", false},
+	}
+	for _, tt := range tests {
+		a, b := Fingerprint(tt.a), Fingerprint(tt.b)
+		if len(a) != 16 {
+			t.Errorf("Fingerprint(%q) = %q, want 16 hex digits", tt.a, a)
+		}
+		if (a == b) != tt.same {
+			t.Errorf("Fingerprint(%q) = %s, Fingerprint(%q) = %s, same = %v, want %v", tt.a, a, tt.b, b, a == b, tt.same)
+		}
+	}
+}
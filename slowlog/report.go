@@ -0,0 +1,145 @@
+package slowlog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nonsonwune/spk2_db/explain"
+)
+
+const (
+	// largeScanCost is the estimated cost from which a sequential scan is
+	// worth an index; about a thousand 8kB pages
+	largeScanCost = 1000
+	// frequentRuns is how often a slow query has to run in the period for
+	// caching it to be suggested
+	frequentRuns = 100
+)
+
+// Offender is one query, by fingerprint, and how slow it was over the period
+type Offender struct {
+	Fingerprint string
+	Runs        int
+	TotalMs     float64
+	AvgMs       float64
+	P95Ms       float64
+	MaxMs       float64
+	Errors      int
+	LastSeen    time.Time
+	Sources     string
+	// Statement, Params and Plan are from its slowest run with a plan
+	Statement string
+	Params    []string
+	Plan      *explain.Plan
+	// Error is the last error one of its runs failed with
+	Error string
+	// Advice suggests what to look at first
+	Advice []string
+}
+
+// summarySQL groups the slow queries since $1 by fingerprint, the ones that
+// took the most time in all first, and keeps the slowest run of each
+const summarySQL = marker + `
+    WITH recent AS (
+        SELECT fingerprint, statement, params, duration_ms, error, plan, source, recorded_at
+        FROM slow_queries
+        WHERE recorded_at >= $1
+    ), grouped AS (
+        SELECT fingerprint,
+               COUNT(*) AS runs,
+               SUM(duration_ms) AS total_ms,
+               AVG(duration_ms) AS avg_ms,
+               PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms) AS p95_ms,
+               MAX(duration_ms) AS max_ms,
+               COUNT(error) AS errors,
+               MAX(recorded_at) AS last_seen,
+               STRING_AGG(DISTINCT COALESCE(source, ''), ', ') AS sources
+        FROM recent
+        GROUP BY fingerprint
+    )
+    SELECT g.fingerprint, g.runs, g.total_ms, g.avg_ms, g.p95_ms, g.max_ms, g.errors, g.last_seen, g.sources,
+           w.statement, w.params, COALESCE(w.plan::text, ''),
+           COALESCE((SELECT error FROM recent e
+                     WHERE e.fingerprint = g.fingerprint AND e.error IS NOT NULL
+                     ORDER BY e.recorded_at DESC LIMIT 1), '')
+    FROM grouped g
+    CROSS JOIN LATERAL (
+        SELECT statement, params, plan
+        FROM recent r
+        WHERE r.fingerprint = g.fingerprint
+        ORDER BY r.plan IS NULL, r.duration_ms DESC
+        LIMIT 1
+    ) w
+    ORDER BY g.total_ms DESC
+    LIMIT $2`
+
+// Summarize returns the limit queries that spent the most time being slow
+// since the given time, with advice for each
+func Summarize(ctx context.Context, db *sql.DB, since time.Time, limit int) ([]Offender, error) {
+	rows, err := db.QueryContext(ctx, summarySQL, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error summarising slow queries: %w", err)
+	}
+	defer rows.Close()
+
+	var offenders []Offender
+	for rows.Next() {
+		var o Offender
+		var plan string
+		if err := rows.Scan(&o.Fingerprint, &o.Runs, &o.TotalMs, &o.AvgMs, &o.P95Ms, &o.MaxMs, &o.Errors,
+			&o.LastSeen, &o.Sources, &o.Statement, pq.Array(&o.Params), &plan, &o.Error); err != nil {
+			return nil, fmt.Errorf("error reading slow queries: %w", err)
+		}
+		if plan != "" {
+			// A plan that doesn't parse is left out rather than fail the report
+			o.Plan, _ = explain.Parse([]byte(plan))
+		}
+		o.Advice = advise(&o)
+		offenders = append(offenders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading slow queries: %w", err)
+	}
+	return offenders, nil
+}
+
+// advise reads the offender's plan and runs for the usual causes of a slow
+// query. The plan is an estimate taken after the run, so the advice is a
+// place to start rather than a diagnosis.
+func advise(o *Offender) []string {
+	var advice []string
+	if o.Errors > 0 {
+		advice = append(advice, fmt.Sprintf("%d of %d runs failed, last with: %s", o.Errors, o.Runs, o.Error))
+	}
+	if o.Plan != nil {
+		o.Plan.Walk(func(n *explain.Node, depth int) {
+			switch {
+			case n.NodeType == "Seq Scan" && n.Filter != "" && n.TotalCost >= largeScanCost:
+				advice = append(advice, fmt.Sprintf("scans all of %s to filter on %s; an index on those columns may help",
+					n.RelationName, n.Filter))
+			case n.NodeType == "Nested Loop" && len(n.Plans) == 2 && n.Plans[1].NodeType == "Seq Scan" &&
+				n.Plans[0].PlanRows*n.Plans[1].TotalCost >= largeScanCost:
+				advice = append(advice, fmt.Sprintf("rescans %s for every row of the join; index its join columns",
+					n.Plans[1].RelationName))
+			case n.NodeType == "Sort" && n.TotalCost >= largeScanCost:
+				advice = append(advice, fmt.Sprintf("sorts on %s; an index in that order, or a LIMIT, may avoid it",
+					strings.Join(n.SortKey, ", ")))
+			}
+		})
+	}
+	if o.Runs >= frequentRuns {
+		advice = append(advice, fmt.Sprintf("ran slowly %d times; caching its result or a summary table would spare the database", o.Runs))
+	}
+	if len(advice) == 0 {
+		if o.Plan == nil {
+			advice = append(advice, "no plan could be taken for it; look at the statement and its parameters")
+		} else {
+			advice = append(advice, "the plan looks sound; check for lock waits or load when it ran")
+		}
+	}
+	return advice
+}
@@ -0,0 +1,143 @@
+package spatial
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Boundary levels
+const (
+	LevelState = "state"
+	LevelLGA   = "lga"
+)
+
+type featureCollection struct {
+	Features []struct {
+		Properties map[string]interface{} `json:"properties"`
+		Geometry   json.RawMessage        `json:"geometry"`
+	} `json:"features"`
+}
+
+// LoadSummary reports the outcome of loading a boundary file
+type LoadSummary struct {
+	Loaded    int
+	Unmatched []string
+}
+
+// LoadBoundaries reads a GeoJSON FeatureCollection in WGS84 and stores each
+// feature's polygon against the state or LGA it describes. key names the
+// feature property identifying the area: numeric values are taken as the
+// st_id/lg_id, anything else is matched against the area name. LGA names
+// shared by several states are only matched when stateKey names a property
+// holding the state name.
+func LoadBoundaries(ctx context.Context, db *sql.DB, level string, r io.Reader, key, stateKey string) (*LoadSummary, error) {
+	var table, idColumn string
+	switch level {
+	case LevelState:
+		table, idColumn = "state_boundaries", "st_id"
+	case LevelLGA:
+		table, idColumn = "lga_boundaries", "lg_id"
+	default:
+		return nil, fmt.Errorf("unknown boundary level %q", level)
+	}
+
+	var fc featureCollection
+	if err := json.NewDecoder(r).Decode(&fc); err != nil {
+		return nil, fmt.Errorf("error reading GeoJSON: %w", err)
+	}
+	if len(fc.Features) == 0 {
+		return nil, fmt.Errorf("no features found; expected a GeoJSON FeatureCollection")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insert := fmt.Sprintf(`
+        INSERT INTO %s (%s, geom)
+        VALUES ($1, ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($2), 4326)))
+        ON CONFLICT (%[2]s) DO UPDATE SET geom = EXCLUDED.geom, loaded_at = NOW()`, table, idColumn)
+
+	summary := &LoadSummary{}
+	for _, f := range fc.Features {
+		value := propertyString(f.Properties[key])
+		id, err := resolveArea(ctx, tx, level, value, propertyString(f.Properties[stateKey]))
+		if err != nil {
+			return nil, err
+		}
+		if id == 0 || len(f.Geometry) == 0 || string(f.Geometry) == "null" {
+			summary.Unmatched = append(summary.Unmatched, value)
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, insert, id, string(f.Geometry)); err != nil {
+			return nil, fmt.Errorf("error storing boundary for %s: %w", value, err)
+		}
+		summary.Loaded++
+	}
+	return summary, tx.Commit()
+}
+
+// resolveArea returns the st_id/lg_id for a boundary feature, or 0 when it
+// matches no area or more than one
+func resolveArea(ctx context.Context, tx *sql.Tx, level, value, state string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+
+	var rows *sql.Rows
+	var err error
+	switch {
+	case level == LevelState:
+		rows, err = tx.QueryContext(ctx,
+			"SELECT st_id FROM state WHERE UPPER(TRIM(st_name)) = UPPER(TRIM($1))", value)
+	case state != "":
+		rows, err = tx.QueryContext(ctx, `
+            SELECT l.lg_id FROM lga l
+            JOIN state s ON s.st_id = l.lg_st_id
+            WHERE UPPER(TRIM(l.lg_name)) = UPPER(TRIM($1))
+              AND UPPER(TRIM(s.st_name)) = UPPER(TRIM($2))`, value, state)
+	default:
+		rows, err = tx.QueryContext(ctx,
+			"SELECT lg_id FROM lga WHERE UPPER(TRIM(lg_name)) = UPPER(TRIM($1))", value)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error matching %s: %w", value, err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 1 {
+		return 0, rows.Err()
+	}
+	return ids[0], rows.Err()
+}
+
+func propertyString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
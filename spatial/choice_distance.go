@@ -0,0 +1,76 @@
+package spatial
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// DistanceBands are the upper bounds in km of the home-to-institution
+// distance bands, after the same-state band; the last band is open-ended
+var DistanceBands = []int{250, 500}
+
+// ChoiceDistanceRow is the distance distribution for one score band and gender
+type ChoiceDistanceRow struct {
+	ScoreBand  string
+	Gender     string
+	Candidates int
+	SameState  int
+	// Bands counts out-of-state choices per DistanceBands entry, plus one
+	// final count for choices beyond the last bound
+	Bands    []int
+	MedianKm float64
+}
+
+// ChoiceDistances measures how far candidates apply from home as the
+// distance between the centroids of their state of origin and the state of
+// their chosen institution, segmented by aggregate score band and gender.
+// Candidates whose states have no boundary loaded are left out.
+func ChoiceDistances(ctx context.Context, db *sql.DB, source string) ([]ChoiceDistanceRow, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+        WITH centroids AS (
+            SELECT st_id, ST_Centroid(geom)::geography AS centre FROM state_boundaries
+        ),
+        choices AS (
+            SELECT CASE
+                       WHEN c.aggregate >= 300 THEN '300+'
+                       WHEN c.aggregate >= 250 THEN '250-299'
+                       WHEN c.aggregate >= 200 THEN '200-249'
+                       WHEN c.aggregate >= 150 THEN '150-199'
+                       ELSE 'Below 150'
+                   END AS score_band,
+                   COALESCE(c.gender, 'Unknown') AS gender,
+                   c.statecode = i.inst_state_id AS same_state,
+                   ST_Distance(home.centre, inst.centre) / 1000 AS km
+            FROM %s c
+            JOIN institution i ON i.inid = c.inid
+            JOIN centroids home ON home.st_id = c.statecode
+            JOIN centroids inst ON inst.st_id = i.inst_state_id
+        )
+        SELECT score_band, gender, COUNT(*),
+               COUNT(*) FILTER (WHERE same_state),
+               COUNT(*) FILTER (WHERE NOT same_state AND km < $1),
+               COUNT(*) FILTER (WHERE NOT same_state AND km >= $1 AND km < $2),
+               COUNT(*) FILTER (WHERE NOT same_state AND km >= $2),
+               percentile_cont(0.5) WITHIN GROUP (ORDER BY km)
+        FROM choices
+        GROUP BY score_band, gender
+        ORDER BY MIN(CASE score_band WHEN '300+' THEN 1 WHEN '250-299' THEN 2
+                      WHEN '200-249' THEN 3 WHEN '150-199' THEN 4 ELSE 5 END), gender`, source),
+		DistanceBands[0], DistanceBands[1])
+	if err != nil {
+		return nil, fmt.Errorf("error calculating choice distances: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ChoiceDistanceRow
+	for rows.Next() {
+		r := ChoiceDistanceRow{Bands: make([]int, len(DistanceBands)+1)}
+		if err := rows.Scan(&r.ScoreBand, &r.Gender, &r.Candidates, &r.SameState,
+			&r.Bands[0], &r.Bands[1], &r.Bands[2], &r.MedianKm); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
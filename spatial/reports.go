@@ -0,0 +1,109 @@
+package spatial
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Distance report groupings
+const (
+	ByState       = "state"
+	ByLGA         = "lga"
+	ByInstitution = "institution"
+)
+
+// DistanceRow summarises travel distances for one group of candidates
+type DistanceRow struct {
+	Label      string
+	Candidates int
+	AverageKm  float64
+	MedianKm   float64
+}
+
+// TravelDistances reports how far candidates in source would travel from
+// the centroid of their LGA to their chosen institution, grouped by state
+// of origin, LGA or institution. Candidates whose LGA has no boundary or
+// whose institution has no location are left out.
+func TravelDistances(ctx context.Context, db *sql.DB, source, groupBy string, limit int) ([]DistanceRow, error) {
+	var label string
+	switch groupBy {
+	case ByState:
+		label = "s.st_name"
+	case ByLGA:
+		label = "l.lg_name || ', ' || s.st_name"
+	case ByInstitution:
+		label = "i.inname"
+	default:
+		return nil, fmt.Errorf("unknown grouping %q", groupBy)
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+        WITH distances AS (
+            SELECT %s AS label,
+                   ST_Distance(ST_Centroid(lb.geom)::geography, il.geom::geography) / 1000 AS km
+            FROM %s c
+            JOIN lga_boundaries lb ON lb.lg_id = c.lg_id
+            JOIN institution_locations il ON il.inid = c.inid
+            JOIN lga l ON l.lg_id = c.lg_id
+            JOIN state s ON s.st_id = l.lg_st_id
+            JOIN institution i ON i.inid = c.inid
+        )
+        SELECT label, COUNT(*), AVG(km),
+               percentile_cont(0.5) WITHIN GROUP (ORDER BY km)
+        FROM distances
+        GROUP BY label
+        ORDER BY AVG(km) DESC
+        LIMIT $1`, label, source), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error calculating travel distances: %w", err)
+	}
+	defer rows.Close()
+
+	var result []DistanceRow
+	for rows.Next() {
+		var r DistanceRow
+		if err := rows.Scan(&r.Label, &r.Candidates, &r.AverageKm, &r.MedianKm); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// MismatchRow counts geocoded candidates per declared state whose address
+// falls outside the LGA they declared
+type MismatchRow struct {
+	State      string
+	Located    int
+	OutsideLGA int
+}
+
+// LGAMismatches compares geocoded addresses against declared LGAs, which
+// flags both geocoding misses and questionable LGA declarations
+func LGAMismatches(ctx context.Context, db *sql.DB, source string) ([]MismatchRow, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT s.st_name, COUNT(*),
+               COUNT(*) FILTER (WHERE NOT ST_Contains(lb.geom, cl.geom))
+        FROM %s c
+        JOIN candidate_locations cl ON cl.regnumber = c.regnumber AND cl.source = 'address'
+        JOIN lga_boundaries lb ON lb.lg_id = c.lg_id
+        JOIN lga l ON l.lg_id = c.lg_id
+        JOIN state s ON s.st_id = l.lg_st_id
+        GROUP BY s.st_name
+        ORDER BY 3 DESC`, source))
+	if err != nil {
+		return nil, fmt.Errorf("error comparing locations with LGAs: %w", err)
+	}
+	defer rows.Close()
+
+	var result []MismatchRow
+	for rows.Next() {
+		var r MismatchRow
+		if err := rows.Scan(&r.State, &r.Located, &r.OutsideLGA); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,121 @@
+// Package spatial adds optional PostGIS support: administrative boundaries,
+// point geometries for geocoded candidates and institutions, and distance
+// reports built on them. Everything here requires the postgis extension.
+package spatial
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/nonsonwune/spk2_db/geocode"
+	"github.com/nonsonwune/spk2_db/migrations"
+)
+
+// Available reports whether the postgis extension is installed in the database
+func Available(ctx context.Context, db *sql.DB) (bool, error) {
+	var installed bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'postgis')").Scan(&installed)
+	if err != nil {
+		return false, fmt.Errorf("error checking for PostGIS: %w", err)
+	}
+	return installed, nil
+}
+
+// spatialMigration creates the spatial tables when PostGIS is installed
+const spatialMigration = "create_spatial_tables"
+
+// Enable installs the postgis extension if the server provides it and runs
+// the spatial tables migration again, which did nothing while PostGIS was
+// missing. Installing needs superuser or database owner rights; when that
+// fails the caller can ask a DBA to run CREATE EXTENSION.
+func Enable(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS postgis"); err != nil {
+		return fmt.Errorf("error installing PostGIS (is it available on the server?): %w", err)
+	}
+	all, err := migrations.All()
+	if err != nil {
+		return err
+	}
+	for _, m := range all {
+		if m.Name == spatialMigration {
+			if _, err := db.ExecContext(ctx, m.Up); err != nil {
+				return fmt.Errorf("error creating spatial tables: %w", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("migration %s is missing", spatialMigration)
+}
+
+// Ready reports whether the spatial tables exist. They are missing when
+// PostGIS was installed after the migrations ran, until Enable is run.
+func Ready(ctx context.Context, db *sql.DB) (bool, error) {
+	var ready bool
+	err := db.QueryRowContext(ctx, "SELECT to_regclass('state_boundaries') IS NOT NULL").Scan(&ready)
+	if err != nil {
+		return false, fmt.Errorf("error checking for the spatial tables: %w", err)
+	}
+	return ready, nil
+}
+
+// LocateInstitutions geocodes up to limit institutions without a location
+// by name, so distance reports have a destination for each choice
+func LocateInstitutions(ctx context.Context, db *sql.DB, geocoder geocode.Geocoder, limit int) (located, missed int, err error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT i.inid, i.inname
+        FROM institution i
+        LEFT JOIN institution_locations l ON l.inid = i.inid
+        WHERE l.inid IS NULL
+        ORDER BY i.inid
+        LIMIT $1`, limit)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error selecting institutions to locate: %w", err)
+	}
+	type institution struct{ id, name string }
+	var pending []institution
+	for rows.Next() {
+		var inst institution
+		if err := rows.Scan(&inst.id, &inst.name); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		pending = append(pending, inst)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, inst := range pending {
+		point, err := geocoder.Geocode(ctx, inst.name+", Nigeria")
+		if err != nil {
+			if ctx.Err() != nil {
+				return located, missed, ctx.Err()
+			}
+			missed++
+			continue
+		}
+		if err := SetInstitutionLocation(ctx, db, inst.id, point, geocoder.Name()); err != nil {
+			return located, missed, err
+		}
+		located++
+	}
+	return located, missed, nil
+}
+
+// SetInstitutionLocation records where an institution is, replacing any
+// earlier location
+func SetInstitutionLocation(ctx context.Context, db *sql.DB, inid string, point geocode.Point, source string) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO institution_locations (inid, geom, source)
+        VALUES ($1, ST_SetSRID(ST_MakePoint($2, $3), 4326), $4)
+        ON CONFLICT (inid) DO UPDATE
+        SET geom = EXCLUDED.geom, source = EXCLUDED.source, updated_at = NOW()`,
+		inid, point.Lng, point.Lat, source)
+	if err != nil {
+		return fmt.Errorf("error saving location for institution %s: %w", inid, err)
+	}
+	return nil
+}
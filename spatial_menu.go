@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/geocode"
+	"github.com/nonsonwune/spk2_db/spatial"
+)
+
+// handleSpatialAnalysis runs the PostGIS-backed reports, offering to enable
+// PostGIS when it isn't installed yet
+func handleSpatialAnalysis(ctx context.Context, db *sql.DB) error {
+	available, err := spatial.Available(ctx, db)
+	if err != nil {
+		return err
+	}
+	if !available {
+		color.Yellow("PostGIS is not installed in this database.")
+		fmt.Print("Try to enable it now? (y/n): ")
+		if readString() != "y" {
+			return nil
+		}
+		if err := spatial.Enable(ctx, db); err != nil {
+			return err
+		}
+		color.Green("PostGIS enabled")
+	} else if ready, err := spatial.Ready(ctx, db); err != nil {
+		return err
+	} else if !ready {
+		// PostGIS was installed after the migrations ran
+		if err := spatial.Enable(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	color.Cyan("\nSpatial Analysis")
+	fmt.Println("1. Load state or LGA boundaries (GeoJSON)")
+	fmt.Println("2. Locate institutions")
+	fmt.Println("3. Travel distance from LGA to chosen institution")
+	fmt.Println("4. Geocoded addresses outside declared LGA")
+	fmt.Println("5. How far candidates apply from home")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		return loadBoundaries(ctx, db)
+	case "2":
+		return locateInstitutions(ctx, db)
+	case "3":
+		return displayTravelDistances(ctx, db)
+	case "4":
+		return displayLGAMismatches(ctx, db)
+	case "5":
+		return displayChoiceDistances(ctx, db)
+	case "0":
+		return nil
+	default:
+		return fmt.Errorf("invalid choice")
+	}
+}
+
+func loadBoundaries(ctx context.Context, db *sql.DB) error {
+	fmt.Print("Boundary level (1) states or (2) LGAs: ")
+	level := spatial.LevelState
+	if readString() == "2" {
+		level = spatial.LevelLGA
+	}
+	fmt.Print("GeoJSON file: ")
+	path := readString()
+	fmt.Print("Property holding the area name or ID: ")
+	key := readString()
+	stateKey := ""
+	if level == spatial.LevelLGA {
+		fmt.Print("Property holding the state name (blank if none): ")
+		stateKey = readString()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	summary, err := spatial.LoadBoundaries(ctx, db, level, f, key, stateKey)
+	if err != nil {
+		return err
+	}
+	color.Green("Loaded %d %s boundaries", summary.Loaded, level)
+	if len(summary.Unmatched) > 0 {
+		color.Yellow("%d features did not match a single %s: %s", len(summary.Unmatched), level,
+			strings.Join(summary.Unmatched, ", "))
+	}
+	return nil
+}
+
+func locateInstitutions(ctx context.Context, db *sql.DB) error {
+	geocoder, err := geocode.FromEnv()
+	if err != nil {
+		return err
+	}
+	fmt.Print("Maximum institutions to look up (0 for 100): ")
+	limit := readInt()
+	if limit <= 0 {
+		limit = 100
+	}
+	located, missed, err := spatial.LocateInstitutions(ctx, db, geocoder, limit)
+	if err != nil {
+		return err
+	}
+	color.Green("Located %d institutions with %s; %d could not be found", located, geocoder.Name(), missed)
+	return nil
+}
+
+func displayTravelDistances(ctx context.Context, db *sql.DB) error {
+	fmt.Print("Group by (1) state of origin, (2) LGA or (3) institution: ")
+	groupBy, heading := spatial.ByState, "State"
+	switch readString() {
+	case "2":
+		groupBy, heading = spatial.ByLGA, "LGA"
+	case "3":
+		groupBy, heading = spatial.ByInstitution, "Institution"
+	}
+
+	rows, err := spatial.TravelDistances(ctx, db, currentSession.CandidateSource(), groupBy, 50)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		color.Yellow("No distances available; load LGA boundaries and locate institutions first")
+		return nil
+	}
+
+	table := newResultTable("travel-distances")
+	table.SetHeader([]string{heading, "Candidates", "Average km", "Median km"})
+	for _, r := range rows {
+		table.Append([]string{
+			r.Label,
+			fmt.Sprintf("%d", r.Candidates),
+			fmt.Sprintf("%.1f", r.AverageKm),
+			fmt.Sprintf("%.1f", r.MedianKm),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func displayLGAMismatches(ctx context.Context, db *sql.DB) error {
+	rows, err := spatial.LGAMismatches(ctx, db, currentSession.CandidateSource())
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		color.Yellow("No geocoded addresses with LGA boundaries to compare; run Geocoding and load LGA boundaries first")
+		return nil
+	}
+
+	table := newResultTable("lga-mismatches")
+	table.SetHeader([]string{"State", "Located", "Outside Declared LGA", "Share"})
+	for _, r := range rows {
+		table.Append([]string{
+			r.State,
+			fmt.Sprintf("%d", r.Located),
+			fmt.Sprintf("%d", r.OutsideLGA),
+			fmt.Sprintf("%.1f%%", float64(r.OutsideLGA)*100/float64(r.Located)),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func displayChoiceDistances(ctx context.Context, db *sql.DB) error {
+	rows, err := spatial.ChoiceDistances(ctx, db, currentSession.CandidateSource())
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		color.Yellow("No distances available; load state boundaries first")
+		return nil
+	}
+
+	header := []string{"Score Band", "Gender", "Candidates", "Same State"}
+	lower := 0
+	for _, upper := range spatial.DistanceBands {
+		header = append(header, fmt.Sprintf("%d-%d km", lower, upper))
+		lower = upper
+	}
+	header = append(header, fmt.Sprintf("%d+ km", lower), "Median km")
+
+	percent := func(n, total int) string {
+		return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+	}
+	table := newResultTable("choice-distances")
+	table.SetHeader(header)
+	for _, r := range rows {
+		line := []string{r.ScoreBand, r.Gender, fmt.Sprintf("%d", r.Candidates), percent(r.SameState, r.Candidates)}
+		for _, n := range r.Bands {
+			line = append(line, percent(n, r.Candidates))
+		}
+		table.Append(append(line, fmt.Sprintf("%.0f", r.MedianKm)))
+	}
+	table.Render()
+	color.Yellow("Distances are between state centroids; out-of-state bands exclude same-state choices")
+	return nil
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/sqllint"
+)
+
+const (
+	defaultConsoleTimeout  = 30 * time.Second
+	defaultConsoleRowLimit = 200
+	// The timeout and row limit can be raised up to these, so a console
+	// query cannot tie up the database or flood the terminal
+	maxConsoleTimeout  = 10 * time.Minute
+	maxConsoleRowLimit = 10000
+)
+
+// sqlConsole is an interactive SQL prompt for power users. Statements run in
+// read-only transactions unless write mode is explicitly enabled.
+type sqlConsole struct {
+	db       *sql.DB
+	schema   map[string][]string // table -> columns
+	readOnly bool
+	timeout  time.Duration
+	rowLimit int
+	format   string // table, csv or json
+	last     *consoleResult
+	rl       *readline.Instance
+}
+
+// consoleResult is the rows a statement returned, up to the row limit
+type consoleResult struct {
+	columns   []string
+	rows      [][]interface{}
+	truncated bool // more rows than the limit
+	elapsed   time.Duration
+}
+
+// consoleLimits reads SQL_CONSOLE_TIMEOUT (e.g. 1m) and SQL_CONSOLE_ROW_LIMIT,
+// the console's starting limits
+func consoleLimits() (time.Duration, int, error) {
+	timeout, rowLimit := defaultConsoleTimeout, defaultConsoleRowLimit
+	if raw := os.Getenv("SQL_CONSOLE_TIMEOUT"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || checkConsoleTimeout(d) != nil {
+			return 0, 0, fmt.Errorf("SQL_CONSOLE_TIMEOUT %q is not a duration between 1s and %v", raw, maxConsoleTimeout)
+		}
+		timeout = d
+	}
+	if raw := os.Getenv("SQL_CONSOLE_ROW_LIMIT"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || checkConsoleRowLimit(n) != nil {
+			return 0, 0, fmt.Errorf("SQL_CONSOLE_ROW_LIMIT %q is not a whole number between 1 and %d", raw, maxConsoleRowLimit)
+		}
+		rowLimit = n
+	}
+	return timeout, rowLimit, nil
+}
+
+func checkConsoleTimeout(d time.Duration) error {
+	if d < time.Second || d > maxConsoleTimeout {
+		return fmt.Errorf("the timeout must be between 1s and %v", maxConsoleTimeout)
+	}
+	return nil
+}
+
+func checkConsoleRowLimit(n int) error {
+	if n < 1 || n > maxConsoleRowLimit {
+		return fmt.Errorf("the row limit must be between 1 and %d", maxConsoleRowLimit)
+	}
+	return nil
+}
+
+// newSQLConsole starts read-only with the configured limits
+func newSQLConsole(db *sql.DB) (*sqlConsole, error) {
+	timeout, rowLimit, err := consoleLimits()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConsole{db: db, readOnly: true, timeout: timeout, rowLimit: rowLimit, format: "table"}, nil
+}
+
+func handleSQLConsole(ctx context.Context, db *sql.DB) error {
+	console, err := newSQLConsole(db)
+	if err != nil {
+		return err
+	}
+	return console.interact(ctx)
+}
+
+// interact reads statements and backslash commands until \q, starting
+// with the console's current limits
+func (c *sqlConsole) interact(ctx context.Context) error {
+	var err error
+	if c.schema, err = introspectSchema(ctx, c.db); err != nil {
+		return fmt.Errorf("error loading schema: %w", err)
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "sql> ",
+		HistoryFile:     consoleHistoryFile(),
+		AutoComplete:    &schemaCompleter{console: c},
+		InterruptPrompt: "^C",
+		EOFPrompt:       `\q`,
+		// Whole statements are saved, not the lines they were typed on
+		DisableAutoSaveHistory: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error starting console: %w", err)
+	}
+	defer rl.Close()
+	c.rl = rl
+
+	color.Cyan("\nSQL Console (read-only, %v timeout, %d row limit)", c.timeout, c.rowLimit)
+	fmt.Println(`End statements with ';'. Type \help for commands, \q to return to menu.`)
+
+	var buf strings.Builder
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			buf.Reset()
+			rl.SetPrompt("sql> ")
+			continue
+		}
+		if err != nil {
+			return nil
+		}
+
+		line = strings.TrimSpace(line)
+		if buf.Len() == 0 && strings.HasPrefix(line, `\`) {
+			rl.SaveHistory(line)
+			if quit := c.runCommand(line); quit {
+				return nil
+			}
+			continue
+		}
+
+		if line == "" {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if !strings.HasSuffix(line, ";") {
+			rl.SetPrompt("  -> ")
+			continue
+		}
+
+		statement := strings.TrimSpace(buf.String())
+		buf.Reset()
+		rl.SetPrompt("sql> ")
+		rl.SaveHistory(strings.ReplaceAll(statement, "\n", " "))
+
+		if err := c.execute(ctx, statement); err != nil {
+			color.Red("Error: %v", err)
+		}
+	}
+}
+
+func consoleHistoryFile() string {
+	if path := os.Getenv("SQL_CONSOLE_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".spk2_sql_history")
+}
+
+// runCommand handles backslash commands and reports whether to leave the console
+func (c *sqlConsole) runCommand(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case `\q`, `\quit`:
+		return true
+	case `\help`, `\?`:
+		fmt.Println(`\tables            list tables`)
+		fmt.Println(`\d <table>         describe table columns`)
+		fmt.Println(`\timeout <seconds> set statement timeout`)
+		fmt.Println(`\limit <rows>      set maximum rows displayed`)
+		fmt.Println(`\write on|off      allow data-modifying statements`)
+		fmt.Println(`\format <format>   show results as table, csv or json`)
+		fmt.Println(`\export <file>     save the last result as .csv, .json or .xlsx`)
+		fmt.Println(`\history [n]       list the last n statements (default 20)`)
+		fmt.Println(`\q                 return to menu`)
+	case `\tables`:
+		for _, table := range c.tableNames() {
+			fmt.Println(table)
+		}
+	case `\d`:
+		if len(fields) < 2 {
+			color.Red(`usage: \d <table>`)
+			break
+		}
+		columns, ok := c.schema[strings.ToLower(fields[1])]
+		if !ok {
+			color.Red("unknown table: %s", fields[1])
+			break
+		}
+		for _, col := range columns {
+			fmt.Println(col)
+		}
+	case `\timeout`:
+		if seconds, err := strconv.Atoi(argOrEmpty(fields)); err == nil {
+			if err := checkConsoleTimeout(time.Duration(seconds) * time.Second); err != nil {
+				color.Red("%v", err)
+			} else {
+				c.timeout = time.Duration(seconds) * time.Second
+			}
+		}
+		fmt.Printf("Statement timeout: %v\n", c.timeout)
+	case `\limit`:
+		if rows, err := strconv.Atoi(argOrEmpty(fields)); err == nil {
+			if err := checkConsoleRowLimit(rows); err != nil {
+				color.Red("%v", err)
+			} else {
+				c.rowLimit = rows
+			}
+		}
+		fmt.Printf("Row limit: %d\n", c.rowLimit)
+	case `\format`:
+		switch format := strings.ToLower(argOrEmpty(fields)); format {
+		case "table", "csv", "json":
+			c.format = format
+		case "":
+		default:
+			color.Red("unknown format %s (use table, csv or json)", format)
+		}
+		fmt.Printf("Output format: %s\n", c.format)
+	case `\export`:
+		if len(fields) < 2 {
+			color.Red(`usage: \export <file.csv|file.json|file.xlsx>`)
+			break
+		}
+		if err := c.export(fields[1]); err != nil {
+			color.Red("%v", err)
+		}
+	case `\history`:
+		n := 20
+		if arg := argOrEmpty(fields); arg != "" {
+			if v, err := strconv.Atoi(arg); err == nil && v > 0 {
+				n = v
+			}
+		}
+		if err := printConsoleHistory(n); err != nil {
+			color.Red("%v", err)
+		}
+	case `\write`:
+		switch argOrEmpty(fields) {
+		case "on":
+			if c.confirm("Enable write mode? Statements may modify data. (y/n): ") {
+				c.readOnly = false
+			}
+		case "off":
+			c.readOnly = true
+		}
+		if c.readOnly {
+			color.Green("Read-only mode")
+		} else {
+			color.Red("WRITE mode: statements will be committed")
+		}
+	default:
+		color.Red(`unknown command %s (try \help)`, fields[0])
+	}
+	return false
+}
+
+// confirm asks a yes/no question through the console's line editor
+func (c *sqlConsole) confirm(question string) bool {
+	c.rl.SetPrompt(question)
+	defer c.rl.SetPrompt("sql> ")
+	answer, err := c.rl.Readline()
+	return err == nil && strings.EqualFold(strings.TrimSpace(answer), "y")
+}
+
+func argOrEmpty(fields []string) string {
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+// execute runs a statement and shows its rows in the console's format
+func (c *sqlConsole) execute(ctx context.Context, statement string) error {
+	result, err := c.query(ctx, statement)
+	if err != nil {
+		return err
+	}
+	c.last = result
+
+	if len(result.columns) > 0 {
+		switch c.format {
+		case "csv":
+			err = CSVRenderer{W: os.Stdout}.Render("sql-query", result.columns, result.rows)
+		case "json":
+			err = JSONRenderer{W: os.Stdout}.Render("sql-query", result.columns, result.rows)
+		default:
+			showResult("sql-query", result.columns, result.rows, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	elapsed := result.elapsed.Round(time.Millisecond)
+	if result.truncated {
+		color.Yellow("(showing first %d rows, %v; use \\limit to change)", len(result.rows), elapsed)
+	} else {
+		fmt.Printf("(%d rows, %v)\n", len(result.rows), elapsed)
+	}
+	return nil
+}
+
+// query runs a statement in its own transaction with the console's
+// timeout and read-only setting applied. Read-only queries are fetched
+// through a cursor, so the database stops after the row limit rather than
+// producing every row.
+func (c *sqlConsole) query(ctx context.Context, statement string) (*consoleResult, error) {
+	if c.readOnly {
+		// A second statement in the string would run outside the read-only
+		// transaction once the first had ended it
+		var err error
+		if statement, err = sqllint.Single(statement); err != nil {
+			return nil, err
+		}
+	}
+	stmtCtx, cancel := context.WithTimeout(ctx, c.timeout+5*time.Second)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(stmtCtx, &sql.TxOptions{ReadOnly: c.readOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(stmtCtx,
+		fmt.Sprintf("SET LOCAL statement_timeout = %d", c.timeout.Milliseconds())); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	statement = strings.TrimSuffix(strings.TrimSpace(statement), ";")
+	var rows *sql.Rows
+	if c.readOnly && cursorable(statement) {
+		if _, err = tx.ExecContext(stmtCtx, "DECLARE console_rows NO SCROLL CURSOR FOR "+statement); err == nil {
+			rows, err = tx.QueryContext(stmtCtx, fmt.Sprintf("FETCH FORWARD %d FROM console_rows", c.rowLimit+1))
+		}
+	} else {
+		rows, err = tx.QueryContext(stmtCtx, statement)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	result := &consoleResult{columns: columns}
+
+	for rows.Next() {
+		if len(result.rows) >= c.rowLimit {
+			result.truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.rows = append(result.rows, values)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !c.readOnly {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	result.elapsed = time.Since(start)
+	return result, nil
+}
+
+// cursorable reports whether a statement is a query a cursor can be
+// declared for; others, such as EXPLAIN or SHOW, run as they are
+func cursorable(statement string) bool {
+	fields := strings.Fields(strings.TrimLeft(statement, "( \t\n"))
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToLower(fields[0]) {
+	case "select", "with", "values", "table":
+		return true
+	}
+	return false
+}
+
+// export saves the last result, in the format its file extension names
+func (c *sqlConsole) export(path string) error {
+	if c.last == nil || len(c.last.columns) == 0 {
+		return fmt.Errorf("no result to export yet")
+	}
+	format, err := parseResultFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil || format == "table" {
+		return fmt.Errorf("export to a .csv, .json or .xlsx file")
+	}
+	if err := writeResult("sql-query", c.last.columns, c.last.rows, format, path); err != nil {
+		return err
+	}
+	if c.last.truncated {
+		color.Yellow("Only the first %d rows were saved; raise \\limit and rerun the statement for more", len(c.last.rows))
+	}
+	return nil
+}
+
+// printConsoleHistory lists the last n statements saved in the history file
+func printConsoleHistory(n int) error {
+	path := consoleHistoryFile()
+	if path == "" {
+		return fmt.Errorf("no history file (set SQL_CONSOLE_HISTORY)")
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		fmt.Println("No history yet")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading history: %w", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	first := max(len(lines)-n, 0)
+	for i := first; i < len(lines); i++ {
+		fmt.Printf("%4d  %s\n", i+1, lines[i])
+	}
+	return nil
+}
+
+func formatConsoleValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(val)
+	case time.Time:
+		return val.Format("2006-01-02 15:04:05")
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+func (c *sqlConsole) tableNames() []string {
+	names := make([]string, 0, len(c.schema))
+	for name := range c.schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// introspectSchema loads the public tables and their columns
+func introspectSchema(ctx context.Context, db *sql.DB) (map[string][]string, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT table_name, column_name
+        FROM information_schema.columns
+        WHERE table_schema = 'public'
+        ORDER BY table_name, ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schema := make(map[string][]string)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		schema[table] = append(schema[table], column)
+	}
+	return schema, rows.Err()
+}
+
+// schemaCompleter completes table names, column names and table.column
+// references from the introspected schema.
+type schemaCompleter struct {
+	console *sqlConsole
+}
+
+func (sc *schemaCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	start := pos
+	for start > 0 && isIdentRune(line[start-1]) {
+		start--
+	}
+	word := strings.ToLower(string(line[start:pos]))
+
+	var candidates []string
+	if dot := strings.LastIndex(word, "."); dot >= 0 {
+		table, prefix := word[:dot], word[dot+1:]
+		for _, col := range sc.console.schema[table] {
+			if strings.HasPrefix(col, prefix) {
+				candidates = append(candidates, col[len(prefix):])
+			}
+		}
+		return toRunes(candidates), len(prefix)
+	}
+
+	if word == "" {
+		return nil, 0
+	}
+	seen := make(map[string]bool)
+	for _, table := range sc.console.tableNames() {
+		if strings.HasPrefix(table, word) && !seen[table] {
+			seen[table] = true
+			candidates = append(candidates, table[len(word):])
+		}
+		for _, col := range sc.console.schema[table] {
+			if strings.HasPrefix(col, word) && !seen[col] {
+				seen[col] = true
+				candidates = append(candidates, col[len(word):])
+			}
+		}
+	}
+	return toRunes(candidates), len(word)
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+func toRunes(candidates []string) [][]rune {
+	out := make([][]rune, len(candidates))
+	for i, c := range candidates {
+		out[i] = []rune(c)
+	}
+	return out
+}
+
+// runSQL is the scriptable form of menu item 23: it runs one read-only
+// statement with the console's limits, or opens the console when no
+// statement is given
+func runSQL(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	console, err := newSQLConsole(db)
+	if err != nil {
+		return err
+	}
+	fs := newFlagSet("sql")
+	fs.DurationVar(&console.timeout, "timeout", console.timeout, "statement timeout (default $SQL_CONSOLE_TIMEOUT or 30s)")
+	fs.IntVar(&console.rowLimit, "limit", console.rowLimit, "maximum rows returned (default $SQL_CONSOLE_ROW_LIMIT or 200)")
+	format := fs.String("format", "table", "output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if err := checkConsoleTimeout(console.timeout); err != nil {
+		return usageError{err}
+	}
+	if err := checkConsoleRowLimit(console.rowLimit); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() == 0 {
+		return console.interact(ctx)
+	}
+
+	statement := strings.Join(fs.Args(), " ")
+	if strings.HasPrefix(statement, "@") {
+		data, err := os.ReadFile(statement[1:])
+		if err != nil {
+			return fmt.Errorf("error reading statement file: %w", err)
+		}
+		statement = string(data)
+	}
+	result, err := console.query(ctx, statement)
+	if err != nil {
+		return err
+	}
+	if err := writeResult("sql-query", result.columns, result.rows, *format, *output); err != nil {
+		return err
+	}
+	if result.truncated {
+		fmt.Fprintf(os.Stderr, "Only the first %d rows are shown; raise -limit for more\n", len(result.rows))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package sqllint
+
+import (
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// unsafeFunctions have side effects a read-only transaction does not
+// prevent, or read files and other sessions outside the tables
+var unsafeFunctions = map[string]bool{
+	"pg_sleep": true, "pg_sleep_for": true, "pg_sleep_until": true,
+	"pg_terminate_backend": true, "pg_cancel_backend": true,
+	"pg_reload_conf": true, "pg_rotate_logfile": true,
+	"pg_read_file": true, "pg_read_binary_file": true, "pg_ls_dir": true, "pg_stat_file": true,
+	"lo_import": true, "lo_export": true,
+	"dblink": true, "dblink_exec": true, "dblink_connect": true,
+	"set_config": true, "pg_notify": true,
+	"pg_advisory_lock": true, "pg_advisory_lock_shared": true,
+	"pg_advisory_xact_lock": true, "pg_advisory_xact_lock_shared": true,
+	"pg_try_advisory_lock": true, "pg_try_advisory_xact_lock": true,
+}
+
+// Guard checks that query only reads: a single SELECT, optionally with
+// WITH clauses that are themselves SELECTs, without SELECT INTO, row locks
+// or functions with side effects. It returns the statement with a LIMIT of
+// at most maxRows; a missing LIMIT is appended and a larger one lowered.
+// Guard does not need the schema, so it applies even when linting is off.
+func Guard(query string, maxRows int) (string, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\n")
+	tree, err := pg_query.Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("syntax error: %v", err)
+	}
+	if len(tree.Stmts) != 1 {
+		return "", fmt.Errorf("expected one statement, found %d", len(tree.Stmts))
+	}
+	top := tree.Stmts[0].Stmt.GetSelectStmt()
+	if top == nil {
+		return "", fmt.Errorf("only SELECT statements are allowed")
+	}
+
+	var problem string
+	walk(tree.Stmts[0].Stmt.ProtoReflect(), func(m proto.Message) {
+		if problem != "" {
+			return
+		}
+		switch n := m.(type) {
+		case *pg_query.InsertStmt, *pg_query.UpdateStmt, *pg_query.DeleteStmt, *pg_query.MergeStmt:
+			problem = "data-modifying statements are not allowed"
+		case *pg_query.SelectStmt:
+			if n.IntoClause != nil {
+				problem = "SELECT INTO is not allowed"
+			} else if len(n.LockingClause) > 0 {
+				problem = "FOR UPDATE and FOR SHARE are not allowed"
+			}
+		case *pg_query.FuncCall:
+			if name := funcName(n); unsafeFunctions[name] {
+				problem = fmt.Sprintf("function %s is not allowed", name)
+			}
+		}
+	})
+	if problem != "" {
+		return "", fmt.Errorf("%s", problem)
+	}
+
+	if maxRows <= 0 {
+		return query, nil
+	}
+	if top.LimitCount == nil {
+		// On its own line, in case the query ends in a -- comment
+		return fmt.Sprintf("%s\nLIMIT %d", query, maxRows), nil
+	}
+	withTies := top.LimitOption == pg_query.LimitOption_LIMIT_OPTION_WITH_TIES
+	if c := top.LimitCount.GetAConst(); c != nil && !c.Isnull && c.GetIval() != nil && c.GetIval().Ival <= int32(maxRows) && !withTies {
+		return query, nil
+	}
+	// LIMIT ALL, an expression, too many rows, or ties that could exceed
+	// the cap
+	top.LimitCount = pg_query.MakeAConstIntNode(int64(maxRows), -1)
+	top.LimitOption = pg_query.LimitOption_LIMIT_OPTION_COUNT
+	capped, err := pg_query.Deparse(tree)
+	if err != nil {
+		return "", fmt.Errorf("error applying row limit: %v", err)
+	}
+	return capped, nil
+}
+
+// Single checks that query is exactly one statement and not transaction
+// control, so that it cannot end the transaction it is run in and carry on
+// outside it. The driver may accept several statements in one string;
+// Single does not rely on it refusing. It returns the statement without a
+// trailing semicolon.
+func Single(query string) (string, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\n")
+	tree, err := pg_query.Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("syntax error: %v", err)
+	}
+	if len(tree.Stmts) != 1 {
+		return "", fmt.Errorf("expected one statement, found %d", len(tree.Stmts))
+	}
+	if tree.Stmts[0].Stmt.GetTransactionStmt() != nil {
+		return "", fmt.Errorf("transaction control statements are not allowed")
+	}
+	return query, nil
+}
+
+// funcName returns the unqualified, lower case name of a function call
+func funcName(call *pg_query.FuncCall) string {
+	if len(call.Funcname) == 0 {
+		return ""
+	}
+	return strings.ToLower(call.Funcname[len(call.Funcname)-1].GetString_().GetSval())
+}
@@ -0,0 +1,97 @@
+package sqllint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT regnumber FROM candidate", "SELECT regnumber FROM candidate\nLIMIT 50"},
+		{"SELECT regnumber FROM candidate;  \n", "SELECT regnumber FROM candidate\nLIMIT 50"},
+		{"SELECT regnumber FROM candidate -- first page", "SELECT regnumber FROM candidate -- first page\nLIMIT 50"},
+		{"SELECT regnumber FROM candidate LIMIT 10", "SELECT regnumber FROM candidate LIMIT 10"},
+		{"SELECT regnumber FROM candidate LIMIT 500", "SELECT regnumber FROM candidate LIMIT 50"},
+		{"SELECT regnumber FROM candidate LIMIT ALL", "SELECT regnumber FROM candidate LIMIT 50"},
+		{"SELECT regnumber FROM candidate ORDER BY aggregate FETCH FIRST 10 ROWS WITH TIES",
+			"SELECT regnumber FROM candidate ORDER BY aggregate LIMIT 50"},
+		{"WITH top AS (SELECT regnumber FROM candidate ORDER BY aggregate DESC) SELECT * FROM top",
+			"WITH top AS (SELECT regnumber FROM candidate ORDER BY aggregate DESC) SELECT * FROM top\nLIMIT 50"},
+		{"SELECT year, COUNT(*) FROM candidate GROUP BY year UNION SELECT 0, 0",
+			"SELECT year, COUNT(*) FROM candidate GROUP BY year UNION SELECT 0, 0\nLIMIT 50"},
+	}
+	for _, tt := range tests {
+		got, err := Guard(tt.query, 50)
+		if err != nil {
+			t.Errorf("Guard(%q): %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Guard(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGuardWithoutCap(t *testing.T) {
+	const query = "SELECT regnumber FROM candidate LIMIT 500"
+	if got, err := Guard(query, 0); err != nil || got != query {
+		t.Errorf("Guard(%q, 0) = %q, %v", query, got, err)
+	}
+}
+
+func TestGuardRefuses(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELEC regnumber FROM candidate", "syntax error"},
+		{"SELECT 1; SELECT 2", "expected one statement, found 2"},
+		{"SELECT 1; DROP TABLE candidate", "expected one statement, found 2"},
+		{"DELETE FROM candidate", "only SELECT statements are allowed"},
+		{"UPDATE candidate SET aggregate = 0", "only SELECT statements are allowed"},
+		{"EXPLAIN ANALYZE DELETE FROM candidate", "only SELECT statements are allowed"},
+		{"BEGIN", "only SELECT statements are allowed"},
+		{"WITH gone AS (DELETE FROM candidate RETURNING *) SELECT * FROM gone", "data-modifying statements are not allowed"},
+		{"SELECT * INTO backup FROM candidate", "SELECT INTO is not allowed"},
+		{"SELECT * FROM candidate FOR UPDATE", "FOR UPDATE and FOR SHARE are not allowed"},
+		{"SELECT pg_sleep(60)", "function pg_sleep is not allowed"},
+		{"SELECT regnumber FROM candidate WHERE pg_catalog.PG_READ_FILE('/etc/passwd') IS NULL", "function pg_read_file is not allowed"},
+		{"SELECT (SELECT set_config('statement_timeout', '0', false))", "function set_config is not allowed"},
+	}
+	for _, tt := range tests {
+		_, err := Guard(tt.query, 50)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("Guard(%q) error = %v, want %q", tt.query, err, tt.want)
+		}
+	}
+}
+
+func TestSingle(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string // the statement returned, or the start of the error
+		ok    bool
+	}{
+		{"SELECT 1;", "SELECT 1", true},
+		{"UPDATE candidate SET aggregate = 0 WHERE year = 2023", "UPDATE candidate SET aggregate = 0 WHERE year = 2023", true},
+		{"SELECT 1; SELECT 2", "expected one statement, found 2", false},
+		{"", "expected one statement, found 0", false},
+		{"COMMIT", "transaction control statements are not allowed", false},
+		{"ROLLBACK; DELETE FROM candidate", "expected one statement, found 2", false},
+		{"START TRANSACTION READ WRITE", "transaction control statements are not allowed", false},
+		{"SELEC 1", "syntax error", false},
+	}
+	for _, tt := range tests {
+		got, err := Single(tt.query)
+		if tt.ok {
+			if err != nil || got != tt.want {
+				t.Errorf("Single(%q) = %q, %v; want %q", tt.query, got, err, tt.want)
+			}
+		} else if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("Single(%q) error = %v, want %q", tt.query, err, tt.want)
+		}
+	}
+}
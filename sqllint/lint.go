@@ -0,0 +1,453 @@
+// Package sqllint checks generated SQL locally before it is sent anywhere.
+// It parses the statement with the PostgreSQL parser, checks the tables and
+// columns it references against the database schema and fixes trivial
+// mistakes (a missing table alias, MySQL or double-quoted string quoting)
+// so that a model round-trip is only spent on SQL that could run.
+package sqllint
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Schema maps each table, view and materialized view in the public schema
+// to its columns
+type Schema map[string]map[string]bool
+
+// LoadSchema introspects the public schema of db
+func LoadSchema(ctx context.Context, db *sql.DB) (Schema, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT c.relname, a.attname
+        FROM pg_class c
+        JOIN pg_namespace n ON n.oid = c.relnamespace
+        JOIN pg_attribute a ON a.attrelid = c.oid
+        WHERE n.nspname = 'public'
+          AND c.relkind IN ('r', 'v', 'm', 'p', 'f')
+          AND a.attnum > 0 AND NOT a.attisdropped`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema: %w", err)
+	}
+	defer rows.Close()
+
+	schema := make(Schema)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, fmt.Errorf("error reading schema: %w", err)
+		}
+		if schema[table] == nil {
+			schema[table] = make(map[string]bool)
+		}
+		schema[table][column] = true
+	}
+	return schema, rows.Err()
+}
+
+// ReadSchema reads a column listing as psql prints information_schema.columns,
+// table_schema | table_name | column_name | ..., such as current_db_state.txt
+func ReadSchema(r io.Reader) (Schema, error) {
+	schema := make(Schema)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) < 3 || strings.TrimSpace(fields[0]) != "public" {
+			continue
+		}
+		table, column := strings.TrimSpace(fields[1]), strings.TrimSpace(fields[2])
+		if schema[table] == nil {
+			schema[table] = make(map[string]bool)
+		}
+		schema[table][column] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading schema: %w", err)
+	}
+	return schema, nil
+}
+
+// Result is the outcome of linting one statement
+type Result struct {
+	SQL      string   // the statement with any fixes applied
+	Fixes    []string // what was changed
+	Problems []string // what is still wrong; the SQL should not be run
+}
+
+// OK reports whether the statement passed
+func (r *Result) OK() bool { return len(r.Problems) == 0 }
+
+// Error describes the problems in one line
+func (r *Result) Error() string { return strings.Join(r.Problems, "; ") }
+
+// maxPasses bounds how often fixes are applied and the statement relinted
+const maxPasses = 3
+
+// Lint checks a single SELECT statement against the schema, fixing what it
+// can. A statement that still does not parse is reported as a problem.
+func (s Schema) Lint(query string) *Result {
+	result := &Result{SQL: strings.TrimSpace(query)}
+	if trimmed := strings.TrimRight(result.SQL, "; \t\n"); trimmed != result.SQL {
+		result.SQL = trimmed
+	}
+	if fixed, ok := replaceBackticks(result.SQL); ok {
+		result.SQL = fixed
+		result.Fixes = append(result.Fixes, "replaced backtick quoting with double quotes")
+	}
+
+	for pass := 0; pass < maxPasses; pass++ {
+		edits, fixes, problems := s.check(result.SQL)
+		if len(edits) == 0 {
+			result.Problems = problems
+			return result
+		}
+		result.SQL = applyEdits(result.SQL, edits)
+		result.Fixes = append(result.Fixes, fixes...)
+	}
+	_, _, result.Problems = s.check(result.SQL)
+	return result
+}
+
+// edit replaces sql[start:end] with text
+type edit struct {
+	start, end int
+	text       string
+}
+
+func applyEdits(sql string, edits []edit) string {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	for _, e := range edits {
+		sql = sql[:e.start] + e.text + sql[e.end:]
+	}
+	return sql
+}
+
+// relation is a table reference in the FROM clause
+type relation struct {
+	name     string
+	alias    string
+	location int
+	known    bool // the table is in the schema
+}
+
+// statement is what the linter collects from a parse tree. Scopes are
+// flattened: an alias defined anywhere in the statement is visible
+// everywhere, which is loose but never rejects valid SQL.
+type statement struct {
+	relations []*relation
+	aliases   map[string][]*relation
+	derived   map[string]bool // CTE, subquery and function aliases
+	outputs   map[string]bool // select list aliases, usable in ORDER BY
+	columns   []*pg_query.ColumnRef
+}
+
+// check parses sql and returns the fixes it can make and the problems it
+// cannot fix
+func (s Schema) check(sql string) ([]edit, []string, []string) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, nil, []string{fmt.Sprintf("syntax error: %v", err)}
+	}
+	if len(tree.Stmts) != 1 {
+		return nil, nil, []string{fmt.Sprintf("expected one statement, found %d", len(tree.Stmts))}
+	}
+	if tree.Stmts[0].Stmt.GetSelectStmt() == nil {
+		return nil, nil, []string{"only SELECT statements are allowed"}
+	}
+
+	st := &statement{
+		aliases: make(map[string][]*relation),
+		derived: make(map[string]bool),
+		outputs: make(map[string]bool),
+	}
+	walk(tree.Stmts[0].Stmt.ProtoReflect(), st.visit)
+	s.resolve(st)
+
+	var edits []edit
+	var fixes, problems []string
+	for _, rel := range st.relations {
+		if rel.known || st.derived[rel.name] {
+			continue
+		}
+		// "Candidate" is a different table from candidate
+		if lower := strings.ToLower(rel.name); s[lower] != nil && strings.HasPrefix(sql[rel.location:], `"`) {
+			edits = append(edits, edit{rel.location, rel.location + len(rel.name) + 2, lower})
+			fixes = append(fixes, fmt.Sprintf("unquoted table %q", rel.name))
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("unknown table %s%s", rel.name, s.suggest(rel.name)))
+	}
+
+	unknownQualifiers := make(map[string][]string)
+	var qualifierOrder []string
+	for _, ref := range st.columns {
+		names, star := columnNames(ref)
+		if len(names) == 0 {
+			continue
+		}
+		switch {
+		case len(names) == 1 && !star:
+			edit, fix, problem := s.checkUnqualified(sql, st, names[0], int(ref.Location))
+			if fix != "" {
+				edits = append(edits, edit)
+				fixes = append(fixes, fix)
+			} else if problem != "" {
+				problems = append(problems, problem)
+			}
+		case len(names) >= 1:
+			qualifier := names[len(names)-1]
+			column := ""
+			if !star {
+				qualifier, column = names[len(names)-2], names[len(names)-1]
+			}
+			if st.derived[qualifier] {
+				continue
+			}
+			tables := st.tablesFor(qualifier)
+			if len(tables) == 0 {
+				if _, seen := unknownQualifiers[qualifier]; !seen {
+					qualifierOrder = append(qualifierOrder, qualifier)
+				}
+				unknownQualifiers[qualifier] = append(unknownQualifiers[qualifier], column)
+				continue
+			}
+			if column != "" && !s.anyHas(tables, column) {
+				problems = append(problems, fmt.Sprintf("column %s.%s does not exist in %s", qualifier, column, tableNames(tables)))
+			}
+		}
+	}
+
+	// c.gender with "FROM candidate" lacking the alias c
+	for _, qualifier := range qualifierOrder {
+		if rel := s.unaliasedFor(st, unknownQualifiers[qualifier]); rel != nil {
+			end := identEnd(sql, rel.location)
+			edits = append(edits, edit{end, end, " " + qualifier})
+			fixes = append(fixes, fmt.Sprintf("added missing alias %s for %s", qualifier, rel.name))
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("unknown table or alias %s", qualifier))
+	}
+	return edits, fixes, problems
+}
+
+// checkUnqualified checks a column without a table qualifier. A name that
+// is no column but was written in double quotes is taken for a string.
+func (s Schema) checkUnqualified(sql string, st *statement, name string, location int) (edit, string, string) {
+	if st.outputs[name] || len(st.derived) > 0 {
+		return edit{}, "", ""
+	}
+	var tables []*relation
+	for _, rel := range st.relations {
+		if rel.known {
+			tables = append(tables, rel)
+		}
+	}
+	if len(tables) < len(st.relations) || s.anyHas(tables, name) {
+		// Unknown tables are already reported
+		return edit{}, "", ""
+	}
+	if strings.HasPrefix(sql[location:], `"`) {
+		end := location + 1 + strings.Index(sql[location+1:], `"`) + 1
+		literal := "'" + strings.ReplaceAll(sql[location+1:end-1], "'", "''") + "'"
+		return edit{location, end, literal}, fmt.Sprintf("quoted %s as a string", literal), ""
+	}
+	return edit{}, "", fmt.Sprintf("column %s does not exist in %s", name, tableNames(tables))
+}
+
+// tablesFor returns the tables a qualifier refers to, by alias or by name
+func (st *statement) tablesFor(qualifier string) []*relation {
+	if rels := st.aliases[qualifier]; len(rels) > 0 {
+		return rels
+	}
+	var rels []*relation
+	for _, rel := range st.relations {
+		if rel.alias == "" && rel.name == qualifier {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+// unaliasedFor returns the only table without an alias that has all of
+// columns, or nil
+func (s Schema) unaliasedFor(st *statement, columns []string) *relation {
+	var found *relation
+	for _, rel := range st.relations {
+		if rel.alias != "" || !rel.known {
+			continue
+		}
+		ok := true
+		for _, col := range columns {
+			if col != "" && !s[rel.name][col] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			if found != nil {
+				return nil
+			}
+			found = rel
+		}
+	}
+	return found
+}
+
+func (s Schema) anyHas(tables []*relation, column string) bool {
+	for _, rel := range tables {
+		if !rel.known || s[rel.name][column] {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest names a table that differs from name only in case or plural
+func (s Schema) suggest(name string) string {
+	lower := strings.ToLower(name)
+	for _, candidate := range []string{lower, strings.TrimSuffix(lower, "s"), lower + "s"} {
+		if candidate != name && s[candidate] != nil {
+			return fmt.Sprintf(" (did you mean %s?)", candidate)
+		}
+	}
+	return ""
+}
+
+func (st *statement) visit(m proto.Message) {
+	switch n := m.(type) {
+	case *pg_query.RangeVar:
+		if n.Schemaname != "" && n.Schemaname != "public" {
+			// pg_catalog and information_schema are not linted
+			return
+		}
+		rel := &relation{name: n.Relname, location: int(n.Location)}
+		if n.Alias != nil {
+			rel.alias = n.Alias.Aliasname
+			st.aliases[rel.alias] = append(st.aliases[rel.alias], rel)
+		}
+		st.relations = append(st.relations, rel)
+	case *pg_query.CommonTableExpr:
+		st.derived[n.Ctename] = true
+	case *pg_query.RangeSubselect:
+		if n.Alias != nil {
+			st.derived[n.Alias.Aliasname] = true
+		}
+	case *pg_query.RangeFunction:
+		if n.Alias != nil {
+			st.derived[n.Alias.Aliasname] = true
+		}
+	case *pg_query.JoinExpr:
+		if n.Alias != nil {
+			st.derived[n.Alias.Aliasname] = true
+		}
+	case *pg_query.ResTarget:
+		if n.Name != "" {
+			st.outputs[n.Name] = true
+		}
+	case *pg_query.ColumnRef:
+		st.columns = append(st.columns, n)
+	}
+}
+
+// resolve marks the relations found in the schema; it runs after the walk
+// because CTE names may be collected after the references to them
+func (s Schema) resolve(st *statement) {
+	for _, rel := range st.relations {
+		rel.known = s[rel.name] != nil && !st.derived[rel.name]
+	}
+}
+
+// walk visits every message in a parse tree
+func walk(m protoreflect.Message, visit func(proto.Message)) {
+	visit(m.Interface())
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap() || fd.Message() == nil:
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				walk(list.Get(i).Message(), visit)
+			}
+		default:
+			walk(v.Message(), visit)
+		}
+		return true
+	})
+}
+
+// columnNames returns the name parts of a column reference and whether it
+// ends in *
+func columnNames(ref *pg_query.ColumnRef) ([]string, bool) {
+	var names []string
+	star := false
+	for _, field := range ref.Fields {
+		switch {
+		case field.GetString_() != nil:
+			names = append(names, field.GetString_().Sval)
+		case field.GetAStar() != nil:
+			star = true
+		}
+	}
+	return names, star
+}
+
+func tableNames(tables []*relation) string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, rel := range tables {
+		if !seen[rel.name] {
+			seen[rel.name] = true
+			names = append(names, rel.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// identEnd returns the offset just past the (possibly schema qualified or
+// quoted) identifier starting at start
+func identEnd(sql string, start int) int {
+	i := start
+	for i < len(sql) {
+		switch c := sql[i]; {
+		case c == '"':
+			close := strings.Index(sql[i+1:], `"`)
+			if close < 0 {
+				return len(sql)
+			}
+			i += close + 2
+		case c == '.' || c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80:
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// replaceBackticks turns MySQL `identifier` quoting into "identifier",
+// leaving string literals alone
+func replaceBackticks(sql string) (string, bool) {
+	if !strings.Contains(sql, "`") {
+		return sql, false
+	}
+	b := []byte(sql)
+	inString := false
+	changed := false
+	for i, c := range b {
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == '`' && !inString:
+			b[i] = '"'
+			changed = true
+		}
+	}
+	return string(b), changed
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/importer"
+)
+
+// runStateMatches lists the states imports matched by spelling distance,
+// and confirms or corrects them
+func runStateMatches(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("state-matches")
+	format := fs.String("format", "table", "with list, output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "with list, write the result to this file instead of stdout")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError{errors.New("state-matches needs list, review, accept RAW or correct RAW STATE")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	switch action := positional[0]; action {
+	case "list":
+		groups, err := importer.PendingStateMatches(ctx, db)
+		if err != nil {
+			return err
+		}
+		rows := make([][]interface{}, len(groups))
+		for i, g := range groups {
+			rows[i] = []interface{}{g.RawValue, g.State, g.Distance, g.Rows, strings.Join(g.Sample, ", ")}
+		}
+		return writeResult("state-matches", []string{"raw_value", "matched_state", "distance", "rows", "sample_regnumbers"}, rows, *format, *output)
+	case "review":
+		return reviewStateMatches(ctx, db)
+	case "accept":
+		if len(positional) != 2 {
+			return usageError{errors.New("state-matches accept needs the raw value")}
+		}
+		groups, err := importer.PendingStateMatches(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			if g.RawValue == positional[1] {
+				return settleStateMatch(ctx, db, g.RawValue, g.StateID, g.State)
+			}
+		}
+		return fmt.Errorf("no pending state match for %q", positional[1])
+	case "correct":
+		if len(positional) != 3 {
+			return usageError{errors.New("state-matches correct needs the raw value and a state name or ID")}
+		}
+		id, name, err := importer.ResolveState(ctx, db, positional[2])
+		if err != nil {
+			return err
+		}
+		return settleStateMatch(ctx, db, positional[1], id, name)
+	default:
+		return usageError{fmt.Errorf("unknown state-matches action %q", action)}
+	}
+}
+
+func settleStateMatch(ctx context.Context, db *sql.DB, raw string, stateID int, state string) error {
+	corrected, cleared, err := importer.ReviewStateMatch(ctx, db, raw, stateID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%q is %s from now on", raw, state)
+	if corrected > 0 {
+		fmt.Fprintf(os.Stderr, "; corrected %d candidates", corrected)
+		if cleared > 0 {
+			fmt.Fprintf(os.Stderr, " and cleared %d LGAs outside %s", cleared, state)
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// reviewStateMatches asks about each pending match in turn
+func reviewStateMatches(ctx context.Context, db *sql.DB) error {
+	groups, err := importer.PendingStateMatches(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		color.Green("No state matches to review")
+		return nil
+	}
+	fmt.Println("For each value press Enter to accept the match, type the right state name or ID, or s to skip.")
+	for _, g := range groups {
+		fmt.Printf("%q -> %s (distance %d, %d rows, e.g. %s): ", g.RawValue, g.State, g.Distance, g.Rows, strings.Join(g.Sample, ", "))
+		input := readString()
+		switch strings.ToLower(input) {
+		case "s":
+			continue
+		case "":
+			err = settleStateMatch(ctx, db, g.RawValue, g.StateID, g.State)
+		default:
+			id, name, resolveErr := importer.ResolveState(ctx, db, input)
+			if resolveErr != nil {
+				color.Red("Skipping %q: %v", g.RawValue, resolveErr)
+				continue
+			}
+			err = settleStateMatch(ctx, db, g.RawValue, id, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Freshness is when a fact table or view was last rebuilt and whether
+// candidate data has changed since. Changes bump a generation counter and
+// each rebuild records the generation it saw, so a relation is fresh when
+// it was rebuilt at or after the current generation.
+type Freshness struct {
+	Relation    string
+	RefreshedAt time.Time // zero when never rebuilt
+	Fresh       bool
+}
+
+// MarkChanged records that candidate data has changed, so every fact table
+// and view is stale until its next refresh
+func MarkChanged(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT nextval('candidate_data_generation')")
+	return err
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// dataGeneration returns the current candidate data generation, 0 before
+// any change has been recorded
+func dataGeneration(ctx context.Context, q queryer) (int64, error) {
+	var generation int64
+	err := q.QueryRowContext(ctx,
+		"SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM candidate_data_generation").Scan(&generation)
+	return generation, err
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// markFresh records that relation was rebuilt from the data as of generation
+func markFresh(ctx context.Context, e execer, relation string, generation int64) error {
+	_, err := e.ExecContext(ctx, `
+        INSERT INTO relation_freshness (relation, generation, refreshed_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (relation) DO UPDATE SET
+            generation = GREATEST(relation_freshness.generation, EXCLUDED.generation),
+            refreshed_at = EXCLUDED.refreshed_at`, relation, generation)
+	return err
+}
+
+// RelationFreshness reports the freshness of each relation. Relations never
+// rebuilt, or all of them before any refresh has run, are stale.
+func RelationFreshness(ctx context.Context, db *sql.DB, relations ...string) ([]Freshness, error) {
+	result := make([]Freshness, len(relations))
+	for i, relation := range relations {
+		result[i].Relation = relation
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass('relation_freshness') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return result, nil
+	}
+	generation, err := dataGeneration(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT relation, generation, refreshed_at FROM relation_freshness
+        WHERE relation = ANY($1)`, pq.Array(relations))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	index := make(map[string]int, len(relations))
+	for i, relation := range relations {
+		index[relation] = i
+	}
+	for rows.Next() {
+		var relation string
+		var built int64
+		var at time.Time
+		if err := rows.Scan(&relation, &built, &at); err != nil {
+			return nil, err
+		}
+		f := &result[index[relation]]
+		f.RefreshedAt = at
+		f.Fresh = built >= generation
+	}
+	return result, rows.Err()
+}
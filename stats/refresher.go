@@ -0,0 +1,169 @@
+// Package stats keeps planner statistics, derived views and the yearly
+// fact tables current after candidate data changes.
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nonsonwune/spk2_db/joblog"
+)
+
+// candidateTables are the tables an import writes to
+var candidateTables = []string{"candidate", "candidate_scores"}
+
+// refreshTimeout bounds a single background refresh
+const refreshTimeout = time.Hour
+
+// Refresher runs ANALYZE and refreshes materialized views in the background
+// after imports, so the first report afterwards runs against fresh statistics.
+// Refreshes are serialized; concurrent requests queue behind the running one.
+type Refresher struct {
+	db   *sql.DB
+	jobs *joblog.Log
+
+	mu      sync.Mutex // held while a refresh runs
+	running sync.WaitGroup
+}
+
+func NewRefresher(db *sql.DB, jobs *joblog.Log) *Refresher {
+	return &Refresher{db: db, jobs: jobs}
+}
+
+// AfterImport starts a background refresh for year and returns immediately.
+// It has the signature of an importer completion hook. The refresh outlives
+// the import's context, which is usually cancelled as soon as it returns.
+func (r *Refresher) AfterImport(ctx context.Context, year int) error {
+	if err := MarkChanged(ctx, r.db); err != nil {
+		log.Printf("Warning: could not mark summary tables stale: %v", err)
+	}
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+		defer cancel()
+		if err := r.Refresh(ctx, year); err != nil {
+			log.Printf("Background statistics refresh failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until background refreshes have finished
+func (r *Refresher) Wait() {
+	r.running.Wait()
+}
+
+// Refresh analyzes the candidate tables, rebuilds year's rows in the fact
+// tables (every year's when year is 0) and refreshes every materialized view
+// built on the candidate tables
+func (r *Refresher) Refresh(ctx context.Context, year int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := fmt.Sprintf("stats-refresh:%d", year)
+	start := time.Now()
+
+	for _, table := range candidateTables {
+		if err := r.step(ctx, job, "analyze:"+table, "ANALYZE "+table); err != nil {
+			return err
+		}
+	}
+
+	// Changes made while the refresh runs leave the relations stale
+	generation, err := dataGeneration(ctx, r.db)
+	if err != nil {
+		return fmt.Errorf("error reading data generation: %w", err)
+	}
+	for _, t := range FactTables {
+		t := t
+		err := r.run(ctx, job, "facts:"+t.Name, func(ctx context.Context) error {
+			return refreshFact(ctx, r.db, t, year, generation)
+		})
+		if err != nil {
+			return fmt.Errorf("error refreshing %s: %w", t.Name, err)
+		}
+	}
+
+	views, err := r.dependentViews(ctx)
+	if err != nil {
+		r.record(ctx, job, "views", joblog.StatusFailed, err.Error())
+		return fmt.Errorf("error finding materialized views: %w", err)
+	}
+	for _, view := range views {
+		if err := r.step(ctx, job, "refresh:"+view, "REFRESH MATERIALIZED VIEW "+view); err != nil {
+			return err
+		}
+		if err := markFresh(ctx, r.db, view, generation); err != nil {
+			log.Printf("Warning: could not record refresh of %s: %v", view, err)
+		}
+	}
+
+	log.Printf("Statistics refreshed for %d in %v (%d fact tables, %d materialized views)",
+		year, time.Since(start).Round(time.Second), len(FactTables), len(views))
+	return nil
+}
+
+// dependentViews lists materialized views whose definitions read directly
+// from the candidate tables
+func (r *Refresher) dependentViews(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT DISTINCT v.oid::regclass::text
+        FROM pg_depend d
+        JOIN pg_rewrite rw ON d.objid = rw.oid
+        JOIN pg_class v ON rw.ev_class = v.oid
+        JOIN pg_class t ON d.refobjid = t.oid
+        WHERE v.relkind = 'm'
+        AND t.relname = ANY($1)
+        ORDER BY 1`, pq.Array(candidateTables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var views []string
+	for rows.Next() {
+		var view string
+		if err := rows.Scan(&view); err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+	return views, rows.Err()
+}
+
+func (r *Refresher) step(ctx context.Context, job, step, statement string) error {
+	err := r.run(ctx, job, step, func(ctx context.Context) error {
+		_, err := r.db.ExecContext(ctx, statement)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", statement, err)
+	}
+	return nil
+}
+
+// run performs a step of a refresh job, recording its outcome and duration
+func (r *Refresher) run(ctx context.Context, job, step string, fn func(context.Context) error) error {
+	start := time.Now()
+	if err := fn(ctx); err != nil {
+		r.record(ctx, job, step, joblog.StatusFailed, err.Error())
+		return err
+	}
+	r.record(ctx, job, step, joblog.StatusSucceeded, time.Since(start).Round(time.Millisecond).String())
+	return nil
+}
+
+func (r *Refresher) record(ctx context.Context, job, step, status, detail string) {
+	if r.jobs == nil {
+		return
+	}
+	if err := r.jobs.Record(ctx, joblog.Entry{Job: job, Step: step, Status: status, Detail: detail}); err != nil {
+		log.Printf("Warning: failed to record refresh status: %v", err)
+	}
+}
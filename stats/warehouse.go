@@ -0,0 +1,171 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// FactTable is a small pre-aggregated table of yearly figures. Trend
+// questions read these instead of scanning every candidate. The tables are
+// created by migration 0024.
+type FactTable struct {
+	Name        string
+	Description string
+	// Columns lists the table's columns as "name: meaning"
+	Columns []string
+	// populate selects the table's rows for the years matching $1, or every
+	// year when $1 is 0
+	populate string
+}
+
+// FactTables are the warehouse tables kept current after imports
+var FactTables = []FactTable{
+	{
+		Name:        "applicants_by_state_course_year",
+		Description: "applicants and admissions per state of origin, first-choice course and year",
+		Columns: []string{
+			"year", "st_id", "state_name: upper case, e.g. LAGOS", "course_code", "course_name",
+			"applicants", "admitted", "female", "male", "avg_aggregate",
+		},
+		populate: `
+            SELECT c.year, c.statecode, s.st_name, c.app_course1, co.course_name,
+                   COUNT(*), COUNT(*) FILTER (WHERE c.is_admitted),
+                   COUNT(*) FILTER (WHERE c.gender = 'F'), COUNT(*) FILTER (WHERE c.gender = 'M'),
+                   ROUND(AVG(NULLIF(c.aggregate, 0)), 2)
+            FROM candidate c
+            LEFT JOIN state s ON s.st_id = c.statecode
+            LEFT JOIN course co ON co.course_code = c.app_course1
+            WHERE $1 = 0 OR c.year = $1
+            GROUP BY c.year, c.statecode, s.st_name, c.app_course1, co.course_name`,
+	},
+	{
+		Name:        "applicants_by_state_year",
+		Description: "applicants, admissions and scores per state of origin and year",
+		Columns: []string{
+			"year", "st_id", "state_name: upper case, e.g. LAGOS", "applicants", "admitted",
+			"female", "male", "avg_aggregate", "median_aggregate",
+		},
+		populate: `
+            SELECT c.year, c.statecode, s.st_name,
+                   COUNT(*), COUNT(*) FILTER (WHERE c.is_admitted),
+                   COUNT(*) FILTER (WHERE c.gender = 'F'), COUNT(*) FILTER (WHERE c.gender = 'M'),
+                   ROUND(AVG(NULLIF(c.aggregate, 0)), 2),
+                   ROUND((PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY NULLIF(c.aggregate, 0)))::numeric, 2)
+            FROM candidate c
+            LEFT JOIN state s ON s.st_id = c.statecode
+            WHERE $1 = 0 OR c.year = $1
+            GROUP BY c.year, c.statecode, s.st_name`,
+	},
+	{
+		Name:        "applicants_by_institution_year",
+		Description: "applicants and admissions per first-choice institution and year",
+		Columns: []string{
+			"year", "inid", "institution_name", "institution_abbreviation", "applicants", "admitted",
+			"female", "avg_aggregate",
+		},
+		populate: `
+            SELECT c.year, c.inid, i.inname, i.inabv,
+                   COUNT(*), COUNT(*) FILTER (WHERE c.is_admitted),
+                   COUNT(*) FILTER (WHERE c.gender = 'F'),
+                   ROUND(AVG(NULLIF(c.aggregate, 0)), 2)
+            FROM candidate c
+            LEFT JOIN institution i ON i.inid = c.inid
+            WHERE $1 = 0 OR c.year = $1
+            GROUP BY c.year, c.inid, i.inname, i.inabv`,
+	},
+	{
+		Name:        "aggregate_bands_by_year",
+		Description: "candidates with an aggregate per 50-point aggregate band and year",
+		Columns: []string{
+			"year", "band_start: lowest aggregate in the band, e.g. 200 for 200-249",
+			"candidates", "admitted",
+		},
+		populate: `
+            SELECT c.year, (c.aggregate / 50) * 50,
+                   COUNT(*), COUNT(*) FILTER (WHERE c.is_admitted)
+            FROM candidate c
+            WHERE c.aggregate IS NOT NULL AND ($1 = 0 OR c.year = $1)
+            GROUP BY c.year, (c.aggregate / 50) * 50`,
+	},
+	{
+		Name:        "subject_scores_by_year",
+		Description: "score statistics per subject and year",
+		Columns: []string{
+			"year", "subject_id", "subject_name", "candidates", "avg_score", "stddev_score", "pass_count: scores of 50 and above",
+		},
+		populate: `
+            SELECT cs.year, cs.subject_id, sub.su_name,
+                   COUNT(*), ROUND(AVG(cs.score), 2), ROUND(STDDEV(cs.score), 2),
+                   COUNT(*) FILTER (WHERE cs.score >= 50)
+            FROM candidate_scores cs
+            LEFT JOIN subject sub ON sub.su_id = cs.subject_id
+            WHERE $1 = 0 OR cs.year = $1
+            GROUP BY cs.year, cs.subject_id, sub.su_name`,
+	},
+}
+
+// refreshFact replaces a fact table's rows for year, or all rows when
+// year is 0, in one transaction so readers never see a partial year, and
+// records it as built from generation. An empty table, e.g. one just
+// created, is filled for every year.
+func refreshFact(ctx context.Context, db *sql.DB, t FactTable, year int, generation int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if year != 0 {
+		var empty bool
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT NOT EXISTS (SELECT 1 FROM %s)", t.Name)).Scan(&empty); err != nil {
+			return err
+		}
+		if empty {
+			year = 0
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE $1 = 0 OR year = $1", t.Name), year); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s %s", t.Name, t.populate), year); err != nil {
+		return err
+	}
+	if err := markFresh(ctx, tx, t.Name, generation); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AvailableFacts returns the fact tables that exist and hold data
+func AvailableFacts(ctx context.Context, db *sql.DB) ([]FactTable, error) {
+	var available []FactTable
+	for _, t := range FactTables {
+		var exists bool
+		err := db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", t.Name).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s)", t.Name)).Scan(&exists)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if exists {
+			available = append(available, t)
+		}
+	}
+	return available, nil
+}
+
+// DescribeFacts summarises fact tables for prompts and documentation
+func DescribeFacts(tables []FactTable) string {
+	var b strings.Builder
+	for _, t := range tables {
+		fmt.Fprintf(&b, "- %s: %s\n  columns: %s\n", t.Name, t.Description, strings.Join(t.Columns, ", "))
+	}
+	return b.String()
+}
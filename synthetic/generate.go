@@ -0,0 +1,117 @@
+package synthetic
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sampler draws values in proportion to their counts
+type sampler struct {
+	values []string
+	cum    []int64
+}
+
+func newSampler(weights []Weighted) *sampler {
+	s := &sampler{}
+	var total int64
+	for _, w := range weights {
+		total += w.Count
+		s.values = append(s.values, w.Value)
+		s.cum = append(s.cum, total)
+	}
+	return s
+}
+
+func (s *sampler) draw(rng *rand.Rand) string {
+	if len(s.values) == 0 {
+		return ""
+	}
+	n := rng.Int63n(s.cum[len(s.cum)-1])
+	return s.values[sort.Search(len(s.cum), func(i int) bool { return s.cum[i] > n })]
+}
+
+// quantile returns the value at cumulative share u of the distribution
+func (s *sampler) quantile(u float64) string {
+	if len(s.values) == 0 {
+		return ""
+	}
+	n := int64(u * float64(s.cum[len(s.cum)-1]))
+	i := sort.Search(len(s.cum), func(i int) bool { return s.cum[i] > n })
+	if i == len(s.values) {
+		i--
+	}
+	return s.values[i]
+}
+
+// Summary reports what Generate wrote
+type Summary struct {
+	Candidates int
+	Scores     int
+}
+
+// Generate writes n synthetic candidates drawn from the profile in the
+// candidate import format to candidates, and their subject scores as
+// REGNUMBER,SUBJECT_ID,SCORE,YEAR rows to scores. The same seed always
+// yields the same dataset.
+func Generate(p *Profile, n int, seed int64, candidates, scores io.Writer) (*Summary, error) {
+	rng := rand.New(rand.NewSource(seed))
+	genders := newSampler(p.Genders)
+	locations := newSampler(p.Locations)
+	choices := newSampler(p.Choices)
+	combos := newSampler(p.SubjectCombos)
+	sittings := newSampler(p.Sittings)
+	subjectScores := make(map[string]*sampler, len(p.Scores))
+	for subject, weights := range p.Scores {
+		subjectScores[subject] = newSampler(weights)
+	}
+	// One-factor model: each subject score shares an ability factor with
+	// loading sqrt(r), giving pairwise correlation r between subjects
+	loading := math.Sqrt(math.Min(p.ScoreCorrelation, 0.99))
+
+	cw := csv.NewWriter(candidates)
+	sw := csv.NewWriter(scores)
+	cw.Write([]string{"REGNUMBER", "GENDER", "STATECODE", "LG_ID", "INID", "APP_COURSE1",
+		"AGGREGATE", "IS_ADMITTED", "IS_DIRECT_ENTRY", "NOOFSITTINGS"})
+	sw.Write([]string{"REGNUMBER", "SUBJECT_ID", "SCORE", "YEAR"})
+
+	summary := &Summary{}
+	for i := 1; i <= n; i++ {
+		reg := fmt.Sprintf("SYN%d%08d", p.Year, i)
+		state, lga, _ := strings.Cut(locations.draw(rng), "/")
+		course, inid, _ := strings.Cut(choices.draw(rng), "/")
+
+		ability := rng.NormFloat64()
+		aggregate := 0
+		for _, subject := range strings.Split(combos.draw(rng), ",") {
+			dist, ok := subjectScores[subject]
+			if !ok {
+				continue
+			}
+			z := loading*ability + math.Sqrt(1-loading*loading)*rng.NormFloat64()
+			score := dist.quantile(0.5 * (1 + math.Erf(z/math.Sqrt2)))
+			v, _ := strconv.Atoi(score)
+			aggregate += v
+			sw.Write([]string{reg, subject, score, strconv.Itoa(p.Year)})
+			summary.Scores++
+		}
+
+		admitted := rng.Float64() < p.AdmissionRates[strconv.Itoa(aggregate/20*20)]
+		directEntry := rng.Float64() < p.DirectEntryRate
+		cw.Write([]string{reg, genders.draw(rng), state, lga, inid, course, strconv.Itoa(aggregate),
+			strconv.FormatBool(admitted), strconv.FormatBool(directEntry), sittings.draw(rng)})
+		summary.Candidates++
+	}
+
+	cw.Flush()
+	sw.Flush()
+	if err := cw.Error(); err != nil {
+		return summary, err
+	}
+	return summary, sw.Error()
+}
@@ -0,0 +1,192 @@
+// Package synthetic fits the distributions of a year's real candidates and
+// generates statistically similar fake candidates from them, so realistic
+// datasets can be shared with vendors without sharing anyone's records.
+package synthetic
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Weighted is a category observed Count times
+type Weighted struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Profile holds the fitted distributions. It contains only counts of
+// categories seen at least MinCount times, so the profile itself is safe
+// to share.
+type Profile struct {
+	Year     int   `json:"year"`
+	MinCount int64 `json:"min_count"`
+	// Genders, Locations ("statecode/lg_id") and Choices ("course/inid")
+	Genders   []Weighted `json:"genders"`
+	Locations []Weighted `json:"locations"`
+	Choices   []Weighted `json:"choices"`
+	// SubjectCombos are comma-separated subject IDs taken together
+	SubjectCombos []Weighted `json:"subject_combos"`
+	// Scores maps subject ID to its score histogram
+	Scores map[string][]Weighted `json:"scores"`
+	// ScoreCorrelation is the average correlation between a candidate's
+	// subject scores, reproduced through a shared ability factor
+	ScoreCorrelation float64    `json:"score_correlation"`
+	Sittings         []Weighted `json:"sittings"`
+	DirectEntryRate  float64    `json:"direct_entry_rate"`
+	// AdmissionRates maps the lower bound of each 20-point aggregate band
+	// to the share of candidates in it who were admitted
+	AdmissionRates map[string]float64 `json:"admission_rates"`
+}
+
+// Fit measures the distributions of year's candidates. Categories seen
+// fewer than minCount times are left out so rare combinations can't
+// identify anyone.
+func Fit(ctx context.Context, db *sql.DB, year int, minCount int64) (*Profile, error) {
+	if minCount < 1 {
+		minCount = 1
+	}
+	p := &Profile{Year: year, MinCount: minCount, Scores: map[string][]Weighted{}, AdmissionRates: map[string]float64{}}
+
+	categorical := []struct {
+		into  *[]Weighted
+		query string
+	}{
+		{&p.Genders, `SELECT gender, COUNT(*) FROM candidate WHERE year = $1 AND gender IS NOT NULL GROUP BY 1`},
+		{&p.Locations, `SELECT statecode || '/' || lg_id, COUNT(*) FROM candidate
+            WHERE year = $1 AND statecode IS NOT NULL AND lg_id IS NOT NULL GROUP BY 1`},
+		{&p.Choices, `SELECT app_course1 || '/' || inid, COUNT(*) FROM candidate
+            WHERE year = $1 AND app_course1 IS NOT NULL AND inid IS NOT NULL GROUP BY 1`},
+		{&p.Sittings, `SELECT noofsittings::text, COUNT(*) FROM candidate
+            WHERE year = $1 AND noofsittings IS NOT NULL GROUP BY 1`},
+		{&p.SubjectCombos, `SELECT combo, COUNT(*) FROM (
+                SELECT string_agg(subject_id::text, ',' ORDER BY subject_id) AS combo
+                FROM candidate_scores WHERE year = $1 GROUP BY cand_reg_number
+            ) c GROUP BY 1`},
+	}
+	for _, c := range categorical {
+		weights, err := queryWeights(ctx, db, c.query+" HAVING COUNT(*) >= $2", year, minCount)
+		if err != nil {
+			return nil, err
+		}
+		*c.into = weights
+	}
+	if len(p.Locations) == 0 || len(p.Choices) == 0 || len(p.SubjectCombos) == 0 {
+		return nil, fmt.Errorf("not enough %d data to fit a profile", year)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT subject_id::text, score::text, COUNT(*)
+        FROM candidate_scores
+        WHERE year = $1 AND score IS NOT NULL
+        GROUP BY 1, 2
+        ORDER BY 1, score`, year)
+	if err != nil {
+		return nil, fmt.Errorf("error fitting score distributions: %w", err)
+	}
+	for rows.Next() {
+		var subject string
+		var w Weighted
+		if err := rows.Scan(&subject, &w.Value, &w.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p.Scores[subject] = append(p.Scores[subject], w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// A sample of candidates is plenty to estimate the correlation
+	var corr sql.NullFloat64
+	err = db.QueryRowContext(ctx, `
+        WITH sample AS (
+            SELECT cand_reg_number FROM candidate_scores
+            WHERE year = $1 GROUP BY 1 ORDER BY random() LIMIT 20000
+        )
+        SELECT corr(a.score, b.score)
+        FROM candidate_scores a
+        JOIN candidate_scores b ON b.cand_reg_number = a.cand_reg_number
+            AND b.year = a.year AND b.subject_id > a.subject_id
+        WHERE a.year = $1 AND a.cand_reg_number IN (SELECT cand_reg_number FROM sample)`, year).Scan(&corr)
+	if err != nil {
+		return nil, fmt.Errorf("error fitting score correlation: %w", err)
+	}
+	p.ScoreCorrelation = math.Max(0, corr.Float64)
+
+	var directEntry sql.NullFloat64
+	err = db.QueryRowContext(ctx, `
+        SELECT AVG(CASE WHEN is_direct_entry THEN 1 ELSE 0 END)
+        FROM candidate WHERE year = $1`, year).Scan(&directEntry)
+	if err != nil {
+		return nil, fmt.Errorf("error fitting direct entry rate: %w", err)
+	}
+	p.DirectEntryRate = directEntry.Float64
+
+	rows, err = db.QueryContext(ctx, `
+        SELECT (aggregate / 20) * 20, AVG(CASE WHEN is_admitted THEN 1 ELSE 0 END)
+        FROM candidate
+        WHERE year = $1 AND aggregate IS NOT NULL
+        GROUP BY 1
+        HAVING COUNT(*) >= $2`, year, minCount)
+	if err != nil {
+		return nil, fmt.Errorf("error fitting admission rates: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var band int
+		var rate float64
+		if err := rows.Scan(&band, &rate); err != nil {
+			return nil, err
+		}
+		p.AdmissionRates[strconv.Itoa(band)] = rate
+	}
+	return p, rows.Err()
+}
+
+func queryWeights(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Weighted, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fitting distribution: %w", err)
+	}
+	defer rows.Close()
+
+	var weights []Weighted
+	for rows.Next() {
+		var w Weighted
+		if err := rows.Scan(&w.Value, &w.Count); err != nil {
+			return nil, err
+		}
+		w.Value = strings.TrimSpace(w.Value)
+		weights = append(weights, w)
+	}
+	return weights, rows.Err()
+}
+
+// Save writes the profile as JSON
+func (p *Profile) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// LoadProfile reads a profile written by Save
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", path, err)
+	}
+	return &p, nil
+}
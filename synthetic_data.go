@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/privacy"
+	"github.com/nonsonwune/spk2_db/synthetic"
+)
+
+// handleSyntheticData fits distributions from real candidates and generates
+// shareable synthetic datasets from them
+func handleSyntheticData(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nSynthetic Data")
+	fmt.Println("1. Fit a profile from real data")
+	fmt.Println("2. Generate a synthetic dataset")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	switch readChoice() {
+	case "1":
+		profile, err := fitSyntheticProfile(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Print("Save profile to (e.g. profile.json): ")
+		path := readString()
+		if path == "" {
+			path = fmt.Sprintf("synthetic_profile_%d.json", profile.Year)
+		}
+		if err := profile.Save(path); err != nil {
+			return fmt.Errorf("error saving profile: %w", err)
+		}
+		color.Green("Profile saved to %s", path)
+	case "2":
+		return generateSyntheticData(ctx, db)
+	}
+	return nil
+}
+
+func fitSyntheticProfile(ctx context.Context, db *sql.DB) (*synthetic.Profile, error) {
+	fmt.Print("Year to fit: ")
+	year := readInt()
+	if year == 0 {
+		return nil, fmt.Errorf("year is required")
+	}
+	guard, err := privacy.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	profile, err := synthetic.Fit(ctx, db, year, int64(guard.K))
+	if err != nil {
+		return nil, err
+	}
+	color.Green("Fitted %d locations, %d course choices and %d subject combinations from %d in %v (categories under %d candidates left out)",
+		len(profile.Locations), len(profile.Choices), len(profile.SubjectCombos), year,
+		time.Since(start).Round(time.Millisecond), guard.K)
+	return profile, nil
+}
+
+func generateSyntheticData(ctx context.Context, db *sql.DB) error {
+	fmt.Print("Profile file (blank to fit one now): ")
+	var profile *synthetic.Profile
+	if path := readString(); path != "" {
+		var err error
+		if profile, err = synthetic.LoadProfile(path); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if profile, err = fitSyntheticProfile(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	fmt.Print("Number of candidates to generate: ")
+	n := readInt()
+	if n <= 0 {
+		return fmt.Errorf("number of candidates must be positive")
+	}
+	fmt.Print("Random seed (blank for current time): ")
+	seed := time.Now().UnixNano()
+	if raw := readString(); raw != "" {
+		var err error
+		if seed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return fmt.Errorf("invalid seed %q", raw)
+		}
+	}
+	fmt.Print("Output directory: ")
+	dir := readString()
+	if dir == "" {
+		return fmt.Errorf("output directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	candidates, err := os.Create(filepath.Join(dir, "candidates.csv"))
+	if err != nil {
+		return err
+	}
+	defer candidates.Close()
+	scores, err := os.Create(filepath.Join(dir, "scores.csv"))
+	if err != nil {
+		return err
+	}
+	defer scores.Close()
+
+	summary, err := synthetic.Generate(profile, n, seed, candidates, scores)
+	if err != nil {
+		return fmt.Errorf("error generating synthetic data: %w", err)
+	}
+	if err := profile.Save(filepath.Join(dir, "profile.json")); err != nil {
+		return err
+	}
+	color.Green("Wrote %d synthetic candidates and %d scores to %s (seed %d)", summary.Candidates, summary.Scores, dir, seed)
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+)
+
+// tableLayout chooses how console tables are drawn: as tables, as one
+// record per block of "column | value" lines, or as tables unless they are
+// wider than the terminal
+type tableLayout string
+
+const (
+	layoutAuto     tableLayout = "auto"
+	layoutTable    tableLayout = "table"
+	layoutVertical tableLayout = "vertical"
+)
+
+// consoleLayout is the layout console tables use, set from the environment
+// at startup
+var consoleLayout = layoutAuto
+
+// tableLayoutFromEnv reads TABLE_LAYOUT (default auto)
+func tableLayoutFromEnv() (tableLayout, error) {
+	switch layout := tableLayout(strings.ToLower(os.Getenv("TABLE_LAYOUT"))); layout {
+	case "":
+		return layoutAuto, nil
+	case layoutAuto, layoutTable, layoutVertical:
+		return layout, nil
+	default:
+		return layoutAuto, fmt.Errorf("TABLE_LAYOUT %q must be auto, table or vertical", os.Getenv("TABLE_LAYOUT"))
+	}
+}
+
+// terminalWidth is the width of the terminal stdout is on, or 0 when stdout
+// is not a terminal. It is read for every table, so a resized window is
+// picked up by the next one.
+func terminalWidth() int {
+	if !readline.IsTerminal(int(os.Stdout.Fd())) {
+		return 0
+	}
+	if width := readline.GetScreenWidth(); width > 0 {
+		return width
+	}
+	return 0
+}
+
+// consoleTable returns the renderer for tables drawn on stdout
+func consoleTable(noWrap bool) TableRenderer {
+	r := TableRenderer{W: os.Stdout, NoWrap: noWrap}
+	switch consoleLayout {
+	case layoutVertical:
+		r.Vertical = true
+	case layoutAuto:
+		r.Width = terminalWidth()
+	}
+	return r
+}
+
+// wrapWidth is the width tablewriter wraps long cells at
+const wrapWidth = 30
+
+// tableWidth estimates how wide tablewriter draws a table: each column as
+// wide as its widest cell, or wrapped at word boundaries past wrapWidth,
+// plus a space either side and a border between columns
+func tableWidth(header []string, rows [][]string, noWrap bool) int {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				break
+			}
+			w := utf8.RuneCountInString(cell)
+			if !noWrap && w > wrapWidth {
+				w = wrapWidth
+				for _, word := range strings.Fields(cell) {
+					if n := utf8.RuneCountInString(word); n > w {
+						w = n
+					}
+				}
+			}
+			if w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	total := 1
+	for _, w := range widths {
+		total += w + 3
+	}
+	return total
+}
+
+// renderRecords draws each row as a block of "column | value" lines, the
+// way psql's expanded display does, for tables too wide for the terminal
+func renderRecords(w io.Writer, header []string, rows [][]string, width int) error {
+	labelWidth := 0
+	for _, h := range header {
+		if n := utf8.RuneCountInString(h); n > labelWidth {
+			labelWidth = n
+		}
+	}
+	for i, row := range rows {
+		title := fmt.Sprintf("-[ RECORD %d ]", i+1)
+		if pad := width/2 - utf8.RuneCountInString(title); pad > 0 {
+			title += strings.Repeat("-", pad)
+		}
+		if _, err := fmt.Fprintln(w, title); err != nil {
+			return err
+		}
+		for j, h := range header {
+			value := ""
+			if j < len(row) {
+				value = row[j]
+			}
+			label := h + strings.Repeat(" ", labelWidth-utf8.RuneCountInString(h))
+			if _, err := fmt.Fprintf(w, "%s | %s\n", label, value); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rows) == 0 {
+		_, err := fmt.Fprintln(w, "(no rows)")
+		return err
+	}
+	return nil
+}
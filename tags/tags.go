@@ -0,0 +1,170 @@
+// Package tags names cohorts of candidates, such as a scholarship
+// shortlist, so a filter can restrict any report, search or export to them
+// with tag=NAME.
+package tags
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nonsonwune/spk2_db/filter"
+)
+
+// Tag is a named cohort and the number of candidates in it
+type Tag struct {
+	Name        string
+	Description string
+	Candidates  int64
+	CreatedAt   time.Time
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// Normalize lower-cases a tag name and checks it can be written in a filter
+// without quotes, e.g. scholarship-2024-shortlist
+func Normalize(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) > 100 || !namePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid tag name %q: use letters, digits, '-', '_' and '.', starting with a letter or digit", name)
+	}
+	return name, nil
+}
+
+// List returns every tag with its size, by name
+func List(ctx context.Context, db *sql.DB) ([]Tag, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT t.name, COALESCE(t.description, ''), COUNT(ct.regnumber), t.created_at
+        FROM tags t
+        LEFT JOIN candidate_tags ct ON ct.tag_id = t.id
+        GROUP BY t.id
+        ORDER BY t.name`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Description, &t.Candidates, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// AddFilter tags the candidates in source (candidate, or a working set
+// table) that match expr, creating the tag if needed. A blank description
+// keeps the tag's current one. It returns the number newly tagged.
+func AddFilter(ctx context.Context, db *sql.DB, name, description, source string, expr *filter.Filter) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := create(ctx, tx, name, description)
+	if err != nil {
+		return 0, err
+	}
+	where, args := expr.SQL("c", 1)
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`
+        INSERT INTO candidate_tags (tag_id, regnumber)
+        SELECT $1, c.regnumber FROM %s c WHERE %s
+        ON CONFLICT DO NOTHING`, source, where), append([]interface{}{id}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("error tagging candidates: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, tx.Commit()
+}
+
+// AddList tags the candidates with the given registration numbers,
+// creating the tag if needed. It returns the number newly tagged and the
+// number of registration numbers that match no candidate.
+func AddList(ctx context.Context, db *sql.DB, name, description string, regs []string) (added, unknown int64, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := create(ctx, tx, name, description)
+	if err != nil {
+		return 0, 0, err
+	}
+	var known int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM candidate WHERE regnumber = ANY($1)`, pq.Array(regs)).Scan(&known); err != nil {
+		return 0, 0, fmt.Errorf("error checking registration numbers: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `
+        INSERT INTO candidate_tags (tag_id, regnumber)
+        SELECT $1, regnumber FROM candidate WHERE regnumber = ANY($2)
+        ON CONFLICT DO NOTHING`, id, pq.Array(regs))
+	if err != nil {
+		return 0, 0, fmt.Errorf("error tagging candidates: %w", err)
+	}
+	added, _ = res.RowsAffected()
+	return added, int64(len(regs)) - known, tx.Commit()
+}
+
+// RemoveFilter untags the candidates matching expr, returning how many were
+// removed
+func RemoveFilter(ctx context.Context, db *sql.DB, name string, expr *filter.Filter) (int64, error) {
+	where, args := expr.SQL("c", 1)
+	return remove(ctx, db, name, fmt.Sprintf(
+		`regnumber IN (SELECT c.regnumber FROM candidate c WHERE %s)`, where), args...)
+}
+
+// RemoveList untags the candidates with the given registration numbers
+func RemoveList(ctx context.Context, db *sql.DB, name string, regs []string) (int64, error) {
+	return remove(ctx, db, name, `regnumber = ANY($2)`, pq.Array(regs))
+}
+
+// Delete removes a tag and its memberships
+func Delete(ctx context.Context, db *sql.DB, name string) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM tags WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("error deleting tag: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no tag named %s", name)
+	}
+	return nil
+}
+
+func remove(ctx context.Context, db *sql.DB, name, condition string, args ...interface{}) (int64, error) {
+	var id int
+	err := db.QueryRowContext(ctx, `SELECT id FROM tags WHERE name = $1`, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no tag named %s", name)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error finding tag: %w", err)
+	}
+	res, err := db.ExecContext(ctx, `DELETE FROM candidate_tags WHERE tag_id = $1 AND `+condition,
+		append([]interface{}{id}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("error untagging candidates: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func create(ctx context.Context, tx *sql.Tx, name, description string) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `
+        INSERT INTO tags (name, description) VALUES ($1, NULLIF($2, ''))
+        ON CONFLICT (name) DO UPDATE SET description = COALESCE(EXCLUDED.description, tags.description)
+        RETURNING id`, name, description).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating tag: %w", err)
+	}
+	return id, nil
+}
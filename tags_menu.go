@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nonsonwune/spk2_db/filter"
+	"github.com/nonsonwune/spk2_db/tags"
+)
+
+// tagNames lists the tags offered after "tag=" in filter expressions
+const tagNames = "SELECT name FROM tags ORDER BY 1"
+
+// handleTags manages cohort tags. A tag is used like any other filter field,
+// e.g. tag=scholarship-2024-shortlist in the session filter or an export.
+func handleTags(ctx context.Context, db *sql.DB) error {
+	color.Cyan("\nCandidate Tags")
+	fmt.Println("1. List tags")
+	fmt.Println("2. Tag candidates matching a filter")
+	fmt.Println("3. Tag candidates from a regnumber list (CSV/TXT)")
+	fmt.Println("4. Tag the session working set")
+	fmt.Println("5. Untag candidates from a regnumber list")
+	fmt.Println("6. Delete a tag")
+	fmt.Println("0. Back")
+	fmt.Print("\nEnter your choice: ")
+
+	choice := readChoice()
+	switch choice {
+	case "0", "":
+		return nil
+	case "1":
+		return showTags(ctx, db)
+	case "2", "3", "4", "5", "6":
+	default:
+		return fmt.Errorf("invalid choice")
+	}
+
+	fmt.Print("Tag name (e.g. scholarship-2024-shortlist): ")
+	name, err := tags.Normalize(readString())
+	if err != nil {
+		return err
+	}
+	// New or deleted tags change the names offered for completion
+	defer delete(entityCache, tagNames)
+
+	switch choice {
+	case "2", "4":
+		source, expr := "candidate", (*filter.Filter)(nil)
+		if choice == "4" {
+			if currentSession.table == "" {
+				return fmt.Errorf("set a session filter or load a list first")
+			}
+			source = currentSession.CandidateSource()
+		} else {
+			input := readFilter(ctx, db, "Filter: ")
+			if input == "" {
+				return fmt.Errorf("a filter is required")
+			}
+			if expr, err = filter.Parse(input); err != nil {
+				return fmt.Errorf("invalid filter: %w", err)
+			}
+		}
+		fmt.Print("Description (optional): ")
+		n, err := tags.AddFilter(ctx, db, name, readString(), source, expr)
+		if err != nil {
+			return err
+		}
+		color.Green("Tagged %d candidates as %s", n, name)
+	case "3":
+		regs, err := readTagList()
+		if err != nil {
+			return err
+		}
+		fmt.Print("Description (optional): ")
+		added, unknown, err := tags.AddList(ctx, db, name, readString(), regs)
+		if err != nil {
+			return err
+		}
+		color.Green("Tagged %d candidates as %s", added, name)
+		if unknown > 0 {
+			color.Yellow("%d registration numbers in the list were not found", unknown)
+		}
+	case "5":
+		regs, err := readTagList()
+		if err != nil {
+			return err
+		}
+		n, err := tags.RemoveList(ctx, db, name, regs)
+		if err != nil {
+			return err
+		}
+		color.Green("Removed %d candidates from %s", n, name)
+	case "6":
+		fmt.Printf("Delete tag %s? (y/n): ", name)
+		if strings.ToLower(readString()) != "y" {
+			fmt.Println("Delete cancelled.")
+			return nil
+		}
+		if err := tags.Delete(ctx, db, name); err != nil {
+			return err
+		}
+		color.Green("Deleted tag %s", name)
+	}
+	return nil
+}
+
+func readTagList() ([]string, error) {
+	fmt.Print("List file (.csv or .txt): ")
+	path := readString()
+	if path == "" {
+		return nil, fmt.Errorf("a list file is required")
+	}
+	return readRegNumbers(path)
+}
+
+func showTags(ctx context.Context, db *sql.DB) error {
+	list, err := tags.List(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		color.Yellow("No tags yet")
+		return nil
+	}
+	table := newResultTable("tags")
+	table.SetHeader([]string{"Tag", "Candidates", "Description", "Created"})
+	for _, t := range list {
+		table.Append([]string{t.Name, strconv.FormatInt(t.Candidates, 10), t.Description, t.CreatedAt.Format("2006-01-02")})
+	}
+	table.Render()
+	fmt.Println("Use tag=NAME in a session filter, search, export or report filter")
+	return nil
+}
+
+// runTag is the scriptable form of menu item 42
+func runTag(ctx context.Context, db *sql.DB, cfg *Config, args []string) error {
+	fs := newFlagSet("tag")
+	filterText := fs.String("filter", "", "with add or remove, the candidates to tag, e.g. year=2024 AND aggregate>=300")
+	listFile := fs.String("list", "", "with add or remove, a CSV or TXT file of registration numbers")
+	description := fs.String("description", "", "with add, describe the tag")
+	format := fs.String("format", "table", "with list, output format: table, csv, json or xlsx")
+	output := fs.String("o", "", "with list, write the result to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError{errors.New("tag needs list, add NAME, remove NAME or delete NAME")}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	action := fs.Arg(0)
+	if action == "list" {
+		list, err := tags.List(ctx, db)
+		if err != nil {
+			return err
+		}
+		rows := make([][]interface{}, len(list))
+		for i, t := range list {
+			rows[i] = []interface{}{t.Name, t.Candidates, t.Description, t.CreatedAt.Format("2006-01-02 15:04:05")}
+		}
+		return writeResult("tags", []string{"tag", "candidates", "description", "created_at"}, rows, *format, *output)
+	}
+	if action != "add" && action != "remove" && action != "delete" {
+		return usageError{fmt.Errorf("unknown tag action %q", action)}
+	}
+	if fs.NArg() != 2 {
+		return usageError{fmt.Errorf("tag %s needs a tag name", action)}
+	}
+	name, err := tags.Normalize(fs.Arg(1))
+	if err != nil {
+		return usageError{err}
+	}
+	if action == "delete" {
+		if err := tags.Delete(ctx, db, name); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Deleted tag %s\n", name)
+		return nil
+	}
+
+	if (*filterText == "") == (*listFile == "") {
+		return usageError{fmt.Errorf("tag %s needs one of -filter or -list", action)}
+	}
+	var regs []string
+	expr, err := parseFilter(*filterText)
+	if err != nil {
+		return err
+	}
+	if *listFile != "" {
+		if regs, err = readRegNumbers(*listFile); err != nil {
+			return err
+		}
+	}
+
+	var n, unknown int64
+	switch {
+	case action == "add" && regs != nil:
+		n, unknown, err = tags.AddList(ctx, db, name, *description, regs)
+	case action == "add":
+		n, err = tags.AddFilter(ctx, db, name, *description, "candidate", expr)
+	case regs != nil:
+		n, err = tags.RemoveList(ctx, db, name, regs)
+	default:
+		n, err = tags.RemoveFilter(ctx, db, name, expr)
+	}
+	if err != nil {
+		return err
+	}
+	if action == "add" {
+		fmt.Fprintf(os.Stderr, "Tagged %d candidates as %s\n", n, name)
+	} else {
+		fmt.Fprintf(os.Stderr, "Removed %d candidates from %s\n", n, name)
+	}
+	if unknown > 0 {
+		fmt.Fprintf(os.Stderr, "%d registration numbers in the list were not found\n", unknown)
+	}
+	return nil
+}
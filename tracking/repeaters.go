@@ -0,0 +1,341 @@
+// Package tracking links the candidates of different years who are the
+// same person, to follow repeat takers from one sitting to the next.
+package tracking
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// How two candidates were linked
+const (
+	// MatchRegnumber links regnumbers that are the same once case, spaces
+	// and punctuation are ignored
+	MatchRegnumber = "regnumber"
+	// MatchNameDOBState links candidates with the same surname and first
+	// name, in either order, date of birth and state of origin
+	MatchNameDOBState = "name_dob_state"
+)
+
+// Methods lists the match methods in the order they are tried
+var Methods = []string{MatchRegnumber, MatchNameDOBState}
+
+// matchKeys selects the key each method links candidates on. A key shared
+// by more than one candidate of the same year is ambiguous, e.g. two
+// people of the same name and birthday, and links nobody.
+var matchKeys = map[string]string{
+	MatchRegnumber: `regexp_replace(UPPER(regnumber), '[^A-Z0-9]', '', 'g')`,
+	MatchNameDOBState: `
+        LEAST(UPPER(TRIM(surname)), UPPER(TRIM(firstname))) || '|' ||
+        GREATEST(UPPER(TRIM(surname)), UPPER(TRIM(firstname))) || '|' ||
+        date_of_birth::text || '|' || statecode::text`,
+}
+
+var matchConditions = map[string]string{
+	MatchRegnumber:    `regnumber IS NOT NULL`,
+	MatchNameDOBState: `TRIM(COALESCE(surname, '')) <> '' AND TRIM(COALESCE(firstname, '')) <> '' AND date_of_birth IS NOT NULL AND statecode IS NOT NULL`,
+}
+
+// ParseMethods reads a comma separated list of match methods; "all" or
+// blank selects every method
+func ParseMethods(spec string) ([]string, error) {
+	spec = strings.TrimSpace(strings.ToLower(spec))
+	if spec == "" || spec == "all" {
+		return Methods, nil
+	}
+	var methods []string
+	for _, m := range strings.Split(spec, ",") {
+		m = strings.TrimSpace(m)
+		if m == "name" {
+			m = MatchNameDOBState
+		}
+		if _, ok := matchKeys[m]; !ok {
+			return nil, fmt.Errorf("unknown match method %q (use %s or all)", m, strings.Join(Methods, ", "))
+		}
+		methods = append(methods, m)
+	}
+	return methods, nil
+}
+
+// Attempt is one year's registration of a repeat taker
+type Attempt struct {
+	Regnumber string
+	Year      int
+	Name      string
+	Aggregate sql.NullInt64
+	Admitted  bool
+	Course    string
+	Inid      string
+}
+
+// Person is a candidate who registered in more than one year
+type Person struct {
+	Attempts  []Attempt // in year order
+	MatchedBy []string  // the methods that linked the attempts
+}
+
+// First returns the earliest attempt
+func (p *Person) First() Attempt { return p.Attempts[0] }
+
+// Last returns the latest attempt
+func (p *Person) Last() Attempt { return p.Attempts[len(p.Attempts)-1] }
+
+// Best returns the highest aggregate of any attempt
+func (p *Person) Best() sql.NullInt64 {
+	var best sql.NullInt64
+	for _, a := range p.Attempts {
+		if a.Aggregate.Valid && (!best.Valid || a.Aggregate.Int64 > best.Int64) {
+			best = a.Aggregate
+		}
+	}
+	return best
+}
+
+// Improvement is the last aggregate less the first; ok is false when
+// either is missing
+func (p *Person) Improvement() (int64, bool) {
+	first, last := p.First().Aggregate, p.Last().Aggregate
+	if !first.Valid || !last.Valid {
+		return 0, false
+	}
+	return last.Int64 - first.Int64, true
+}
+
+// AdmittedYear returns the year of the first attempt that led to
+// admission, or 0
+func (p *Person) AdmittedYear() int {
+	for _, a := range p.Attempts {
+		if a.Admitted {
+			return a.Year
+		}
+	}
+	return 0
+}
+
+// FindRepeaters links candidates across years with the given methods and
+// returns the people with attempts in more than one year, most attempts
+// first. When year is not 0 only people with an attempt that year are
+// returned.
+func FindRepeaters(ctx context.Context, db *sql.DB, methods []string, year int) ([]Person, error) {
+	links := newUnionFind()
+	matched := map[string]map[string]bool{}
+	for _, method := range methods {
+		groups, err := linkedGroups(ctx, db, method)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			for _, reg := range group {
+				links.union(group[0], reg)
+				if matched[reg] == nil {
+					matched[reg] = map[string]bool{}
+				}
+				matched[reg][method] = true
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil, nil
+	}
+
+	regnumbers := make([]string, 0, len(matched))
+	for reg := range matched {
+		regnumbers = append(regnumbers, reg)
+	}
+	attempts, err := loadAttempts(ctx, db, regnumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	byRoot := map[string]*Person{}
+	var roots []string
+	for _, a := range attempts {
+		root := links.find(a.Regnumber)
+		p, ok := byRoot[root]
+		if !ok {
+			p = &Person{}
+			byRoot[root] = p
+			roots = append(roots, root)
+		}
+		p.Attempts = append(p.Attempts, a)
+		for method := range matched[a.Regnumber] {
+			if !contains(p.MatchedBy, method) {
+				p.MatchedBy = append(p.MatchedBy, method)
+			}
+		}
+	}
+
+	people := make([]Person, 0, len(roots))
+	for _, root := range roots {
+		p := byRoot[root]
+		if len(p.Attempts) < 2 || (year != 0 && !p.attempted(year)) {
+			continue
+		}
+		sort.Strings(p.MatchedBy)
+		people = append(people, *p)
+	}
+	sort.SliceStable(people, func(i, j int) bool {
+		if len(people[i].Attempts) != len(people[j].Attempts) {
+			return len(people[i].Attempts) > len(people[j].Attempts)
+		}
+		return people[i].First().Regnumber < people[j].First().Regnumber
+	})
+	return people, nil
+}
+
+func (p *Person) attempted(year int) bool {
+	for _, a := range p.Attempts {
+		if a.Year == year {
+			return true
+		}
+	}
+	return false
+}
+
+// linkedGroups returns the regnumbers sharing a method's key, one group per
+// key held in more than one year and by at most one candidate a year
+func linkedGroups(ctx context.Context, db *sql.DB, method string) ([][]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT array_agg(regnumber ORDER BY year, regnumber)
+        FROM (SELECT regnumber, year, %s AS match_key FROM candidate WHERE %s) k
+        GROUP BY match_key
+        HAVING COUNT(DISTINCT year) > 1 AND COUNT(*) = COUNT(DISTINCT year)`,
+		matchKeys[method], matchConditions[method]))
+	if err != nil {
+		return nil, fmt.Errorf("error matching candidates by %s: %w", method, err)
+	}
+	defer rows.Close()
+
+	var groups [][]string
+	for rows.Next() {
+		var group []string
+		if err := rows.Scan(pq.Array(&group)); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// loadAttempts reads the candidates behind the linked regnumbers, in year
+// order
+func loadAttempts(ctx context.Context, db *sql.DB, regnumbers []string) ([]Attempt, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT regnumber, year, TRIM(COALESCE(surname, '') || ' ' || COALESCE(firstname, '')),
+               aggregate, COALESCE(is_admitted, false), COALESCE(app_course1, ''), COALESCE(inid, '')
+        FROM candidate
+        WHERE regnumber = ANY($1)
+        ORDER BY year, regnumber`, pq.Array(regnumbers))
+	if err != nil {
+		return nil, fmt.Errorf("error loading repeat candidates: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []Attempt
+	for rows.Next() {
+		var a Attempt
+		if err := rows.Scan(&a.Regnumber, &a.Year, &a.Name, &a.Aggregate, &a.Admitted, &a.Course, &a.Inid); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// unionFind groups regnumbers linked by any method, directly or through
+// other attempts
+type unionFind struct {
+	parent map[string]string
+}
+
+func newUnionFind() *unionFind {
+	return &unionFind{parent: map[string]string{}}
+}
+
+func (u *unionFind) find(x string) string {
+	p, ok := u.parent[x]
+	if !ok {
+		u.parent[x] = x
+		return x
+	}
+	if p != x {
+		p = u.find(p)
+		u.parent[x] = p
+	}
+	return p
+}
+
+func (u *unionFind) union(a, b string) {
+	ra, rb := u.find(a), u.find(b)
+	if ra != rb {
+		u.parent[rb] = ra
+	}
+}
+
+// Summary aggregates the repeat takers who sat in the same number of years
+type Summary struct {
+	Attempts       int
+	People         int
+	Improved       int     // last aggregate above the first
+	AvgImprovement float64 // over the people with both aggregates
+	Admitted       int     // admitted after one of the attempts
+}
+
+// AdmittedPercent is the share of the people admitted in the end
+func (s Summary) AdmittedPercent() float64 {
+	if s.People == 0 {
+		return 0
+	}
+	return float64(s.Admitted) * 100 / float64(s.People)
+}
+
+// Summarize groups people by their number of attempts, fewest first
+func Summarize(people []Person) []Summary {
+	byAttempts := map[int]*Summary{}
+	scored := map[int]int{}
+	total := map[int]int64{}
+	for i := range people {
+		p := &people[i]
+		n := len(p.Attempts)
+		s, ok := byAttempts[n]
+		if !ok {
+			s = &Summary{Attempts: n}
+			byAttempts[n] = s
+		}
+		s.People++
+		if diff, ok := p.Improvement(); ok {
+			scored[n]++
+			total[n] += diff
+			if diff > 0 {
+				s.Improved++
+			}
+		}
+		if p.AdmittedYear() != 0 {
+			s.Admitted++
+		}
+	}
+
+	summaries := make([]Summary, 0, len(byAttempts))
+	for n, s := range byAttempts {
+		if scored[n] > 0 {
+			s.AvgImprovement = float64(total[n]) / float64(scored[n])
+		}
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Attempts < summaries[j].Attempts })
+	return summaries
+}